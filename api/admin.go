@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/duration"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/user"
+)
+
+//adminUser is what an admin sees of a user - everything but their tokens.
+type adminUser struct {
+	UserID          int        `json:"UserID"`
+	Email           string     `json:"Email"`
+	FirstName       string     `json:"FirstName"`
+	LastName        string     `json:"LastName"`
+	FullName        string     `json:"FullName"`
+	CreatedDate     string     `json:"TimeCreated"`
+	TokenProvider   string     `json:"TokenProvider"`
+	Admin           bool       `json:"IsAdmin"`
+	Active          bool       `json:"IsActive"`
+	DeactivatedDate *time.Time `json:"TimeDeactivated,omitempty"`
+}
+
+func newAdminUser(u userDomain.User) adminUser {
+	view := adminUser{UserID: u.UserID, Email: u.Email, FirstName: u.FirstName, LastName: u.LastName, FullName: u.FullName,
+		CreatedDate: u.CreatedDate, TokenProvider: u.TokenProvider, Admin: u.Admin, Active: u.IsActive()}
+	if !u.IsActive() {
+		view.DeactivatedDate = &u.DeactivatedDate
+	}
+	return view
+}
+
+//RequireAdmin is the middleware for the /admin routes, after RequireUser. It aborts with a 403 unless the user is an admin.
+//A session an admin started as someone else is that user's, so it can't be used here.
+func (h *handler) RequireAdmin(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	if !requestUser.Admin {
+		fmt.Println("user", requestUser.UserID, "tried an admin route:", c.Request.URL.Path)
+		abortWithError(c, fcerr.NewForbiddenError("Only an admin can do this"))
+		return
+	}
+	c.Next()
+}
+
+//ListUsers is GET /admin/users, with ?q= to only list users whose email or name contains it.
+func (h *handler) ListUsers(c *gin.Context) {
+	users, fcErr := h.userService.List(c.Request.Context(), c.Query("q"))
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+
+	views := make([]adminUser, 0, len(*users))
+	for _, u := range *users {
+		views = append(views, newAdminUser(u))
+	}
+	marshaled, err := json.Marshal(views)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the users"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
+
+//DeactivateUser is POST /admin/users/:id/deactivate. The user is logged out everywhere and can't sign in again until
+//they are reactivated. Admins can't deactivate themselves.
+func (h *handler) DeactivateUser(c *gin.Context) {
+	_, admin, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	userID, ok := personalID(c)
+	if !ok {
+		return
+	}
+	if userID == admin.UserID {
+		abortWithError(c, fcerr.NewBadRequestError("An admin can't deactivate themselves"))
+		return
+	}
+
+	fmt.Println("admin", admin.UserID, "is deactivating user", userID)
+	deactivated, fcErr := h.userService.SetActive(c.Request.Context(), userID, false)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	if fcErr := h.sessionService.RevokeAll(c.Request.Context(), deactivated); fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	h.respondAdminUser(c, deactivated)
+}
+
+//ReactivateUser is POST /admin/users/:id/reactivate. The user has to log in again.
+func (h *handler) ReactivateUser(c *gin.Context) {
+	_, admin, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	userID, ok := personalID(c)
+	if !ok {
+		return
+	}
+
+	fmt.Println("admin", admin.UserID, "is reactivating user", userID)
+	reactivated, fcErr := h.userService.SetActive(c.Request.Context(), userID, true)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	h.respondAdminUser(c, reactivated)
+}
+
+//ImpersonateUser is POST /admin/users/:id/impersonate. It gives the admin a short session token to use the API as the
+//user does, for support. The session remembers which admin started it.
+func (h *handler) ImpersonateUser(c *gin.Context) {
+	_, admin, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	userID, ok := personalID(c)
+	if !ok {
+		return
+	}
+
+	target, fcErr := h.userService.GetByID(c.Request.Context(), userID)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	token, created, fcErr := h.sessionService.Impersonate(c.Request.Context(), admin, target)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	c.JSON(http.StatusCreated, sessionResponse{Token: token, TokenType: "Bearer", ExpiresAt: created.ExpireDate})
+}
+
+//ExpiredDishStats is GET /admin/stats/expired - how many dishes across every household have expired, and how many will
+//within ?within= (an ISO 8601 duration, P3D when not given).
+func (h *handler) ExpiredDishStats(c *gin.Context) {
+	within := c.DefaultQuery("within", defaultExpiringWindow)
+	window, fcErr := duration.Parse(within)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+
+	stats, fcErr := h.dishService.ExpiryStats(c.Request.Context(), window)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(stats)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the dish statistics"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
+
+//tokenCacheStats is the access token cache's counts as an admin sees them, with the hit rate worked out.
+type tokenCacheStats struct {
+	user.CacheStats
+	HitRate float64 `json:"hitRate"`
+}
+
+//TokenCacheStats is GET /admin/stats/tokens - how often access tokens have been answered from the cache rather than by
+//asking the identity provider, since the service started.
+func (h *handler) TokenCacheStats(c *gin.Context) {
+	stats := h.userService.TokenCacheStats()
+	marshaled, err := json.Marshal(tokenCacheStats{CacheStats: stats, HitRate: stats.HitRate()})
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the token cache statistics"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
+
+//respondAdminUser writes the user as an admin sees them.
+func (h *handler) respondAdminUser(c *gin.Context, u *userDomain.User) {
+	marshaled, err := json.Marshal(newAdminUser(*u))
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the user"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
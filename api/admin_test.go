@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc"
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc/oidctest"
+	dbrepo "github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/session"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/storage"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/user"
+	"github.com/stretchr/testify/assert"
+)
+
+//adminTest is the /admin routes, /v1/dishes and /v1/users/me over a memory repository holding an admin and two users, with a bearer
+//header for each of them.
+type adminTest struct {
+	router  *gin.Engine
+	users   []*userDomain.User
+	bearers []string
+	//stub is the identity provider access tokens are checked with
+	stub *oidctest.Server
+}
+
+func newAdminTest(t *testing.T) *adminTest {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	repo := dbrepo.NewMemoryRepository()
+	sessions := session.NewService(repo, time.Hour)
+
+	at := &adminTest{stub: oidctest.NewServer()}
+	t.Cleanup(at.stub.Close)
+	p, fcErr := oidc.Discover(ctx, at.stub.Config("stub", "https://fcapi.example.com/success"), nil)
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	providers, _ := oidc.NewProviders(p)
+
+	for i, email := range []string{"admin@gmail.com", "nothing@gmail.com", "session@gmail.com"} {
+		u, _ := repo.CreateUser(ctx, userDomain.User{Email: email, FullName: "User " + strconv.Itoa(i), Admin: i == 0})
+		token, _, fcErr := sessions.Create(ctx, u)
+		if fcErr != nil {
+			t.Fatal(fcErr.Message())
+		}
+		at.users = append(at.users, u)
+		at.bearers = append(at.bearers, "Bearer "+token)
+	}
+
+	h := NewHandler(dish.NewService(repo), storage.NewService(repo),
+		user.NewServiceWithProviders(repo, providers, user.DefaultTokenCacheTTL, user.DefaultTokenCacheSize), household.NewService(repo),
+		sessions, nil, nil, nil, nil, nil, providers)
+	at.router = gin.New()
+	at.router.Use(ErrorHandler())
+	v1 := at.router.Group("/v1")
+	v1.Use(h.RequireUser)
+	v1.GET("/dishes", h.ListDishes)
+	v1.POST("/dishes", h.CreateDish)
+	v1.GET("/users/me", h.GetUser)
+	v1.PATCH("/users/me", h.UpdateUser)
+	v1.DELETE("/users/me", h.DeleteUser)
+	v1.DELETE("/users/me/deletion", h.CancelUserDeletion)
+	v1.GET("/users/me/export", h.ExportUser)
+	admin := at.router.Group("/admin")
+	admin.Use(h.RequireUser, h.RequireAdmin)
+	admin.GET("/users", h.ListUsers)
+	admin.POST("/users/:id/deactivate", h.DeactivateUser)
+	admin.POST("/users/:id/reactivate", h.ReactivateUser)
+	admin.POST("/users/:id/impersonate", h.ImpersonateUser)
+	admin.GET("/stats/expired", h.ExpiredDishStats)
+	admin.GET("/stats/tokens", h.TokenCacheStats)
+	return at
+}
+
+//userPath is the /admin path for doing action to the user.
+func (at *adminTest) userPath(u *userDomain.User, action string) string {
+	return "/admin/users/" + strconv.Itoa(u.UserID) + "/" + action
+}
+
+func TestAPIHandler_Admin_ListUsers(t *testing.T) {
+	at := newAdminTest(t)
+
+	w := serve(at.router, "GET", "/admin/users", at.bearers[1], "")
+	assert.Equal(t, http.StatusForbidden, w.Code, "not an admin")
+	w = serve(at.router, "GET", "/admin/users", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serve(at.router, "GET", "/admin/users", at.bearers[0], "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed []map[string]interface{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	if assert.Equal(t, 3, len(listed)) {
+		assert.Equal(t, "admin@gmail.com", listed[0]["Email"])
+		assert.Equal(t, true, listed[0]["IsAdmin"])
+		assert.Equal(t, true, listed[1]["IsActive"])
+		assert.NotContains(t, listed[1], "AccessToken")
+		assert.NotContains(t, listed[1], "TimeDeactivated")
+	}
+
+	w = serve(at.router, "GET", "/admin/users?q=SESSION", at.bearers[0], "")
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	if assert.Equal(t, 1, len(listed)) {
+		assert.Equal(t, "session@gmail.com", listed[0]["Email"])
+	}
+	w = serve(at.router, "GET", "/admin/users?q=nobody", at.bearers[0], "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[]", w.Body.String())
+}
+
+func TestAPIHandler_Admin_Deactivate(t *testing.T) {
+	at := newAdminTest(t)
+	bob := at.users[1]
+
+	w := serve(at.router, "POST", at.userPath(at.users[0], "deactivate"), at.bearers[0], "")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "admins can't deactivate themselves")
+	w = serve(at.router, "POST", "/admin/users/99/deactivate", at.bearers[0], "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(at.router, "POST", at.userPath(bob, "deactivate"), at.bearers[0], "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var deactivated map[string]interface{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &deactivated))
+	assert.Equal(t, false, deactivated["IsActive"])
+	assert.Contains(t, deactivated, "TimeDeactivated")
+
+	w = serve(at.router, "GET", "/v1/dishes", at.bearers[1], "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "their sessions were logged out")
+
+	w = serve(at.router, "POST", at.userPath(bob, "reactivate"), at.bearers[0], "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &deactivated))
+	assert.Equal(t, true, deactivated["IsActive"])
+	w = serve(at.router, "GET", "/v1/dishes", at.bearers[1], "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "they have to log in again")
+}
+
+func TestAPIHandler_Admin_Impersonate(t *testing.T) {
+	at := newAdminTest(t)
+	sam := at.users[2]
+
+	w := serve(at.router, "POST", at.userPath(sam, "impersonate"), at.bearers[1], "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(at.router, "POST", at.userPath(at.users[0], "impersonate"), at.bearers[0], "")
+	assert.Equal(t, http.StatusForbidden, w.Code, "admins can't be impersonated")
+
+	w = serve(at.router, "POST", at.userPath(sam, "impersonate"), at.bearers[0], "")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var started sessionResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &started))
+	assert.Equal(t, "Bearer", started.TokenType)
+	assert.True(t, started.ExpiresAt.Before(time.Now().Add(session.ImpersonationTTL+time.Minute)))
+
+	asSam := "Bearer " + started.Token
+	w = serve(at.router, "POST", "/v1/dishes", asSam, `{"storageID": "0", "title": "Carrots", "expireWindow": "P7D"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(at.router, "GET", "/v1/dishes", at.bearers[2], "")
+	assert.Equal(t, http.StatusOK, w.Code, "the dish is sam's")
+	w = serve(at.router, "GET", "/admin/users", asSam, "")
+	assert.Equal(t, http.StatusForbidden, w.Code, "acting as sam isn't acting as an admin")
+}
+
+func TestAPIHandler_Admin_ExpiredDishStats(t *testing.T) {
+	at := newAdminTest(t)
+
+	w := serve(at.router, "POST", "/v1/dishes", at.bearers[1], `{"storageID": "0", "title": "Carrots", "expireWindow": "P7D"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(at.router, "POST", "/v1/dishes", at.bearers[2], `{"storageID": "0", "title": "Soup", "expireWindow": "P1D"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(at.router, "GET", "/admin/stats/expired", at.bearers[0], "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var stats dishDomain.ExpiryStats
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, 2, stats.Dishes)
+	assert.Equal(t, 0, stats.Expired)
+	assert.Equal(t, 1, stats.ExpiringSoon)
+	assert.Equal(t, 2, stats.Households)
+
+	w = serve(at.router, "GET", "/admin/stats/expired?within=P2W", at.bearers[0], "")
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, 2, stats.ExpiringSoon)
+
+	w = serve(at.router, "GET", "/admin/stats/expired?within=soon", at.bearers[0], "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(at.router, "GET", "/admin/stats/expired", at.bearers[1], "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAPIHandler_Admin_TokenCacheStats(t *testing.T) {
+	at := newAdminTest(t)
+	accessToken, _, _ := at.stub.IssueTokens(oidctest.DefaultUser)
+
+	for i := 0; i < 3; i++ {
+		w := serve(at.router, "GET", "/v1/users/me", "Bearer "+accessToken, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w := serve(at.router, "GET", "/admin/stats/tokens", at.bearers[0], "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var stats struct {
+		Hits    uint64  `json:"hits"`
+		Misses  uint64  `json:"misses"`
+		Size    int     `json:"size"`
+		HitRate float64 `json:"hitRate"`
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, uint64(2), stats.Hits, "only the first request asked the provider")
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 1, stats.Size)
+	assert.InDelta(t, 2.0/3, stats.HitRate, 0.001)
+
+	w = serve(at.router, "GET", "/admin/stats/tokens", at.bearers[1], "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/link"
+)
+
+//linkRequest is the Alexa account linking request a login attempt finishes, instead of starting a session.
+type linkRequest struct {
+	clientID    string
+	redirectURI string
+	state       string
+}
+
+//linkRedirect is the skill's redirect URL with values and the request's state added to its query.
+func linkRedirect(request *linkRequest, values url.Values) string {
+	redirect, err := url.Parse(request.redirectURI)
+	if err != nil {
+		//CheckClient only lets through the redirect URLs in the config, which app checks parse
+		return request.redirectURI
+	}
+	query := redirect.Query()
+	for name, value := range values {
+		query[name] = value
+	}
+	query.Set("state", request.state)
+	redirect.RawQuery = query.Encode()
+	return redirect.String()
+}
+
+//AlexaAuthorize is GET /alexa/authorize, the authorization URI in the skill's account linking settings. The user signs in
+//the same way as at /oauthlogin, then LoginSuccess sends them back to Alexa with an authorization code.
+func (h *handler) AlexaAuthorize(c *gin.Context) {
+	if h.linkService == nil {
+		abortWithError(c, fcerr.NewNotFoundError("Alexa account linking is not set up"))
+		return
+	}
+	request := &linkRequest{clientID: c.Query("client_id"), redirectURI: c.Query("redirect_uri"), state: c.Query("state")}
+	if fcErr := h.linkService.CheckClient(request.clientID, request.redirectURI); fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	if c.Query("response_type") != "code" {
+		c.Redirect(http.StatusFound, linkRedirect(request, url.Values{"error": {"unsupported_response_type"}}))
+		return
+	}
+	provider, ok := h.providers.Get(c.Query("provider"))
+	if !ok {
+		abortWithError(c, fcerr.NewNotFoundError("There is no identity provider called "+c.Query("provider")))
+		return
+	}
+
+	fmt.Println("starting Alexa account linking with", provider.Name())
+	h.startLogin(c, provider, loginAttempt{link: request})
+}
+
+//tokenResponse is an OAuth 2.0 token response, the shape Alexa expects from the access token URI.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+//AlexaToken is POST /alexa/token, the access token URI in the skill's account linking settings. It takes the
+//authorization_code and refresh_token grants, with the skill's credentials as HTTP Basic or in the form, and answers in
+//the OAuth 2.0 format - errors included - rather than the API's own.
+func (h *handler) AlexaToken(c *gin.Context) {
+	if h.linkService == nil {
+		respondTokenError(c, fcerr.NewNotFoundError("Alexa account linking is not set up"))
+		return
+	}
+	clientID, clientSecret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID, clientSecret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	var tokens *link.Tokens
+	var fcErr fcerr.FCErr
+	switch grantType := c.PostForm("grant_type"); grantType {
+	case "authorization_code":
+		tokens, fcErr = h.linkService.Exchange(c.Request.Context(), clientID, clientSecret, c.PostForm("code"), c.PostForm("redirect_uri"))
+	case "refresh_token":
+		tokens, fcErr = h.linkService.Refresh(c.Request.Context(), clientID, clientSecret, c.PostForm("refresh_token"))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type", "error_description": "Unknown grant_type " + grantType})
+		return
+	}
+	if fcErr != nil {
+		fmt.Println("the Alexa token request failed:", fcErr.Message())
+		respondTokenError(c, fcErr)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, tokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(time.Until(tokens.ExpiresAt) / time.Second),
+		RefreshToken: tokens.RefreshToken,
+	})
+}
+
+//respondTokenError writes an OAuth 2.0 error response for a failed token request.
+func respondTokenError(c *gin.Context, fcErr fcerr.FCErr) {
+	status, code := fcErr.Status(), "server_error"
+	switch status {
+	case http.StatusBadRequest:
+		code = "invalid_grant"
+	case http.StatusUnauthorized, http.StatusNotFound:
+		status, code = http.StatusUnauthorized, "invalid_client"
+		c.Header("WWW-Authenticate", `Basic realm="alexa"`)
+	}
+	c.JSON(status, gin.H{"error": code, "error_description": fcErr.Message()})
+}
+
+//alexaUser gives the user the Alexa account with this Alexa user id is linked to.
+func (h *handler) alexaUser(ctx context.Context, alexaUserID string) (*userDomain.User, fcerr.FCErr) {
+	linked, fcErr := h.linkService.Get(ctx, alexaUserID)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return h.userService.GetByID(ctx, linked.UserID)
+}
+
+//ListLinks is GET /v1/users/me/links, the Alexa accounts the user has linked.
+func (h *handler) ListLinks(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	if h.linkService == nil {
+		abortWithError(c, fcerr.NewNotFoundError("Alexa account linking is not set up"))
+		return
+	}
+
+	identities, fcErr := h.linkService.List(c.Request.Context(), requestUser)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(identities)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the linked accounts"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
+
+//Unlink is DELETE /v1/users/me/links/:id. The skill's tokens stop working, so Alexa has to be linked again to use it.
+func (h *handler) Unlink(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	linkID, ok := personalID(c)
+	if !ok {
+		return
+	}
+	if h.linkService == nil {
+		abortWithError(c, fcerr.NewNotFoundError("Alexa account linking is not set up"))
+		return
+	}
+
+	if fcErr := h.linkService.Unlink(c.Request.Context(), requestUser, linkID); fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	respondMessage(c, http.StatusOK, "The account has been unlinked.")
+}
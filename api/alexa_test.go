@@ -0,0 +1,204 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/identity"
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+)
+
+//linkAuthorizePath is the authorization request Alexa sends the user to when they link the skill.
+func linkAuthorizePath(state string) string {
+	query := url.Values{
+		"client_id":     {testSkill.ID},
+		"redirect_uri":  {testSkill.RedirectURIs[0]},
+		"response_type": {"code"},
+		"state":         {state},
+		"provider":      {"stub"},
+	}
+	return "/alexa/authorize?" + query.Encode()
+}
+
+//requestTokens posts form to /alexa/token, with the skill's credentials as HTTP Basic.
+func requestTokens(router *gin.Engine, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/alexa/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(testSkill.ID, testSkill.Secret)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+//authorizeSkill signs in through /alexa/authorize and gives back the code Alexa is redirected with.
+func authorizeSkill(t *testing.T, router *gin.Engine, stub *oidctest.Server) string {
+	cookies, query := startSignIn(t, router, stub, linkAuthorizePath("alexa-state"))
+	w := finishSignIn(router, cookies, query)
+	if !assert.Equal(t, http.StatusFound, w.Code) {
+		t.FailNow()
+	}
+	redirect, _ := url.Parse(w.Header().Get("Location"))
+	assert.Equal(t, "pitangui.amazon.com", redirect.Host)
+	assert.Equal(t, "alexa-state", redirect.Query().Get("state"))
+	return redirect.Query().Get("code")
+}
+
+//linkSkill goes through account linking the way Alexa does, and gives back the skill's tokens.
+func linkSkill(t *testing.T, router *gin.Engine, stub *oidctest.Server) tokenResponse {
+	code := authorizeSkill(t, router, stub)
+	w := requestTokens(router, url.Values{"grant_type": {"authorization_code"}, "code": {code},
+		"redirect_uri": {testSkill.RedirectURIs[0]}})
+	if !assert.Equal(t, http.StatusOK, w.Code) {
+		t.FailNow()
+	}
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	var tokens tokenResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	return tokens
+}
+
+func TestAPIHandler_AlexaLinking(t *testing.T) {
+	router, stub, _ := newLoginRouter(t)
+
+	tokens := linkSkill(t, router, stub)
+	assert.Equal(t, "Bearer", tokens.TokenType)
+	assert.True(t, strings.HasPrefix(tokens.AccessToken, "fcs_"))
+	assert.True(t, strings.HasPrefix(tokens.RefreshToken, identity.RefreshTokenPrefix))
+	assert.True(t, tokens.ExpiresIn > 0)
+
+	w := serve(router, "GET", "/v1/users/me/links", "Bearer "+tokens.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	//the Alexa user id isn't known until the skill first calls with it and its access token. The legacy /dishes route
+	//answers 404 while the user has no dishes, so a 404 there means the user was found. A bare Alexa user id is never
+	//enough on the legacy or /v1 routes, linked or not
+	alexaOnly := `{"fcapiRequestType": "GET", "alexaUserID": "amzn1.account.linked"}`
+	w = serve(router, "POST", "/dishes", "", alexaOnly)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serve(router, "POST", "/dishes", "",
+		fmt.Sprintf(`{"fcapiRequestType": "GET", "accessToken": "%s", "alexaUserID": "amzn1.account.linked"}`, tokens.AccessToken))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(router, "POST", "/dishes", "", alexaOnly)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serve(router, "GET", "/v1/users/me/links", "", alexaOnly)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serve(router, "GET", "/v1/users/me/links", "Bearer "+tokens.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var links identity.Identities
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &links))
+	if assert.Equal(t, 1, len(links)) {
+		assert.Equal(t, "amzn1.account.linked", links[0].ExternalID)
+		assert.Equal(t, identity.Alexa, links[0].Provider)
+	}
+	assert.NotContains(t, w.Body.String(), "Hash")
+
+	//the access token can't be moved to another Alexa account
+	w = serve(router, "POST", "/dishes", "",
+		fmt.Sprintf(`{"fcapiRequestType": "GET", "accessToken": "%s", "alexaUserID": "amzn1.account.other"}`, tokens.AccessToken))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(router, "POST", "/dishes", "", `{"fcapiRequestType": "GET", "alexaUserID": "amzn1.account.other"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serve(router, "DELETE", fmt.Sprint("/v1/users/me/links/", links[0].LinkID), "Bearer "+tokens.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, "POST", "/dishes", "", alexaOnly)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serve(router, "GET", "/v1/users/me/links", "Bearer "+tokens.AccessToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = requestTokens(router, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPIHandler_AlexaLinking_Refresh(t *testing.T) {
+	router, stub, _ := newLoginRouter(t)
+	tokens := linkSkill(t, router, stub)
+
+	w := requestTokens(router, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var refreshed tokenResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &refreshed))
+	assert.NotEqual(t, tokens.AccessToken, refreshed.AccessToken)
+	assert.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+
+	w = serve(router, "GET", "/v1/users/me/links", "Bearer "+tokens.AccessToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serve(router, "GET", "/v1/users/me/links", "Bearer "+refreshed.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	//a refresh token is only good once
+	w = requestTokens(router, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPIHandler_AlexaLinking_Errors(t *testing.T) {
+	router, stub, _ := newLoginRouter(t)
+
+	w := serve(router, "GET", "/alexa/authorize?client_id=someone-else&response_type=code&redirect_uri="+
+		url.QueryEscape(testSkill.RedirectURIs[0]), "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, "GET", "/alexa/authorize?client_id=alexa-client&response_type=code&redirect_uri="+
+		url.QueryEscape("https://evil.example.com/"), "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, "GET", strings.Replace(linkAuthorizePath("s"), "response_type=code", "response_type=token", 1), "", "")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Contains(t, w.Header().Get("Location"), "error=unsupported_response_type")
+
+	//the user turned the identity provider down, so Alexa hears about it
+	cookies, query := startSignIn(t, router, stub, linkAuthorizePath("alexa-state"))
+	query.Del("code")
+	query.Set("error", "access_denied")
+	w = finishSignIn(router, cookies, query)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Contains(t, w.Header().Get("Location"), "error=access_denied")
+
+	code := authorizeSkill(t, router, stub)
+	exchange := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {testSkill.RedirectURIs[0]}}
+
+	req := httptest.NewRequest("POST", "/alexa/token", strings.NewReader(exchange.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(testSkill.ID, "wrong-secret")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_client")
+
+	w = requestTokens(router, url.Values{"grant_type": {"password"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unsupported_grant_type")
+
+	//the code has to go back with the redirect URL it was issued for, and only once
+	wrongRedirect := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"https://evil.example.com/"}}
+	w = requestTokens(router, wrongRedirect)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_grant")
+
+	code = authorizeSkill(t, router, stub)
+	exchange.Set("code", code)
+	w = requestTokens(router, exchange)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = requestTokens(router, exchange)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPIHandler_AlexaLinking_AccessTokenDoesNotLink(t *testing.T) {
+	router := testRouter()
+
+	//as above, a 404 from /dishes means the user was found and has no dishes
+	w := serve(router, "POST", "/dishes", "",
+		`{"fcapiRequestType": "GET", "accessToken": "`+rUser.AccessToken+`", "alexaUserID": "amzn1.account.new"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(router, "POST", "/dishes", "", `{"fcapiRequestType": "GET", "alexaUserID": "amzn1.account.new"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	//nor does an Alexa user id linked before the linked identities table, outside the skill
+	w = serve(router, "POST", "/dishes", "", `{"fcapiRequestType": "GET", "alexaUserID": "`+rUserAlexaID+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
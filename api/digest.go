@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/digest"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//GetDigest is GET /v1/digest - what to use up soon, by storage unit, and how much was eaten and wasted lately.
+//?period= is daily (the default) or weekly, ?days= how far ahead to look, and ?format= json (the default), text or html.
+func (h *handler) GetDigest(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	period := c.DefaultQuery("period", digest.Daily)
+	days := 0
+	if value := c.Query("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil {
+			abortWithError(c, fcerr.NewValidationError("The days must be a number", fcerr.FieldDetail{Field: "days", Message: "not a number: " + value}))
+			return
+		}
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "text" && format != "html" {
+		abortWithError(c, fcerr.NewValidationError("The format must be json, text or html",
+			fcerr.FieldDetail{Field: "format", Message: "not json, text or html: " + format}))
+		return
+	}
+
+	result, fcErr := h.digestService.Get(c.Request.Context(), requestUser, period, days)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+
+	switch format {
+	case "text":
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(result.Text()))
+	case "html":
+		page, fcErr := result.HTML()
+		if fcErr != nil {
+			abortWithError(c, fcErr)
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
+	default:
+		marshaled, err := json.Marshal(result)
+		if err != nil {
+			abortWithError(c, fcerr.NewInternalServerError("Could not marshal the digest"))
+			return
+		}
+		respond(c, http.StatusOK, marshaled)
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	digestDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIHandler_V1_Digest(t *testing.T) {
+	router := testRouter()
+	bearer := "Bearer " + rUser.AccessToken
+
+	w := serve(router, "POST", "/v1/storage", bearer, `{"title": "Fridge"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	for _, body := range []string{
+		`{"storageID": "1", "title": "Soup", "expireWindow": "PT6H"}`,
+		`{"storageID": "1", "title": "Carrots", "expireWindow": "P1D", "priority": "high"}`,
+		`{"storageID": "1", "title": "Rice", "expireWindow": "P30D"}`,
+		`{"storageID": "2", "title": "Bread", "expireWindow": "PT1H"}`,
+	} {
+		w = serve(router, "POST", "/v1/dishes", bearer, body)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	w = serve(router, "DELETE", "/v1/dishes/3?outcome=eaten", bearer, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, "DELETE", "/v1/dishes/3", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code, "the rice hadn't expired, so it was eaten")
+	w = serve(router, "DELETE", "/v1/dishes/3?outcome=wasted", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code, "the bread, renumbered")
+	for _, title := range []string{"Cheese", "Milk"} {
+		w = serve(router, "POST", "/v1/dishes", bearer, `{"storageID": "7", "title": "`+title+`", "expireWindow": "PT2H"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+	w = serve(router, "DELETE", "/v1/dishes/4", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, "GET", "/v1/digest", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var result digestDomain.Digest
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, digestDomain.Daily, result.Period)
+	assert.Equal(t, 2, result.Consumed, "the rice and the milk")
+	assert.Equal(t, 1, result.Wasted, "the bread")
+	assert.Equal(t, 3, result.Expiring)
+	if assert.Equal(t, 2, len(result.Storages)) {
+		assert.Equal(t, "Fridge", result.Storages[0].Title)
+		if assert.Equal(t, 2, len(result.Storages[0].Dishes)) {
+			assert.Equal(t, "Carrots", result.Storages[0].Dishes[0].Title, "high priority goes first")
+			assert.Equal(t, "Soup", result.Storages[0].Dishes[1].Title)
+		}
+		assert.Equal(t, "Elsewhere", result.Storages[1].Title)
+	}
+
+	w = serve(router, "GET", "/v1/digest?period=weekly&format=text", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "Fridge\n- Carrots (high priority) expires tomorrow\n- Soup expires today\n")
+	assert.Contains(t, w.Body.String(), "used up 2 dishes and threw away 1 dish.")
+
+	w = serve(router, "GET", "/v1/digest?format=html", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "<li>Carrots (high priority) expires tomorrow</li>")
+
+	for _, query := range []string{"period=monthly", "days=x", "days=90", "format=pdf"} {
+		w = serve(router, "GET", "/v1/digest?"+query, bearer, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
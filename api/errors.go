@@ -0,0 +1,109 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//RequestIDHeader is the header a request ID is read from and echoed back in, so a client can match an error to the server logs.
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDKey = "fcapiRequestID"
+
+//maxRequestIDLength keeps a caller from filling the logs with an enormous request ID.
+const maxRequestIDLength = 128
+
+//errorEnvelope is the body of every error response:
+//{"error": {"code": "not_found", "message": "...", "status": 404, "requestId": "...", "details": [...]}}
+type errorEnvelope struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code      string              `json:"code"`
+	Message   string              `json:"message"`
+	Status    int                 `json:"status"`
+	RequestID string              `json:"requestId"`
+	Details   []fcerr.FieldDetail `json:"details,omitempty"`
+}
+
+//ErrorHandler gives every request an ID, and renders the last error a handler added with c.Error as the JSON error envelope.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = newRequestID()
+		}
+		c.Set(requestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		fcErr := toFCErr(c.Errors.Last().Err)
+		fmt.Println("request", requestID, "failed with:", fcErr.Error())
+		c.JSON(fcErr.Status(), errorEnvelope{
+			Error: errorBody{
+				Code:      fcErr.Code(),
+				Message:   fcErr.Message(),
+				Status:    fcErr.Status(),
+				RequestID: requestID,
+				Details:   fcErr.Details(),
+			},
+		})
+	}
+}
+
+//abortWithError stops the request and leaves the error for ErrorHandler to render.
+func abortWithError(c *gin.Context, err fcerr.FCErr) {
+	c.Error(err)
+	c.Abort()
+}
+
+//toFCErr finds the FCErr to show the client. A timeout anywhere in the chain wins, so a cancelled database call
+//reads as a 504 even if a service wrapped it in a 500; anything that isn't an FCErr at all is a 500.
+func toFCErr(err error) fcerr.FCErr {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if fcErr, ok := e.(fcerr.FCErr); ok && fcErr.Status() == http.StatusGatewayTimeout {
+			return fcErr
+		}
+	}
+
+	var fcErr fcerr.FCErr
+	if errors.As(err, &fcErr) {
+		return fcErr
+	}
+	return fcerr.Wrap(err, "Something went wrong while handling the request", http.StatusInternalServerError)
+}
+
+//newRequestID makes a random 16 byte hex ID for a request that didn't bring its own.
+func newRequestID() string {
+	n := make([]byte, 16)
+	rand.Read(n)
+	return hex.EncodeToString(n)
+}
+
+//bindError turns a JSON binding failure into a 400, naming the field when the body had a value of the wrong type.
+func bindError(err error) fcerr.FCErr {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fcerr.NewValidationError("The request body has a value of the wrong type",
+			fcerr.FieldDetail{Field: typeErr.Field, Message: "should be a " + typeErr.Type.String()})
+	}
+	return fcerr.NewValidationError("The request body is not valid JSON: " + err.Error())
+}
+
+//notImplemented is the error for a legacy fcapiRequestType that a route doesn't handle.
+func notImplemented(requestType string) fcerr.FCErr {
+	return fcerr.NewFCErr("This route does not handle the fcapiRequestType \""+requestType+"\"", http.StatusNotImplemented)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+func TestAPIHandler_ErrorEnvelope_NotFound(t *testing.T) {
+	router := testRouter()
+
+	w := serve(router, "GET", "/v1/dishes/42", "Bearer "+rUser.AccessToken, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var envelope errorEnvelope
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	assert.Equal(t, "not_found", envelope.Error.Code)
+	assert.Equal(t, http.StatusNotFound, envelope.Error.Status)
+	assert.NotEmpty(t, envelope.Error.Message)
+	assert.NotEmpty(t, envelope.Error.RequestID)
+	assert.Equal(t, w.Header().Get(RequestIDHeader), envelope.Error.RequestID)
+}
+
+func TestAPIHandler_ErrorEnvelope_FieldDetails(t *testing.T) {
+	router := testRouter()
+
+	w := serve(router, "POST", "/v1/dishes", "Bearer "+rUser.AccessToken, `{"storageID": "the fridge", "title": "Carrots", "expireWindow": "P7D"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var envelope errorEnvelope
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	assert.Equal(t, "bad_request", envelope.Error.Code)
+	if assert.Equal(t, 1, len(envelope.Error.Details)) {
+		assert.Equal(t, "storageID", envelope.Error.Details[0].Field)
+	}
+
+	w = serve(router, "POST", "/v1/dishes", "Bearer "+rUser.AccessToken, `{"dishID": "one"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	if assert.Equal(t, 1, len(envelope.Error.Details)) {
+		assert.Equal(t, "dishID", envelope.Error.Details[0].Field)
+	}
+}
+
+func TestAPIHandler_ErrorHandler_KeepsRequestID(t *testing.T) {
+	router := testRouter()
+
+	req := httptest.NewRequest("GET", "/v1/dishes", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "abc-123", w.Header().Get(RequestIDHeader))
+	var envelope errorEnvelope
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	assert.Equal(t, "abc-123", envelope.Error.RequestID)
+	assert.Equal(t, "unauthorized", envelope.Error.Code)
+}
+
+func TestAPIHandler_toFCErr(t *testing.T) {
+	timeout := fcerr.Wrap(context.DeadlineExceeded, "The database ran out of time", http.StatusGatewayTimeout)
+	wrapped := fcerr.Wrap(timeout, "Could not get the dishes", http.StatusInternalServerError)
+	assert.Equal(t, http.StatusGatewayTimeout, toFCErr(wrapped).Status())
+
+	notFound := fcerr.NewNotFoundError("Database could not find any dishes")
+	assert.Equal(t, http.StatusNotFound, toFCErr(notFound).Status())
+
+	plain := toFCErr(errors.New("something broke"))
+	assert.Equal(t, http.StatusInternalServerError, plain.Status())
+	assert.Equal(t, "internal_server_error", plain.Code())
+}
@@ -2,24 +2,37 @@ package api
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"html"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jasonradcliffe/freshness-countdown-api/ask"
+	"github.com/jasonradcliffe/freshness-countdown-api/duration"
 	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc"
 	"golang.org/x/oauth2"
 
 	"github.com/gin-gonic/gin"
 	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	sessionDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/session"
 	storageDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
 	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/digest"
 	"github.com/jasonradcliffe/freshness-countdown-api/services/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/link"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/session"
 	"github.com/jasonradcliffe/freshness-countdown-api/services/storage"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/stream"
 	"github.com/jasonradcliffe/freshness-countdown-api/services/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/webhook"
 )
 
 //Handler interface is the contract for the methods that the handler needs to have.
@@ -30,7 +43,12 @@ type Handler interface {
 	Login(*gin.Context)
 	Oauthlogin(*gin.Context)
 	LoginSuccess(*gin.Context)
+	Logout(*gin.Context)
 
+	//RequireUser is the middleware for the /v1 routes - it resolves the bearer token to a user before the handler runs.
+	RequireUser(*gin.Context)
+
+	//The legacy routes take the credentials and fcapiRequestType in a POSTed body, and pass the request on to the /v1 handlers.
 	GetDishes(*gin.Context)
 	HandleDishRequest(*gin.Context)
 	GetDishesExpired(*gin.Context)
@@ -41,20 +59,89 @@ type Handler interface {
 	GetStorageDishes(*gin.Context)
 
 	HandleUsersRequest(*gin.Context)
-}
 
-type oauthConfig interface {
-	//Exchange func(ctx context.Context, code string, opts ...AuthCodeOption)
-	Exchange(context.Context, string, ...oauth2.AuthCodeOption) (*oauth2.Token, error)
-	//AuthCodeURL func(state string, opts ...AuthCodeOption)
-	AuthCodeURL(string, ...oauth2.AuthCodeOption) string
+	//The /v1 routes use the HTTP verb and an Authorization header.
+	ListDishes(*gin.Context)
+	ListExpiredDishes(*gin.Context)
+	ListDishesExpiredBy(*gin.Context)
+	GetDish(*gin.Context)
+	CreateDish(*gin.Context)
+	UpdateDish(*gin.Context)
+	DeleteDish(*gin.Context)
+
+	ListStorages(*gin.Context)
+	GetStorage(*gin.Context)
+	ListStorageDishes(*gin.Context)
+	CreateStorage(*gin.Context)
+	UpdateStorage(*gin.Context)
+	DeleteStorage(*gin.Context)
+
+	GetUser(*gin.Context)
+	UpdateUser(*gin.Context)
+	DeleteUser(*gin.Context)
+	CancelUserDeletion(*gin.Context)
+	ExportUser(*gin.Context)
+
+	//GetDigest sums up what the user's household should use up soon.
+	GetDigest(*gin.Context)
+
+	//The household the user shares their storage units and dishes with.
+	GetHousehold(*gin.Context)
+	UpdateHousehold(*gin.Context)
+	InviteHouseholdMember(*gin.Context)
+	UpdateHouseholdMember(*gin.Context)
+	RemoveHouseholdMember(*gin.Context)
+
+	//The invites into other households waiting for the user to accept or decline.
+	ListInvites(*gin.Context)
+	AcceptInvite(*gin.Context)
+	DeclineInvite(*gin.Context)
+
+	//The URLs the user has the events in their household posted to, and the log of what was posted.
+	GetWebhooks(*gin.Context)
+	CreateWebhook(*gin.Context)
+	GetWebhook(*gin.Context)
+	UpdateWebhook(*gin.Context)
+	DeleteWebhook(*gin.Context)
+	GetWebhookDeliveries(*gin.Context)
+	GetDeadLetters(*gin.Context)
+	RedeliverWebhook(*gin.Context)
+
+	//StreamEvents streams what happens in the user's household as server-sent events.
+	StreamEvents(*gin.Context)
+
+	//Alexa account linking - the skill's authorization and token URLs, and the user's own list of linked accounts.
+	AlexaAuthorize(*gin.Context)
+	AlexaToken(*gin.Context)
+	ListLinks(*gin.Context)
+	Unlink(*gin.Context)
+
+	//Skill is the Alexa skill's endpoint, answering Alexa's signed requests in speech.
+	Skill(*gin.Context)
+
+	//RequireAdmin is the middleware for the /admin routes, after RequireUser. The rest are for admins looking after users.
+	RequireAdmin(*gin.Context)
+	ListUsers(*gin.Context)
+	DeactivateUser(*gin.Context)
+	ReactivateUser(*gin.Context)
+	ImpersonateUser(*gin.Context)
+	ExpiredDishStats(*gin.Context)
+	TokenCacheStats(*gin.Context)
 }
 
 type handler struct {
-	dishService    dish.Service
-	storageService storage.Service
-	userService    user.Service
-	oauthConfig    oauthConfig
+	dishService      dish.Service
+	storageService   storage.Service
+	userService      user.Service
+	householdService household.Service
+	sessionService   session.Service
+	linkService      link.Service
+	digestService    digest.Service
+	webhookService   webhook.Service
+	streamService    stream.Service
+	skillVerifier    *ask.Verifier
+	providers        *oidc.Providers
+	logins           *loginStore
 }
 
 type apiRequest struct {
@@ -70,293 +157,496 @@ type apiRequest struct {
 	Priority     string `json:"priority"`
 	DishType     string `json:"dishType"`
 	Portions     int    `json:"portions"`
+	Name         string `json:"name"`
+	Email        string `json:"email"`
+	Role         string `json:"role"`
+	Outcome      string `json:"outcome"`
+	//the user's own profile, and how long to wait before erasing their account
+	FirstName        string `json:"firstName"`
+	LastName         string `json:"lastName"`
+	FullName         string `json:"fullName"`
+	TimeZone         string `json:"timeZone"`
+	NotifyExpiring   *bool  `json:"notifyExpiring"`
+	NotifyDaysBefore *int   `json:"notifyDaysBefore"`
+	QuietStart       string `json:"quietStart"`
+	QuietEnd         string `json:"quietEnd"`
+	Grace            string `json:"grace"`
+	//a webhook's URL, the types of event posted to it, and whether it is turned on
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
 }
 
-var oauthstate string
-var currentUser userDomain.OauthUser
-
-//NewHandler takes a sequence of services and returns a new API Handler.
-func NewHandler(ds dish.Service, ss storage.Service, us user.Service, oC oauthConfig) Handler {
+//NewHandler takes a sequence of services, the verifier for the Alexa skill's requests and the identity providers users
+//sign in with, and returns a new API Handler. A nil skill verifier turns the skill endpoint off.
+func NewHandler(ds dish.Service, ss storage.Service, us user.Service, hs household.Service, sessions session.Service,
+	links link.Service, digests digest.Service, webhooks webhook.Service, streams stream.Service, skill *ask.Verifier,
+	providers *oidc.Providers) Handler {
 	return &handler{
-		dishService:    ds,
-		storageService: ss,
-		userService:    us,
-		oauthConfig:    oC,
+		dishService:      ds,
+		storageService:   ss,
+		userService:      us,
+		householdService: hs,
+		sessionService:   sessions,
+		linkService:      links,
+		digestService:    digests,
+		webhookService:   webhooks,
+		streamService:    streams,
+		skillVerifier:    skill,
+		providers:        providers,
+		logins:           newLoginStore(loginAttemptTTL),
 	}
 }
 
-//ValidateUser looks at the request details and extracts the user making the request. Err is returned if not able to find OR add a user
-func ValidateUser(h *handler, aR apiRequest) (*userDomain.User, fcerr.FCErr) {
-	alexaIDUser, err := h.userService.GetByAlexaID(aR.AlexaUserID)
+//ValidateUser looks at the request details and extracts the user making the request. Err is returned if not able to find OR add a user.
+//A session token the API minted at login is checked against the session table, without a call to Google, and gives a 401
+//if it is unknown, logged out or expired. Anything else is treated as an access token from the default identity provider and
+//gives a 403 if the provider doesn't know it.
+//An Alexa user id never identifies the user here. One that comes along with the access token the skill was given at
+//linking is tied to that token's user, for the signed skill requests to /alexa/skill to be answered with.
+//A user an admin has deactivated gets a 403 whichever way they come in.
+func ValidateUser(ctx context.Context, h *handler, aR apiRequest) (*userDomain.User, fcerr.FCErr) {
+	requestUser, err := requestingUser(ctx, h, aR)
 	if err != nil {
-		fmt.Println("couldn't get a user from alexa id:" + aR.AlexaUserID)
-		accessTokenUser, err2 := h.userService.GetOrCreateByAccessToken(aR.AccessToken, user.NewClient())
-		if err2 != nil {
-			fmt.Println("couldn't get or create a user with access token:" + aR.AccessToken)
-			return nil, fcerr.NewUnauthorizedError("Could not validate this user")
-		}
+		return nil, err
+	}
+	if !requestUser.IsActive() {
+		fmt.Println("user", requestUser.UserID, "is deactivated")
+		return nil, fcerr.NewForbiddenError(session.DeactivatedMessage)
+	}
+	return requestUser, nil
+}
 
-		fmt.Println("Here is the user we got from the access token!" + accessTokenUser.Email)
-		fmt.Println("We should add the user's alexa ID since we know the db doesn't have it")
-		_, err3 := h.userService.UpdateAlexaID(*accessTokenUser, aR.AlexaUserID)
-		if err3 != nil {
-			fmt.Println("We couldn't add the alexa user id of the new user - no biggie")
+//requestingUser is ValidateUser without the check that the user is active.
+func requestingUser(ctx context.Context, h *handler, aR apiRequest) (*userDomain.User, fcerr.FCErr) {
+	if sessionDomain.IsToken(aR.AccessToken) {
+		sessionUser, err := h.sessionService.Validate(ctx, aR.AccessToken)
+		if err != nil {
+			fmt.Println("couldn't validate the session token:", err.Message())
+			return nil, err
 		}
-		return accessTokenUser, nil
+		if aR.AlexaUserID != "" && h.linkService != nil {
+			if err := h.linkService.Bind(ctx, sessionUser, aR.AccessToken, aR.AlexaUserID); err != nil {
+				fmt.Println("did not link the alexa user id to the session's user:", err.Message())
+			}
+		}
+		return sessionUser, nil
 	}
 
-	fmt.Println("Here is the user we got from the Alexa ID!" + alexaIDUser.Email)
-	return alexaIDUser, nil
+	accessTokenUser, err := h.userService.GetOrCreateByAccessToken(ctx, aR.AccessToken, user.NewClient())
+	if err != nil {
+		fmt.Println("couldn't get or create a user with the access token:", err.Message())
+		return nil, fcerr.NewForbiddenError("Could not validate this user")
+	}
+	return accessTokenUser, nil
 }
 
-//------Dishes Handler and Helpers---------------------------------------------------------------------------------------------------------------
-func (h *handler) GetDishes(c *gin.Context) {
+//legacyRequestKey and requestUserKey are where a legacy route leaves the body and the validated user
+//for the /v1 handler it passes the request on to.
+const legacyRequestKey = "fcapiLegacyRequest"
+const requestUserKey = "fcapiRequestUser"
+
+//legacyRequest reads a legacy fcapiRequestType body and validates the user named in it. It aborts the request
+//and returns false if that fails.
+func (h *handler) legacyRequest(c *gin.Context) (apiRequest, bool) {
 	var aR apiRequest
 
 	if err := c.ShouldBindJSON(&aR); err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
+		abortWithError(c, bindError(err))
+		return aR, false
 	}
 
-	if aR.AlexaUserID == "" && aR.AccessToken == "" {
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
+	//an alexaUserID on its own isn't proof of who is asking - only the skill's signed requests can be trusted with one
+	if aR.AccessToken == "" {
+		abortWithError(c, fcerr.NewUnauthorizedError("The request needs an accessToken"))
+		return aR, false
 	}
-	requestUser, err := ValidateUser(h, aR)
+	requestUser, err := ValidateUser(c.Request.Context(), h, aR)
 	if err != nil {
-		c.AbortWithStatus(http.StatusForbidden)
+		abortWithError(c, err)
+		return aR, false
+	}
+
+	c.Set(legacyRequestKey, aR)
+	c.Set(requestUserKey, requestUser)
+	return aR, true
+}
+
+//RequireUser resolves the "Authorization: Bearer" header to the user making the request and leaves it for the handler.
+//It aborts the request if there is no valid user.
+func (h *handler) RequireUser(c *gin.Context) {
+	if _, ok := c.Get(requestUserKey); ok {
+		c.Next()
+		return
+	}
+	if _, ok := h.headerUser(c); !ok {
 		return
 	}
+	c.Next()
+}
 
-	if aR.RequestType == "GET" {
-		fmt.Println("got the getDishes route!!!")
-		marshaledDishList, err := getDishes(requestUser, h.dishService)
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
+//headerUser validates the bearer token from the "Authorization" header and sets requestUserKey. It aborts the request
+//and returns false if there is no token or it doesn't belong to a user.
+func (h *handler) headerUser(c *gin.Context) (*userDomain.User, bool) {
+	//credentials only come from the header on /v1 routes
+	aR := apiRequest{AccessToken: bearerToken(c.GetHeader("Authorization"))}
+	if aR.AccessToken == "" {
+		c.Header("WWW-Authenticate", "Bearer")
+		abortWithError(c, fcerr.NewUnauthorizedError("The request needs an \"Authorization: Bearer <token>\" header"))
+		return nil, false
+	}
+
+	requestUser, err := ValidateUser(c.Request.Context(), h, aR)
+	if err != nil {
+		if err.Status() == http.StatusUnauthorized {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		}
+		abortWithError(c, err)
+		return nil, false
+	}
+	c.Set(requestUserKey, requestUser)
+	return requestUser, true
+}
+
+//authenticate gives the request body and the user making the request. A legacy route has already read both;
+//otherwise RequireUser has found the user from the "Authorization: Bearer" header and POST and PATCH bodies are read as JSON.
+//It aborts the request and returns false if there is no valid user.
+func (h *handler) authenticate(c *gin.Context) (apiRequest, *userDomain.User, bool) {
+	if legacy, ok := c.Get(legacyRequestKey); ok {
+		requestUser := c.MustGet(requestUserKey).(*userDomain.User)
+		return legacy.(apiRequest), requestUser, true
+	}
+
+	var requestUser *userDomain.User
+	if u, ok := c.Get(requestUserKey); ok {
+		requestUser = u.(*userDomain.User)
+	} else if requestUser, ok = h.headerUser(c); !ok {
+		return apiRequest{}, nil, false
+	}
+
+	var aR apiRequest
+	if (c.Request.Method == http.MethodPost || c.Request.Method == http.MethodPatch) && c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&aR); err != nil {
+			abortWithError(c, bindError(err))
+			return aR, nil, false
 		}
+	}
+	aR.AccessToken = bearerToken(c.GetHeader("Authorization"))
+	aR.AlexaUserID = ""
+	return aR, requestUser, true
+}
+
+//bearerToken pulls the token out of an "Authorization: Bearer <token>" header value, or gives "" if it isn't one.
+func bearerToken(authorization string) string {
+	scheme, token, found := strings.Cut(strings.TrimSpace(authorization), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+//personalID reads the dish or storage unit id from the path - :id on the /v1 routes, :p_id on the legacy ones.
+//It aborts with a 400 and returns false if the id isn't a number.
+func personalID(c *gin.Context) (int, bool) {
+	idParam := c.Param("id")
+	if idParam == "" {
+		idParam = c.Param("p_id")
+	}
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		abortWithError(c, fcerr.NewValidationError("The id in the path must be a number", fcerr.FieldDetail{Field: "id", Message: "not a number: " + idParam}))
+		return 0, false
+	}
+	return id, true
+}
 
-		c.JSON(200, gin.H{
-			"message": marshaledDishList,
+//respond writes a successful result. /v1 routes get the JSON itself, while legacy routes keep their
+//{"message": <the JSON as base64>} body so existing clients don't break.
+func respond(c *gin.Context, status int, marshaled []byte) {
+	if _, legacy := c.Get(legacyRequestKey); legacy {
+		c.JSON(http.StatusOK, gin.H{
+			"message": marshaled,
 		})
 		return
 	}
-	c.AbortWithStatus(http.StatusNotImplemented)
+	c.Data(status, "application/json; charset=utf-8", marshaled)
 }
 
-func (h *handler) GetDishesExpired(c *gin.Context) {
-	var aR apiRequest
+//respondMessage writes a confirmation message, in the same legacy or /v1 shape as respond.
+func respondMessage(c *gin.Context, status int, message string) {
+	if _, legacy := c.Get(legacyRequestKey); legacy {
+		c.JSON(http.StatusOK, gin.H{
+			"message": []byte(message),
+		})
+		return
+	}
+	c.JSON(status, gin.H{
+		"message": message,
+	})
+}
 
-	if err := c.ShouldBindJSON(&aR); err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
+//------Dishes Handler and Helpers---------------------------------------------------------------------------------------------------------------
+
+//GetDishes is the legacy POST /dishes route.
+func (h *handler) GetDishes(c *gin.Context) {
+	aR, ok := h.legacyRequest(c)
+	if !ok {
 		return
 	}
 
-	if aR.AlexaUserID == "" && aR.AccessToken == "" {
-		c.AbortWithStatus(http.StatusUnauthorized)
+	if aR.RequestType == "GET" {
+		fmt.Println("got the getDishes route!!!")
+		h.ListDishes(c)
 		return
 	}
-	requestUser, err := ValidateUser(h, aR)
-	if err != nil {
-		c.AbortWithStatus(http.StatusForbidden)
+	abortWithError(c, notImplemented(aR.RequestType))
+}
+
+//GetDishesExpired is the legacy POST /dishes/expired route.
+func (h *handler) GetDishesExpired(c *gin.Context) {
+	aR, ok := h.legacyRequest(c)
+	if !ok {
 		return
 	}
 
 	if aR.RequestType == "GET" {
 		fmt.Println("got the get expired dishes route!!!")
-		marshaledDishList, err := getDishesExpired(requestUser, h.dishService)
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-
-		c.JSON(200, gin.H{
-			"message": marshaledDishList,
-		})
+		h.ListExpiredDishes(c)
 		return
 	}
-	c.AbortWithStatus(http.StatusNotImplemented)
+	abortWithError(c, notImplemented(aR.RequestType))
 }
 
+//GetDishesExpiredBy is the legacy POST /dishes/expiredby/ route, which takes the date as expireDate in the body.
 func (h *handler) GetDishesExpiredBy(c *gin.Context) {
-	var aR apiRequest
-
-	if err := c.ShouldBindJSON(&aR); err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
+	aR, ok := h.legacyRequest(c)
+	if !ok {
 		return
 	}
 
-	if aR.AlexaUserID == "" && aR.AccessToken == "" {
-		c.AbortWithStatus(http.StatusUnauthorized)
+	if aR.RequestType == "GET" {
+		fmt.Println("got the get dishes Expired by date route!!!")
+		h.ListDishesExpiredBy(c)
 		return
 	}
-	requestUser, err := ValidateUser(h, aR)
-	if err != nil {
-		c.AbortWithStatus(http.StatusForbidden)
+	abortWithError(c, notImplemented(aR.RequestType))
+}
+
+//HandleDishRequest is the legacy POST /dishes/dish/:p_id route, which picks the action from fcapiRequestType in the body.
+func (h *handler) HandleDishRequest(c *gin.Context) {
+	aR, ok := h.legacyRequest(c)
+	if !ok {
 		return
 	}
 
-	if aR.RequestType == "GET" {
-		fmt.Println("got the get dishes Expired by date route!!!")
+	fmt.Println("got the p_id param:" + c.Param("p_id"))
 
-		if aR.ExpireDate == "" {
-			c.AbortWithStatus(http.StatusBadRequest)
+	switch aR.RequestType {
+	case "GET":
+		if c.Param("p_id") == "" {
+			abortWithError(c, fcerr.NewValidationError("A GET needs the id in the path", fcerr.FieldDetail{Field: "id", Message: "missing"}))
 			return
 		}
+		h.GetDish(c)
+	case "POST":
+		h.CreateDish(c)
+	case "PATCH":
+		h.UpdateDish(c)
+	case "DELETE":
+		h.DeleteDish(c)
+	default:
+		abortWithError(c, notImplemented(aR.RequestType))
+	}
+}
 
-		marshaledDishList, err := getDishesExpiredBy(requestUser, aR.ExpireDate, h.dishService)
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
+//ListDishes is GET /v1/dishes - the dishes the requesting user has, narrowed down, sorted and paged by the query
+//string the way dishQuery reads it. The cursor for the next page is in the NextCursorHeader.
+func (h *handler) ListDishes(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	q, ok := dishQuery(c)
+	if !ok {
+		return
+	}
 
-		c.JSON(200, gin.H{
-			"message": marshaledDishList,
-		})
+	marshaledDishList, next, err := getDishes(c.Request.Context(), requestUser, q, h.dishService)
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
-	c.AbortWithStatus(http.StatusNotImplemented)
+	if next != "" {
+		c.Header(NextCursorHeader, next)
+	}
+	respond(c, http.StatusOK, marshaledDishList)
 }
 
-func (h *handler) HandleDishRequest(c *gin.Context) {
-	var aR apiRequest
+//ListExpiredDishes is GET /v1/dishes/expired - the requesting user's dishes that have already expired.
+func (h *handler) ListExpiredDishes(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
 
-	if err := c.ShouldBindJSON(&aR); err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
+	marshaledDishList, err := getDishesExpired(c.Request.Context(), requestUser, h.dishService)
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
+	respond(c, http.StatusOK, marshaledDishList)
+}
 
-	if aR.AlexaUserID == "" && aR.AccessToken == "" {
-		c.AbortWithStatus(http.StatusUnauthorized)
+//ListDishesExpiredBy is GET /v1/dishes/expiredby/:date - the requesting user's dishes that will have expired by the date.
+func (h *handler) ListDishesExpiredBy(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
 		return
 	}
-	requestUser, err := ValidateUser(h, aR)
-	if err != nil {
-		c.AbortWithStatus(http.StatusForbidden)
+
+	expireDate := c.Param("date")
+	if expireDate == "" {
+		expireDate = aR.ExpireDate
+	}
+	if expireDate == "" {
+		abortWithError(c, fcerr.NewValidationError("The request needs a date to check against", fcerr.FieldDetail{Field: "expireDate", Message: "missing"}))
 		return
 	}
 
-	dishIDParam := c.Param("p_id")
-	fmt.Println("got the p_id param:" + dishIDParam)
+	marshaledDishList, err := getDishesExpiredBy(c.Request.Context(), requestUser, expireDate, h.dishService)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, marshaledDishList)
+}
 
-	switch aR.RequestType {
+//GetDish is GET /v1/dishes/:id - one of the requesting user's dishes, by its personal id.
+func (h *handler) GetDish(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	dishID, ok := personalID(c)
+	if !ok {
+		return
+	}
+	fmt.Println("dishID:" + strconv.Itoa(dishID))
 
-	case "GET":
-		if dishIDParam != "" {
-			dishID, err := strconv.Atoi(dishIDParam)
-			if err != nil {
-				c.AbortWithStatus(http.StatusBadRequest)
-				return
-			}
-			fmt.Println("dishID:" + strconv.Itoa(dishID))
+	marshaledDish, err := getDishByID(c.Request.Context(), requestUser, dishID, h.dishService)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, marshaledDish)
+}
 
-			marshaledDish, err := getDishByID(requestUser, dishID, h.dishService)
-			if err != nil {
-				c.AbortWithStatus(http.StatusInternalServerError)
-				return
-			}
+//CreateDish is POST /v1/dishes, with the new dish in the body.
+func (h *handler) CreateDish(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
 
-			c.JSON(200, gin.H{
-				"message": marshaledDish,
-			})
-			return
-		}
+	fmt.Println("doing the createDish() within the dish request handler")
+	err := createDish(c.Request.Context(), requestUser, aR, h.dishService)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	fmt.Println("Successfully added the dish to the database!")
+	respondMessage(c, http.StatusCreated, "Your dish has been added to the database.")
+}
 
-		c.AbortWithStatus(http.StatusBadRequest)
+//UpdateDish is PATCH /v1/dishes/:id, with the fields to change in the body.
+func (h *handler) UpdateDish(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	dishID, ok := personalID(c)
+	if !ok {
 		return
+	}
 
-	case "POST":
-		fmt.Println("doing the createDish() within the dish request handler")
-		err := createDish(requestUser, aR, h.dishService)
-		if err != nil {
-			c.AbortWithStatus(err.Status())
-			return
-		}
-		fmt.Println("Successfully added the dish to the database!")
-		c.JSON(200, gin.H{
-			"message": []byte("Your dish has been added to the database."),
-		})
+	fmt.Println("got the dish update method for dish number:", dishID)
+	err := updateDish(c.Request.Context(), requestUser, dishID, aR, h.dishService)
+	if err != nil {
+		fmt.Println("Got an error when doing the update dish route:" + err.Message())
+		abortWithError(c, err)
 		return
+	}
+	fmt.Println("Successfully updated the dish in the database!")
+	respondMessage(c, http.StatusOK, "Your dish has been updated in the database.")
+}
 
-	case "PATCH":
-		dishID, err := strconv.Atoi(dishIDParam)
-		if err != nil {
-			c.AbortWithStatus(http.StatusBadRequest)
-			return
-		}
-		fmt.Println("got the dish update method for dish number:", dishID)
-		err2 := updateDish(requestUser, dishID, aR, h.dishService)
-		if err2 != nil {
-			fmt.Println("Got an error when doing the update dish route:" + err2.Message())
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-		fmt.Println("Successfully updated the dish in the database!")
-		c.JSON(200, gin.H{
-			"message": []byte("Your dish has been updated in the database."),
-		})
-	case "DELETE":
-		dishID, err := strconv.Atoi(dishIDParam)
-		if err != nil {
-			c.AbortWithStatus(http.StatusBadRequest)
-			return
-		}
-		fmt.Println("got the dish delete method for dish number:", dishID)
-		err2 := deleteDish(requestUser, dishID, h.dishService)
-		if err2 != nil {
-			fmt.Println("Got an error when doing the delete dish route")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-		fmt.Println("Successfully deleted the dish from the database!")
-		c.JSON(200, gin.H{
-			"message": []byte("Your dish has been deleted from the database."),
-		})
+//DeleteDish is DELETE /v1/dishes/:id. ?outcome=consumed or wasted - or "outcome" in a legacy body - says what became of
+//the dish; without it, an expired dish counts as wasted.
+func (h *handler) DeleteDish(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	dishID, ok := personalID(c)
+	if !ok {
+		return
+	}
 
-	default:
-		c.AbortWithStatus(http.StatusNotImplemented)
+	outcome := aR.Outcome
+	if outcome == "" {
+		outcome = c.Query("outcome")
+	}
 
+	fmt.Println("got the dish delete method for dish number:", dishID)
+	err := deleteDish(c.Request.Context(), requestUser, dishID, outcome, h.dishService)
+	if err != nil {
+		fmt.Println("Got an error when doing the delete dish route")
+		abortWithError(c, err)
+		return
 	}
+	fmt.Println("Successfully deleted the dish from the database!")
+	respondMessage(c, http.StatusOK, "Your dish has been deleted from the database.")
 }
 
-//getDishes gets all the dishes the active user has
-func getDishes(requestUser *userDomain.User, service dish.Service) ([]byte, fcerr.FCErr) {
-	var dishes *dishDomain.Dishes
-	var err fcerr.FCErr
+//getDishes gets a page of the dishes the active user has, and the cursor for the next page. Asking for every dish, or
+//every dish in a storage unit, and finding none is a 404 as it always was - a narrowed down or paged list can be empty.
+func getDishes(ctx context.Context, requestUser *userDomain.User, q dishDomain.Query, service dish.Service) ([]byte, string, fcerr.FCErr) {
 	fmt.Println("Running the getDishes function")
 
-	//accessToken := aR.AccessToken
-
-	dishes, err = service.GetAll(requestUser)
-
+	dishes, next, err := service.List(ctx, requestUser, q)
 	if err != nil {
 		fmt.Println("could not handle the GetDishes route")
-		return nil, fcerr.NewInternalServerError("unsuccessful at service.GetAll")
+		return nil, "", err
 	}
 
-	fmt.Println("I think we got some dishes!!! The first of which is:", (*dishes)[0])
+	everything := q
+	everything.StorageID = nil
+	if len(*dishes) == 0 && everything == (dishDomain.Query{}) {
+		return nil, "", fcerr.NewNotFoundError("Could not find any dishes")
+	}
+	fmt.Println("The length of the list we got is:", len(*dishes))
 
 	marshaledDishes, merr := json.Marshal(dishes)
 	if merr != nil {
-		return nil, fcerr.NewInternalServerError("JSON Error - Could not marshal the dishes")
+		return nil, "", fcerr.NewInternalServerError("JSON Error - Could not marshal the dishes")
 	}
-	return marshaledDishes, nil
+	return marshaledDishes, next, nil
 }
 
 //getDishByID gets a particular dish the requesting user has
-func getDishByID(requestingUser *userDomain.User, pID int, service dish.Service) ([]byte, fcerr.FCErr) {
+func getDishByID(ctx context.Context, requestingUser *userDomain.User, pID int, service dish.Service) ([]byte, fcerr.FCErr) {
 	var dish *dishDomain.Dish
 	var err fcerr.FCErr
 	fmt.Println("running non-gin getDishByID func")
 
 	//accessToken := aR.AccessToken
 
-	dish, err = service.GetByID(requestingUser, pID)
+	dish, err = service.GetByID(ctx, requestingUser, pID)
 
 	if err != nil {
 		//fcerr := fcerr.NewInternalServerError("could not handle the GetDishes route")
 		fmt.Println("could not handle the GetDishes route")
-		return nil, fcerr.NewInternalServerError("unsuccessful at service.GetAll")
+		return nil, err
 	}
 
 	fmt.Println("I think we got a dish!!! It is:", dish.Title)
@@ -369,19 +659,19 @@ func getDishByID(requestingUser *userDomain.User, pID int, service dish.Service)
 }
 
 //getDishesExpired gets all the dishes the active user has that have already expired
-func getDishesExpired(rUser *userDomain.User, service dish.Service) ([]byte, fcerr.FCErr) {
+func getDishesExpired(ctx context.Context, rUser *userDomain.User, service dish.Service) ([]byte, fcerr.FCErr) {
 	var dishes *dishDomain.Dishes
 	var err fcerr.FCErr
 	fmt.Println("Running the getDishesExpired function")
 
 	//accessToken := aR.AccessToken
 
-	dishes, err = service.GetExpired(rUser)
+	dishes, err = service.GetExpired(ctx, rUser)
 
 	if err != nil {
 		//fcerr := fcerr.NewInternalServerError("could not handle the GetDishes route")
 		fmt.Println("could not handle the get expired dishes handle function")
-		return nil, fcerr.NewInternalServerError("unsuccessful at service.GetAll")
+		return nil, err
 	}
 
 	fmt.Println("The length of the list we got is:", len(*dishes))
@@ -397,16 +687,16 @@ func getDishesExpired(rUser *userDomain.User, service dish.Service) ([]byte, fce
 }
 
 //getDishesExpiresBy gets all the dishes the active user has that have already expired
-func getDishesExpiredBy(rUser *userDomain.User, checkDateStr string, service dish.Service) ([]byte, fcerr.FCErr) {
+func getDishesExpiredBy(ctx context.Context, rUser *userDomain.User, checkDateStr string, service dish.Service) ([]byte, fcerr.FCErr) {
 	var dishes *dishDomain.Dishes
 	var err fcerr.FCErr
 	fmt.Println("Running the getDishesExpiresBy function")
 
-	dishes, err = service.GetExpiredByDate(rUser, checkDateStr)
+	dishes, err = service.GetExpiredByDate(ctx, rUser, checkDateStr)
 
 	if err != nil {
 		fmt.Println("could not handle the get expired dishes handle function")
-		return nil, fcerr.NewInternalServerError("unsuccessful at service.GetExpiredBy")
+		return nil, err
 	}
 
 	fmt.Println("The length of the list we got is:", len(*dishes))
@@ -422,13 +712,13 @@ func getDishesExpiredBy(rUser *userDomain.User, checkDateStr string, service dis
 }
 
 //createDish adds a dish to the list
-func createDish(requestingUser *userDomain.User, aR apiRequest, service dish.Service) fcerr.FCErr {
+func createDish(ctx context.Context, requestingUser *userDomain.User, aR apiRequest, service dish.Service) fcerr.FCErr {
 
 	fmt.Println("running the createDish() non-handler function")
 
 	storageID, err := strconv.Atoi(aR.StorageID)
 	if err != nil {
-		return fcerr.NewBadRequestError("Error when creating the dish.")
+		return fcerr.NewValidationError("The storageID must be a number", fcerr.FieldDetail{Field: "storageID", Message: "not a number: " + aR.StorageID})
 	}
 
 	newDish := &dishDomain.Dish{
@@ -441,23 +731,28 @@ func createDish(requestingUser *userDomain.User, aR apiRequest, service dish.Ser
 	}
 	expireWindow := aR.ExpireWindow
 
-	resultingDish, err := service.Create(requestingUser, newDish, expireWindow)
+	resultingDish, fcErr := service.Create(ctx, requestingUser, newDish, expireWindow)
 
-	if err != nil || resultingDish.DishID == 0 {
-		return fcerr.NewInternalServerError("seems to have brokne")
+	if fcErr != nil {
+		return fcErr
+	}
+	if resultingDish.DishID == 0 {
+		return fcerr.NewInternalServerError("The dish was not given an id when it was created")
 	}
 	return nil
 
 }
 
 //updateDish(requestingUser *userDomain.User, aR apiRequest, service dish.Service) takes a requesting user, and an API request along with the dish service to update the dish to the values contained in the apirequest
-func updateDish(requestingUser *userDomain.User, pID int, aR apiRequest, service dish.Service) fcerr.FCErr {
+func updateDish(ctx context.Context, requestingUser *userDomain.User, pID int, aR apiRequest, service dish.Service) fcerr.FCErr {
 	fmt.Println("running the updateDish() non-handler function")
 	fmt.Println("Got this ar storageID:" + aR.StorageID)
 
-	marshaledExistingDish, err := getDishByID(requestingUser, pID, service)
-	if err != nil {
-		return fcerr.NewBadRequestError("Can not update a dish that does not exist.")
+	marshaledExistingDish, err := getDishByID(ctx, requestingUser, pID, service)
+	if errors.Is(err, fcerr.ErrNotFound) {
+		return fcerr.Wrap(err, "Can not update a dish that does not exist.", http.StatusNotFound)
+	} else if err != nil {
+		return err
 	}
 
 	var existingDish dishDomain.Dish
@@ -476,26 +771,26 @@ func updateDish(requestingUser *userDomain.User, pID int, aR apiRequest, service
 	if aR.StorageID != "" {
 		storageID, err := strconv.Atoi(aR.StorageID)
 		if err != nil {
-			return fcerr.NewBadRequestError("Could not recognize the Storage ID value")
+			return fcerr.NewValidationError("Could not recognize the Storage ID value", fcerr.FieldDetail{Field: "storageID", Message: "not a number: " + aR.StorageID})
 		}
 		newDish.StorageID = storageID
 	}
 
 	//aR.ExpireWindow could be "" if the user has not changed it - the Service will handle this case as it parses
-	err2 := service.Update(requestingUser, &newDish, aR.ExpireWindow)
+	err2 := service.Update(ctx, requestingUser, &newDish, aR.ExpireWindow)
 
 	if err2 != nil {
-		return fcerr.NewInternalServerError("Error when updating the dish")
+		return err2
 	}
 	return nil
 }
 
-//deleteDish takes a requesting user, and a dish ID along with the dish service to delete the dish with the personal id given
-func deleteDish(requestingUser *userDomain.User, dishID int, service dish.Service) fcerr.FCErr {
+//deleteDish takes a requesting user, and a dish ID and outcome along with the dish service to delete the dish with the personal id given
+func deleteDish(ctx context.Context, requestingUser *userDomain.User, dishID int, outcome string, service dish.Service) fcerr.FCErr {
 	fmt.Println("running the updateDish() non-handler function")
-	err := service.Delete(requestingUser, dishID)
+	err := service.Delete(ctx, requestingUser, dishID, outcome)
 	if err != nil {
-		return fcerr.NewInternalServerError("Error when deleting the dish")
+		return err
 	}
 	return nil
 }
@@ -503,218 +798,215 @@ func deleteDish(requestingUser *userDomain.User, dishID int, service dish.Servic
 //---------------------------------------------------------------------------------------------------------------------------------------------------
 
 //****Storage Handler and helpers********************************************************************************************************************
-func (h *handler) HandleStorageRequest(c *gin.Context) {
-	var aR apiRequest
-
-	if err := c.ShouldBindJSON(&aR); err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
-	}
 
-	if aR.AlexaUserID == "" && aR.AccessToken == "" {
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
-	}
-	requestUser, err := ValidateUser(h, aR)
-	if err != nil {
-		c.AbortWithStatus(http.StatusForbidden)
+//HandleStorageRequest is the legacy POST /storage/storage/:p_id route, which picks the action from fcapiRequestType in the body.
+func (h *handler) HandleStorageRequest(c *gin.Context) {
+	aR, ok := h.legacyRequest(c)
+	if !ok {
 		return
 	}
 
-	storageIDParam := c.Param("storage_id")
-
 	switch aR.RequestType {
-
 	case "GET":
-		if storageIDParam != "" {
+		if c.Param("p_id") != "" {
 			fmt.Println("GOT THE NORMAL GETStorage ROUTE!!!")
-			storageID, err := strconv.Atoi(storageIDParam)
-			if err != nil {
-				c.AbortWithStatus(http.StatusInternalServerError)
-				return
-			}
-
-			marshaledStorage, err := getStorageByID(storageID, requestUser, h.storageService)
-			if err != nil {
-				c.AbortWithStatus(http.StatusInternalServerError)
-				return
-			}
-
-			c.JSON(200, gin.H{
-				"message": marshaledStorage,
-			})
+			h.GetStorage(c)
 			return
 		}
 		fmt.Println("got the getStorage route!!!")
-		marshaledStorageList, err := getStorage(requestUser, h.storageService)
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-
-		c.JSON(200, gin.H{
-			"message": marshaledStorageList,
-		})
-		return
-
+		h.ListStorages(c)
 	case "POST":
-		fmt.Println("doing the createStorage() within the storage request handler")
-		err := createStorage(requestUser, aR, h.storageService)
-		if err != nil {
-			c.AbortWithStatus(err.Status())
-			return
-		}
-		fmt.Println("Successfully added the storage to the database!")
-		c.JSON(200, gin.H{
-			"message": []byte("Your storage unit has been added to the database."),
-		})
-		return
-
+		h.CreateStorage(c)
 	case "PATCH":
-		storageID, err := strconv.Atoi(storageIDParam)
-		if err != nil {
-			c.AbortWithStatus(http.StatusBadRequest)
-			return
-		}
-		fmt.Println("got the storage update method for storage number:", storageID)
-		err2 := updateStorage(requestUser, aR, h.storageService)
-		if err2 != nil {
-			fmt.Println("Got an error when doing the update storage route")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
+		h.UpdateStorage(c)
 	case "DELETE":
-		storageID, err := strconv.Atoi(storageIDParam)
-		if err != nil {
-			c.AbortWithStatus(http.StatusBadRequest)
-			return
-		}
-		fmt.Println("got the storage delete method for storage number:", storageID)
-		err2 := deleteStorage(requestUser, storageID, h.storageService)
-		if err2 != nil {
-			fmt.Println("Got an error when doing the update storage route")
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-
+		h.DeleteStorage(c)
 	default:
-		c.AbortWithStatus(http.StatusNotImplemented)
-
+		abortWithError(c, notImplemented(aR.RequestType))
 	}
-
 }
 
+//GetStorageDishes is the legacy POST /storage/storage/:p_id/dishes route.
 func (h *handler) GetStorageDishes(c *gin.Context) {
-	var aR apiRequest
-
-	if err := c.ShouldBindJSON(&aR); err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
+	aR, ok := h.legacyRequest(c)
+	if !ok {
 		return
 	}
 
-	if aR.AlexaUserID == "" && aR.AccessToken == "" {
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
-	}
-	requestUser, err := ValidateUser(h, aR)
-	if err != nil {
-		c.AbortWithStatus(http.StatusForbidden)
-		return
-	}
-
-	storageIDParam := c.Param("p_id")
-
 	switch aR.RequestType {
-
 	case "GET":
-		if storageIDParam == "" {
-			c.AbortWithStatus(http.StatusBadRequest)
-			return
-		}
-
-		fmt.Println("GOT THE get storage dishes route for storage id: " + storageIDParam)
-		storageID, err := strconv.Atoi(storageIDParam)
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-
-		marshaledDishList, err := getStorageDishes(requestUser, storageID, h.storageService)
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
+		if c.Param("p_id") == "" {
+			abortWithError(c, fcerr.NewValidationError("A GET needs the id in the path", fcerr.FieldDetail{Field: "id", Message: "missing"}))
 			return
 		}
+		fmt.Println("GOT THE get storage dishes route for storage id: " + c.Param("p_id"))
+		h.ListStorageDishes(c)
+	default:
+		abortWithError(c, notImplemented(aR.RequestType))
+	}
+}
 
-		c.JSON(200, gin.H{
-			"message": marshaledDishList,
-		})
+//GetStorages is the legacy POST /storage route.
+func (h *handler) GetStorages(c *gin.Context) {
+	aR, ok := h.legacyRequest(c)
+	if !ok {
 		return
+	}
 
+	switch aR.RequestType {
+	case "GET":
+		fmt.Println("GOT THE GETStorages ROUTE!!!")
+		h.ListStorages(c)
 	default:
-		c.AbortWithStatus(http.StatusNotImplemented)
+		abortWithError(c, notImplemented(aR.RequestType))
+	}
+}
 
+//ListStorages is GET /v1/storage - every storage unit the requesting user has.
+func (h *handler) ListStorages(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
 	}
 
+	marshaledStorages, err := getStorage(c.Request.Context(), requestUser, h.storageService)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, marshaledStorages)
 }
 
-func (h *handler) GetStorages(c *gin.Context) {
-	var aR apiRequest
+//GetStorage is GET /v1/storage/:id - one of the requesting user's storage units, by its personal id.
+func (h *handler) GetStorage(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	storageID, ok := personalID(c)
+	if !ok {
+		return
+	}
 
-	if err := c.ShouldBindJSON(&aR); err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
+	marshaledStorage, err := getStorageByID(c.Request.Context(), storageID, requestUser, h.storageService)
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
+	respond(c, http.StatusOK, marshaledStorage)
+}
 
-	if aR.AlexaUserID == "" && aR.AccessToken == "" {
-		c.AbortWithStatus(http.StatusUnauthorized)
+//ListStorageDishes is GET /v1/storage/:id/dishes - the dishes in one of the requesting user's storage units, narrowed
+//down, sorted and paged the same as ListDishes.
+func (h *handler) ListStorageDishes(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
 		return
 	}
-	requestUser, err := ValidateUser(h, aR)
-	if err != nil {
-		c.AbortWithStatus(http.StatusForbidden)
+	storageID, ok := personalID(c)
+	if !ok {
+		return
+	}
+	q, ok := dishQuery(c)
+	if !ok {
 		return
 	}
+	q.StorageID = &storageID
 
-	switch aR.RequestType {
+	ctx := c.Request.Context()
+	if _, err := h.storageService.GetByID(ctx, requestUser, storageID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	marshaledDishList, next, err := getDishes(ctx, requestUser, q, h.dishService)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if next != "" {
+		c.Header(NextCursorHeader, next)
+	}
+	respond(c, http.StatusOK, marshaledDishList)
+}
 
-	case "GET":
-		fmt.Println("GOT THE GETStorages ROUTE!!!")
+//CreateStorage is POST /v1/storage, with the new storage unit in the body.
+func (h *handler) CreateStorage(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
 
-		marshaledStorages, err := getStorage(requestUser, h.storageService)
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
+	fmt.Println("doing the createStorage() within the storage request handler")
+	err := createStorage(c.Request.Context(), requestUser, aR, h.storageService)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	fmt.Println("Successfully added the storage to the database!")
+	respondMessage(c, http.StatusCreated, "Your storage unit has been added to the database.")
+}
 
-		c.JSON(200, gin.H{
-			"message": marshaledStorages,
-		})
+//UpdateStorage is PATCH /v1/storage/:id, with the fields to change in the body.
+func (h *handler) UpdateStorage(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	storageID, ok := personalID(c)
+	if !ok {
 		return
+	}
 
-	default:
-		c.AbortWithStatus(http.StatusNotImplemented)
+	fmt.Println("got the storage update method for storage number:", storageID)
+	err := updateStorage(c.Request.Context(), requestUser, storageID, aR, h.storageService)
+	if err != nil {
+		fmt.Println("Got an error when doing the update storage route")
+		abortWithError(c, err)
+		return
+	}
+	respondMessage(c, http.StatusOK, "Your storage unit has been updated in the database.")
+}
 
+//DeleteStorage is DELETE /v1/storage/:id. Only an empty storage unit can be deleted.
+func (h *handler) DeleteStorage(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	storageID, ok := personalID(c)
+	if !ok {
+		return
 	}
 
+	fmt.Println("got the storage delete method for storage number:", storageID)
+	err := deleteStorage(c.Request.Context(), requestUser, storageID, h.storageService)
+	if err != nil {
+		fmt.Println("Got an error when doing the delete storage route")
+		abortWithError(c, err)
+		return
+	}
+	respondMessage(c, http.StatusOK, "Your storage unit has been deleted from the database.")
 }
 
 //getStorage gets all the storage units the requesting user has
-func getStorage(requestUser *userDomain.User, service storage.Service) ([]byte, fcerr.FCErr) {
+func getStorage(ctx context.Context, requestUser *userDomain.User, service storage.Service) ([]byte, fcerr.FCErr) {
 	var storageList *storageDomain.Storages
 	var err fcerr.FCErr
 	fmt.Println("Running the getStorage function")
 
 	//accessToken := aR.AccessToken
 
-	storageList, err = service.GetAll(requestUser)
+	storageList, err = service.GetAll(ctx, requestUser)
 
 	if err != nil {
 		//fcerr := fcerr.NewInternalServerError("could not handle the GetStorage route")
 		fmt.Println("could not handle the GetStorage route")
-		return nil, fcerr.NewInternalServerError("unsuccessful at service.GetAll")
+		return nil, err
 	}
 
-	fmt.Println("I think we got some storage units!!! The first of which is:", (*storageList)[0])
+	if len(*storageList) > 0 {
+		fmt.Println("I think we got some storage units!!! The first of which is:", (*storageList)[0])
+	}
 
 	marshaledStorageList, merr := json.Marshal(storageList)
 	if merr != nil {
@@ -724,17 +1016,17 @@ func getStorage(requestUser *userDomain.User, service storage.Service) ([]byte,
 }
 
 //getStorageByID gets a particular storage unit the requesting user has
-func getStorageByID(pID int, requestingUser *userDomain.User, service storage.Service) ([]byte, fcerr.FCErr) {
+func getStorageByID(ctx context.Context, pID int, requestingUser *userDomain.User, service storage.Service) ([]byte, fcerr.FCErr) {
 	var storage *storageDomain.Storage
 	var err fcerr.FCErr
 	fmt.Println("running non-gin getStorageByID func")
 
-	storage, err = service.GetByID(requestingUser, pID)
+	storage, err = service.GetByID(ctx, requestingUser, pID)
 
 	if err != nil {
 		//fcerr := fcerr.NewInternalServerError("could not handle the GetStorageByID route")
 		fmt.Println("could not handle the GetStorageByID route")
-		return nil, fcerr.NewInternalServerError("unsuccessful at service.GetAll")
+		return nil, err
 	}
 
 	fmt.Println("I think we got a storage unit!!! It is:", storage.Title)
@@ -746,34 +1038,8 @@ func getStorageByID(pID int, requestingUser *userDomain.User, service storage.Se
 	return marshaledStorage, nil
 }
 
-//getStorageDishes(requestingUser *userDomain.User, pID int, service storage.Service) gets all the dishes the requsting user has in the given storage unit
-func getStorageDishes(requestingUser *userDomain.User, pID int, storageService storage.Service) ([]byte, fcerr.FCErr) {
-	var dishes *dishDomain.Dishes
-	var err fcerr.FCErr
-	fmt.Println("running non-gin getStorageDishes func")
-
-	dishes, err = storageService.GetDishesByID(requestingUser, pID)
-
-	if err != nil {
-		//fcerr := fcerr.NewInternalServerError("could not handle the GetStorageByID route")
-		fmt.Println("could not handle the getStorageDishes non-gin func")
-		return nil, fcerr.NewInternalServerError("unsuccessful at storageService.GetDishesByID")
-	}
-
-	fmt.Println("The length of the list we got is:", len(*dishes))
-	if len(*dishes) > 0 {
-		fmt.Println("I think we got some dishes!!! The first of which is:", (*dishes)[0])
-	}
-
-	marshaledDishes, merr := json.Marshal(dishes)
-	if merr != nil {
-		return nil, fcerr.NewInternalServerError("JSON Error - Could not marshal the dishes")
-	}
-	return marshaledDishes, nil
-}
-
 //createStorage adds a storage unit to the list
-func createStorage(requestingUser *userDomain.User, aR apiRequest, service storage.Service) fcerr.FCErr {
+func createStorage(ctx context.Context, requestingUser *userDomain.User, aR apiRequest, service storage.Service) fcerr.FCErr {
 
 	fmt.Println("running the createStorage() non-handler function")
 
@@ -782,44 +1048,54 @@ func createStorage(requestingUser *userDomain.User, aR apiRequest, service stora
 		Description: aR.Description,
 	}
 
-	resultingStorage, err := service.Create(requestingUser, newStorage)
+	resultingStorage, err := service.Create(ctx, requestingUser, newStorage)
 
-	if err != nil || resultingStorage.StorageID == 0 {
-		return fcerr.NewInternalServerError("seems to have brokne")
+	if err != nil {
+		return err
+	}
+	if resultingStorage.StorageID == 0 {
+		return fcerr.NewInternalServerError("The storage unit was not given an id when it was created")
 	}
 	return nil
 
 }
 
-//updateStorage takes a requesting user, and an API request along with the storage service to update the storage unit to the values contained in the apirequest
-func updateStorage(requestingUser *userDomain.User, aR apiRequest, service storage.Service) fcerr.FCErr {
+//updateStorage takes a requesting user, the personal id of one of their storage units, and an API request along with the storage service
+//to update the storage unit to the values contained in the apirequest
+func updateStorage(ctx context.Context, requestingUser *userDomain.User, pID int, aR apiRequest, service storage.Service) fcerr.FCErr {
 	fmt.Println("running the updateStorage() function")
 
-	storageID, err := strconv.Atoi(aR.StorageID)
-	if err != nil {
-		return fcerr.NewBadRequestError("Error when creating the storage unit.")
+	existingStorage, err := service.GetByID(ctx, requestingUser, pID)
+	if errors.Is(err, fcerr.ErrNotFound) {
+		return fcerr.Wrap(err, "Can not update a storage unit that does not exist.", http.StatusNotFound)
+	} else if err != nil {
+		return err
 	}
 
-	newStorage := &storageDomain.Storage{
-		StorageID:   storageID,
-		Title:       aR.Title,
-		Description: aR.Description,
+	newStorage := *existingStorage
+
+	if aR.Title != "" {
+		newStorage.Title = aR.Title
 	}
 
-	err2 := service.Update(requestingUser, newStorage)
+	if aR.Description != "" {
+		newStorage.Description = aR.Description
+	}
+
+	err2 := service.Update(ctx, requestingUser, &newStorage)
 
 	if err2 != nil {
-		return fcerr.NewInternalServerError("Error when updating the storage unit")
+		return err2
 	}
 	return nil
 }
 
 //deleteStorage takes a requesting user, and a storage ID along with the storage service to delete the storage unit with the personal id given
-func deleteStorage(requestingUser *userDomain.User, storageID int, service storage.Service) fcerr.FCErr {
+func deleteStorage(ctx context.Context, requestingUser *userDomain.User, storageID int, service storage.Service) fcerr.FCErr {
 	fmt.Println("running the deleteStorage() function")
-	err := service.Delete(requestingUser, storageID)
+	err := service.Delete(ctx, requestingUser, storageID)
 	if err != nil {
-		return fcerr.NewInternalServerError("Error when deleting the storage unit")
+		return err
 	}
 	return nil
 }
@@ -827,43 +1103,157 @@ func deleteStorage(requestingUser *userDomain.User, storageID int, service stora
 //*****************************************************************************************************************************************************
 
 //^^^^^^^^^Users Handler and helpers^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^
+
+//HandleUsersRequest is the legacy POST /users route, which picks the action from fcapiRequestType in the body.
 func (h *handler) HandleUsersRequest(c *gin.Context) {
-	var aR apiRequest
+	aR, ok := h.legacyRequest(c)
+	if !ok {
+		return
+	}
 
-	if err := c.ShouldBindJSON(&aR); err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
+	switch aR.RequestType {
+	case "GET":
+		h.GetUser(c)
+	case "PATCH":
+		h.UpdateUser(c)
+	case "DELETE":
+		h.DeleteUser(c)
+	default:
+		abortWithError(c, notImplemented(aR.RequestType))
+	}
+}
+
+//userProfile is what a user sees of themselves - everything but their tokens.
+type userProfile struct {
+	UserID           int        `json:"UserID"`
+	Email            string     `json:"Email"`
+	FirstName        string     `json:"FirstName"`
+	LastName         string     `json:"LastName"`
+	FullName         string     `json:"FullName"`
+	CreatedDate      string     `json:"TimeCreated"`
+	TimeZone         string     `json:"TimeZone"`
+	NotifyExpiring   bool       `json:"NotifyExpiring"`
+	NotifyDaysBefore int        `json:"NotifyDaysBefore"`
+	QuietStart       string     `json:"QuietStart"`
+	QuietEnd         string     `json:"QuietEnd"`
+	DeletionDate     *time.Time `json:"TimeDeletion,omitempty"`
+}
+
+//respondProfile writes the user's profile with the given status.
+func respondProfile(c *gin.Context, status int, u *userDomain.User) {
+	profile := userProfile{UserID: u.UserID, Email: u.Email, FirstName: u.FirstName, LastName: u.LastName,
+		FullName: u.FullName, CreatedDate: u.CreatedDate, TimeZone: u.TimeZone, NotifyExpiring: u.NotifyExpiring,
+		NotifyDaysBefore: u.NoticeDays(), QuietStart: u.QuietStart, QuietEnd: u.QuietEnd}
+	if u.DeletionPending() {
+		profile.DeletionDate = &u.DeletionDate
+	}
+	marshaled, err := json.Marshal(profile)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the user"))
 		return
 	}
+	respond(c, status, marshaled)
+}
 
-	if aR.AlexaUserID == "" && aR.AccessToken == "" {
-		c.AbortWithStatus(http.StatusUnauthorized)
+//GetUser is GET /v1/users/me.
+func (h *handler) GetUser(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
 		return
 	}
-	requestUser, err := ValidateUser(h, aR)
-	if err != nil {
-		c.AbortWithStatus(http.StatusForbidden)
+	respondProfile(c, http.StatusOK, requestUser)
+}
+
+//UpdateUser is PATCH /v1/users/me. It changes the user's names, time zone and notification preferences, quiet hours
+//included - whatever the body has.
+func (h *handler) UpdateUser(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
 		return
 	}
 
-	switch aR.RequestType {
+	fmt.Println("doing the updateUsers() within the users request handler for this user:", requestUser.Email)
+	updated, fcErr := h.userService.UpdateProfile(c.Request.Context(), requestUser.UserID, userDomain.Profile{
+		FirstName: aR.FirstName, LastName: aR.LastName, FullName: aR.FullName, TimeZone: aR.TimeZone,
+		NotifyExpiring: aR.NotifyExpiring, NotifyDaysBefore: aR.NotifyDaysBefore,
+		QuietStart: aR.QuietStart, QuietEnd: aR.QuietEnd})
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	respondProfile(c, http.StatusOK, updated)
+}
 
-	case "PATCH":
-		fmt.Println("doing the updateUsers() within the users request handler for this user:", requestUser.Email)
-		c.JSON(200, gin.H{
-			"message": []byte("Your user has been updated in the database."),
-		})
+//DeleteUser is DELETE /v1/users/me. With a grace period - ?grace=P30D, or "grace" in a legacy body - the account is
+//erased once it is over, and until then the user can still export their data or change their mind. Without one it is
+//erased now, along with their sessions, linked accounts, and the food in their household if nobody else shares it.
+func (h *handler) DeleteUser(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
 		return
-	case "DELETE":
-		fmt.Println("doing the deleteUsers() within the users request handler for this user:", requestUser.Email)
-		c.JSON(200, gin.H{
-			"message": []byte("Your user has been removed from the database."),
-		})
+	}
+
+	grace := aR.Grace
+	if grace == "" {
+		grace = c.Query("grace")
+	}
+	if grace != "" {
+		window, fcErr := duration.Parse(grace)
+		if fcErr != nil {
+			abortWithError(c, fcerr.NewValidationError(fcErr.Message(), fcerr.FieldDetail{Field: "grace", Message: "not a duration: " + grace}))
+			return
+		}
+		scheduled, fcErr := h.userService.ScheduleDeletion(c.Request.Context(), requestUser.UserID, window)
+		if fcErr != nil {
+			abortWithError(c, fcErr)
+			return
+		}
+		respondProfile(c, http.StatusAccepted, scheduled)
+		return
+	}
+
+	fmt.Println("doing the deleteUsers() within the users request handler for this user:", requestUser.Email)
+	if fcErr := h.userService.Erase(c.Request.Context(), requestUser.UserID); fcErr != nil {
+		abortWithError(c, fcErr)
 		return
+	}
+	respondMessage(c, http.StatusOK, "Your user has been removed from the database.")
+}
 
-	default:
-		c.AbortWithStatus(http.StatusNotImplemented)
+//CancelUserDeletion is DELETE /v1/users/me/deletion - the user keeps their account after asking for it to be erased.
+func (h *handler) CancelUserDeletion(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
 
+	kept, fcErr := h.userService.CancelDeletion(c.Request.Context(), requestUser.UserID)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	respondProfile(c, http.StatusOK, kept)
+}
+
+//ExportUser is GET /v1/users/me/export, everything the API keeps about the user as one JSON download.
+func (h *handler) ExportUser(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	exported, fcErr := h.userService.Export(c.Request.Context(), requestUser.UserID)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(exported)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the export"))
+		return
 	}
+	c.Header("Content-Disposition", `attachment; filename="freshness-countdown-export.json"`)
+	respond(c, http.StatusOK, marshaled)
 }
 
 //^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^
@@ -885,123 +1275,251 @@ func (h *handler) Pong(c *gin.Context) {
 
 //@@@@@@App Handlers@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
 
-//Login displays a simple link that takes a user to the external google sign in flow.
+//Login displays a link for each identity provider that takes a user to its sign in flow.
 func (h *handler) Login(c *gin.Context) {
 	fmt.Println("Running the Login function")
-	siteData := []byte("<a href=/oauthlogin> Login with Google </a>")
-	c.Data(200, "text/html", siteData)
+	var siteData strings.Builder
+	for _, provider := range h.providers.All() {
+		siteData.WriteString("<a href=/oauthlogin?provider=" + url.QueryEscape(provider.Name()) + "> Login with " +
+			html.EscapeString(provider.DisplayName()) + " </a><br>")
+	}
+	c.Data(200, "text/html", []byte(siteData.String()))
 }
 
-//Oauthlogin takes a user to the external google sign in flow.
+//Oauthlogin takes a user to the sign in flow of the identity provider named by ?provider=, or the default one.
+//The attempt's state, nonce and PKCE verifier stay on the server under a random id the browser gets in a cookie.
+//?return= is a path on this site to send the browser to once signed in.
 func (h *handler) Oauthlogin(c *gin.Context) {
 	fmt.Println("Running the Oauthlogin function")
-	oauthstate := numGenerator()
-	url := getOAuthURL(h.oauthConfig, oauthstate)
-	cookie := &http.Cookie{
-		Name:   "oauthstate",
-		Value:  oauthstate,
-		MaxAge: 120,
-		Secure: true,
+	provider, ok := h.providers.Get(c.Query("provider"))
+	if !ok {
+		abortWithError(c, fcerr.NewNotFoundError("There is no identity provider called "+c.Query("provider")))
+		return
+	}
+	returnURL, ok := safeReturnURL(c.Query("return"))
+	if !ok {
+		abortWithError(c, fcerr.NewBadRequestError("The return URL has to be a path on this site"))
+		return
 	}
-	http.SetCookie(c.Writer, cookie)
-	c.Redirect(http.StatusTemporaryRedirect, url)
-}
 
-//getOAuthURL takes an oauthConfig (real or mocked) and does the .AuthCodeURL() with it.
-func getOAuthURL(oC oauthConfig, state string) string {
-	return oC.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	h.startLogin(c, provider, loginAttempt{returnURL: returnURL})
 }
 
-//getOAuthToken takes an oauthConfig (real or mocked) and does the .Exchange() with it.
-func getOAuthToken(oC oauthConfig, c *gin.Context, code string) (*oauth2.Token, error) {
-	token, err := oC.Exchange(c, code)
-	return token, err
+//startLogin remembers the attempt, with a new state, nonce and PKCE verifier, and sends the browser to the provider.
+func (h *handler) startLogin(c *gin.Context, provider *oidc.Provider, attempt loginAttempt) {
+	attempt.provider = provider.Name()
+	attempt.state = randomToken()
+	attempt.nonce = randomToken()
+	attempt.codeVerifier = randomToken()
+	loginID := h.logins.put(attempt)
+
+	options := append([]oauth2.AuthCodeOption{oauth2.AccessTypeOffline, oauth2.SetAuthURLParam("nonce", attempt.nonce)},
+		pkceChallenge(attempt.codeVerifier)...)
+	authURL := provider.AuthCodeURL(attempt.state, options...)
+	setLoginCookie(c, loginID, int(loginAttemptTTL/time.Second))
+	c.Redirect(http.StatusTemporaryRedirect, authURL)
+}
 
+//setLoginCookie sets the login attempt cookie - Lax, so it comes back on the provider's redirect to /success.
+//A maxAge below 0 clears it.
+func setLoginCookie(c *gin.Context, loginID string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     loginCookie,
+		Value:    loginID,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
 }
 
 //LoginSuccess is where the Oauth provider routes to after successfully authenticating a user
 func (h *handler) LoginSuccess(c *gin.Context) {
 
-	receivedCookie, err := c.Cookie("oauthstate")
+	loginID, err := c.Cookie(loginCookie)
 	if err != nil {
 		fmt.Println("got an error when retrieving the cookie during loginSuccess()")
-		c.AbortWithStatus(http.StatusInternalServerError)
+		abortWithError(c, fcerr.NewBadRequestError("The login attempt has no login cookie, it may have expired"))
+		return
+	}
+	//an attempt is only good for one try, whatever happens to it
+	attempt, ok := h.logins.take(loginID)
+	setLoginCookie(c, "", -1)
+	if !ok {
+		abortWithError(c, fcerr.NewBadRequestError("The login attempt has expired or was already used, please log in again"))
 		return
 	}
-	fmt.Println("In LoginSuccess - got the cookie:", receivedCookie)
 
 	receivedState := c.Request.FormValue("state")
-	if receivedState != receivedCookie {
-		fmt.Println("receivedState:", receivedState, "did not equal oauthstate:", oauthstate)
-		c.AbortWithStatus(http.StatusForbidden)
+	if subtle.ConstantTimeCompare([]byte(receivedState), []byte(attempt.state)) != 1 {
+		fmt.Println("the state the provider sent back did not match the login attempt")
+		abortWithError(c, fcerr.NewForbiddenError("The login state did not match"))
+		return
+	}
+	if providerError := c.Request.FormValue("error"); providerError != "" {
+		if attempt.link != nil {
+			c.Redirect(http.StatusFound, linkRedirect(attempt.link, url.Values{"error": {"access_denied"}}))
+			return
+		}
+		abortWithError(c, fcerr.NewUnauthorizedError("The sign in did not finish: "+providerError))
 		return
 	}
+	provider, ok := h.providers.Get(attempt.provider)
+	if !ok {
+		abortWithError(c, fcerr.NewBadRequestError("The login attempt is for an identity provider that isn't set up"))
+		return
+	}
+
 	code := c.Request.FormValue("code")
-	token, err := getOAuthToken(h.oauthConfig, c, code)
+	token, err := provider.Exchange(c.Request.Context(), code, oauth2.SetAuthURLParam("code_verifier", attempt.codeVerifier))
 	if err != nil {
 		fmt.Println("error when exchanging the token")
-		c.AbortWithStatus(http.StatusInternalServerError)
+		abortWithError(c, fcerr.Wrap(err, "Could not exchange the login code for a token", http.StatusInternalServerError))
 		return
 	}
 
-	response, err := http.Get("https://openidconnect.googleapis.com/v1/userinfo?access_token=" + token.AccessToken)
-	if err != nil {
-		fmt.Println("error when getting the userinfo with the access token")
-		c.AbortWithStatus(http.StatusInternalServerError)
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		abortWithError(c, fcerr.NewFCErr(provider.DisplayName()+" did not send an ID token with the login", http.StatusBadGateway))
 		return
 	}
-
-	defer response.Body.Close()
-
-	contents, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
+	idToken, fcErr := provider.VerifyIDToken(c.Request.Context(), rawIDToken)
+	if fcErr != nil {
+		fmt.Println("the ID token from", provider.Name(), "did not verify:", fcErr.Message())
+		abortWithError(c, fcErr)
 		return
 	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(attempt.nonce)) != 1 {
+		abortWithError(c, fcerr.NewUnauthorizedError("The ID token is not for this login attempt"))
+		return
+	}
+
+	claims := &idToken.Claims
+	if claims.Email == "" {
+		//some providers only give the email address from their userinfo endpoint
+		info, fcErr := provider.UserInfo(c.Request.Context(), token.AccessToken)
+		if fcErr != nil {
+			abortWithError(c, fcErr)
+			return
+		}
+		if info.Subject != idToken.Subject {
+			abortWithError(c, fcerr.NewForbiddenError("The user info from "+provider.DisplayName()+" is for a different user than the ID token"))
+			return
+		}
+		claims = info
+	}
 
-	json.Unmarshal(contents, &currentUser)
-	fmt.Println("Here is the current User:", currentUser)
+	loginUser := claims.OauthUser()
+	fmt.Println("Here is the user logging in:", loginUser)
 
-	if currentUser.VerifiedEmail == false {
-		fmt.Println("current user.VerifiedEmail is false. CurrentUser:", currentUser)
-		c.AbortWithStatus(http.StatusForbidden)
+	if loginUser.VerifiedEmail == false {
+		fmt.Println("loginUser.VerifiedEmail is false. loginUser:", loginUser)
+		abortWithError(c, fcerr.NewForbiddenError("Please verify your email address with "+provider.DisplayName()+" before logging in"))
 		return
 	}
 
-	fmt.Println("Got a verified user!!!!!!", currentUser)
+	fmt.Println("Got a verified user!!!!!!", loginUser)
 
-	dbUser, err := h.userService.GetByEmail(currentUser.Email)
-	if err != nil {
+	dbUser, fcErr := h.userService.GetByEmail(c.Request.Context(), loginUser.Email)
+	if fcErr != nil && !errors.Is(fcErr, fcerr.ErrNotFound) {
 		fmt.Println("was not able to check the database for the user on login success")
-		c.AbortWithStatus(http.StatusInternalServerError)
+		abortWithError(c, fcErr)
 		return
-	} else if dbUser.UserID <= 0 {
+	} else if fcErr != nil || dbUser.UserID <= 0 {
 		fmt.Println("loginSuccess could not find this user in the database! We should add them!!")
-		receivedUser, err := h.userService.Create(currentUser, token.AccessToken, token.RefreshToken)
-		if err != nil {
+		receivedUser, fcErr := h.userService.Create(c.Request.Context(), loginUser, token.AccessToken, token.RefreshToken)
+		if fcErr != nil {
 			fmt.Println("Was not successful in adding a new user to the database!")
-			c.AbortWithStatus(http.StatusInternalServerError)
+			abortWithError(c, fcErr)
 			return
 
 		}
 		fmt.Println("we just put a new user in the database!! with database user id:", receivedUser.UserID)
+		dbUser = receivedUser
+
+	} else {
+		fmt.Println("We already have this user!!! database user id:", dbUser)
+	}
 
+	if !dbUser.IsActive() {
+		fmt.Println("deactivated user", dbUser.UserID, "tried to log in")
+		abortWithError(c, fcerr.NewForbiddenError(session.DeactivatedMessage))
+		return
 	}
-	fmt.Println("We already have this user!!! database user id:", dbUser)
 
-	successData := []byte("<h1>Success!</h1>")
-	c.Data(200, "text/html", successData)
+	//keep the tokens from this login, so an existing user's expired access token can be refreshed later
+	dbUser, fcErr = h.userService.SaveTokens(c.Request.Context(), *dbUser, provider.Name(), token)
+	if fcErr != nil {
+		fmt.Println("was not able to save the user's tokens on login success")
+		abortWithError(c, fcErr)
+		return
+	}
 
+	if attempt.link != nil {
+		code := h.linkService.IssueCode(dbUser, attempt.link.clientID, attempt.link.redirectURI)
+		c.Redirect(http.StatusFound, linkRedirect(attempt.link, url.Values{"code": {code}}))
+		return
+	}
+	respondSession(c, h.sessionService, dbUser, attempt.returnURL)
 }
 
-//@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
+//sessionResponse is the JSON a client gets back after logging in.
+type sessionResponse struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"tokenType"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	ReturnTo  string    `json:"returnTo,omitempty"`
+}
+
+//respondSession starts a session for the user who just logged in and hands them its bearer token - as JSON
+//when the client asks for it, otherwise as the success page a browser lands on. A browser that started the login with a
+//return URL is sent there instead, with the token in the fragment so it isn't sent on to the server or its logs.
+func respondSession(c *gin.Context, sessions session.Service, loginUser *userDomain.User, returnURL string) {
+	token, created, fcErr := sessions.Create(c.Request.Context(), loginUser)
+	if fcErr != nil {
+		fmt.Println("could not start a session on login success:", fcErr.Message())
+		abortWithError(c, fcErr)
+		return
+	}
+
+	switch c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) {
+	case gin.MIMEJSON:
+		c.JSON(http.StatusOK, sessionResponse{Token: token, TokenType: "Bearer", ExpiresAt: created.ExpireDate, ReturnTo: returnURL})
+	default:
+		if returnURL != "" {
+			fragment := url.Values{"token": {token}, "expiresAt": {created.ExpireDate.Format(time.RFC3339)}}
+			c.Redirect(http.StatusSeeOther, returnURL+"#"+fragment.Encode())
+			return
+		}
+		successData := []byte("<h1>Success!</h1><p>Your API token, good until " + created.ExpireDate.Format(time.RFC1123) +
+			":</p><pre>" + html.EscapeString(token) + "</pre>")
+		c.Data(http.StatusOK, "text/html", successData)
+	}
+}
 
-func numGenerator() string {
-	n := make([]byte, 32)
-	rand.Read(n)
-	fmt.Println("Old way:", base64.StdEncoding.EncodeToString(n))
-	fmt.Println("New way:", base64.URLEncoding.EncodeToString(n))
+//Logout is POST /v1/logout. It logs out the session token the request was made with, or with ?all=true every session the user has.
+func (h *handler) Logout(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
 
-	return base64.URLEncoding.EncodeToString(n)
+	if all, _ := strconv.ParseBool(c.Query("all")); all {
+		if fcErr := h.sessionService.RevokeAll(c.Request.Context(), requestUser); fcErr != nil {
+			abortWithError(c, fcErr)
+			return
+		}
+		respondMessage(c, http.StatusOK, "Every session has been logged out.")
+		return
+	}
 
+	if fcErr := h.sessionService.Revoke(c.Request.Context(), bearerToken(c.GetHeader("Authorization"))); fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	respondMessage(c, http.StatusOK, "You have been logged out.")
 }
+
+//@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
+
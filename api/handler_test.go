@@ -5,10 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
-
-	"golang.org/x/oauth2"
+	"time"
 
 	"github.com/jasonradcliffe/freshness-countdown-api/services/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/session"
 	"github.com/jasonradcliffe/freshness-countdown-api/services/storage"
 	"github.com/jasonradcliffe/freshness-countdown-api/services/user"
 
@@ -20,8 +21,6 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-type mockOAuthConfig struct{}
-
 var rUser = &userDomain.User{
 	UserID:       2,
 	Email:        "nothing@gmail.com",
@@ -31,7 +30,6 @@ var rUser = &userDomain.User{
 	CreatedDate:  "2016-01-02T15:04:05",
 	AccessToken:  "ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 	RefreshToken: "105i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-	AlexaUserID:  "qwertyuiop",
 	Admin:        false,
 	TempMatch:    "1v842d234523a",
 }
@@ -40,11 +38,12 @@ var nD = &dishDomain.Dish{
 	DishID:         1,
 	PersonalDishID: 1,
 	UserID:         2,
+	HouseholdID:    2,
 	StorageID:      3,
 	Title:          "Carrots",
 	Description:    "Some carrots we got at the store",
-	CreatedDate:    "2006-01-02T15:04:05",
-	ExpireDate:     "2020-10-13T08:00",
+	CreatedDate:    time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+	ExpireDate:     time.Date(2020, 10, 13, 8, 0, 0, 0, time.UTC),
 	Priority:       "",
 	DishType:       "",
 	Portions:       -1,
@@ -55,27 +54,18 @@ var nDex = &dishDomain.Dish{
 	DishID:         1,
 	PersonalDishID: 1,
 	UserID:         2,
+	HouseholdID:    2,
 	StorageID:      3,
 	Title:          "Carrots",
 	Description:    "Some carrots we got at the store",
-	CreatedDate:    "2006-01-02T15:04:05",
-	ExpireDate:     "2019-10-13T08:00",
+	CreatedDate:    time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+	ExpireDate:     time.Date(2019, 10, 13, 8, 0, 0, 0, time.UTC),
 	Priority:       "",
 	DishType:       "",
 	Portions:       -1,
 	TempMatch:      "9r842d3a351",
 }
 
-//Exchange is the mock method to get the token for oauth
-func (m *mockOAuthConfig) AuthCodeURL(state string, options ...oauth2.AuthCodeOption) string {
-	return ""
-}
-
-//Exchange is the mock method to get the token for oauth
-func (m *mockOAuthConfig) Exchange(c context.Context, code string, options ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
-	return nil, nil
-}
-
 func TestAPIHandler_getExpiredDishes(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
@@ -88,25 +78,26 @@ func TestAPIHandler_getExpiredDishes(t *testing.T) {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, err)
 	}
 
-	oC := &mockOAuthConfig{}
-
 	dS := dish.NewService(repo)
 	uS := user.NewService(repo)
 	sS := storage.NewService(repo)
 
-	mHandler := NewHandler(dS, sS, uS, oC)
+	mHandler := NewHandler(dS, sS, uS, household.NewService(repo), session.NewService(repo, 0), nil, nil, nil, nil, nil, nil)
 	fmt.Println("testing:", mHandler)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nDex.DishID, nDex.PersonalDishID, nDex.UserID, nDex.StorageID, nDex.Title, nDex.Description, nDex.CreatedDate,
-			nDex.ExpireDate, nDex.Priority, nDex.DishType, nDex.Portions, nDex.TempMatch).
+			nDex.ExpireDate, nDex.Priority, nDex.DishType, nDex.Portions, nDex.TempMatch, nDex.HouseholdID).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetDishesBase, rUser.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(dbrepo.GetMembershipQuery).WithArgs(rUser.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"household_id", "user_id", "role", "joined_date", "email", "full_name"}).
+			AddRow(nD.HouseholdID, rUser.UserID, "owner", "2016-01-02 15:04:05", rUser.Email, rUser.FullName))
+	mock.ExpectQuery(dbrepo.GetDishesQuery).WithArgs(nD.HouseholdID).WillReturnRows(rows)
 
-	resultingDishesMarshaled, err := getDishesExpired(rUser, dS)
+	resultingDishesMarshaled, err := getDishesExpired(context.Background(), rUser, dS)
 	var resultingDishes dishDomain.Dishes
 	jsonErr := json.Unmarshal(resultingDishesMarshaled, &resultingDishes)
 
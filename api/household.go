@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//GetHousehold is GET /v1/household - the user's household and everyone in it.
+func (h *handler) GetHousehold(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	household, fcErr := h.householdService.Get(c.Request.Context(), requestUser)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(household)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the household"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
+
+//UpdateHousehold is PATCH /v1/household, with the new name in the body. Only an owner can rename the household.
+func (h *handler) UpdateHousehold(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	if fcErr := h.householdService.Rename(c.Request.Context(), requestUser, aR.Name); fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	respondMessage(c, http.StatusOK, "Your household has been renamed.")
+}
+
+//InviteHouseholdMember is POST /v1/household/invites, with the email of the person to invite and optionally their role.
+//The invite is made the same way whether or not anyone has signed up with the email.
+func (h *handler) InviteHouseholdMember(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	invite, fcErr := h.householdService.Invite(c.Request.Context(), requestUser, aR.Email, aR.Role)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(invite)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the household invite"))
+		return
+	}
+	respond(c, http.StatusCreated, marshaled)
+}
+
+//UpdateHouseholdMember is PATCH /v1/household/members/:id, where id is the member's user id, with their new role in the body.
+func (h *handler) UpdateHouseholdMember(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	userID, ok := personalID(c)
+	if !ok {
+		return
+	}
+
+	if fcErr := h.householdService.SetRole(c.Request.Context(), requestUser, userID, aR.Role); fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	respondMessage(c, http.StatusOK, "The household member has been updated.")
+}
+
+//RemoveHouseholdMember is DELETE /v1/household/members/:id. Members can remove themselves to leave the household.
+func (h *handler) RemoveHouseholdMember(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	userID, ok := personalID(c)
+	if !ok {
+		return
+	}
+
+	if fcErr := h.householdService.RemoveMember(c.Request.Context(), requestUser, userID); fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	respondMessage(c, http.StatusOK, "The household member has been removed.")
+}
+
+//ListInvites is GET /v1/invites - the invites into other households waiting for the user.
+func (h *handler) ListInvites(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	invites, fcErr := h.householdService.Invites(c.Request.Context(), requestUser)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(invites)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the invites"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
+
+//AcceptInvite is POST /v1/invites/:id/accept, which moves the user into the household that invited them.
+func (h *handler) AcceptInvite(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	inviteID, ok := personalID(c)
+	if !ok {
+		return
+	}
+
+	member, fcErr := h.householdService.AcceptInvite(c.Request.Context(), requestUser, inviteID)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(member)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the household member"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
+
+//DeclineInvite is DELETE /v1/invites/:id, which turns the invite down.
+func (h *handler) DeclineInvite(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	inviteID, ok := personalID(c)
+	if !ok {
+		return
+	}
+
+	if fcErr := h.householdService.DeclineInvite(c.Request.Context(), requestUser, inviteID); fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	respondMessage(c, http.StatusOK, "The invite has been declined.")
+}
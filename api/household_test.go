@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	householdDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/household"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	dbrepo "github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/session"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/storage"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/user"
+	"github.com/stretchr/testify/assert"
+)
+
+//newHouseholdRouter maps the /v1 household, dish and storage routes over a memory repository holding two users, and
+//gives back a bearer header for each of them.
+func newHouseholdRouter(t *testing.T) (*gin.Engine, string, string) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	repo := dbrepo.NewMemoryRepository()
+	sessions := session.NewService(repo, time.Hour)
+
+	var bearers []string
+	for _, email := range []string{"nothing@gmail.com", "session@gmail.com"} {
+		u, _ := repo.CreateUser(ctx, userDomain.User{Email: email})
+		token, _, fcErr := sessions.Create(ctx, u)
+		if fcErr != nil {
+			t.Fatal(fcErr.Message())
+		}
+		bearers = append(bearers, "Bearer "+token)
+	}
+
+	h := NewHandler(dish.NewService(repo), storage.NewService(repo), user.NewService(repo), household.NewService(repo),
+		sessions, nil, nil, nil, nil, nil, nil)
+	router := gin.New()
+	router.Use(ErrorHandler())
+	v1 := router.Group("/v1")
+	v1.Use(h.RequireUser)
+	v1.GET("/dishes", h.ListDishes)
+	v1.POST("/dishes", h.CreateDish)
+	v1.PATCH("/dishes/:id", h.UpdateDish)
+	v1.POST("/storage", h.CreateStorage)
+	v1.DELETE("/storage/:id", h.DeleteStorage)
+	v1.GET("/household", h.GetHousehold)
+	v1.PATCH("/household", h.UpdateHousehold)
+	v1.POST("/household/invites", h.InviteHouseholdMember)
+	v1.PATCH("/household/members/:id", h.UpdateHouseholdMember)
+	v1.DELETE("/household/members/:id", h.RemoveHouseholdMember)
+	v1.GET("/invites", h.ListInvites)
+	v1.POST("/invites/:id/accept", h.AcceptInvite)
+	v1.DELETE("/invites/:id", h.DeclineInvite)
+	return router, bearers[0], bearers[1]
+}
+
+func TestAPIHandler_V1_SharedHousehold(t *testing.T) {
+	router, bob, sam := newHouseholdRouter(t)
+
+	w := serve(router, "POST", "/v1/dishes", bob, `{"storageID": "0", "title": "Carrots", "expireWindow": "P7D"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(router, "GET", "/v1/dishes", sam, "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "sam can't see bob's dishes before joining")
+
+	w = serve(router, "POST", "/v1/household/invites", bob, `{"email": "session@gmail.com"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(router, "GET", "/v1/dishes", sam, "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "sam isn't moved until they accept")
+
+	w = serve(router, "GET", "/v1/invites", sam, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var invites householdDomain.Invites
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &invites))
+	if !assert.Equal(t, 1, len(invites)) {
+		return
+	}
+	accept := "/v1/invites/" + strconv.Itoa(invites[0].InviteID) + "/accept"
+	w = serve(router, "POST", accept, bob, "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "only sam can accept")
+	w = serve(router, "POST", accept, sam, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var added householdDomain.Member
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &added))
+	assert.Equal(t, householdDomain.RoleMember, added.Role)
+
+	//both see and number the same dishes
+	w = serve(router, "POST", "/v1/dishes", sam, `{"storageID": "0", "title": "Soup", "expireWindow": "P2D"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(router, "PATCH", "/v1/dishes/1", sam, `{"title": "Old Carrots"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, "GET", "/v1/dishes", bob, "")
+	var dishes dishDomain.Dishes
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &dishes))
+	if assert.Equal(t, 2, len(dishes)) {
+		assert.Equal(t, "Old Carrots", dishes[0].Title)
+		assert.Equal(t, 2, dishes[1].PersonalDishID)
+		assert.Equal(t, added.UserID, dishes[1].UserID)
+	}
+
+	//only an owner deletes storage units or renames the household
+	w = serve(router, "POST", "/v1/storage", sam, `{"title": "Freezer"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(router, "DELETE", "/v1/storage/1", sam, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(router, "PATCH", "/v1/household", sam, `{"name": "Sam's"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(router, "PATCH", "/v1/household", bob, `{"name": "The Smiths"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, "DELETE", "/v1/storage/1", bob, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, "GET", "/v1/household", sam, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var home householdDomain.Household
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &home))
+	assert.Equal(t, "The Smiths", home.Name)
+	assert.Equal(t, 2, len(home.Members))
+
+	//sam leaves, and bob's household keeps everything
+	w = serve(router, "DELETE", "/v1/household/members/"+strconv.Itoa(added.UserID), sam, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, "GET", "/v1/dishes", sam, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(router, "GET", "/v1/dishes", bob, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAPIHandler_V1_HouseholdInvites(t *testing.T) {
+	router, bob, sam := newHouseholdRouter(t)
+
+	//an email nobody signed up with gets the same answer as one somebody did
+	w := serve(router, "POST", "/v1/household/invites", bob, `{"email": "nobody@example.com"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var unknown householdDomain.Invite
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &unknown))
+	w = serve(router, "POST", "/v1/household/invites", bob, `{"email": "session@gmail.com"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var known householdDomain.Invite
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &known))
+	assert.Equal(t, unknown.Role, known.Role)
+
+	w = serve(router, "DELETE", "/v1/invites/"+strconv.Itoa(unknown.InviteID), sam, "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "sam can't decline someone else's invite")
+	w = serve(router, "DELETE", "/v1/invites/"+strconv.Itoa(known.InviteID), sam, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, "GET", "/v1/invites", sam, "")
+	assert.Equal(t, "[]", w.Body.String())
+	w = serve(router, "GET", "/v1/household", bob, "")
+	var home householdDomain.Household
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &home))
+	assert.Equal(t, 1, len(home.Members))
+	assert.Equal(t, 1, len(home.Invites))
+}
+
+func TestAPIHandler_V1_HouseholdMembers_BadRequests(t *testing.T) {
+	router, bob, _ := newHouseholdRouter(t)
+
+	w := serve(router, "POST", "/v1/household/invites", bob, `{"email": "session@gmail.com", "role": "chef"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, "POST", "/v1/invites/abc/accept", bob, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, "PATCH", "/v1/household/members/abc", bob, `{"role": "owner"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, "GET", "/v1/household", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+//loginCookie holds the id of the browser's login attempt between Oauthlogin and LoginSuccess.
+const loginCookie = "fcapi_login"
+
+//loginAttemptTTL is how long a user has to finish signing in with the identity provider.
+const loginAttemptTTL = 10 * time.Minute
+
+//maxLoginAttempts bounds the login store. Past it the oldest attempt is dropped, so abandoned logins can't grow it.
+const maxLoginAttempts = 10000
+
+//loginAttempt is what Oauthlogin remembers about one sign in until the provider redirects back to LoginSuccess.
+type loginAttempt struct {
+	provider     string
+	state        string
+	nonce        string
+	codeVerifier string
+	returnURL    string
+	link         *linkRequest
+	expires      time.Time
+}
+
+//loginStore keeps login attempts on the server, keyed by the random id in the attempt's cookie, so each sign in only
+//sees its own state. An attempt can be taken once.
+type loginStore struct {
+	mu       sync.Mutex
+	attempts map[string]loginAttempt
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+func newLoginStore(ttl time.Duration) *loginStore {
+	return &loginStore{
+		attempts: make(map[string]loginAttempt),
+		ttl:      ttl,
+		now:      time.Now,
+	}
+}
+
+//put remembers the attempt and gives the id to put in its cookie.
+func (s *loginStore) put(attempt loginAttempt) string {
+	id := randomToken()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if len(s.attempts) >= maxLoginAttempts {
+		s.dropExpired(now)
+	}
+	if len(s.attempts) >= maxLoginAttempts {
+		s.dropOldest()
+	}
+	attempt.expires = now.Add(s.ttl)
+	s.attempts[id] = attempt
+	return id
+}
+
+//take gives the attempt with the id and forgets it. It is false when there is no such attempt or it has expired.
+func (s *loginStore) take(id string) (loginAttempt, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[id]
+	if !ok {
+		return loginAttempt{}, false
+	}
+	delete(s.attempts, id)
+	return attempt, s.now().Before(attempt.expires)
+}
+
+//dropExpired forgets the attempts that ran out of time. Callers hold mu.
+func (s *loginStore) dropExpired(now time.Time) {
+	for id, attempt := range s.attempts {
+		if !now.Before(attempt.expires) {
+			delete(s.attempts, id)
+		}
+	}
+}
+
+//dropOldest forgets the attempt that expires first. Callers hold mu.
+func (s *loginStore) dropOldest() {
+	oldestID := ""
+	var oldest time.Time
+	for id, attempt := range s.attempts {
+		if oldestID == "" || attempt.expires.Before(oldest) {
+			oldestID, oldest = id, attempt.expires
+		}
+	}
+	delete(s.attempts, oldestID)
+}
+
+//pkceChallenge gives the AuthCodeURL options that send the S256 challenge for verifier.
+func pkceChallenge(verifier string) []oauth2.AuthCodeOption {
+	sum := sha256.Sum256([]byte(verifier))
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+}
+
+//safeReturnURL only lets a login return to a path on this site, so the login can't be used to send users elsewhere.
+func safeReturnURL(returnURL string) (string, bool) {
+	if returnURL == "" {
+		return "", true
+	}
+	parsed, err := url.Parse(returnURL)
+	if err != nil || parsed.IsAbs() || parsed.Host != "" || !strings.HasPrefix(returnURL, "/") ||
+		strings.HasPrefix(returnURL, "//") || strings.Contains(returnURL, `\`) {
+		return "", false
+	}
+	return returnURL, true
+}
+
+//randomToken is 32 random bytes, base64url encoded - for login ids, states, nonces and PKCE verifiers.
+func randomToken() string {
+	n := make([]byte, 32)
+	rand.Read(n)
+	return base64.RawURLEncoding.EncodeToString(n)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginStore(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := newLoginStore(time.Minute)
+	store.now = func() time.Time { return now }
+
+	id := store.put(loginAttempt{provider: "stub", state: "abc"})
+	other := store.put(loginAttempt{provider: "stub", state: "def"})
+	assert.NotEqual(t, id, other)
+
+	attempt, ok := store.take(id)
+	assert.True(t, ok)
+	assert.Equal(t, "abc", attempt.state)
+	_, ok = store.take(id)
+	assert.False(t, ok, "an attempt can only be taken once")
+	_, ok = store.take("made-up")
+	assert.False(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = store.take(other)
+	assert.False(t, ok, "an expired attempt can't be taken")
+	assert.Empty(t, store.attempts)
+}
+
+func TestLoginStore_Full(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := newLoginStore(time.Minute)
+	store.now = func() time.Time { return now }
+
+	first := store.put(loginAttempt{state: "first"})
+	for i := 1; i < maxLoginAttempts; i++ {
+		now = now.Add(time.Millisecond)
+		store.put(loginAttempt{})
+	}
+	assert.Len(t, store.attempts, maxLoginAttempts)
+
+	//full of attempts that are still good, so the oldest goes
+	store.put(loginAttempt{})
+	assert.Len(t, store.attempts, maxLoginAttempts)
+	_, ok := store.attempts[first]
+	assert.False(t, ok)
+
+	//once they have expired they all go
+	now = now.Add(time.Hour)
+	latest := store.put(loginAttempt{state: "latest"})
+	assert.Len(t, store.attempts, 1)
+	attempt, ok := store.take(latest)
+	assert.True(t, ok)
+	assert.Equal(t, "latest", attempt.state)
+}
+
+func TestSafeReturnURL(t *testing.T) {
+	tests := []struct {
+		returnURL string
+		ok        bool
+	}{
+		{"", true},
+		{"/", true},
+		{"/kitchen?view=all", true},
+		{"https://evil.example.com/", false},
+		{"//evil.example.com/", false},
+		{`/\evil.example.com/`, false},
+		{"kitchen", false},
+		{"javascript:alert(1)", false},
+	}
+	for _, tt := range tests {
+		got, ok := safeReturnURL(tt.returnURL)
+		assert.Equal(t, tt.ok, ok, tt.returnURL)
+		if tt.ok {
+			assert.Equal(t, tt.returnURL, got)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//RequestTimeout gives every request's context a deadline, so the services and repository give up on a request
+//once its time is up. The context is also cancelled when the client goes away. A timeout of 0 or less sets no deadline,
+//and neither do the routes in streams, like EventsPath, which last as long as the client stays.
+func RequestTimeout(timeout time.Duration, streams ...string) gin.HandlerFunc {
+	untimed := map[string]bool{}
+	for _, path := range streams {
+		untimed[path] = true
+	}
+	return func(c *gin.Context) {
+		if timeout <= 0 || untimed[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
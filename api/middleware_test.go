@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	dbrepo "github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/dish"
+)
+
+func TestRequestTimeout_SetsDeadline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestTimeout(50 * time.Millisecond))
+
+	var hasDeadline bool
+	var ctxErr error
+	router.GET("/slow", func(c *gin.Context) {
+		_, hasDeadline = c.Request.Context().Deadline()
+		<-c.Request.Context().Done()
+		ctxErr = c.Request.Context().Err()
+		c.AbortWithStatus(http.StatusGatewayTimeout)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+
+	assert.True(t, hasDeadline)
+	assert.Equal(t, context.DeadlineExceeded, ctxErr)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+func TestRequestTimeout_ZeroMeansNoDeadline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestTimeout(0))
+
+	hasDeadline := true
+	router.GET("/ping", func(c *gin.Context) {
+		_, hasDeadline = c.Request.Context().Deadline()
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/ping", nil))
+
+	assert.False(t, hasDeadline)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRequestTimeout_CancelledRequestStopsTheService(t *testing.T) {
+	repo := dbrepo.NewMemoryRepository()
+	dS := dish.NewService(repo)
+	newDish := *nD
+	dS.Create(context.Background(), rUser, &newDish, "P7D")
+
+	marshaledDishes, _, err := getDishes(context.Background(), rUser, dishDomain.Query{}, dS)
+	assert.Nil(t, err)
+	assert.NotNil(t, marshaledDishes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	marshaledDishes, _, err = getDishes(ctx, rUser, dishDomain.Query{}, dS)
+	assert.Nil(t, marshaledDishes)
+	assert.NotNil(t, err)
+}
+
+func TestRequestTimeout_StreamsHaveNoDeadline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestTimeout(50*time.Millisecond, EventsPath))
+
+	hasDeadline := true
+	router.GET(EventsPath, func(c *gin.Context) {
+		_, hasDeadline = c.Request.Context().Deadline()
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", EventsPath, nil))
+
+	assert.False(t, hasDeadline)
+}
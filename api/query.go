@@ -0,0 +1,70 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//NextCursorHeader is where a page of dishes gives the cursor for the page after it. It isn't set on the last page.
+const NextCursorHeader = "X-Next-Cursor"
+
+//dishQuery reads how to narrow down, sort and page a list of dishes from the query string: storage, dishType,
+//priority, expiresAfter, expiresBefore, search, sort, order (asc or desc), limit and cursor. If any can't be read it
+//aborts with a 400 for all of them and gives false.
+func dishQuery(c *gin.Context) (dishDomain.Query, bool) {
+	q := dishDomain.Query{
+		DishType: c.Query("dishType"),
+		Priority: c.Query("priority"),
+		Search:   c.Query("search"),
+		Sort:     c.Query("sort"),
+	}
+	var details []fcerr.FieldDetail
+	if value := c.Query("storage"); value != "" {
+		storageID, err := strconv.Atoi(value)
+		if err != nil {
+			details = append(details, fcerr.FieldDetail{Field: "storage", Message: "not a number: " + value})
+		}
+		q.StorageID = &storageID
+	}
+	parseTime := func(field string) time.Time {
+		value := c.Query(field)
+		parsed, err := dishDomain.ParseTime(value)
+		if err != nil {
+			details = append(details, fcerr.FieldDetail{Field: field, Message: "not a time: " + value})
+		}
+		return parsed
+	}
+	q.ExpiresAfter = parseTime("expiresAfter")
+	q.ExpiresBefore = parseTime("expiresBefore")
+	switch order := c.Query("order"); order {
+	case "", "asc":
+	case "desc":
+		q.Descending = true
+	default:
+		details = append(details, fcerr.FieldDetail{Field: "order", Message: "not asc or desc: " + order})
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			details = append(details, fcerr.FieldDetail{Field: "limit", Message: "not a number above 0: " + value})
+		}
+		q.Limit = limit
+	}
+	if value := c.Query("cursor"); value != "" {
+		after, err := dishDomain.DecodeCursor(value)
+		if err != nil {
+			details = append(details, fcerr.FieldDetail{Field: "cursor", Message: err.Error()})
+		}
+		q.After = after
+	}
+
+	if len(details) > 0 {
+		abortWithError(c, fcerr.NewValidationError("The dishes could not be listed", details...))
+		return q, false
+	}
+	return q, true
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/stretchr/testify/assert"
+)
+
+//listTitles gets path and gives the titles of the dishes listed, and the cursor for the next page.
+func listTitles(t *testing.T, router *gin.Engine, bearer, path string) ([]string, string) {
+	w := serve(router, "GET", path, bearer, "")
+	if !assert.Equal(t, http.StatusOK, w.Code, path) {
+		return nil, ""
+	}
+	var dishes dishDomain.Dishes
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &dishes))
+	found := []string{}
+	for _, d := range dishes {
+		found = append(found, d.Title)
+	}
+	return found, w.Header().Get(NextCursorHeader)
+}
+
+func TestAPIHandler_V1_ListDishes_Query(t *testing.T) {
+	router := testRouter()
+	bearer := "Bearer " + rUser.AccessToken
+
+	w := serve(router, "POST", "/v1/storage", bearer, `{"title": "Fridge"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	for _, body := range []string{
+		`{"storageID": "1", "title": "Tomato Soup", "dishType": "soup", "priority": "high", "expireWindow": "P3D"}`,
+		`{"storageID": "1", "title": "Carrots", "dishType": "vegetable", "expireWindow": "PT2H"}`,
+		`{"storageID": "2", "title": "Pea Soup", "dishType": "soup", "priority": "low", "expireWindow": "P5D"}`,
+	} {
+		w = serve(router, "POST", "/v1/dishes", bearer, body)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	found, next := listTitles(t, router, bearer, "/v1/dishes")
+	assert.Equal(t, []string{"Tomato Soup", "Carrots", "Pea Soup"}, found)
+	assert.Equal(t, "", next, "everything fits on one page")
+
+	found, _ = listTitles(t, router, bearer, "/v1/dishes?dishType=soup&search=pea")
+	assert.Equal(t, []string{"Pea Soup"}, found)
+	found, _ = listTitles(t, router, bearer, "/v1/dishes?sort=priority&order=desc")
+	assert.Equal(t, []string{"Pea Soup", "Carrots", "Tomato Soup"}, found)
+	found, _ = listTitles(t, router, bearer, "/v1/dishes?search=cake")
+	assert.Equal(t, []string{}, found, "a list narrowed down to nothing is empty rather than not found")
+
+	found, next = listTitles(t, router, bearer, "/v1/dishes?sort=expireDate&limit=2")
+	assert.Equal(t, []string{"Carrots", "Tomato Soup"}, found)
+	if assert.NotEqual(t, "", next) {
+		found, next = listTitles(t, router, bearer, "/v1/dishes?sort=expireDate&limit=2&cursor="+url.QueryEscape(next))
+		assert.Equal(t, []string{"Pea Soup"}, found)
+		assert.Equal(t, "", next)
+	}
+
+	found, _ = listTitles(t, router, bearer, "/v1/storage/1/dishes?sort=expireDate")
+	assert.Equal(t, []string{"Carrots", "Tomato Soup"}, found)
+	w = serve(router, "GET", "/v1/storage/9/dishes?sort=expireDate", bearer, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPIHandler_V1_ListDishes_BadQuery(t *testing.T) {
+	router := testRouter()
+	bearer := "Bearer " + rUser.AccessToken
+
+	w := serve(router, "GET", "/v1/dishes?storage=fridge&expiresBefore=soon&order=up&limit=0&cursor=nonsense", bearer, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var envelope errorEnvelope
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	var fields []string
+	for _, detail := range envelope.Error.Details {
+		fields = append(fields, detail.Field)
+	}
+	assert.Equal(t, []string{"storage", "expiresBefore", "order", "limit", "cursor"}, fields)
+
+	w = serve(router, "GET", "/v1/dishes?sort=title&limit=1000", bearer, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, "GET", "/v1/dishes", bearer, "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "no dishes at all")
+}
@@ -0,0 +1,681 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/clock"
+	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/identity"
+	sessionDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/session"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/eventbus"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc"
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc/oidctest"
+	dbrepo "github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/digest"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/link"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/session"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/storage"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/stream"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/webhook"
+)
+
+//fakeUserService knows one user, reachable by their id or their access token, so no request goes to Google.
+type fakeUserService struct {
+	user.Service
+	knownUser userDomain.User
+}
+
+func (f *fakeUserService) GetByID(ctx context.Context, id int) (*userDomain.User, fcerr.FCErr) {
+	if id != f.knownUser.UserID {
+		return nil, fcerr.NewNotFoundError("Could not find this user in the system.")
+	}
+	u := f.knownUser
+	return &u, nil
+}
+
+func (f *fakeUserService) GetOrCreateByAccessToken(ctx context.Context, aT string, client *user.Client) (*userDomain.User, fcerr.FCErr) {
+	if aT != f.knownUser.AccessToken {
+		return nil, fcerr.NewBadRequestError("Not Authorized. Please verify email address.")
+	}
+	u := f.knownUser
+	return &u, nil
+}
+
+//rUserAlexaID is the Alexa user id rUser's account is linked to in the test router.
+const rUserAlexaID = "qwertyuiop"
+
+//testSkill is the Alexa skill the test routers let link accounts.
+var testSkill = link.Client{
+	ID:           "alexa-client",
+	Secret:       "alexa-secret",
+	RedirectURIs: []string{"https://pitangui.amazon.com/api/skill/link/TEST"},
+}
+
+//testRouter maps a few legacy routes and the /v1 dish, digest, webhook and event stream routes the same way
+//app.mapRoutes does.
+func testRouter() *gin.Engine {
+	router, _, _ := newTestRouter()
+	return router
+}
+
+//newTestRouter is testRouter, also giving back the repository and session service behind it.
+func newTestRouter() (*gin.Engine, dbrepo.Repository, session.Service) {
+	gin.SetMode(gin.TestMode)
+	repo := dbrepo.NewMemoryRepository()
+	sessions := session.NewService(repo, time.Hour)
+	links := link.NewService(repo, sessions, testSkill)
+	repo.CreateIdentity(context.Background(), identity.Identity{UserID: rUser.UserID, Provider: identity.Alexa,
+		ExternalID: rUserAlexaID, RefreshTokenHash: sessionDomain.HashToken("fcr_legacy")})
+	bus := eventbus.New()
+	ds, ss := dish.NewServiceWithEvents(repo, bus), storage.NewServiceWithEvents(repo, bus)
+	webhooks := webhook.NewService(repo, clock.System(), nil)
+	bus.Subscribe(webhooks.Enqueue)
+	streams := stream.NewService(repo)
+	bus.Subscribe(streams.Record)
+	h := NewHandler(ds, ss, &fakeUserService{knownUser: *rUser}, household.NewService(repo), sessions, links,
+		digest.NewService(ds, ss, clock.System()), webhooks, streams, nil, nil)
+
+	router := gin.New()
+	router.Use(ErrorHandler())
+	router.Use(RequestTimeout(time.Second, EventsPath))
+	router.POST("/dishes", h.GetDishes)
+	router.POST("/dishes/dish", h.HandleDishRequest)
+	router.POST("/dishes/dish/:p_id", h.HandleDishRequest)
+
+	v1 := router.Group("/v1")
+	v1.Use(h.RequireUser)
+	v1.POST("/logout", h.Logout)
+	v1.GET("/dishes", h.ListDishes)
+	v1.POST("/dishes", h.CreateDish)
+	v1.GET("/dishes/:id", h.GetDish)
+	v1.PATCH("/dishes/:id", h.UpdateDish)
+	v1.DELETE("/dishes/:id", h.DeleteDish)
+	v1.POST("/storage", h.CreateStorage)
+	v1.GET("/storage/:id/dishes", h.ListStorageDishes)
+	v1.GET("/digest", h.GetDigest)
+	v1.GET("/webhooks", h.GetWebhooks)
+	v1.POST("/webhooks", h.CreateWebhook)
+	v1.GET("/webhooks/deadletters", h.GetDeadLetters)
+	v1.POST("/webhooks/deliveries/:id/redeliver", h.RedeliverWebhook)
+	v1.GET("/webhooks/:id", h.GetWebhook)
+	v1.PATCH("/webhooks/:id", h.UpdateWebhook)
+	v1.DELETE("/webhooks/:id", h.DeleteWebhook)
+	v1.GET("/webhooks/:id/deliveries", h.GetWebhookDeliveries)
+	v1.GET("/events", h.StreamEvents)
+	return router, repo, sessions
+}
+
+func serve(router *gin.Engine, method string, path string, authorization string, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAPIHandler_V1_DishLifecycle(t *testing.T) {
+	router := testRouter()
+	bearer := "Bearer " + rUser.AccessToken
+
+	w := serve(router, "POST", "/v1/dishes", bearer, `{"storageID": "3", "title": "Carrots", "expireWindow": "P7D"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(router, "GET", "/v1/dishes/1", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resultingDish dishDomain.Dish
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resultingDish))
+	assert.Equal(t, "Carrots", resultingDish.Title)
+	assert.Equal(t, rUser.UserID, resultingDish.UserID)
+
+	w = serve(router, "PATCH", "/v1/dishes/1", bearer, `{"title": "Old Carrots", "expireWindow": "P1D"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, "GET", "/v1/dishes", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resultingDishes dishDomain.Dishes
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resultingDishes))
+	assert.Equal(t, 1, len(resultingDishes))
+	assert.Equal(t, "Old Carrots", resultingDishes[0].Title)
+
+	w = serve(router, "DELETE", "/v1/dishes/1", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, "DELETE", "/v1/dishes/1", bearer, "")
+	assert.NotEqual(t, http.StatusOK, w.Code)
+}
+
+func TestAPIHandler_V1_NeedsAuthorizationHeader(t *testing.T) {
+	router := testRouter()
+
+	w := serve(router, "GET", "/v1/dishes", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+	w = serve(router, "GET", "/v1/dishes", "Bearer not-a-real-token", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	//credentials in the body are only honoured on the legacy routes
+	w = serve(router, "POST", "/v1/dishes", "", `{"alexaUserID": "`+rUserAlexaID+`", "storageID": "3", "expireWindow": "P7D"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAPIHandler_V1_BadID(t *testing.T) {
+	router := testRouter()
+
+	w := serve(router, "GET", "/v1/dishes/carrots", "Bearer "+rUser.AccessToken, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPIHandler_LegacyShim(t *testing.T) {
+	router := testRouter()
+
+	w := serve(router, "POST", "/dishes/dish", "",
+		`{"fcapiRequestType": "POST", "accessToken": "`+rUser.AccessToken+`", "storageID": "3", "title": "Carrots", "expireWindow": "P7D"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, "POST", "/dishes/dish/1", "", `{"fcapiRequestType": "GET", "accessToken": "`+rUser.AccessToken+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	//legacy clients get the dish JSON base64 encoded in "message", as before
+	var legacyBody struct {
+		Message []byte `json:"message"`
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &legacyBody))
+	var resultingDish dishDomain.Dish
+	assert.Nil(t, json.Unmarshal(legacyBody.Message, &resultingDish))
+	assert.Equal(t, "Carrots", resultingDish.Title)
+
+	w = serve(router, "POST", "/dishes/dish", "", `{"fcapiRequestType": "GET", "accessToken": "`+rUser.AccessToken+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, "POST", "/dishes", "", `{"fcapiRequestType": "PUT", "accessToken": "`+rUser.AccessToken+`"}`)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	w = serve(router, "POST", "/dishes", "", `{"fcapiRequestType": "GET"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAPIHandler_bearerToken(t *testing.T) {
+	assert.Equal(t, "abc123", bearerToken("Bearer abc123"))
+	assert.Equal(t, "abc123", bearerToken("bearer  abc123 "))
+	assert.Equal(t, "", bearerToken("Basic dXNlcjpwYXNz"))
+	assert.Equal(t, "", bearerToken("abc123"))
+	assert.Equal(t, "", bearerToken(""))
+}
+
+func TestAPIHandler_V1_DatesAreRFC3339(t *testing.T) {
+	router := testRouter()
+	bearer := "Bearer " + rUser.AccessToken
+
+	w := serve(router, "POST", "/v1/dishes", bearer, `{"storageID": "3", "title": "Carrots", "expireWindow": "P7D"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(router, "GET", "/v1/dishes/1", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var wire struct {
+		TimeCreated string
+		TimeExpires string
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &wire))
+	for _, value := range []string{wire.TimeCreated, wire.TimeExpires} {
+		parsed, err := time.Parse(time.RFC3339, value)
+		assert.Nil(t, err)
+		assert.Equal(t, parsed.UTC().Format(time.RFC3339), value)
+	}
+}
+
+func TestAPIHandler_V1_ExpireWindow(t *testing.T) {
+	router := testRouter()
+	bearer := "Bearer " + rUser.AccessToken
+
+	w := serve(router, "POST", "/v1/dishes", bearer, `{"storageID": "3", "title": "Carrots", "expireWindow": "three days"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var envelope errorEnvelope
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	if assert.Equal(t, 1, len(envelope.Error.Details)) {
+		assert.Equal(t, "expireWindow", envelope.Error.Details[0].Field)
+	}
+
+	w = serve(router, "POST", "/v1/dishes", bearer, `{"storageID": "3", "title": "Carrots"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, "POST", "/v1/dishes", bearer, `{"storageID": "3", "title": "Carrots", "expireWindow": "P2W"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var before dishDomain.Dish
+	w = serve(router, "GET", "/v1/dishes/1", bearer, "")
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &before))
+	assert.Equal(t, 14*24*time.Hour, before.ExpireDate.Sub(before.CreatedDate))
+
+	//a PATCH without an expireWindow leaves the expire date alone
+	w = serve(router, "PATCH", "/v1/dishes/1", bearer, `{"title": "Old Carrots"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var after dishDomain.Dish
+	w = serve(router, "GET", "/v1/dishes/1", bearer, "")
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &after))
+	assert.Equal(t, "Old Carrots", after.Title)
+	assert.True(t, before.ExpireDate.Equal(after.ExpireDate))
+
+	w = serve(router, "PATCH", "/v1/dishes/1", bearer, `{"expireWindow": "P1D2W"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPIHandler_V1_SessionToken(t *testing.T) {
+	router, repo, sessions := newTestRouter()
+	sessionUser, _ := repo.CreateUser(context.Background(), userDomain.User{Email: "session@gmail.com", FirstName: "Sam"})
+	token, _, fcErr := sessions.Create(context.Background(), sessionUser)
+	assert.Nil(t, fcErr)
+	bearer := "Bearer " + token
+
+	w := serve(router, "POST", "/v1/dishes", bearer, `{"storageID": "3", "title": "Carrots", "expireWindow": "P7D"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(router, "GET", "/v1/dishes", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resultingDishes dishDomain.Dishes
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resultingDishes))
+	if assert.Equal(t, 1, len(resultingDishes)) {
+		assert.Equal(t, sessionUser.UserID, resultingDishes[0].UserID)
+	}
+
+	w = serve(router, "POST", "/v1/logout", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, "GET", "/v1/dishes", bearer, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+
+	w = serve(router, "GET", "/v1/dishes", "Bearer fcs_never-issued", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	//a Google access token has no session to log out
+	w = serve(router, "POST", "/v1/logout", "Bearer "+rUser.AccessToken, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPIHandler_V1_LogoutAll(t *testing.T) {
+	router, repo, sessions := newTestRouter()
+	sessionUser, _ := repo.CreateUser(context.Background(), userDomain.User{Email: "session@gmail.com", FirstName: "Sam"})
+	first, _, _ := sessions.Create(context.Background(), sessionUser)
+	second, _, _ := sessions.Create(context.Background(), sessionUser)
+
+	w := serve(router, "POST", "/v1/logout?all=true", "Bearer "+first, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, "GET", "/v1/dishes", "Bearer "+second, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAPIHandler_respondSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := dbrepo.NewMemoryRepository()
+	sessions := session.NewService(repo, time.Hour)
+	loginUser, _ := repo.CreateUser(context.Background(), userDomain.User{Email: "session@gmail.com", FirstName: "Sam"})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/success", nil)
+	c.Request.Header.Set("Accept", "application/json")
+	respondSession(c, sessions, loginUser, "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body sessionResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "Bearer", body.TokenType)
+	assert.False(t, body.ExpiresAt.IsZero())
+	validated, fcErr := sessions.Validate(context.Background(), body.Token)
+	assert.Nil(t, fcErr)
+	assert.Equal(t, loginUser.UserID, validated.UserID)
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/success", nil)
+	c.Request.Header.Set("Accept", "text/html,application/xhtml+xml")
+	respondSession(c, sessions, loginUser, "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<h1>Success!</h1>")
+	assert.Contains(t, w.Body.String(), "fcs_")
+
+	//a browser that asked to come back somewhere gets the token in the fragment
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/success", nil)
+	c.Request.Header.Set("Accept", "text/html,application/xhtml+xml")
+	respondSession(c, sessions, loginUser, "/kitchen?view=all")
+
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	location, _ := url.Parse(w.Header().Get("Location"))
+	assert.Equal(t, "/kitchen", location.Path)
+	fragment, _ := url.ParseQuery(location.Fragment)
+	assert.True(t, strings.HasPrefix(fragment.Get("token"), "fcs_"))
+	assert.NotEqual(t, "", fragment.Get("expiresAt"))
+}
+
+//newLoginRouter maps the login and Alexa account linking routes over a memory repository, with a stub identity provider
+//to sign in with.
+func newLoginRouter(t *testing.T) (*gin.Engine, *oidctest.Server, dbrepo.Repository) {
+	gin.SetMode(gin.TestMode)
+	stub := oidctest.NewServer()
+	t.Cleanup(stub.Close)
+
+	p, fcErr := oidc.Discover(context.Background(), stub.Config("stub", "https://fcapi.example.com/success"), nil)
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	providers, _ := oidc.NewProviders(p)
+
+	repo := dbrepo.NewMemoryRepository()
+	sessions := session.NewService(repo, time.Hour)
+	h := NewHandler(dish.NewService(repo), storage.NewService(repo), user.NewServiceWithProviders(repo, providers, 0, 0),
+		household.NewService(repo), sessions, link.NewService(repo, sessions, testSkill), nil, nil, nil, nil, providers)
+
+	router := gin.New()
+	router.Use(ErrorHandler())
+	router.GET("/login", h.Login)
+	router.GET("/oauthlogin", h.Oauthlogin)
+	router.GET("/success", h.LoginSuccess)
+	router.GET("/alexa/authorize", h.AlexaAuthorize)
+	router.POST("/alexa/token", h.AlexaToken)
+	router.POST("/dishes", h.GetDishes)
+
+	v1 := router.Group("/v1")
+	v1.Use(h.RequireUser)
+	v1.GET("/users/me/links", h.ListLinks)
+	v1.DELETE("/users/me/links/:id", h.Unlink)
+	return router, stub, repo
+}
+
+//startSignIn goes through loginPath and the stub provider, and gives back the login cookies and the query the
+//provider redirects to /success with.
+func startSignIn(t *testing.T, router *gin.Engine, stub *oidctest.Server, loginPath string) ([]*http.Cookie, url.Values) {
+	w := serve(router, "GET", loginPath, "", "")
+	if !assert.Equal(t, http.StatusTemporaryRedirect, w.Code) {
+		t.FailNow()
+	}
+	redirect, err := stub.Authorize(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.Result().Cookies(), redirect.Query()
+}
+
+//finishSignIn sends the provider's redirect back to /success with the login cookies, asking for JSON.
+func finishSignIn(router *gin.Engine, cookies []*http.Cookie, query url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/success?"+query.Encode(), nil)
+	req.Header.Set("Accept", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+//signIn goes through /oauthlogin and the stub provider, and gives back the /success response.
+func signIn(t *testing.T, router *gin.Engine, stub *oidctest.Server) *httptest.ResponseRecorder {
+	cookies, query := startSignIn(t, router, stub, "/oauthlogin?provider=stub")
+	return finishSignIn(router, cookies, query)
+}
+
+func TestAPIHandler_OIDCLogin(t *testing.T) {
+	router, stub, repo := newLoginRouter(t)
+
+	w := serve(router, "GET", "/login", "", "")
+	assert.Contains(t, w.Body.String(), "/oauthlogin?provider=stub")
+
+	w = signIn(t, router, stub)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body sessionResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.True(t, strings.HasPrefix(body.Token, "fcs_"))
+
+	stored, fcErr := repo.GetUserByEmail(context.Background(), oidctest.DefaultUser.Email)
+	if assert.Nil(t, fcErr) {
+		assert.Equal(t, "Bob", stored.FirstName)
+		assert.Equal(t, "stub", stored.TokenProvider)
+		assert.NotEqual(t, "", stored.RefreshToken)
+		assert.True(t, stored.TokenExpiry.After(time.Now()))
+	}
+
+	//signing in again finds the same user
+	w = signIn(t, router, stub)
+	assert.Equal(t, http.StatusOK, w.Code)
+	again, _ := repo.GetUserByEmail(context.Background(), oidctest.DefaultUser.Email)
+	assert.Equal(t, stored.UserID, again.UserID)
+}
+
+func TestAPIHandler_ProviderToken_RefreshesExpiredStoredTokens(t *testing.T) {
+	router, stub, repo := newLoginRouter(t)
+	ctx := context.Background()
+
+	//signed in a while ago - the stored access token has long expired
+	_, storedRefresh, _ := stub.IssueTokens(oidctest.DefaultUser)
+	u, _ := repo.CreateUser(ctx, userDomain.User{Email: oidctest.DefaultUser.Email, AccessToken: "expired-access",
+		RefreshToken: storedRefresh, TokenProvider: "stub", TokenExpiry: time.Now().Add(-time.Hour)})
+
+	accessToken, _, _ := stub.IssueTokens(oidctest.DefaultUser)
+	w := serve(router, "GET", "/v1/users/me/links", "Bearer "+accessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	stored, fcErr := repo.GetUserByID(ctx, u.UserID)
+	if assert.Nil(t, fcErr) {
+		assert.NotEqual(t, "expired-access", stored.AccessToken, "the stored token was refreshed")
+		assert.NotEqual(t, storedRefresh, stored.RefreshToken, "and the refresh token the provider rotated to kept")
+		assert.True(t, stored.TokenExpiry.After(time.Now()))
+	}
+
+	//a refresh the provider turns down doesn't turn the request down
+	stub.RejectRefresh(true)
+	repo.UpdateUser(ctx, userDomain.User{UserID: u.UserID, Email: u.Email, AccessToken: "expired-access",
+		RefreshToken: stored.RefreshToken, TokenProvider: "stub", TokenExpiry: time.Now().Add(-time.Hour)})
+	w = serve(router, "GET", "/v1/users/me/links", "Bearer "+accessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAPIHandler_OIDCLogin_Errors(t *testing.T) {
+	router, stub, _ := newLoginRouter(t)
+
+	w := serve(router, "GET", "/oauthlogin?provider=nobody", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	unverified := oidctest.DefaultUser
+	unverified.EmailVerified = false
+	stub.SetUser(unverified)
+	w = signIn(t, router, stub)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Please verify your email address with stub before logging in")
+
+	stub.SetUser(oidctest.DefaultUser)
+
+	w = serve(router, "GET", "/oauthlogin?provider=stub&return=https://evil.example.com/", "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	//no login cookie, or one the server never handed out
+	w = finishSignIn(router, nil, url.Values{"code": {"made-up"}, "state": {"abc"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = finishSignIn(router, []*http.Cookie{{Name: loginCookie, Value: "made-up"}}, url.Values{"code": {"made-up"}, "state": {"abc"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	//an attempt is only good once
+	cookies, query := startSignIn(t, router, stub, "/oauthlogin?provider=stub")
+	w = finishSignIn(router, cookies, query)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = finishSignIn(router, cookies, query)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	//a state from somewhere else
+	cookies, query = startSignIn(t, router, stub, "/oauthlogin?provider=stub")
+	query.Set("state", "abc")
+	w = finishSignIn(router, cookies, query)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	//a code from another attempt doesn't match this attempt's PKCE verifier
+	_, stolenQuery := startSignIn(t, router, stub, "/oauthlogin?provider=stub")
+	cookies, query = startSignIn(t, router, stub, "/oauthlogin?provider=stub")
+	query.Set("code", stolenQuery.Get("code"))
+	w = finishSignIn(router, cookies, query)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	//the user turned the provider down
+	cookies, query = startSignIn(t, router, stub, "/oauthlogin?provider=stub")
+	query.Del("code")
+	query.Set("error", "access_denied")
+	w = finishSignIn(router, cookies, query)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAPIHandler_OIDCLogin_ReturnURL(t *testing.T) {
+	router, stub, _ := newLoginRouter(t)
+
+	cookies, query := startSignIn(t, router, stub, "/oauthlogin?provider=stub&return=/kitchen")
+	w := finishSignIn(router, cookies, query)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body sessionResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "/kitchen", body.ReturnTo)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == loginCookie {
+			assert.True(t, cookie.MaxAge < 0, "the login cookie is cleared")
+		}
+	}
+}
+
+//TestAPIHandler_OIDCLogin_Parallel signs several users in at once. Run with -race: each has to end up with a
+//session for themselves, not whoever finished signing in last.
+func TestAPIHandler_OIDCLogin_Parallel(t *testing.T) {
+	router, stub, repo := newLoginRouter(t)
+
+	const logins = 8
+	emails := make([]string, logins)
+	cookies := make([][]*http.Cookie, logins)
+	queries := make([]url.Values, logins)
+	for i := range emails {
+		u := oidctest.DefaultUser
+		u.Subject = fmt.Sprint("subject-", i)
+		u.Email = fmt.Sprint("parallel", i, "@gmail.com")
+		emails[i] = u.Email
+		//the stub signs in whoever it was last told to, so the provider part goes one at a time
+		stub.SetUser(u)
+		cookies[i], queries[i] = startSignIn(t, router, stub, "/oauthlogin?provider=stub")
+	}
+
+	responses := make([]*httptest.ResponseRecorder, logins)
+	var wg sync.WaitGroup
+	for i := range emails {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = finishSignIn(router, cookies[i], queries[i])
+		}(i)
+	}
+	wg.Wait()
+
+	sessions := session.NewService(repo, time.Hour)
+	for i, w := range responses {
+		if !assert.Equal(t, http.StatusOK, w.Code, emails[i]) {
+			continue
+		}
+		var body sessionResponse
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+		validated, fcErr := sessions.Validate(context.Background(), body.Token)
+		if assert.Nil(t, fcErr) {
+			signedIn, _ := repo.GetUserByID(context.Background(), validated.UserID)
+			assert.Equal(t, emails[i], signedIn.Email)
+		}
+	}
+}
+
+func TestAPIHandler_V1_UserProfile(t *testing.T) {
+	at := newAdminTest(t)
+	bearer := at.bearers[1]
+
+	w := serve(at.router, "PATCH", "/v1/users/me", bearer,
+		`{"firstName": "Robert", "timeZone": "Europe/Paris", "notifyExpiring": true, "notifyDaysBefore": 2}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var profile map[string]interface{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &profile))
+	assert.Equal(t, "Robert", profile["FirstName"])
+	assert.Equal(t, "Europe/Paris", profile["TimeZone"])
+	assert.Equal(t, true, profile["NotifyExpiring"])
+	assert.Equal(t, float64(2), profile["NotifyDaysBefore"])
+	assert.NotContains(t, profile, "AccessToken")
+	assert.NotContains(t, profile, "TimeDeletion")
+
+	w = serve(at.router, "GET", "/v1/users/me", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &profile))
+	assert.Equal(t, "Europe/Paris", profile["TimeZone"])
+
+	w = serve(at.router, "PATCH", "/v1/users/me", bearer, `{"timeZone": "Nowhere/Special", "notifyDaysBefore": 0}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var body errorEnvelope
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, 2, len(body.Error.Details))
+}
+
+func TestAPIHandler_V1_UserDeletion(t *testing.T) {
+	at := newAdminTest(t)
+	bob, sam := at.bearers[1], at.bearers[2]
+
+	w := serve(at.router, "POST", "/v1/dishes", bob, `{"storageID": "0", "title": "Carrots", "expireWindow": "P7D"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(at.router, "GET", "/v1/users/me/export", bob, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+	var exported user.Export
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &exported))
+	assert.Equal(t, "nothing@gmail.com", exported.User.Email)
+	if assert.Equal(t, 1, len(exported.Dishes)) {
+		assert.Equal(t, "Carrots", exported.Dishes[0].Title)
+	}
+
+	w = serve(at.router, "DELETE", "/v1/users/me?grace=P1Y", bob, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(at.router, "DELETE", "/v1/users/me?grace=soon", bob, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(at.router, "DELETE", "/v1/users/me/deletion", bob, "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "nothing to cancel")
+
+	w = serve(at.router, "DELETE", "/v1/users/me?grace=P30D", bob, "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var profile map[string]interface{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &profile))
+	assert.Contains(t, profile, "TimeDeletion")
+	w = serve(at.router, "DELETE", "/v1/users/me/deletion", bob, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var kept map[string]interface{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &kept))
+	assert.NotContains(t, kept, "TimeDeletion")
+
+	w = serve(at.router, "DELETE", "/v1/users/me", sam, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(at.router, "GET", "/v1/users/me", sam, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "their sessions went with them")
+	w = serve(at.router, "GET", "/v1/users/me", bob, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
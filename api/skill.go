@@ -0,0 +1,264 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jasonradcliffe/freshness-countdown-api/ask"
+	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/duration"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/session"
+)
+
+//maxSkillRequestBytes bounds an Alexa request body. Real ones are a few kilobytes.
+const maxSkillRequestBytes = 128 * 1024
+
+//defaultExpiringWindow is how far ahead "what is expiring" looks when the user doesn't say.
+const defaultExpiringWindow = "P3D"
+
+//maxSpokenDishes is how many dishes Alexa reads out before saying how many more there are.
+const maxSpokenDishes = 5
+
+//The intents in the skill's interaction model, and the slots they use.
+const (
+	addDishIntent        = "AddDish"
+	whatIsExpiringIntent = "WhatIsExpiring"
+	removeDishIntent     = "RemoveDish"
+
+	dishSlot         = "Dish"
+	expireWindowSlot = "ExpireWindow"
+	storageSlot      = "Storage"
+	windowSlot       = "Window"
+)
+
+//skillIntent answers one of the skill's intents for the user.
+type skillIntent func(h *handler, ctx context.Context, u *userDomain.User, intent ask.Intent) *ask.ResponseEnvelope
+
+var skillIntents = map[string]skillIntent{
+	addDishIntent:         (*handler).skillAddDish,
+	whatIsExpiringIntent:  (*handler).skillWhatIsExpiring,
+	removeDishIntent:      (*handler).skillRemoveDish,
+	"AMAZON.HelpIntent":   (*handler).skillHelp,
+	"AMAZON.StopIntent":   (*handler).skillGoodbye,
+	"AMAZON.CancelIntent": (*handler).skillGoodbye,
+}
+
+//Skill is POST /alexa/skill, the endpoint of the Alexa skill itself. Requests are checked to be signed by Amazon for
+//this skill, then answered in speech. Once a request checks out the answer is always a 200 - Alexa only reads out
+//what the skill says, so errors are said rather than sent as a status.
+func (h *handler) Skill(c *gin.Context) {
+	if h.skillVerifier == nil {
+		abortWithError(c, fcerr.NewNotFoundError("The Alexa skill endpoint is not set up"))
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSkillRequestBytes))
+	if err != nil {
+		abortWithError(c, fcerr.NewBadRequestError("Could not read the Alexa request"))
+		return
+	}
+	envelope, fcErr := h.skillVerifier.Verify(c.Request.Context(), c.Request.Header, body)
+	if fcErr != nil {
+		fmt.Println("refused an Alexa request:", fcErr.Message())
+		abortWithError(c, fcErr)
+		return
+	}
+
+	fmt.Println("got an Alexa", envelope.Request.Type, envelope.Request.Intent.Name)
+	c.JSON(http.StatusOK, h.answerSkill(c.Request.Context(), envelope))
+}
+
+//answerSkill works out what to say to a verified request.
+func (h *handler) answerSkill(ctx context.Context, envelope *ask.RequestEnvelope) *ask.ResponseEnvelope {
+	if envelope.Request.Type == ask.SessionEndedRequest {
+		return ask.Empty()
+	}
+
+	skillUser, fcErr := h.skillUser(ctx, envelope.User())
+	if fcErr == nil && !skillUser.IsActive() {
+		fcErr = fcerr.NewForbiddenError(session.DeactivatedMessage)
+	}
+	if fcErr != nil && fcErr.Status() == http.StatusForbidden {
+		fmt.Println("the Alexa request is from a deactivated user")
+		return ask.Tell("Your Freshness Countdown account has been deactivated.")
+	} else if fcErr != nil {
+		fmt.Println("no user for the Alexa request:", fcErr.Message())
+		return ask.LinkAccount("To keep track of your food, link your Freshness Countdown account in the Alexa app.")
+	}
+
+	switch envelope.Request.Type {
+	case ask.LaunchRequest:
+		return ask.Ask("Welcome to Freshness Countdown. You can add a dish, ask what is expiring, or remove a dish. "+
+			"What would you like to do?", "What would you like to do?")
+	case ask.IntentRequest:
+		answer, ok := skillIntents[envelope.Request.Intent.Name]
+		if !ok {
+			return ask.Ask("Sorry, I can't help with that. "+skillHelpText, "What would you like to do?")
+		}
+		return answer(h, ctx, skillUser, envelope.Request.Intent)
+	}
+	return ask.Empty()
+}
+
+//skillUser finds who the Alexa account belongs to: the access token from account linking if the skill has one, and
+//otherwise the Alexa user id, once it has been linked. The user id is safe to trust here because Amazon signed it.
+func (h *handler) skillUser(ctx context.Context, alexaUser ask.User) (*userDomain.User, fcerr.FCErr) {
+	if alexaUser.AccessToken != "" {
+		tokenUser, fcErr := h.sessionService.Validate(ctx, alexaUser.AccessToken)
+		if fcErr != nil {
+			return nil, fcErr
+		}
+		if h.linkService != nil {
+			if fcErr := h.linkService.Bind(ctx, tokenUser, alexaUser.AccessToken, alexaUser.UserID); fcErr != nil {
+				fmt.Println("did not link the alexa user id to the token's user:", fcErr.Message())
+			}
+		}
+		return tokenUser, nil
+	}
+	if h.linkService == nil {
+		return nil, fcerr.NewNotFoundError("Alexa account linking is not set up")
+	}
+	return h.alexaUser(ctx, alexaUser.UserID)
+}
+
+//skillHelpText is what the skill can do, said for help and after an intent it doesn't know.
+const skillHelpText = "You can say add carrots that last a week, what is expiring this week, or remove the carrots."
+
+//skillHelp answers AMAZON.HelpIntent.
+func (h *handler) skillHelp(ctx context.Context, u *userDomain.User, intent ask.Intent) *ask.ResponseEnvelope {
+	return ask.Ask(skillHelpText+" What would you like to do?", "What would you like to do?")
+}
+
+//skillGoodbye answers AMAZON.StopIntent and AMAZON.CancelIntent.
+func (h *handler) skillGoodbye(ctx context.Context, u *userDomain.User, intent ask.Intent) *ask.ResponseEnvelope {
+	return ask.Tell("Goodbye.")
+}
+
+//skillAddDish adds the dish the user named, expiring after the window they gave, in the storage unit they named or
+//else their first one. Alexa is asked to get a missing dish or window from the user.
+func (h *handler) skillAddDish(ctx context.Context, u *userDomain.User, intent ask.Intent) *ask.ResponseEnvelope {
+	title := strings.TrimSpace(intent.SlotValue(dishSlot))
+	if title == "" {
+		return ask.ElicitSlot("What dish would you like to add?", dishSlot, intent)
+	}
+	window := intent.SlotValue(expireWindowSlot)
+	if window == "" {
+		return ask.ElicitSlot(fmt.Sprintf("How long will the %s last?", ask.Escape(title)), expireWindowSlot, intent)
+	}
+	if _, fcErr := duration.Parse(window); fcErr != nil {
+		return ask.ElicitSlot(fmt.Sprintf("Sorry, I didn't catch that. How long will the %s last?", ask.Escape(title)),
+			expireWindowSlot, intent)
+	}
+
+	storageID, said := 0, ""
+	storages, fcErr := h.storageService.GetAll(ctx, u)
+	if fcErr != nil && !errors.Is(fcErr, fcerr.ErrNotFound) {
+		return skillError(fcErr)
+	}
+	if storageName := strings.TrimSpace(intent.SlotValue(storageSlot)); storageName != "" {
+		found := false
+		if storages != nil {
+			for _, s := range *storages {
+				if strings.EqualFold(s.Title, storageName) {
+					storageID, said, found = s.PersonalID, " to the "+ask.Escape(s.Title), true
+					break
+				}
+			}
+		}
+		if !found {
+			return ask.Tell(fmt.Sprintf("I couldn't find a storage unit called %s.", ask.Escape(storageName)))
+		}
+	} else if storages != nil && len(*storages) > 0 {
+		storageID = (*storages)[0].PersonalID
+	}
+
+	created, fcErr := h.dishService.Create(ctx, u, &dishDomain.Dish{StorageID: storageID, Title: title}, window)
+	if fcErr != nil {
+		return skillError(fcErr)
+	}
+	return ask.Tell(fmt.Sprintf("I added the %s%s. It %s.", ask.Escape(title), said, speakExpiry(*created, time.Now())))
+}
+
+//skillWhatIsExpiring reads out the user's dishes that expire within the window they gave, or the next few days,
+//soonest first. Dishes that have already expired come first.
+func (h *handler) skillWhatIsExpiring(ctx context.Context, u *userDomain.User, intent ask.Intent) *ask.ResponseEnvelope {
+	spokenWindow := intent.SlotValue(windowSlot)
+	if spokenWindow == "" {
+		spokenWindow = defaultExpiringWindow
+	}
+	window, fcErr := duration.Parse(spokenWindow)
+	if fcErr != nil {
+		return ask.Ask("Sorry, I didn't catch how far ahead to look. You can say what is expiring this week.",
+			"What would you like to do?")
+	}
+
+	now := time.Now()
+	expiring, fcErr := h.dishService.GetExpiredByDate(ctx, u, window.AddTo(now).Format(time.RFC3339))
+	if fcErr != nil && !errors.Is(fcErr, fcerr.ErrNotFound) {
+		return skillError(fcErr)
+	}
+	if expiring == nil || len(*expiring) == 0 {
+		return ask.Tell("Nothing is expiring. Everything is fresh.")
+	}
+
+	dishes := *expiring
+	sort.SliceStable(dishes, func(i, j int) bool { return dishes[i].ExpireDate.Before(dishes[j].ExpireDate) })
+	var sentences []string
+	for i, d := range dishes {
+		if i == maxSpokenDishes {
+			sentences = append(sentences, fmt.Sprintf("And %d more.", len(dishes)-maxSpokenDishes))
+			break
+		}
+		sentences = append(sentences, fmt.Sprintf("The %s %s.", ask.Escape(d.Title), speakExpiry(d, now)))
+	}
+	return ask.Tell(strings.Join(sentences, " "))
+}
+
+//skillRemoveDish removes the dish the user named. If they have more than one by that name, the one that expires first goes.
+//Whether it was eaten or wasted is told from whether it had expired.
+func (h *handler) skillRemoveDish(ctx context.Context, u *userDomain.User, intent ask.Intent) *ask.ResponseEnvelope {
+	title := strings.TrimSpace(intent.SlotValue(dishSlot))
+	if title == "" {
+		return ask.ElicitSlot("Which dish would you like to remove?", dishSlot, intent)
+	}
+
+	dishes, fcErr := h.dishService.GetAll(ctx, u)
+	if fcErr != nil && !errors.Is(fcErr, fcerr.ErrNotFound) {
+		return skillError(fcErr)
+	}
+	var found *dishDomain.Dish
+	if dishes != nil {
+		for i, d := range *dishes {
+			if strings.EqualFold(d.Title, title) && (found == nil || d.ExpireDate.Before(found.ExpireDate)) {
+				found = &(*dishes)[i]
+			}
+		}
+	}
+	if found == nil {
+		return ask.Tell(fmt.Sprintf("I couldn't find a dish called %s.", ask.Escape(title)))
+	}
+
+	if fcErr := h.dishService.Delete(ctx, u, found.PersonalDishID, ""); fcErr != nil {
+		return skillError(fcErr)
+	}
+	return ask.Tell(fmt.Sprintf("I removed the %s.", ask.Escape(found.Title)))
+}
+
+//skillError says something went wrong, without the details a client would get.
+func skillError(fcErr fcerr.FCErr) *ask.ResponseEnvelope {
+	fmt.Println("the Alexa request failed:", fcErr.Message())
+	return ask.Tell("Sorry, something went wrong. Please try again later.")
+}
+
+//speakExpiry says when a dish expires, in days from now to the nearest day: "expires in 3 days", "expired yesterday".
+func speakExpiry(d dishDomain.Dish, now time.Time) string {
+	return d.ExpiresIn(now)
+}
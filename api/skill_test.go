@@ -0,0 +1,227 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jasonradcliffe/freshness-countdown-api/ask"
+	"github.com/jasonradcliffe/freshness-countdown-api/ask/asktest"
+	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/identity"
+	sessionDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/session"
+	storageDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	dbrepo "github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/link"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/session"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/storage"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/user"
+	"github.com/stretchr/testify/assert"
+)
+
+//The skill and Alexa account the recorded requests in testdata/ask are from.
+const fixtureSkillID = "amzn1.ask.skill.5c0c2e3a-8f1d-4b7e-9a63-2f6e1d0b7c44"
+const fixtureAlexaUserID = "amzn1.ask.account.AGF7EXAMPLEUSERID4QJ3YQX5RBQ"
+
+//skillTest is the skill endpoint over a memory repository, with a user whose Alexa account is the fixtures' and who
+//has a storage unit called Fridge.
+type skillTest struct {
+	router   *gin.Engine
+	amazon   *asktest.Amazon
+	repo     dbrepo.Repository
+	sessions session.Service
+	user     *userDomain.User
+}
+
+func newSkillTest(t *testing.T) *skillTest {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	repo := dbrepo.NewMemoryRepository()
+	sessions := session.NewService(repo, time.Hour)
+	amazon := asktest.New(asktest.Options{})
+
+	skillUser, _ := repo.CreateUser(ctx, userDomain.User{Email: "nothing@gmail.com", FirstName: "Bob"})
+	repo.CreateIdentity(ctx, identity.Identity{UserID: skillUser.UserID, Provider: identity.Alexa,
+		ExternalID: fixtureAlexaUserID, RefreshTokenHash: sessionDomain.HashToken("fcr_fixture")})
+	storage.NewService(repo).Create(ctx, skillUser, &storageDomain.Storage{Title: "Fridge"})
+
+	h := NewHandler(dish.NewService(repo), storage.NewService(repo), user.NewService(repo), household.NewService(repo),
+		sessions, link.NewService(repo, sessions, testSkill), nil, nil, nil, ask.NewVerifier(fixtureSkillID, amazon.Client, amazon.Roots), nil)
+	router := gin.New()
+	router.Use(ErrorHandler())
+	router.POST("/alexa/skill", h.Skill)
+	return &skillTest{router: router, amazon: amazon, repo: repo, sessions: sessions, user: skillUser}
+}
+
+//fixture reads a recorded request from testdata/ask and moves it to now, so it can be replayed.
+func (st *skillTest) fixture(t *testing.T, name string) []byte {
+	recorded, err := os.ReadFile(filepath.Join("testdata", "ask", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return asktest.Restamp(recorded, time.Now())
+}
+
+//post sends body to the skill endpoint signed with headers, and gives back the response.
+func (st *skillTest) post(body []byte, headers http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/alexa/skill", bytes.NewReader(body))
+	req.Header = headers
+	w := httptest.NewRecorder()
+	st.router.ServeHTTP(w, req)
+	return w
+}
+
+//ask sends the body signed like Alexa does and gives back what the skill answered.
+func (st *skillTest) ask(t *testing.T, body []byte) ask.Response {
+	w := st.post(body, st.amazon.Sign(body))
+	if !assert.Equal(t, http.StatusOK, w.Code) {
+		t.FailNow()
+	}
+	var envelope ask.ResponseEnvelope
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	assert.Equal(t, "1.0", envelope.Version)
+	return envelope.Response
+}
+
+//said is the SSML the skill answered with.
+func said(response ask.Response) string {
+	if response.OutputSpeech == nil {
+		return ""
+	}
+	return response.OutputSpeech.SSML
+}
+
+func TestAPIHandler_Skill(t *testing.T) {
+	st := newSkillTest(t)
+
+	response := st.ask(t, st.fixture(t, "launch.json"))
+	assert.Contains(t, said(response), "Welcome to Freshness Countdown")
+	assert.False(t, response.ShouldEndSession)
+	assert.NotNil(t, response.Reprompt)
+
+	response = st.ask(t, st.fixture(t, "add_dish.json"))
+	assert.Equal(t, "<speak>I added the carrots to the Fridge. It expires in 7 days.</speak>", said(response))
+	assert.True(t, response.ShouldEndSession)
+	home, _ := household.Membership(context.Background(), st.repo, st.user)
+	dishes, _ := st.repo.GetDishes(context.Background(), home.HouseholdID)
+	if assert.Equal(t, 1, len(*dishes)) {
+		assert.Equal(t, "carrots", (*dishes)[0].Title)
+		assert.Equal(t, 1, (*dishes)[0].StorageID)
+	}
+
+	response = st.ask(t, st.fixture(t, "what_is_expiring.json"))
+	assert.Equal(t, "<speak>Nothing is expiring. Everything is fresh.</speak>", said(response))
+
+	dish.NewService(st.repo).Create(context.Background(), st.user, &dishDomain.Dish{Title: "Soup & Bread"}, "P1D")
+	response = st.ask(t, st.fixture(t, "what_is_expiring.json"))
+	assert.Equal(t, "<speak>The Soup &amp; Bread expires tomorrow.</speak>", said(response))
+
+	response = st.ask(t, st.fixture(t, "remove_dish.json"))
+	assert.Equal(t, "<speak>I removed the carrots.</speak>", said(response))
+	response = st.ask(t, st.fixture(t, "remove_dish.json"))
+	assert.Equal(t, "<speak>I couldn't find a dish called Carrots.</speak>", said(response))
+
+	response = st.ask(t, st.fixture(t, "help.json"))
+	assert.Contains(t, said(response), "You can say")
+	assert.False(t, response.ShouldEndSession)
+
+	response = st.ask(t, st.fixture(t, "session_ended.json"))
+	assert.Nil(t, response.OutputSpeech)
+}
+
+func TestAPIHandler_Skill_ElicitsMissingSlots(t *testing.T) {
+	st := newSkillTest(t)
+
+	response := st.ask(t, st.fixture(t, "add_dish_no_window.json"))
+	assert.Equal(t, "<speak>How long will the mac &amp; cheese last?</speak>", said(response))
+	if assert.Equal(t, 1, len(response.Directives)) {
+		assert.Equal(t, "Dialog.ElicitSlot", response.Directives[0].Type)
+		assert.Equal(t, "ExpireWindow", response.Directives[0].SlotToElicit)
+		assert.Equal(t, "mac & cheese", response.Directives[0].UpdatedIntent.SlotValue("Dish"))
+	}
+	assert.False(t, response.ShouldEndSession)
+
+	home, _ := household.Membership(context.Background(), st.repo, st.user)
+	_, fcErr := st.repo.GetDishes(context.Background(), home.HouseholdID)
+	assert.NotNil(t, fcErr, "nothing is added until the window is given")
+}
+
+func TestAPIHandler_Skill_AccountLinking(t *testing.T) {
+	st := newSkillTest(t)
+
+	//an Alexa account that was never linked is asked to link
+	stranger := bytes.ReplaceAll(st.fixture(t, "launch.json"), []byte(fixtureAlexaUserID), []byte("amzn1.ask.account.STRANGER"))
+	response := st.ask(t, stranger)
+	if assert.NotNil(t, response.Card) {
+		assert.Equal(t, "LinkAccount", response.Card.Type)
+	}
+	assert.True(t, response.ShouldEndSession)
+
+	//the access token from account linking is used when Alexa sends one
+	token, _, _ := st.sessions.Create(context.Background(), st.user)
+	withToken := bytes.ReplaceAll(stranger, []byte(`"userId":"amzn1.ask.account.STRANGER"`),
+		[]byte(`"userId":"amzn1.ask.account.STRANGER","accessToken":"`+token+`"`))
+	response = st.ask(t, withToken)
+	assert.Nil(t, response.Card)
+	assert.Contains(t, said(response), "Welcome")
+
+	//and a token that was logged out has to be linked again
+	st.sessions.Revoke(context.Background(), token)
+	response = st.ask(t, withToken)
+	if assert.NotNil(t, response.Card) {
+		assert.Equal(t, "LinkAccount", response.Card.Type)
+	}
+}
+
+func TestAPIHandler_Skill_Refused(t *testing.T) {
+	st := newSkillTest(t)
+	body := st.fixture(t, "launch.json")
+
+	w := st.post(body, http.Header{"Content-Type": {"application/json"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "not signed")
+
+	w = st.post([]byte(strings.Replace(string(body), "LaunchRequest", "IntentRequest", 1)), st.amazon.Sign(body))
+	assert.Equal(t, http.StatusBadRequest, w.Code, "changed after it was signed")
+
+	recorded, _ := os.ReadFile(filepath.Join("testdata", "ask", "launch.json"))
+	w = st.post(recorded, st.amazon.Sign(recorded))
+	assert.Equal(t, http.StatusBadRequest, w.Code, "replayed as recorded, long after its timestamp")
+
+	off := NewHandler(nil, nil, nil, nil, st.sessions, nil, nil, nil, nil, nil, nil)
+	router := gin.New()
+	router.Use(ErrorHandler())
+	router.POST("/alexa/skill", off.Skill)
+	req := httptest.NewRequest("POST", "/alexa/skill", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPIHandler_speakExpiry(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expires time.Time
+		said    string
+	}{
+		{now.Add(2 * time.Hour), "expires today"},
+		{now.Add(24 * time.Hour), "expires tomorrow"},
+		{now.Add(7*24*time.Hour - time.Minute), "expires in 7 days"},
+		{now.Add(-time.Hour), "expired today"},
+		{now.Add(-26 * time.Hour), "expired yesterday"},
+		{now.Add(-3 * 24 * time.Hour), "expired 3 days ago"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.said, speakExpiry(dishDomain.Dish{ExpireDate: tt.expires}, now), tt.expires)
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/stream"
+)
+
+//EventsPath is the route StreamEvents is mapped to. It streams for as long as the client stays, so it is left out of
+//RequestTimeout.
+const EventsPath = "/v1/events"
+
+//ResetEvent is sent first when the Last-Event-ID can't be resumed from, so the client should load everything again.
+const ResetEvent = "reset"
+
+//streamKeepAlive is how often a comment is sent on a quiet stream, so proxies don't close it.
+const streamKeepAlive = 25 * time.Second
+
+//StreamEvents is GET /v1/events - a text/event-stream of the dishes and storage units in the user's household being
+//created, updated and deleted, and of dishes expiring, as it happens. Each event's name is its Type and its data the
+//event as JSON. A client reconnecting with the Last-Event-ID header gets what it missed first, up to stream.LogSize
+//events, or a ResetEvent if it missed more than that.
+func (h *handler) StreamEvents(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	if h.streamService == nil {
+		abortWithError(c, fcerr.NewNotFoundError("The event stream is not set up"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	st, fcErr := h.streamService.Follow(ctx, requestUser, c.GetHeader("Last-Event-ID"))
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	defer st.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	if st.Reset {
+		c.Render(-1, sse.Event{Event: ResetEvent, Data: "Some events were missed, load everything again"})
+	}
+	for _, entry := range st.Missed {
+		renderEntry(c, entry)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case entry, ok := <-st.Events:
+			if !ok {
+				//it fell behind - the client reconnects and picks up from the log
+				return
+			}
+			renderEntry(c, entry)
+		case <-keepAlive.C:
+			c.Writer.WriteString(":\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+//renderEntry writes one event to the stream.
+func renderEntry(c *gin.Context, entry stream.Entry) {
+	c.Render(-1, sse.Event{Event: entry.Event.Type, Id: entry.ID, Data: entry.Event})
+}
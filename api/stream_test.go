@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/event"
+	"github.com/stretchr/testify/assert"
+)
+
+//sseEvent is one event read off a text/event-stream.
+type sseEvent struct {
+	id   string
+	name string
+	data string
+}
+
+//follow opens the event stream, resuming after lastEventID if it isn't "", and gives the events read off it.
+func follow(t *testing.T, server *httptest.Server, lastEventID string) (*http.Response, <-chan sseEvent) {
+	req, _ := http.NewRequest("GET", server.URL+EventsPath, nil)
+	req.Header.Set("Authorization", "Bearer "+rUser.AccessToken)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		var current sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if current.name != "" {
+					events <- current
+				}
+				current = sseEvent{}
+			case strings.HasPrefix(line, "id:"):
+				current.id = strings.TrimPrefix(line, "id:")
+			case strings.HasPrefix(line, "event:"):
+				current.name = strings.TrimPrefix(line, "event:")
+			case strings.HasPrefix(line, "data:"):
+				current.data = strings.TrimPrefix(line, "data:")
+			}
+		}
+	}()
+	return resp, events
+}
+
+//nextEvent is the next event off the stream, failing the test if there isn't one within a second.
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("the stream ended")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event came")
+	}
+	return sseEvent{}
+}
+
+func TestAPIHandler_V1_StreamEvents(t *testing.T) {
+	router := testRouter()
+	server := httptest.NewServer(router)
+	defer server.Close()
+	bearer := "Bearer " + rUser.AccessToken
+
+	w := serve(router, "GET", EventsPath, "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	resp, events := follow(t, server, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	w = serve(router, "POST", "/v1/dishes", bearer, `{"storageID": "3", "title": "Carrots", "expireWindow": "P7D"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	created := nextEvent(t, events)
+	assert.Equal(t, event.DishCreated, created.name)
+	var e event.Event
+	assert.Nil(t, json.Unmarshal([]byte(created.data), &e))
+	assert.Equal(t, rUser.UserID, e.UserID)
+	assert.Contains(t, created.data, "Carrots")
+	resp.Body.Close()
+
+	w = serve(router, "POST", "/v1/storage", bearer, `{"title": "Fridge"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(router, "DELETE", "/v1/dishes/1", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	resp, events = follow(t, server, created.id)
+	assert.Equal(t, event.StorageCreated, nextEvent(t, events).name, "what was missed comes first")
+	assert.Equal(t, event.DishDeleted, nextEvent(t, events).name)
+	resp.Body.Close()
+
+	resp, events = follow(t, server, "from-before-a-restart")
+	assert.Equal(t, ResetEvent, nextEvent(t, events).name)
+	resp.Body.Close()
+}
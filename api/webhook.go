@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/webhook"
+)
+
+//GetWebhooks is GET /v1/webhooks - the URLs the user has the events in their household posted to.
+func (h *handler) GetWebhooks(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	subscriptions, fcErr := h.webhookService.GetAll(c.Request.Context(), requestUser)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(subscriptions)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the webhooks"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
+
+//CreateWebhook is POST /v1/webhooks, with the url and optionally the events to post to it - all of them if none are
+//given. The new webhook's Secret, which signs every delivery, is only shown here.
+func (h *handler) CreateWebhook(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	subscription, fcErr := h.webhookService.Create(c.Request.Context(), requestUser, aR.URL, aR.Events)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(subscription)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the webhook"))
+		return
+	}
+	respond(c, http.StatusCreated, marshaled)
+}
+
+//GetWebhook is GET /v1/webhooks/:id.
+func (h *handler) GetWebhook(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	id, ok := personalID(c)
+	if !ok {
+		return
+	}
+
+	subscription, fcErr := h.webhookService.GetByID(c.Request.Context(), requestUser, id)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(subscription)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the webhook"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
+
+//UpdateWebhook is PATCH /v1/webhooks/:id, with whichever of the url, events and active are changing. An empty list of
+//events posts every event to it.
+func (h *handler) UpdateWebhook(c *gin.Context) {
+	aR, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	id, ok := personalID(c)
+	if !ok {
+		return
+	}
+
+	changes := webhook.Changes{Events: aR.Events, Active: aR.Active}
+	if aR.URL != "" {
+		changes.URL = &aR.URL
+	}
+	subscription, fcErr := h.webhookService.Update(c.Request.Context(), requestUser, id, changes)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(subscription)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the webhook"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
+
+//DeleteWebhook is DELETE /v1/webhooks/:id. Its delivery log goes with it.
+func (h *handler) DeleteWebhook(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	id, ok := personalID(c)
+	if !ok {
+		return
+	}
+
+	if fcErr := h.webhookService.Delete(c.Request.Context(), requestUser, id); fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	respondMessage(c, http.StatusOK, "The webhook has been deleted.")
+}
+
+//GetWebhookDeliveries is GET /v1/webhooks/:id/deliveries - the latest events posted, or to be posted, to the webhook,
+//newest first, with how each attempt went.
+func (h *handler) GetWebhookDeliveries(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	id, ok := personalID(c)
+	if !ok {
+		return
+	}
+
+	deliveries, fcErr := h.webhookService.GetDeliveries(c.Request.Context(), requestUser, id)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(deliveries)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the webhook deliveries"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
+
+//GetDeadLetters is GET /v1/webhooks/deadletters - the latest deliveries to any of the user's webhooks that were given
+//up on, newest first.
+func (h *handler) GetDeadLetters(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+
+	deliveries, fcErr := h.webhookService.GetDeadLetters(c.Request.Context(), requestUser)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(deliveries)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the webhook deliveries"))
+		return
+	}
+	respond(c, http.StatusOK, marshaled)
+}
+
+//RedeliverWebhook is POST /v1/webhooks/deliveries/:id/redeliver, where id is the delivery's id. It queues a delivery
+//that was given up on, or already delivered, to be posted again straight away.
+func (h *handler) RedeliverWebhook(c *gin.Context) {
+	_, requestUser, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	id, ok := personalID(c)
+	if !ok {
+		return
+	}
+
+	delivery, fcErr := h.webhookService.Redeliver(c.Request.Context(), requestUser, id)
+	if fcErr != nil {
+		abortWithError(c, fcErr)
+		return
+	}
+	marshaled, err := json.Marshal(delivery)
+	if err != nil {
+		abortWithError(c, fcerr.NewInternalServerError("Could not marshal the webhook delivery"))
+		return
+	}
+	respond(c, http.StatusAccepted, marshaled)
+}
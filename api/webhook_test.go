@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/event"
+	webhookDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIHandler_V1_Webhooks(t *testing.T) {
+	router := testRouter()
+	bearer := "Bearer " + rUser.AccessToken
+
+	w := serve(router, "POST", "/v1/webhooks", bearer, `{"url": "ftp://homeassistant.local/fc"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, "POST", "/v1/webhooks", bearer, `{"url": "https://homeassistant.local/api/webhook/fc", "events": ["dish.created"]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created webhookDomain.Subscription
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEqual(t, "", created.Secret)
+	path := "/v1/webhooks/" + strconv.Itoa(created.SubscriptionID)
+
+	w = serve(router, "GET", path, bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), created.Secret, "the secret is only shown when the webhook is created")
+
+	w = serve(router, "POST", "/v1/dishes", bearer, `{"storageID": "3", "title": "Carrots", "expireWindow": "P7D"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(router, "POST", "/v1/storage", bearer, `{"title": "Fridge"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(router, "GET", path+"/deliveries", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var deliveries webhookDomain.Deliveries
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &deliveries))
+	if assert.Equal(t, 1, len(deliveries), "only the dish being created was wanted") {
+		assert.Equal(t, event.DishCreated, deliveries[0].EventType)
+		assert.Equal(t, webhookDomain.Pending, deliveries[0].Status)
+		assert.Contains(t, deliveries[0].Payload, "Carrots")
+
+		w = serve(router, "POST", "/v1/webhooks/deliveries/"+strconv.Itoa(deliveries[0].DeliveryID)+"/redeliver", bearer, "")
+		assert.Equal(t, http.StatusConflict, w.Code, "it hasn't been tried yet")
+	}
+
+	w = serve(router, "PATCH", path, bearer, `{"active": false}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var updated webhookDomain.Subscription
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.False(t, updated.Active)
+	assert.Equal(t, []string{event.DishCreated}, updated.Events)
+
+	w = serve(router, "GET", "/v1/webhooks/deadletters", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[]", w.Body.String())
+
+	w = serve(router, "DELETE", path, bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, "GET", path+"/deliveries", bearer, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(router, "GET", "/v1/webhooks", bearer, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[]", w.Body.String())
+}
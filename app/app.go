@@ -1,30 +1,50 @@
 package app
 
 import (
-	"crypto/rand"
+	"context"
 	"crypto/tls"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jasonradcliffe/freshness-countdown-api/api"
+	"github.com/jasonradcliffe/freshness-countdown-api/ask"
+	"github.com/jasonradcliffe/freshness-countdown-api/clock"
+	"github.com/jasonradcliffe/freshness-countdown-api/eventbus"
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc"
 	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/secret"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/digest"
 	"github.com/jasonradcliffe/freshness-countdown-api/services/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/link"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/notify"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/session"
 	"github.com/jasonradcliffe/freshness-countdown-api/services/storage"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/stream"
 	"github.com/jasonradcliffe/freshness-countdown-api/services/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/webhook"
 
 	"github.com/gin-gonic/gin"
-	domainUser "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
 type appConfig struct {
-	DBConfig   string `json:"dbCon"`
-	CertConfig struct {
+	DBDriver              string `json:"dbDriver"`
+	DBConfig              string `json:"dbCon"`
+	RequestTimeoutSeconds int    `json:"requestTimeoutSeconds"`
+	SessionTTLHours       int    `json:"sessionTTLHours"`
+	TokenCacheSeconds     int    `json:"tokenCacheSeconds"`
+	TokenCacheSize        int    `json:"tokenCacheSize"`
+	TokenEncryptionKey    string `json:"tokenEncryptionKey"`
+	PublicURL             string `json:"publicURL"`
+	WebhookPrivateNets    bool   `json:"webhookPrivateNetworks"`
+	CertConfig            struct {
 		Fullchain string `json:"fullchain"`
 		PrivKey   string `json:"privkey"`
 	} `json:"certconfigs"`
@@ -32,13 +52,39 @@ type appConfig struct {
 		ClientID     string `json:"clientid"`
 		ClientSecret string `json:"clientsecret"`
 	} `json:"oauthconfigs"`
+	IdentityProviders []oidc.Config `json:"identityProviders"`
+	AlexaLinking      link.Client   `json:"alexaLinking"`
+	AlexaSkillID      string        `json:"alexaSkillID"`
+	Notifications     struct {
+		IntervalMinutes int                  `json:"intervalMinutes"`
+		SMTP            notify.SMTPConfig    `json:"smtp"`
+		Webhook         notify.WebhookConfig `json:"webhook"`
+		AlexaEvents     notify.AlexaConfig   `json:"alexaEvents"`
+	} `json:"notifications"`
 }
 
+//defaultRequestTimeout is used when the config file doesn't set requestTimeoutSeconds.
+const defaultRequestTimeout = 10 * time.Second
+
+//accountErasureInterval is how often accounts whose deletion grace period is over are looked for and erased.
+const accountErasureInterval = time.Hour
+
+//expiryCheckInterval is how often dishes that have just expired are looked for, to publish their events.
+const expiryCheckInterval = time.Minute
+
+//webhookDeliveryInterval is how often webhook deliveries that are due to be tried again are looked for. New events are
+//delivered straight away.
+const webhookDeliveryInterval = time.Minute
+
+//defaultNotificationInterval is how often users are looked for to tell about food expiring, when the config file doesn't
+//set notifications.intervalMinutes.
+const defaultNotificationInterval = 15 * time.Minute
+
+//defaultPublicURL is where the api is reached when the config file doesn't set publicURL.
+const defaultPublicURL = "https://fcapi.jasonradcliffe.com"
+
 //Config contins all the initial configuration info for this software
 var config appConfig
-var oauthconfig *oauth2.Config
-var oauthstate string
-var currentUser domainUser.OauthUser
 var apiHandler api.Handler
 var router = gin.Default()
 
@@ -51,34 +97,41 @@ func init() {
 	if err != nil {
 		log.Fatalln("got an err during json.unmarshal of config" + err.Error())
 	}
-	oauthconfig = &oauth2.Config{
-		ClientID:     config.OAuthConfig.ClientID,
-		ClientSecret: config.OAuthConfig.ClientSecret,
-		RedirectURL:  "https://fcapi.jasonradcliffe.com/success",
-		Scopes: []string{
-			"https://www.googleapis.com/auth/userinfo.profile",
-			"https://www.googleapis.com/auth/userinfo.email",
-			"openid",
-		},
-		Endpoint: google.Endpoint,
-	}
-
 }
 
 //StartApplication is called by main.go and starts the app.
 func StartApplication() {
 
-	repo, err := db.NewRepository(config.DBConfig)
+	repo, err := db.NewRepositoryWithDriver(config.DBDriver, config.DBConfig)
 	if err != nil {
 		log.Fatalln("StartApplication() could not create the repo")
 	}
+	repo = sealTokens(repo)
 
-	ds := dish.NewService(repo)
-	ss := storage.NewService(repo)
-	us := user.NewService(repo)
+	bus := eventbus.New()
+	ds := dish.NewServiceWithEvents(repo, bus)
+	ss := storage.NewServiceWithEvents(repo, bus)
+	hs := household.NewService(repo)
+	providers := identityProviders()
+	us := user.NewServiceWithProviders(repo, providers, tokenCacheTTL(), tokenCacheSize())
+	sessions := session.NewService(repo, sessionTTL())
+	links := link.NewService(repo, sessions, alexaLinking())
+	digests := digest.NewService(ds, ss, clock.System())
+	webhooks := webhook.NewService(repo, clock.System(), webhook.NewClient(config.WebhookPrivateNets))
+	bus.Subscribe(webhooks.Enqueue)
+	streams := stream.NewService(repo)
+	bus.Subscribe(streams.Record)
 
-	apiHandler = api.NewHandler(ds, ss, us, oauthconfig)
+	apiHandler = api.NewHandler(ds, ss, us, hs, sessions, links, digests, webhooks, streams, skillVerifier(), providers)
+	go eraseDueAccounts(us)
+	go publishExpired(ds)
+	go webhooks.Run(context.Background(), webhookDeliveryInterval)
+	if ns := notifiers(repo); len(ns) > 0 {
+		go notify.NewService(repo, clock.System(), ns...).Run(context.Background(), notificationInterval())
+	}
 
+	router.Use(api.ErrorHandler())
+	router.Use(api.RequestTimeout(requestTimeout(), api.EventsPath))
 	mapRoutes()
 
 	//Server Setup and Config--------------------------------------------------
@@ -104,6 +157,244 @@ func StartApplication() {
 
 }
 
+//RunMigrations is called by main.go for the migrate subcommand: "up" (the default), "down [steps]" or "status".
+func RunMigrations(args []string) {
+	driver := config.DBDriver
+	if driver == "" {
+		driver = db.MySQLDriver
+	}
+	database, fcErr := db.OpenDatabase(driver, config.DBConfig)
+	if fcErr != nil {
+		log.Fatalln("RunMigrations() could not open the database:", fcErr.Message())
+	}
+	defer database.Close()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		fcErr = db.Migrate(database, driver)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalln("migrate down expects a positive number of steps, got:", args[1])
+			}
+		}
+		fcErr = db.MigrateDown(database, driver, steps)
+	case "status":
+		var applied []int
+		applied, fcErr = db.AppliedMigrations(database)
+		if fcErr == nil {
+			migrations, fcErr := db.Migrations()
+			if fcErr != nil {
+				log.Fatalln(fcErr.Message())
+			}
+			isApplied := make(map[int]bool)
+			for _, version := range applied {
+				isApplied[version] = true
+			}
+			for _, m := range migrations {
+				state := "pending"
+				if isApplied[m.Version] {
+					state = "applied"
+				}
+				fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, state)
+			}
+		}
+	default:
+		log.Fatalln("unknown migrate command:", command, "(expected up, down [steps] or status)")
+	}
+
+	if fcErr != nil {
+		log.Fatalln("migrate", command, "failed:", fcErr.Message())
+	}
+}
+
+//requestTimeout is the per-request deadline from the config file.
+func requestTimeout() time.Duration {
+	if config.RequestTimeoutSeconds <= 0 {
+		return defaultRequestTimeout
+	}
+	return time.Duration(config.RequestTimeoutSeconds) * time.Second
+}
+
+//sessionTTL is how long a login lasts, from the config file. 0 leaves it to session.DefaultTTL.
+func sessionTTL() time.Duration {
+	return time.Duration(config.SessionTTLHours) * time.Hour
+}
+
+//tokenCacheTTL is how long a Google access token is trusted before asking Google again, from the config file.
+//0 leaves it to user.DefaultTokenCacheTTL and a negative number turns the cache off.
+func tokenCacheTTL() time.Duration {
+	if config.TokenCacheSeconds == 0 {
+		return user.DefaultTokenCacheTTL
+	}
+	return time.Duration(config.TokenCacheSeconds) * time.Second
+}
+
+//tokenCacheSize is how many access tokens are remembered, from the config file. 0 leaves it to user.DefaultTokenCacheSize.
+func tokenCacheSize() int {
+	if config.TokenCacheSize == 0 {
+		return user.DefaultTokenCacheSize
+	}
+	return config.TokenCacheSize
+}
+
+//identityProviders discovers each identity provider in the config file. With none listed, users sign in with Google
+//using the oauthconfigs client.
+func identityProviders() *oidc.Providers {
+	configs := config.IdentityProviders
+	if len(configs) == 0 {
+		configs = []oidc.Config{{
+			Name:         "google",
+			DisplayName:  "Google",
+			Issuer:       oidc.GoogleIssuer,
+			ClientID:     config.OAuthConfig.ClientID,
+			ClientSecret: config.OAuthConfig.ClientSecret,
+		}}
+	}
+
+	publicURL := strings.TrimSuffix(config.PublicURL, "/")
+	if publicURL == "" {
+		publicURL = defaultPublicURL
+	}
+
+	var discovered []*oidc.Provider
+	for _, providerConfig := range configs {
+		if providerConfig.RedirectURL == "" {
+			providerConfig.RedirectURL = publicURL + "/success"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout())
+		provider, fcErr := oidc.Discover(ctx, providerConfig, nil)
+		cancel()
+		if fcErr != nil {
+			log.Fatalln("StartApplication() could not set up the identity provider", providerConfig.Name+":", fcErr.Message())
+		}
+		discovered = append(discovered, provider)
+	}
+
+	providers, fcErr := oidc.NewProviders(discovered...)
+	if fcErr != nil {
+		log.Fatalln("StartApplication() could not set up the identity providers:", fcErr.Message())
+	}
+	return providers
+}
+
+//alexaLinking is the Alexa skill's account linking client from the config file. Without a client id, account linking is off.
+func alexaLinking() link.Client {
+	client := config.AlexaLinking
+	if client.ID == "" {
+		fmt.Println("no alexaLinking client in the config file, Alexa account linking is turned off")
+		return client
+	}
+	if client.Secret == "" || len(client.RedirectURIs) == 0 {
+		log.Fatalln("StartApplication() needs a clientsecret and redirectURIs for alexaLinking")
+	}
+	for _, redirectURI := range client.RedirectURIs {
+		if parsed, err := url.Parse(redirectURI); err != nil || parsed.Scheme != "https" {
+			log.Fatalln("StartApplication() needs https redirectURIs for alexaLinking, not", redirectURI)
+		}
+	}
+	return client
+}
+
+//skillVerifier checks requests to the Alexa skill's endpoint are for the skill in the config file. Without a skill id,
+//the endpoint is off.
+func skillVerifier() *ask.Verifier {
+	if config.AlexaSkillID == "" {
+		fmt.Println("no alexaSkillID in the config file, the Alexa skill endpoint is turned off")
+		return nil
+	}
+	return ask.NewVerifier(config.AlexaSkillID, nil, nil)
+}
+
+//notificationInterval is how often users are told about food expiring, from the config file.
+func notificationInterval() time.Duration {
+	if config.Notifications.IntervalMinutes <= 0 {
+		return defaultNotificationInterval
+	}
+	return time.Duration(config.Notifications.IntervalMinutes) * time.Minute
+}
+
+//notifiers are the ways of telling users about food expiring that the config file sets up: email with an smtp host,
+//a webhook with a url, and Alexa with a clientid for the skill's proactive events.
+func notifiers(repo db.Repository) []notify.Notifier {
+	var ns []notify.Notifier
+	if config.Notifications.SMTP.Host != "" {
+		if config.Notifications.SMTP.From == "" {
+			log.Fatalln("StartApplication() needs a from address for the notifications smtp server")
+		}
+		ns = append(ns, notify.NewEmailNotifier(config.Notifications.SMTP))
+	}
+	if config.Notifications.Webhook.URL != "" {
+		ns = append(ns, notify.NewWebhookNotifier(config.Notifications.Webhook, nil))
+	}
+	if config.Notifications.AlexaEvents.ClientID != "" {
+		ns = append(ns, notify.NewAlexaNotifier(repo, config.Notifications.AlexaEvents, nil))
+	}
+	if len(ns) == 0 {
+		fmt.Println("no notifications in the config file, expiry notifications are turned off")
+	}
+	return ns
+}
+
+//eraseDueAccounts erases the accounts whose deletion grace period is over, once at startup and then every
+//accountErasureInterval for as long as the app runs.
+func eraseDueAccounts(us user.Service) {
+	ticker := time.NewTicker(accountErasureInterval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), accountErasureInterval/2)
+		erased, fcErr := us.EraseDue(ctx, time.Now())
+		cancel()
+		if fcErr != nil {
+			fmt.Println("could not erase the accounts due for deletion:", fcErr.Message())
+		} else if erased > 0 {
+			fmt.Println("erased", erased, "accounts whose deletion grace period was over")
+		}
+		<-ticker.C
+	}
+}
+
+//publishExpired publishes an event for each dish as it expires, checking every expiryCheckInterval for as long as the
+//app runs. Dishes that expired while the app was down aren't published when it starts again.
+func publishExpired(ds dish.Service) {
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for range ticker.C {
+		now := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), expiryCheckInterval/2)
+		_, fcErr := ds.PublishExpired(ctx, last, now)
+		cancel()
+		if fcErr != nil {
+			fmt.Println("could not publish the dishes that expired:", fcErr.Message())
+			continue
+		}
+		last = now
+	}
+}
+
+//sealTokens wraps repo so users' Google tokens and webhook secrets are stored encrypted with the key from the config
+//file. Without a key they are stored as they are.
+func sealTokens(repo db.Repository) db.Repository {
+	if config.TokenEncryptionKey == "" {
+		fmt.Println("WARNING: no tokenEncryptionKey in the config file, Google tokens and webhook secrets will be stored unencrypted")
+		return repo
+	}
+	box, fcErr := secret.NewBox(config.TokenEncryptionKey)
+	if fcErr != nil {
+		log.Fatalln("StartApplication() could not use the tokenEncryptionKey:", fcErr.Message())
+	}
+	return db.NewSealedRepository(repo, box)
+}
+
 func check(err error) {
 	if err != nil {
 		log.Fatalln("something must have happened: ", err)
@@ -121,9 +412,3 @@ func Privacy(c *gin.Context) {
 	c.Data(200, "text/html", siteData)
 
 }
-
-func numGenerator() string {
-	n := make([]byte, 32)
-	rand.Read(n)
-	return base64.StdEncoding.EncodeToString(n)
-}
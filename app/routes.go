@@ -4,6 +4,7 @@ func mapRoutes() {
 	router.GET("/ping", apiHandler.Ping)
 	router.GET("/pong", apiHandler.Pong)
 
+	//Legacy routes - the verb and the credentials are in the POSTed body. Kept for existing clients.
 	router.POST("/dishes", apiHandler.GetDishes)
 	router.POST("/dishes/dish", apiHandler.HandleDishRequest)
 	router.POST("/dishes/dish/:p_id", apiHandler.HandleDishRequest)
@@ -17,9 +18,78 @@ func mapRoutes() {
 
 	router.POST("/users", apiHandler.HandleUsersRequest)
 
+	//v1 routes - "Authorization: Bearer <token>", either the session token from /success or a Google access token
+	v1 := router.Group("/v1")
+	v1.Use(apiHandler.RequireUser)
+
+	v1.GET("/dishes", apiHandler.ListDishes)
+	v1.POST("/dishes", apiHandler.CreateDish)
+	v1.GET("/dishes/expired", apiHandler.ListExpiredDishes)
+	v1.GET("/dishes/expiredby/:date", apiHandler.ListDishesExpiredBy)
+	v1.GET("/dishes/:id", apiHandler.GetDish)
+	v1.PATCH("/dishes/:id", apiHandler.UpdateDish)
+	v1.DELETE("/dishes/:id", apiHandler.DeleteDish)
+
+	v1.GET("/storage", apiHandler.ListStorages)
+	v1.POST("/storage", apiHandler.CreateStorage)
+	v1.GET("/storage/:id", apiHandler.GetStorage)
+	v1.PATCH("/storage/:id", apiHandler.UpdateStorage)
+	v1.DELETE("/storage/:id", apiHandler.DeleteStorage)
+	v1.GET("/storage/:id/dishes", apiHandler.ListStorageDishes)
+
+	v1.GET("/users/me", apiHandler.GetUser)
+	v1.PATCH("/users/me", apiHandler.UpdateUser)
+	v1.DELETE("/users/me", apiHandler.DeleteUser)
+	v1.DELETE("/users/me/deletion", apiHandler.CancelUserDeletion)
+	v1.GET("/users/me/export", apiHandler.ExportUser)
+	v1.GET("/users/me/links", apiHandler.ListLinks)
+	v1.DELETE("/users/me/links/:id", apiHandler.Unlink)
+
+	v1.GET("/digest", apiHandler.GetDigest)
+
+	v1.GET("/household", apiHandler.GetHousehold)
+	v1.PATCH("/household", apiHandler.UpdateHousehold)
+	v1.POST("/household/invites", apiHandler.InviteHouseholdMember)
+	v1.PATCH("/household/members/:id", apiHandler.UpdateHouseholdMember)
+	v1.DELETE("/household/members/:id", apiHandler.RemoveHouseholdMember)
+	v1.GET("/invites", apiHandler.ListInvites)
+	v1.POST("/invites/:id/accept", apiHandler.AcceptInvite)
+	v1.DELETE("/invites/:id", apiHandler.DeclineInvite)
+
+	v1.GET("/webhooks", apiHandler.GetWebhooks)
+	v1.POST("/webhooks", apiHandler.CreateWebhook)
+	v1.GET("/webhooks/deadletters", apiHandler.GetDeadLetters)
+	v1.POST("/webhooks/deliveries/:id/redeliver", apiHandler.RedeliverWebhook)
+	v1.GET("/webhooks/:id", apiHandler.GetWebhook)
+	v1.PATCH("/webhooks/:id", apiHandler.UpdateWebhook)
+	v1.DELETE("/webhooks/:id", apiHandler.DeleteWebhook)
+	v1.GET("/webhooks/:id/deliveries", apiHandler.GetWebhookDeliveries)
+
+	v1.GET("/events", apiHandler.StreamEvents)
+
+	v1.POST("/logout", apiHandler.Logout)
+
+	//admin routes - a bearer token like /v1, for a user with is_admin set
+	admin := router.Group("/admin")
+	admin.Use(apiHandler.RequireUser, apiHandler.RequireAdmin)
+
+	admin.GET("/users", apiHandler.ListUsers)
+	admin.POST("/users/:id/deactivate", apiHandler.DeactivateUser)
+	admin.POST("/users/:id/reactivate", apiHandler.ReactivateUser)
+	admin.POST("/users/:id/impersonate", apiHandler.ImpersonateUser)
+	admin.GET("/stats/expired", apiHandler.ExpiredDishStats)
+	admin.GET("/stats/tokens", apiHandler.TokenCacheStats)
+
 	router.GET("/login", apiHandler.Login)
 	router.GET("/oauthlogin", apiHandler.Oauthlogin)
 	router.GET("/privacy", Privacy)
 	router.GET("/success", apiHandler.LoginSuccess)
 
+	//Alexa account linking - the authorization and access token URIs set in the skill's account linking settings
+	router.GET("/alexa/authorize", apiHandler.AlexaAuthorize)
+	router.POST("/alexa/token", apiHandler.AlexaToken)
+
+	//the Alexa skill's endpoint, for Alexa's signed requests
+	router.POST("/alexa/skill", apiHandler.Skill)
+
 }
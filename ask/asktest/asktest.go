@@ -0,0 +1,145 @@
+package asktest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//CertURL is where the stand-in serves its certificate chain - a URL the verifier accepts as Amazon's.
+const CertURL = "https://s3.amazonaws.com/echo.api/echo-api-cert-test.pem"
+
+//Options changes the signing certificate, to test the ones the verifier has to refuse. The zero value is a good one.
+type Options struct {
+	DNSName   string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+//Amazon stands in for Alexa signing requests: a root, a signing certificate issued by it, and an http client that
+//serves the chain at CertURL without going out to the network.
+type Amazon struct {
+	Roots  *x509.CertPool
+	Client *http.Client
+
+	key      *rsa.PrivateKey
+	chainPEM []byte
+
+	mu      sync.Mutex
+	fetches int
+}
+
+//New makes a stand-in with a fresh root and signing certificate. It panics if the keys can't be made.
+func New(options Options) *Amazon {
+	if options.DNSName == "" {
+		options.DNSName = "echo-api.amazon.com"
+	}
+	if options.NotBefore.IsZero() {
+		options.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if options.NotAfter.IsZero() {
+		options.NotAfter = time.Now().Add(24 * time.Hour)
+	}
+
+	rootKey := newKey()
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "asktest root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		panic("asktest: could not make the root certificate: " + err.Error())
+	}
+	root, _ := x509.ParseCertificate(rootDER)
+
+	key := newKey()
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: options.DNSName},
+		DNSNames:     []string{options.DNSName},
+		NotBefore:    options.NotBefore,
+		NotAfter:     options.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, root, &key.PublicKey, rootKey)
+	if err != nil {
+		panic("asktest: could not make the signing certificate: " + err.Error())
+	}
+
+	a := &Amazon{Roots: x509.NewCertPool(), key: key}
+	a.Roots.AddCert(root)
+	a.chainPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+	a.Client = &http.Client{Transport: a}
+	return a
+}
+
+//Sign gives the headers Alexa would send with body.
+func (a *Amazon) Sign(body []byte) http.Header {
+	digest := sha256.Sum256(body)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic("asktest: could not sign the request: " + err.Error())
+	}
+	header := http.Header{}
+	header.Set("SignatureCertChainUrl", CertURL)
+	header.Set("Signature-256", base64.StdEncoding.EncodeToString(signature))
+	header.Set("Content-Type", "application/json")
+	return header
+}
+
+//Fetches is how many times the certificate chain was downloaded.
+func (a *Amazon) Fetches() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.fetches
+}
+
+//RoundTrip serves the certificate chain at CertURL and a 404 anywhere else.
+func (a *Amazon) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.String() != CertURL {
+		return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: io.NopCloser(&bytes.Buffer{}), Request: req}, nil
+	}
+	a.mu.Lock()
+	a.fetches++
+	a.mu.Unlock()
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(bytes.NewReader(a.chainPEM)), Request: req}, nil
+}
+
+//Restamp gives a recorded request with its timestamp moved to t, so it can be replayed. It panics if body isn't JSON.
+func Restamp(body []byte, t time.Time) []byte {
+	var request map[string]interface{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		panic("asktest: the request to restamp is not JSON: " + err.Error())
+	}
+	if inner, ok := request["request"].(map[string]interface{}); ok {
+		inner["timestamp"] = t.UTC().Format(time.RFC3339)
+	}
+	restamped, _ := json.Marshal(request)
+	return restamped
+}
+
+func newKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("asktest: could not make a key: " + err.Error())
+	}
+	return key
+}
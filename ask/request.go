@@ -0,0 +1,95 @@
+package ask
+
+import "time"
+
+//Request types Alexa sends to the skill's endpoint.
+const (
+	LaunchRequest       = "LaunchRequest"
+	IntentRequest       = "IntentRequest"
+	SessionEndedRequest = "SessionEndedRequest"
+)
+
+//RequestEnvelope is the JSON body of every request Alexa sends the skill. Only the parts the API uses are here.
+type RequestEnvelope struct {
+	Version string  `json:"version"`
+	Session Session `json:"session"`
+	Context Context `json:"context"`
+	Request Request `json:"request"`
+}
+
+//Session is the conversation the request is part of. Requests that aren't part of one, like some SessionEndedRequests,
+//leave it empty.
+type Session struct {
+	New         bool        `json:"new"`
+	SessionID   string      `json:"sessionId"`
+	Application Application `json:"application"`
+	User        User        `json:"user"`
+}
+
+//Context is the state of the device and the skill when the request was made.
+type Context struct {
+	System System `json:"System"`
+}
+
+//System says which skill and which Alexa account the request is for.
+type System struct {
+	Application Application `json:"application"`
+	User        User        `json:"user"`
+}
+
+//Application is the skill the request was sent for.
+type Application struct {
+	ApplicationID string `json:"applicationId"`
+}
+
+//User is the Alexa account making the request. AccessToken is the token from account linking, once it is linked.
+type User struct {
+	UserID      string `json:"userId"`
+	AccessToken string `json:"accessToken"`
+}
+
+//Request is what the user asked for.
+type Request struct {
+	Type        string    `json:"type"`
+	RequestID   string    `json:"requestId"`
+	Timestamp   time.Time `json:"timestamp"`
+	Locale      string    `json:"locale"`
+	Intent      Intent    `json:"intent"`
+	DialogState string    `json:"dialogState"`
+	Reason      string    `json:"reason"`
+}
+
+//Intent is the intent Alexa matched the user's words to, with the slots it filled in.
+type Intent struct {
+	Name               string          `json:"name"`
+	ConfirmationStatus string          `json:"confirmationStatus,omitempty"`
+	Slots              map[string]Slot `json:"slots,omitempty"`
+}
+
+//Slot is one value the user gave for an intent. Value is empty when they didn't give it.
+type Slot struct {
+	Name               string `json:"name"`
+	Value              string `json:"value,omitempty"`
+	ConfirmationStatus string `json:"confirmationStatus,omitempty"`
+}
+
+//SlotValue gives the value the user said for the slot, or "" if they didn't fill it in.
+func (i Intent) SlotValue(name string) string {
+	return i.Slots[name].Value
+}
+
+//ApplicationID is the skill the request is for, from the context or, for older requests, the session.
+func (e *RequestEnvelope) ApplicationID() string {
+	if e.Context.System.Application.ApplicationID != "" {
+		return e.Context.System.Application.ApplicationID
+	}
+	return e.Session.Application.ApplicationID
+}
+
+//User is the Alexa account the request is from, from the context or, for older requests, the session.
+func (e *RequestEnvelope) User() User {
+	if e.Context.System.User.UserID != "" {
+		return e.Context.System.User
+	}
+	return e.Session.User
+}
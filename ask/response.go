@@ -0,0 +1,95 @@
+package ask
+
+import (
+	"bytes"
+	"encoding/xml"
+)
+
+//ResponseEnvelope is the JSON the skill answers a request with.
+type ResponseEnvelope struct {
+	Version  string   `json:"version"`
+	Response Response `json:"response"`
+}
+
+//Response is what Alexa says and shows back to the user, and whether it keeps listening.
+type Response struct {
+	OutputSpeech     *OutputSpeech `json:"outputSpeech,omitempty"`
+	Card             *Card         `json:"card,omitempty"`
+	Reprompt         *Reprompt     `json:"reprompt,omitempty"`
+	Directives       []Directive   `json:"directives,omitempty"`
+	ShouldEndSession bool          `json:"shouldEndSession"`
+}
+
+//OutputSpeech is speech as SSML.
+type OutputSpeech struct {
+	Type string `json:"type"`
+	SSML string `json:"ssml"`
+}
+
+//Card is shown in the Alexa app. A LinkAccount card takes the user to account linking.
+type Card struct {
+	Type    string `json:"type"`
+	Title   string `json:"title,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+//Reprompt is said if the user doesn't answer.
+type Reprompt struct {
+	OutputSpeech OutputSpeech `json:"outputSpeech"`
+}
+
+//Directive asks Alexa to do something besides talking - here, to ask the user for a slot the intent still needs.
+type Directive struct {
+	Type          string  `json:"type"`
+	SlotToElicit  string  `json:"slotToElicit,omitempty"`
+	UpdatedIntent *Intent `json:"updatedIntent,omitempty"`
+}
+
+//version is the response format version the skill speaks.
+const version = "1.0"
+
+//Escape makes text safe to put inside SSML, so a dish called "Mac & Cheese" can't break the speech.
+func Escape(text string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+//speech wraps SSML in the <speak> element Alexa expects.
+func speech(ssml string) *OutputSpeech {
+	return &OutputSpeech{Type: "SSML", SSML: "<speak>" + ssml + "</speak>"}
+}
+
+//Tell says the SSML and ends the session.
+func Tell(ssml string) *ResponseEnvelope {
+	return &ResponseEnvelope{Version: version, Response: Response{OutputSpeech: speech(ssml), ShouldEndSession: true}}
+}
+
+//Ask says the SSML and waits for an answer, saying reprompt if none comes.
+func Ask(ssml string, reprompt string) *ResponseEnvelope {
+	return &ResponseEnvelope{Version: version, Response: Response{
+		OutputSpeech: speech(ssml),
+		Reprompt:     &Reprompt{OutputSpeech: *speech(reprompt)},
+	}}
+}
+
+//ElicitSlot says the SSML to ask for one of the intent's slots, and sends the answer back as the same intent.
+func ElicitSlot(ssml string, slot string, intent Intent) *ResponseEnvelope {
+	return &ResponseEnvelope{Version: version, Response: Response{
+		OutputSpeech: speech(ssml),
+		Reprompt:     &Reprompt{OutputSpeech: *speech(ssml)},
+		Directives:   []Directive{{Type: "Dialog.ElicitSlot", SlotToElicit: slot, UpdatedIntent: &intent}},
+	}}
+}
+
+//LinkAccount says the SSML and puts a card in the Alexa app that starts account linking.
+func LinkAccount(ssml string) *ResponseEnvelope {
+	response := Tell(ssml)
+	response.Response.Card = &Card{Type: "LinkAccount"}
+	return response
+}
+
+//Empty is the response to a request that needs no answer, like a SessionEndedRequest.
+func Empty() *ResponseEnvelope {
+	return &ResponseEnvelope{Version: version}
+}
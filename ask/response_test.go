@@ -0,0 +1,37 @@
+package ask
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscape(t *testing.T) {
+	assert.Equal(t, "Mac &amp; Cheese &lt;leftover&gt;", Escape("Mac & Cheese <leftover>"))
+	assert.Equal(t, "<speak>Hi</speak>", Tell("Hi").Response.OutputSpeech.SSML)
+}
+
+func TestElicitSlot(t *testing.T) {
+	intent := Intent{Name: "AddDish", Slots: map[string]Slot{"Dish": {Name: "Dish", Value: "carrots"}, "ExpireWindow": {Name: "ExpireWindow"}}}
+	marshaled, err := json.Marshal(ElicitSlot("How long will carrots last?", "ExpireWindow", intent))
+	assert.Nil(t, err)
+
+	var wire struct {
+		Response struct {
+			Directives []struct {
+				Type          string
+				SlotToElicit  string
+				UpdatedIntent Intent
+			}
+			ShouldEndSession bool
+		}
+	}
+	assert.Nil(t, json.Unmarshal(marshaled, &wire))
+	if assert.Equal(t, 1, len(wire.Response.Directives)) {
+		assert.Equal(t, "Dialog.ElicitSlot", wire.Response.Directives[0].Type)
+		assert.Equal(t, "ExpireWindow", wire.Response.Directives[0].SlotToElicit)
+		assert.Equal(t, "carrots", wire.Response.Directives[0].UpdatedIntent.SlotValue("Dish"))
+	}
+	assert.False(t, wire.Response.ShouldEndSession)
+}
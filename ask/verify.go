@@ -0,0 +1,217 @@
+package ask
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//Headers Alexa signs every request with.
+const (
+	CertChainURLHeader = "SignatureCertChainUrl"
+	SignatureHeader    = "Signature-256"
+)
+
+//SigningHost is the name Amazon's signing certificate has to be issued for.
+const SigningHost = "echo-api.amazon.com"
+
+//certHost and certPathPrefix are where Amazon keeps its signing certificate chains. A chain from anywhere else is refused.
+const certHost = "s3.amazonaws.com"
+const certPathPrefix = "/echo.api/"
+
+//MaxRequestAge is how far a request's timestamp can be from now, either way, before it is refused as a replay.
+const MaxRequestAge = 150 * time.Second
+
+//maxChains bounds the certificate chain cache. Amazon only uses a handful of URLs.
+const maxChains = 16
+
+//maxChainBytes bounds how much of a certificate chain is read.
+const maxChainBytes = 64 * 1024
+
+//fetchTimeout bounds downloading a certificate chain when the request has no deadline of its own.
+const fetchTimeout = 10 * time.Second
+
+//Verifier checks requests really come from Alexa for this skill: signed with Amazon's certificate, recent, and for the
+//skill's application id. Certificate chains are downloaded once and kept.
+type Verifier struct {
+	skillID    string
+	httpClient *http.Client
+	roots      *x509.CertPool
+	now        func() time.Time
+
+	mu     sync.Mutex
+	chains map[string]*chain
+}
+
+//chain is a downloaded signing certificate and the intermediates that lead to a root.
+type chain struct {
+	leaf          *x509.Certificate
+	intermediates *x509.CertPool
+}
+
+//NewVerifier gives you a Verifier for the skill with this application id. A nil httpClient uses one with a timeout and
+//nil roots uses the system's trusted roots.
+func NewVerifier(skillID string, httpClient *http.Client, roots *x509.CertPool) *Verifier {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: fetchTimeout}
+	}
+	return &Verifier{
+		skillID:    skillID,
+		httpClient: httpClient,
+		roots:      roots,
+		now:        time.Now,
+		chains:     make(map[string]*chain),
+	}
+}
+
+//Verify checks the request's signature, timestamp and application id and gives back the parsed request. Anything that
+//doesn't check out is a 400, which is what Alexa expects when a request is refused.
+func (v *Verifier) Verify(ctx context.Context, header http.Header, body []byte) (*RequestEnvelope, fcerr.FCErr) {
+	certURL, fcErr := checkCertURL(header.Get(CertChainURLHeader))
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	signature, err := base64.StdEncoding.DecodeString(header.Get(SignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return nil, fcerr.NewBadRequestError("The request is not signed")
+	}
+
+	signer, fcErr := v.certificate(ctx, certURL)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	publicKey, ok := signer.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fcerr.NewBadRequestError("The signing certificate does not have an RSA key")
+	}
+	digest := sha256.Sum256(body)
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fcerr.NewBadRequestError("The request signature is not valid")
+	}
+
+	var envelope RequestEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fcerr.NewBadRequestError("The request is not an Alexa request: " + err.Error())
+	}
+	age := v.now().Sub(envelope.Request.Timestamp)
+	if age > MaxRequestAge || age < -MaxRequestAge {
+		return nil, fcerr.NewBadRequestError("The request timestamp is too far from now")
+	}
+	if envelope.ApplicationID() != v.skillID {
+		return nil, fcerr.NewBadRequestError("The request is for a different skill")
+	}
+	return &envelope, nil
+}
+
+//checkCertURL makes sure the certificate chain is one of Amazon's: https, on s3.amazonaws.com at port 443, under /echo.api/.
+func checkCertURL(raw string) (string, fcerr.FCErr) {
+	if raw == "" {
+		return "", fcerr.NewBadRequestError("The request has no " + CertChainURLHeader + " header")
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", fcerr.NewBadRequestError("The certificate chain URL can't be read")
+	}
+	cleanPath := path.Clean(parsed.Path)
+	if !strings.EqualFold(parsed.Scheme, "https") || !strings.EqualFold(parsed.Hostname(), certHost) ||
+		(parsed.Port() != "" && parsed.Port() != "443") || !strings.HasPrefix(cleanPath, certPathPrefix) {
+		return "", fcerr.NewBadRequestError("The certificate chain URL is not one of Amazon's: " + raw)
+	}
+	return "https://" + certHost + cleanPath, nil
+}
+
+//certificate gives the signing certificate at certURL once it is checked to be current, issued for SigningHost and
+//chained to a trusted root.
+func (v *Verifier) certificate(ctx context.Context, certURL string) (*x509.Certificate, fcerr.FCErr) {
+	v.mu.Lock()
+	cached, ok := v.chains[certURL]
+	v.mu.Unlock()
+
+	if !ok {
+		var fcErr fcerr.FCErr
+		cached, fcErr = v.fetch(ctx, certURL)
+		if fcErr != nil {
+			return nil, fcErr
+		}
+	}
+
+	_, err := cached.leaf.Verify(x509.VerifyOptions{
+		DNSName:       SigningHost,
+		Intermediates: cached.intermediates,
+		Roots:         v.roots,
+		CurrentTime:   v.now(),
+	})
+	if err != nil {
+		return nil, fcerr.NewBadRequestError("The signing certificate is not valid: " + err.Error())
+	}
+
+	if !ok {
+		v.mu.Lock()
+		if len(v.chains) >= maxChains {
+			v.chains = make(map[string]*chain)
+		}
+		v.chains[certURL] = cached
+		v.mu.Unlock()
+	}
+	return cached.leaf, nil
+}
+
+//fetch downloads and parses the PEM certificate chain at certURL. The signing certificate comes first.
+func (v *Verifier) fetch(ctx context.Context, certURL string) (*chain, fcerr.FCErr) {
+	req, err := http.NewRequestWithContext(ctx, "GET", certURL, nil)
+	if err != nil {
+		return nil, fcerr.NewBadRequestError("Could not request the certificate chain")
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, fcerr.NewBadRequestError("Could not download the certificate chain: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fcerr.NewBadRequestError("Could not download the certificate chain: " + resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxChainBytes))
+	if err != nil {
+		return nil, fcerr.NewBadRequestError("Could not read the certificate chain")
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fcerr.NewBadRequestError("The certificate chain has a certificate that can't be read")
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fcerr.NewBadRequestError("The certificate chain has no certificates in it")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	return &chain{leaf: certs[0], intermediates: intermediates}, nil
+}
@@ -0,0 +1,121 @@
+package ask
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/ask/asktest"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSkillID = "amzn1.ask.skill.test-skill"
+
+//testRequest is a LaunchRequest for the test skill, made at the given time.
+func testRequest(timestamp time.Time) []byte {
+	return []byte(`{"version": "1.0", "session": {"new": true, "sessionId": "amzn1.echo-api.session.test",
+		"application": {"applicationId": "` + testSkillID + `"}, "user": {"userId": "amzn1.ask.account.TEST"}},
+		"context": {"System": {"application": {"applicationId": "` + testSkillID + `"}, "user": {"userId": "amzn1.ask.account.TEST"}}},
+		"request": {"type": "LaunchRequest", "requestId": "amzn1.echo-api.request.test", "timestamp": "` +
+		timestamp.UTC().Format(time.RFC3339) + `", "locale": "en-US"}}`)
+}
+
+//newTestVerifier gives a verifier trusting amazon's root, with a clock the test can move.
+func newTestVerifier(amazon *asktest.Amazon) (*Verifier, *time.Time) {
+	now := time.Now().Truncate(time.Second)
+	v := NewVerifier(testSkillID, amazon.Client, amazon.Roots)
+	v.now = func() time.Time { return now }
+	return v, &now
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	amazon := asktest.New(asktest.Options{})
+	v, now := newTestVerifier(amazon)
+
+	body := testRequest(*now)
+	envelope, err := v.Verify(context.Background(), amazon.Sign(body), body)
+	assert.Nil(t, err)
+	if assert.NotNil(t, envelope) {
+		assert.Equal(t, LaunchRequest, envelope.Request.Type)
+		assert.Equal(t, "amzn1.ask.account.TEST", envelope.User().UserID)
+	}
+
+	//the chain is only downloaded once
+	_, err = v.Verify(context.Background(), amazon.Sign(body), body)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, amazon.Fetches())
+}
+
+func TestVerifier_Verify_Refused(t *testing.T) {
+	amazon := asktest.New(asktest.Options{})
+	v, now := newTestVerifier(amazon)
+	body := testRequest(*now)
+
+	tampered := amazon.Sign(body)
+	_, err := v.Verify(context.Background(), tampered, append([]byte(" "), body...))
+	assert.Equal(t, http.StatusBadRequest, err.Status(), "a body that isn't the one signed")
+
+	unsigned := amazon.Sign(body)
+	unsigned.Del(SignatureHeader)
+	_, err = v.Verify(context.Background(), unsigned, body)
+	assert.Equal(t, http.StatusBadRequest, err.Status(), "no signature")
+
+	elsewhere := amazon.Sign(body)
+	elsewhere.Set(CertChainURLHeader, "https://s3.amazonaws.com/echo.api/someone-elses.pem")
+	_, err = v.Verify(context.Background(), elsewhere, body)
+	assert.Equal(t, http.StatusBadRequest, err.Status(), "a chain that can't be downloaded")
+
+	for _, timestamp := range []time.Time{now.Add(-MaxRequestAge - time.Second), now.Add(MaxRequestAge + time.Second)} {
+		stale := testRequest(timestamp)
+		_, err = v.Verify(context.Background(), amazon.Sign(stale), stale)
+		assert.Equal(t, http.StatusBadRequest, err.Status(), timestamp)
+	}
+
+	otherSkill := NewVerifier("amzn1.ask.skill.other-skill", amazon.Client, amazon.Roots)
+	_, err = otherSkill.Verify(context.Background(), amazon.Sign(body), body)
+	assert.Equal(t, http.StatusBadRequest, err.Status(), "a request for another skill")
+
+	untrusted := NewVerifier(testSkillID, amazon.Client, asktest.New(asktest.Options{}).Roots)
+	_, err = untrusted.Verify(context.Background(), amazon.Sign(body), body)
+	assert.Equal(t, http.StatusBadRequest, err.Status(), "a chain to a root that isn't trusted")
+}
+
+func TestVerifier_Verify_BadCertificate(t *testing.T) {
+	for name, options := range map[string]asktest.Options{
+		"another host": {DNSName: "echo-api.example.com"},
+		"expired":      {NotBefore: time.Now().Add(-48 * time.Hour), NotAfter: time.Now().Add(-24 * time.Hour)},
+		"not yet good": {NotBefore: time.Now().Add(24 * time.Hour), NotAfter: time.Now().Add(48 * time.Hour)},
+	} {
+		amazon := asktest.New(options)
+		v, now := newTestVerifier(amazon)
+		body := testRequest(*now)
+		_, err := v.Verify(context.Background(), amazon.Sign(body), body)
+		if assert.NotNil(t, err, name) {
+			assert.Equal(t, http.StatusBadRequest, err.Status(), name)
+		}
+	}
+}
+
+func TestCheckCertURL(t *testing.T) {
+	tests := []struct {
+		certURL string
+		ok      bool
+	}{
+		{"https://s3.amazonaws.com/echo.api/echo-api-cert.pem", true},
+		{"https://s3.amazonaws.com:443/echo.api/echo-api-cert.pem", true},
+		{"HTTPS://s3.AmazonAWS.com/echo.api/echo-api-cert.pem", true},
+		{"https://s3.amazonaws.com/echo.api/../echo.api/echo-api-cert.pem", true},
+		{"", false},
+		{"http://s3.amazonaws.com/echo.api/echo-api-cert.pem", false},
+		{"https://notamazon.com/echo.api/echo-api-cert.pem", false},
+		{"https://s3.amazonaws.com/EcHo.aPi/echo-api-cert.pem", false},
+		{"https://s3.amazonaws.com/invalid.path/echo-api-cert.pem", false},
+		{"https://s3.amazonaws.com:563/echo.api/echo-api-cert.pem", false},
+		{"https://s3.amazonaws.com/echo.api/../invalid.path/echo-api-cert.pem", false},
+	}
+	for _, tt := range tests {
+		_, err := checkCertURL(tt.certURL)
+		assert.Equal(t, tt.ok, err == nil, tt.certURL)
+	}
+}
@@ -0,0 +1,24 @@
+package clock
+
+import "time"
+
+//Clock is where the background jobs get the time from, so tests can move it along instead of waiting.
+type Clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+//System is the clock on the wall.
+func System() Clock {
+	return systemClock{}
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,86 @@
+package clocktest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/clock"
+)
+
+//Clock is a clock.Clock that only moves when the test says so.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+	//waiting is signalled whenever After is called, so a test can wait for a goroutine to go to sleep
+	waiting chan struct{}
+}
+
+var _ clock.Clock = (*Clock)(nil)
+
+//waiter is a call to After that hasn't fired yet.
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+//New gives a clock stopped at now.
+func New(now time.Time) *Clock {
+	return &Clock{now: now, waiting: make(chan struct{}, 64)}
+}
+
+//Now is the time the clock was set to.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+//After fires once the clock is moved d past now. Nothing fires by itself.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fired := make(chan time.Time, 1)
+	if d <= 0 {
+		fired <- c.now
+		return fired
+	}
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), c: fired})
+	select {
+	case c.waiting <- struct{}{}:
+	default:
+	}
+	return fired
+}
+
+//Advance moves the clock on by d, firing every After that is due by then.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = pending
+}
+
+//WaitForSleepers blocks until n calls to After have been made since the last time it returned, or the timeout passes.
+//It tells whether they were.
+func (c *Clock) WaitForSleepers(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for i := 0; i < n; i++ {
+		select {
+		case <-c.waiting:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
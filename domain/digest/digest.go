@@ -0,0 +1,240 @@
+package digest
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//The periods a digest covers.
+const (
+	Daily  = "daily"
+	Weekly = "weekly"
+)
+
+//Length is how far back a period's counts of eaten and wasted dishes go, and false if period isn't one.
+func Length(period string) (time.Duration, bool) {
+	switch period {
+	case Daily:
+		return 24 * time.Hour, true
+	case Weekly:
+		return 7 * 24 * time.Hour, true
+	}
+	return 0, false
+}
+
+//Digest type is the struct in the Domain for a "use it up" summary of a user's household: the dishes that expire by
+//ExpiringBy, grouped by storage unit, and how many dishes were eaten and wasted over the period from From to AsOf.
+type Digest struct {
+	UserID     int       `json:"UserID"`
+	Period     string    `json:"Period"`
+	From       time.Time `json:"From"`
+	AsOf       time.Time `json:"AsOf"`
+	ExpiringBy time.Time `json:"ExpiringBy"`
+	Storages   []Section `json:"Storages"`
+	Expiring   int       `json:"Expiring"`
+	Consumed   int       `json:"Consumed"`
+	Wasted     int       `json:"Wasted"`
+
+	name     string
+	location *time.Location
+}
+
+//Section is the dishes in one storage unit, in the order to use them up. StorageID is the storage unit's personal id,
+//or 0 for dishes whose storage unit is gone.
+type Section struct {
+	StorageID int         `json:"StorageID"`
+	Title     string      `json:"Title"`
+	Dishes    dish.Dishes `json:"Dishes"`
+}
+
+//elsewhere titles the section of dishes whose storage unit is gone.
+const elsewhere = "Elsewhere"
+
+//New starts an empty digest for the user over the period that ends at asOf, for dishes that expire by expiringBy.
+func New(u user.User, period string, asOf time.Time, expiringBy time.Time) *Digest {
+	length, _ := Length(period)
+	name := u.FirstName
+	if name == "" {
+		name = "there"
+	}
+	return &Digest{
+		UserID:     u.UserID,
+		Period:     period,
+		From:       dish.CanonicalTime(asOf.Add(-length)),
+		AsOf:       dish.CanonicalTime(asOf),
+		ExpiringBy: dish.CanonicalTime(expiringBy),
+		Storages:   []Section{},
+		name:       name,
+		location:   u.Location(),
+	}
+}
+
+//Group puts the dishes into a section for each storage unit that has any, in the order the storage units come. Each
+//section is ordered by the dishes' priority, then by how long they have left.
+func (d *Digest) Group(storages storage.Storages, dishes dish.Dishes) {
+	index := map[int]int{}
+	var sections []Section
+	for _, st := range storages {
+		index[st.PersonalID] = len(sections)
+		sections = append(sections, Section{StorageID: st.PersonalID, Title: st.Title})
+	}
+	var lost Section
+	for _, di := range dishes {
+		if i, ok := index[di.StorageID]; ok {
+			sections[i].Dishes = append(sections[i].Dishes, di)
+		} else {
+			lost.Dishes = append(lost.Dishes, di)
+		}
+	}
+	if len(lost.Dishes) > 0 {
+		lost.Title = elsewhere
+		sections = append(sections, lost)
+	}
+
+	d.Storages, d.Expiring = []Section{}, 0
+	for _, section := range sections {
+		if len(section.Dishes) == 0 {
+			continue
+		}
+		sortDishes(section.Dishes)
+		d.Storages = append(d.Storages, section)
+		d.Expiring += len(section.Dishes)
+	}
+}
+
+//Count adds up the dishes removed over the period as eaten or wasted.
+func (d *Digest) Count(removals dish.Removals) {
+	d.Consumed, d.Wasted = removals.Count()
+}
+
+//sortDishes orders dishes to use them up: higher priority first, then the soonest to expire.
+func sortDishes(dishes dish.Dishes) {
+	sort.SliceStable(dishes, func(i, j int) bool {
+		ri, rj := dish.PriorityRank(dishes[i].Priority), dish.PriorityRank(dishes[j].Priority)
+		if ri != rj {
+			return ri < rj
+		}
+		return dishes[i].ExpireDate.Before(dishes[j].ExpireDate)
+	})
+}
+
+//Subject is the digest in a line, for an email.
+func (d *Digest) Subject() string {
+	switch d.Expiring {
+	case 0:
+		return "Nothing to use up - your " + d.Period + " Freshness Countdown digest"
+	case 1:
+		return "1 dish to use up - your " + d.Period + " Freshness Countdown digest"
+	}
+	return fmt.Sprintf("%d dishes to use up - your %s Freshness Countdown digest", d.Expiring, d.Period)
+}
+
+//dishLine says what one dish needs: "Carrots (high priority) expires today".
+func (d *Digest) dishLine(di dish.Dish) string {
+	text := di.Title
+	if priority := strings.TrimSpace(di.Priority); priority != "" {
+		text += " (" + priority + " priority)"
+	}
+	return text + " " + di.ExpiresIn(d.AsOf)
+}
+
+//date writes t as a day in the user's time zone.
+func (d *Digest) date(t time.Time) string {
+	return t.In(d.location).Format("Monday, January 2")
+}
+
+//tally says how many dishes were eaten and wasted over the period.
+func (d *Digest) tally() string {
+	return fmt.Sprintf("Since %s, your household used up %s and threw away %s.", d.date(d.From),
+		dishCount(d.Consumed), dishCount(d.Wasted))
+}
+
+//dishCount writes n as "1 dish" or "3 dishes".
+func dishCount(n int) string {
+	if n == 1 {
+		return "1 dish"
+	}
+	return fmt.Sprintf("%d dishes", n)
+}
+
+//Text is the whole digest as plain text.
+func (d *Digest) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\nThis is your %s Freshness Countdown digest for %s.\n\n", d.name, d.Period, d.date(d.AsOf))
+	if d.Expiring == 0 {
+		fmt.Fprintf(&b, "Nothing in your household expires by %s.\n", d.date(d.ExpiringBy))
+	}
+	for _, section := range d.Storages {
+		b.WriteString(section.Title + "\n")
+		for _, di := range section.Dishes {
+			b.WriteString("- " + d.dishLine(di) + "\n")
+		}
+		b.WriteString("\n")
+	}
+	if d.Expiring == 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString(d.tally() + "\n")
+	return b.String()
+}
+
+//htmlDigest is the digest as an HTML email. The styles are inline, as email clients drop style sheets.
+var htmlDigest = template.Must(template.New("digest").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Subject}}</title></head>
+<body style="font-family: Arial, sans-serif; color: #222222; margin: 0; padding: 16px;">
+<p>Hi {{.Name}},</p>
+<p>This is your {{.Period}} Freshness Countdown digest for {{.Date}}.</p>
+{{- if not .Sections}}
+<p>Nothing in your household expires by {{.ExpiringBy}}.</p>
+{{- end}}
+{{- range .Sections}}
+<h3 style="margin: 16px 0 4px 0;">{{.Title}}</h3>
+<ul style="margin: 0; padding-left: 20px;">
+{{- range .Lines}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- end}}
+<p style="margin-top: 16px;">{{.Tally}}</p>
+</body>
+</html>
+`))
+
+//htmlSection is a section with its dishes already written out.
+type htmlSection struct {
+	Title string
+	Lines []string
+}
+
+//HTML is the whole digest as an HTML email, with the titles escaped.
+func (d *Digest) HTML() (string, fcerr.FCErr) {
+	data := struct {
+		Subject, Name, Period, Date, ExpiringBy, Tally string
+		Sections                                       []htmlSection
+	}{Subject: d.Subject(), Name: d.name, Period: d.Period, Date: d.date(d.AsOf), ExpiringBy: d.date(d.ExpiringBy),
+		Tally: d.tally()}
+	for _, section := range d.Storages {
+		s := htmlSection{Title: section.Title}
+		for _, di := range section.Dishes {
+			s.Lines = append(s.Lines, d.dishLine(di))
+		}
+		data.Sections = append(data.Sections, s)
+	}
+
+	var b bytes.Buffer
+	if err := htmlDigest.Execute(&b, data); err != nil {
+		return "", fcerr.Wrap(err, "Could not write the digest as HTML", http.StatusInternalServerError)
+	}
+	return b.String(), nil
+}
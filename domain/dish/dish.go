@@ -2,6 +2,7 @@ package dish
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/araddon/dateparse"
@@ -9,19 +10,44 @@ import (
 )
 
 //Dish type is the struct in the Domain that contains all the fields for what a Dish is.
+//UserID is who added the dish; every member of its household can see it.
 type Dish struct {
-	DishID         int    `json:"DishID"`
-	PersonalDishID int    `json:"PersonalDishID"`
-	UserID         int    `json:"UserID"`
-	StorageID      int    `json:"StorageID"`
-	Title          string `json:"Title"`
-	Description    string `json:"Description"`
-	CreatedDate    string `json:"TimeCreated"`
-	ExpireDate     string `json:"TimeExpires"`
-	Priority       string `json:"Priority"`
-	DishType       string `json:"DishType"`
-	Portions       int    `json:"Portions"`
-	TempMatch      string `json:"TempMatch"`
+	DishID         int       `json:"DishID"`
+	PersonalDishID int       `json:"PersonalDishID"`
+	UserID         int       `json:"UserID"`
+	HouseholdID    int       `json:"HouseholdID"`
+	StorageID      int       `json:"StorageID"`
+	Title          string    `json:"Title"`
+	Description    string    `json:"Description"`
+	CreatedDate    time.Time `json:"TimeCreated"`
+	ExpireDate     time.Time `json:"TimeExpires"`
+	Priority       string    `json:"Priority"`
+	DishType       string    `json:"DishType"`
+	Portions       int       `json:"Portions"`
+	TempMatch      string    `json:"TempMatch"`
+}
+
+//StorageFormat is how dish times are written to the database, and the only way they are read back. It is what a MySQL
+//DATETIME takes, and it sorts as text.
+const StorageFormat = "2006-01-02 15:04:05"
+
+//CanonicalTime gives t the way a dish keeps its times - in UTC and to the second, so it marshals as plain RFC 3339.
+func CanonicalTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+//ParseTime reads a dish time written as RFC 3339 or in StorageFormat, which is taken as UTC. "" is the zero time.
+func ParseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return CanonicalTime(t), nil
+	}
+	if t, err := time.Parse(StorageFormat, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date this api recognizes", value)
 }
 
 //Dishes type is a slice of the domain type Dish.
@@ -35,13 +61,12 @@ type Dishes []Dish
 
 //IsExpired will check the ExpireDate field against the current time, and return true for expired
 func (d *Dish) IsExpired() (bool, fcerr.FCErr) {
-	expireTime, err := time.Parse("2006-01-02T15:04", d.ExpireDate)
-	if err != nil {
-		fmt.Println("The dish did not have a valid expiration date. Error:", err.Error())
+	if d.ExpireDate.IsZero() {
+		fmt.Println("The dish did not have a valid expiration date:", d.Title)
 		return false, fcerr.NewInternalServerError("Encountered a dish without a valid expiration date")
 	}
 
-	if expireTime.After(time.Now()) {
+	if d.ExpireDate.After(time.Now()) {
 		return false, nil
 	}
 	return true, nil
@@ -49,21 +74,156 @@ func (d *Dish) IsExpired() (bool, fcerr.FCErr) {
 
 //WillExpireBy will check the ExpireDate field against the given date/time, and return true if the dish will be expired
 func (d *Dish) WillExpireBy(dateStr string) (bool, fcerr.FCErr) {
-	dishExpireTime, err := time.Parse("2006-01-02T15:04", d.ExpireDate)
-	if err != nil {
-		fmt.Println("The dish did not have a valid expiration date. Error:", err.Error())
+	if d.ExpireDate.IsZero() {
+		fmt.Println("The dish did not have a valid expiration date:", d.Title)
 		return false, fcerr.NewInternalServerError("Encountered a dish without a valid expiration date")
 	}
 
-	//checkTime, err := time.Parse("2006-01-02", dateStr)
-	checkTime, err := dateparse.ParseAny(dateStr)
+	//a date without a zone is taken as UTC, the same as the dish times
+	checkTime, err := dateparse.ParseIn(dateStr, time.UTC)
 	if err != nil {
 		fmt.Println("WillExpireBy was passed an invalid expiration string:" + dateStr)
 		return false, fcerr.NewBadRequestError("dish method was passed an invalid expiration string")
 	}
 
-	if dishExpireTime.After(checkTime) {
+	if d.ExpireDate.After(checkTime) {
 		return false, nil
 	}
 	return true, nil
 }
+
+//ExpiresIn says when the dish expires, in days from now to the nearest day: "expires in 3 days", "expired yesterday".
+func (d *Dish) ExpiresIn(now time.Time) string {
+	left := d.ExpireDate.Sub(now)
+	if left > 0 {
+		switch days := int((left + 12*time.Hour) / (24 * time.Hour)); days {
+		case 0:
+			return "expires today"
+		case 1:
+			return "expires tomorrow"
+		default:
+			return fmt.Sprintf("expires in %d days", days)
+		}
+	}
+	switch days := int((-left + 12*time.Hour) / (24 * time.Hour)); days {
+	case 0:
+		return "expired today"
+	case 1:
+		return "expired yesterday"
+	default:
+		return fmt.Sprintf("expired %d days ago", days)
+	}
+}
+
+//ExpiryCount is how many of a household's dishes expire at one time.
+type ExpiryCount struct {
+	HouseholdID int
+	ExpireDate  time.Time
+	Dishes      int
+}
+
+//ExpiryCounts is a slice of the domain type ExpiryCount.
+type ExpiryCounts []ExpiryCount
+
+//ExpiryStats sums up how fresh the dishes across every household are. A dish is expired the same way IsExpired says,
+//and expiring soon if it hasn't yet but will by ExpiringBy. Undated dishes have no expire date to go by.
+type ExpiryStats struct {
+	AsOf                  time.Time `json:"AsOf"`
+	ExpiringBy            time.Time `json:"ExpiringBy"`
+	Dishes                int       `json:"Dishes"`
+	Expired               int       `json:"Expired"`
+	ExpiringSoon          int       `json:"ExpiringSoon"`
+	Undated               int       `json:"Undated"`
+	Households            int       `json:"Households"`
+	HouseholdsWithExpired int       `json:"HouseholdsWithExpired"`
+}
+
+//Stats adds the counts up as of now, with dishes expiring by soon counted as expiring soon.
+func (counts ExpiryCounts) Stats(now time.Time, soon time.Time) ExpiryStats {
+	stats := ExpiryStats{AsOf: CanonicalTime(now), ExpiringBy: CanonicalTime(soon)}
+	households := map[int]bool{}
+	for _, c := range counts {
+		stats.Dishes += c.Dishes
+		if _, seen := households[c.HouseholdID]; !seen {
+			households[c.HouseholdID] = false
+		}
+		switch {
+		case c.ExpireDate.IsZero():
+			stats.Undated += c.Dishes
+		case !c.ExpireDate.After(now):
+			stats.Expired += c.Dishes
+			households[c.HouseholdID] = true
+		case !c.ExpireDate.After(soon):
+			stats.ExpiringSoon += c.Dishes
+		}
+	}
+	stats.Households = len(households)
+	for _, hasExpired := range households {
+		if hasExpired {
+			stats.HouseholdsWithExpired++
+		}
+	}
+	return stats
+}
+
+//The outcomes of a dish leaving its household: it was eaten, or it went to waste.
+const (
+	Consumed = "consumed"
+	Wasted   = "wasted"
+)
+
+//Removal is the record of a dish leaving its household, kept so a digest can count what was eaten and what was wasted.
+//UserID is who removed it, and StorageID the personal id of the storage unit it was in.
+type Removal struct {
+	RemovalID   int       `json:"RemovalID"`
+	HouseholdID int       `json:"HouseholdID"`
+	UserID      int       `json:"UserID"`
+	StorageID   int       `json:"StorageID"`
+	Title       string    `json:"Title"`
+	ExpireDate  time.Time `json:"TimeExpires"`
+	RemovedDate time.Time `json:"TimeRemoved"`
+	Outcome     string    `json:"Outcome"`
+}
+
+//Removals type is a slice of the domain type Removal.
+type Removals []Removal
+
+//Outcome is what became of the dish if it is removed at now without saying: a dish that had expired went to waste,
+//and any other was eaten.
+func (d *Dish) Outcome(now time.Time) string {
+	if !d.ExpireDate.IsZero() && !d.ExpireDate.After(now) {
+		return Wasted
+	}
+	return Consumed
+}
+
+//ValidOutcome says whether outcome is one a removal can be given.
+func ValidOutcome(outcome string) bool {
+	return outcome == Consumed || outcome == Wasted
+}
+
+//Count gives how many of the removals were consumed, and how many wasted.
+func (r Removals) Count() (consumed int, wasted int) {
+	for _, removal := range r {
+		switch removal.Outcome {
+		case Consumed:
+			consumed++
+		case Wasted:
+			wasted++
+		}
+	}
+	return consumed, wasted
+}
+
+//PriorityRank orders a dish's Priority for eating first: "high" comes before "medium", then "low". Anything else, like
+//no priority at all, is taken as medium.
+func PriorityRank(priority string) int {
+	switch strings.ToLower(strings.TrimSpace(priority)) {
+	case "high", "urgent":
+		return 0
+	case "low":
+		return 2
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,115 @@
+package dish
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Query is what a list of a household's dishes is narrowed down to, how it is sorted, and which page of it to give.
+//A nil StorageID, an empty string and a zero time leave that out. ExpiresAfter is exclusive and ExpiresBefore
+//inclusive, the same as IsExpired. Search looks for the text anywhere in the title, ignoring case. A Limit of 0 gives
+//every dish after the cursor.
+type Query struct {
+	StorageID     *int
+	DishType      string
+	Priority      string
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+	Search        string
+	Sort          string
+	Descending    bool
+	Limit         int
+	After         *Cursor
+}
+
+//The keys a list of dishes can be sorted by. SortDefault is the order the dishes were added in. Dishes that sort the
+//same are in the order they were added.
+const (
+	SortDefault     = ""
+	SortExpireDate  = "expireDate"
+	SortCreatedDate = "createdDate"
+	SortPriority    = "priority"
+)
+
+//ValidSort says whether sort is one of the keys a list of dishes can be sorted by.
+func ValidSort(sort string) bool {
+	switch sort {
+	case SortDefault, SortExpireDate, SortCreatedDate, SortPriority:
+		return true
+	}
+	return false
+}
+
+//ValidPriority says whether a list of dishes can be narrowed down to priority: high, medium or low, the same as
+//PriorityRank reads them.
+func ValidPriority(priority string) bool {
+	switch strings.ToLower(strings.TrimSpace(priority)) {
+	case "high", "urgent", "medium", "low":
+		return true
+	}
+	return false
+}
+
+//SortKey is what the dish is sorted by for the key sort, as a Cursor keeps it: a time the way it is stored, in
+//StorageFormat or "" for no time at all, or the dish's PriorityRank.
+func (d *Dish) SortKey(sort string) string {
+	switch sort {
+	case SortExpireDate:
+		return sortTime(d.ExpireDate)
+	case SortCreatedDate:
+		return sortTime(d.CreatedDate)
+	case SortPriority:
+		return strconv.Itoa(PriorityRank(d.Priority))
+	}
+	return ""
+}
+
+//sortTime is t the way it is stored and sorted.
+func sortTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(StorageFormat)
+}
+
+//Cursor marks where a page of a list of dishes ended: the last dish's SortKey and DishID, and how the list was sorted.
+//The next page starts after it.
+type Cursor struct {
+	Sort       string `json:"s,omitempty"`
+	Descending bool   `json:"d,omitempty"`
+	Key        string `json:"k,omitempty"`
+	ID         int    `json:"i"`
+}
+
+//CursorAfter gives the Cursor for the next page of a list sorted by sort, after the dish d.
+func CursorAfter(d Dish, sort string, descending bool) Cursor {
+	return Cursor{Sort: sort, Descending: descending, Key: d.SortKey(sort), ID: d.DishID}
+}
+
+//Encode writes the cursor the way it is handed to clients, who should treat it as opaque.
+func (c Cursor) Encode() string {
+	marshaled, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(marshaled)
+}
+
+//DecodeCursor reads a cursor written by Encode.
+func DecodeCursor(encoded string) (*Cursor, error) {
+	marshaled, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("not a cursor this api gave out")
+	}
+	var c Cursor
+	if err := json.Unmarshal(marshaled, &c); err != nil || !ValidSort(c.Sort) || c.ID < 1 {
+		return nil, errors.New("not a cursor this api gave out")
+	}
+	if c.Sort == SortPriority {
+		if _, err := strconv.Atoi(c.Key); err != nil {
+			return nil, errors.New("not a cursor this api gave out")
+		}
+	}
+	return &c, nil
+}
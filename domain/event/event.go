@@ -0,0 +1,68 @@
+package event
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+)
+
+//Event type is the struct in the Domain for something that happened to the food in a household: a dish or storage unit
+//was created, updated or deleted, or a dish expired. UserID is who made the change, or 0 for an expiry, and Data is the
+//dish or storage unit as it was after the change, or just before it was deleted.
+type Event struct {
+	EventID     string      `json:"EventID"`
+	Type        string      `json:"Type"`
+	HouseholdID int         `json:"HouseholdID"`
+	UserID      int         `json:"UserID"`
+	Time        time.Time   `json:"Time"`
+	Data        interface{} `json:"Data"`
+}
+
+//The types of event.
+const (
+	DishCreated    = "dish.created"
+	DishUpdated    = "dish.updated"
+	DishDeleted    = "dish.deleted"
+	DishExpired    = "dish.expired"
+	StorageCreated = "storage.created"
+	StorageUpdated = "storage.updated"
+	StorageDeleted = "storage.deleted"
+)
+
+//Types is every type of event, in the order they are documented.
+var Types = []string{DishCreated, DishUpdated, DishDeleted, DishExpired, StorageCreated, StorageUpdated, StorageDeleted}
+
+//ValidType says whether t is one of the Types.
+func ValidType(t string) bool {
+	for _, known := range Types {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+//RemovedDish is the Data of a DishDeleted event: the dish, and whether it was dish.Consumed or dish.Wasted.
+type RemovedDish struct {
+	dish.Dish
+	Outcome string `json:"Outcome"`
+}
+
+//idPrefix starts every event id.
+const idPrefix = "evt_"
+
+//New makes an event of type t with a new random id, happening now.
+func New(t string, householdID int, userID int, now time.Time, data interface{}) Event {
+	n := make([]byte, 12)
+	rand.Read(n)
+	return Event{
+		EventID:     idPrefix + hex.EncodeToString(n),
+		Type:        t,
+		HouseholdID: householdID,
+		UserID:      userID,
+		Time:        dish.CanonicalTime(now),
+		Data:        data,
+	}
+}
@@ -0,0 +1,83 @@
+package household
+
+import "time"
+
+//Household type is the struct in the Domain for the people who share storage units and dishes - a family sharing one
+//fridge. Every storage unit and dish belongs to a household, and their personal ids are numbered within it.
+type Household struct {
+	HouseholdID int       `json:"HouseholdID"`
+	Name        string    `json:"Name"`
+	CreatedDate time.Time `json:"TimeCreated"`
+	Members     Members   `json:"Members,omitempty"`
+	Invites     Invites   `json:"Invites,omitempty"`
+	TempMatch   string    `json:"-"`
+}
+
+//Households is a slice of the domain type Household.
+type Households []Household
+
+//Member type is a user's place in a household. A user is in exactly one household at a time.
+//Email and FullName are the user's, for showing who is in the household.
+type Member struct {
+	HouseholdID int       `json:"HouseholdID"`
+	UserID      int       `json:"UserID"`
+	Role        string    `json:"Role"`
+	JoinedDate  time.Time `json:"TimeJoined"`
+	Email       string    `json:"Email"`
+	FullName    string    `json:"FullName"`
+}
+
+//Members is a slice of the domain type Member.
+type Members []Member
+
+//Roles a member can have. Owners manage the household - its name, who is in it, and deleting its storage units.
+//Members can do everything else with the food.
+const (
+	RoleOwner  = "owner"
+	RoleMember = "member"
+)
+
+//ValidRole says whether role is one a member can be given.
+func ValidRole(role string) bool {
+	return role == RoleOwner || role == RoleMember
+}
+
+//IsOwner says whether the member can manage the household.
+func (m *Member) IsOwner() bool {
+	return m.Role == RoleOwner
+}
+
+//Owners counts the owners among the members.
+func (ms Members) Owners() int {
+	count := 0
+	for _, m := range ms {
+		if m.IsOwner() {
+			count++
+		}
+	}
+	return count
+}
+
+//Invite type is an owner's offer of a place in their household to whoever signs in with Email. Nobody is moved into a
+//household until the invited user accepts. HouseholdName and From, the email of the owner who sent it, are filled in
+//for the invited user to see who it is from.
+type Invite struct {
+	InviteID      int       `json:"InviteID"`
+	HouseholdID   int       `json:"HouseholdID"`
+	Email         string    `json:"Email"`
+	Role          string    `json:"Role"`
+	InvitedBy     int       `json:"-"`
+	CreatedDate   time.Time `json:"TimeCreated"`
+	ExpireDate    time.Time `json:"TimeExpires"`
+	HouseholdName string    `json:"HouseholdName,omitempty"`
+	From          string    `json:"From,omitempty"`
+	TempMatch     string    `json:"-"`
+}
+
+//Invites is a slice of the domain type Invite.
+type Invites []Invite
+
+//IsExpired says whether the invite can no longer be accepted at the time now.
+func (i *Invite) IsExpired(now time.Time) bool {
+	return !now.Before(i.ExpireDate)
+}
@@ -0,0 +1,49 @@
+package identity
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/session"
+)
+
+//Identity type is the struct in the Domain for an account on another service - so far only Alexa - that a user linked
+//to act as them. The link holds the tokens this API gave that service, as hashes like a session's, so unlinking can
+//revoke them. ExternalID is the other service's id for the account, which is only known once it first calls the API.
+type Identity struct {
+	LinkID           int       `json:"LinkID"`
+	UserID           int       `json:"UserID"`
+	Provider         string    `json:"Provider"`
+	ExternalID       string    `json:"ExternalID"`
+	AccessTokenHash  string    `json:"-"`
+	RefreshTokenHash string    `json:"-"`
+	CreatedDate      time.Time `json:"TimeCreated"`
+}
+
+//Identities is a user's linked identities.
+type Identities []Identity
+
+//Alexa is the Provider of an identity linked through Alexa account linking. Its ExternalID is the Alexa user id.
+const Alexa = "alexa"
+
+//RefreshTokenPrefix starts every refresh token the API gives a linked service, so one can't be used as a session token.
+const RefreshTokenPrefix = "fcr_"
+
+//refreshTokenBytes is how much randomness goes into a refresh token.
+const refreshTokenBytes = 32
+
+//NewRefreshToken makes a random refresh token and the hash to store for it.
+func NewRefreshToken() (string, string, error) {
+	n := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(n); err != nil {
+		return "", "", err
+	}
+	token := RefreshTokenPrefix + base64.RawURLEncoding.EncodeToString(n)
+	return token, session.HashToken(token), nil
+}
+
+//IsBound says whether the other service has told us which of its accounts the link is for.
+func (i *Identity) IsBound() bool {
+	return i.ExternalID != ""
+}
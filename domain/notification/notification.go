@@ -0,0 +1,34 @@
+package notification
+
+import "time"
+
+//Notification type is the struct in the Domain for a record that a user was told, on one channel, that a dish was about
+//to expire. ExpireDate is when the dish expired at the time, so a dish that is given longer is notified about again.
+type Notification struct {
+	NotificationID int       `json:"NotificationID"`
+	UserID         int       `json:"UserID"`
+	DishID         int       `json:"DishID"`
+	Channel        string    `json:"Channel"`
+	ExpireDate     time.Time `json:"TimeExpires"`
+	SentDate       time.Time `json:"TimeSent"`
+}
+
+//Notifications is the notifications a user was sent.
+type Notifications []Notification
+
+//The channels a notification can go out on.
+const (
+	Email   = "email"
+	Webhook = "webhook"
+	Alexa   = "alexa"
+)
+
+//Sent says whether the user was already told on the channel that the dish expires at expires.
+func (n Notifications) Sent(dishID int, channel string, expires time.Time) bool {
+	for _, sent := range n {
+		if sent.DishID == dishID && sent.Channel == channel && sent.ExpireDate.Equal(expires) {
+			return true
+		}
+	}
+	return false
+}
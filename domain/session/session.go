@@ -0,0 +1,55 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+//Session type is the struct in the Domain for one login. The client holds the bearer token; only its hash is stored,
+//so a copy of the database can't be used to sign in.
+//ImpersonatorID is the admin who started the session to act as the user for support, or 0 for the user's own login.
+type Session struct {
+	SessionID      int       `json:"SessionID"`
+	UserID         int       `json:"UserID"`
+	TokenHash      string    `json:"-"`
+	CreatedDate    time.Time `json:"TimeCreated"`
+	ExpireDate     time.Time `json:"TimeExpires"`
+	RevokedDate    time.Time `json:"TimeRevoked"`
+	ImpersonatorID int       `json:"ImpersonatorID"`
+}
+
+//TokenPrefix starts every token the API mints, which is how a bearer token is told apart from a Google access token.
+const TokenPrefix = "fcs_"
+
+//tokenBytes is how much randomness goes into a token.
+const tokenBytes = 32
+
+//NewToken makes a random session token and the hash to store for it.
+func NewToken() (string, string, error) {
+	n := make([]byte, tokenBytes)
+	if _, err := rand.Read(n); err != nil {
+		return "", "", err
+	}
+	token := TokenPrefix + base64.RawURLEncoding.EncodeToString(n)
+	return token, HashToken(token), nil
+}
+
+//HashToken gives the hex SHA-256 a token is stored and looked up as.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//IsToken says whether a bearer token was minted by the API, rather than coming from Google.
+func IsToken(token string) bool {
+	return strings.HasPrefix(token, TokenPrefix)
+}
+
+//IsActive says whether the session can still be used at the time given - it has not been revoked and has not expired.
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedDate.IsZero() && now.Before(s.ExpireDate)
+}
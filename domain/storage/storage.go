@@ -1,10 +1,12 @@
 package storage
 
 //Storage type is the struct in the Domain that contains all the fields for what a Storage Unit is.
+//UserID is who added the storage unit; it belongs to their household.
 type Storage struct {
 	StorageID   int    `json:"StorageID"`
 	PersonalID  int    `json:"PersonalID"`
 	UserID      int    `json:"UserID"`
+	HouseholdID int    `json:"HouseholdID"`
 	Title       string `json:"Title"`
 	Description string `json:"Description"`
 	TempMatch   string `json:"TempMatch"`
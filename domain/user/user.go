@@ -1,18 +1,31 @@
 package user
 
+import "time"
+
 //User type is the struct in the Domain that contains all the fields for what a User is.
+//TimeZone is an IANA name like "America/Chicago", "" for UTC. DeletionDate is when the user asked for their account to
+//be erased, if they gave it a grace period. QuietStart and QuietEnd are "15:04" times in TimeZone between which they
+//don't want to be notified.
 type User struct {
-	UserID       int    `json:"UserID"`
-	Email        string `json:"Email"`
-	FirstName    string `json:"FirstName"`
-	LastName     string `json:"LastName"`
-	FullName     string `json:"FullName"`
-	CreatedDate  string `json:"TimeCreated"`
-	AccessToken  string `json:"AccessToken"`
-	RefreshToken string `json:"RefreshToken"`
-	AlexaUserID  string `json:"AlexaUserID"`
-	Admin        bool   `json:"IsAdmin"`
-	TempMatch    string `json:"TempMatch"`
+	UserID           int       `json:"UserID"`
+	Email            string    `json:"Email"`
+	FirstName        string    `json:"FirstName"`
+	LastName         string    `json:"LastName"`
+	FullName         string    `json:"FullName"`
+	CreatedDate      string    `json:"TimeCreated"`
+	AccessToken      string    `json:"AccessToken"`
+	RefreshToken     string    `json:"RefreshToken"`
+	TokenExpiry      time.Time `json:"TokenExpires"`
+	TokenProvider    string    `json:"TokenProvider"`
+	Admin            bool      `json:"IsAdmin"`
+	TempMatch        string    `json:"TempMatch"`
+	DeactivatedDate  time.Time `json:"TimeDeactivated"`
+	TimeZone         string    `json:"TimeZone"`
+	NotifyExpiring   bool      `json:"NotifyExpiring"`
+	NotifyDaysBefore int       `json:"NotifyDaysBefore"`
+	DeletionDate     time.Time `json:"TimeDeletion"`
+	QuietStart       string    `json:"QuietStart"`
+	QuietEnd         string    `json:"QuietEnd"`
 }
 
 //OauthUser is what will be populated upon receiving confirmation from Oauth Provider.
@@ -30,5 +43,77 @@ type OauthUser struct {
 //Users is a slice of type User
 type Users []User
 
+//Profile is what a user can change about themselves. An empty string or a nil pointer leaves that part as it is.
+type Profile struct {
+	FirstName        string
+	LastName         string
+	FullName         string
+	TimeZone         string
+	NotifyExpiring   *bool
+	NotifyDaysBefore *int
+	QuietStart       string
+	QuietEnd         string
+}
+
+//QuietFormat is how quiet hours are written, like "22:30".
+const QuietFormat = "15:04"
+
+//DefaultNotifyDaysBefore is how many days ahead a user is told about food expiring when they haven't said.
+const DefaultNotifyDaysBefore = 1
+
+//MaxNotifyDaysBefore is the furthest ahead a user can ask to be told about food expiring.
+const MaxNotifyDaysBefore = 30
+
 //Contains methods and validators that a user would know about themselves
 //
+
+//IsActive says whether the user can use the API - an admin has not deactivated them.
+func (u *User) IsActive() bool {
+	return u.DeactivatedDate.IsZero()
+}
+
+//Location is the user's time zone, or UTC if they haven't set one or it isn't known here.
+func (u *User) Location() *time.Location {
+	if u.TimeZone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(u.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+//NoticeDays is how many days before food expires the user wants to hear about it.
+func (u *User) NoticeDays() int {
+	if u.NotifyDaysBefore <= 0 {
+		return DefaultNotifyDaysBefore
+	}
+	return u.NotifyDaysBefore
+}
+
+//DeletionPending says whether the user has asked for their account to be erased once its grace period is over.
+func (u *User) DeletionPending() bool {
+	return !u.DeletionDate.IsZero()
+}
+
+//InQuietHours says whether t falls in the user's quiet hours, in their time zone. Quiet hours can run past midnight,
+//like 22:00 to 07:00. A user without both ends set, or with the same start and end, has none.
+func (u *User) InQuietHours(t time.Time) bool {
+	start, err := time.Parse(QuietFormat, u.QuietStart)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse(QuietFormat, u.QuietEnd)
+	if err != nil || start.Equal(end) {
+		return false
+	}
+
+	local := t.In(u.Location())
+	minute := local.Hour()*60 + local.Minute()
+	from, until := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	if from < until {
+		return minute >= from && minute < until
+	}
+	return minute >= from || minute < until
+}
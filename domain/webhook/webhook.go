@@ -0,0 +1,202 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+)
+
+//Subscription type is the struct in the Domain for a URL a user wants the events in their household posted to, like
+//Home Assistant's. Events is the types of event it wants, or every type if it is empty. Secret signs each delivery, and
+//is only given back when the subscription is created.
+type Subscription struct {
+	SubscriptionID int       `json:"SubscriptionID"`
+	UserID         int       `json:"UserID"`
+	URL            string    `json:"URL"`
+	Secret         string    `json:"Secret,omitempty"`
+	Events         []string  `json:"Events"`
+	Active         bool      `json:"Active"`
+	CreatedDate    time.Time `json:"TimeCreated"`
+	TempMatch      string    `json:"-"`
+}
+
+//Subscriptions is a user's webhook subscriptions.
+type Subscriptions []Subscription
+
+//Wants says whether an event of type eventType should be posted to the subscription.
+func (s *Subscription) Wants(eventType string) bool {
+	if !s.Active {
+		return false
+	}
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, wanted := range s.Events {
+		if wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+//JoinEvents writes the event types the way they are stored, separated by commas.
+func JoinEvents(events []string) string {
+	return strings.Join(events, ",")
+}
+
+//SplitEvents reads event types stored by JoinEvents.
+func SplitEvents(stored string) []string {
+	if stored == "" {
+		return []string{}
+	}
+	return strings.Split(stored, ",")
+}
+
+//SecretPrefix starts every subscription's secret.
+const SecretPrefix = "whsec_"
+
+//NewSecret makes a random secret to sign a subscription's deliveries with.
+func NewSecret() (string, error) {
+	n := make([]byte, 32)
+	if _, err := rand.Read(n); err != nil {
+		return "", err
+	}
+	return SecretPrefix + base64.RawURLEncoding.EncodeToString(n), nil
+}
+
+//The headers every delivery is posted with.
+const (
+	SignatureHeader = "X-FC-Signature"
+	EventHeader     = "X-FC-Event"
+	DeliveryHeader  = "X-FC-Delivery"
+)
+
+//Sign gives the SignatureHeader of a delivery of body at t: "t=<unix seconds>,v1=<hex HMAC-SHA256>", where the HMAC is
+//of the seconds, a dot and the body, keyed with the subscription's secret. The receiver recomputes it to know the
+//delivery came from us, and checks t so an old delivery can't be replayed.
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+//Verify checks a SignatureHeader against the body, and that it was signed within tolerance of now.
+func Verify(secret string, header string, body []byte, now time.Time, tolerance time.Duration) bool {
+	var timestamp, given string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			given = value
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || given == "" {
+		return false
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(given), []byte(signature(secret, timestamp, body)))
+}
+
+//signature is the hex HMAC-SHA256 of the timestamp, a dot and the body.
+func signature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//Delivery type is the struct in the Domain for one event posted, or to be posted, to a subscription. A delivery that
+//fails is tried again later, waiting twice as long each time, until it has been tried MaxAttempts times and is Dead.
+type Delivery struct {
+	DeliveryID     int       `json:"DeliveryID"`
+	SubscriptionID int       `json:"SubscriptionID"`
+	UserID         int       `json:"UserID"`
+	EventID        string    `json:"EventID"`
+	EventType      string    `json:"EventType"`
+	Payload        string    `json:"Payload"`
+	Status         string    `json:"Status"`
+	Attempts       int       `json:"Attempts"`
+	ResponseCode   int       `json:"ResponseCode"`
+	LastError      string    `json:"LastError"`
+	NextAttempt    time.Time `json:"TimeNextAttempt"`
+	CreatedDate    time.Time `json:"TimeCreated"`
+	UpdatedDate    time.Time `json:"TimeUpdated"`
+	TempMatch      string    `json:"-"`
+}
+
+//Deliveries is a list of deliveries, newest first.
+type Deliveries []Delivery
+
+//The states a delivery can be in.
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	Dead      = "dead"
+)
+
+//MaxAttempts is how many times a delivery is tried before it is given up on as Dead.
+const MaxAttempts = 8
+
+//FirstRetry is how long after the first failed attempt a delivery is tried again.
+const FirstRetry = time.Minute
+
+//maxLastError is the most of a failure's message that is kept.
+const maxLastError = 500
+
+//Backoff is how long to wait after a delivery's attempts-th failed attempt before trying again: FirstRetry, then twice
+//as long each time.
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return FirstRetry << (attempts - 1)
+}
+
+//Succeeded records an attempt the receiver answered with a 2xx code.
+func (d *Delivery) Succeeded(now time.Time, code int) {
+	d.Attempts++
+	d.Status, d.ResponseCode, d.LastError = Delivered, code, ""
+	d.NextAttempt = time.Time{}
+	d.UpdatedDate = dish.CanonicalTime(now)
+}
+
+//Failed records an attempt that didn't get a 2xx code - code is 0 if there was no answer at all - and puts off the
+//next one, or gives up once there have been MaxAttempts.
+func (d *Delivery) Failed(now time.Time, code int, reason string) {
+	d.Attempts++
+	d.ResponseCode = code
+	if len(reason) > maxLastError {
+		reason = reason[:maxLastError]
+	}
+	d.LastError = reason
+	d.UpdatedDate = dish.CanonicalTime(now)
+	if d.Attempts >= MaxAttempts {
+		d.Status, d.NextAttempt = Dead, time.Time{}
+		return
+	}
+	d.Status, d.NextAttempt = Pending, dish.CanonicalTime(now.Add(Backoff(d.Attempts)))
+}
+
+//GiveUp makes the delivery Dead without trying it again, like when its subscription is turned off.
+func (d *Delivery) GiveUp(now time.Time, reason string) {
+	d.Status, d.LastError, d.NextAttempt = Dead, reason, time.Time{}
+	d.UpdatedDate = dish.CanonicalTime(now)
+}
+
+//Retry queues the delivery to be tried again straight away, with its attempts counted from scratch.
+func (d *Delivery) Retry(now time.Time) {
+	d.Status, d.Attempts, d.ResponseCode, d.LastError = Pending, 0, 0, ""
+	d.NextAttempt = dish.CanonicalTime(now)
+	d.UpdatedDate = dish.CanonicalTime(now)
+}
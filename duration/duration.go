@@ -0,0 +1,196 @@
+package duration
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//Duration is an ISO 8601 duration, "PnYnMnWnDTnHnMnS", the format Alexa's AMAZON.DURATION slot gives.
+//Years and months aren't a fixed number of hours, so a Duration can only be turned into a time by adding it to one.
+type Duration struct {
+	Years   float64
+	Months  float64
+	Weeks   float64
+	Days    float64
+	Hours   float64
+	Minutes float64
+	Seconds float64
+}
+
+//maxAmount keeps any one part small enough that adding it to a time can't overflow.
+const maxAmount = 100000
+
+//designator is one "nX" part of a duration and the field it fills.
+type designator struct {
+	letter byte
+	field  func(*Duration) *float64
+}
+
+var dateDesignators = []designator{
+	{'Y', func(d *Duration) *float64 { return &d.Years }},
+	{'M', func(d *Duration) *float64 { return &d.Months }},
+	{'W', func(d *Duration) *float64 { return &d.Weeks }},
+	{'D', func(d *Duration) *float64 { return &d.Days }},
+}
+
+var timeDesignators = []designator{
+	{'H', func(d *Duration) *float64 { return &d.Hours }},
+	{'M', func(d *Duration) *float64 { return &d.Minutes }},
+	{'S', func(d *Duration) *float64 { return &d.Seconds }},
+}
+
+//Parse reads an ISO 8601 duration such as "P2W", "P1Y6M", "PT90M" or "P0.5D". Any part may be a decimal, with a point
+//or a comma, but only the last part given. It returns a 400 FCErr saying what was wrong if the string isn't one.
+func Parse(value string) (Duration, fcerr.FCErr) {
+	var d Duration
+
+	rest := strings.ToUpper(strings.TrimSpace(value))
+	if rest == "" {
+		return d, invalid(value, "it is empty")
+	}
+	if rest[0] != 'P' {
+		return d, invalid(value, "it must start with P")
+	}
+	rest = rest[1:]
+
+	datePart, timePart, timeFound := strings.Cut(rest, "T")
+	if timeFound && timePart == "" {
+		return d, invalid(value, "there is nothing after the T")
+	}
+
+	dateCount, fraction, fcErr := parseParts(&d, value, datePart, dateDesignators)
+	if fcErr != nil {
+		return d, fcErr
+	}
+	timeCount, _, fcErr := parseParts(&d, value, timePart, timeDesignators)
+	if fcErr != nil {
+		return d, fcErr
+	}
+
+	if dateCount+timeCount == 0 {
+		return d, invalid(value, "it has no amounts in it")
+	}
+	if fraction && timeCount > 0 {
+		return d, invalid(value, "only the last amount can have a fraction")
+	}
+	return d, nil
+}
+
+//parseParts reads the "nX" parts of one half of a duration, which have to come in the order of designators.
+//It gives how many parts there were and whether the last one had a fraction.
+func parseParts(d *Duration, value string, part string, designators []designator) (int, bool, fcerr.FCErr) {
+	count := 0
+	fraction := false
+	next := 0
+
+	for part != "" {
+		end := strings.IndexFunc(part, func(r rune) bool { return (r < '0' || r > '9') && r != '.' && r != ',' })
+		if end == -1 {
+			return 0, false, invalid(value, fmt.Sprintf("%q has no letter after it", part))
+		}
+		if end == 0 {
+			return 0, false, invalid(value, fmt.Sprintf("%q has no number before it", part[:1]))
+		}
+
+		letter := part[end]
+		i := next
+		for i < len(designators) && designators[i].letter != letter {
+			i++
+		}
+		if i == len(designators) {
+			return 0, false, invalid(value, fmt.Sprintf("%q is out of order or not a duration letter here", string(letter)))
+		}
+
+		if fraction {
+			return 0, false, invalid(value, "only the last amount can have a fraction")
+		}
+		number := strings.Replace(part[:end], ",", ".", 1)
+		amount, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return 0, false, invalid(value, fmt.Sprintf("%q is not a number", part[:end]))
+		}
+		if amount > maxAmount {
+			return 0, false, invalid(value, fmt.Sprintf("%q is more than %d", part[:end], maxAmount))
+		}
+		fraction = strings.Contains(number, ".") && amount != math.Trunc(amount)
+
+		*designators[i].field(d) = amount
+		next = i + 1
+		count++
+		part = part[end+1:]
+	}
+
+	return count, fraction, nil
+}
+
+//invalid is the 400 for a value that isn't a duration, with the reason why.
+func invalid(value string, reason string) fcerr.FCErr {
+	return fcerr.NewValidationError(fmt.Sprintf("%q is not an ISO 8601 duration like P3D or PT12H: %s", value, reason))
+}
+
+//AddTo gives t plus the duration. Years and months are added on the calendar, so P1M from January 31st is the last
+//day of February and P1Y from March 1st is the next March 1st whether or not there is a February 29th in between.
+//A fraction of a year is that many months, and a fraction of a month is that share of the days in the month it lands in.
+func (d Duration) AddTo(t time.Time) time.Time {
+	months := d.Years*12 + d.Months
+	wholeMonths := math.Trunc(months)
+	t = addMonths(t, int(wholeMonths))
+
+	if partMonth := months - wholeMonths; partMonth > 0 {
+		daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+		t = t.Add(time.Duration(partMonth * float64(daysInMonth) * float64(24*time.Hour)))
+	}
+
+	days := d.Weeks*7 + d.Days
+	wholeDays := math.Trunc(days)
+	t = t.AddDate(0, 0, int(wholeDays))
+
+	rest := (days-wholeDays)*float64(24*time.Hour) +
+		d.Hours*float64(time.Hour) + d.Minutes*float64(time.Minute) + d.Seconds*float64(time.Second)
+	return t.Add(time.Duration(math.Round(rest)))
+}
+
+//addMonths moves t by whole months, keeping the day of the month unless the new month is too short for it.
+func addMonths(t time.Time, months int) time.Time {
+	if months == 0 {
+		return t
+	}
+	firstOfMonth := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return firstOfMonth.AddDate(0, 0, day-1)
+}
+
+//String gives the duration back in ISO 8601 form, leaving out the parts that are zero.
+func (d Duration) String() string {
+	var b strings.Builder
+	write := func(amount float64, letter string) {
+		if amount != 0 {
+			b.WriteString(strconv.FormatFloat(amount, 'f', -1, 64) + letter)
+		}
+	}
+
+	b.WriteString("P")
+	write(d.Years, "Y")
+	write(d.Months, "M")
+	write(d.Weeks, "W")
+	write(d.Days, "D")
+	if d.Hours != 0 || d.Minutes != 0 || d.Seconds != 0 {
+		b.WriteString("T")
+		write(d.Hours, "H")
+		write(d.Minutes, "M")
+		write(d.Seconds, "S")
+	}
+	if b.Len() == 1 {
+		return "PT0S"
+	}
+	return b.String()
+}
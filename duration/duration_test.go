@@ -0,0 +1,93 @@
+package duration
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var created = time.Date(2021, 1, 31, 18, 30, 0, 0, time.UTC)
+
+func TestDuration_Parse(t *testing.T) {
+	cases := map[string]Duration{
+		"P2W":              {Weeks: 2},
+		"P1Y3DT2M":         {Years: 1, Days: 3, Minutes: 2},
+		"P1MT2H30S":        {Months: 1, Hours: 2, Seconds: 30},
+		"PT90M":            {Minutes: 90},
+		"P0.5D":            {Days: 0.5},
+		"PT1,5H":           {Hours: 1.5},
+		"P1Y2M3W4DT5H6M7S": {Years: 1, Months: 2, Weeks: 3, Days: 4, Hours: 5, Minutes: 6, Seconds: 7},
+		" p3d ":            {Days: 3},
+	}
+	for value, expected := range cases {
+		d, err := Parse(value)
+		assert.Nil(t, err, value)
+		assert.Equal(t, expected, d, value)
+	}
+}
+
+func TestDuration_Parse_Invalid(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"   ",
+		"3D",
+		"P",
+		"PT",
+		"P3DT",
+		"P1aY1M1DT2H2M30S",
+		"P1Ya1M1DT2H2M30S",
+		"P1Y1M1DT2H2M3d0S",
+		"P3D1Y",
+		"P1Y1Y",
+		"PT3D",
+		"P1.5Y2M",
+		"P1.5DT2H",
+		"P-3D",
+		"P1..5D",
+		"P3",
+		"P999999999Y",
+	} {
+		_, err := Parse(value)
+		if assert.NotNil(t, err, value) {
+			assert.Equal(t, http.StatusBadRequest, err.Status(), value)
+		}
+	}
+}
+
+func TestDuration_AddTo(t *testing.T) {
+	cases := map[string]time.Time{
+		"P2W":    time.Date(2021, 2, 14, 18, 30, 0, 0, time.UTC),
+		"P1M":    time.Date(2021, 2, 28, 18, 30, 0, 0, time.UTC),
+		"P13M":   time.Date(2022, 2, 28, 18, 30, 0, 0, time.UTC),
+		"P1Y":    time.Date(2022, 1, 31, 18, 30, 0, 0, time.UTC),
+		"P0.5Y":  time.Date(2021, 7, 31, 18, 30, 0, 0, time.UTC),
+		"P1.5D":  time.Date(2021, 2, 2, 6, 30, 0, 0, time.UTC),
+		"PT90M":  time.Date(2021, 1, 31, 20, 0, 0, 0, time.UTC),
+		"PT0.5S": time.Date(2021, 1, 31, 18, 30, 0, 500000000, time.UTC),
+		"P1MT1H": time.Date(2021, 2, 28, 19, 30, 0, 0, time.UTC),
+	}
+	for value, expected := range cases {
+		d, err := Parse(value)
+		assert.Nil(t, err, value)
+		assert.Equal(t, expected, d.AddTo(created), value)
+	}
+
+	//half of February 2021 is 14 days
+	d, _ := Parse("P1.5M")
+	assert.Equal(t, time.Date(2021, 3, 14, 18, 30, 0, 0, time.UTC), d.AddTo(created))
+
+	//a year from a leap day lands on the last day of February
+	d, _ = Parse("P1Y")
+	assert.Equal(t, time.Date(2021, 2, 28, 0, 0, 0, 0, time.UTC), d.AddTo(time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestDuration_String(t *testing.T) {
+	for _, value := range []string{"P2W", "P1Y3DT2M", "PT1.5H", "P1Y2M3W4DT5H6M7S"} {
+		d, err := Parse(value)
+		assert.Nil(t, err, value)
+		assert.Equal(t, value, d.String())
+	}
+	assert.Equal(t, "PT0S", Duration{}.String())
+}
@@ -0,0 +1,72 @@
+package eventbus
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/event"
+)
+
+//Bus hands every event published on it to each of its subscribers, in the order they subscribed. Subscribers are
+//called on the publisher's goroutine, so they should hand the event off rather than do slow work. A nil *Bus drops
+//every event, so services can be made without one.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers []subscriber
+	lastID      int
+}
+
+type subscriber struct {
+	id      int
+	receive func(event.Event)
+}
+
+//New gives a Bus with no subscribers.
+func New() *Bus {
+	return &Bus{}
+}
+
+//Subscribe has receive called with every event published from now on, until the returned function is called.
+func (b *Bus) Subscribe(receive func(event.Event)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	id := b.lastID
+	b.subscribers = append(b.subscribers, subscriber{id: id, receive: receive})
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		remaining := b.subscribers[:0]
+		for _, s := range b.subscribers {
+			if s.id != id {
+				remaining = append(remaining, s)
+			}
+		}
+		b.subscribers = remaining
+	}
+}
+
+//Publish hands e to every subscriber. A subscriber that panics is logged and doesn't stop the others.
+func (b *Bus) Publish(e event.Event) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	subscribers := append([]subscriber(nil), b.subscribers...)
+	b.mu.RUnlock()
+
+	for _, s := range subscribers {
+		deliver(s, e)
+	}
+}
+
+//deliver calls one subscriber, recovering if it panics.
+func deliver(s subscriber, e event.Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("an event subscriber panicked on", e.Type, e.EventID, "-", r)
+		}
+	}()
+	s.receive(e)
+}
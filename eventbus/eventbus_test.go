@@ -0,0 +1,29 @@
+package eventbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus_Publish(t *testing.T) {
+	b := New()
+	var first, second []string
+	unsubscribe := b.Subscribe(func(e event.Event) { first = append(first, e.Type) })
+	b.Subscribe(func(e event.Event) { panic("broken subscriber") })
+	b.Subscribe(func(e event.Event) { second = append(second, e.Type) })
+
+	b.Publish(event.New(event.DishCreated, 1, 1, time.Now(), nil))
+	unsubscribe()
+	b.Publish(event.New(event.DishDeleted, 1, 1, time.Now(), nil))
+
+	assert.Equal(t, []string{event.DishCreated}, first)
+	assert.Equal(t, []string{event.DishCreated, event.DishDeleted}, second, "a subscriber that panics doesn't stop the others")
+}
+
+func TestBus_Nil(t *testing.T) {
+	var b *Bus
+	assert.NotPanics(t, func() { b.Publish(event.New(event.DishCreated, 1, 1, time.Now(), nil)) })
+}
@@ -3,21 +3,43 @@ package fcerr
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 //FCErr is a custom Error interface that uses a message and an int to provide consistant, informative error support.
 type FCErr interface {
 	Message() string
 	Status() int
+	Code() string
+	Details() []FieldDetail
 	Error() string
 }
 
+//FieldDetail points at one request field that was wrong, and says what was wrong with it.
+type FieldDetail struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
 type fcerr struct {
-	ErrMessage string `json:"message"`
-	ErrStatus  int    `json:"status"`
-	ErrError   string `json:"error"`
+	ErrMessage string        `json:"message"`
+	ErrStatus  int           `json:"status"`
+	ErrError   string        `json:"error"`
+	ErrDetails []FieldDetail `json:"details,omitempty"`
+	cause      error
 }
 
+//Sentinels for errors.Is - any FCErr with the same status matches, whatever its message, e.g. errors.Is(err, fcerr.ErrNotFound).
+var (
+	ErrBadRequest          FCErr = fcerr{ErrStatus: http.StatusBadRequest}
+	ErrUnauthorized        FCErr = fcerr{ErrStatus: http.StatusUnauthorized}
+	ErrForbidden           FCErr = fcerr{ErrStatus: http.StatusForbidden}
+	ErrNotFound            FCErr = fcerr{ErrStatus: http.StatusNotFound}
+	ErrConflict            FCErr = fcerr{ErrStatus: http.StatusConflict}
+	ErrInternalServerError FCErr = fcerr{ErrStatus: http.StatusInternalServerError}
+	ErrGatewayTimeout      FCErr = fcerr{ErrStatus: http.StatusGatewayTimeout}
+)
+
 //Message is the simple message to return the string that comprises the message of the error.
 func (e fcerr) Message() string {
 	return e.ErrMessage
@@ -27,10 +49,50 @@ func (e fcerr) Status() int {
 	return e.ErrStatus
 }
 
+//Code is the status as a short snake_case word, e.g. "not_found", for clients that would rather not switch on numbers.
+func (e fcerr) Code() string {
+	text := http.StatusText(e.ErrStatus)
+	if text == "" {
+		return "error"
+	}
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+}
+
+//Details gives the request fields that caused the error, if any.
+func (e fcerr) Details() []FieldDetail {
+	return e.ErrDetails
+}
+
 func (e fcerr) Error() string {
+	if e.cause != nil {
+		return fmt.Sprint("Message: ", e.ErrMessage, " - Status: ", e.ErrStatus, " - Cause: ", e.cause.Error())
+	}
 	return fmt.Sprint("Message: ", e.ErrMessage, " - Status: ", e.ErrStatus)
 }
 
+//Unwrap gives the error this one was wrapped around with Wrap, or nil.
+func (e fcerr) Unwrap() error {
+	return e.cause
+}
+
+//Is matches the sentinel errors above by status.
+func (e fcerr) Is(target error) bool {
+	t, ok := target.(fcerr)
+	return ok && t.ErrMessage == "" && t.ErrStatus == e.ErrStatus
+}
+
+//Wrap takes the error that caused a failure, a message string and a status int and gives you a FCErr object
+//that still carries the cause for errors.Is and errors.As.
+func Wrap(cause error, message string, status int) FCErr {
+	err := fmt.Sprint("Message: ", message, " - Status: ", status)
+	return fcerr{
+		ErrMessage: message,
+		ErrStatus:  status,
+		ErrError:   err,
+		cause:      cause,
+	}
+}
+
 //NewFCErr takes a message string and a status int and gives you the final FCErr object.
 func NewFCErr(message string, status int) FCErr {
 	err := fmt.Sprint("Message: ", message, " - Status: ", status)
@@ -90,3 +152,34 @@ func NewForbiddenError(message string) FCErr {
 		ErrError:   err,
 	}
 }
+
+//NewConflictError takes a message string and gives you a FCErr object with the status of http.StatusConflict.
+func NewConflictError(message string) FCErr {
+	err := fmt.Sprint("Message: ", message, " - Status: ", http.StatusConflict)
+	return fcerr{
+		ErrMessage: message,
+		ErrStatus:  http.StatusConflict,
+		ErrError:   err,
+	}
+}
+
+//NewGatewayTimeoutError takes a message string and gives you a FCErr object with the status of http.StatusGatewayTimeout.
+func NewGatewayTimeoutError(message string) FCErr {
+	err := fmt.Sprint("Message: ", message, " - Status: ", http.StatusGatewayTimeout)
+	return fcerr{
+		ErrMessage: message,
+		ErrStatus:  http.StatusGatewayTimeout,
+		ErrError:   err,
+	}
+}
+
+//NewValidationError takes a message string and the fields that were wrong, and gives you a FCErr object with the status of http.StatusBadRequest.
+func NewValidationError(message string, details ...FieldDetail) FCErr {
+	err := fmt.Sprint("Message: ", message, " - Status: ", http.StatusBadRequest)
+	return fcerr{
+		ErrMessage: message,
+		ErrStatus:  http.StatusBadRequest,
+		ErrError:   err,
+		ErrDetails: details,
+	}
+}
@@ -0,0 +1,48 @@
+package fcerr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFCErr_Is(t *testing.T) {
+	err := NewNotFoundError("Database could not find a dish with this ID")
+
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrInternalServerError))
+	assert.False(t, errors.Is(err, NewNotFoundError("some other message")))
+}
+
+func TestFCErr_Wrap(t *testing.T) {
+	dbErr := Wrap(context.DeadlineExceeded, "The database ran out of time", http.StatusGatewayTimeout)
+	serviceErr := Wrap(dbErr, "Could not get the dishes", http.StatusInternalServerError)
+
+	assert.Equal(t, http.StatusInternalServerError, serviceErr.Status())
+	assert.Equal(t, "Could not get the dishes", serviceErr.Message())
+	assert.True(t, errors.Is(serviceErr, context.DeadlineExceeded))
+	assert.True(t, errors.Is(serviceErr, ErrGatewayTimeout))
+	assert.Equal(t, dbErr, errors.Unwrap(serviceErr))
+	assert.Contains(t, serviceErr.Error(), "Cause:")
+
+	var fcErr FCErr
+	assert.True(t, errors.As(error(serviceErr), &fcErr))
+	assert.Nil(t, errors.Unwrap(NewBadRequestError("no cause")))
+}
+
+func TestFCErr_CodeAndDetails(t *testing.T) {
+	assert.Equal(t, "not_found", NewNotFoundError("").Code())
+	assert.Equal(t, "internal_server_error", NewInternalServerError("").Code())
+	assert.Equal(t, "gateway_timeout", NewGatewayTimeoutError("").Code())
+	assert.Equal(t, "conflict", NewConflictError("").Code())
+	assert.True(t, errors.Is(NewConflictError("in another household"), ErrConflict))
+	assert.Equal(t, "error", NewFCErr("", 599).Code())
+
+	err := NewValidationError("The storageID must be a number", FieldDetail{Field: "storageID", Message: "not a number"})
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+	assert.Equal(t, []FieldDetail{{Field: "storageID", Message: "not a number"}}, err.Details())
+	assert.Nil(t, NewBadRequestError("").Details())
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"os"
+
 	"github.com/jasonradcliffe/freshness-countdown-api/app"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "modernc.org/sqlite"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		app.RunMigrations(os.Args[2:])
+		return
+	}
 	app.StartApplication()
 }
@@ -0,0 +1,89 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//Claims are the standard claims about a user, from an ID token or a userinfo endpoint.
+type Claims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+}
+
+//UnmarshalJSON reads the claims, taking email_verified as either a boolean or the string "true" - some providers
+//send it as a string.
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	type plainClaims Claims
+	var raw struct {
+		plainClaims
+		EmailVerified interface{} `json:"email_verified"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*c = Claims(raw.plainClaims)
+	switch verified := raw.EmailVerified.(type) {
+	case bool:
+		c.EmailVerified = verified
+	case string:
+		c.EmailVerified = verified == "true"
+	}
+	return nil
+}
+
+//OauthUser gives the claims as the user the rest of the api works with.
+func (c *Claims) OauthUser() user.OauthUser {
+	return user.OauthUser{
+		Email:         c.Email,
+		VerifiedEmail: c.EmailVerified,
+		FirstName:     c.GivenName,
+		LastName:      c.FamilyName,
+		FullName:      c.Name,
+	}
+}
+
+//FetchUserInfo asks the userinfo endpoint at url who accessToken belongs to. It is a 401 when the provider doesn't
+//accept the token.
+func FetchUserInfo(ctx context.Context, httpClient *http.Client, url string, accessToken string) (*Claims, fcerr.FCErr) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Error when setting up the network request", http.StatusInternalServerError)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	response, err := httpClient.Do(req)
+	if err != nil {
+		fmt.Println("error when getting the userinfo with the access token")
+		return nil, fcerr.Wrap(err, "Error when trying to verify user identity", http.StatusInternalServerError)
+	}
+	defer response.Body.Close()
+
+	contents, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Error when trying to read response from the identity provider about user identity", http.StatusInternalServerError)
+	}
+	if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
+		return nil, fcerr.NewUnauthorizedError("The identity provider did not accept the access token")
+	}
+	if response.StatusCode >= http.StatusMultipleChoices {
+		fmt.Println("got status", response.StatusCode, "from the userinfo endpoint")
+		return nil, fcerr.NewFCErr(fmt.Sprintf("The identity provider answered with status %d", response.StatusCode), http.StatusBadGateway)
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(contents, &claims); err != nil {
+		return nil, fcerr.Wrap(err, "Could not Unmarshal the data received from the AccessToken request into a valid user.", http.StatusInternalServerError)
+	}
+	return &claims, nil
+}
@@ -0,0 +1,9 @@
+package oidc
+
+import "time"
+
+//SetNow moves the provider's clock, for the tests in oidc_test.
+func SetNow(p *Provider, now func() time.Time) {
+	p.now = now
+	p.keys.now = now
+}
@@ -0,0 +1,230 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//clockSkew is how far the provider's clock and ours may disagree when checking an ID token's times.
+const clockSkew = time.Minute
+
+//keyRefetchInterval is the least time between fetching the provider's keys again for a key id we don't know,
+//so a stream of bad tokens can't make every request fetch them.
+const keyRefetchInterval = time.Minute
+
+//IDToken is an ID token whose signature and claims have been checked.
+type IDToken struct {
+	Claims
+	Issuer   string
+	Audience []string
+	Expiry   time.Time
+	IssuedAt time.Time
+	Nonce    string
+}
+
+type idTokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type idTokenClaims struct {
+	Issuer          string   `json:"iss"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Expiry          float64  `json:"exp"`
+	IssuedAt        float64  `json:"iat"`
+	Nonce           string   `json:"nonce"`
+}
+
+//audience is the aud claim, which is either one string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+//VerifyIDToken checks rawIDToken was signed by the provider, is for this client and hasn't expired, and gives its claims.
+//A token that fails any check is a 401.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken string) (*IDToken, fcerr.FCErr) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, fcerr.NewUnauthorizedError("The ID token is not a signed JWT")
+	}
+
+	var header idTokenHeader
+	if fcErr := decodeSegment(parts[0], &header); fcErr != nil {
+		return nil, fcErr
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fcerr.NewUnauthorizedError("The ID token is signed with " + header.Algorithm + ", only RS256 is accepted")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fcerr.NewUnauthorizedError("The ID token's signature is not valid base64")
+	}
+
+	key, fcErr := p.keys.get(ctx, header.KeyID)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fcerr.NewUnauthorizedError("The ID token's signature does not match " + p.DisplayName() + "'s keys")
+	}
+
+	var claims idTokenClaims
+	if fcErr := decodeSegment(parts[1], &claims); fcErr != nil {
+		return nil, fcErr
+	}
+	token := &IDToken{
+		Issuer:   claims.Issuer,
+		Audience: claims.Audience,
+		Expiry:   time.Unix(int64(claims.Expiry), 0).UTC(),
+		IssuedAt: time.Unix(int64(claims.IssuedAt), 0).UTC(),
+		Nonce:    claims.Nonce,
+	}
+	if fcErr := decodeSegment(parts[1], &token.Claims); fcErr != nil {
+		return nil, fcErr
+	}
+
+	now := p.now()
+	switch {
+	case token.Issuer != p.metadata.Issuer:
+		return nil, fcerr.NewUnauthorizedError("The ID token was issued by " + token.Issuer + ", not " + p.metadata.Issuer)
+	case !contains(token.Audience, p.config.ClientID):
+		return nil, fcerr.NewUnauthorizedError("The ID token is not for this client")
+	case len(token.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, fcerr.NewUnauthorizedError("The ID token was not issued to this client")
+	case claims.Expiry == 0 || !now.Before(token.Expiry.Add(clockSkew)):
+		return nil, fcerr.NewUnauthorizedError("The ID token has expired")
+	case token.IssuedAt.After(now.Add(clockSkew)):
+		return nil, fcerr.NewUnauthorizedError("The ID token was issued in the future")
+	case token.Subject == "":
+		return nil, fcerr.NewUnauthorizedError("The ID token has no subject")
+	}
+	return token, nil
+}
+
+//decodeSegment decodes one base64url part of a JWT into v.
+func decodeSegment(segment string, v interface{}) fcerr.FCErr {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fcerr.NewUnauthorizedError("The ID token is not valid base64")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fcerr.NewUnauthorizedError("The ID token is not valid JSON")
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//keySet is the provider's signing keys from its JWKS endpoint, fetched when first needed and again when a token is
+//signed with a key id we haven't seen - which is how providers rotate keys.
+type keySet struct {
+	mu         sync.Mutex
+	url        string
+	httpClient *http.Client
+	keys       map[string]*rsa.PublicKey
+	fetched    time.Time
+	now        func() time.Time
+}
+
+type jsonWebKeys struct {
+	Keys []struct {
+		KeyType string `json:"kty"`
+		KeyID   string `json:"kid"`
+		Use     string `json:"use"`
+		N       string `json:"n"`
+		E       string `json:"e"`
+	} `json:"keys"`
+}
+
+func newKeySet(url string, httpClient *http.Client) *keySet {
+	return &keySet{url: url, httpClient: httpClient, now: time.Now}
+}
+
+//get gives the key with the given id. A token without a key id can only use the provider's key when it has just one.
+func (ks *keySet) get(ctx context.Context, keyID string) (*rsa.PublicKey, fcerr.FCErr) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if key := ks.find(keyID); key != nil {
+		return key, nil
+	}
+	if ks.keys != nil && ks.now().Sub(ks.fetched) < keyRefetchInterval {
+		return nil, fcerr.NewUnauthorizedError("The ID token is signed with a key the provider does not list")
+	}
+
+	if fcErr := ks.fetch(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	if key := ks.find(keyID); key != nil {
+		return key, nil
+	}
+	return nil, fcerr.NewUnauthorizedError("The ID token is signed with a key the provider does not list")
+}
+
+//find looks the key up in what was last fetched. Callers hold mu.
+func (ks *keySet) find(keyID string) *rsa.PublicKey {
+	if keyID == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key
+		}
+	}
+	return ks.keys[keyID]
+}
+
+//fetch replaces the keys with what the JWKS endpoint lists now. Callers hold mu.
+func (ks *keySet) fetch(ctx context.Context) fcerr.FCErr {
+	var set jsonWebKeys
+	if fcErr := getJSON(ctx, ks.httpClient, ks.url, &set); fcErr != nil {
+		return fcErr
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, nErr := base64.RawURLEncoding.DecodeString(k.N)
+		e, eErr := base64.RawURLEncoding.DecodeString(k.E)
+		if nErr != nil || eErr != nil || len(e) == 0 || len(e) > 4 {
+			fmt.Println("skipping a malformed key from", ks.url, "with key id", k.KeyID)
+			continue
+		}
+		keys[k.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	ks.keys = keys
+	ks.fetched = ks.now()
+	return nil
+}
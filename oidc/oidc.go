@@ -0,0 +1,181 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"golang.org/x/oauth2"
+)
+
+//GoogleIssuer is Google's OpenID Connect issuer, used when the config file doesn't list any providers.
+const GoogleIssuer = "https://accounts.google.com"
+
+//GoogleUserInfoURL is Google's userinfo endpoint, for checking access tokens when no provider was configured.
+const GoogleUserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+
+//DefaultScopes are asked for when a provider's config doesn't list any.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+//discoveryTimeout bounds each request to a provider that isn't already under a request's deadline.
+const discoveryTimeout = 10 * time.Second
+
+//Config is one identity provider from the config file. The endpoints aren't needed - they come from the issuer's
+//discovery document.
+type Config struct {
+	Name         string   `json:"name"`
+	DisplayName  string   `json:"displayName"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientid"`
+	ClientSecret string   `json:"clientsecret"`
+	RedirectURL  string   `json:"redirectURL"`
+	Scopes       []string `json:"scopes"`
+}
+
+//Metadata is the part of an issuer's discovery document that is used here.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+//Provider is an OpenID Connect identity provider users can sign in with.
+type Provider struct {
+	config     Config
+	metadata   Metadata
+	oauth      *oauth2.Config
+	httpClient *http.Client
+	keys       *keySet
+	now        func() time.Time
+}
+
+//Discover reads the issuer's discovery document and gives you a Provider that signs users in with it.
+//A nil httpClient uses one with a timeout.
+func Discover(ctx context.Context, config Config, httpClient *http.Client) (*Provider, fcerr.FCErr) {
+	if config.Name == "" || config.Issuer == "" {
+		return nil, fcerr.NewInternalServerError("An identity provider needs a name and an issuer")
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: discoveryTimeout}
+	}
+
+	discoveryURL := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	var metadata Metadata
+	if fcErr := getJSON(ctx, httpClient, discoveryURL, &metadata); fcErr != nil {
+		return nil, fcErr
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != strings.TrimSuffix(config.Issuer, "/") {
+		return nil, fcerr.NewFCErr(fmt.Sprintf("The discovery document for %s is for the issuer %q", config.Issuer, metadata.Issuer), http.StatusBadGateway)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fcerr.NewFCErr("The discovery document for "+config.Issuer+" is missing an endpoint", http.StatusBadGateway)
+	}
+
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	p := &Provider{
+		config:   config,
+		metadata: metadata,
+		oauth: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Scopes:       scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  metadata.AuthorizationEndpoint,
+				TokenURL: metadata.TokenEndpoint,
+			},
+		},
+		httpClient: httpClient,
+		now:        time.Now,
+	}
+	p.keys = newKeySet(metadata.JWKSURI, httpClient)
+	return p, nil
+}
+
+//Name is what the provider is called in the config file and in login URLs.
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+//DisplayName is what the provider is called on the login page - its name when the config doesn't give one.
+func (p *Provider) DisplayName() string {
+	if p.config.DisplayName == "" {
+		return p.config.Name
+	}
+	return p.config.DisplayName
+}
+
+//Issuer is the provider's issuer identifier, as its ID tokens carry it.
+func (p *Provider) Issuer() string {
+	return p.metadata.Issuer
+}
+
+//UserInfoURL is the provider's userinfo endpoint - "" if it doesn't have one.
+func (p *Provider) UserInfoURL() string {
+	return p.metadata.UserinfoEndpoint
+}
+
+//AuthCodeURL is where a user is sent to sign in with the provider.
+func (p *Provider) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	return p.oauth.AuthCodeURL(state, opts...)
+}
+
+//Exchange trades the code the provider redirected back with for the user's tokens.
+func (p *Provider) Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return p.oauth.Exchange(p.clientContext(ctx), code, opts...)
+}
+
+//TokenSource gives the token while it is valid, then refreshes it with the provider.
+func (p *Provider) TokenSource(ctx context.Context, t *oauth2.Token) oauth2.TokenSource {
+	return p.oauth.TokenSource(p.clientContext(ctx), t)
+}
+
+//UserInfo asks the provider who the access token belongs to.
+func (p *Provider) UserInfo(ctx context.Context, accessToken string) (*Claims, fcerr.FCErr) {
+	if p.metadata.UserinfoEndpoint == "" {
+		return nil, fcerr.NewFCErr(p.DisplayName()+" does not have a userinfo endpoint", http.StatusBadGateway)
+	}
+	return FetchUserInfo(ctx, p.httpClient, p.metadata.UserinfoEndpoint, accessToken)
+}
+
+//clientContext has the oauth2 package use the provider's http client.
+func (p *Provider) clientContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, p.httpClient)
+}
+
+//getJSON gets url and decodes the JSON it answers with into v.
+func getJSON(ctx context.Context, httpClient *http.Client, url string, v interface{}) fcerr.FCErr {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fcerr.Wrap(err, "Error when setting up the network request", http.StatusInternalServerError)
+	}
+	response, err := httpClient.Do(req)
+	if err != nil {
+		fmt.Println("error when getting", url, ":", err.Error())
+		return fcerr.Wrap(err, "Could not reach the identity provider", http.StatusBadGateway)
+	}
+	defer response.Body.Close()
+
+	contents, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fcerr.Wrap(err, "Could not read the identity provider's response", http.StatusBadGateway)
+	}
+	if response.StatusCode != http.StatusOK {
+		fmt.Println("got status", response.StatusCode, "from", url)
+		return fcerr.NewFCErr(fmt.Sprintf("The identity provider answered with status %d", response.StatusCode), http.StatusBadGateway)
+	}
+	if err := json.Unmarshal(contents, v); err != nil {
+		return fcerr.Wrap(err, "The identity provider's response was not valid JSON", http.StatusBadGateway)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc"
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc/oidctest"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+//newStubProvider starts a stub provider and discovers it.
+func newStubProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	stub := oidctest.NewServer()
+	t.Cleanup(stub.Close)
+
+	p, err := oidc.Discover(context.Background(), stub.Config("stub", "https://fcapi.example.com/success"), nil)
+	if err != nil {
+		t.Fatal(err.Message())
+	}
+	return stub, p
+}
+
+//claimsFor are the claims of a valid ID token from stub, to change one at a time.
+func claimsFor(stub *oidctest.Server) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            stub.URL,
+		"aud":            stub.ClientID,
+		"sub":            "12345",
+		"email":          "nothing@gmail.com",
+		"email_verified": "true",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestOIDC_Discover(t *testing.T) {
+	stub, p := newStubProvider(t)
+
+	assert.Equal(t, "stub", p.Name())
+	assert.Equal(t, "stub", p.DisplayName())
+	assert.Equal(t, stub.URL, p.Issuer())
+	assert.Equal(t, stub.URL+"/userinfo", p.UserInfoURL())
+
+	authURL := p.AuthCodeURL("some-state")
+	assert.True(t, strings.HasPrefix(authURL, stub.URL+"/authorize?"))
+	assert.Contains(t, authURL, "scope=openid+email+profile")
+}
+
+func TestOIDC_Discover_IssuerMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"issuer": "https://someone-else.example.com", "authorization_endpoint": "a", "token_endpoint": "t", "jwks_uri": "j"}`))
+	}))
+	defer server.Close()
+
+	_, err := oidc.Discover(context.Background(), oidc.Config{Name: "bad", Issuer: server.URL}, nil)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusBadGateway, err.Status())
+	}
+
+	_, err = oidc.Discover(context.Background(), oidc.Config{Issuer: server.URL}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestOIDC_LoginFlow(t *testing.T) {
+	stub, p := newStubProvider(t)
+
+	redirect, err := stub.Authorize(p.AuthCodeURL("some-state"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "some-state", redirect.Query().Get("state"))
+
+	token, err := p.Exchange(context.Background(), redirect.Query().Get("code"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	idToken, fcErr := p.VerifyIDToken(context.Background(), rawIDToken)
+	if assert.Nil(t, fcErr) {
+		assert.Equal(t, oidctest.DefaultUser.Subject, idToken.Subject)
+		assert.Equal(t, oidctest.DefaultUser.Email, idToken.Email)
+		assert.True(t, idToken.EmailVerified)
+	}
+
+	info, fcErr := p.UserInfo(context.Background(), token.AccessToken)
+	if assert.Nil(t, fcErr) {
+		assert.Equal(t, oidctest.DefaultUser.Subject, info.Subject)
+		assert.Equal(t, "Bob", info.OauthUser().FirstName)
+	}
+
+	_, fcErr = p.UserInfo(context.Background(), "not-a-token")
+	assert.Equal(t, http.StatusUnauthorized, fcErr.Status())
+}
+
+func TestOIDC_VerifyIDToken_Claims(t *testing.T) {
+	stub, p := newStubProvider(t)
+
+	idToken, err := p.VerifyIDToken(context.Background(), stub.SignIDToken(claimsFor(stub)))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "12345", idToken.Subject)
+		assert.True(t, idToken.EmailVerified)
+	}
+
+	tests := []struct {
+		name  string
+		claim string
+		value interface{}
+	}{
+		{"wrong issuer", "iss", "https://someone-else.example.com"},
+		{"wrong audience", "aud", "another-client"},
+		{"expired", "exp", time.Now().Add(-time.Hour).Unix()},
+		{"no expiry", "exp", nil},
+		{"issued in the future", "iat", time.Now().Add(time.Hour).Unix()},
+		{"no subject", "sub", ""},
+		{"several audiences without azp", "aud", []string{stub.ClientID, "another-client"}},
+	}
+	for _, tt := range tests {
+		claims := claimsFor(stub)
+		if tt.value == nil {
+			delete(claims, tt.claim)
+		} else {
+			claims[tt.claim] = tt.value
+		}
+		_, err := p.VerifyIDToken(context.Background(), stub.SignIDToken(claims))
+		if assert.NotNil(t, err, tt.name) {
+			assert.Equal(t, http.StatusUnauthorized, err.Status(), tt.name)
+		}
+	}
+
+	claims := claimsFor(stub)
+	claims["aud"] = []string{stub.ClientID, "another-client"}
+	claims["azp"] = stub.ClientID
+	_, err = p.VerifyIDToken(context.Background(), stub.SignIDToken(claims))
+	assert.Nil(t, err)
+}
+
+func TestOIDC_VerifyIDToken_Signature(t *testing.T) {
+	stub, p := newStubProvider(t)
+	valid := stub.SignIDToken(claimsFor(stub))
+	parts := strings.Split(valid, ".")
+
+	//the payload changed after signing
+	forged := claimsFor(stub)
+	forged["sub"] = "someone-else"
+	forgedParts := strings.Split(stub.SignIDToken(forged), ".")
+	_, err := p.VerifyIDToken(context.Background(), parts[0]+"."+forgedParts[1]+"."+parts[2])
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+
+	//an unsigned token
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	_, err = p.VerifyIDToken(context.Background(), none+"."+parts[1]+".")
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+
+	_, err = p.VerifyIDToken(context.Background(), "not-a-jwt")
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+
+	//signed by a different provider
+	otherStub, _ := newStubProvider(t)
+	_, err = p.VerifyIDToken(context.Background(), otherStub.SignIDToken(claimsFor(stub)))
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+}
+
+func TestOIDC_VerifyIDToken_KeyRotation(t *testing.T) {
+	stub, p := newStubProvider(t)
+	_, err := p.VerifyIDToken(context.Background(), stub.SignIDToken(claimsFor(stub)))
+	assert.Nil(t, err)
+
+	//a token signed with a key published after the keys were fetched is only looked for once they are a minute old
+	stub.RotateKey()
+	rotated := stub.SignIDToken(claimsFor(stub))
+	_, err = p.VerifyIDToken(context.Background(), rotated)
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+
+	oidc.SetNow(p, func() time.Time { return time.Now().Add(time.Minute) })
+	_, err = p.VerifyIDToken(context.Background(), rotated)
+	assert.Nil(t, err)
+}
+
+func TestOIDC_Refresh(t *testing.T) {
+	stub, p := newStubProvider(t)
+	accessToken, refreshToken, _ := stub.IssueTokens(oidctest.DefaultUser)
+
+	expired := oauth2.Token{AccessToken: accessToken, RefreshToken: refreshToken, Expiry: time.Now().Add(-time.Minute)}
+	stale := expired
+	refreshed, err := p.TokenSource(context.Background(), &stale).Token()
+	if assert.Nil(t, err) {
+		assert.NotEqual(t, accessToken, refreshed.AccessToken)
+		assert.NotEqual(t, refreshToken, refreshed.RefreshToken)
+	}
+
+	stub.RejectRefresh(true)
+	stale = expired
+	_, err = p.TokenSource(context.Background(), &stale).Token()
+	assert.NotNil(t, err)
+}
+
+func TestOIDC_Providers(t *testing.T) {
+	stub := oidctest.NewServer()
+	defer stub.Close()
+	first, _ := oidc.Discover(context.Background(), stub.Config("first", ""), nil)
+	second, _ := oidc.Discover(context.Background(), stub.Config("second", ""), nil)
+
+	providers, err := oidc.NewProviders(first, second)
+	assert.Nil(t, err)
+	assert.Equal(t, first, providers.Default())
+
+	p, ok := providers.Get("second")
+	assert.True(t, ok)
+	assert.Equal(t, second, p)
+	p, ok = providers.Get("")
+	assert.True(t, ok)
+	assert.Equal(t, first, p)
+	_, ok = providers.Get("third")
+	assert.False(t, ok)
+
+	_, err = oidc.NewProviders(first, first)
+	assert.NotNil(t, err)
+
+	var none *oidc.Providers
+	assert.Nil(t, none.Default())
+	_, ok = none.Get("")
+	assert.False(t, ok)
+}
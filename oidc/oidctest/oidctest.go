@@ -0,0 +1,327 @@
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc"
+)
+
+//User is who signs in at the stub provider.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	GivenName     string
+	FamilyName    string
+}
+
+//DefaultUser is who signs in until SetUser is called.
+var DefaultUser = User{
+	Subject:       "114668774842776472919",
+	Email:         "nothing@gmail.com",
+	EmailVerified: true,
+	Name:          "Bob Nothing",
+	GivenName:     "Bob",
+	FamilyName:    "Nothing",
+}
+
+//Server is a stub OpenID Connect provider on a local httptest server, for signing users in during tests. It serves
+//discovery, an authorize endpoint that signs the current user straight in, a token endpoint for codes and refresh
+//tokens, userinfo and its signing keys. A code asked for with an S256 PKCE challenge needs the matching verifier.
+type Server struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	mu            sync.Mutex
+	user          User
+	keys          []signingKey
+	codes         map[string]grant
+	accessTokens  map[string]User
+	refreshTokens map[string]User
+	rejectRefresh bool
+}
+
+type signingKey struct {
+	id  string
+	key *rsa.PrivateKey
+}
+
+//grant is what a code from the authorize endpoint is exchanged for.
+type grant struct {
+	user          User
+	nonce         string
+	codeChallenge string
+}
+
+//NewServer starts a stub provider. Close it when the test is done.
+func NewServer() *Server {
+	s := &Server{
+		ClientID:      "stub-client",
+		ClientSecret:  "stub-secret",
+		user:          DefaultUser,
+		codes:         make(map[string]grant),
+		accessTokens:  make(map[string]User),
+		refreshTokens: make(map[string]User),
+	}
+	s.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/userinfo", s.userinfo)
+	mux.HandleFunc("/jwks", s.jwks)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+//Config is the config for signing in with the stub provider under name, redirecting back to redirectURL.
+func (s *Server) Config(name string, redirectURL string) oidc.Config {
+	return oidc.Config{
+		Name:         name,
+		Issuer:       s.URL,
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		RedirectURL:  redirectURL,
+	}
+}
+
+//SetUser changes who signs in next.
+func (s *Server) SetUser(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = u
+}
+
+//RejectRefresh makes the token endpoint turn refresh tokens down, as a provider does once the user revokes access.
+func (s *Server) RejectRefresh(reject bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejectRefresh = reject
+}
+
+//RotateKey starts signing with a new key. The old ones are still listed, as a provider does while it rotates.
+func (s *Server) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("oidctest: could not make a signing key: " + err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, signingKey{id: randomString(8), key: key})
+}
+
+//IssueTokens gives an access token, refresh token and ID token for u as if they had just signed in.
+func (s *Server) IssueTokens(u User) (accessToken string, refreshToken string, idToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issue(u, "")
+}
+
+//SignIDToken signs claims with the current key, for tests that need an ID token the stub wouldn't issue.
+func (s *Server) SignIDToken(claims map[string]interface{}) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sign(claims)
+}
+
+//Authorize follows an AuthCodeURL through the stub's authorize endpoint, giving back where it redirects to - the
+//redirect URL with the code and state.
+func (s *Server) Authorize(authCodeURL string) (*url.URL, error) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(authCodeURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	return response.Location()
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"userinfo_endpoint":      s.URL + "/userinfo",
+		"jwks_uri":               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if query.Get("client_id") != s.ClientID || err != nil || query.Get("response_type") != "code" ||
+		(query.Get("code_challenge") != "" && query.Get("code_challenge_method") != "S256") {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	code := randomString(16)
+	s.codes[code] = grant{user: s.user, nonce: query.Get("nonce"), codeChallenge: query.Get("code_challenge")}
+	s.mu.Unlock()
+
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	if clientID != s.ClientID || clientSecret != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		g, ok := s.codes[r.FormValue("code")]
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(s.codes, r.FormValue("code"))
+		if g.codeChallenge != "" && g.codeChallenge != challengeFor(r.FormValue("code_verifier")) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		accessToken, refreshToken, idToken := s.issue(g.user, g.nonce)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token":  accessToken,
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+			"refresh_token": refreshToken,
+			"id_token":      idToken,
+		})
+	case "refresh_token":
+		u, ok := s.refreshTokens[r.FormValue("refresh_token")]
+		if !ok || s.rejectRefresh {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(s.refreshTokens, r.FormValue("refresh_token"))
+		accessToken, refreshToken, _ := s.issue(u, "")
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token":  accessToken,
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+			"refresh_token": refreshToken,
+		})
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+	}
+}
+
+func (s *Server) userinfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	u, ok := s.accessTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	writeJSON(w, http.StatusOK, userClaims(u))
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]map[string]string, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": k.id,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+//issue makes a new set of tokens for u. Callers hold mu.
+func (s *Server) issue(u User, nonce string) (accessToken string, refreshToken string, idToken string) {
+	accessToken = "stub-access-" + randomString(16)
+	refreshToken = "stub-refresh-" + randomString(16)
+	s.accessTokens[accessToken] = u
+	s.refreshTokens[refreshToken] = u
+
+	now := time.Now()
+	claims := userClaims(u)
+	claims["iss"] = s.URL
+	claims["aud"] = s.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	return accessToken, refreshToken, s.sign(claims)
+}
+
+//sign makes an RS256 JWT of claims with the newest key. Callers hold mu.
+func (s *Server) sign(claims map[string]interface{}) string {
+	k := s.keys[len(s.keys)-1]
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": k.id})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, k.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic("oidctest: could not sign an ID token: " + err.Error())
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func userClaims(u User) map[string]interface{} {
+	return map[string]interface{}{
+		"sub":            u.Subject,
+		"email":          u.Email,
+		"email_verified": u.EmailVerified,
+		"name":           u.Name,
+		"given_name":     u.GivenName,
+		"family_name":    u.FamilyName,
+	}
+}
+
+//challengeFor is the S256 PKCE challenge for verifier.
+func challengeFor(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
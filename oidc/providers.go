@@ -0,0 +1,54 @@
+package oidc
+
+import (
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//Providers are the identity providers users can sign in with, in the order the config file lists them.
+//The first is the default. A nil *Providers has none.
+type Providers struct {
+	list   []*Provider
+	byName map[string]*Provider
+}
+
+//NewProviders puts the providers together. Each needs its own name.
+func NewProviders(providers ...*Provider) (*Providers, fcerr.FCErr) {
+	ps := &Providers{byName: make(map[string]*Provider)}
+	for _, p := range providers {
+		if _, ok := ps.byName[p.Name()]; ok {
+			return nil, fcerr.NewInternalServerError("There is more than one identity provider called " + p.Name())
+		}
+		ps.byName[p.Name()] = p
+		ps.list = append(ps.list, p)
+	}
+	return ps, nil
+}
+
+//Get gives the provider with the given name, or the default one for "".
+func (ps *Providers) Get(name string) (*Provider, bool) {
+	if name == "" {
+		p := ps.Default()
+		return p, p != nil
+	}
+	if ps == nil {
+		return nil, false
+	}
+	p, ok := ps.byName[name]
+	return p, ok
+}
+
+//Default is the first provider, or nil when there are none.
+func (ps *Providers) Default() *Provider {
+	if ps == nil || len(ps.list) == 0 {
+		return nil
+	}
+	return ps.list[0]
+}
+
+//All gives every provider, the default first.
+func (ps *Providers) All() []*Provider {
+	if ps == nil {
+		return nil
+	}
+	return ps.list
+}
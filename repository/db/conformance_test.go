@@ -0,0 +1,1199 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/identity"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/notification"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/session"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/webhook"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/secret"
+
+	"github.com/stretchr/testify/assert"
+	_ "modernc.org/sqlite"
+)
+
+//repositoryFactory gives each conformance test a fresh, empty Repository.
+type repositoryFactory func(t *testing.T) Repository
+
+//runConformance is the behaviour every Repository implementation has to share.
+func runConformance(t *testing.T, newRepo repositoryFactory) {
+	t.Run("EmptyRepository", func(t *testing.T) { conformanceEmptyRepository(t, newRepo(t)) })
+	t.Run("DishRoundTrip", func(t *testing.T) { conformanceDishRoundTrip(t, newRepo(t)) })
+	t.Run("UpdateDish", func(t *testing.T) { conformanceUpdateDish(t, newRepo(t)) })
+	t.Run("DeleteDishRenumbers", func(t *testing.T) { conformanceDeleteDishRenumbers(t, newRepo(t)) })
+	t.Run("DishesPerHousehold", func(t *testing.T) { conformanceDishesPerHousehold(t, newRepo(t)) })
+	t.Run("UserLifecycle", func(t *testing.T) { conformanceUserLifecycle(t, newRepo(t)) })
+	t.Run("StorageLifecycle", func(t *testing.T) { conformanceStorageLifecycle(t, newRepo(t)) })
+	t.Run("SessionLifecycle", func(t *testing.T) { conformanceSessionLifecycle(t, newRepo(t)) })
+	t.Run("IdentityLifecycle", func(t *testing.T) { conformanceIdentityLifecycle(t, newRepo(t)) })
+	t.Run("HouseholdLifecycle", func(t *testing.T) { conformanceHouseholdLifecycle(t, newRepo(t)) })
+	t.Run("InviteLifecycle", func(t *testing.T) { conformanceInviteLifecycle(t, newRepo(t)) })
+	t.Run("NotificationLifecycle", func(t *testing.T) { conformanceNotificationLifecycle(t, newRepo(t)) })
+	t.Run("RemovalLifecycle", func(t *testing.T) { conformanceRemovalLifecycle(t, newRepo(t)) })
+	t.Run("DishesExpiringBetween", func(t *testing.T) { conformanceDishesExpiringBetween(t, newRepo(t)) })
+	t.Run("FindDishes", func(t *testing.T) { conformanceFindDishes(t, newRepo(t)) })
+	t.Run("WebhookLifecycle", func(t *testing.T) { conformanceWebhookLifecycle(t, newRepo(t)) })
+	t.Run("DeliveryLifecycle", func(t *testing.T) { conformanceDeliveryLifecycle(t, newRepo(t)) })
+	t.Run("ConcurrentCreateDish", func(t *testing.T) { conformanceConcurrentCreateDish(t, newRepo(t)) })
+	t.Run("WithTxCommits", func(t *testing.T) { conformanceWithTxCommits(t, newRepo(t)) })
+	t.Run("WithTxRollsBack", func(t *testing.T) { conformanceWithTxRollsBack(t, newRepo(t)) })
+	t.Run("ConcurrentPersonalIDs", func(t *testing.T) { conformanceConcurrentPersonalIDs(t, newRepo(t)) })
+	t.Run("CancelledContext", func(t *testing.T) { conformanceCancelledContext(t, newRepo(t)) })
+}
+
+func TestMemoryRepository_Conformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) Repository {
+		return NewMemoryRepository()
+	})
+}
+
+func TestSQLiteRepository_Conformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) Repository {
+		repo, err := NewRepositoryWithDriver(SQLiteDriver, filepath.Join(t.TempDir(), "fcapi.db"))
+		if err != nil {
+			t.Fatal(err.Message())
+		}
+		return repo
+	})
+}
+
+func TestSealedRepository_Conformance(t *testing.T) {
+	box, fcErr := secret.NewBox(secret.NewKey())
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	runConformance(t, func(t *testing.T) Repository {
+		repo, err := NewRepositoryWithDriver(SQLiteDriver, filepath.Join(t.TempDir(), "fcapi.db"))
+		if err != nil {
+			t.Fatal(err.Message())
+		}
+		return NewSealedRepository(repo, box)
+	})
+}
+
+//TestMySQLRepository_Conformance needs a throwaway database, e.g.
+//FCAPI_TEST_MYSQL_DSN="root:pass@tcp(127.0.0.1:3306)/fcapi_test" go test ./repository/db/
+func TestMySQLRepository_Conformance(t *testing.T) {
+	dsn := os.Getenv("FCAPI_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("FCAPI_TEST_MYSQL_DSN is not set")
+	}
+
+	runConformance(t, func(t *testing.T) Repository {
+		repo, err := NewRepository(dsn)
+		if err != nil {
+			t.Fatal(err.Message())
+		}
+		database := repo.(*repository).db
+		for _, table := range []string{"dish", "storage", "user", "session", "household", "household_member", "household_invite", "notification", "dish_removal",
+			"webhook_subscription", "webhook_delivery"} {
+			if _, err := database.ExecContext(context.Background(), "DELETE FROM " + table); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return repo
+	})
+}
+
+//legacyDatesRepository gives a SQLite Repository whose dishes were written, before the dates were normalized, with the
+//created and expire dates given for each title. Opening it normalizes them, as it would an older database.
+func legacyDatesRepository(t *testing.T, householdID int, dates map[string][2]string) Repository {
+	path := filepath.Join(t.TempDir(), "fcapi.db")
+	db, fcErr := OpenDatabase(SQLiteDriver, path)
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	if fcErr := Migrate(db, SQLiteDriver); fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	migrateDownBefore(t, db, 15)
+	personalID := 0
+	for title, d := range dates {
+		personalID++
+		if _, err := db.Exec(`INSERT INTO dish (personal_id, user_id, household_id, storage_id, title, created_date, expire_date) VALUES (?, 1, ?, 1, ?, ?, ?)`,
+			personalID, householdID, title, d[0], d[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	repo, fcErr := NewRepositoryWithDriver(SQLiteDriver, path)
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	return repo
+}
+
+//A dish written with an older date format is found by the time it expires, not by how its date text sorts.
+func TestSQLiteRepository_LegacyDatesExpiringBetween(t *testing.T) {
+	ctx := context.Background()
+	monday := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	repo := legacyDatesRepository(t, 9, map[string][2]string{
+		"Soup":    {"2021-02-20T08:00:00", "2021-03-01T13:00:00"},
+		"Carrots": {"2021-02-20", "2021-03-01T07:30:00-05:00"},
+		"Rice":    {"2021-02-20 08:00", "2021-03-01"},
+		"Bread":   {"2021-02-20 08:00:00", "2021-03-01T15:00"},
+	})
+	repo.CreateDish(ctx, dish.Dish{Title: "Salt", PersonalDishID: 5, UserID: 1, HouseholdID: 9, ExpireDate: monday.Add(90 * time.Minute)})
+
+	dishes, err := repo.GetDishesExpiringBetween(ctx, monday, monday.Add(2*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Carrots", "Soup", "Salt"}, titles(dishes))
+	if len(*dishes) == 3 {
+		assert.Equal(t, monday.Add(time.Hour), (*dishes)[1].ExpireDate)
+	}
+
+	dishes, err = repo.GetDishesExpiringBetween(ctx, monday.Add(-13*time.Hour), monday.Add(-11*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Rice"}, titles(dishes))
+}
+
+//Paging through dishes by date carries on in time order over dishes that were written with an older date format.
+func TestSQLiteRepository_LegacyDatesPaging(t *testing.T) {
+	ctx := context.Background()
+	monday := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	repo := legacyDatesRepository(t, 9, map[string][2]string{
+		"Soup":    {"2021-02-28T08:00:00", "2021-03-01T13:00:00"},
+		"Carrots": {"2021-02-28T11:00:00Z", "2021-03-01T07:30:00-05:00"},
+		"Rice":    {"2021-02-28 10:00", "2021-03-02"},
+	})
+	for i, d := range []dish.Dish{
+		{Title: "Bread", ExpireDate: monday.Add(2 * time.Hour), CreatedDate: monday.Add(-23 * time.Hour)},
+		{Title: "Salt", ExpireDate: monday.Add(-time.Hour), CreatedDate: monday.Add(-27 * time.Hour)},
+	} {
+		d.PersonalDishID, d.UserID, d.HouseholdID = i+4, 1, 9
+		if _, err := repo.CreateDish(ctx, d); err != nil {
+			t.Fatal(err.Message())
+		}
+	}
+
+	for sort, inOrder := range map[string][]string{
+		dish.SortExpireDate:  {"Salt", "Carrots", "Soup", "Bread", "Rice"},
+		dish.SortCreatedDate: {"Soup", "Salt", "Rice", "Carrots", "Bread"},
+	} {
+		q := dish.Query{Sort: sort, Limit: 2}
+		var paged []string
+		//a cursor out of step with the stored order could go round for ever, so no more pages than there are dishes
+		for page := 0; page < len(inOrder); page++ {
+			dishes, err := repo.FindDishes(ctx, 9, q)
+			if !assert.Nil(t, err) || len(*dishes) == 0 {
+				break
+			}
+			paged = append(paged, titles(dishes)...)
+			last := dish.CursorAfter((*dishes)[len(*dishes)-1], q.Sort, q.Descending)
+			q.After = &last
+		}
+		assert.Equal(t, inOrder, paged, sort)
+	}
+}
+
+func conformanceEmptyRepository(t *testing.T, repo Repository) {
+	_, err := repo.GetDishes(context.Background(), 1)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	_, err = repo.GetDishByID(context.Background(), 1, 1)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	_, err = repo.GetDishByTempMatch(context.Background(), "nothing")
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	_, err = repo.GetUserByID(context.Background(), 1)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	_, err = repo.GetUserByEmail(context.Background(), "nobody@example.com")
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	_, err = repo.GetStorages(context.Background(), 1)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	_, err = repo.GetStorageDishes(context.Background(), 1, 1)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	dishCount, err := repo.GetPersonalDishCount(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, dishCount)
+
+	storageCount, err := repo.GetPersonalStorageCount(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, storageCount)
+}
+
+func conformanceDishRoundTrip(t *testing.T, repo Repository) {
+	for i, tricky := range trickyStrings {
+		newDish := *nD
+		newDish.PersonalDishID = i + 1
+		newDish.Title = tricky
+		newDish.Description = tricky
+
+		created, err := repo.CreateDish(context.Background(), newDish)
+		assert.Nil(t, err)
+		assert.NotEqual(t, 0, created.DishID)
+		assert.NotEqual(t, nD.TempMatch, created.TempMatch)
+		assert.Equal(t, tricky, created.Title)
+		assert.Equal(t, tricky, created.Description)
+		assert.Equal(t, nD.ExpireDate, created.ExpireDate)
+		assert.Equal(t, nD.Portions, created.Portions)
+
+		byTempMatch, err := repo.GetDishByTempMatch(context.Background(), created.TempMatch)
+		assert.Nil(t, err)
+		assert.Equal(t, created, byTempMatch)
+
+		byID, err := repo.GetDishByID(context.Background(), nD.HouseholdID, i+1)
+		assert.Nil(t, err)
+		assert.Equal(t, created, byID)
+	}
+
+	count, err := repo.GetPersonalDishCount(context.Background(), nD.HouseholdID)
+	assert.Nil(t, err)
+	assert.Equal(t, len(trickyStrings), count)
+}
+
+func conformanceUpdateDish(t *testing.T, repo Repository) {
+	newDish := *nD
+	newDish.PersonalDishID = 1
+	created, _ := repo.CreateDish(context.Background(), newDish)
+
+	changed := *created
+	changed.Title = "Cooked Carrots"
+	changed.Priority = "high"
+	changed.Portions = 3
+	err := repo.UpdateDish(context.Background(), changed)
+	assert.Nil(t, err)
+
+	result, _ := repo.GetDishByID(context.Background(), nD.HouseholdID, 1)
+	assert.Equal(t, "Cooked Carrots", result.Title)
+	assert.Equal(t, "high", result.Priority)
+	assert.Equal(t, 3, result.Portions)
+	assert.Equal(t, created.CreatedDate, result.CreatedDate)
+	assert.Equal(t, created.TempMatch, result.TempMatch)
+
+	missing := *created
+	missing.DishID = created.DishID + 1000
+	missing.PersonalDishID = 50
+	err = repo.UpdateDish(context.Background(), missing)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+}
+
+func conformanceDeleteDishRenumbers(t *testing.T, repo Repository) {
+	for i, title := range []string{"first", "second", "third"} {
+		newDish := *nD
+		newDish.PersonalDishID = i + 1
+		newDish.Title = title
+		repo.CreateDish(context.Background(), newDish)
+	}
+
+	err := repo.DeleteDish(context.Background(), nD.HouseholdID, 4)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+
+	err = repo.DeleteDish(context.Background(), nD.HouseholdID, 2)
+	assert.Nil(t, err)
+
+	dishes, _ := repo.GetDishes(context.Background(), nD.HouseholdID)
+	assert.Equal(t, 2, len(*dishes))
+	first, _ := repo.GetDishByID(context.Background(), nD.HouseholdID, 1)
+	assert.Equal(t, "first", first.Title)
+	third, _ := repo.GetDishByID(context.Background(), nD.HouseholdID, 2)
+	assert.Equal(t, "third", third.Title)
+
+	err = repo.DeleteDish(context.Background(), nD.HouseholdID, 2)
+	assert.Nil(t, err)
+	first, _ = repo.GetDishByID(context.Background(), nD.HouseholdID, 1)
+	assert.Equal(t, "first", first.Title)
+	_, err = repo.GetDishByID(context.Background(), nD.HouseholdID, 2)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+}
+
+func conformanceDishesPerHousehold(t *testing.T, repo Repository) {
+	mine := *nD
+	mine.PersonalDishID = 1
+	repo.CreateDish(context.Background(), mine)
+
+	//a dish another member added is the household's too
+	shared := *nD
+	shared.UserID = nD.UserID + 5
+	shared.PersonalDishID = 2
+	shared.Title = "Leftover lasagna"
+	repo.CreateDish(context.Background(), shared)
+
+	theirs := *nD
+	theirs.UserID = nD.UserID + 1
+	theirs.HouseholdID = nD.HouseholdID + 1
+	theirs.PersonalDishID = 1
+	theirs.Title = "Someone else's soup"
+	repo.CreateDish(context.Background(), theirs)
+
+	dishes, err := repo.GetDishes(context.Background(), nD.HouseholdID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(*dishes))
+	byShared, _ := repo.GetDishByID(context.Background(), nD.HouseholdID, 2)
+	assert.Equal(t, shared.UserID, byShared.UserID)
+
+	other, _ := repo.GetDishByID(context.Background(), theirs.HouseholdID, 1)
+	assert.Equal(t, "Someone else's soup", other.Title)
+
+	counts, err := repo.GetDishExpiryCounts(context.Background())
+	assert.Nil(t, err)
+	stats := counts.Stats(nD.ExpireDate, nD.ExpireDate.Add(time.Hour))
+	assert.Equal(t, 3, stats.Dishes)
+	assert.Equal(t, 3, stats.Expired)
+	assert.Equal(t, 2, stats.Households)
+
+	err = repo.DeleteDish(context.Background(), theirs.HouseholdID, 1)
+	assert.Nil(t, err)
+	_, err = repo.GetDishByID(context.Background(), nD.HouseholdID, 1)
+	assert.Nil(t, err)
+
+	fridge := *nS
+	fridge.HouseholdID = nD.HouseholdID
+	repo.CreateStorage(context.Background(), fridge)
+	repo.CreateDish(context.Background(), theirs)
+	assert.Nil(t, repo.DeleteHouseholdFood(context.Background(), nD.HouseholdID))
+	_, err = repo.GetDishes(context.Background(), nD.HouseholdID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	_, err = repo.GetStorages(context.Background(), nD.HouseholdID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	_, err = repo.GetDishByID(context.Background(), theirs.HouseholdID, 1)
+	assert.Nil(t, err, "other households keep theirs")
+}
+
+func conformanceSessionLifecycle(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	first := session.Session{UserID: 1, TokenHash: session.HashToken("fcs_first"), CreatedDate: now, ExpireDate: now.Add(time.Hour)}
+	second := session.Session{UserID: 1, TokenHash: session.HashToken("fcs_second"), CreatedDate: now, ExpireDate: now.Add(time.Hour)}
+	other := session.Session{UserID: 2, TokenHash: session.HashToken("fcs_other"), CreatedDate: now, ExpireDate: now.Add(time.Hour),
+		ImpersonatorID: 1}
+
+	_, err := repo.GetSessionByTokenHash(ctx, first.TokenHash)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	created, err := repo.CreateSession(ctx, first)
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, created.SessionID)
+	assert.Equal(t, now, created.CreatedDate)
+	assert.Equal(t, now.Add(time.Hour), created.ExpireDate)
+	assert.True(t, created.RevokedDate.IsZero())
+
+	_, err = repo.CreateSession(ctx, first)
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+
+	_, err = repo.CreateSession(ctx, second)
+	assert.Nil(t, err)
+	_, err = repo.CreateSession(ctx, other)
+	assert.Nil(t, err)
+
+	assert.Nil(t, repo.RevokeSession(ctx, first.TokenHash, now.Add(time.Minute)))
+	assert.Nil(t, repo.RevokeSession(ctx, first.TokenHash, now.Add(2*time.Minute)))
+	revoked, err := repo.GetSessionByTokenHash(ctx, first.TokenHash)
+	assert.Nil(t, err)
+	assert.Equal(t, now.Add(time.Minute), revoked.RevokedDate)
+	assert.False(t, revoked.IsActive(now))
+
+	assert.Nil(t, repo.RevokeUserSessions(ctx, 1, now.Add(3*time.Minute)))
+	revoked, _ = repo.GetSessionByTokenHash(ctx, second.TokenHash)
+	assert.Equal(t, now.Add(3*time.Minute), revoked.RevokedDate)
+	revoked, _ = repo.GetSessionByTokenHash(ctx, first.TokenHash)
+	assert.Equal(t, now.Add(time.Minute), revoked.RevokedDate)
+
+	untouched, _ := repo.GetSessionByTokenHash(ctx, other.TokenHash)
+	assert.True(t, untouched.IsActive(now))
+	assert.Equal(t, 1, untouched.ImpersonatorID)
+
+	assert.Nil(t, repo.DeleteUserSessions(ctx, 1))
+	_, err = repo.GetSessionByTokenHash(ctx, second.TokenHash)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	_, err = repo.GetSessionByTokenHash(ctx, other.TokenHash)
+	assert.Nil(t, err)
+}
+
+func conformanceIdentityLifecycle(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	unbound := identity.Identity{UserID: 1, Provider: identity.Alexa, AccessTokenHash: session.HashToken("fcs_first"),
+		RefreshTokenHash: session.HashToken("fcr_first"), CreatedDate: now}
+	legacy := identity.Identity{UserID: 2, Provider: identity.Alexa, ExternalID: "qwertyuiop", CreatedDate: now}
+
+	_, err := repo.CreateIdentity(ctx, legacy)
+	assert.NotNil(t, err, "a link has to have a refresh token to find it by")
+	legacy.RefreshTokenHash = session.HashToken("fcr_legacy")
+	legacyCreated, err := repo.CreateIdentity(ctx, legacy)
+	assert.Nil(t, err)
+
+	created, err := repo.CreateIdentity(ctx, unbound)
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, created.LinkID)
+	assert.NotEqual(t, legacyCreated.LinkID, created.LinkID)
+	assert.False(t, created.IsBound())
+	assert.Equal(t, now, created.CreatedDate)
+
+	byRefresh, err := repo.GetIdentityByRefreshHash(ctx, unbound.RefreshTokenHash)
+	assert.Nil(t, err)
+	assert.Equal(t, created, byRefresh)
+	_, err = repo.GetIdentityByRefreshHash(ctx, session.HashToken("fcr_made_up"))
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	byExternal, err := repo.GetIdentity(ctx, identity.Alexa, "qwertyuiop")
+	assert.Nil(t, err)
+	assert.Equal(t, legacyCreated, byExternal)
+	_, err = repo.GetIdentity(ctx, "google", "qwertyuiop")
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	bound := *created
+	bound.ExternalID = "asdfghjkl"
+	bound.AccessTokenHash = session.HashToken("fcs_second")
+	assert.Nil(t, repo.UpdateIdentity(ctx, bound))
+	byExternal, err = repo.GetIdentity(ctx, identity.Alexa, "asdfghjkl")
+	assert.Nil(t, err)
+	assert.Equal(t, bound, *byExternal)
+
+	identities, err := repo.GetUserIdentities(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, identity.Identities{bound}, *identities)
+	identities, err = repo.GetUserIdentities(ctx, 3)
+	assert.Nil(t, err)
+	assert.Empty(t, *identities)
+
+	err = repo.DeleteIdentity(ctx, 2, bound.LinkID)
+	assert.Equal(t, http.StatusNotFound, err.Status(), "a user can only delete their own links")
+	assert.Nil(t, repo.DeleteIdentity(ctx, 1, bound.LinkID))
+	err = repo.DeleteIdentity(ctx, 1, bound.LinkID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	_, err = repo.GetIdentity(ctx, identity.Alexa, "asdfghjkl")
+	assert.Equal(t, http.StatusNotFound, err.Status())
+}
+
+func conformanceHouseholdLifecycle(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	owner, _ := repo.CreateUser(ctx, *nU)
+	other := *nU
+	other.Email = "someone@example.com"
+	other.FullName = "Someone Else"
+	member, _ := repo.CreateUser(ctx, other)
+
+	_, err := repo.GetHousehold(ctx, 1)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	_, err = repo.GetMembership(ctx, owner.UserID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	created, err := repo.CreateHousehold(ctx, household.Household{Name: trickyStrings[0], CreatedDate: now})
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, created.HouseholdID)
+	assert.Equal(t, trickyStrings[0], created.Name)
+	assert.Equal(t, now, created.CreatedDate)
+	second, _ := repo.CreateHousehold(ctx, household.Household{CreatedDate: now})
+	assert.NotEqual(t, created.HouseholdID, second.HouseholdID)
+
+	assert.Nil(t, repo.UpdateHousehold(ctx, household.Household{HouseholdID: created.HouseholdID, Name: "The Smiths"}))
+	byID, err := repo.GetHousehold(ctx, created.HouseholdID)
+	assert.Nil(t, err)
+	assert.Equal(t, "The Smiths", byID.Name)
+
+	assert.Nil(t, repo.CreateMembership(ctx, household.Member{HouseholdID: created.HouseholdID, UserID: owner.UserID,
+		Role: household.RoleOwner, JoinedDate: now}))
+	assert.Nil(t, repo.CreateMembership(ctx, household.Member{HouseholdID: created.HouseholdID, UserID: member.UserID,
+		Role: household.RoleMember, JoinedDate: now.Add(time.Minute)}))
+	err = repo.CreateMembership(ctx, household.Member{HouseholdID: second.HouseholdID, UserID: member.UserID,
+		Role: household.RoleOwner, JoinedDate: now})
+	assert.NotNil(t, err, "a user is only in one household")
+
+	joined, err := repo.GetMembership(ctx, member.UserID)
+	assert.Nil(t, err)
+	assert.Equal(t, household.Member{HouseholdID: created.HouseholdID, UserID: member.UserID, Role: household.RoleMember,
+		JoinedDate: now.Add(time.Minute), Email: "someone@example.com", FullName: "Someone Else"}, *joined)
+
+	members, err := repo.GetHouseholdMembers(ctx, created.HouseholdID)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(*members)) {
+		assert.Equal(t, owner.UserID, (*members)[0].UserID)
+		assert.Equal(t, nU.Email, (*members)[0].Email)
+		assert.Equal(t, 1, members.Owners())
+	}
+	members, err = repo.GetHouseholdMembers(ctx, second.HouseholdID)
+	assert.Nil(t, err)
+	assert.Empty(t, *members)
+
+	joined.Role = household.RoleOwner
+	assert.Nil(t, repo.UpdateMembership(ctx, *joined))
+	joined, _ = repo.GetMembership(ctx, member.UserID)
+	assert.True(t, joined.IsOwner())
+
+	err = repo.DeleteMembership(ctx, second.HouseholdID, member.UserID)
+	assert.Equal(t, http.StatusNotFound, err.Status(), "a member is only removed from their own household")
+	assert.Nil(t, repo.DeleteMembership(ctx, created.HouseholdID, member.UserID))
+	_, err = repo.GetMembership(ctx, member.UserID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	assert.Nil(t, repo.DeleteHousehold(ctx, second.HouseholdID))
+	_, err = repo.GetHousehold(ctx, second.HouseholdID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+}
+
+func conformanceInviteLifecycle(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	_, err := repo.GetInvite(ctx, 1)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	created, err := repo.CreateInvite(ctx, household.Invite{HouseholdID: 3, Email: "someone@example.com", Role: household.RoleMember,
+		InvitedBy: 7, CreatedDate: now, ExpireDate: now.Add(7 * 24 * time.Hour)})
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, created.InviteID)
+	second, _ := repo.CreateInvite(ctx, household.Invite{HouseholdID: 4, Email: "someone@example.com", Role: household.RoleOwner,
+		InvitedBy: 8, CreatedDate: now, ExpireDate: now.Add(time.Hour)})
+	repo.CreateInvite(ctx, household.Invite{HouseholdID: 3, Email: "another@example.com", Role: household.RoleMember,
+		InvitedBy: 7, CreatedDate: now, ExpireDate: now.Add(time.Hour)})
+
+	byID, err := repo.GetInvite(ctx, created.InviteID)
+	assert.Nil(t, err)
+	byID.TempMatch = ""
+	assert.Equal(t, household.Invite{InviteID: created.InviteID, HouseholdID: 3, Email: "someone@example.com",
+		Role: household.RoleMember, InvitedBy: 7, CreatedDate: now, ExpireDate: now.Add(7 * 24 * time.Hour)}, *byID)
+
+	invites, err := repo.GetInvitesByEmail(ctx, "someone@example.com")
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(*invites)) {
+		assert.Equal(t, created.InviteID, (*invites)[0].InviteID)
+		assert.Equal(t, second.InviteID, (*invites)[1].InviteID)
+	}
+	invites, err = repo.GetHouseholdInvites(ctx, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(*invites))
+	invites, _ = repo.GetInvitesByEmail(ctx, "nobody@example.com")
+	assert.Empty(t, *invites)
+
+	assert.Nil(t, repo.DeleteInvite(ctx, created.InviteID))
+	assert.Nil(t, repo.DeleteInvite(ctx, created.InviteID), "deleting an invite twice is fine")
+	_, err = repo.GetInvite(ctx, created.InviteID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	invites, _ = repo.GetInvitesByEmail(ctx, "someone@example.com")
+	assert.Equal(t, 1, len(*invites))
+}
+
+func conformanceUserLifecycle(t *testing.T, repo Repository) {
+	newUser := *nU
+	newUser.FullName = trickyStrings[0]
+
+	created, err := repo.CreateUser(context.Background(), newUser)
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, created.UserID)
+	assert.NotEqual(t, nU.TempMatch, created.TempMatch)
+	assert.Equal(t, trickyStrings[0], created.FullName)
+	assert.Equal(t, nU.AccessToken, created.AccessToken)
+
+	_, err = repo.CreateUser(context.Background(), newUser)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+
+	byEmail, err := repo.GetUserByEmail(context.Background(), nU.Email)
+	assert.Nil(t, err)
+	assert.Equal(t, created, byEmail)
+
+	byTempMatch, err := repo.GetUserByTempMatch(context.Background(), created.TempMatch)
+	assert.Nil(t, err)
+	assert.Equal(t, created, byTempMatch)
+
+	listed, err := repo.GetUsers(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, user.Users{*created}, *listed)
+	listed, err = repo.GetUsers(context.Background(), "BEST\" CHILI")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*listed))
+	_, err = repo.GetUsers(context.Background(), "%")
+	assert.Equal(t, http.StatusNotFound, err.Status(), "a wildcard is searched for as it is")
+
+	deactivatedAt := time.Date(2021, 3, 2, 9, 0, 0, 0, time.UTC)
+	assert.Nil(t, repo.SetUserDeactivated(context.Background(), created.UserID, deactivatedAt))
+	deactivated, _ := repo.GetUserByID(context.Background(), created.UserID)
+	assert.Equal(t, deactivatedAt, deactivated.DeactivatedDate)
+	assert.False(t, deactivated.IsActive())
+	assert.Nil(t, repo.SetUserDeactivated(context.Background(), created.UserID, time.Time{}))
+	reactivated, _ := repo.GetUserByID(context.Background(), created.UserID)
+	assert.True(t, reactivated.IsActive())
+
+	_, err = repo.GetUsersPendingDeletion(context.Background())
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	deleteAt := time.Date(2021, 4, 1, 9, 0, 0, 0, time.UTC)
+	assert.Nil(t, repo.SetUserDeletion(context.Background(), created.UserID, deleteAt))
+	pending, err := repo.GetUsersPendingDeletion(context.Background())
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(*pending)) {
+		assert.Equal(t, deleteAt, (*pending)[0].DeletionDate)
+		assert.Equal(t, nU.AccessToken, (*pending)[0].AccessToken)
+	}
+
+	changed := *created
+	changed.AccessToken = "a-new-token"
+	changed.TokenExpiry = time.Date(2021, 3, 1, 13, 0, 0, 0, time.UTC)
+	changed.TokenProvider = "google"
+	changed.TimeZone = "America/Chicago"
+	changed.NotifyExpiring = true
+	changed.NotifyDaysBefore = 3
+	changed.QuietStart, changed.QuietEnd = "22:00", "07:30"
+	updated, err := repo.UpdateUser(context.Background(), changed)
+	assert.Nil(t, err)
+	assert.Equal(t, "a-new-token", updated.AccessToken)
+	assert.Equal(t, nU.RefreshToken, updated.RefreshToken)
+	assert.Equal(t, changed.TokenExpiry, updated.TokenExpiry)
+	assert.Equal(t, "google", updated.TokenProvider)
+	assert.Equal(t, created.CreatedDate, updated.CreatedDate)
+	assert.Equal(t, "America/Chicago", updated.TimeZone)
+	assert.True(t, updated.NotifyExpiring)
+	assert.Equal(t, 3, updated.NotifyDaysBefore)
+	assert.Equal(t, "22:00", updated.QuietStart)
+	assert.Equal(t, "07:30", updated.QuietEnd)
+	assert.Equal(t, deleteAt, updated.DeletionDate, "UpdateUser leaves a scheduled deletion alone")
+
+	assert.Nil(t, repo.SetUserDeletion(context.Background(), created.UserID, time.Time{}))
+	_, err = repo.GetUsersPendingDeletion(context.Background())
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	toNotify, err := repo.GetUsersToNotify(context.Background())
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(*toNotify)) {
+		assert.Equal(t, created.UserID, (*toNotify)[0].UserID)
+		assert.Equal(t, "a-new-token", (*toNotify)[0].AccessToken)
+	}
+	assert.Nil(t, repo.SetUserDeactivated(context.Background(), created.UserID, deactivatedAt))
+	_, err = repo.GetUsersToNotify(context.Background())
+	assert.Equal(t, http.StatusNotFound, err.Status(), "deactivated users aren't notified")
+
+	err = repo.DeleteUser(context.Background(), created.UserID)
+	assert.Nil(t, err)
+	_, err = repo.GetUserByID(context.Background(), created.UserID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	_, err = repo.UpdateUser(context.Background(), changed)
+	assert.NotNil(t, err)
+}
+
+func conformanceStorageLifecycle(t *testing.T, repo Repository) {
+	created, err := repo.CreateStorage(context.Background(), *nS)
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, created.StorageID)
+	assert.NotEqual(t, nS.TempMatch, created.TempMatch)
+	assert.Equal(t, nS.Title, created.Title)
+
+	_, err = repo.CreateStorage(context.Background(), *nS)
+	assert.NotNil(t, err)
+
+	second := *nS
+	second.PersonalID = 2
+	second.Title = "Freezer"
+	repo.CreateStorage(context.Background(), second)
+
+	count, _ := repo.GetPersonalStorageCount(context.Background(), nS.HouseholdID)
+	assert.Equal(t, 2, count)
+
+	storages, err := repo.GetStorages(context.Background(), nS.HouseholdID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(*storages))
+
+	byTempMatch, err := repo.GetStorageByTempMatch(context.Background(), created.TempMatch)
+	assert.Nil(t, err)
+	assert.Equal(t, created, byTempMatch)
+
+	changed := *created
+	changed.Title = "Garage Fridge"
+	err = repo.UpdateStorage(context.Background(), changed)
+	assert.Nil(t, err)
+	byID, _ := repo.GetStorageByID(context.Background(), nS.HouseholdID, nS.PersonalID)
+	assert.Equal(t, "Garage Fridge", byID.Title)
+
+	_, err = repo.GetStorageDishes(context.Background(), nS.HouseholdID, nS.PersonalID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	inFridge := *nD
+	inFridge.UserID = nS.UserID
+	inFridge.HouseholdID = nS.HouseholdID
+	inFridge.StorageID = nS.PersonalID
+	inFridge.PersonalDishID = 1
+	repo.CreateDish(context.Background(), inFridge)
+	inFreezer := inFridge
+	inFreezer.StorageID = 2
+	inFreezer.PersonalDishID = 2
+	repo.CreateDish(context.Background(), inFreezer)
+
+	fridgeDishes, err := repo.GetStorageDishes(context.Background(), nS.HouseholdID, nS.PersonalID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*fridgeDishes))
+	assert.Equal(t, 1, (*fridgeDishes)[0].PersonalDishID)
+
+	err = repo.DeleteStorage(context.Background(), nS.HouseholdID, 2)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+
+	err = repo.DeleteStorage(context.Background(), nS.HouseholdID, 3)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+
+	repo.DeleteDish(context.Background(), nS.HouseholdID, 1)
+	err = repo.DeleteStorage(context.Background(), nS.HouseholdID, nS.PersonalID)
+	assert.Nil(t, err)
+
+	//The freezer moves up to personal id 1 and takes its dish along
+	freezer, err := repo.GetStorageByID(context.Background(), nS.HouseholdID, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "Freezer", freezer.Title)
+	_, err = repo.GetStorageByID(context.Background(), nS.HouseholdID, 2)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	freezerDishes, err := repo.GetStorageDishes(context.Background(), nS.HouseholdID, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*freezerDishes))
+
+	third := *nS
+	third.PersonalID = 2
+	_, err = repo.CreateStorage(context.Background(), third)
+	assert.Nil(t, err)
+}
+
+func conformanceWithTxCommits(t *testing.T, repo Repository) {
+	err := repo.WithTx(context.Background(), func(tx Repository) fcerr.FCErr {
+		newDish := *nD
+		newDish.PersonalDishID = 1
+		if _, err := tx.CreateDish(context.Background(), newDish); err != nil {
+			return err
+		}
+		_, err := tx.CreateStorage(context.Background(), *nS)
+		return err
+	})
+	assert.Nil(t, err)
+
+	_, err = repo.GetDishByID(context.Background(), nD.HouseholdID, 1)
+	assert.Nil(t, err)
+	_, err = repo.GetStorageByID(context.Background(), nS.HouseholdID, nS.PersonalID)
+	assert.Nil(t, err)
+}
+
+func conformanceWithTxRollsBack(t *testing.T, repo Repository) {
+	err := repo.WithTx(context.Background(), func(tx Repository) fcerr.FCErr {
+		newDish := *nD
+		newDish.PersonalDishID = 1
+		if _, err := tx.CreateDish(context.Background(), newDish); err != nil {
+			return err
+		}
+		//a nested WithTx joins the outer transaction, so this is rolled back too
+		tx.WithTx(context.Background(), func(inner Repository) fcerr.FCErr {
+			_, err := inner.CreateStorage(context.Background(), *nS)
+			return err
+		})
+		return fcerr.NewBadRequestError("changed my mind")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+
+	count, _ := repo.GetPersonalDishCount(context.Background(), nD.HouseholdID)
+	assert.Equal(t, 0, count)
+	_, err = repo.GetStorageByID(context.Background(), nS.HouseholdID, nS.PersonalID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+}
+
+func conformanceConcurrentPersonalIDs(t *testing.T, repo Repository) {
+	const workers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.WithTx(context.Background(), func(tx Repository) fcerr.FCErr {
+				count, err := tx.GetPersonalDishCount(context.Background(), nD.HouseholdID)
+				if err != nil {
+					return err
+				}
+				newDish := *nD
+				newDish.PersonalDishID = count + 1
+				_, err = tx.CreateDish(context.Background(), newDish)
+				return err
+			})
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	dishes, _ := repo.GetDishes(context.Background(), nD.HouseholdID)
+	seen := make(map[int]bool)
+	for _, d := range *dishes {
+		assert.False(t, seen[d.PersonalDishID], "personal id %d handed out twice", d.PersonalDishID)
+		seen[d.PersonalDishID] = true
+	}
+	for pID := 1; pID <= workers; pID++ {
+		assert.True(t, seen[pID], "personal id %d is missing", pID)
+	}
+}
+
+func conformanceConcurrentCreateDish(t *testing.T, repo Repository) {
+	const workers = 20
+	var wg sync.WaitGroup
+	created := make([]*dish.Dish, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			newDish := *nD
+			newDish.PersonalDishID = i + 1
+			created[i], _ = repo.CreateDish(context.Background(), newDish)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[int]bool)
+	for _, d := range created {
+		assert.NotNil(t, d)
+		if d != nil {
+			assert.False(t, seen[d.DishID])
+			seen[d.DishID] = true
+		}
+	}
+
+	count, _ := repo.GetPersonalDishCount(context.Background(), nD.HouseholdID)
+	assert.Equal(t, workers, count)
+}
+
+func conformanceCancelledContext(t *testing.T, repo Repository) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := repo.GetDishes(ctx, nD.HouseholdID)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, err.Status())
+
+	_, err = repo.CreateDish(ctx, *nD)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, err.Status())
+
+	called := false
+	err = repo.WithTx(ctx, func(tx Repository) fcerr.FCErr {
+		called = true
+		return nil
+	})
+	assert.NotNil(t, err)
+	assert.False(t, called)
+
+	//nothing was written, and the repository still works for a live context
+	_, err = repo.GetDishes(context.Background(), nD.HouseholdID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+}
+
+func conformanceNotificationLifecycle(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	soon := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	later := soon.Add(48 * time.Hour)
+	sentAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	none, err := repo.GetNotifications(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(*none))
+
+	assert.Nil(t, repo.CreateNotification(ctx, notification.Notification{UserID: 1, DishID: 7, Channel: notification.Email,
+		ExpireDate: soon, SentDate: sentAt}))
+	assert.Nil(t, repo.CreateNotification(ctx, notification.Notification{UserID: 1, DishID: 8, Channel: notification.Alexa,
+		ExpireDate: later, SentDate: sentAt}))
+	assert.Nil(t, repo.CreateNotification(ctx, notification.Notification{UserID: 2, DishID: 9, Channel: notification.Email,
+		ExpireDate: later, SentDate: sentAt}))
+
+	sent, err := repo.GetNotifications(ctx, 1)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(*sent)) {
+		assert.NotEqual(t, 0, (*sent)[0].NotificationID)
+		assert.Equal(t, sentAt, (*sent)[0].SentDate)
+		assert.True(t, sent.Sent(7, notification.Email, soon))
+		assert.False(t, sent.Sent(7, notification.Email, later), "a dish given longer is a new notification")
+		assert.False(t, sent.Sent(7, notification.Alexa, soon))
+	}
+
+	assert.Nil(t, repo.DeleteNotificationsBefore(ctx, soon.Add(time.Hour)))
+	sent, _ = repo.GetNotifications(ctx, 1)
+	if assert.Equal(t, 1, len(*sent)) {
+		assert.Equal(t, 8, (*sent)[0].DishID)
+	}
+
+	assert.Nil(t, repo.DeleteUserNotifications(ctx, 1))
+	sent, _ = repo.GetNotifications(ctx, 1)
+	assert.Equal(t, 0, len(*sent))
+	others, _ := repo.GetNotifications(ctx, 2)
+	assert.Equal(t, 1, len(*others))
+}
+
+func conformanceRemovalLifecycle(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	monday := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	none, err := repo.GetRemovals(ctx, 9, monday)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(*none))
+
+	assert.Nil(t, repo.CreateRemoval(ctx, dish.Removal{HouseholdID: 9, UserID: 1, StorageID: 2, Title: "Carrots",
+		ExpireDate: monday.Add(48 * time.Hour), RemovedDate: monday, Outcome: dish.Consumed}))
+	assert.Nil(t, repo.CreateRemoval(ctx, dish.Removal{HouseholdID: 9, UserID: 1, Title: "Soup",
+		RemovedDate: monday.Add(24 * time.Hour), Outcome: dish.Wasted}))
+	assert.Nil(t, repo.CreateRemoval(ctx, dish.Removal{HouseholdID: 10, UserID: 2, Title: "Rice",
+		RemovedDate: monday.Add(24 * time.Hour), Outcome: dish.Wasted}))
+
+	removals, err := repo.GetRemovals(ctx, 9, monday)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(*removals)) {
+		assert.NotEqual(t, 0, (*removals)[0].RemovalID)
+		assert.Equal(t, "Carrots", (*removals)[0].Title)
+		assert.Equal(t, 2, (*removals)[0].StorageID)
+		assert.Equal(t, monday.Add(48*time.Hour), (*removals)[0].ExpireDate)
+		assert.Equal(t, monday, (*removals)[0].RemovedDate)
+		assert.True(t, (*removals)[1].ExpireDate.IsZero())
+		consumed, wasted := removals.Count()
+		assert.Equal(t, 1, consumed)
+		assert.Equal(t, 1, wasted)
+	}
+	removals, _ = repo.GetRemovals(ctx, 9, monday.Add(time.Hour))
+	assert.Equal(t, 1, len(*removals), "only the soup was removed since")
+
+	assert.Nil(t, repo.DeleteHouseholdFood(ctx, 9))
+	removals, _ = repo.GetRemovals(ctx, 9, monday)
+	assert.Equal(t, 0, len(*removals))
+	removals, _ = repo.GetRemovals(ctx, 10, monday)
+	assert.Equal(t, 1, len(*removals), "other households keep theirs")
+}
+
+func conformanceDishesExpiringBetween(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	monday := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, d := range []dish.Dish{
+		{Title: "Soup", HouseholdID: 9, ExpireDate: monday.Add(2 * time.Hour)},
+		{Title: "Carrots", HouseholdID: 10, ExpireDate: monday.Add(time.Hour)},
+		{Title: "Rice", HouseholdID: 9, ExpireDate: monday},
+		{Title: "Bread", HouseholdID: 9, ExpireDate: monday.Add(3 * time.Hour)},
+		{Title: "Salt", HouseholdID: 9},
+	} {
+		d.PersonalDishID, d.UserID = i+1, 1
+		if _, err := repo.CreateDish(ctx, d); err != nil {
+			t.Fatal(err.Message())
+		}
+	}
+
+	dishes, err := repo.GetDishesExpiringBetween(ctx, monday, monday.Add(2*time.Hour))
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(*dishes), "after the first time and by the second, in every household") {
+		assert.Equal(t, "Carrots", (*dishes)[0].Title)
+		assert.Equal(t, "Soup", (*dishes)[1].Title)
+	}
+
+	dishes, err = repo.GetDishesExpiringBetween(ctx, monday.Add(-time.Hour), monday.Add(-time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(*dishes))
+}
+
+//titles gives the titles of the dishes, in order.
+func titles(dishes *dish.Dishes) []string {
+	found := []string{}
+	for _, d := range *dishes {
+		found = append(found, d.Title)
+	}
+	return found
+}
+
+func conformanceFindDishes(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	monday := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	fridge, freezer := 1, 2
+	for i, d := range []dish.Dish{
+		{Title: "Tomato Soup", StorageID: fridge, DishType: "Soup", Priority: "High", ExpireDate: monday.Add(48 * time.Hour)},
+		{Title: "Carrots", StorageID: fridge, DishType: "vegetable", ExpireDate: monday.Add(time.Hour)},
+		{Title: "Pea Soup", StorageID: freezer, DishType: "soup", Priority: "low", ExpireDate: monday.Add(72 * time.Hour)},
+		{Title: "50% Rye", StorageID: fridge, Priority: "urgent", ExpireDate: monday.Add(time.Hour)},
+		{Title: "Rice", StorageID: freezer, Priority: "medium", ExpireDate: monday.Add(-time.Hour)},
+	} {
+		d.PersonalDishID, d.UserID, d.HouseholdID = i+1, 1, 9
+		d.CreatedDate = monday.Add(-time.Duration(i) * time.Hour)
+		if _, err := repo.CreateDish(ctx, d); err != nil {
+			t.Fatal(err.Message())
+		}
+	}
+	repo.CreateDish(ctx, dish.Dish{Title: "Soup", PersonalDishID: 1, UserID: 2, HouseholdID: 10, DishType: "soup", ExpireDate: monday})
+
+	find := func(q dish.Query) []string {
+		dishes, err := repo.FindDishes(ctx, 9, q)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		return titles(dishes)
+	}
+	assert.Equal(t, []string{"Tomato Soup", "Carrots", "Pea Soup", "50% Rye", "Rice"}, find(dish.Query{}), "in the order they were added")
+	assert.Equal(t, []string{"Pea Soup", "Rice"}, find(dish.Query{StorageID: &freezer}))
+	assert.Equal(t, []string{"Tomato Soup", "Pea Soup"}, find(dish.Query{DishType: "SOUP"}), "ignoring case")
+	assert.Equal(t, []string{"Tomato Soup", "50% Rye"}, find(dish.Query{Priority: "high"}))
+	assert.Equal(t, []string{"Carrots", "Rice"}, find(dish.Query{Priority: "medium"}), "no priority is medium")
+	assert.Equal(t, []string{"Carrots", "50% Rye"}, find(dish.Query{ExpiresAfter: monday, ExpiresBefore: monday.Add(time.Hour)}))
+	assert.Equal(t, []string{"Tomato Soup", "Pea Soup"}, find(dish.Query{Search: "soup"}))
+	assert.Equal(t, []string{"50% Rye"}, find(dish.Query{Search: "0%"}), "a % is searched for as it is")
+	assert.Equal(t, []string{}, find(dish.Query{Search: "cake"}))
+
+	assert.Equal(t, []string{"Rice", "Carrots", "50% Rye", "Tomato Soup", "Pea Soup"}, find(dish.Query{Sort: dish.SortExpireDate}))
+	assert.Equal(t, []string{"Pea Soup", "Tomato Soup", "50% Rye", "Carrots", "Rice"}, find(dish.Query{Sort: dish.SortExpireDate, Descending: true}))
+	assert.Equal(t, []string{"Rice", "50% Rye", "Pea Soup", "Carrots", "Tomato Soup"}, find(dish.Query{Sort: dish.SortCreatedDate}))
+	assert.Equal(t, []string{"Tomato Soup", "50% Rye", "Carrots", "Rice", "Pea Soup"}, find(dish.Query{Sort: dish.SortPriority}))
+
+	//paging through, each page after the last dish of the one before
+	for _, q := range []dish.Query{
+		{Sort: dish.SortDefault},
+		{Sort: dish.SortExpireDate},
+		{Sort: dish.SortCreatedDate, Descending: true},
+		{Sort: dish.SortPriority},
+		{Sort: dish.SortPriority, Descending: true},
+	} {
+		whole := find(q)
+		var paged []string
+		q.Limit = 2
+		for {
+			dishes, err := repo.FindDishes(ctx, 9, q)
+			if !assert.Nil(t, err) || len(*dishes) == 0 {
+				break
+			}
+			paged = append(paged, titles(dishes)...)
+			last := dish.CursorAfter((*dishes)[len(*dishes)-1], q.Sort, q.Descending)
+			q.After = &last
+		}
+		assert.Equal(t, whole, paged, q.Sort)
+	}
+
+	//a dish with no expire date isn't expiring before anything
+	repo.CreateDish(ctx, dish.Dish{Title: "Salt", PersonalDishID: 1, UserID: 3, HouseholdID: 11})
+	dishes, err := repo.FindDishes(ctx, 11, dish.Query{ExpiresBefore: monday})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, titles(dishes))
+	dishes, err = repo.FindDishes(ctx, 11, dish.Query{Sort: dish.SortExpireDate, Descending: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Salt"}, titles(dishes))
+}
+
+func conformanceWebhookLifecycle(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	monday := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	none, err := repo.GetWebhooks(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(*none))
+	_, err = repo.GetWebhookByID(ctx, 1, 1)
+	assert.True(t, errors.Is(err, fcerr.ErrNotFound))
+
+	created, err := repo.CreateWebhook(ctx, webhook.Subscription{UserID: 1, URL: "https://example.com/hook", Secret: "whsec_one",
+		Events: []string{"dish.created", "dish.deleted"}, Active: true, CreatedDate: monday})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.NotEqual(t, 0, created.SubscriptionID)
+	assert.Equal(t, "whsec_one", created.Secret)
+	assert.Equal(t, []string{"dish.created", "dish.deleted"}, created.Events)
+	assert.Equal(t, monday, created.CreatedDate)
+	other, _ := repo.CreateWebhook(ctx, webhook.Subscription{UserID: 2, URL: "http://homeassistant.local:8123/api/webhook/fc",
+		Secret: "whsec_two", Active: true, CreatedDate: monday})
+	assert.Equal(t, []string{}, other.Events)
+	_, err = repo.GetWebhookByID(ctx, 2, created.SubscriptionID)
+	assert.True(t, errors.Is(err, fcerr.ErrNotFound), "someone else's webhook")
+
+	for _, userID := range []int{1, 2} {
+		assert.Nil(t, repo.CreateMembership(ctx, household.Member{HouseholdID: 9, UserID: userID, Role: household.RoleMember,
+			JoinedDate: monday}))
+	}
+	inHousehold, err := repo.GetHouseholdWebhooks(ctx, 9)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(*inHousehold))
+
+	created.URL, created.Events, created.Active = "https://example.com/other", []string{"dish.expired"}, false
+	assert.Nil(t, repo.UpdateWebhook(ctx, *created))
+	updated, err := repo.GetWebhookByID(ctx, 1, created.SubscriptionID)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://example.com/other", updated.URL)
+	assert.Equal(t, []string{"dish.expired"}, updated.Events)
+	assert.False(t, updated.Active)
+	assert.Equal(t, "whsec_one", updated.Secret)
+	inHousehold, _ = repo.GetHouseholdWebhooks(ctx, 9)
+	if assert.Equal(t, 1, len(*inHousehold), "only active ones") {
+		assert.Equal(t, other.SubscriptionID, (*inHousehold)[0].SubscriptionID)
+	}
+
+	err = repo.DeleteWebhook(ctx, 2, created.SubscriptionID)
+	assert.True(t, errors.Is(err, fcerr.ErrNotFound))
+	assert.Nil(t, repo.DeleteWebhook(ctx, 1, created.SubscriptionID))
+	remaining, _ := repo.GetWebhooks(ctx, 1)
+	assert.Equal(t, 0, len(*remaining))
+
+	assert.Nil(t, repo.DeleteUserWebhooks(ctx, 2))
+	remaining, _ = repo.GetWebhooks(ctx, 2)
+	assert.Equal(t, 0, len(*remaining))
+}
+
+func conformanceDeliveryLifecycle(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	monday := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	first, err := repo.CreateDelivery(ctx, webhook.Delivery{SubscriptionID: 5, UserID: 1, EventID: "evt_1", EventType: "dish.created",
+		Payload: `{"Type":"dish.created"}`, Status: webhook.Pending, NextAttempt: monday.Add(time.Minute), CreatedDate: monday,
+		UpdatedDate: monday})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.NotEqual(t, 0, first.DeliveryID)
+	assert.Equal(t, `{"Type":"dish.created"}`, first.Payload)
+	second, _ := repo.CreateDelivery(ctx, webhook.Delivery{SubscriptionID: 5, UserID: 1, EventID: "evt_2", EventType: "dish.deleted",
+		Payload: "{}", Status: webhook.Pending, NextAttempt: monday, CreatedDate: monday, UpdatedDate: monday})
+	repo.CreateDelivery(ctx, webhook.Delivery{SubscriptionID: 6, UserID: 2, EventID: "evt_3", EventType: "dish.deleted",
+		Payload: "{}", Status: webhook.Pending, NextAttempt: monday.Add(time.Hour), CreatedDate: monday, UpdatedDate: monday})
+
+	due, err := repo.GetDueDeliveries(ctx, monday.Add(time.Minute))
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(*due), "the third isn't due yet") {
+		assert.Equal(t, second.DeliveryID, (*due)[0].DeliveryID, "the longest waiting first")
+		assert.Equal(t, first.DeliveryID, (*due)[1].DeliveryID)
+	}
+
+	second.Failed(monday, http.StatusInternalServerError, "The webhook answered with status 500")
+	assert.Nil(t, repo.UpdateDelivery(ctx, *second))
+	fetched, err := repo.GetDeliveryByID(ctx, 1, second.DeliveryID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, fetched.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, fetched.ResponseCode)
+	assert.Equal(t, monday.Add(webhook.FirstRetry), fetched.NextAttempt)
+	_, err = repo.GetDeliveryByID(ctx, 2, second.DeliveryID)
+	assert.True(t, errors.Is(err, fcerr.ErrNotFound), "someone else's delivery")
+
+	first.GiveUp(monday, "The webhook was turned off")
+	assert.Nil(t, repo.UpdateDelivery(ctx, *first))
+	dead, err := repo.GetDeliveriesByStatus(ctx, 1, webhook.Dead)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(*dead)) {
+		assert.Equal(t, first.DeliveryID, (*dead)[0].DeliveryID)
+		assert.True(t, (*dead)[0].NextAttempt.IsZero())
+	}
+
+	log, err := repo.GetDeliveries(ctx, 1, 5)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(*log)) {
+		assert.Equal(t, second.DeliveryID, (*log)[0].DeliveryID, "newest first")
+	}
+
+	assert.Nil(t, repo.DeleteDeliveriesBefore(ctx, monday.Add(time.Second)))
+	log, _ = repo.GetDeliveries(ctx, 1, 5)
+	if assert.Equal(t, 1, len(*log), "the dead one is forgotten, the pending one kept") {
+		assert.Equal(t, second.DeliveryID, (*log)[0].DeliveryID)
+	}
+
+	assert.Nil(t, repo.DeleteUserWebhooks(ctx, 1))
+	log, _ = repo.GetDeliveries(ctx, 1, 5)
+	assert.Equal(t, 0, len(*log))
+	log, _ = repo.GetDeliveries(ctx, 2, 6)
+	assert.Equal(t, 1, len(*log), "other users keep theirs")
+}
@@ -1,169 +1,748 @@
 package db
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/identity"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/notification"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/session"
 	"github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
 	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/webhook"
 	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
 )
 
-//GetDishesBase is the Query for GetDishes().
-const GetDishesBase = `SELECT * FROM dish WHERE user_id = %d`
+//DishColumns lists the dish columns in the order every dish query scans them, so new columns don't break rows.Scan.
+const DishColumns = `id, personal_id, user_id, storage_id, title, description, created_date, expire_date, priority, dish_type, portions, temp_match, household_id`
 
-//GetDishByIDBase can be used with fmt.Sprintf() to get the Query for GetDishByID().
-const GetDishByIDBase = `SELECT * FROM dish WHERE user_id = %d AND personal_id = %d`
+//UserColumns lists the user columns in the order every user query scans them.
+const UserColumns = `id, email, first_name, last_name, full_name, created_date, access_token, refresh_token, is_admin, temp_match, token_expiry, token_provider, deactivated_date, ` +
+	`time_zone, notify_expiring, notify_days_before, deletion_date, quiet_start, quiet_end`
 
-//GetDishByTempMatchBase can be used with fmt.Sprintf() to get the Query for GetDishByTempMatch().
-const GetDishByTempMatchBase = `SELECT * FROM dish WHERE temp_match = "%s"`
+//StorageColumns lists the storage columns in the order every storage query scans them.
+const StorageColumns = `id, personal_id, user_id, title, description, temp_match, household_id`
 
-//GetPersonalDishCountBase returns the number of dishes a given user has in the database, to be used for personal_id field
-const GetPersonalDishCountBase = `SELECT COUNT(*) FROM dish WHERE user_id = %d`
+//SessionColumns lists the session columns in the order every session query scans them.
+const SessionColumns = `id, user_id, token_hash, created_date, expire_date, revoked_date, impersonator_id`
 
-//GetPersonalStorageCountBase returns the number of storage units a given user has in the database, to be used for personal_id field
-const GetPersonalStorageCountBase = `SELECT COUNT(*) FROM storage WHERE user_id = %d`
+//IdentityColumns lists the linked_identity columns in the order every identity query scans them.
+const IdentityColumns = `id, user_id, provider, external_id, access_token_hash, refresh_token_hash, created_date`
 
-//DecrementSomeDishesBase is used to shift every dish "up" after one in the middle of the dish list is deleted
-const DecrementSomeDishesBase = `UPDATE dish SET personal_id = personal_id - 1 WHERE user_id = %d AND personal_id IN(%s)`
+//NotificationColumns lists the notification columns in the order every notification query scans them.
+const NotificationColumns = `id, user_id, dish_id, channel, expire_date, sent_date`
 
-//CreateDishBase can be used with fmt.Sprintf() to get the Query for CreateDish().
-const CreateDishBase = `INSERT INTO dish ` +
-	`(personal_id, user_id, storage_id, title, description, created_date, expire_date, priority, dish_type, portions, temp_match) ` +
-	`VALUES(%d, %d, %d, "%s", "%s", "%s", "%s", "%s", "%s", %d, "%s")`
+//RemovalColumns lists the dish_removal columns in the order every removal query scans them.
+const RemovalColumns = `id, household_id, user_id, storage_id, title, expire_date, removed_date, outcome`
 
-//UpdateDishBase can be used with fmt.Sprintf() to get the Query for UpdateDish().
-const UpdateDishBase = `UPDATE dish SET personal_id = %d, storage_id = %d, title = "%s", description = "%s", expire_date = "%s", ` +
-	`priority = "%s", dish_type = "%s", portions = %d WHERE id=%d`
+//WebhookColumns lists the webhook_subscription columns in the order every webhook query scans them.
+const WebhookColumns = `id, user_id, url, secret, events, active, created_date, temp_match`
 
-//DeleteDishBase can be used with fmt.Sprintf() to get the Query for DeleteDish().
-const DeleteDishBase = `DELETE FROM dish WHERE user_id = %d AND personal_id=%d`
+//DeliveryColumns lists the webhook_delivery columns in the order every delivery query scans them.
+const DeliveryColumns = `id, subscription_id, user_id, event_id, event_type, payload, status, attempts, response_code, ` +
+	`last_error, next_attempt, created_date, updated_date, temp_match`
 
-//GetUsersBase is the Query for GetUsers().
-const GetUsersBase = `SELECT * FROM user`
+//HouseholdColumns lists the household columns in the order every household query scans them.
+const HouseholdColumns = `id, name, created_date, temp_match`
 
-//GetUserByIDBase can be used with fmt.Sprintf() to get the Query for GetUserByID().
-const GetUserByIDBase = `SELECT * FROM user WHERE id = %d`
+//InviteColumns lists the household_invite columns in the order every invite query scans them.
+const InviteColumns = `id, household_id, email, role, invited_by, created_date, expire_date, temp_match`
 
-//GetUserByEmailBase can be used with fmt.Sprintf() to get the Query for GetUserByEmail().
-const GetUserByEmailBase = `SELECT * FROM user WHERE email = "%s"`
+//MemberColumns lists the household_member columns, and the member's email and name from the user table, in the order
+//every member query scans them.
+const MemberColumns = `household_member.household_id, household_member.user_id, household_member.role, ` +
+	`household_member.joined_date, COALESCE(user.email, ''), COALESCE(user.full_name, '')`
 
-//GetUserByAlexaBase can be used with fmt.Sprintf() to get the Query for GetUserByAlexa().
-const GetUserByAlexaBase = `SELECT * FROM user WHERE alexa_user_id = "%s"`
+//memberTables is what every member query selects from.
+const memberTables = ` FROM household_member LEFT JOIN user ON user.id = household_member.user_id`
 
-//GetUserByTempMatchBase can be used with fmt.Sprintf() to get the Query for GetUserByTempMatch().
-const GetUserByTempMatchBase = `SELECT * FROM user WHERE temp_match = "%s"`
+//GetDishesQuery is the Query for GetDishes(), bound with the household id.
+const GetDishesQuery = `SELECT ` + DishColumns + ` FROM dish WHERE household_id = ?`
 
-//CreateUserBase can be used with fmt.Sprintf() to get the Query for CreateUser().
-const CreateUserBase = `INSERT INTO user (email, first_name, last_name, full_name, created_date, access_token, refresh_token, alexa_user_id, is_admin, temp_match) ` +
-	`VALUES("%s", "%s", "%s", "%s", "%s", "%s", "%s", "%s", %t, "%s")`
+//GetDishByIDQuery is the Query for GetDishByID(), bound with the household id and the personal dish id.
+const GetDishByIDQuery = `SELECT ` + DishColumns + ` FROM dish WHERE household_id = ? AND personal_id = ?`
 
-//UpdateUserBase can be used with fmt.Sprintf() to get the Query for UpdateUser().
-const UpdateUserBase = `UPDATE user SET email = "%s", first_name = "%s", last_name = "%s", full_name = "%s", ` +
-	`access_token = "%s", refresh_token = "%s", alexa_user_id = "%s", temp_match = "%s" WHERE id = %d `
+//GetDishByTempMatchQuery is the Query for GetDishByTempMatch(), bound with the temp match string.
+const GetDishByTempMatchQuery = `SELECT ` + DishColumns + ` FROM dish WHERE temp_match = ?`
 
-//DeleteUserBase can be used with fmt.Sprintf() to get the Query for DeleteUser().
-const DeleteUserBase = `DELETE FROM user WHERE id=%d`
+//GetPersonalDishCountQuery returns the number of dishes a given household has in the database, to be used for personal_id field
+const GetPersonalDishCountQuery = `SELECT COUNT(*) FROM dish WHERE household_id = ?`
 
-//GetStoragesBase can be used with fmt.Sprintf() to get the Query for GetAllStorage().
-const GetStoragesBase = `SELECT * FROM storage WHERE user_id=%d`
+//GetPersonalStorageCountQuery returns the number of storage units a given household has in the database, to be used for personal_id field
+const GetPersonalStorageCountQuery = `SELECT COUNT(*) FROM storage WHERE household_id = ?`
 
-//GetStorageByIDBase can be used with fmt.Sprintf() to get the Query for GetStorageByID().
-const GetStorageByIDBase = `SELECT * FROM storage WHERE user_id = %d AND personal_id = %d`
+//ForUpdate is appended to the personal id count queries inside a transaction, so concurrent creates and deletes
+//for the same household wait on each other instead of handing out the same personal_id.
+const ForUpdate = ` FOR UPDATE`
 
-//GetStorageByTempMatchBase can be used with fmt.Sprintf() to get the Query for GetStorageByTempMatch().
-const GetStorageByTempMatchBase = `SELECT * FROM storage WHERE temp_match="%s"`
+//DecrementSomeDishesQuery is used to shift every dish "up" after one in the middle of the dish list is deleted,
+//bound with the household id and the personal id of the deleted dish.
+const DecrementSomeDishesQuery = `UPDATE dish SET personal_id = personal_id - 1 WHERE household_id = ? AND personal_id > ?`
 
-//CreateStorageBase can be used with fmt.Sprintf() to get the Query for CreateStorage().
-const CreateStorageBase = `INSERT INTO storage (personal_id, user_id, title, description, temp_match) ` +
-	`VALUES(%d, %d, "%s", "%s", "%s")`
+//CreateDishQuery is the statement for CreateDish().
+const CreateDishQuery = `INSERT INTO dish ` +
+	`(personal_id, user_id, storage_id, title, description, created_date, expire_date, priority, dish_type, portions, temp_match, household_id) ` +
+	`VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-//UpdateStorageBase can be used with fmt.Sprintf() to get the Query for UpdateStorage().
-const UpdateStorageBase = `UPDATE storage SET personal_id = %d, title = "%s", description = "%s", temp_match = "%s" WHERE id=%d`
+//UpdateDishQuery is the statement for UpdateDish().
+const UpdateDishQuery = `UPDATE dish SET personal_id = ?, storage_id = ?, title = ?, description = ?, expire_date = ?, ` +
+	`priority = ?, dish_type = ?, portions = ? WHERE id = ?`
 
-//DeleteStorageBase can be used with fmt.Sprintf() to get the Query for DeleteStorage().
-const DeleteStorageBase = `DELETE FROM storage WHERE user_id = %d AND personal_id=%d`
+//DeleteDishQuery is the statement for DeleteDish(), bound with the household id and the personal dish id.
+const DeleteDishQuery = `DELETE FROM dish WHERE household_id = ? AND personal_id = ?`
 
-//GetStorageDishesBase can be used with fmt.Sprintf() to get the Query for GetStorageDishes().
-const GetStorageDishesBase = `SELECT * FROM dish WHERE user_id = %d AND storage_id = %d`
+//GetUsersQuery is the Query for GetUsers() without a search.
+const GetUsersQuery = `SELECT ` + UserColumns + ` FROM user ORDER BY id`
+
+//SearchUsersQuery is the Query for GetUsers() with a search, bound with the LIKE pattern twice - once for the email and
+//once for the name. '!' escapes the wildcards in what was searched for, since mysql and sqlite disagree about backslashes.
+const SearchUsersQuery = `SELECT ` + UserColumns + ` FROM user WHERE email LIKE ? ESCAPE '!' OR full_name LIKE ? ESCAPE '!' ORDER BY id`
+
+//GetUserByIDQuery is the Query for GetUserByID(), bound with the user id.
+const GetUserByIDQuery = `SELECT ` + UserColumns + ` FROM user WHERE id = ?`
+
+//GetUserByEmailQuery is the Query for GetUserByEmail(), bound with the email address.
+const GetUserByEmailQuery = `SELECT ` + UserColumns + ` FROM user WHERE email = ?`
+
+//GetUserByTempMatchQuery is the Query for GetUserByTempMatch(), bound with the temp match string.
+const GetUserByTempMatchQuery = `SELECT ` + UserColumns + ` FROM user WHERE temp_match = ?`
+
+//CreateUserQuery is the statement for CreateUser().
+const CreateUserQuery = `INSERT INTO user (email, first_name, last_name, full_name, created_date, access_token, refresh_token, is_admin, temp_match, token_expiry, token_provider, ` +
+	`time_zone, notify_expiring, notify_days_before, quiet_start, quiet_end) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+//UpdateUserQuery is the statement for UpdateUser().
+const UpdateUserQuery = `UPDATE user SET email = ?, first_name = ?, last_name = ?, full_name = ?, ` +
+	`access_token = ?, refresh_token = ?, token_expiry = ?, token_provider = ?, temp_match = ?, ` +
+	`time_zone = ?, notify_expiring = ?, notify_days_before = ?, quiet_start = ?, quiet_end = ? WHERE id = ?`
+
+//SetUserDeactivatedQuery is the statement for SetUserDeactivated(), bound with the deactivated time and the user id.
+const SetUserDeactivatedQuery = `UPDATE user SET deactivated_date = ? WHERE id = ?`
+
+//SetUserDeletionQuery is the statement for SetUserDeletion(), bound with the deletion time and the user id.
+const SetUserDeletionQuery = `UPDATE user SET deletion_date = ? WHERE id = ?`
+
+//GetUsersPendingDeletionQuery is the Query for GetUsersPendingDeletion().
+const GetUsersPendingDeletionQuery = `SELECT ` + UserColumns + ` FROM user WHERE deletion_date <> '' ORDER BY id`
+
+//GetUsersToNotifyQuery is the Query for GetUsersToNotify().
+const GetUsersToNotifyQuery = `SELECT ` + UserColumns + ` FROM user WHERE notify_expiring = TRUE AND deactivated_date = '' ORDER BY id`
+
+//DeleteUserSessionsQuery is the statement for DeleteUserSessions(), bound with the user id.
+const DeleteUserSessionsQuery = `DELETE FROM session WHERE user_id = ?`
+
+//DeleteHouseholdDishesQuery and DeleteHouseholdStoragesQuery are the statements for DeleteHouseholdFood(), bound with the household id.
+const DeleteHouseholdDishesQuery = `DELETE FROM dish WHERE household_id = ?`
+const DeleteHouseholdStoragesQuery = `DELETE FROM storage WHERE household_id = ?`
+
+//DeleteHouseholdRemovalsQuery is the statement for DeleteHouseholdFood() that forgets the dishes the household removed,
+//bound with the household id.
+const DeleteHouseholdRemovalsQuery = `DELETE FROM dish_removal WHERE household_id = ?`
+
+//DeleteUserQuery is the statement for DeleteUser(), bound with the user id.
+const DeleteUserQuery = `DELETE FROM user WHERE id = ?`
+
+//GetStoragesQuery is the Query for GetStorages(), bound with the household id.
+const GetStoragesQuery = `SELECT ` + StorageColumns + ` FROM storage WHERE household_id = ?`
+
+//GetStorageByIDQuery is the Query for GetStorageByID(), bound with the household id and the personal storage id.
+const GetStorageByIDQuery = `SELECT ` + StorageColumns + ` FROM storage WHERE household_id = ? AND personal_id = ?`
+
+//GetStorageByTempMatchQuery is the Query for GetStorageByTempMatch(), bound with the temp match string.
+const GetStorageByTempMatchQuery = `SELECT ` + StorageColumns + ` FROM storage WHERE temp_match = ?`
+
+//CreateStorageQuery is the statement for CreateStorage().
+const CreateStorageQuery = `INSERT INTO storage (personal_id, user_id, title, description, temp_match, household_id) ` +
+	`VALUES(?, ?, ?, ?, ?, ?)`
+
+//UpdateStorageQuery is the statement for UpdateStorage().
+const UpdateStorageQuery = `UPDATE storage SET personal_id = ?, title = ?, description = ?, temp_match = ? WHERE id = ?`
+
+//DeleteStorageQuery is the statement for DeleteStorage(), bound with the household id and the personal storage id.
+const DeleteStorageQuery = `DELETE FROM storage WHERE household_id = ? AND personal_id = ?`
+
+//GetStorageDishCountQuery returns how many dishes are in one storage unit, bound with the household id and the storage id.
+const GetStorageDishCountQuery = `SELECT COUNT(*) FROM dish WHERE household_id = ? AND storage_id = ?`
+
+//NegateSomeStoragesQuery is the first half of shifting storage units "up" after a deletion, bound with the household id and
+//the personal id of the deleted storage. Parking the new ids as negatives keeps UNIQUE (household_id, personal_id) happy mid-update.
+const NegateSomeStoragesQuery = `UPDATE storage SET personal_id = 1 - personal_id WHERE household_id = ? AND personal_id > ?`
+
+//RestoreNegatedStoragesQuery is the second half of the shift, bound with the household id.
+const RestoreNegatedStoragesQuery = `UPDATE storage SET personal_id = -personal_id WHERE household_id = ? AND personal_id < 0`
+
+//DecrementSomeStorageDishesQuery moves dishes along with their storage unit's new personal id,
+//bound with the household id and the personal id of the deleted storage.
+const DecrementSomeStorageDishesQuery = `UPDATE dish SET storage_id = storage_id - 1 WHERE household_id = ? AND storage_id > ?`
+
+//GetDishExpiryCountsQuery is the Query for GetDishExpiryCounts(). Expire dates are DATETIME columns, so there is one
+//group per time, and one for the dishes with no expire date.
+const GetDishExpiryCountsQuery = `SELECT household_id, expire_date, COUNT(*) FROM dish GROUP BY household_id, expire_date`
+
+//GetDishesExpiringBetweenQuery is the Query for GetDishesExpiringBetween(), bound with the time after which and the time
+//by which the dishes expire. A dish with no expire date is NULL, so it is never between them.
+const GetDishesExpiringBetweenQuery = `SELECT ` + DishColumns + ` FROM dish WHERE expire_date > ? AND expire_date <= ? ORDER BY expire_date, id`
+
+//PriorityRankSQL is dish.PriorityRank in SQL, for FindDishes() to sort and narrow down by priority.
+const PriorityRankSQL = `CASE LOWER(TRIM(priority)) WHEN 'high' THEN 0 WHEN 'urgent' THEN 0 WHEN 'low' THEN 2 ELSE 1 END`
+
+//dishSortExpressions are what FindDishes() orders by for each of the dish sort keys, before the id. A date is
+//compared as dish.StorageFormat text with "" for no date, which is how a Cursor keeps it, so a NULL date still sorts
+//first and a cursor can carry on past it.
+var dishSortExpressions = map[string]string{
+	dish.SortExpireDate:  "COALESCE(expire_date, '')",
+	dish.SortCreatedDate: "COALESCE(created_date, '')",
+	dish.SortPriority:    PriorityRankSQL,
+}
+
+//findDishesQuery builds the Query for FindDishes() on GetDishesQuery, and what to bind it with. Everything from the
+//dish.Query is bound rather than written into the query, apart from the sort. The cursor carries on after the dish it
+//was made from, in the same order. A dish with no expire date is never narrowed down to by ExpiresAfter or ExpiresBefore.
+func findDishesQuery(householdID int, q dish.Query) (string, []interface{}) {
+	query := GetDishesQuery
+	args := []interface{}{householdID}
+	if q.StorageID != nil {
+		query += ` AND storage_id = ?`
+		args = append(args, *q.StorageID)
+	}
+	if q.DishType != "" {
+		query += ` AND LOWER(dish_type) = ?`
+		args = append(args, strings.ToLower(q.DishType))
+	}
+	if q.Priority != "" {
+		query += ` AND ` + PriorityRankSQL + ` = ?`
+		args = append(args, dish.PriorityRank(q.Priority))
+	}
+	if !q.ExpiresAfter.IsZero() {
+		query += ` AND expire_date > ?`
+		args = append(args, storedTime(q.ExpiresAfter))
+	}
+	if !q.ExpiresBefore.IsZero() {
+		query += ` AND expire_date <= ?`
+		args = append(args, storedTime(q.ExpiresBefore))
+	}
+	if q.Search != "" {
+		query += ` AND title LIKE ? ESCAPE '!'`
+		args = append(args, "%"+likeEscaper.Replace(q.Search)+"%")
+	}
+
+	direction, comparison := "", ">"
+	if q.Descending {
+		direction, comparison = " DESC", "<"
+	}
+	sortBy, sorted := dishSortExpressions[q.Sort]
+	if q.After != nil && sorted {
+		var key interface{} = q.After.Key
+		if q.Sort == dish.SortPriority {
+			//the rank is a number, and sqlite never finds a number equal to text
+			key, _ = strconv.Atoi(q.After.Key)
+		}
+		query += ` AND (` + sortBy + ` ` + comparison + ` ? OR (` + sortBy + ` = ? AND id ` + comparison + ` ?))`
+		args = append(args, key, key, q.After.ID)
+	} else if q.After != nil {
+		query += ` AND id ` + comparison + ` ?`
+		args = append(args, q.After.ID)
+	}
+
+	query += ` ORDER BY `
+	if sorted {
+		query += sortBy + direction + `, `
+	}
+	query += `id` + direction
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+	return query, args
+}
+
+//GetStorageDishesQuery is the Query for GetStorageDishes(), bound with the household id and the storage id.
+const GetStorageDishesQuery = `SELECT ` + DishColumns + ` FROM dish WHERE household_id = ? AND storage_id = ?`
+
+//GetSessionByTokenHashQuery is the Query for GetSessionByTokenHash(), bound with the token hash.
+const GetSessionByTokenHashQuery = `SELECT ` + SessionColumns + ` FROM session WHERE token_hash = ?`
+
+//CreateSessionQuery is the statement for CreateSession().
+const CreateSessionQuery = `INSERT INTO session (user_id, token_hash, created_date, expire_date, revoked_date, impersonator_id) ` +
+	`VALUES(?, ?, ?, ?, '', ?)`
+
+//RevokeSessionQuery is the statement for RevokeSession(), bound with the revoked time and the token hash.
+//A session that is already revoked keeps the time it was first revoked.
+const RevokeSessionQuery = `UPDATE session SET revoked_date = ? WHERE token_hash = ? AND revoked_date = ''`
+
+//RevokeUserSessionsQuery is the statement for RevokeUserSessions(), bound with the revoked time and the user id.
+const RevokeUserSessionsQuery = `UPDATE session SET revoked_date = ? WHERE user_id = ? AND revoked_date = ''`
+
+//GetIdentityQuery is the Query for GetIdentity(), bound with the provider and the external id.
+const GetIdentityQuery = `SELECT ` + IdentityColumns + ` FROM linked_identity WHERE provider = ? AND external_id = ?`
+
+//GetIdentityByRefreshHashQuery is the Query for GetIdentityByRefreshHash(), bound with the refresh token hash.
+const GetIdentityByRefreshHashQuery = `SELECT ` + IdentityColumns + ` FROM linked_identity WHERE refresh_token_hash = ?`
+
+//GetUserIdentitiesQuery is the Query for GetUserIdentities(), bound with the user id.
+const GetUserIdentitiesQuery = `SELECT ` + IdentityColumns + ` FROM linked_identity WHERE user_id = ? ORDER BY id`
+
+//CreateIdentityQuery is the statement for CreateIdentity().
+const CreateIdentityQuery = `INSERT INTO linked_identity ` +
+	`(user_id, provider, external_id, access_token_hash, refresh_token_hash, created_date) VALUES(?, ?, ?, ?, ?, ?)`
+
+//UpdateIdentityQuery is the statement for UpdateIdentity(), bound with the new external id and token hashes and the link id.
+const UpdateIdentityQuery = `UPDATE linked_identity SET external_id = ?, access_token_hash = ?, refresh_token_hash = ? WHERE id = ?`
+
+//DeleteIdentityQuery is the statement for DeleteIdentity(), bound with the user id and the link id.
+const DeleteIdentityQuery = `DELETE FROM linked_identity WHERE user_id = ? AND id = ?`
+
+//GetHouseholdQuery is the Query for GetHousehold(), bound with the household id.
+const GetHouseholdQuery = `SELECT ` + HouseholdColumns + ` FROM household WHERE id = ?`
+
+//GetHouseholdByTempMatchQuery finds a household that was just created, bound with the temp match string.
+const GetHouseholdByTempMatchQuery = `SELECT ` + HouseholdColumns + ` FROM household WHERE temp_match = ?`
+
+//CreateHouseholdQuery is the statement for CreateHousehold().
+const CreateHouseholdQuery = `INSERT INTO household (name, created_date, temp_match) VALUES(?, ?, ?)`
+
+//UpdateHouseholdQuery is the statement for UpdateHousehold(), bound with the name and the household id.
+const UpdateHouseholdQuery = `UPDATE household SET name = ? WHERE id = ?`
+
+//DeleteHouseholdQuery is the statement for DeleteHousehold(), bound with the household id.
+const DeleteHouseholdQuery = `DELETE FROM household WHERE id = ?`
+
+//GetMembershipQuery is the Query for GetMembership(), bound with the user id.
+const GetMembershipQuery = `SELECT ` + MemberColumns + memberTables + ` WHERE household_member.user_id = ?`
+
+//GetHouseholdMembersQuery is the Query for GetHouseholdMembers(), bound with the household id.
+const GetHouseholdMembersQuery = `SELECT ` + MemberColumns + memberTables + ` WHERE household_member.household_id = ? ` +
+	`ORDER BY household_member.joined_date, household_member.user_id`
+
+//CreateMembershipQuery is the statement for CreateMembership().
+const CreateMembershipQuery = `INSERT INTO household_member (household_id, user_id, role, joined_date) VALUES(?, ?, ?, ?)`
+
+//UpdateMembershipQuery is the statement for UpdateMembership(), bound with the role, the household id and the user id.
+const UpdateMembershipQuery = `UPDATE household_member SET role = ? WHERE household_id = ? AND user_id = ?`
+
+//DeleteMembershipQuery is the statement for DeleteMembership(), bound with the household id and the user id.
+const DeleteMembershipQuery = `DELETE FROM household_member WHERE household_id = ? AND user_id = ?`
+
+//GetInviteQuery is the Query for GetInvite(), bound with the invite id.
+const GetInviteQuery = `SELECT ` + InviteColumns + ` FROM household_invite WHERE id = ?`
+
+//GetInviteByTempMatchQuery finds an invite that was just created, bound with the temp match string.
+const GetInviteByTempMatchQuery = `SELECT ` + InviteColumns + ` FROM household_invite WHERE temp_match = ?`
+
+//GetInvitesByEmailQuery is the Query for GetInvitesByEmail(), bound with the lower case email.
+const GetInvitesByEmailQuery = `SELECT ` + InviteColumns + ` FROM household_invite WHERE email = ? ORDER BY id`
+
+//GetHouseholdInvitesQuery is the Query for GetHouseholdInvites(), bound with the household id.
+const GetHouseholdInvitesQuery = `SELECT ` + InviteColumns + ` FROM household_invite WHERE household_id = ? ORDER BY id`
+
+//CreateInviteQuery is the statement for CreateInvite().
+const CreateInviteQuery = `INSERT INTO household_invite (household_id, email, role, invited_by, created_date, expire_date, temp_match) ` +
+	`VALUES(?, ?, ?, ?, ?, ?, ?)`
+
+//DeleteInviteQuery is the statement for DeleteInvite(), bound with the invite id.
+const DeleteInviteQuery = `DELETE FROM household_invite WHERE id = ?`
+
+//GetNotificationsQuery is the Query for GetNotifications(), bound with the user id.
+const GetNotificationsQuery = `SELECT ` + NotificationColumns + ` FROM notification WHERE user_id = ? ORDER BY id`
+
+//CreateNotificationQuery is the statement for CreateNotification().
+const CreateNotificationQuery = `INSERT INTO notification (user_id, dish_id, channel, expire_date, sent_date) VALUES(?, ?, ?, ?, ?)`
+
+//DeleteNotificationsBeforeQuery is the statement for DeleteNotificationsBefore(), bound with the expiry time.
+const DeleteNotificationsBeforeQuery = `DELETE FROM notification WHERE expire_date < ?`
+
+//DeleteUserNotificationsQuery is the statement for DeleteUserNotifications(), bound with the user id.
+const DeleteUserNotificationsQuery = `DELETE FROM notification WHERE user_id = ?`
+
+//GetRemovalsQuery is the Query for GetRemovals(), bound with the household id and the earliest removal time.
+const GetRemovalsQuery = `SELECT ` + RemovalColumns + ` FROM dish_removal WHERE household_id = ? AND removed_date >= ? ORDER BY id`
+
+//CreateRemovalQuery is the statement for CreateRemoval().
+const CreateRemovalQuery = `INSERT INTO dish_removal ` +
+	`(household_id, user_id, storage_id, title, expire_date, removed_date, outcome) VALUES(?, ?, ?, ?, ?, ?, ?)`
+
+//GetWebhooksQuery is the Query for GetWebhooks(), bound with the user id.
+const GetWebhooksQuery = `SELECT ` + WebhookColumns + ` FROM webhook_subscription WHERE user_id = ? ORDER BY id`
+
+//GetWebhookByIDQuery is the Query for GetWebhookByID(), bound with the user id and the subscription id.
+const GetWebhookByIDQuery = `SELECT ` + WebhookColumns + ` FROM webhook_subscription WHERE user_id = ? AND id = ?`
+
+//GetWebhookByTempMatchQuery finds a webhook subscription that was just created, bound with the temp match string.
+const GetWebhookByTempMatchQuery = `SELECT ` + WebhookColumns + ` FROM webhook_subscription WHERE temp_match = ?`
+
+//GetHouseholdWebhooksQuery is the Query for GetHouseholdWebhooks(), bound with the household id.
+const GetHouseholdWebhooksQuery = `SELECT ` + WebhookColumns + ` FROM webhook_subscription WHERE active = TRUE AND ` +
+	`user_id IN (SELECT user_id FROM household_member WHERE household_id = ?) ORDER BY id`
+
+//CreateWebhookQuery is the statement for CreateWebhook().
+const CreateWebhookQuery = `INSERT INTO webhook_subscription (user_id, url, secret, events, active, created_date, temp_match) ` +
+	`VALUES(?, ?, ?, ?, ?, ?, ?)`
+
+//UpdateWebhookQuery is the statement for UpdateWebhook(), bound with the url, events and active flag, then the user id
+//and the subscription id. The secret never changes.
+const UpdateWebhookQuery = `UPDATE webhook_subscription SET url = ?, events = ?, active = ? WHERE user_id = ? AND id = ?`
+
+//DeleteWebhookQuery is the statement for DeleteWebhook(), bound with the user id and the subscription id.
+const DeleteWebhookQuery = `DELETE FROM webhook_subscription WHERE user_id = ? AND id = ?`
+
+//DeleteWebhookDeliveriesQuery is the statement for DeleteWebhook() that deletes the subscription's deliveries, bound
+//with the user id and the subscription id.
+const DeleteWebhookDeliveriesQuery = `DELETE FROM webhook_delivery WHERE user_id = ? AND subscription_id = ?`
+
+//DeleteUserWebhooksQuery is the statement for DeleteUserWebhooks(), bound with the user id.
+const DeleteUserWebhooksQuery = `DELETE FROM webhook_subscription WHERE user_id = ?`
+
+//DeleteUserDeliveriesQuery is the statement for DeleteUserWebhooks() that deletes the user's deliveries, bound with
+//the user id.
+const DeleteUserDeliveriesQuery = `DELETE FROM webhook_delivery WHERE user_id = ?`
+
+//GetDeliveriesQuery is the Query for GetDeliveries(), bound with the user id, the subscription id and MaxDeliveries.
+//It gives the latest deliveries.
+const GetDeliveriesQuery = `SELECT ` + DeliveryColumns + ` FROM webhook_delivery WHERE user_id = ? AND subscription_id = ? ` +
+	`ORDER BY id DESC LIMIT ?`
+
+//GetDeliveriesByStatusQuery is the Query for GetDeliveriesByStatus(), bound with the user id, the status and
+//MaxDeliveries. It gives the latest deliveries.
+const GetDeliveriesByStatusQuery = `SELECT ` + DeliveryColumns + ` FROM webhook_delivery WHERE user_id = ? AND status = ? ` +
+	`ORDER BY id DESC LIMIT ?`
+
+//MaxDeliveries is the most deliveries GetDeliveries() and GetDeliveriesByStatus() give.
+const MaxDeliveries = 100
+
+//GetDeliveryByIDQuery is the Query for GetDeliveryByID(), bound with the user id and the delivery id.
+const GetDeliveryByIDQuery = `SELECT ` + DeliveryColumns + ` FROM webhook_delivery WHERE user_id = ? AND id = ?`
+
+//GetDeliveryByTempMatchQuery finds a delivery that was just created, bound with the temp match string.
+const GetDeliveryByTempMatchQuery = `SELECT ` + DeliveryColumns + ` FROM webhook_delivery WHERE temp_match = ?`
+
+//GetDueDeliveriesQuery is the Query for GetDueDeliveries(), bound with webhook.Pending, the time now and
+//MaxDueDeliveries. It gives the deliveries that have waited longest.
+const GetDueDeliveriesQuery = `SELECT ` + DeliveryColumns + ` FROM webhook_delivery WHERE status = ? AND next_attempt <= ? ` +
+	`ORDER BY next_attempt, id LIMIT ?`
+
+//MaxDueDeliveries is the most deliveries GetDueDeliveries() gives.
+const MaxDueDeliveries = 50
+
+//CreateDeliveryQuery is the statement for CreateDelivery().
+const CreateDeliveryQuery = `INSERT INTO webhook_delivery (subscription_id, user_id, event_id, event_type, payload, status, ` +
+	`attempts, response_code, last_error, next_attempt, created_date, updated_date, temp_match) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+//UpdateDeliveryQuery is the statement for UpdateDelivery(), bound with the status, attempts, response code, last error,
+//next attempt and updated time, then the delivery id.
+const UpdateDeliveryQuery = `UPDATE webhook_delivery SET status = ?, attempts = ?, response_code = ?, last_error = ?, ` +
+	`next_attempt = ?, updated_date = ? WHERE id = ?`
+
+//DeleteDeliveriesBeforeQuery is the statement for DeleteDeliveriesBefore(), bound with webhook.Pending and the time
+//before which finished deliveries are forgotten.
+const DeleteDeliveriesBeforeQuery = `DELETE FROM webhook_delivery WHERE status <> ? AND updated_date < ?`
 
 //Repository interface is a contract for all the methods contained by this db.Repository object.
 type Repository interface {
-	GetDishes(int) (*dish.Dishes, fcerr.FCErr)
-	GetDishByID(int, int) (*dish.Dish, fcerr.FCErr)
-	GetDishByTempMatch(string) (*dish.Dish, fcerr.FCErr)
-	GetPersonalDishCount(int) (int, fcerr.FCErr)
-	CreateDish(dish.Dish) (*dish.Dish, fcerr.FCErr)
-	UpdateDish(dish.Dish) fcerr.FCErr
-	DeleteDish(int, int) fcerr.FCErr
-
-	//GetUsers() (*user.Users, fcerr.FCErr)
-	GetUserByID(int) (*user.User, fcerr.FCErr)
-	GetUserByEmail(string) (*user.User, fcerr.FCErr)
-	GetUserByAlexa(string) (*user.User, fcerr.FCErr)
-	GetUserByTempMatch(string) (*user.User, fcerr.FCErr)
-	CreateUser(user.User) (*user.User, fcerr.FCErr)
-	UpdateUser(user.User) (*user.User, fcerr.FCErr)
-	DeleteUser(int) fcerr.FCErr
-
-	GetStorages(int) (*storage.Storages, fcerr.FCErr)
-	GetStorageByID(int, int) (*storage.Storage, fcerr.FCErr)
-	GetStorageByTempMatch(string) (*storage.Storage, fcerr.FCErr)
-	GetPersonalStorageCount(int) (int, fcerr.FCErr)
-	CreateStorage(storage.Storage) (*storage.Storage, fcerr.FCErr)
-	UpdateStorage(storage.Storage) fcerr.FCErr
-	DeleteStorage(int, int) fcerr.FCErr
-
-	GetStorageDishes(int, int) (*dish.Dishes, fcerr.FCErr)
+	GetDishes(context.Context, int) (*dish.Dishes, fcerr.FCErr)
+	GetDishByID(context.Context, int, int) (*dish.Dish, fcerr.FCErr)
+	GetDishByTempMatch(context.Context, string) (*dish.Dish, fcerr.FCErr)
+	GetPersonalDishCount(context.Context, int) (int, fcerr.FCErr)
+	CreateDish(context.Context, dish.Dish) (*dish.Dish, fcerr.FCErr)
+	UpdateDish(context.Context, dish.Dish) fcerr.FCErr
+	DeleteDish(context.Context, int, int) fcerr.FCErr
+	GetDishExpiryCounts(context.Context) (*dish.ExpiryCounts, fcerr.FCErr)
+	GetDishesExpiringBetween(context.Context, time.Time, time.Time) (*dish.Dishes, fcerr.FCErr)
+	FindDishes(context.Context, int, dish.Query) (*dish.Dishes, fcerr.FCErr)
+
+	GetUsers(context.Context, string) (*user.Users, fcerr.FCErr)
+	GetUserByID(context.Context, int) (*user.User, fcerr.FCErr)
+	GetUserByEmail(context.Context, string) (*user.User, fcerr.FCErr)
+	GetUserByTempMatch(context.Context, string) (*user.User, fcerr.FCErr)
+	CreateUser(context.Context, user.User) (*user.User, fcerr.FCErr)
+	UpdateUser(context.Context, user.User) (*user.User, fcerr.FCErr)
+	SetUserDeactivated(context.Context, int, time.Time) fcerr.FCErr
+	SetUserDeletion(context.Context, int, time.Time) fcerr.FCErr
+	GetUsersPendingDeletion(context.Context) (*user.Users, fcerr.FCErr)
+	GetUsersToNotify(context.Context) (*user.Users, fcerr.FCErr)
+	DeleteUser(context.Context, int) fcerr.FCErr
+
+	GetStorages(context.Context, int) (*storage.Storages, fcerr.FCErr)
+	GetStorageByID(context.Context, int, int) (*storage.Storage, fcerr.FCErr)
+	GetStorageByTempMatch(context.Context, string) (*storage.Storage, fcerr.FCErr)
+	GetPersonalStorageCount(context.Context, int) (int, fcerr.FCErr)
+	CreateStorage(context.Context, storage.Storage) (*storage.Storage, fcerr.FCErr)
+	UpdateStorage(context.Context, storage.Storage) fcerr.FCErr
+	DeleteStorage(context.Context, int, int) fcerr.FCErr
+
+	GetStorageDishes(context.Context, int, int) (*dish.Dishes, fcerr.FCErr)
+
+	GetSessionByTokenHash(context.Context, string) (*session.Session, fcerr.FCErr)
+	CreateSession(context.Context, session.Session) (*session.Session, fcerr.FCErr)
+	RevokeSession(context.Context, string, time.Time) fcerr.FCErr
+	RevokeUserSessions(context.Context, int, time.Time) fcerr.FCErr
+	DeleteUserSessions(context.Context, int) fcerr.FCErr
+
+	GetIdentity(context.Context, string, string) (*identity.Identity, fcerr.FCErr)
+	GetIdentityByRefreshHash(context.Context, string) (*identity.Identity, fcerr.FCErr)
+	GetUserIdentities(context.Context, int) (*identity.Identities, fcerr.FCErr)
+	CreateIdentity(context.Context, identity.Identity) (*identity.Identity, fcerr.FCErr)
+	UpdateIdentity(context.Context, identity.Identity) fcerr.FCErr
+	DeleteIdentity(context.Context, int, int) fcerr.FCErr
+
+	GetHousehold(context.Context, int) (*household.Household, fcerr.FCErr)
+	CreateHousehold(context.Context, household.Household) (*household.Household, fcerr.FCErr)
+	UpdateHousehold(context.Context, household.Household) fcerr.FCErr
+	DeleteHousehold(context.Context, int) fcerr.FCErr
+	DeleteHouseholdFood(context.Context, int) fcerr.FCErr
+	GetMembership(context.Context, int) (*household.Member, fcerr.FCErr)
+	GetHouseholdMembers(context.Context, int) (*household.Members, fcerr.FCErr)
+	CreateMembership(context.Context, household.Member) fcerr.FCErr
+	UpdateMembership(context.Context, household.Member) fcerr.FCErr
+	DeleteMembership(context.Context, int, int) fcerr.FCErr
+	GetInvite(context.Context, int) (*household.Invite, fcerr.FCErr)
+	GetInvitesByEmail(context.Context, string) (*household.Invites, fcerr.FCErr)
+	GetHouseholdInvites(context.Context, int) (*household.Invites, fcerr.FCErr)
+	CreateInvite(context.Context, household.Invite) (*household.Invite, fcerr.FCErr)
+	DeleteInvite(context.Context, int) fcerr.FCErr
+
+	GetNotifications(context.Context, int) (*notification.Notifications, fcerr.FCErr)
+	CreateNotification(context.Context, notification.Notification) fcerr.FCErr
+	DeleteNotificationsBefore(context.Context, time.Time) fcerr.FCErr
+	DeleteUserNotifications(context.Context, int) fcerr.FCErr
+
+	GetRemovals(context.Context, int, time.Time) (*dish.Removals, fcerr.FCErr)
+	CreateRemoval(context.Context, dish.Removal) fcerr.FCErr
+
+	GetWebhooks(context.Context, int) (*webhook.Subscriptions, fcerr.FCErr)
+	GetWebhookByID(context.Context, int, int) (*webhook.Subscription, fcerr.FCErr)
+	GetHouseholdWebhooks(context.Context, int) (*webhook.Subscriptions, fcerr.FCErr)
+	CreateWebhook(context.Context, webhook.Subscription) (*webhook.Subscription, fcerr.FCErr)
+	UpdateWebhook(context.Context, webhook.Subscription) fcerr.FCErr
+	DeleteWebhook(context.Context, int, int) fcerr.FCErr
+	DeleteUserWebhooks(context.Context, int) fcerr.FCErr
+
+	GetDeliveries(context.Context, int, int) (*webhook.Deliveries, fcerr.FCErr)
+	GetDeliveriesByStatus(context.Context, int, string) (*webhook.Deliveries, fcerr.FCErr)
+	GetDeliveryByID(context.Context, int, int) (*webhook.Delivery, fcerr.FCErr)
+	GetDueDeliveries(context.Context, time.Time) (*webhook.Deliveries, fcerr.FCErr)
+	CreateDelivery(context.Context, webhook.Delivery) (*webhook.Delivery, fcerr.FCErr)
+	UpdateDelivery(context.Context, webhook.Delivery) fcerr.FCErr
+	DeleteDeliveriesBefore(context.Context, time.Time) fcerr.FCErr
+
+	WithTx(context.Context, func(Repository) fcerr.FCErr) fcerr.FCErr
+}
+
+//querier is what the repository runs its statements on - either the *sql.DB or the *sql.Tx of a WithTx call.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 }
 
 type repository struct {
-	db *sql.DB
+	db     querier
+	tx     *sql.Tx
+	driver string
 }
 
+//MySQLDriver is the database/sql driver name for the production mysql database.
+const MySQLDriver = "mysql"
+
+//SQLiteDriver is the database/sql driver name registered by the pure-Go modernc.org/sqlite package.
+const SQLiteDriver = "sqlite"
+
+//MemoryDriver selects NewMemoryRepository, which keeps nothing once the process exits.
+const MemoryDriver = "memory"
+
 //NewRepository will get an instance of this type which satisfies the Repository interface.
+//Any pending schema migrations are applied before the repository is returned.
 func NewRepository(config string) (Repository, fcerr.FCErr) {
-	db, err := sql.Open("mysql", strings.TrimSpace(config))
+	return NewRepositoryWithDriver(MySQLDriver, config)
+}
+
+//NewRepositoryWithDriver picks the backing store by driver name ("mysql" when empty, "sqlite" or "memory"),
+//opens it with config and applies any pending schema migrations.
+func NewRepositoryWithDriver(driver string, config string) (Repository, fcerr.FCErr) {
+	if driver == "" {
+		driver = MySQLDriver
+	}
+	if driver == MemoryDriver {
+		return NewMemoryRepository(), nil
+	}
+
+	db, fcErr := OpenDatabase(driver, config)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+
+	fcErr = Migrate(db, driver)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+
+	resultDB := repository{db: db, driver: driver}
+	return &resultDB, nil
+}
+
+//OpenDatabase connects to the database described by config and checks the connection.
+//For sqlite the config is the database file path (or any modernc.org/sqlite DSN).
+func OpenDatabase(driver string, config string) (*sql.DB, fcerr.FCErr) {
+	if driver != MySQLDriver && driver != SQLiteDriver {
+		return nil, fcerr.NewInternalServerError("Unknown database driver: " + driver)
+	}
+
+	db, err := sql.Open(driver, strings.TrimSpace(config))
 	if err != nil {
-		fcerr := fcerr.NewInternalServerError("Error while connecting to the mysql database")
+		fcerr := fcerr.NewInternalServerError("Error while connecting to the " + driver + " database")
 		return nil, fcerr
 	}
 
 	//trying without the db.Close()
 	//defer db.Close()
 
+	if driver == SQLiteDriver {
+		//sqlite allows one writer at a time, and every connection to ":memory:" would be a different database
+		db.SetMaxOpenConns(1)
+	}
+
 	//Check the connection to the database - If the credentials are wrong this will err out
 	err = db.Ping()
 	if err != nil {
-		fcerr := fcerr.NewInternalServerError("Error while pinging the mysql database")
+		fcerr := fcerr.NewInternalServerError("Error while pinging the " + driver + " database")
 		return nil, fcerr
 	}
 
-	resultDB := repository{db}
-	return &resultDB, nil
+	return db, nil
 }
 
 //NewRepositoryWithDB will get an instance of this type which satisfies the Repository interface.
 func NewRepositoryWithDB(db *sql.DB) (Repository, fcerr.FCErr) {
-	resultDB := repository{db}
+	resultDB := repository{db: db, driver: MySQLDriver}
 	return &resultDB, nil
 }
 
-//GetDishes(userID int) returns a *[]dish - all dishes the user has
-func (repo *repository) GetDishes(userID int) (*dish.Dishes, fcerr.FCErr) {
+//WithTx runs fn with a Repository bound to one database transaction - committed when fn returns nil, rolled back otherwise.
+//Calling WithTx on the Repository that fn was given joins the transaction that is already open.
+func (repo *repository) WithTx(ctx context.Context, fn func(Repository) fcerr.FCErr) (resultErr fcerr.FCErr) {
+	if repo.tx != nil {
+		return fn(repo)
+	}
+
+	sqlDB, ok := repo.db.(*sql.DB)
+	if !ok {
+		return fcerr.NewInternalServerError("Error while starting a database transaction")
+	}
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		fmt.Println("got an error starting the transaction:", err.Error())
+		return dbError(ctx, "Error while starting a database transaction")
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	txRepo := &repository{db: tx, tx: tx, driver: repo.driver}
+	fcErr := fn(txRepo)
+	if fcErr != nil {
+		tx.Rollback()
+		return fcErr
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		fmt.Println("got an error committing the transaction:", err.Error())
+		return dbError(ctx, "Error while committing the database transaction")
+	}
+	return nil
+}
+
+//dbError is the error for a failed statement. When ctx was cancelled or hit its deadline the statement never
+//really failed, so the caller gets a 504 instead of the 500 with the given message.
+func dbError(ctx context.Context, message string) fcerr.FCErr {
+	if ctx.Err() != nil {
+		return fcerr.Wrap(ctx.Err(), "The request was cancelled or ran out of time before the database answered", http.StatusGatewayTimeout)
+	}
+	return fcerr.NewInternalServerError(message)
+}
+
+//dbTime scans a dish or session date column into a time.Time. The dish dates are DATETIME columns, which arrive as a
+//time.Time or as text depending on the driver's parseTime setting, and the others are VARCHAR text in dish.StorageFormat.
+//NULL, "" and a value that can't be read are left as the zero time, which is treated as having no valid date, rather
+//than failing the whole query.
+type dbTime struct {
+	t *time.Time
+}
+
+func (d dbTime) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case time.Time:
+		*d.t = dish.CanonicalTime(v)
+	case []byte:
+		d.parse(string(v))
+	case string:
+		d.parse(v)
+	default:
+		*d.t = time.Time{}
+	}
+	return nil
+}
+
+func (d dbTime) parse(value string) {
+	*d.t = time.Time{}
+	if value == "" {
+		return
+	}
+	t, err := time.Parse(dish.StorageFormat, value)
+	if err != nil {
+		fmt.Println("could not read a date from the database:", err.Error())
+		return
+	}
+	*d.t = t
+}
+
+//storedTime gives the text a time is written to a VARCHAR column as - UTC in dish.StorageFormat, or "" for no time at all.
+func storedTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(dish.StorageFormat)
+}
+
+//storedDate gives what a dish date is written to its DATETIME column as - UTC in dish.StorageFormat, or NULL for no
+//date at all.
+func storedDate(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return storedTime(t)
+}
+
+//lockSuffix gives ForUpdate when inside a transaction on mysql. sqlite has no row locks, but its
+//single connection already keeps a transaction to itself.
+func (repo *repository) lockSuffix() string {
+	if repo.tx == nil || repo.driver == SQLiteDriver {
+		return ""
+	}
+	return ForUpdate
+}
+
+//GetDishes(householdID int) returns a *[]dish - all dishes the household has
+func (repo *repository) GetDishes(ctx context.Context, householdID int) (*dish.Dishes, fcerr.FCErr) {
 	fmt.Println("now at the beginning of the db_repository GetDishes()")
 	var resultDishes dish.Dishes
-	getDishesQuery := fmt.Sprintf(GetDishesBase, userID)
-	rows, err := repo.db.Query(getDishesQuery)
-	fmt.Println("now after doing the Query:", getDishesQuery)
+	rows, err := repo.db.QueryContext(ctx, GetDishesQuery, householdID)
+	fmt.Println("now after doing the Query:", GetDishesQuery)
 	if err != nil {
 		fmt.Println("got an error on the Query:", err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while retrieving dishes from the database")
+		fcerr := dbError(ctx, "Error while retrieving dishes from the database")
 		return nil, fcerr
 	}
 	defer rows.Close()
@@ -174,13 +753,13 @@ func (repo *repository) GetDishes(userID int) (*dish.Dishes, fcerr.FCErr) {
 		var currentDish dish.Dish
 		fmt.Println("Inside the result set loop. currentDish:", currentDish)
 		err := rows.Scan(&currentDish.DishID, &currentDish.PersonalDishID, &currentDish.UserID, &currentDish.StorageID, &currentDish.Title,
-			&currentDish.Description, &currentDish.CreatedDate, &currentDish.ExpireDate, &currentDish.Priority,
-			&currentDish.DishType, &currentDish.Portions, &currentDish.TempMatch)
+			&currentDish.Description, dbTime{&currentDish.CreatedDate}, dbTime{&currentDish.ExpireDate}, &currentDish.Priority,
+			&currentDish.DishType, &currentDish.Portions, &currentDish.TempMatch, &currentDish.HouseholdID)
 		if err != nil {
 			fmt.Println("got an error from the rows.Scan.")
 			fmt.Println("&currentDish.DishID:", currentDish.DishID)
 			fmt.Println("&currentDish.TempMatch:", currentDish.TempMatch)
-			fcerr := fcerr.NewInternalServerError("Error while scanning the result from the database")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
 			return nil, fcerr
 		}
 		fmt.Println("now after the current dish scanned. currentDish:", currentDish)
@@ -196,17 +775,16 @@ func (repo *repository) GetDishes(userID int) (*dish.Dishes, fcerr.FCErr) {
 	return &resultDishes, nil
 }
 
-//GetDishByID (userID int, pID int) queries the mysql database for a dish the requesting user has with the given personal id.
-func (repo *repository) GetDishByID(userID int, pID int) (*dish.Dish, fcerr.FCErr) {
+//GetDishByID (householdID int, pID int) queries the mysql database for a dish the household has with the given personal id.
+func (repo *repository) GetDishByID(ctx context.Context, householdID int, pID int) (*dish.Dish, fcerr.FCErr) {
 	var resultingDish dish.Dish
-	getDishByIDQuery := fmt.Sprintf(GetDishByIDBase, userID, pID)
-	fmt.Println("about to run this query in GetDishByID:", getDishByIDQuery)
+	fmt.Println("about to run this query in GetDishByID:", GetDishByIDQuery)
 
-	rows, err := repo.db.Query(getDishByIDQuery)
-	fmt.Println("now after doing the Query:", getDishByIDQuery)
+	rows, err := repo.db.QueryContext(ctx, GetDishByIDQuery, householdID, pID)
+	fmt.Println("now after doing the Query:", GetDishByIDQuery)
 	if err != nil {
 		fmt.Println("got an error on the Query:", err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while retrieving dish from the database")
+		fcerr := dbError(ctx, "Error while retrieving dish from the database")
 		return nil, fcerr
 	}
 	defer rows.Close()
@@ -222,13 +800,13 @@ func (repo *repository) GetDishByID(userID int, pID int) (*dish.Dish, fcerr.FCEr
 		var currentDish dish.Dish
 		fmt.Println("Inside the result set loop. currentDish:", currentDish)
 		err := rows.Scan(&currentDish.DishID, &currentDish.PersonalDishID, &currentDish.UserID, &currentDish.StorageID, &currentDish.Title,
-			&currentDish.Description, &currentDish.CreatedDate, &currentDish.ExpireDate, &currentDish.Priority,
-			&currentDish.DishType, &currentDish.Portions, &currentDish.TempMatch)
+			&currentDish.Description, dbTime{&currentDish.CreatedDate}, dbTime{&currentDish.ExpireDate}, &currentDish.Priority,
+			&currentDish.DishType, &currentDish.Portions, &currentDish.TempMatch, &currentDish.HouseholdID)
 		if err != nil {
 			fmt.Println("got an error from the rows.Scan.")
 			fmt.Println("&currentDish.DishID:", currentDish.DishID)
 			fmt.Println("&currentDish.TempMatch:", currentDish.TempMatch)
-			fcerr := fcerr.NewInternalServerError("Error while scanning the result from the database")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
 			return nil, fcerr
 		}
 		fmt.Println("now after the current dish scanned. currentDish:", currentDish)
@@ -244,16 +822,15 @@ func (repo *repository) GetDishByID(userID int, pID int) (*dish.Dish, fcerr.FCEr
 }
 
 //GetDishByTempMatch(tm string) takes a string and queries the mysql database for a dish with this temp_match.
-func (repo *repository) GetDishByTempMatch(tm string) (*dish.Dish, fcerr.FCErr) {
+func (repo *repository) GetDishByTempMatch(ctx context.Context, tm string) (*dish.Dish, fcerr.FCErr) {
 	var resultingDish dish.Dish
-	getDishByTempMatchQuery := fmt.Sprintf(GetDishByTempMatchBase, tm)
-	fmt.Println("about to run this query in GetDishByTempMatch:", getDishByTempMatchQuery)
+	fmt.Println("about to run this query in GetDishByTempMatch:", GetDishByTempMatchQuery)
 
-	rows, err := repo.db.Query(getDishByTempMatchQuery)
-	fmt.Println("now after doing the Query:", getDishByTempMatchQuery)
+	rows, err := repo.db.QueryContext(ctx, GetDishByTempMatchQuery, tm)
+	fmt.Println("now after doing the Query:", GetDishByTempMatchQuery)
 	if err != nil {
 		fmt.Println("got an error on the Query:", err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while retrieving dish from the database")
+		fcerr := dbError(ctx, "Error while retrieving dish from the database")
 		return nil, fcerr
 	}
 	defer rows.Close()
@@ -269,13 +846,13 @@ func (repo *repository) GetDishByTempMatch(tm string) (*dish.Dish, fcerr.FCErr)
 		var currentDish dish.Dish
 		fmt.Println("Inside the result set loop. currentDish:", currentDish)
 		err := rows.Scan(&currentDish.DishID, &currentDish.PersonalDishID, &currentDish.UserID, &currentDish.StorageID, &currentDish.Title,
-			&currentDish.Description, &currentDish.CreatedDate, &currentDish.ExpireDate, &currentDish.Priority,
-			&currentDish.DishType, &currentDish.Portions, &currentDish.TempMatch)
+			&currentDish.Description, dbTime{&currentDish.CreatedDate}, dbTime{&currentDish.ExpireDate}, &currentDish.Priority,
+			&currentDish.DishType, &currentDish.Portions, &currentDish.TempMatch, &currentDish.HouseholdID)
 		if err != nil {
 			fmt.Println("got an error from the rows.Scan.")
 			fmt.Println("&currentDish.DishID:", currentDish.DishID)
 			fmt.Println("&currentDish.TempMatch:", currentDish.TempMatch)
-			fcerr := fcerr.NewInternalServerError("Error while scanning the result from the database")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
 			return nil, fcerr
 		}
 		fmt.Println("now after the current dish scanned. currentDish:", currentDish)
@@ -290,24 +867,36 @@ func (repo *repository) GetDishByTempMatch(tm string) (*dish.Dish, fcerr.FCErr)
 }
 
 //CreateDish(d dish.Dish) takes a dish object and tries to add it to the database
-func (repo *repository) CreateDish(d dish.Dish) (*dish.Dish, fcerr.FCErr) {
-	tMatch := generateTempMatch()
-	createDishQuery := fmt.Sprintf(CreateDishBase, d.PersonalDishID, d.UserID, d.StorageID, d.Title, d.Description,
-		d.CreatedDate, d.ExpireDate, d.Priority, d.DishType, d.Portions, tMatch)
+func (repo *repository) CreateDish(ctx context.Context, d dish.Dish) (*dish.Dish, fcerr.FCErr) {
+	var resultDish *dish.Dish
+	fcErr := repo.WithTx(ctx, func(txRepo Repository) fcerr.FCErr {
+		var err fcerr.FCErr
+		resultDish, err = txRepo.(*repository).createDish(ctx, d)
+		return err
+	})
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return resultDish, nil
+}
 
-	fmt.Println("About to run this Query on the database:\n", createDishQuery)
+//createDish inserts the dish and reads it back by its temp match, inside CreateDish's transaction.
+func (repo *repository) createDish(ctx context.Context, d dish.Dish) (*dish.Dish, fcerr.FCErr) {
+	tMatch := generateTempMatch()
+	fmt.Println("About to run this Query on the database:\n", CreateDishQuery)
 
-	_, err := repo.db.Query(createDishQuery)
+	_, err := repo.db.ExecContext(ctx, CreateDishQuery, d.PersonalDishID, d.UserID, d.StorageID, d.Title, d.Description,
+		storedDate(d.CreatedDate), storedDate(d.ExpireDate), d.Priority, d.DishType, d.Portions, tMatch, d.HouseholdID)
 	if err != nil {
 		fmt.Println("got an error on the Query:" + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while inserting the dish into the database")
+		fcerr := dbError(ctx, "Error while inserting the dish into the database")
 		return nil, fcerr
 	}
 
-	checkDish, err := repo.GetDishByTempMatch(tMatch)
+	checkDish, err := repo.GetDishByTempMatch(ctx, tMatch)
 	if err != nil {
 		fmt.Println("Trying to CreateDish, seem to have hit a snag. Got an error when checking what we just put in: " + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while checking the dish that was created." +
+		fcerr := dbError(ctx, "Error while checking the dish that was created."+
 			" Cannot verify if anything was entered to the Database")
 		return nil, fcerr
 	}
@@ -316,86 +905,77 @@ func (repo *repository) CreateDish(d dish.Dish) (*dish.Dish, fcerr.FCErr) {
 }
 
 //UpdateDish(d dish.Dish) takes a dish object and tries to update the existing dish in the database to match
-func (repo *repository) UpdateDish(d dish.Dish) fcerr.FCErr {
-	updateDishQuery := fmt.Sprintf(UpdateDishBase, d.PersonalDishID, d.StorageID, d.Title, d.Description,
-		d.ExpireDate, d.Priority, d.DishType, d.Portions, d.DishID)
-
-	fmt.Println("About to run this Query on the database:\n", updateDishQuery)
+func (repo *repository) UpdateDish(ctx context.Context, d dish.Dish) fcerr.FCErr {
+	fmt.Println("About to run this Query on the database:\n", UpdateDishQuery)
 
-	_, err := repo.db.Query(updateDishQuery)
+	_, err := repo.db.ExecContext(ctx, UpdateDishQuery, d.PersonalDishID, d.StorageID, d.Title, d.Description,
+		storedDate(d.ExpireDate), d.Priority, d.DishType, d.Portions, d.DishID)
 	if err != nil {
 		fmt.Println("got an error on the query:" + err.Error())
-		return fcerr.NewInternalServerError("Error while updating the dish in the database")
+		return dbError(ctx, "Error while updating the dish in the database")
 	}
 
-	_, err2 := repo.GetDishByID(d.UserID, d.PersonalDishID)
+	_, err2 := repo.GetDishByID(ctx, d.HouseholdID, d.PersonalDishID)
 	if err2 != nil {
 		fmt.Println("got an error on the check query:")
-		return fcerr.NewInternalServerError("Error while checking the dish that was created. Cannot verify if anything was updated in the Database")
+		return dbError(ctx, "Error while checking the dish that was created. Cannot verify if anything was updated in the Database")
 	}
 
 	return nil
 }
 
-//GetPersonalDishCount(userID int) gets the number of dishes the given user has in the database
-func (repo *repository) GetPersonalDishCount(userID int) (int, fcerr.FCErr) {
-	getPersonalDishCountQuery := fmt.Sprintf(GetPersonalDishCountBase, userID)
-	personalDishCountRow := repo.db.QueryRow(getPersonalDishCountQuery)
+//GetPersonalDishCount(householdID int) gets the number of dishes the given household has in the database
+func (repo *repository) GetPersonalDishCount(ctx context.Context, householdID int) (int, fcerr.FCErr) {
+	personalDishCountRow := repo.db.QueryRowContext(ctx, GetPersonalDishCountQuery+repo.lockSuffix(), householdID)
 	var personalDishCount int
 	err := personalDishCountRow.Scan(&personalDishCount)
 	if err != nil {
 		fmt.Println("got an error on the get personal count process:" + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while checking on how many dishes the user has.")
+		fcerr := dbError(ctx, "Error while checking on how many dishes the user has.")
 		return 0, fcerr
 	}
 	return personalDishCount, nil
 
 }
 
-//DeleteDish(userID int, pID int) takes a requesting user and a personal dish id and tries to delete the dish
-func (repo *repository) DeleteDish(userID int, pID int) fcerr.FCErr {
-	personalDishCount, err := repo.GetPersonalDishCount(userID)
+//DeleteDish(householdID int, pID int) takes a household and a personal dish id and tries to delete the dish.
+//The count, delete and renumbering of the household's later dishes happen in one transaction.
+func (repo *repository) DeleteDish(ctx context.Context, householdID int, pID int) fcerr.FCErr {
+	return repo.WithTx(ctx, func(txRepo Repository) fcerr.FCErr {
+		return txRepo.(*repository).deleteDish(ctx, householdID, pID)
+	})
+}
+
+func (repo *repository) deleteDish(ctx context.Context, householdID int, pID int) fcerr.FCErr {
+	personalDishCount, err := repo.GetPersonalDishCount(ctx, householdID)
 	if err != nil {
-		return fcerr.NewInternalServerError("Error when Deleting the dish")
+		return dbError(ctx, "Error when Deleting the dish")
 	}
 
-	if pID > personalDishCount {
+	if pID < 1 || pID > personalDishCount {
 		return fcerr.NewBadRequestError("Could not delete a dish that doesn't exist")
 	}
 
-	deleteDishQuery := fmt.Sprintf(DeleteDishBase, userID, pID)
-
-	_, err2 := repo.db.Query(deleteDishQuery)
+	_, err2 := repo.db.ExecContext(ctx, DeleteDishQuery, householdID, pID)
 	if err2 != nil {
 		fmt.Println("got an error on the delete query:" + err2.Error())
-		return fcerr.NewInternalServerError("Error while deleting the dish from the database")
+		return dbError(ctx, "Error while deleting the dish from the database")
 	}
 
-	returnedDish, err3 := repo.GetDishByID(userID, pID)
+	returnedDish, err3 := repo.GetDishByID(ctx, householdID, pID)
 	if err3 == nil {
 		fmt.Println("Expected an error here, but didn't get one!! Dish Title:" + returnedDish.Title)
-		fcerr := fcerr.NewInternalServerError("Error while deleting the dish from the database, could not verify it was deleted.")
+		fcerr := dbError(ctx, "Error while deleting the dish from the database, could not verify it was deleted.")
 		return fcerr
 	}
 
 	if pID != personalDishCount {
 		//Dish was in the middle of the list somewhere - shift the second half of the list up
-		pDSstr := ""
-		for i := pID + 1; i <= personalDishCount; i++ {
-			if i == personalDishCount {
-				pDSstr = pDSstr + strconv.Itoa(i)
-			} else {
-				pDSstr = pDSstr + strconv.Itoa(i) + ","
-			}
-
-		}
-
-		decrementSomeDishesQuery := fmt.Sprintf(DecrementSomeDishesBase, userID, pDSstr)
-		fmt.Println("about to run this query on the db:", decrementSomeDishesQuery)
-		_, err3 := repo.db.Query(decrementSomeDishesQuery)
+		fmt.Println("about to run this query on the db:", DecrementSomeDishesQuery)
+		_, err3 := repo.db.ExecContext(ctx, DecrementSomeDishesQuery, householdID, pID)
 		if err3 != nil {
 			fmt.Println("got an error while trying to decrement some dishes:" + err3.Error())
-			fcerr := fcerr.NewInternalServerError("Error while cleaning up the remaining dishes - however it appears the dish was successfully deleted")
+			fcerr := dbError(ctx, "Error while cleaning up the remaining dishes - however it appears the dish was successfully deleted")
 			return fcerr
 		}
 	}
@@ -403,57 +983,137 @@ func (repo *repository) DeleteDish(userID int, pID int) fcerr.FCErr {
 	return nil
 }
 
-//GetUserByID(id int) gets a user from the database with the given ID.
-func (repo *repository) GetUserByID(id int) (*user.User, fcerr.FCErr) {
-	getUserByIDQuery := fmt.Sprintf(GetUserByIDBase, id)
-	fmt.Println("About to run this Query on the database:\n", getUserByIDQuery)
-	var resultingUser user.User
+//GetDishExpiryCounts() counts the dishes of every household by when they expire, for statistics across the whole system.
+func (repo *repository) GetDishExpiryCounts(ctx context.Context) (*dish.ExpiryCounts, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetDishExpiryCountsQuery)
+	rows, err := repo.db.QueryContext(ctx, GetDishExpiryCountsQuery)
+	if err != nil {
+		fmt.Println("got an error on the Query:", err.Error())
+		fcerr := dbError(ctx, "Error while counting dishes in the database")
+		return nil, fcerr
+	}
+	defer rows.Close()
+
+	counts := dish.ExpiryCounts{}
+	for rows.Next() {
+		var cCount dish.ExpiryCount
+		if err := rows.Scan(&cCount.HouseholdID, dbTime{&cCount.ExpireDate}, &cCount.Dishes); err != nil {
+			fmt.Println("got an error from the rows.Scan.")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
+			return nil, fcerr
+		}
+		counts = append(counts, cCount)
+	}
+	return &counts, nil
+}
+
+//GetDishesExpiringBetween(after time.Time, until time.Time) gives the dishes of every household that expire after the
+//first time and by the second, soonest first. It gives an empty list if there are none.
+func (repo *repository) GetDishesExpiringBetween(ctx context.Context, after time.Time, until time.Time) (*dish.Dishes, fcerr.FCErr) {
+	return repo.queryDishList(ctx, GetDishesExpiringBetweenQuery, storedTime(after), storedTime(until))
+}
 
-	rows, err := repo.db.Query(getUserByIDQuery)
+//FindDishes(householdID int, q dish.Query) gives the household's dishes that q narrows them down to, sorted and paged
+//the way it says. It gives an empty list, not a 404, if there are none.
+func (repo *repository) FindDishes(ctx context.Context, householdID int, q dish.Query) (*dish.Dishes, fcerr.FCErr) {
+	query, args := findDishesQuery(householdID, q)
+	return repo.queryDishList(ctx, query, args...)
+}
+
+//queryDishList runs a dish query, giving an empty list if it finds nothing.
+func (repo *repository) queryDishList(ctx context.Context, query string, args ...interface{}) (*dish.Dishes, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", query)
+	rows, err := repo.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		fmt.Println("got an error on the Query")
-		fcerr := fcerr.NewInternalServerError("Error while retrieving user from the database")
+		fmt.Println("got an error on the Query:", err.Error())
+		fcerr := dbError(ctx, "Error while retrieving dishes from the database")
 		return nil, fcerr
 	}
 	defer rows.Close()
-	fmt.Println("now about to check the rows returned:")
-	count := 0
+
+	dishes := dish.Dishes{}
 	for rows.Next() {
-		count++
-		if count > 1 {
-			dberr := fcerr.NewInternalServerError("Database returned more than 1 row when only 1 was expected")
-			return nil, dberr
+		var currentDish dish.Dish
+		err := rows.Scan(&currentDish.DishID, &currentDish.PersonalDishID, &currentDish.UserID, &currentDish.StorageID, &currentDish.Title,
+			&currentDish.Description, dbTime{&currentDish.CreatedDate}, dbTime{&currentDish.ExpireDate}, &currentDish.Priority,
+			&currentDish.DishType, &currentDish.Portions, &currentDish.TempMatch, &currentDish.HouseholdID)
+		if err != nil {
+			fmt.Println("got an error from the rows.Scan.")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
+			return nil, fcerr
 		}
+		dishes = append(dishes, currentDish)
+	}
+	return &dishes, nil
+}
+
+//GetUsers(search string) gets every user, or with a search only those whose email or full name contains it, by id.
+//It gives a 404 if there are none.
+func (repo *repository) GetUsers(ctx context.Context, search string) (*user.Users, fcerr.FCErr) {
+	if search == "" {
+		return repo.queryUsers(ctx, GetUsersQuery)
+	}
+	pattern := "%" + likeEscaper.Replace(search) + "%"
+	return repo.queryUsers(ctx, SearchUsersQuery, pattern, pattern)
+}
+
+//GetUsersPendingDeletion gets every user who has asked for their account to be erased after a grace period, by id.
+//It gives a 404 if there are none.
+func (repo *repository) GetUsersPendingDeletion(ctx context.Context) (*user.Users, fcerr.FCErr) {
+	return repo.queryUsers(ctx, GetUsersPendingDeletionQuery)
+}
+
+//GetUsersToNotify gives every active user who asked to be told about food expiring, by id.
+//It gives a 404 if there are none.
+func (repo *repository) GetUsersToNotify(ctx context.Context) (*user.Users, fcerr.FCErr) {
+	return repo.queryUsers(ctx, GetUsersToNotifyQuery)
+}
+
+//queryUsers runs a query for users, giving a 404 if it finds none.
+func (repo *repository) queryUsers(ctx context.Context, query string, args ...interface{}) (*user.Users, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", query)
+
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		fmt.Println("got an error on the Query")
+		fcerr := dbError(ctx, "Error while retrieving users from the database")
+		return nil, fcerr
+	}
+	defer rows.Close()
+	var resultingUsers user.Users
+	for rows.Next() {
 		var cUser user.User
-		fmt.Println("Inside the result set loop. currentUser:", cUser)
 		err := rows.Scan(&cUser.UserID, &cUser.Email, &cUser.FirstName, &cUser.LastName, &cUser.FullName,
-			&cUser.CreatedDate, &cUser.AccessToken, &cUser.RefreshToken, &cUser.AlexaUserID, &cUser.Admin, &cUser.TempMatch)
+			&cUser.CreatedDate, &cUser.AccessToken, &cUser.RefreshToken, &cUser.Admin, &cUser.TempMatch,
+			dbTime{&cUser.TokenExpiry}, &cUser.TokenProvider, dbTime{&cUser.DeactivatedDate},
+			&cUser.TimeZone, &cUser.NotifyExpiring, &cUser.NotifyDaysBefore, dbTime{&cUser.DeletionDate},
+			&cUser.QuietStart, &cUser.QuietEnd)
 		if err != nil {
 			fmt.Println("got an error from the rows.Scan.")
-			fcerr := fcerr.NewInternalServerError("Error while scanning the result from the database")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
 			return nil, fcerr
 		}
-		fmt.Println("now after the current user scanned. currentUser:", cUser)
-		resultingUser = cUser
-
+		resultingUsers = append(resultingUsers, cUser)
 	}
-	if count == 0 {
-		fcerr := fcerr.NewNotFoundError("Database could not find a user with this ID")
+	if len(resultingUsers) == 0 {
+		fcerr := fcerr.NewNotFoundError("Database could not find any users")
 		return nil, fcerr
 	}
-	return &resultingUser, nil
+	return &resultingUsers, nil
 }
 
-//GetUserByEmail(email string) gets a user from the database with the given Email.
-func (repo *repository) GetUserByEmail(email string) (*user.User, fcerr.FCErr) {
-	getUserByEmailQuery := fmt.Sprintf(GetUserByEmailBase, email)
-	fmt.Println("About to run this Query on the database:\n", getUserByEmailQuery)
+//likeEscaper escapes a search for a LIKE pattern with ESCAPE '!', so its % and _ are matched as they are.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+//GetUserByID(id int) gets a user from the database with the given ID.
+func (repo *repository) GetUserByID(ctx context.Context, id int) (*user.User, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetUserByIDQuery)
 	var resultingUser user.User
 
-	rows, err := repo.db.Query(getUserByEmailQuery)
+	rows, err := repo.db.QueryContext(ctx, GetUserByIDQuery, id)
 	if err != nil {
 		fmt.Println("got an error on the Query")
-		fcerr := fcerr.NewInternalServerError("Error while retrieving user from the database")
+		fcerr := dbError(ctx, "Error while retrieving user from the database")
 		return nil, fcerr
 	}
 	defer rows.Close()
@@ -468,10 +1128,13 @@ func (repo *repository) GetUserByEmail(email string) (*user.User, fcerr.FCErr) {
 		var cUser user.User
 		fmt.Println("Inside the result set loop. currentUser:", cUser)
 		err := rows.Scan(&cUser.UserID, &cUser.Email, &cUser.FirstName, &cUser.LastName, &cUser.FullName,
-			&cUser.CreatedDate, &cUser.AccessToken, &cUser.RefreshToken, &cUser.AlexaUserID, &cUser.Admin, &cUser.TempMatch)
+			&cUser.CreatedDate, &cUser.AccessToken, &cUser.RefreshToken, &cUser.Admin, &cUser.TempMatch,
+			dbTime{&cUser.TokenExpiry}, &cUser.TokenProvider, dbTime{&cUser.DeactivatedDate},
+			&cUser.TimeZone, &cUser.NotifyExpiring, &cUser.NotifyDaysBefore, dbTime{&cUser.DeletionDate},
+			&cUser.QuietStart, &cUser.QuietEnd)
 		if err != nil {
 			fmt.Println("got an error from the rows.Scan.")
-			fcerr := fcerr.NewInternalServerError("Error while scanning the result from the database")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
 			return nil, fcerr
 		}
 		fmt.Println("now after the current user scanned. currentUser:", cUser)
@@ -479,22 +1142,21 @@ func (repo *repository) GetUserByEmail(email string) (*user.User, fcerr.FCErr) {
 
 	}
 	if count == 0 {
-		fcerr := fcerr.NewNotFoundError("Database could not find a user with this Email")
+		fcerr := fcerr.NewNotFoundError("Database could not find a user with this ID")
 		return nil, fcerr
 	}
 	return &resultingUser, nil
 }
 
-//GetUserByAlexa(aID string) gets a user from the database with the given alexa_user_id.
-func (repo *repository) GetUserByAlexa(aID string) (*user.User, fcerr.FCErr) {
-	getUserByAlexaQuery := fmt.Sprintf(GetUserByAlexaBase, aID)
-	fmt.Println("About to run this Query on the database:\n", getUserByAlexaQuery)
+//GetUserByEmail(email string) gets a user from the database with the given Email.
+func (repo *repository) GetUserByEmail(ctx context.Context, email string) (*user.User, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetUserByEmailQuery)
 	var resultingUser user.User
 
-	rows, err := repo.db.Query(getUserByAlexaQuery)
+	rows, err := repo.db.QueryContext(ctx, GetUserByEmailQuery, email)
 	if err != nil {
 		fmt.Println("got an error on the Query")
-		fcerr := fcerr.NewInternalServerError("Error while retrieving user from the database")
+		fcerr := dbError(ctx, "Error while retrieving user from the database")
 		return nil, fcerr
 	}
 	defer rows.Close()
@@ -509,10 +1171,13 @@ func (repo *repository) GetUserByAlexa(aID string) (*user.User, fcerr.FCErr) {
 		var cUser user.User
 		fmt.Println("Inside the result set loop. currentUser:", cUser)
 		err := rows.Scan(&cUser.UserID, &cUser.Email, &cUser.FirstName, &cUser.LastName, &cUser.FullName,
-			&cUser.CreatedDate, &cUser.AccessToken, &cUser.RefreshToken, &cUser.AlexaUserID, &cUser.Admin, &cUser.TempMatch)
+			&cUser.CreatedDate, &cUser.AccessToken, &cUser.RefreshToken, &cUser.Admin, &cUser.TempMatch,
+			dbTime{&cUser.TokenExpiry}, &cUser.TokenProvider, dbTime{&cUser.DeactivatedDate},
+			&cUser.TimeZone, &cUser.NotifyExpiring, &cUser.NotifyDaysBefore, dbTime{&cUser.DeletionDate},
+			&cUser.QuietStart, &cUser.QuietEnd)
 		if err != nil {
 			fmt.Println("got an error from the rows.Scan.")
-			fcerr := fcerr.NewInternalServerError("Error while scanning the result from the database")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
 			return nil, fcerr
 		}
 		fmt.Println("now after the current user scanned. currentUser:", cUser)
@@ -520,22 +1185,21 @@ func (repo *repository) GetUserByAlexa(aID string) (*user.User, fcerr.FCErr) {
 
 	}
 	if count == 0 {
-		fcerr := fcerr.NewNotFoundError("Database could not find a user with this Alexa User ID")
+		fcerr := fcerr.NewNotFoundError("Database could not find a user with this Email")
 		return nil, fcerr
 	}
 	return &resultingUser, nil
 }
 
 //GetUserByTempMatch(tm string) gets a user from the database with the given email.
-func (repo *repository) GetUserByTempMatch(tm string) (*user.User, fcerr.FCErr) {
-	getUserByTempMatchQuery := fmt.Sprintf(GetUserByTempMatchBase, tm)
-	fmt.Println("About to run this Query on the database:\n", getUserByTempMatchQuery)
+func (repo *repository) GetUserByTempMatch(ctx context.Context, tm string) (*user.User, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetUserByTempMatchQuery)
 	var resultingUser user.User
 
-	rows, err := repo.db.Query(getUserByTempMatchQuery)
+	rows, err := repo.db.QueryContext(ctx, GetUserByTempMatchQuery, tm)
 	if err != nil {
 		fmt.Println("got an error on the Query")
-		fcerr := fcerr.NewInternalServerError("Error while retrieving user from the database")
+		fcerr := dbError(ctx, "Error while retrieving user from the database")
 		return nil, fcerr
 	}
 	defer rows.Close()
@@ -550,10 +1214,13 @@ func (repo *repository) GetUserByTempMatch(tm string) (*user.User, fcerr.FCErr)
 		var cUser user.User
 		fmt.Println("Inside the result set loop. currentUser:", cUser)
 		err := rows.Scan(&cUser.UserID, &cUser.Email, &cUser.FirstName, &cUser.LastName, &cUser.FullName,
-			&cUser.CreatedDate, &cUser.AccessToken, &cUser.RefreshToken, &cUser.AlexaUserID, &cUser.Admin, &cUser.TempMatch)
+			&cUser.CreatedDate, &cUser.AccessToken, &cUser.RefreshToken, &cUser.Admin, &cUser.TempMatch,
+			dbTime{&cUser.TokenExpiry}, &cUser.TokenProvider, dbTime{&cUser.DeactivatedDate},
+			&cUser.TimeZone, &cUser.NotifyExpiring, &cUser.NotifyDaysBefore, dbTime{&cUser.DeletionDate},
+			&cUser.QuietStart, &cUser.QuietEnd)
 		if err != nil {
 			fmt.Println("got an error from the rows.Scan.")
-			fcerr := fcerr.NewInternalServerError("Error while scanning the result from the database")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
 			return nil, fcerr
 		}
 		fmt.Println("now after the current user scanned. currentUser:", cUser)
@@ -568,24 +1235,23 @@ func (repo *repository) GetUserByTempMatch(tm string) (*user.User, fcerr.FCErr)
 }
 
 //CreateUser(u user.User) takes a user object and attempts to add it to the database
-func (repo *repository) CreateUser(u user.User) (*user.User, fcerr.FCErr) {
+func (repo *repository) CreateUser(ctx context.Context, u user.User) (*user.User, fcerr.FCErr) {
 	tMatch := generateTempMatch()
-	createUserQuery := fmt.Sprintf(CreateUserBase, u.Email, u.FirstName, u.LastName, u.FullName,
-		u.CreatedDate, u.AccessToken, u.RefreshToken, u.AlexaUserID, u.Admin, tMatch)
-
-	fmt.Println("About to run this Query on the database:\n", createUserQuery)
+	fmt.Println("About to run this Query on the database:\n", CreateUserQuery)
 
-	_, err := repo.db.Query(createUserQuery)
+	_, err := repo.db.ExecContext(ctx, CreateUserQuery, u.Email, u.FirstName, u.LastName, u.FullName,
+		u.CreatedDate, u.AccessToken, u.RefreshToken, u.Admin, tMatch, storedTime(u.TokenExpiry), u.TokenProvider,
+		u.TimeZone, u.NotifyExpiring, u.NotifyDaysBefore, u.QuietStart, u.QuietEnd)
 	if err != nil {
 		fmt.Println("got an error on the Query:" + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while inserting the user into the database")
+		fcerr := dbError(ctx, "Error while inserting the user into the database")
 		return nil, fcerr
 	}
 
-	checkUser, err := repo.GetUserByTempMatch(tMatch)
+	checkUser, err := repo.GetUserByTempMatch(ctx, tMatch)
 	if err != nil {
 		fmt.Println("Trying to CreateUser, seem to have hit a snag. Got an error when checking what we just put in: " + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while checking the user that was created." +
+		fcerr := dbError(ctx, "Error while checking the user that was created."+
 			" Cannot verify if anything was entered to the Database")
 		return nil, fcerr
 	}
@@ -594,23 +1260,22 @@ func (repo *repository) CreateUser(u user.User) (*user.User, fcerr.FCErr) {
 }
 
 //UpdateUser(u user.User) takes a user object and tries to update the existing user in the database to match
-func (repo *repository) UpdateUser(u user.User) (*user.User, fcerr.FCErr) {
-	updateUserQuery := fmt.Sprintf(UpdateUserBase, u.Email, u.FirstName, u.LastName,
-		u.FullName, u.AccessToken, u.RefreshToken, u.AlexaUserID, u.TempMatch, u.UserID)
+func (repo *repository) UpdateUser(ctx context.Context, u user.User) (*user.User, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", UpdateUserQuery)
 
-	fmt.Println("About to run this Query on the database:\n", updateUserQuery)
-
-	_, err := repo.db.Query(updateUserQuery)
+	_, err := repo.db.ExecContext(ctx, UpdateUserQuery, u.Email, u.FirstName, u.LastName,
+		u.FullName, u.AccessToken, u.RefreshToken, storedTime(u.TokenExpiry), u.TokenProvider, u.TempMatch,
+		u.TimeZone, u.NotifyExpiring, u.NotifyDaysBefore, u.QuietStart, u.QuietEnd, u.UserID)
 	if err != nil {
 		fmt.Println("got an error on the query:" + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while updating the user in the database")
+		fcerr := dbError(ctx, "Error while updating the user in the database")
 		return nil, fcerr
 	}
 
-	checkDish, err := repo.GetUserByID(u.UserID)
+	checkDish, err := repo.GetUserByID(ctx, u.UserID)
 	if err != nil {
 		fmt.Println("got an error on the check query:" + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while checking the user that was created." +
+		fcerr := dbError(ctx, "Error while checking the user that was created."+
 			" Cannot verify if anything was updated in the Database")
 		return nil, fcerr
 	}
@@ -618,38 +1283,60 @@ func (repo *repository) UpdateUser(u user.User) (*user.User, fcerr.FCErr) {
 	return checkDish, nil
 }
 
-//DeleteUser(uID int) takes a user id int and tries to delete the existing user from the database
-func (repo *repository) DeleteUser(uID int) fcerr.FCErr {
-	deleteUserQuery := fmt.Sprintf(DeleteUserBase, uID)
+//SetUserDeactivated(userID int, when time.Time) deactivates the user at the given time, or with the zero time reactivates them.
+func (repo *repository) SetUserDeactivated(ctx context.Context, userID int, when time.Time) fcerr.FCErr {
+	fmt.Println("About to run this Query on the database:\n", SetUserDeactivatedQuery)
+	_, err := repo.db.ExecContext(ctx, SetUserDeactivatedQuery, storedTime(when), userID)
+	if err != nil {
+		fmt.Println("got an error on the update query:" + err.Error())
+		fcerr := dbError(ctx, "Error while deactivating the user in the database")
+		return fcerr
+	}
+	return nil
+}
+
+//SetUserDeletion(userID int, when time.Time) schedules the user's account to be erased at the given time, or with the
+//zero time cancels that.
+func (repo *repository) SetUserDeletion(ctx context.Context, userID int, when time.Time) fcerr.FCErr {
+	fmt.Println("About to run this Query on the database:\n", SetUserDeletionQuery)
+	_, err := repo.db.ExecContext(ctx, SetUserDeletionQuery, storedTime(when), userID)
+	if err != nil {
+		fmt.Println("got an error on the update query:" + err.Error())
+		fcerr := dbError(ctx, "Error while scheduling the user's deletion in the database")
+		return fcerr
+	}
+	return nil
+}
 
-	_, err := repo.db.Query(deleteUserQuery)
+//DeleteUser(uID int) takes a user id int and tries to delete the existing user from the database
+func (repo *repository) DeleteUser(ctx context.Context, uID int) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, DeleteUserQuery, uID)
 	if err != nil {
 		fmt.Println("got an error on the delete query:" + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while deleting the user from the database")
+		fcerr := dbError(ctx, "Error while deleting the user from the database")
 		return fcerr
 
 	}
 
-	returnedUser, err := repo.GetUserByID(uID)
+	returnedUser, err := repo.GetUserByID(ctx, uID)
 	if err == nil {
 		fmt.Println("Expected an error here, but didn't get one!! User Email:" + returnedUser.Email)
-		fcerr := fcerr.NewInternalServerError("Error while deleting the user from the database, could not verify it was deleted.")
+		fcerr := dbError(ctx, "Error while deleting the user from the database, could not verify it was deleted.")
 		return fcerr
 	}
 
 	return nil
 }
 
-//GetStorages(userID int) takes an int of a user id and returns a []storage units owned by that user.
-func (repo *repository) GetStorages(userID int) (*storage.Storages, fcerr.FCErr) {
+//GetStorages(householdID int) takes an int of a household id and returns a []storage units the household has.
+func (repo *repository) GetStorages(ctx context.Context, householdID int) (*storage.Storages, fcerr.FCErr) {
 	fmt.Println("now at the beginning of the db_repository GetStoragesByUser()")
 	var resultingStorages storage.Storages
-	getStoragesQuery := fmt.Sprintf(GetStoragesBase, userID)
-	rows, err := repo.db.Query(getStoragesQuery)
-	fmt.Println("now after doing the Query:", getStoragesQuery)
+	rows, err := repo.db.QueryContext(ctx, GetStoragesQuery, householdID)
+	fmt.Println("now after doing the Query:", GetStoragesQuery)
 	if err != nil {
 		fmt.Println("got an error on the Query:", err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while retrieving storage units from the database")
+		fcerr := dbError(ctx, "Error while retrieving storage units from the database")
 		return nil, fcerr
 	}
 	defer rows.Close()
@@ -659,12 +1346,12 @@ func (repo *repository) GetStorages(userID int) (*storage.Storages, fcerr.FCErr)
 		count++
 		var currentStorage storage.Storage
 		fmt.Println("Inside the result set loop. currentStorage:", currentStorage)
-		err := rows.Scan(&currentStorage.StorageID, &currentStorage.PersonalID, &currentStorage.UserID, &currentStorage.Title, &currentStorage.Description, &currentStorage.TempMatch)
+		err := rows.Scan(&currentStorage.StorageID, &currentStorage.PersonalID, &currentStorage.UserID, &currentStorage.Title, &currentStorage.Description, &currentStorage.TempMatch, &currentStorage.HouseholdID)
 		if err != nil {
 			fmt.Println("got an error from the rows.Scan.")
 			fmt.Println("&currentStorage.StorageID:", currentStorage.StorageID)
 			fmt.Println("&currentStorage.TempMatch:", currentStorage.TempMatch)
-			fcerr := fcerr.NewInternalServerError("Error while scanning the result from the database")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
 			return nil, fcerr
 		}
 		fmt.Println("now after the current storage scanned. currentStorage:", currentStorage)
@@ -680,16 +1367,15 @@ func (repo *repository) GetStorages(userID int) (*storage.Storages, fcerr.FCErr)
 	return &resultingStorages, nil
 }
 
-//GetStorageByID(userID int, pID int) queries the mysql database for a storage belonging to the requesting user with the personal id given
-func (repo *repository) GetStorageByID(userID int, pID int) (*storage.Storage, fcerr.FCErr) {
-	getStorageByIDQuery := fmt.Sprintf(GetStorageByIDBase, userID, pID)
-	fmt.Println("About to run this Query on the database:\n", getStorageByIDQuery)
+//GetStorageByID(householdID int, pID int) queries the mysql database for a storage belonging to the household with the personal id given
+func (repo *repository) GetStorageByID(ctx context.Context, householdID int, pID int) (*storage.Storage, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetStorageByIDQuery)
 	var resultingStorage storage.Storage
 
-	rows, err := repo.db.Query(getStorageByIDQuery)
+	rows, err := repo.db.QueryContext(ctx, GetStorageByIDQuery, householdID, pID)
 	if err != nil {
 		fmt.Println("got an error on the Query")
-		fcerr := fcerr.NewInternalServerError("Error while retrieving storage unit from the database")
+		fcerr := dbError(ctx, "Error while retrieving storage unit from the database")
 		return nil, fcerr
 	}
 	defer rows.Close()
@@ -703,10 +1389,10 @@ func (repo *repository) GetStorageByID(userID int, pID int) (*storage.Storage, f
 		}
 		var cStorage storage.Storage
 		fmt.Println("Inside the result set loop. currentStorage:", cStorage)
-		err := rows.Scan(&cStorage.StorageID, &cStorage.PersonalID, &cStorage.UserID, &cStorage.Title, &cStorage.Description, &cStorage.TempMatch)
+		err := rows.Scan(&cStorage.StorageID, &cStorage.PersonalID, &cStorage.UserID, &cStorage.Title, &cStorage.Description, &cStorage.TempMatch, &cStorage.HouseholdID)
 		if err != nil {
 			fmt.Println("got an error from the rows.Scan.")
-			fcerr := fcerr.NewInternalServerError("Error while scanning the result from the database")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
 			return nil, fcerr
 		}
 		fmt.Println("now after the current storage unit scanned. currentStorage:", cStorage)
@@ -721,15 +1407,14 @@ func (repo *repository) GetStorageByID(userID int, pID int) (*storage.Storage, f
 }
 
 //GetStorageByTempMatch(tM string) takes a string and queries the mysql database for a storage with this temp_match.
-func (repo *repository) GetStorageByTempMatch(tM string) (*storage.Storage, fcerr.FCErr) {
-	getStorageByIDQuery := fmt.Sprintf(GetStorageByTempMatchBase, tM)
-	fmt.Println("About to run this Query on the database:\n", getStorageByIDQuery)
+func (repo *repository) GetStorageByTempMatch(ctx context.Context, tM string) (*storage.Storage, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetStorageByTempMatchQuery)
 	var resultingStorage storage.Storage
 
-	rows, err := repo.db.Query(getStorageByIDQuery)
+	rows, err := repo.db.QueryContext(ctx, GetStorageByTempMatchQuery, tM)
 	if err != nil {
 		fmt.Println("got an error on the Query")
-		fcerr := fcerr.NewInternalServerError("Error while retrieving storage unit from the database")
+		fcerr := dbError(ctx, "Error while retrieving storage unit from the database")
 		return nil, fcerr
 	}
 	defer rows.Close()
@@ -743,10 +1428,10 @@ func (repo *repository) GetStorageByTempMatch(tM string) (*storage.Storage, fcer
 		}
 		var cStorage storage.Storage
 		fmt.Println("Inside the result set loop. currentStorage:", cStorage)
-		err := rows.Scan(&cStorage.StorageID, &cStorage.PersonalID, &cStorage.UserID, &cStorage.Title, &cStorage.Description, &cStorage.TempMatch)
+		err := rows.Scan(&cStorage.StorageID, &cStorage.PersonalID, &cStorage.UserID, &cStorage.Title, &cStorage.Description, &cStorage.TempMatch, &cStorage.HouseholdID)
 		if err != nil {
 			fmt.Println("got an error from the rows.Scan.")
-			fcerr := fcerr.NewInternalServerError("Error while scanning the result from the database")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
 			return nil, fcerr
 		}
 		fmt.Println("now after the current storage unit scanned. currentStorage:", cStorage)
@@ -761,23 +1446,21 @@ func (repo *repository) GetStorageByTempMatch(tM string) (*storage.Storage, fcer
 }
 
 //reateStorage(s storage.Storage) takes a storage object and tries to add it to the database
-func (repo *repository) CreateStorage(s storage.Storage) (*storage.Storage, fcerr.FCErr) {
+func (repo *repository) CreateStorage(ctx context.Context, s storage.Storage) (*storage.Storage, fcerr.FCErr) {
 	tMatch := generateTempMatch()
-	createStorageQuery := fmt.Sprintf(CreateStorageBase, s.PersonalID, s.UserID, s.Title, s.Description, tMatch)
+	fmt.Println("About to run this Query on the database:\n", CreateStorageQuery)
 
-	fmt.Println("About to run this Query on the database:\n", createStorageQuery)
-
-	_, err := repo.db.Query(createStorageQuery)
+	_, err := repo.db.ExecContext(ctx, CreateStorageQuery, s.PersonalID, s.UserID, s.Title, s.Description, tMatch, s.HouseholdID)
 	if err != nil {
 		fmt.Println("got an error on the Query:" + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while inserting the storage unit into the database")
+		fcerr := dbError(ctx, "Error while inserting the storage unit into the database")
 		return nil, fcerr
 	}
 
-	checkStorage, err := repo.GetStorageByTempMatch(tMatch)
+	checkStorage, err := repo.GetStorageByTempMatch(ctx, tMatch)
 	if err != nil {
 		fmt.Println("Trying to CreateStorage, seem to have hit a snag. Got an error when checking what we just put in: " + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while checking the storage unit that was created." +
+		fcerr := dbError(ctx, "Error while checking the storage unit that was created."+
 			" Cannot verify if anything was entered to the Database")
 		return nil, fcerr
 	}
@@ -786,22 +1469,20 @@ func (repo *repository) CreateStorage(s storage.Storage) (*storage.Storage, fcer
 }
 
 //UpdateStorage(s storage.Storage) takes a storage object and tries to update the existing storage in the database to match
-func (repo *repository) UpdateStorage(s storage.Storage) fcerr.FCErr {
-	updateStorageQuery := fmt.Sprintf(UpdateStorageBase, s.PersonalID, s.Title, s.Description, s.TempMatch, s.StorageID)
-
-	fmt.Println("About to run this Query on the database:\n", updateStorageQuery)
+func (repo *repository) UpdateStorage(ctx context.Context, s storage.Storage) fcerr.FCErr {
+	fmt.Println("About to run this Query on the database:\n", UpdateStorageQuery)
 
-	_, err := repo.db.Query(updateStorageQuery)
+	_, err := repo.db.ExecContext(ctx, UpdateStorageQuery, s.PersonalID, s.Title, s.Description, s.TempMatch, s.StorageID)
 	if err != nil {
 		fmt.Println("got an error on the query:" + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while updating the storage unit in the database")
+		fcerr := dbError(ctx, "Error while updating the storage unit in the database")
 		return fcerr
 	}
 
-	_, err2 := repo.GetStorageByID(s.UserID, s.PersonalID)
+	_, err2 := repo.GetStorageByID(ctx, s.HouseholdID, s.PersonalID)
 	if err2 != nil {
 		fmt.Println("got an error on the check query:" + err2.Error())
-		fcerr := fcerr.NewInternalServerError("Error while checking the storage unit that was created." +
+		fcerr := dbError(ctx, "Error while checking the storage unit that was created."+
 			" Cannot verify if anything was updated in the Database")
 		return fcerr
 	}
@@ -809,60 +1490,100 @@ func (repo *repository) UpdateStorage(s storage.Storage) fcerr.FCErr {
 	return nil
 }
 
-//GetPersonalStorageCount(userID int) gets the number of dishes the given user has in the database
-func (repo *repository) GetPersonalStorageCount(userID int) (int, fcerr.FCErr) {
-	getPersonalStorageCountQuery := fmt.Sprintf(GetPersonalStorageCountBase, userID)
-	personalStorageCountRow := repo.db.QueryRow(getPersonalStorageCountQuery)
+//GetPersonalStorageCount(householdID int) gets the number of storage units the given household has in the database
+func (repo *repository) GetPersonalStorageCount(ctx context.Context, householdID int) (int, fcerr.FCErr) {
+	personalStorageCountRow := repo.db.QueryRowContext(ctx, GetPersonalStorageCountQuery+repo.lockSuffix(), householdID)
 	var personalStorageCount int
 	err := personalStorageCountRow.Scan(&personalStorageCount)
 	if err != nil {
 		fmt.Println("got an error on the get personal count process:" + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while checking on how many storage units the user has.")
+		fcerr := dbError(ctx, "Error while checking on how many storage units the user has.")
 		return 0, fcerr
 	}
 	return personalStorageCount, nil
 
 }
 
-//DeleteStorage(userID int, pID int) takes a user id and a personal id number and tries to delete the existing storage from the database
-func (repo *repository) DeleteStorage(userID int, pID int) fcerr.FCErr {
-	deleteStorageQuery := fmt.Sprintf(DeleteStorageBase, userID, pID)
-
-	_, err := repo.db.Query(deleteStorageQuery)
-	if err != nil {
-		fmt.Println("got an error on the delete query:" + err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while deleting the storage unit from the database")
-		return fcerr
+//DeleteStorage(householdID int, pID int) takes a household id and a personal id number and tries to delete the existing storage from the database.
+//Only an empty storage unit can be deleted; the household's later storage units (and the dishes in them) are renumbered in the same transaction.
+func (repo *repository) DeleteStorage(ctx context.Context, householdID int, pID int) fcerr.FCErr {
+	return repo.WithTx(ctx, func(txRepo Repository) fcerr.FCErr {
+		return txRepo.(*repository).deleteStorage(ctx, householdID, pID)
+	})
+}
 
+func (repo *repository) deleteStorage(ctx context.Context, householdID int, pID int) fcerr.FCErr {
+	personalStorageCount, fcErr := repo.GetPersonalStorageCount(ctx, householdID)
+	if fcErr != nil {
+		return dbError(ctx, "Error when Deleting the storage unit")
 	}
 
-	returnedStorage, err := repo.GetStorageByID(userID, pID)
-	if err == nil {
-		fmt.Println("Expected an error here, but didn't get one!! Storage ID:", returnedStorage.StorageID)
-		fcerr := fcerr.NewInternalServerError("Error while deleting the storage unit from the database, could not verify it was deleted.")
-		return fcerr
+	if pID < 1 || pID > personalStorageCount {
+		return fcerr.NewBadRequestError("Could not delete a storage unit that doesn't exist")
+	}
+
+	var storageDishCount int
+	err := repo.db.QueryRowContext(ctx, GetStorageDishCountQuery, householdID, pID).Scan(&storageDishCount)
+	if err != nil {
+		fmt.Println("got an error counting the storage unit's dishes:" + err.Error())
+		return dbError(ctx, "Error while checking the dishes in the storage unit")
+	}
+	if storageDishCount > 0 {
+		return fcerr.NewBadRequestError("Could not delete a storage unit that still has dishes in it")
+	}
+
+	_, err = repo.db.ExecContext(ctx, DeleteStorageQuery, householdID, pID)
+	if err != nil {
+		fmt.Println("got an error on the delete query:" + err.Error())
+		fcerr := dbError(ctx, "Error while deleting the storage unit from the database")
+		return fcerr
+
+	}
+
+	returnedStorage, fcErr := repo.GetStorageByID(ctx, householdID, pID)
+	if fcErr == nil {
+		fmt.Println("Expected an error here, but didn't get one!! Storage ID:", returnedStorage.StorageID)
+		fcerr := dbError(ctx, "Error while deleting the storage unit from the database, could not verify it was deleted.")
+		return fcerr
+	}
+
+	if pID != personalStorageCount {
+		//Storage was in the middle of the list somewhere - shift the rest of the list up, dishes included
+		_, err := repo.db.ExecContext(ctx, NegateSomeStoragesQuery, householdID, pID)
+		if err == nil {
+			_, err = repo.db.ExecContext(ctx, RestoreNegatedStoragesQuery, householdID)
+		}
+		if err == nil {
+			_, err = repo.db.ExecContext(ctx, DecrementSomeStorageDishesQuery, householdID, pID)
+		}
+		if err != nil {
+			fmt.Println("got an error renumbering the storage units:" + err.Error())
+			return dbError(ctx, "Error while cleaning up the remaining storage units")
+		}
 	}
 
 	return nil
 }
 
-//GetStorageDishes(userID int, storagePID int) takes a personal id number and returns the []dish contained in that user's matching storage unit
-func (repo *repository) GetStorageDishes(userID int, storagePID int) (*dish.Dishes, fcerr.FCErr) {
+//GetStorageDishes(householdID int, storagePID int) takes a personal id number and returns the []dish contained in that household's matching storage unit
+func (repo *repository) GetStorageDishes(ctx context.Context, householdID int, storagePID int) (*dish.Dishes, fcerr.FCErr) {
 	fmt.Println("now at the beginning of the db_repository GetStorageDishes()")
 	var resultDishes dish.Dishes
 
-	resultStorage, storageErr := repo.GetStorageByID(userID, storagePID)
+	resultStorage, storageErr := repo.GetStorageByID(ctx, householdID, storagePID)
 	if storageErr != nil {
-		fmt.Println("could not find a storage unit belonging to user:" + strconv.Itoa(userID) + " with the personal storage id:" + strconv.Itoa(storagePID))
-		return nil, fcerr.NewInternalServerError("Could not find such a storage unit")
+		fmt.Println("could not find a storage unit belonging to household:" + strconv.Itoa(householdID) + " with the personal storage id:" + strconv.Itoa(storagePID))
+		if storageErr.Status() == http.StatusNotFound {
+			return nil, fcerr.NewNotFoundError("Database could not find such a storage unit")
+		}
+		return nil, dbError(ctx, "Could not find such a storage unit")
 	}
 
-	getStorageDishesQuery := fmt.Sprintf(GetStorageDishesBase, userID, resultStorage.PersonalID)
-	rows, err := repo.db.Query(getStorageDishesQuery)
-	fmt.Println("now after doing the Query:", getStorageDishesQuery)
+	rows, err := repo.db.QueryContext(ctx, GetStorageDishesQuery, householdID, resultStorage.PersonalID)
+	fmt.Println("now after doing the Query:", GetStorageDishesQuery)
 	if err != nil {
 		fmt.Println("got an error on the Query:", err.Error())
-		fcerr := fcerr.NewInternalServerError("Error while retrieving dishes from the database")
+		fcerr := dbError(ctx, "Error while retrieving dishes from the database")
 		return nil, fcerr
 	}
 	defer rows.Close()
@@ -873,13 +1594,13 @@ func (repo *repository) GetStorageDishes(userID int, storagePID int) (*dish.Dish
 		var currentDish dish.Dish
 		fmt.Println("Inside the result set loop. currentDish:", currentDish)
 		err := rows.Scan(&currentDish.DishID, &currentDish.PersonalDishID, &currentDish.UserID, &currentDish.StorageID, &currentDish.Title,
-			&currentDish.Description, &currentDish.CreatedDate, &currentDish.ExpireDate, &currentDish.Priority,
-			&currentDish.DishType, &currentDish.Portions, &currentDish.TempMatch)
+			&currentDish.Description, dbTime{&currentDish.CreatedDate}, dbTime{&currentDish.ExpireDate}, &currentDish.Priority,
+			&currentDish.DishType, &currentDish.Portions, &currentDish.TempMatch, &currentDish.HouseholdID)
 		if err != nil {
 			fmt.Println("got an error from the rows.Scan.")
 			fmt.Println("&currentDish.DishID:", currentDish.DishID)
 			fmt.Println("&currentDish.TempMatch:", currentDish.TempMatch)
-			fcerr := fcerr.NewInternalServerError("Error while scanning the result from the database")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
 			return nil, fcerr
 		}
 		fmt.Println("now after the current dish scanned. currentDish:", currentDish)
@@ -895,6 +1616,859 @@ func (repo *repository) GetStorageDishes(userID int, storagePID int) (*dish.Dish
 	return &resultDishes, nil
 }
 
+//GetSessionByTokenHash(hash string) gets the session whose token hashes to the given value, revoked or expired or not.
+func (repo *repository) GetSessionByTokenHash(ctx context.Context, hash string) (*session.Session, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetSessionByTokenHashQuery)
+	var resultingSession session.Session
+
+	rows, err := repo.db.QueryContext(ctx, GetSessionByTokenHashQuery, hash)
+	if err != nil {
+		fmt.Println("got an error on the Query:", err.Error())
+		fcerr := dbError(ctx, "Error while retrieving session from the database")
+		return nil, fcerr
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		count++
+		if count > 1 {
+			dberr := fcerr.NewInternalServerError("Database returned more than 1 row when only 1 was expected")
+			return nil, dberr
+		}
+		var cSession session.Session
+		err := rows.Scan(&cSession.SessionID, &cSession.UserID, &cSession.TokenHash, dbTime{&cSession.CreatedDate},
+			dbTime{&cSession.ExpireDate}, dbTime{&cSession.RevokedDate}, &cSession.ImpersonatorID)
+		if err != nil {
+			fmt.Println("got an error from the rows.Scan.")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
+			return nil, fcerr
+		}
+		resultingSession = cSession
+	}
+	if count == 0 {
+		fcerr := fcerr.NewNotFoundError("Database could not find a session with this token")
+		return nil, fcerr
+	}
+	return &resultingSession, nil
+}
+
+//CreateSession(s session.Session) adds a session to the database. The token hash is unique, so it finds the new row again.
+func (repo *repository) CreateSession(ctx context.Context, s session.Session) (*session.Session, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", CreateSessionQuery)
+
+	_, err := repo.db.ExecContext(ctx, CreateSessionQuery, s.UserID, s.TokenHash, storedTime(s.CreatedDate), storedTime(s.ExpireDate),
+		s.ImpersonatorID)
+	if err != nil {
+		fmt.Println("got an error on the Query:" + err.Error())
+		fcerr := dbError(ctx, "Error while inserting the session into the database")
+		return nil, fcerr
+	}
+
+	checkSession, err := repo.GetSessionByTokenHash(ctx, s.TokenHash)
+	if err != nil {
+		fmt.Println("Trying to CreateSession, got an error when checking what we just put in: " + err.Error())
+		fcerr := dbError(ctx, "Error while checking the session that was created."+
+			" Cannot verify if anything was entered to the Database")
+		return nil, fcerr
+	}
+
+	return checkSession, nil
+}
+
+//RevokeSession(hash string, when time.Time) marks the session with this token hash as revoked at the given time.
+func (repo *repository) RevokeSession(ctx context.Context, hash string, when time.Time) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, RevokeSessionQuery, storedTime(when), hash)
+	if err != nil {
+		fmt.Println("got an error on the revoke query:" + err.Error())
+		fcerr := dbError(ctx, "Error while revoking the session in the database")
+		return fcerr
+	}
+	return nil
+}
+
+//RevokeUserSessions(userID int, when time.Time) marks every session the user still has as revoked at the given time.
+func (repo *repository) RevokeUserSessions(ctx context.Context, userID int, when time.Time) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, RevokeUserSessionsQuery, storedTime(when), userID)
+	if err != nil {
+		fmt.Println("got an error on the revoke query:" + err.Error())
+		fcerr := dbError(ctx, "Error while revoking the user's sessions in the database")
+		return fcerr
+	}
+	return nil
+}
+
+//DeleteUserSessions(userID int) deletes every session the user has had, logged out or not.
+func (repo *repository) DeleteUserSessions(ctx context.Context, userID int) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, DeleteUserSessionsQuery, userID)
+	if err != nil {
+		fmt.Println("got an error on the delete query:" + err.Error())
+		fcerr := dbError(ctx, "Error while deleting the user's sessions from the database")
+		return fcerr
+	}
+	return nil
+}
+
+//GetIdentity(provider string, externalID string) gets the identity the user linked from the provider's account with this id.
+func (repo *repository) GetIdentity(ctx context.Context, provider string, externalID string) (*identity.Identity, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetIdentityQuery)
+	return repo.getIdentity(ctx, GetIdentityQuery, "Database could not find an identity linked from this account", provider, externalID)
+}
+
+//GetIdentityByRefreshHash(hash string) gets the identity whose refresh token hashes to the given value.
+func (repo *repository) GetIdentityByRefreshHash(ctx context.Context, hash string) (*identity.Identity, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetIdentityByRefreshHashQuery)
+	return repo.getIdentity(ctx, GetIdentityByRefreshHashQuery, "Database could not find an identity with this refresh token", hash)
+}
+
+//getIdentity runs a query that should find one linked identity.
+func (repo *repository) getIdentity(ctx context.Context, query string, notFound string, args ...interface{}) (*identity.Identity, fcerr.FCErr) {
+	identities, fcErr := repo.queryIdentities(ctx, query, args...)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	if len(identities) > 1 {
+		return nil, fcerr.NewInternalServerError("Database returned more than 1 row when only 1 was expected")
+	}
+	if len(identities) == 0 {
+		return nil, fcerr.NewNotFoundError(notFound)
+	}
+	return &identities[0], nil
+}
+
+//GetUserIdentities(userID int) gives every identity the user has linked, oldest first. A user with none gets an empty list.
+func (repo *repository) GetUserIdentities(ctx context.Context, userID int) (*identity.Identities, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetUserIdentitiesQuery)
+	identities, fcErr := repo.queryIdentities(ctx, GetUserIdentitiesQuery, userID)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return &identities, nil
+}
+
+//queryIdentities scans every linked identity the query gives.
+func (repo *repository) queryIdentities(ctx context.Context, query string, args ...interface{}) (identity.Identities, fcerr.FCErr) {
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		fmt.Println("got an error on the Query:", err.Error())
+		fcerr := dbError(ctx, "Error while retrieving linked identities from the database")
+		return nil, fcerr
+	}
+	defer rows.Close()
+
+	identities := identity.Identities{}
+	for rows.Next() {
+		var cIdentity identity.Identity
+		err := rows.Scan(&cIdentity.LinkID, &cIdentity.UserID, &cIdentity.Provider, &cIdentity.ExternalID,
+			&cIdentity.AccessTokenHash, &cIdentity.RefreshTokenHash, dbTime{&cIdentity.CreatedDate})
+		if err != nil {
+			fmt.Println("got an error from the rows.Scan.")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
+			return nil, fcerr
+		}
+		identities = append(identities, cIdentity)
+	}
+	return identities, nil
+}
+
+//CreateIdentity(i identity.Identity) adds a linked identity to the database. Every new link has a refresh token,
+//so its hash finds the new row again.
+func (repo *repository) CreateIdentity(ctx context.Context, i identity.Identity) (*identity.Identity, fcerr.FCErr) {
+	if i.RefreshTokenHash == "" {
+		return nil, fcerr.NewInternalServerError("A linked identity needs a refresh token")
+	}
+	fmt.Println("About to run this Query on the database:\n", CreateIdentityQuery)
+
+	_, err := repo.db.ExecContext(ctx, CreateIdentityQuery, i.UserID, i.Provider, i.ExternalID, i.AccessTokenHash,
+		i.RefreshTokenHash, storedTime(i.CreatedDate))
+	if err != nil {
+		fmt.Println("got an error on the Query:" + err.Error())
+		fcerr := dbError(ctx, "Error while inserting the linked identity into the database")
+		return nil, fcerr
+	}
+
+	checkIdentity, err := repo.GetIdentityByRefreshHash(ctx, i.RefreshTokenHash)
+	if err != nil {
+		fmt.Println("Trying to CreateIdentity, got an error when checking what we just put in: " + err.Error())
+		fcerr := dbError(ctx, "Error while checking the linked identity that was created."+
+			" Cannot verify if anything was entered to the Database")
+		return nil, fcerr
+	}
+	return checkIdentity, nil
+}
+
+//UpdateIdentity(i identity.Identity) saves the identity's external id and token hashes.
+func (repo *repository) UpdateIdentity(ctx context.Context, i identity.Identity) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, UpdateIdentityQuery, i.ExternalID, i.AccessTokenHash, i.RefreshTokenHash, i.LinkID)
+	if err != nil {
+		fmt.Println("got an error on the update query:" + err.Error())
+		fcerr := dbError(ctx, "Error while updating the linked identity in the database")
+		return fcerr
+	}
+	return nil
+}
+
+//DeleteIdentity(userID int, linkID int) removes one of the user's linked identities, or gives a 404 if they have none with that id.
+func (repo *repository) DeleteIdentity(ctx context.Context, userID int, linkID int) fcerr.FCErr {
+	result, err := repo.db.ExecContext(ctx, DeleteIdentityQuery, userID, linkID)
+	if err != nil {
+		fmt.Println("got an error on the delete query:" + err.Error())
+		fcerr := dbError(ctx, "Error while deleting the linked identity from the database")
+		return fcerr
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return fcerr.NewNotFoundError("Database could not find a linked identity with this id")
+	}
+	return nil
+}
+
+//GetHousehold(householdID int) gets the household with the given id.
+func (repo *repository) GetHousehold(ctx context.Context, householdID int) (*household.Household, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetHouseholdQuery)
+	return repo.getHousehold(ctx, GetHouseholdQuery, "Database could not find a household with this ID", householdID)
+}
+
+//getHousehold runs a query that should find one household.
+func (repo *repository) getHousehold(ctx context.Context, query string, notFound string, args ...interface{}) (*household.Household, fcerr.FCErr) {
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		fmt.Println("got an error on the Query:", err.Error())
+		fcerr := dbError(ctx, "Error while retrieving the household from the database")
+		return nil, fcerr
+	}
+	defer rows.Close()
+
+	var households household.Households
+	for rows.Next() {
+		var cHousehold household.Household
+		err := rows.Scan(&cHousehold.HouseholdID, &cHousehold.Name, dbTime{&cHousehold.CreatedDate}, &cHousehold.TempMatch)
+		if err != nil {
+			fmt.Println("got an error from the rows.Scan.")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
+			return nil, fcerr
+		}
+		households = append(households, cHousehold)
+	}
+	if len(households) > 1 {
+		return nil, fcerr.NewInternalServerError("Database returned more than 1 row when only 1 was expected")
+	}
+	if len(households) == 0 {
+		return nil, fcerr.NewNotFoundError(notFound)
+	}
+	return &households[0], nil
+}
+
+//CreateHousehold(h household.Household) adds a household with a new id and temp match.
+func (repo *repository) CreateHousehold(ctx context.Context, h household.Household) (*household.Household, fcerr.FCErr) {
+	tMatch := generateTempMatch()
+	fmt.Println("About to run this Query on the database:\n", CreateHouseholdQuery)
+
+	_, err := repo.db.ExecContext(ctx, CreateHouseholdQuery, h.Name, storedTime(h.CreatedDate), tMatch)
+	if err != nil {
+		fmt.Println("got an error on the Query:" + err.Error())
+		fcerr := dbError(ctx, "Error while inserting the household into the database")
+		return nil, fcerr
+	}
+
+	checkHousehold, err := repo.getHousehold(ctx, GetHouseholdByTempMatchQuery, "", tMatch)
+	if err != nil {
+		fmt.Println("Trying to CreateHousehold, got an error when checking what we just put in: " + err.Error())
+		fcerr := dbError(ctx, "Error while checking the household that was created."+
+			" Cannot verify if anything was entered to the Database")
+		return nil, fcerr
+	}
+	return checkHousehold, nil
+}
+
+//UpdateHousehold(h household.Household) saves the household's name.
+func (repo *repository) UpdateHousehold(ctx context.Context, h household.Household) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, UpdateHouseholdQuery, h.Name, h.HouseholdID)
+	if err != nil {
+		fmt.Println("got an error on the update query:" + err.Error())
+		fcerr := dbError(ctx, "Error while updating the household in the database")
+		return fcerr
+	}
+	return nil
+}
+
+//DeleteHousehold(householdID int) deletes the household. Its members, storage units and dishes are left alone, so
+//callers only delete a household nobody and nothing is in any more.
+func (repo *repository) DeleteHousehold(ctx context.Context, householdID int) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, DeleteHouseholdQuery, householdID)
+	if err != nil {
+		fmt.Println("got an error on the delete query:" + err.Error())
+		fcerr := dbError(ctx, "Error while deleting the household from the database")
+		return fcerr
+	}
+	return nil
+}
+
+//DeleteHouseholdFood(householdID int) deletes every dish and storage unit the household has, and the record of the
+//dishes it removed.
+func (repo *repository) DeleteHouseholdFood(ctx context.Context, householdID int) fcerr.FCErr {
+	for _, query := range []string{DeleteHouseholdDishesQuery, DeleteHouseholdStoragesQuery, DeleteHouseholdRemovalsQuery} {
+		_, err := repo.db.ExecContext(ctx, query, householdID)
+		if err != nil {
+			fmt.Println("got an error on the delete query:" + err.Error())
+			fcerr := dbError(ctx, "Error while deleting the household's food from the database")
+			return fcerr
+		}
+	}
+	return nil
+}
+
+//GetMembership(userID int) gets the user's place in their household, or gives a 404 if they aren't in one.
+func (repo *repository) GetMembership(ctx context.Context, userID int) (*household.Member, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetMembershipQuery)
+	members, fcErr := repo.queryMembers(ctx, GetMembershipQuery, userID)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	if len(members) > 1 {
+		return nil, fcerr.NewInternalServerError("Database returned more than 1 row when only 1 was expected")
+	}
+	if len(members) == 0 {
+		return nil, fcerr.NewNotFoundError("Database could not find a household this user is in")
+	}
+	return &members[0], nil
+}
+
+//GetHouseholdMembers(householdID int) gives everyone in the household, in the order they joined.
+func (repo *repository) GetHouseholdMembers(ctx context.Context, householdID int) (*household.Members, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetHouseholdMembersQuery)
+	members, fcErr := repo.queryMembers(ctx, GetHouseholdMembersQuery, householdID)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return &members, nil
+}
+
+//queryMembers scans every household member the query gives.
+func (repo *repository) queryMembers(ctx context.Context, query string, args ...interface{}) (household.Members, fcerr.FCErr) {
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		fmt.Println("got an error on the Query:", err.Error())
+		fcerr := dbError(ctx, "Error while retrieving household members from the database")
+		return nil, fcerr
+	}
+	defer rows.Close()
+
+	members := household.Members{}
+	for rows.Next() {
+		var cMember household.Member
+		err := rows.Scan(&cMember.HouseholdID, &cMember.UserID, &cMember.Role, dbTime{&cMember.JoinedDate},
+			&cMember.Email, &cMember.FullName)
+		if err != nil {
+			fmt.Println("got an error from the rows.Scan.")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
+			return nil, fcerr
+		}
+		members = append(members, cMember)
+	}
+	return members, nil
+}
+
+//CreateMembership(m household.Member) puts the user in the household. A user already in a household can't be added to another.
+func (repo *repository) CreateMembership(ctx context.Context, m household.Member) fcerr.FCErr {
+	fmt.Println("About to run this Query on the database:\n", CreateMembershipQuery)
+	_, err := repo.db.ExecContext(ctx, CreateMembershipQuery, m.HouseholdID, m.UserID, m.Role, storedTime(m.JoinedDate))
+	if err != nil {
+		fmt.Println("got an error on the Query:" + err.Error())
+		fcerr := dbError(ctx, "Error while inserting the household member into the database")
+		return fcerr
+	}
+	return nil
+}
+
+//UpdateMembership(m household.Member) saves the member's role.
+func (repo *repository) UpdateMembership(ctx context.Context, m household.Member) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, UpdateMembershipQuery, m.Role, m.HouseholdID, m.UserID)
+	if err != nil {
+		fmt.Println("got an error on the update query:" + err.Error())
+		fcerr := dbError(ctx, "Error while updating the household member in the database")
+		return fcerr
+	}
+	return nil
+}
+
+//DeleteMembership(householdID int, userID int) takes the user out of the household, or gives a 404 if they aren't in it.
+func (repo *repository) DeleteMembership(ctx context.Context, householdID int, userID int) fcerr.FCErr {
+	result, err := repo.db.ExecContext(ctx, DeleteMembershipQuery, householdID, userID)
+	if err != nil {
+		fmt.Println("got an error on the delete query:" + err.Error())
+		fcerr := dbError(ctx, "Error while deleting the household member from the database")
+		return fcerr
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return fcerr.NewNotFoundError("Database could not find this member in the household")
+	}
+	return nil
+}
+
+//GetInvite(inviteID int) gets the household invite with the given id.
+func (repo *repository) GetInvite(ctx context.Context, inviteID int) (*household.Invite, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetInviteQuery)
+	return repo.getInvite(ctx, GetInviteQuery, "Database could not find a household invite with this ID", inviteID)
+}
+
+//GetInvitesByEmail(email string) gives the invites sent to the email, oldest first. Emails are kept in lower case.
+func (repo *repository) GetInvitesByEmail(ctx context.Context, email string) (*household.Invites, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetInvitesByEmailQuery)
+	invites, fcErr := repo.queryInvites(ctx, GetInvitesByEmailQuery, email)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return &invites, nil
+}
+
+//GetHouseholdInvites(householdID int) gives the invites the household has sent, oldest first.
+func (repo *repository) GetHouseholdInvites(ctx context.Context, householdID int) (*household.Invites, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetHouseholdInvitesQuery)
+	invites, fcErr := repo.queryInvites(ctx, GetHouseholdInvitesQuery, householdID)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return &invites, nil
+}
+
+//getInvite runs a query that should find one invite.
+func (repo *repository) getInvite(ctx context.Context, query string, notFound string, args ...interface{}) (*household.Invite, fcerr.FCErr) {
+	invites, fcErr := repo.queryInvites(ctx, query, args...)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	if len(invites) > 1 {
+		return nil, fcerr.NewInternalServerError("Database returned more than 1 row when only 1 was expected")
+	}
+	if len(invites) == 0 {
+		return nil, fcerr.NewNotFoundError(notFound)
+	}
+	return &invites[0], nil
+}
+
+//queryInvites scans every household invite the query gives.
+func (repo *repository) queryInvites(ctx context.Context, query string, args ...interface{}) (household.Invites, fcerr.FCErr) {
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		fmt.Println("got an error on the Query:", err.Error())
+		fcerr := dbError(ctx, "Error while retrieving household invites from the database")
+		return nil, fcerr
+	}
+	defer rows.Close()
+
+	invites := household.Invites{}
+	for rows.Next() {
+		var cInvite household.Invite
+		err := rows.Scan(&cInvite.InviteID, &cInvite.HouseholdID, &cInvite.Email, &cInvite.Role, &cInvite.InvitedBy,
+			dbTime{&cInvite.CreatedDate}, dbTime{&cInvite.ExpireDate}, &cInvite.TempMatch)
+		if err != nil {
+			fmt.Println("got an error from the rows.Scan.")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
+			return nil, fcerr
+		}
+		invites = append(invites, cInvite)
+	}
+	return invites, nil
+}
+
+//CreateInvite(i household.Invite) adds a household invite with a new id and temp match.
+func (repo *repository) CreateInvite(ctx context.Context, i household.Invite) (*household.Invite, fcerr.FCErr) {
+	tMatch := generateTempMatch()
+	fmt.Println("About to run this Query on the database:\n", CreateInviteQuery)
+
+	_, err := repo.db.ExecContext(ctx, CreateInviteQuery, i.HouseholdID, i.Email, i.Role, i.InvitedBy, storedTime(i.CreatedDate),
+		storedTime(i.ExpireDate), tMatch)
+	if err != nil {
+		fmt.Println("got an error on the Query:" + err.Error())
+		fcerr := dbError(ctx, "Error while inserting the household invite into the database")
+		return nil, fcerr
+	}
+
+	checkInvite, err := repo.getInvite(ctx, GetInviteByTempMatchQuery, "", tMatch)
+	if err != nil {
+		fmt.Println("Trying to CreateInvite, got an error when checking what we just put in: " + err.Error())
+		fcerr := dbError(ctx, "Error while checking the household invite that was created."+
+			" Cannot verify if anything was entered to the Database")
+		return nil, fcerr
+	}
+	return checkInvite, nil
+}
+
+//DeleteInvite(inviteID int) deletes the household invite. Deleting one that is already gone isn't an error.
+func (repo *repository) DeleteInvite(ctx context.Context, inviteID int) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, DeleteInviteQuery, inviteID)
+	if err != nil {
+		fmt.Println("got an error on the delete query:" + err.Error())
+		fcerr := dbError(ctx, "Error while deleting the household invite from the database")
+		return fcerr
+	}
+	return nil
+}
+
+//GetNotifications(userID int) gives the notifications the user was sent that haven't been cleared out yet, oldest
+//first. A user who was sent none gets an empty list.
+func (repo *repository) GetNotifications(ctx context.Context, userID int) (*notification.Notifications, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetNotificationsQuery)
+	rows, err := repo.db.QueryContext(ctx, GetNotificationsQuery, userID)
+	if err != nil {
+		fmt.Println("got an error on the Query:", err.Error())
+		fcerr := dbError(ctx, "Error while retrieving notifications from the database")
+		return nil, fcerr
+	}
+	defer rows.Close()
+
+	notifications := notification.Notifications{}
+	for rows.Next() {
+		var cNotification notification.Notification
+		err := rows.Scan(&cNotification.NotificationID, &cNotification.UserID, &cNotification.DishID, &cNotification.Channel,
+			dbTime{&cNotification.ExpireDate}, dbTime{&cNotification.SentDate})
+		if err != nil {
+			fmt.Println("got an error from the rows.Scan.")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
+			return nil, fcerr
+		}
+		notifications = append(notifications, cNotification)
+	}
+	return &notifications, nil
+}
+
+//CreateNotification(n notification.Notification) records that the user was sent a notification.
+func (repo *repository) CreateNotification(ctx context.Context, n notification.Notification) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, CreateNotificationQuery, n.UserID, n.DishID, n.Channel, storedTime(n.ExpireDate),
+		storedTime(n.SentDate))
+	if err != nil {
+		fmt.Println("got an error on the Query:" + err.Error())
+		fcerr := dbError(ctx, "Error while inserting the notification into the database")
+		return fcerr
+	}
+	return nil
+}
+
+//DeleteNotificationsBefore(expired time.Time) clears out the notifications about dishes that expired before the given
+//time, which nobody is told about any more.
+func (repo *repository) DeleteNotificationsBefore(ctx context.Context, expired time.Time) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, DeleteNotificationsBeforeQuery, storedTime(expired))
+	if err != nil {
+		fmt.Println("got an error on the delete query:" + err.Error())
+		fcerr := dbError(ctx, "Error while deleting old notifications from the database")
+		return fcerr
+	}
+	return nil
+}
+
+//DeleteUserNotifications(userID int) deletes every notification the user was sent.
+func (repo *repository) DeleteUserNotifications(ctx context.Context, userID int) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, DeleteUserNotificationsQuery, userID)
+	if err != nil {
+		fmt.Println("got an error on the delete query:" + err.Error())
+		fcerr := dbError(ctx, "Error while deleting the user's notifications from the database")
+		return fcerr
+	}
+	return nil
+}
+
+//GetRemovals(householdID int, since time.Time) gives the dishes the household removed since the given time, oldest
+//first. It gives an empty list if there are none.
+func (repo *repository) GetRemovals(ctx context.Context, householdID int, since time.Time) (*dish.Removals, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetRemovalsQuery)
+	rows, err := repo.db.QueryContext(ctx, GetRemovalsQuery, householdID, storedTime(since))
+	if err != nil {
+		fmt.Println("got an error on the Query:", err.Error())
+		fcerr := dbError(ctx, "Error while retrieving removed dishes from the database")
+		return nil, fcerr
+	}
+	defer rows.Close()
+
+	removals := dish.Removals{}
+	for rows.Next() {
+		var cRemoval dish.Removal
+		err := rows.Scan(&cRemoval.RemovalID, &cRemoval.HouseholdID, &cRemoval.UserID, &cRemoval.StorageID, &cRemoval.Title,
+			dbTime{&cRemoval.ExpireDate}, dbTime{&cRemoval.RemovedDate}, &cRemoval.Outcome)
+		if err != nil {
+			fmt.Println("got an error from the rows.Scan.")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
+			return nil, fcerr
+		}
+		removals = append(removals, cRemoval)
+	}
+	return &removals, nil
+}
+
+//CreateRemoval(r dish.Removal) records that a dish left its household, and whether it was eaten or wasted.
+func (repo *repository) CreateRemoval(ctx context.Context, r dish.Removal) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, CreateRemovalQuery, r.HouseholdID, r.UserID, r.StorageID, r.Title,
+		storedTime(r.ExpireDate), storedTime(r.RemovedDate), r.Outcome)
+	if err != nil {
+		fmt.Println("got an error on the Query:" + err.Error())
+		fcerr := dbError(ctx, "Error while inserting the dish removal into the database")
+		return fcerr
+	}
+	return nil
+}
+
+//GetWebhooks(userID int) gives the user's webhook subscriptions, oldest first, or an empty list if they have none.
+func (repo *repository) GetWebhooks(ctx context.Context, userID int) (*webhook.Subscriptions, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetWebhooksQuery)
+	subscriptions, fcErr := repo.queryWebhooks(ctx, GetWebhooksQuery, userID)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return &subscriptions, nil
+}
+
+//GetWebhookByID(userID int, id int) gets the user's webhook subscription with the given id.
+func (repo *repository) GetWebhookByID(ctx context.Context, userID int, id int) (*webhook.Subscription, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetWebhookByIDQuery)
+	return repo.getWebhook(ctx, GetWebhookByIDQuery, "Database could not find a webhook with this ID", userID, id)
+}
+
+//GetHouseholdWebhooks(householdID int) gives the active webhook subscriptions of everyone in the household, oldest first.
+func (repo *repository) GetHouseholdWebhooks(ctx context.Context, householdID int) (*webhook.Subscriptions, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetHouseholdWebhooksQuery)
+	subscriptions, fcErr := repo.queryWebhooks(ctx, GetHouseholdWebhooksQuery, householdID)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return &subscriptions, nil
+}
+
+//getWebhook runs a query that should find one webhook subscription.
+func (repo *repository) getWebhook(ctx context.Context, query string, notFound string, args ...interface{}) (*webhook.Subscription, fcerr.FCErr) {
+	subscriptions, fcErr := repo.queryWebhooks(ctx, query, args...)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	if len(subscriptions) > 1 {
+		return nil, fcerr.NewInternalServerError("Database returned more than 1 row when only 1 was expected")
+	}
+	if len(subscriptions) == 0 {
+		return nil, fcerr.NewNotFoundError(notFound)
+	}
+	return &subscriptions[0], nil
+}
+
+//queryWebhooks scans every webhook subscription the query gives.
+func (repo *repository) queryWebhooks(ctx context.Context, query string, args ...interface{}) (webhook.Subscriptions, fcerr.FCErr) {
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		fmt.Println("got an error on the Query:", err.Error())
+		fcerr := dbError(ctx, "Error while retrieving webhooks from the database")
+		return nil, fcerr
+	}
+	defer rows.Close()
+
+	subscriptions := webhook.Subscriptions{}
+	for rows.Next() {
+		var cSubscription webhook.Subscription
+		var events string
+		err := rows.Scan(&cSubscription.SubscriptionID, &cSubscription.UserID, &cSubscription.URL, &cSubscription.Secret,
+			&events, &cSubscription.Active, dbTime{&cSubscription.CreatedDate}, &cSubscription.TempMatch)
+		if err != nil {
+			fmt.Println("got an error from the rows.Scan.")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
+			return nil, fcerr
+		}
+		cSubscription.Events = webhook.SplitEvents(events)
+		subscriptions = append(subscriptions, cSubscription)
+	}
+	return subscriptions, nil
+}
+
+//CreateWebhook(s webhook.Subscription) adds the webhook subscription and gives it back with its new id.
+func (repo *repository) CreateWebhook(ctx context.Context, s webhook.Subscription) (*webhook.Subscription, fcerr.FCErr) {
+	tMatch := generateTempMatch()
+	fmt.Println("About to run this Query on the database:\n", CreateWebhookQuery)
+
+	_, err := repo.db.ExecContext(ctx, CreateWebhookQuery, s.UserID, s.URL, s.Secret, webhook.JoinEvents(s.Events), s.Active,
+		storedTime(s.CreatedDate), tMatch)
+	if err != nil {
+		fmt.Println("got an error on the Query:" + err.Error())
+		fcerr := dbError(ctx, "Error while inserting the webhook into the database")
+		return nil, fcerr
+	}
+
+	checkSubscription, err := repo.getWebhook(ctx, GetWebhookByTempMatchQuery, "", tMatch)
+	if err != nil {
+		fmt.Println("Trying to CreateWebhook, got an error when checking what we just put in: " + err.Error())
+		fcerr := dbError(ctx, "Error while checking the webhook that was created."+
+			" Cannot verify if anything was entered to the Database")
+		return nil, fcerr
+	}
+	return checkSubscription, nil
+}
+
+//UpdateWebhook(s webhook.Subscription) saves the subscription's url, events and whether it is active.
+func (repo *repository) UpdateWebhook(ctx context.Context, s webhook.Subscription) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, UpdateWebhookQuery, s.URL, webhook.JoinEvents(s.Events), s.Active, s.UserID, s.SubscriptionID)
+	if err != nil {
+		fmt.Println("got an error on the update query:" + err.Error())
+		fcerr := dbError(ctx, "Error while updating the webhook in the database")
+		return fcerr
+	}
+	return nil
+}
+
+//DeleteWebhook(userID int, id int) deletes the user's webhook subscription and its deliveries, or gives a 404 if the user
+//has no subscription with this id.
+func (repo *repository) DeleteWebhook(ctx context.Context, userID int, id int) fcerr.FCErr {
+	if _, err := repo.db.ExecContext(ctx, DeleteWebhookDeliveriesQuery, userID, id); err != nil {
+		fmt.Println("got an error on the delete query:" + err.Error())
+		fcerr := dbError(ctx, "Error while deleting the webhook's deliveries from the database")
+		return fcerr
+	}
+	result, err := repo.db.ExecContext(ctx, DeleteWebhookQuery, userID, id)
+	if err != nil {
+		fmt.Println("got an error on the delete query:" + err.Error())
+		fcerr := dbError(ctx, "Error while deleting the webhook from the database")
+		return fcerr
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return fcerr.NewNotFoundError("Database could not find a webhook with this id")
+	}
+	return nil
+}
+
+//DeleteUserWebhooks(userID int) deletes every webhook subscription the user has, and their deliveries.
+func (repo *repository) DeleteUserWebhooks(ctx context.Context, userID int) fcerr.FCErr {
+	for _, statement := range []string{DeleteUserDeliveriesQuery, DeleteUserWebhooksQuery} {
+		if _, err := repo.db.ExecContext(ctx, statement, userID); err != nil {
+			fmt.Println("got an error on the delete query:" + err.Error())
+			fcerr := dbError(ctx, "Error while deleting the user's webhooks from the database")
+			return fcerr
+		}
+	}
+	return nil
+}
+
+//GetDeliveries(userID int, subscriptionID int) gives the latest deliveries to the user's webhook subscription, newest
+//first, or an empty list if there are none.
+func (repo *repository) GetDeliveries(ctx context.Context, userID int, subscriptionID int) (*webhook.Deliveries, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetDeliveriesQuery)
+	deliveries, fcErr := repo.queryDeliveries(ctx, GetDeliveriesQuery, userID, subscriptionID, MaxDeliveries)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return &deliveries, nil
+}
+
+//GetDeliveriesByStatus(userID int, status string) gives the latest deliveries to any of the user's webhook
+//subscriptions that are in the given state, newest first, or an empty list if there are none.
+func (repo *repository) GetDeliveriesByStatus(ctx context.Context, userID int, status string) (*webhook.Deliveries, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetDeliveriesByStatusQuery)
+	deliveries, fcErr := repo.queryDeliveries(ctx, GetDeliveriesByStatusQuery, userID, status, MaxDeliveries)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return &deliveries, nil
+}
+
+//GetDeliveryByID(userID int, id int) gets the delivery with the given id to one of the user's webhook subscriptions.
+func (repo *repository) GetDeliveryByID(ctx context.Context, userID int, id int) (*webhook.Delivery, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetDeliveryByIDQuery)
+	return repo.getDelivery(ctx, GetDeliveryByIDQuery, "Database could not find a delivery with this ID", userID, id)
+}
+
+//GetDueDeliveries(now time.Time) gives the pending deliveries, to anyone, whose next attempt is due by now, those that
+//have waited longest first.
+func (repo *repository) GetDueDeliveries(ctx context.Context, now time.Time) (*webhook.Deliveries, fcerr.FCErr) {
+	fmt.Println("About to run this Query on the database:\n", GetDueDeliveriesQuery)
+	deliveries, fcErr := repo.queryDeliveries(ctx, GetDueDeliveriesQuery, webhook.Pending, storedTime(now), MaxDueDeliveries)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return &deliveries, nil
+}
+
+//getDelivery runs a query that should find one delivery.
+func (repo *repository) getDelivery(ctx context.Context, query string, notFound string, args ...interface{}) (*webhook.Delivery, fcerr.FCErr) {
+	deliveries, fcErr := repo.queryDeliveries(ctx, query, args...)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	if len(deliveries) > 1 {
+		return nil, fcerr.NewInternalServerError("Database returned more than 1 row when only 1 was expected")
+	}
+	if len(deliveries) == 0 {
+		return nil, fcerr.NewNotFoundError(notFound)
+	}
+	return &deliveries[0], nil
+}
+
+//queryDeliveries scans every delivery the query gives.
+func (repo *repository) queryDeliveries(ctx context.Context, query string, args ...interface{}) (webhook.Deliveries, fcerr.FCErr) {
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		fmt.Println("got an error on the Query:", err.Error())
+		fcerr := dbError(ctx, "Error while retrieving webhook deliveries from the database")
+		return nil, fcerr
+	}
+	defer rows.Close()
+
+	deliveries := webhook.Deliveries{}
+	for rows.Next() {
+		var cDelivery webhook.Delivery
+		err := rows.Scan(&cDelivery.DeliveryID, &cDelivery.SubscriptionID, &cDelivery.UserID, &cDelivery.EventID,
+			&cDelivery.EventType, &cDelivery.Payload, &cDelivery.Status, &cDelivery.Attempts, &cDelivery.ResponseCode,
+			&cDelivery.LastError, dbTime{&cDelivery.NextAttempt}, dbTime{&cDelivery.CreatedDate}, dbTime{&cDelivery.UpdatedDate},
+			&cDelivery.TempMatch)
+		if err != nil {
+			fmt.Println("got an error from the rows.Scan.")
+			fcerr := dbError(ctx, "Error while scanning the result from the database")
+			return nil, fcerr
+		}
+		deliveries = append(deliveries, cDelivery)
+	}
+	return deliveries, nil
+}
+
+//CreateDelivery(d webhook.Delivery) queues an event to be posted to a webhook subscription, and gives it back with its
+//new id.
+func (repo *repository) CreateDelivery(ctx context.Context, d webhook.Delivery) (*webhook.Delivery, fcerr.FCErr) {
+	tMatch := generateTempMatch()
+	fmt.Println("About to run this Query on the database:\n", CreateDeliveryQuery)
+
+	_, err := repo.db.ExecContext(ctx, CreateDeliveryQuery, d.SubscriptionID, d.UserID, d.EventID, d.EventType, d.Payload,
+		d.Status, d.Attempts, d.ResponseCode, d.LastError, storedTime(d.NextAttempt), storedTime(d.CreatedDate),
+		storedTime(d.UpdatedDate), tMatch)
+	if err != nil {
+		fmt.Println("got an error on the Query:" + err.Error())
+		fcerr := dbError(ctx, "Error while inserting the webhook delivery into the database")
+		return nil, fcerr
+	}
+
+	checkDelivery, err := repo.getDelivery(ctx, GetDeliveryByTempMatchQuery, "", tMatch)
+	if err != nil {
+		fmt.Println("Trying to CreateDelivery, got an error when checking what we just put in: " + err.Error())
+		fcerr := dbError(ctx, "Error while checking the webhook delivery that was created."+
+			" Cannot verify if anything was entered to the Database")
+		return nil, fcerr
+	}
+	return checkDelivery, nil
+}
+
+//UpdateDelivery(d webhook.Delivery) saves how the delivery's latest attempt went and when it is next due.
+func (repo *repository) UpdateDelivery(ctx context.Context, d webhook.Delivery) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, UpdateDeliveryQuery, d.Status, d.Attempts, d.ResponseCode, d.LastError,
+		storedTime(d.NextAttempt), storedTime(d.UpdatedDate), d.DeliveryID)
+	if err != nil {
+		fmt.Println("got an error on the update query:" + err.Error())
+		fcerr := dbError(ctx, "Error while updating the webhook delivery in the database")
+		return fcerr
+	}
+	return nil
+}
+
+//DeleteDeliveriesBefore(before time.Time) forgets the deliveries that were delivered or given up on before the given
+//time. Pending ones are kept however old they are.
+func (repo *repository) DeleteDeliveriesBefore(ctx context.Context, before time.Time) fcerr.FCErr {
+	_, err := repo.db.ExecContext(ctx, DeleteDeliveriesBeforeQuery, webhook.Pending, storedTime(before))
+	if err != nil {
+		fmt.Println("got an error on the delete query:" + err.Error())
+		fcerr := dbError(ctx, "Error while deleting old webhook deliveries from the database")
+		return fcerr
+	}
+	return nil
+}
+
 func generateTempMatch() string {
 	n := make([]byte, 15)
 	rand.Read(n)
@@ -1,14 +1,20 @@
 package db
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/notification"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/session"
 	"github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
 	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/webhook"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -18,11 +24,12 @@ var nD = &dish.Dish{
 	DishID:         360,
 	PersonalDishID: 2,
 	UserID:         2,
+	HouseholdID:    2,
 	StorageID:      1,
 	Title:          "Carrots",
 	Description:    "Some carrots we got at the store",
-	CreatedDate:    "2006-01-02T15:04:05",
-	ExpireDate:     "2020-10-13T08:00",
+	CreatedDate:    time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+	ExpireDate:     time.Date(2020, 10, 13, 8, 0, 0, 0, time.UTC),
 	Priority:       "",
 	DishType:       "",
 	Portions:       -1,
@@ -33,11 +40,12 @@ var nDex = &dish.Dish{
 	DishID:         4,
 	PersonalDishID: 1,
 	UserID:         2,
+	HouseholdID:    2,
 	StorageID:      1,
 	Title:          "Old Carrots",
 	Description:    "Some carrots we got at the store last year",
-	CreatedDate:    "2006-01-02T15:04:05",
-	ExpireDate:     "2019-10-13T08:00",
+	CreatedDate:    time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+	ExpireDate:     time.Date(2019, 10, 13, 8, 0, 0, 0, time.UTC),
 	Priority:       "",
 	DishType:       "",
 	Portions:       -1,
@@ -53,7 +61,6 @@ var nU = &user.User{
 	CreatedDate:  "2016-01-02T15:04:05",
 	AccessToken:  "ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 	RefreshToken: "105i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-	AlexaUserID:  "qwertyuiop",
 	Admin:        false,
 	TempMatch:    "1v842d234523a",
 }
@@ -62,11 +69,20 @@ var nS = &storage.Storage{
 	StorageID:   5,
 	PersonalID:  1,
 	UserID:      2,
+	HouseholdID: 2,
 	Title:       "Fridge",
 	Description: "The main fridge in the house",
 	TempMatch:   "Eb2iev8zpxgy-dxe",
 }
 
+//trickyStrings are values that used to break the hand-built queries - they now have to round-trip untouched.
+var trickyStrings = []string{
+	`Mom's "best" chili`,
+	`C:\fridge\shelf\2 \" still one value`,
+	`'); DROP TABLE dish; --`,
+	"Crème brûlée 🍮 日本の漬物",
+}
+
 func TestDb_NewRepository_CantConnect(t *testing.T) {
 	_, err := NewRepository("")
 
@@ -86,15 +102,15 @@ func TestDb_GetDishes(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description,
-			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch).
+			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID).
 		AddRow(nD.DishID+200, nD.PersonalDishID+1, nD.UserID, nD.StorageID, nD.Title, nD.Description,
-			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishesBase, nU.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetDishesQuery).WithArgs(nU.UserID).WillReturnRows(rows)
 
-	resultingDishes, err := repo.GetDishes(nU.UserID)
+	resultingDishes, err := repo.GetDishes(context.Background(), nU.UserID)
 
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(*resultingDishes))
@@ -114,11 +130,11 @@ func TestDb_GetDishes_NotFound(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"})
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"})
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishesBase, nU.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetDishesQuery).WithArgs(nU.UserID).WillReturnRows(rows)
 
-	resultingDishes, err := repo.GetDishes(nU.UserID)
+	resultingDishes, err := repo.GetDishes(context.Background(), nU.UserID)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingDishes)
@@ -135,8 +151,8 @@ func TestDb_GetDishes_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishesBase, nU.UserID)).WillReturnError(errors.New("database error"))
-	resultingDishes, err := repo.GetDishes(nU.UserID)
+	mock.ExpectQuery(GetDishesQuery).WithArgs(nU.UserID).WillReturnError(errors.New("database error"))
+	resultingDishes, err := repo.GetDishes(context.Background(), nU.UserID)
 
 	assert.Nil(t, resultingDishes)
 	assert.NotNil(t, err)
@@ -154,12 +170,12 @@ func TestDb_GetDishes_RowScanError(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
-		AddRow("SHOULDBEINT", 1, 1, 3, "Carrots", "Some carrots we got at the store", "2006-01-02T15:04:05", "2020-10-13T08:00", 1, "", -1, "")
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
+		AddRow("SHOULDBEINT", 1, 1, 3, "Carrots", "Some carrots we got at the store", "2006-01-02 15:04:05", "2020-10-13 08:00:00", 1, "", -1, "", 1)
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishesBase, nU.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetDishesQuery).WithArgs(nU.UserID).WillReturnRows(rows)
 
-	resultingDishes, err := repo.GetDishes(nU.UserID)
+	resultingDishes, err := repo.GetDishes(context.Background(), nU.UserID)
 
 	assert.Nil(t, resultingDishes)
 	assert.NotNil(t, err)
@@ -167,6 +183,52 @@ func TestDb_GetDishes_RowScanError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
 }
 
+func TestDb_FindDishes(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
+		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description,
+			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
+
+	storageID := 1
+	q := dish.Query{StorageID: &storageID, DishType: "Vegetable", Priority: "medium", Search: "car_",
+		ExpiresAfter: time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC), Sort: dish.SortExpireDate, Descending: true, Limit: 11,
+		After: &dish.Cursor{Sort: dish.SortExpireDate, Descending: true, Key: "2020-10-20 08:00:00", ID: 400}}
+	mock.ExpectQuery(GetDishesQuery+` AND storage_id = ? AND LOWER(dish_type) = ? AND `+PriorityRankSQL+` = ?`+
+		` AND expire_date > ? AND title LIKE ? ESCAPE '!'`+
+		` AND (COALESCE(expire_date, '') < ? OR (COALESCE(expire_date, '') = ? AND id < ?)) ORDER BY COALESCE(expire_date, '') DESC, id DESC LIMIT ?`).
+		WithArgs(nD.HouseholdID, 1, "vegetable", 1, "2020-10-01 00:00:00", "%car!_%",
+			"2020-10-20 08:00:00", "2020-10-20 08:00:00", 400, 11).
+		WillReturnRows(rows)
+
+	resultingDishes, err := repo.FindDishes(context.Background(), nD.HouseholdID, q)
+
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(*resultingDishes)) {
+		assert.Equal(t, *nD, (*resultingDishes)[0])
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	mock.ExpectQuery(GetDishesQuery+` AND `+PriorityRankSQL+` = ?`+
+		` AND (`+PriorityRankSQL+` > ? OR (`+PriorityRankSQL+` = ? AND id > ?)) ORDER BY `+PriorityRankSQL+`, id`).
+		WithArgs(nD.HouseholdID, 0, 0, 0, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	resultingDishes, err = repo.FindDishes(context.Background(), nD.HouseholdID, dish.Query{Priority: "urgent",
+		Sort: dish.SortPriority, After: &dish.Cursor{Sort: dish.SortPriority, Key: "0", ID: 7}})
+
+	assert.Nil(t, err, "finding nothing isn't an error")
+	assert.Equal(t, 0, len(*resultingDishes))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
 func TestDb_GetDishByID(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
@@ -177,13 +239,13 @@ func TestDb_GetDishByID(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description,
-			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishByIDBase, nD.UserID, nD.PersonalDishID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetDishByIDQuery).WithArgs(nD.UserID, nD.PersonalDishID).WillReturnRows(rows)
 
-	resultingDish, err := repo.GetDishByID(nD.UserID, nD.PersonalDishID)
+	resultingDish, err := repo.GetDishByID(context.Background(), nD.UserID, nD.PersonalDishID)
 
 	assert.Nil(t, err)
 
@@ -199,9 +261,9 @@ func TestDb_GetDishByID_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishByIDBase, nD.UserID, nD.PersonalDishID)).WillReturnError(errors.New("database error"))
+	mock.ExpectQuery(GetDishByIDQuery).WithArgs(nD.UserID, nD.PersonalDishID).WillReturnError(errors.New("database error"))
 
-	resultingDish, err := repo.GetDishByID(nD.UserID, nD.PersonalDishID)
+	resultingDish, err := repo.GetDishByID(context.Background(), nD.UserID, nD.PersonalDishID)
 
 	assert.Nil(t, resultingDish)
 	assert.NotNil(t, err)
@@ -220,11 +282,11 @@ func TestDb_GetDishByID_NotFound(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"})
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"})
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishByIDBase, nD.UserID, nD.PersonalDishID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetDishByIDQuery).WithArgs(nD.UserID, nD.PersonalDishID).WillReturnRows(rows)
 
-	resultingDish, err := repo.GetDishByID(nD.UserID, nD.PersonalDishID)
+	resultingDish, err := repo.GetDishByID(context.Background(), nD.UserID, nD.PersonalDishID)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingDish)
@@ -243,13 +305,13 @@ func TestDb_GetDishByID_RowScanError(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, "SHOULDBEINT", nD.Title, nD.Description,
-			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishByIDBase, nD.UserID, nD.PersonalDishID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetDishByIDQuery).WithArgs(nD.UserID, nD.PersonalDishID).WillReturnRows(rows)
 
-	resultingDish, err := repo.GetDishByID(nD.UserID, nD.PersonalDishID)
+	resultingDish, err := repo.GetDishByID(context.Background(), nD.UserID, nD.PersonalDishID)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingDish)
@@ -268,15 +330,15 @@ func TestDb_GetDishByID_FoundMultiple(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description,
-			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch).
+			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID).
 		AddRow(nD.DishID+1, nD.PersonalDishID+1, nD.UserID, nD.StorageID, nD.Title, nD.Description,
-			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishByIDBase, nD.UserID, nD.PersonalDishID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetDishByIDQuery).WithArgs(nD.UserID, nD.PersonalDishID).WillReturnRows(rows)
 
-	resultingDish, err := repo.GetDishByID(nD.UserID, nD.PersonalDishID)
+	resultingDish, err := repo.GetDishByID(context.Background(), nD.UserID, nD.PersonalDishID)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingDish)
@@ -295,12 +357,12 @@ func TestDb_GetDishByTempMatch(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
-		AddRow(1, 1, 2, 3, "Carrots", "Some carrots we got at the store", "2006-01-02T15:04:05", "2020-10-13T08:00", 1, "", -1, "9r842da351")
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
+		AddRow(1, 1, 2, 3, "Carrots", "Some carrots we got at the store", "2006-01-02 15:04:05", "2020-10-13 08:00:00", 1, "", -1, "9r842da351", 2)
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishByTempMatchBase, "9r842da351")).WillReturnRows(rows)
+	mock.ExpectQuery(GetDishByTempMatchQuery).WithArgs("9r842da351").WillReturnRows(rows)
 
-	resultingDish, err := repo.GetDishByTempMatch("9r842da351")
+	resultingDish, err := repo.GetDishByTempMatch(context.Background(), "9r842da351")
 
 	assert.Nil(t, err)
 
@@ -321,11 +383,11 @@ func TestDb_GetDishByTempMatch_NotFound(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"})
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"})
 
-	mock.ExpectQuery(`SELECT * FROM dish WHERE temp_match = "9r842da351"`).WillReturnRows(rows)
+	mock.ExpectQuery(GetDishByTempMatchQuery).WithArgs("9r842da351").WillReturnRows(rows)
 
-	resultingDish, err := repo.GetDishByTempMatch("9r842da351")
+	resultingDish, err := repo.GetDishByTempMatch(context.Background(), "9r842da351")
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingDish)
@@ -344,12 +406,12 @@ func TestDb_GetDishByTempMatch_RowScanError(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
-		AddRow(1, 2, "SHOULD BE INT", 3, "Carrots", "Some carrots we got at the store", "2006-01-02T15:04:05", "2020-10-13T08:00", 1, "", -1, "")
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
+		AddRow(1, 2, "SHOULD BE INT", 3, "Carrots", "Some carrots we got at the store", "2006-01-02 15:04:05", "2020-10-13 08:00:00", 1, "", -1, "", 2)
 
-	mock.ExpectQuery(`SELECT * FROM dish WHERE temp_match = "9r842da351"`).WillReturnRows(rows)
+	mock.ExpectQuery(GetDishByTempMatchQuery).WithArgs("9r842da351").WillReturnRows(rows)
 
-	resultingDish, err := repo.GetDishByTempMatch("9r842da351")
+	resultingDish, err := repo.GetDishByTempMatch(context.Background(), "9r842da351")
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingDish)
@@ -369,13 +431,13 @@ func TestDb_GetDishByTempMatch_FoundMultiple(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
-		AddRow(1, 1, 2, 3, "Carrots", "Some carrots we got at the store", "2006-01-02T15:04:05", "2020-10-13T08:00", 1, "", -1, "9r842da351").
-		AddRow(4, 1, 2, 3, "Carrots", "Some carrots we got at the store a second time", "2006-01-02T15:04:05", "2020-10-13T08:00", 1, "", -1, "9r842da351")
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
+		AddRow(1, 1, 2, 3, "Carrots", "Some carrots we got at the store", "2006-01-02 15:04:05", "2020-10-13 08:00:00", 1, "", -1, "9r842da351", 2).
+		AddRow(4, 1, 2, 3, "Carrots", "Some carrots we got at the store a second time", "2006-01-02 15:04:05", "2020-10-13 08:00:00", 1, "", -1, "9r842da351", 2)
 
-	mock.ExpectQuery(`SELECT * FROM dish WHERE temp_match = "9r842da351"`).WillReturnRows(rows)
+	mock.ExpectQuery(GetDishByTempMatchQuery).WithArgs("9r842da351").WillReturnRows(rows)
 
-	resultingDish, err := repo.GetDishByTempMatch("9r842da351")
+	resultingDish, err := repo.GetDishByTempMatch(context.Background(), "9r842da351")
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingDish)
@@ -386,7 +448,7 @@ func TestDb_GetDishByTempMatch_FoundMultiple(t *testing.T) {
 
 func TestDb_CreateDish(t *testing.T) {
 
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
 	}
@@ -397,31 +459,34 @@ func TestDb_CreateDish(t *testing.T) {
 	nD := &dish.Dish{
 		PersonalDishID: 1,
 		UserID:         2,
+		HouseholdID:    2,
 		StorageID:      3,
 		Title:          "Carrots",
 		Description:    "Some carrots we got at the store",
-		CreatedDate:    "2006-01-02T15:04:05",
-		ExpireDate:     "2020-10-13T08:00",
+		CreatedDate:    time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+		ExpireDate:     time.Date(2020, 10, 13, 8, 0, 0, 0, time.UTC),
 		Priority:       "",
 		DishType:       "",
 		Portions:       -1,
 		TempMatch:      "9r842d3a351",
 	}
 
-	createRows := sqlmock.NewRows([]string{""})
-
 	getRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(5, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
 
-	mock.ExpectQuery(`INSERT INTO dish \(personal_id, user_id, storage_id, title, description, created_date, expire_date, priority, dish_type, portions, temp_match\) VALUES\(1, 2, 3, ".+", ".+", ".+", ".+", "", "", -1, ".+"\)`).
-		WillReturnRows(createRows)
+	mock.ExpectBegin()
 
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE temp_match = ".+"`).
+	mock.ExpectExec(CreateDishQuery).WithArgs(1, 2, 3, nD.Title, nD.Description, storedDate(nD.CreatedDate), storedDate(nD.ExpireDate), "", "", -1, sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+
+	mock.ExpectQuery(GetDishByTempMatchQuery).WithArgs(sqlmock.AnyArg()).
 		WillReturnRows(getRows)
 
-	returnedDish, err := repo.CreateDish(*nD)
+	mock.ExpectCommit()
+
+	returnedDish, err := repo.CreateDish(context.Background(), *nD)
 
 	assert.Nil(t, err)
 
@@ -430,8 +495,57 @@ func TestDb_CreateDish(t *testing.T) {
 	assert.Equal(t, nD.Title, returnedDish.Title)
 }
 
+func TestDb_CreateDish_SpecialCharacters(t *testing.T) {
+	for _, tricky := range trickyStrings {
+		db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		if testerr != nil {
+			t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+		}
+
+		repo := &repository{db: db}
+
+		nD := &dish.Dish{
+			PersonalDishID: 1,
+			UserID:         2,
+			HouseholdID:    2,
+			StorageID:      3,
+			Title:          tricky,
+			Description:    tricky + " - description",
+			CreatedDate:    time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+			ExpireDate:     time.Date(2020, 10, 13, 8, 0, 0, 0, time.UTC),
+			Priority:       tricky,
+			DishType:       tricky,
+			Portions:       2,
+		}
+
+		getRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
+			"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
+			AddRow(5, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
+				nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, "9r842d3a351", nD.HouseholdID)
+
+		mock.ExpectBegin()
+
+		mock.ExpectExec(CreateDishQuery).WithArgs(nD.PersonalDishID, nD.UserID, nD.StorageID, tricky, tricky+" - description",
+			storedDate(nD.CreatedDate), storedDate(nD.ExpireDate), tricky, tricky, nD.Portions, sqlmock.AnyArg(), nD.HouseholdID).
+			WillReturnResult(sqlmock.NewResult(5, 1))
+
+		mock.ExpectQuery(GetDishByTempMatchQuery).WithArgs(sqlmock.AnyArg()).WillReturnRows(getRows)
+
+		mock.ExpectCommit()
+
+		returnedDish, err := repo.CreateDish(context.Background(), *nD)
+
+		assert.Nil(t, err)
+		assert.NotNil(t, returnedDish)
+		assert.Equal(t, tricky, returnedDish.Title)
+		assert.Equal(t, tricky+" - description", returnedDish.Description)
+		assert.Nil(t, mock.ExpectationsWereMet())
+		db.Close()
+	}
+}
+
 func TestDb_CreateDish_InsertError(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
 	}
@@ -442,21 +556,26 @@ func TestDb_CreateDish_InsertError(t *testing.T) {
 	nD := &dish.Dish{
 		PersonalDishID: 1,
 		UserID:         2,
+		HouseholdID:    2,
 		StorageID:      3,
 		Title:          "Carrots",
 		Description:    "Some carrots we got at the store",
-		CreatedDate:    "2006-01-02T15:04:05",
-		ExpireDate:     "2020-10-13T08:00",
+		CreatedDate:    time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+		ExpireDate:     time.Date(2020, 10, 13, 8, 0, 0, 0, time.UTC),
 		Priority:       "",
 		DishType:       "",
 		Portions:       -1,
 		TempMatch:      "9r842d3a351",
 	}
 
-	mock.ExpectQuery(`INSERT INTO dish \(personal_id, user_id, storage_id, title, description, created_date, expire_date, priority, dish_type, portions, temp_match\) VALUES\(2, 3, ".+", ".+", ".+", ".+", "", "", -1, ".+"\)`).
+	mock.ExpectBegin()
+
+	mock.ExpectExec(CreateDishQuery).WithArgs(1, 2, 3, nD.Title, nD.Description, storedDate(nD.CreatedDate), storedDate(nD.ExpireDate), "", "", -1, sqlmock.AnyArg(), 2).
 		WillReturnError(errors.New("not possible"))
 
-	returnedDish, err := repo.CreateDish(*nD)
+	mock.ExpectRollback()
+
+	returnedDish, err := repo.CreateDish(context.Background(), *nD)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, returnedDish)
@@ -465,7 +584,7 @@ func TestDb_CreateDish_InsertError(t *testing.T) {
 }
 
 func TestDb_CreateDish_CheckError(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
 	}
@@ -476,26 +595,29 @@ func TestDb_CreateDish_CheckError(t *testing.T) {
 	nD := &dish.Dish{
 		PersonalDishID: 1,
 		UserID:         2,
+		HouseholdID:    2,
 		StorageID:      3,
 		Title:          "Carrots",
 		Description:    "Some carrots we got at the store",
-		CreatedDate:    "2006-01-02T15:04:05",
-		ExpireDate:     "2020-10-13T08:00",
+		CreatedDate:    time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+		ExpireDate:     time.Date(2020, 10, 13, 8, 0, 0, 0, time.UTC),
 		Priority:       "",
 		DishType:       "",
 		Portions:       -1,
 		TempMatch:      "9r842d3a351",
 	}
 
-	createRows := sqlmock.NewRows([]string{""})
+	mock.ExpectBegin()
 
-	mock.ExpectQuery(`INSERT INTO dish \(personal_id, user_id, storage_id, title, description, created_date, expire_date, priority, dish_type, portions, temp_match\) VALUES\(2, 3, ".+", ".+", ".+", ".+", "", "", -1, ".+"\)`).
-		WillReturnRows(createRows)
+	mock.ExpectExec(CreateDishQuery).WithArgs(1, 2, 3, nD.Title, nD.Description, storedDate(nD.CreatedDate), storedDate(nD.ExpireDate), "", "", -1, sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(5, 1))
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishByTempMatchBase, `.+`)).
+	mock.ExpectQuery(GetDishByTempMatchQuery).WithArgs(sqlmock.AnyArg()).
 		WillReturnError(errors.New("not possible"))
 
-	returnedDish, err := repo.CreateDish(*nD)
+	mock.ExpectRollback()
+
+	returnedDish, err := repo.CreateDish(context.Background(), *nD)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, returnedDish)
@@ -511,25 +633,55 @@ func TestDb_UpdateDish(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	createRows := sqlmock.NewRows([]string{""})
-
 	getRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(2, 1, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(UpdateDishBase, nD.PersonalDishID, nD.StorageID, nD.Title,
-		nD.Description, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.DishID)).
-		WillReturnRows(createRows)
+	mock.ExpectExec(UpdateDishQuery).WithArgs(nD.PersonalDishID, nD.StorageID, nD.Title,
+		nD.Description, storedDate(nD.ExpireDate), nD.Priority, nD.DishType, nD.Portions, nD.DishID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishByIDBase, nD.UserID, nD.PersonalDishID)).WillReturnRows(getRows)
+	mock.ExpectQuery(GetDishByIDQuery).WithArgs(nD.UserID, nD.PersonalDishID).WillReturnRows(getRows)
 
-	err := repo.UpdateDish(*nD)
+	err := repo.UpdateDish(context.Background(), *nD)
 
 	assert.Nil(t, err)
 
 }
 
+func TestDb_UpdateDish_SpecialCharacters(t *testing.T) {
+	for _, tricky := range trickyStrings {
+		db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		if testerr != nil {
+			t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+		}
+
+		repo := &repository{db: db}
+
+		updatedDish := *nD
+		updatedDish.Title = tricky
+		updatedDish.Description = tricky
+
+		getRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
+			"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
+			AddRow(updatedDish.DishID, updatedDish.PersonalDishID, updatedDish.UserID, updatedDish.StorageID, tricky, tricky,
+				updatedDish.CreatedDate, updatedDish.ExpireDate, updatedDish.Priority, updatedDish.DishType, updatedDish.Portions, updatedDish.TempMatch, updatedDish.HouseholdID)
+
+		mock.ExpectExec(UpdateDishQuery).WithArgs(updatedDish.PersonalDishID, updatedDish.StorageID, tricky, tricky,
+			storedDate(updatedDish.ExpireDate), updatedDish.Priority, updatedDish.DishType, updatedDish.Portions, updatedDish.DishID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		mock.ExpectQuery(GetDishByIDQuery).WithArgs(updatedDish.UserID, updatedDish.PersonalDishID).WillReturnRows(getRows)
+
+		err := repo.UpdateDish(context.Background(), updatedDish)
+
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+		db.Close()
+	}
+}
+
 func TestDb_UpdateDish_QueryError(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
@@ -539,11 +691,11 @@ func TestDb_UpdateDish_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(fmt.Sprintf(UpdateDishBase, nD.PersonalDishID, nD.StorageID, nD.Title,
-		nD.Description, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.DishID)).
+	mock.ExpectExec(UpdateDishQuery).WithArgs(nD.PersonalDishID, nD.StorageID, nD.Title,
+		nD.Description, storedDate(nD.ExpireDate), nD.Priority, nD.DishType, nD.Portions, nD.DishID).
 		WillReturnError(errors.New("database error"))
 
-	err := repo.UpdateDish(*nD)
+	err := repo.UpdateDish(context.Background(), *nD)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
@@ -558,15 +710,13 @@ func TestDb_UpdateDish_CheckError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	createRows := sqlmock.NewRows([]string{""})
+	mock.ExpectExec(UpdateDishQuery).WithArgs(nD.PersonalDishID, nD.StorageID, nD.Title,
+		nD.Description, storedDate(nD.ExpireDate), nD.Priority, nD.DishType, nD.Portions, nD.DishID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(fmt.Sprintf(UpdateDishBase, nD.PersonalDishID, nD.StorageID, nD.Title,
-		nD.Description, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.DishID)).
-		WillReturnRows(createRows)
+	mock.ExpectQuery(GetDishByIDQuery).WithArgs(nD.UserID, nD.PersonalDishID).WillReturnError(errors.New("database error"))
 
-	mock.ExpectQuery(fmt.Sprintf(GetDishByIDBase, nD.UserID, nD.PersonalDishID)).WillReturnError(errors.New("database error"))
-
-	err := repo.UpdateDish(*nD)
+	err := repo.UpdateDish(context.Background(), *nD)
 
 	assert.NotNil(t, err)
 	//assert.Equal(t, http.StatusInternalServerError, err.Status())
@@ -585,17 +735,17 @@ func TestDb_DeleteDish(t *testing.T) {
 	countRow := sqlmock.NewRows([]string{"COUNT(*)"}).
 		AddRow(3)
 
-	deleteRows := sqlmock.NewRows([]string{""})
+	mock.ExpectBegin()
 
-	updateRows := sqlmock.NewRows([]string{""})
+	mock.ExpectQuery(GetPersonalDishCountQuery + ForUpdate).WithArgs(nD.UserID).WillReturnRows(countRow)
 
-	mock.ExpectQuery(fmt.Sprintf(GetPersonalDishCountBase, nD.UserID)).WillReturnRows(countRow)
+	mock.ExpectExec(DeleteDishQuery).WithArgs(nD.UserID, nD.PersonalDishID).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(fmt.Sprintf(DeleteDishBase, nD.UserID, nD.PersonalDishID)).WillReturnRows(deleteRows)
+	mock.ExpectExec(DecrementSomeDishesQuery).WithArgs(nD.UserID, nD.PersonalDishID).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(fmt.Sprintf(DecrementSomeDishesBase, nD.UserID, "3")).WillReturnRows(updateRows)
+	mock.ExpectCommit()
 
-	err := repo.DeleteDish(nD.UserID, nD.PersonalDishID)
+	err := repo.DeleteDish(context.Background(), nD.UserID, nD.PersonalDishID)
 
 	assert.Nil(t, err)
 }
@@ -609,15 +759,18 @@ func TestDb_DeleteDish_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(fmt.Sprintf(GetPersonalDishCountBase, nD.UserID)).WillReturnError(errors.New("database error"))
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(GetPersonalDishCountQuery + ForUpdate).WithArgs(nD.UserID).WillReturnError(errors.New("database error"))
 
-	err := repo.DeleteDish(nU.UserID, nD.PersonalDishID)
+	mock.ExpectRollback()
+
+	err := repo.DeleteDish(context.Background(), nU.UserID, nD.PersonalDishID)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
 }
 
-/* I don't think we need GetUsers for anything...
 func TestDb_GetUsers(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
@@ -628,15 +781,16 @@ func TestDb_GetUsers(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(1, "nothing@gmail.com", "Bob", "Nothing", "Bob Nothing", "2016-01-02T15:04:05",
-			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop", false, "asdfasdfa").
+			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "asdfasdfa", "", "", "", "", false, 0, "", "", "").
 		AddRow(2, "nothing2@gmail.com", "Robert", "Nothingtwo", "Robert Nothingtwo", "2016-02-02T15:04:05",
-			"ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop2", false, "asdfasdfa2")
+			"ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "asdfasdfa2", "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(GetUsersBase).WillReturnRows(rows)
+	mock.ExpectQuery(GetUsersQuery).WillReturnRows(rows)
 
-	resultingUsers, err := repo.GetUsers()
+	resultingUsers, err := repo.GetUsers(context.Background(), "")
 
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(*resultingUsers))
@@ -661,11 +815,12 @@ func TestDb_GetUsers_NotFound(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"})
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"})
 
-	mock.ExpectQuery(GetUsersBase).WillReturnRows(rows)
+	mock.ExpectQuery(GetUsersQuery).WillReturnRows(rows)
 
-	resultingUsers, err := repo.GetUsers()
+	resultingUsers, err := repo.GetUsers(context.Background(), "")
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingUsers)
@@ -682,8 +837,8 @@ func TestDb_GetUsers_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(GetUsersBase).WillReturnError(errors.New("database error"))
-	resultingUsers, err := repo.GetUsers()
+	mock.ExpectQuery(GetUsersQuery).WillReturnError(errors.New("database error"))
+	resultingUsers, err := repo.GetUsers(context.Background(), "")
 
 	assert.Nil(t, resultingUsers)
 	assert.NotNil(t, err)
@@ -701,90 +856,22 @@ func TestDb_GetUsers_RowScanError(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow("SHOULDBEINT", "nothing@gmail.com", "Bob", "Nothing", "Bob Nothing", "2016-01-02T15:04:05",
-			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop", false, "asdfasdfa")
+			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "asdfasdfa", "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(GetUsersBase).WillReturnRows(rows)
+	mock.ExpectQuery(GetUsersQuery).WillReturnRows(rows)
 
-	resultingUsers, err := repo.GetUsers()
+	resultingUsers, err := repo.GetUsers(context.Background(), "")
 
 	assert.Nil(t, resultingUsers)
 	assert.NotNil(t, err)
 	//assert.Equal(t, "Error while scanning the result from the database", err.Message())
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
 }
-*/
-func TestDb_GetUserByID(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	if testerr != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
-	}
-	defer db.Close()
 
-	repo := &repository{db: db}
-
-	nU := &user.User{
-		UserID:       2,
-		Email:        "nothing@gmail.com",
-		FirstName:    "Bob",
-		LastName:     "Nothing",
-		FullName:     "Bob Nothing",
-		CreatedDate:  "2016-01-02T15:04:05",
-		AccessToken:  "ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
-		RefreshToken: "105i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
-		Admin:        false,
-		TempMatch:    "1v842d234523a",
-	}
-
-	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
-		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
-
-	mock.ExpectQuery(fmt.Sprintf(GetUserByIDBase, nU.UserID)).WillReturnRows(rows)
-
-	resultingUser, err := repo.GetUserByID(2)
-
-	assert.Nil(t, err)
-	assert.NotNil(t, resultingUser)
-
-	assert.Equal(t, nU.UserID, resultingUser.UserID)
-	assert.Equal(t, nU.Email, resultingUser.Email)
-	assert.Equal(t, nU.FirstName, resultingUser.FirstName)
-	assert.Equal(t, nU.LastName, resultingUser.LastName)
-	assert.Equal(t, nU.FullName, resultingUser.FullName)
-	assert.Equal(t, nU.CreatedDate, resultingUser.CreatedDate)
-	assert.Equal(t, nU.AccessToken, resultingUser.AccessToken)
-	assert.Equal(t, nU.RefreshToken, resultingUser.RefreshToken)
-	assert.Equal(t, nU.AlexaUserID, resultingUser.AlexaUserID)
-	assert.Equal(t, nU.Admin, resultingUser.Admin)
-	assert.Equal(t, nU.TempMatch, resultingUser.TempMatch)
-
-}
-
-func TestDb_GetUserByID_QueryError(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	if testerr != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
-	}
-	defer db.Close()
-
-	repo := &repository{db: db}
-
-	mock.ExpectQuery(fmt.Sprintf(GetUserByIDBase, 1)).WillReturnError(errors.New("database error"))
-
-	resultingUser, err := repo.GetUserByID(1)
-
-	assert.Nil(t, resultingUser)
-	assert.NotNil(t, err)
-
-	assert.Equal(t, http.StatusInternalServerError, err.Status())
-	//assert.Equal(t, "Error while retrieving user from the database", err.Message())
-}
-
-func TestDb_GetUserByID_NotFound(t *testing.T) {
+func TestDb_GetUsers_Search(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
@@ -794,45 +881,24 @@ func TestDb_GetUserByID_NotFound(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"})
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
+		AddRow(2, "nothing_2@gmail.com", "Robert", "Nothingtwo", "Robert Nothingtwo", "2016-02-02T15:04:05",
+			"", "", false, "asdfasdfa2", "", "", "2022-03-01 12:00:00", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByIDBase, nU.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(SearchUsersQuery).WithArgs("%g!_2!%!!%", "%g!_2!%!!%").WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByID(nU.UserID)
+	resultingUsers, err := repo.GetUsers(context.Background(), "g_2%!")
 
-	assert.NotNil(t, err)
-	assert.Nil(t, resultingUser)
-
-	assert.Equal(t, http.StatusNotFound, err.Status())
-	//assert.Equal(t, "Database could not find a user with this ID", err.Message())
-}
-
-func TestDb_GetUserByID_RowScanError(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	if testerr != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(*resultingUsers)) {
+		assert.False(t, (*resultingUsers)[0].IsActive())
+		assert.Equal(t, time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC), (*resultingUsers)[0].DeactivatedDate)
 	}
-	defer db.Close()
-
-	repo := &repository{db: db}
-
-	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
-		AddRow("SHOULDBEINT", "nothing@gmail.com", "Bob", "Nothing", "Bob Nothing", "2016-01-02T15:04:05",
-			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop", false, "asdfasdfa")
-
-	mock.ExpectQuery(fmt.Sprintf(GetUserByIDBase, 1)).WillReturnRows(rows)
-
-	resultingUser, err := repo.GetUserByID(1)
-
-	assert.NotNil(t, err)
-	assert.Nil(t, resultingUser)
-
-	assert.Equal(t, http.StatusInternalServerError, err.Status())
-	//assert.Equal(t, "Error while scanning the result from the database", err.Message())
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
-func TestDb_GetUserByID_FoundMultiple(t *testing.T) {
+func TestDb_GetDishExpiryCounts(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
@@ -841,25 +907,22 @@ func TestDb_GetUserByID_FoundMultiple(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
-		AddRow(1, "nothing@gmail.com", "Bob", "Nothing", "Bob Nothing", "2016-01-02T15:04:05",
-			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop", false, "asdfasdfa").
-		AddRow(2, "nothing2@gmail.com", "Robert", "Nothingtwo", "Robert Nothingtwo", "2016-02-02T15:04:05",
-			"ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop2", false, "asdfasdfa2")
-
-	mock.ExpectQuery(fmt.Sprintf(GetUserByIDBase, 1)).WillReturnRows(rows)
+	rows := sqlmock.NewRows([]string{"household_id", "expire_date", "COUNT(*)"}).
+		AddRow(1, "2016-01-02 15:04:05", 2).
+		AddRow(2, "2016-01-09 15:04:05", 1)
 
-	resultingUser, err := repo.GetUserByID(1)
+	mock.ExpectQuery(GetDishExpiryCountsQuery).WillReturnRows(rows)
 
-	assert.NotNil(t, err)
-	assert.Nil(t, resultingUser)
+	counts, err := repo.GetDishExpiryCounts(context.Background())
 
-	//assert.Equal(t, "Database returned more than 1 row when only 1 was expected", err.Message())
-	assert.Equal(t, http.StatusInternalServerError, err.Status())
+	assert.Nil(t, err)
+	assert.Equal(t, dish.ExpiryCounts{
+		{HouseholdID: 1, ExpireDate: time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC), Dishes: 2},
+		{HouseholdID: 2, ExpireDate: time.Date(2016, 1, 9, 15, 4, 5, 0, time.UTC), Dishes: 1},
+	}, *counts)
 }
 
-func TestDb_GetUserByEmail(t *testing.T) {
+func TestDb_GetUserByID(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
@@ -876,20 +939,20 @@ func TestDb_GetUserByEmail(t *testing.T) {
 		FullName:     "Bob Nothing",
 		CreatedDate:  "2016-01-02T15:04:05",
 		AccessToken:  "ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
-		RefreshToken: "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
+		RefreshToken: "105i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
 		Admin:        false,
 		TempMatch:    "1v842d234523a",
 	}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
+			nU.AccessToken, nU.RefreshToken, nU.Admin, nU.TempMatch, "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByEmailBase, nU.Email)).WillReturnRows(rows)
+	mock.ExpectQuery(GetUserByIDQuery).WithArgs(nU.UserID).WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByEmail(nU.Email)
+	resultingUser, err := repo.GetUserByID(context.Background(), 2)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, resultingUser)
@@ -902,13 +965,12 @@ func TestDb_GetUserByEmail(t *testing.T) {
 	assert.Equal(t, nU.CreatedDate, resultingUser.CreatedDate)
 	assert.Equal(t, nU.AccessToken, resultingUser.AccessToken)
 	assert.Equal(t, nU.RefreshToken, resultingUser.RefreshToken)
-	assert.Equal(t, nU.AlexaUserID, resultingUser.AlexaUserID)
 	assert.Equal(t, nU.Admin, resultingUser.Admin)
 	assert.Equal(t, nU.TempMatch, resultingUser.TempMatch)
 
 }
 
-func TestDb_GetUserByEmail_QueryError(t *testing.T) {
+func TestDb_GetUserByID_QueryError(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
@@ -917,9 +979,9 @@ func TestDb_GetUserByEmail_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByEmailBase, "nothing@gmail.com")).WillReturnError(errors.New("database error"))
+	mock.ExpectQuery(GetUserByIDQuery).WithArgs(1).WillReturnError(errors.New("database error"))
 
-	resultingUser, err := repo.GetUserByEmail("nothing@gmail.com")
+	resultingUser, err := repo.GetUserByID(context.Background(), 1)
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -928,7 +990,7 @@ func TestDb_GetUserByEmail_QueryError(t *testing.T) {
 	//assert.Equal(t, "Error while retrieving user from the database", err.Message())
 }
 
-func TestDb_GetUserByEmail_NotFound(t *testing.T) {
+func TestDb_GetUserByID_NotFound(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
@@ -938,20 +1000,21 @@ func TestDb_GetUserByEmail_NotFound(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"})
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"})
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByEmailBase, "nothing@gmail.com")).WillReturnRows(rows)
+	mock.ExpectQuery(GetUserByIDQuery).WithArgs(nU.UserID).WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByEmail("nothing@gmail.com")
+	resultingUser, err := repo.GetUserByID(context.Background(), nU.UserID)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingUser)
 
 	assert.Equal(t, http.StatusNotFound, err.Status())
-	//assert.Equal(t, "Database could not find a user with this Email", err.Message())
+	//assert.Equal(t, "Database could not find a user with this ID", err.Message())
 }
 
-func TestDb_GetUserByEmail_RowScanError(t *testing.T) {
+func TestDb_GetUserByID_RowScanError(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
@@ -961,13 +1024,14 @@ func TestDb_GetUserByEmail_RowScanError(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow("SHOULDBEINT", "nothing@gmail.com", "Bob", "Nothing", "Bob Nothing", "2016-01-02T15:04:05",
-			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop", false, "asdfasdfa")
+			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "asdfasdfa", "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByEmailBase, "nothing@gmail.com")).WillReturnRows(rows)
+	mock.ExpectQuery(GetUserByIDQuery).WithArgs(1).WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByEmail("nothing@gmail.com")
+	resultingUser, err := repo.GetUserByID(context.Background(), 1)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingUser)
@@ -976,7 +1040,7 @@ func TestDb_GetUserByEmail_RowScanError(t *testing.T) {
 	//assert.Equal(t, "Error while scanning the result from the database", err.Message())
 }
 
-func TestDb_GetUserByEmail_FoundMultiple(t *testing.T) {
+func TestDb_GetUserByID_FoundMultiple(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
@@ -986,15 +1050,16 @@ func TestDb_GetUserByEmail_FoundMultiple(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(1, "nothing@gmail.com", "Bob", "Nothing", "Bob Nothing", "2016-01-02T15:04:05",
-			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop", false, "asdfasdfa").
+			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "asdfasdfa", "", "", "", "", false, 0, "", "", "").
 		AddRow(2, "nothing2@gmail.com", "Robert", "Nothingtwo", "Robert Nothingtwo", "2016-02-02T15:04:05",
-			"ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop2", false, "asdfasdfa2")
+			"ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "asdfasdfa2", "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByEmailBase, "nothing@gmail.com")).WillReturnRows(rows)
+	mock.ExpectQuery(GetUserByIDQuery).WithArgs(1).WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByEmail("nothing@gmail.com")
+	resultingUser, err := repo.GetUserByID(context.Background(), 1)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingUser)
@@ -1003,7 +1068,7 @@ func TestDb_GetUserByEmail_FoundMultiple(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
 }
 
-func TestDb_GetUserByAlexa(t *testing.T) {
+func TestDb_GetUserByEmail(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
@@ -1021,19 +1086,19 @@ func TestDb_GetUserByAlexa(t *testing.T) {
 		CreatedDate:  "2016-01-02T15:04:05",
 		AccessToken:  "ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 		RefreshToken: "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
 		Admin:        false,
 		TempMatch:    "1v842d234523a",
 	}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
+			nU.AccessToken, nU.RefreshToken, nU.Admin, nU.TempMatch, "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByAlexaBase, nU.AlexaUserID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetUserByEmailQuery).WithArgs(nU.Email).WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByAlexa(nU.AlexaUserID)
+	resultingUser, err := repo.GetUserByEmail(context.Background(), nU.Email)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, resultingUser)
@@ -1046,12 +1111,12 @@ func TestDb_GetUserByAlexa(t *testing.T) {
 	assert.Equal(t, nU.CreatedDate, resultingUser.CreatedDate)
 	assert.Equal(t, nU.AccessToken, resultingUser.AccessToken)
 	assert.Equal(t, nU.RefreshToken, resultingUser.RefreshToken)
-	assert.Equal(t, nU.AlexaUserID, resultingUser.AlexaUserID)
+	assert.Equal(t, nU.Admin, resultingUser.Admin)
 	assert.Equal(t, nU.TempMatch, resultingUser.TempMatch)
 
 }
 
-func TestDb_GetUserByAlexa_QueryError(t *testing.T) {
+func TestDb_GetUserByEmail_QueryError(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
@@ -1060,9 +1125,9 @@ func TestDb_GetUserByAlexa_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByAlexaBase, "qwertyuiop")).WillReturnError(errors.New("database error"))
+	mock.ExpectQuery(GetUserByEmailQuery).WithArgs("nothing@gmail.com").WillReturnError(errors.New("database error"))
 
-	resultingUser, err := repo.GetUserByAlexa("qwertyuiop")
+	resultingUser, err := repo.GetUserByEmail(context.Background(), "nothing@gmail.com")
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -1071,7 +1136,7 @@ func TestDb_GetUserByAlexa_QueryError(t *testing.T) {
 	//assert.Equal(t, "Error while retrieving user from the database", err.Message())
 }
 
-func TestDb_GetUserByAlexa_NotFound(t *testing.T) {
+func TestDb_GetUserByEmail_NotFound(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
@@ -1081,20 +1146,21 @@ func TestDb_GetUserByAlexa_NotFound(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "temp_match"})
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"})
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByAlexaBase, "qwertyuiop")).WillReturnRows(rows)
+	mock.ExpectQuery(GetUserByEmailQuery).WithArgs("nothing@gmail.com").WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByAlexa("qwertyuiop")
+	resultingUser, err := repo.GetUserByEmail(context.Background(), "nothing@gmail.com")
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingUser)
 
 	assert.Equal(t, http.StatusNotFound, err.Status())
-	//assert.Equal(t, "Database could not find a user with this Alexa User ID", err.Message())
+	//assert.Equal(t, "Database could not find a user with this Email", err.Message())
 }
 
-func TestDb_GetUserByAlexa_RowScanError(t *testing.T) {
+func TestDb_GetUserByEmail_RowScanError(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
@@ -1104,13 +1170,14 @@ func TestDb_GetUserByAlexa_RowScanError(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow("SHOULDBEINT", "nothing@gmail.com", "Bob", "Nothing", "Bob Nothing", "2016-01-02T15:04:05",
-			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop", "asdfasdfa")
+			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "asdfasdfa", "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByAlexaBase, "qwertyuiop")).WillReturnRows(rows)
+	mock.ExpectQuery(GetUserByEmailQuery).WithArgs("nothing@gmail.com").WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByAlexa("qwertyuiop")
+	resultingUser, err := repo.GetUserByEmail(context.Background(), "nothing@gmail.com")
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingUser)
@@ -1119,7 +1186,7 @@ func TestDb_GetUserByAlexa_RowScanError(t *testing.T) {
 	//assert.Equal(t, "Error while scanning the result from the database", err.Message())
 }
 
-func TestDb_GetUserByAlexa_FoundMultiple(t *testing.T) {
+func TestDb_GetUserByEmail_FoundMultiple(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
@@ -1129,15 +1196,16 @@ func TestDb_GetUserByAlexa_FoundMultiple(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(1, "nothing@gmail.com", "Bob", "Nothing", "Bob Nothing", "2016-01-02T15:04:05",
-			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop", false, "asdfasdfa").
+			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "asdfasdfa", "", "", "", "", false, 0, "", "", "").
 		AddRow(2, "nothing2@gmail.com", "Robert", "Nothingtwo", "Robert Nothingtwo", "2016-02-02T15:04:05",
-			"ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop2", false, "asdfasdfa2")
+			"ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "asdfasdfa2", "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByAlexaBase, "qwertyuiop")).WillReturnRows(rows)
+	mock.ExpectQuery(GetUserByEmailQuery).WithArgs("nothing@gmail.com").WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByAlexa("qwertyuiop")
+	resultingUser, err := repo.GetUserByEmail(context.Background(), "nothing@gmail.com")
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingUser)
@@ -1164,19 +1232,19 @@ func TestDb_GetUserByTempMatch(t *testing.T) {
 		CreatedDate:  "2016-01-02T15:04:05",
 		AccessToken:  "ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 		RefreshToken: "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
 		Admin:        false,
 		TempMatch:    "1v842d234523a",
 	}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
+			nU.AccessToken, nU.RefreshToken, nU.Admin, nU.TempMatch, "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByTempMatchBase, nU.TempMatch)).WillReturnRows(rows)
+	mock.ExpectQuery(GetUserByTempMatchQuery).WithArgs(nU.TempMatch).WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByTempMatch(nU.TempMatch)
+	resultingUser, err := repo.GetUserByTempMatch(context.Background(), nU.TempMatch)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, resultingUser)
@@ -1189,7 +1257,6 @@ func TestDb_GetUserByTempMatch(t *testing.T) {
 	assert.Equal(t, nU.CreatedDate, resultingUser.CreatedDate)
 	assert.Equal(t, nU.AccessToken, resultingUser.AccessToken)
 	assert.Equal(t, nU.RefreshToken, resultingUser.RefreshToken)
-	assert.Equal(t, nU.AlexaUserID, resultingUser.AlexaUserID)
 	assert.Equal(t, nU.Admin, resultingUser.Admin)
 	assert.Equal(t, nU.TempMatch, resultingUser.TempMatch)
 
@@ -1204,9 +1271,9 @@ func TestDb_GetUserByTempMatch_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByTempMatchBase, "qwertyuiop")).WillReturnError(errors.New("database error"))
+	mock.ExpectQuery(GetUserByTempMatchQuery).WithArgs("qwertyuiop").WillReturnError(errors.New("database error"))
 
-	resultingUser, err := repo.GetUserByTempMatch("qwertyuiop")
+	resultingUser, err := repo.GetUserByTempMatch(context.Background(), "qwertyuiop")
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -1225,11 +1292,12 @@ func TestDb_GetUserByTempMatch_NotFound(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"})
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"})
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByTempMatchBase, "qwertyuiop")).WillReturnRows(rows)
+	mock.ExpectQuery(GetUserByTempMatchQuery).WithArgs("qwertyuiop").WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByTempMatch("qwertyuiop")
+	resultingUser, err := repo.GetUserByTempMatch(context.Background(), "qwertyuiop")
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingUser)
@@ -1248,13 +1316,14 @@ func TestDb_GetUserByTempMatch_RowScanError(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow("SHOULDBEINT", "nothing@gmail.com", "Bob", "Nothing", "Bob Nothing", "2016-01-02T15:04:05",
-			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop", false, "asdfasdfa")
+			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "asdfasdfa", "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByTempMatchBase, "qwertyuiop")).WillReturnRows(rows)
+	mock.ExpectQuery(GetUserByTempMatchQuery).WithArgs("qwertyuiop").WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByTempMatch("qwertyuiop")
+	resultingUser, err := repo.GetUserByTempMatch(context.Background(), "qwertyuiop")
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingUser)
@@ -1273,15 +1342,16 @@ func TestDb_GetUserByTempMatch_FoundMultiple(t *testing.T) {
 	repo := &repository{db: db}
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(1, "nothing@gmail.com", "Bob", "Nothing", "Bob Nothing", "2016-01-02T15:04:05",
-			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop", false, "asdfasdfa").
+			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "1//05i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "asdfasdfa", "", "", "", "", false, 0, "", "", "").
 		AddRow(2, "nothing2@gmail.com", "Robert", "Nothingtwo", "Robert Nothingtwo", "2016-02-02T15:04:05",
-			"ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop2", false, "asdfasdfa2")
+			"ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "asdfasdfa2", "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByTempMatchBase, "qwertyuiop")).WillReturnRows(rows)
+	mock.ExpectQuery(GetUserByTempMatchQuery).WithArgs("qwertyuiop").WillReturnRows(rows)
 
-	resultingUser, err := repo.GetUserByTempMatch("qwertyuiop")
+	resultingUser, err := repo.GetUserByTempMatch(context.Background(), "qwertyuiop")
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingUser)
@@ -1291,7 +1361,7 @@ func TestDb_GetUserByTempMatch_FoundMultiple(t *testing.T) {
 }
 
 func TestDb_CreateUser(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
 	}
@@ -1307,23 +1377,21 @@ func TestDb_CreateUser(t *testing.T) {
 		CreatedDate:  "2016-02-02T15:04:05",
 		AccessToken:  "ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 		RefreshToken: "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
 		Admin:        false,
 	}
 
-	createRows := sqlmock.NewRows([]string{""})
-
 	getRows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(1, "nothing@gmail.com", "Bob", "Nothing", "Bob Nothing", "2016-01-02T15:04:05",
-			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", "qwertyuiop", false, "adfasfsgas654g")
+			"ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k", "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM", false, "adfasfsgas654g", "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(`INSERT INTO user \(.+\) VALUES\(".+", "Bob", "Nothing", ".+", ".+", ".+", ".+", "qwertyuiop", false, ".+"\)`).
-		WillReturnRows(createRows)
+	mock.ExpectExec(CreateUserQuery).WithArgs(nU.Email, "Bob", "Nothing", nU.FullName, nU.CreatedDate, nU.AccessToken, nU.RefreshToken,
+		false, sqlmock.AnyArg(), "", "", "", false, 0, "", "").WillReturnResult(sqlmock.NewResult(1, 1))
 
-	mock.ExpectQuery(`SELECT \* FROM user WHERE temp_match = ".+"`).WillReturnRows(getRows)
+	mock.ExpectQuery(GetUserByTempMatchQuery).WithArgs(sqlmock.AnyArg()).WillReturnRows(getRows)
 
-	returnedUser, err := repo.CreateUser(*nU)
+	returnedUser, err := repo.CreateUser(context.Background(), *nU)
 
 	assert.Nil(t, err)
 
@@ -1331,8 +1399,46 @@ func TestDb_CreateUser(t *testing.T) {
 	assert.Equal(t, nU.FirstName, returnedUser.FirstName)
 }
 
+func TestDb_CreateUser_SpecialCharacters(t *testing.T) {
+	for _, tricky := range trickyStrings {
+		db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		if testerr != nil {
+			t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+		}
+
+		repo := &repository{db: db}
+
+		nU := &user.User{
+			Email:       "o'brien+fridge@gmail.com",
+			FirstName:   tricky,
+			LastName:    tricky,
+			FullName:    tricky + " " + tricky,
+			CreatedDate: "2016-02-02T15:04:05",
+		}
+
+		getRows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
+			"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+			"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
+			AddRow(1, nU.Email, tricky, tricky, nU.FullName, nU.CreatedDate, "", "", false, "adfasfsgas654g", "", "", "", "", false, 0, "", "", "")
+
+		mock.ExpectExec(CreateUserQuery).WithArgs(nU.Email, tricky, tricky, nU.FullName, nU.CreatedDate, "", "", false, sqlmock.AnyArg(), "", "", "", false, 0, "", "").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		mock.ExpectQuery(GetUserByTempMatchQuery).WithArgs(sqlmock.AnyArg()).WillReturnRows(getRows)
+
+		returnedUser, err := repo.CreateUser(context.Background(), *nU)
+
+		assert.Nil(t, err)
+		assert.NotNil(t, returnedUser)
+		assert.Equal(t, nU.Email, returnedUser.Email)
+		assert.Equal(t, tricky, returnedUser.FirstName)
+		assert.Nil(t, mock.ExpectationsWereMet())
+		db.Close()
+	}
+}
+
 func TestDb_CreateUser_InsertError(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
 	}
@@ -1348,15 +1454,14 @@ func TestDb_CreateUser_InsertError(t *testing.T) {
 		CreatedDate:  "2016-02-02T15:04:05",
 		AccessToken:  "ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 		RefreshToken: "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
 		Admin:        false,
 		TempMatch:    "a4s65df6adhy4s5gjet",
 	}
 
-	mock.ExpectQuery(`INSERT INTO user \(.+\) VALUES\(".+", "Bob", "Nothing", ".+", ".+", ".+", ".+", "qwertyuiop", false, ".+"\)`).
-		WillReturnError(errors.New("not possible"))
+	mock.ExpectExec(CreateUserQuery).WithArgs(nU.Email, "Bob", "Nothing", nU.FullName, nU.CreatedDate, nU.AccessToken, nU.RefreshToken,
+		false, sqlmock.AnyArg(), "", "", "", false, 0, "", "").WillReturnError(errors.New("not possible"))
 
-	returnedUser, err := repo.CreateUser(*nU)
+	returnedUser, err := repo.CreateUser(context.Background(), *nU)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, returnedUser)
@@ -1365,7 +1470,7 @@ func TestDb_CreateUser_InsertError(t *testing.T) {
 }
 
 func TestDb_CreateUser_CheckError(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
 	}
@@ -1381,20 +1486,17 @@ func TestDb_CreateUser_CheckError(t *testing.T) {
 		CreatedDate:  "2016-02-02T15:04:05",
 		AccessToken:  "ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 		RefreshToken: "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
 		Admin:        false,
 		TempMatch:    "a4s65df6adhy4s5gjet",
 	}
 
-	createRows := sqlmock.NewRows([]string{""})
-
-	mock.ExpectQuery(`INSERT INTO user \(.+\) VALUES\(".+", "Bob", "Nothing", ".+", ".+", ".+", ".+", "qwertyuiop", false, ".+"\)`).
-		WillReturnRows(createRows)
+	mock.ExpectExec(CreateUserQuery).WithArgs(nU.Email, "Bob", "Nothing", nU.FullName, nU.CreatedDate, nU.AccessToken, nU.RefreshToken,
+		false, sqlmock.AnyArg(), "", "", "", false, 0, "", "").WillReturnResult(sqlmock.NewResult(1, 1))
 
-	mock.ExpectQuery(`SELECT \* FROM user WHERE temp_match = ".+"`).
+	mock.ExpectQuery(GetUserByTempMatchQuery).WithArgs(sqlmock.AnyArg()).
 		WillReturnError(errors.New("not possible"))
 
-	returnedUser, err := repo.CreateUser(*nU)
+	returnedUser, err := repo.CreateUser(context.Background(), *nU)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, returnedUser)
@@ -1421,24 +1523,22 @@ func TestDb_UpdateUser(t *testing.T) {
 		CreatedDate:  "2016-02-02T15:04:05",
 		AccessToken:  "ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 		RefreshToken: "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
 		Admin:        false,
 		TempMatch:    "a4s65df6adhy4s5gjet",
 	}
 
-	updateRows := sqlmock.NewRows([]string{""})
-
 	getRows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
+			nU.AccessToken, nU.RefreshToken, nU.Admin, nU.TempMatch, "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(UpdateUserBase, nU.Email, nU.FirstName, nU.LastName, nU.FullName,
-		nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.TempMatch, nU.UserID)).WillReturnRows(updateRows)
+	mock.ExpectExec(UpdateUserQuery).WithArgs(nU.Email, nU.FirstName, nU.LastName, nU.FullName,
+		nU.AccessToken, nU.RefreshToken, "", "", nU.TempMatch, "", false, 0, "", "", nU.UserID).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByIDBase, nU.UserID)).WillReturnRows(getRows)
+	mock.ExpectQuery(GetUserByIDQuery).WithArgs(nU.UserID).WillReturnRows(getRows)
 
-	returnedUser, err := repo.UpdateUser(*nU)
+	returnedUser, err := repo.UpdateUser(context.Background(), *nU)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, returnedUser)
@@ -1451,7 +1551,6 @@ func TestDb_UpdateUser(t *testing.T) {
 	assert.Equal(t, nU.CreatedDate, returnedUser.CreatedDate)
 	assert.Equal(t, nU.AccessToken, returnedUser.AccessToken)
 	assert.Equal(t, nU.RefreshToken, returnedUser.RefreshToken)
-	assert.Equal(t, nU.AlexaUserID, returnedUser.AlexaUserID)
 	assert.Equal(t, nU.Admin, returnedUser.Admin)
 	assert.Equal(t, nU.TempMatch, returnedUser.TempMatch)
 
@@ -1475,15 +1574,14 @@ func TestDb_UpdateUser_QueryError(t *testing.T) {
 		CreatedDate:  "2016-02-02T15:04:05",
 		AccessToken:  "ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 		RefreshToken: "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
 		TempMatch:    "a4s65df6adhy4s5gjet",
 	}
 
-	mock.ExpectQuery(fmt.Sprintf(UpdateUserBase, nU.Email, nU.FirstName, nU.LastName, nU.FullName,
-		nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.TempMatch, nU.UserID)).
+	mock.ExpectExec(UpdateUserQuery).WithArgs(nU.Email, nU.FirstName, nU.LastName, nU.FullName,
+		nU.AccessToken, nU.RefreshToken, "", "", nU.TempMatch, "", false, 0, "", "", nU.UserID).
 		WillReturnError(errors.New("database error"))
 
-	returnedUser, err := repo.UpdateUser(*nU)
+	returnedUser, err := repo.UpdateUser(context.Background(), *nU)
 
 	assert.Nil(t, returnedUser)
 	assert.NotNil(t, err)
@@ -1510,19 +1608,16 @@ func TestDb_UpdateUser_CheckError(t *testing.T) {
 		CreatedDate:  "2016-02-02T15:04:05",
 		AccessToken:  "ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 		RefreshToken: "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
 		TempMatch:    "a4s65df6adhy4s5gjet",
 	}
 
-	createRows := sqlmock.NewRows([]string{""})
-
-	mock.ExpectQuery(fmt.Sprintf(UpdateUserBase, nU.Email, nU.FirstName, nU.LastName, nU.FullName,
-		nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.TempMatch, nU.UserID)).
-		WillReturnRows(createRows)
+	mock.ExpectExec(UpdateUserQuery).WithArgs(nU.Email, nU.FirstName, nU.LastName, nU.FullName,
+		nU.AccessToken, nU.RefreshToken, "", "", nU.TempMatch, "", false, 0, "", "", nU.UserID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByIDBase, nU.UserID)).WillReturnError(errors.New("database error"))
+	mock.ExpectQuery(GetUserByIDQuery).WithArgs(nU.UserID).WillReturnError(errors.New("database error"))
 
-	returnedUser, err := repo.UpdateUser(*nU)
+	returnedUser, err := repo.UpdateUser(context.Background(), *nU)
 
 	assert.Nil(t, returnedUser)
 	assert.NotNil(t, err)
@@ -1551,15 +1646,12 @@ func TestDb_DeleteUser(t *testing.T) {
 		CreatedDate:  "2016-02-02T15:04:05",
 		AccessToken:  "ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 		RefreshToken: "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
 		TempMatch:    "a4s65df6adhy4s5gjet",
 	}
 
-	deleteRows := sqlmock.NewRows([]string{""})
+	mock.ExpectExec(DeleteUserQuery).WithArgs(nU.UserID).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(fmt.Sprintf(DeleteUserBase, nU.UserID)).WillReturnRows(deleteRows)
-
-	err := repo.DeleteUser(nU.UserID)
+	err := repo.DeleteUser(context.Background(), nU.UserID)
 
 	assert.Nil(t, err)
 }
@@ -1582,13 +1674,12 @@ func TestDb_DeleteUser_QueryError(t *testing.T) {
 		CreatedDate:  "2016-02-02T15:04:05",
 		AccessToken:  "ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 		RefreshToken: "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
 		TempMatch:    "a4s65df6adhy4s5gjet",
 	}
 
-	mock.ExpectQuery(fmt.Sprintf(DeleteUserBase, nU.UserID)).WillReturnError(errors.New("database error"))
+	mock.ExpectExec(DeleteUserQuery).WithArgs(nU.UserID).WillReturnError(errors.New("database error"))
 
-	err := repo.DeleteUser(nU.UserID)
+	err := repo.DeleteUser(context.Background(), nU.UserID)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
@@ -1613,23 +1704,21 @@ func TestDb_DeleteUser_CheckError(t *testing.T) {
 		CreatedDate:  "2016-02-02T15:04:05",
 		AccessToken:  "ya44.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 		RefreshToken: "205i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-		AlexaUserID:  "qwertyuiop",
 		Admin:        false,
 		TempMatch:    "a4s65df6adhy4s5gjet",
 	}
 
 	getRows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
-
-	deleteRows := sqlmock.NewRows([]string{""})
+			nU.AccessToken, nU.RefreshToken, nU.Admin, nU.TempMatch, "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(DeleteUserBase, nU.UserID)).WillReturnRows(deleteRows)
+	mock.ExpectExec(DeleteUserQuery).WithArgs(nU.UserID).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(fmt.Sprintf(GetUserByIDBase, nU.UserID)).WillReturnRows(getRows)
+	mock.ExpectQuery(GetUserByIDQuery).WithArgs(nU.UserID).WillReturnRows(getRows)
 
-	err := repo.DeleteUser(nU.UserID)
+	err := repo.DeleteUser(context.Background(), nU.UserID)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
@@ -1645,13 +1734,13 @@ func TestDb_GetStoragesByUser(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"}).
-		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch).
-		AddRow(nS.StorageID+1, nS.PersonalID+1, nS.UserID, nS.Title+"2", nS.Description+"2", nS.TempMatch+"2")
+	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch, nS.HouseholdID).
+		AddRow(nS.StorageID+1, nS.PersonalID+1, nS.UserID, nS.Title+"2", nS.Description+"2", nS.TempMatch+"2", nS.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetStoragesBase, nS.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetStoragesQuery).WithArgs(nS.UserID).WillReturnRows(rows)
 
-	resultingStorages, err := repo.GetStorages(nS.UserID)
+	resultingStorages, err := repo.GetStorages(context.Background(), nS.UserID)
 
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(*resultingStorages))
@@ -1672,11 +1761,11 @@ func TestDb_GetStoragesByUser_NotFound(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"})
+	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"})
 
-	mock.ExpectQuery(fmt.Sprintf(GetStoragesBase, nS.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetStoragesQuery).WithArgs(nS.UserID).WillReturnRows(rows)
 
-	resultingStorages, err := repo.GetStorages(nS.UserID)
+	resultingStorages, err := repo.GetStorages(context.Background(), nS.UserID)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingStorages)
@@ -1693,9 +1782,9 @@ func TestDb_GetStoragesByUser_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(fmt.Sprintf(GetStoragesBase, 1)).WillReturnError(errors.New("database error"))
+	mock.ExpectQuery(GetStoragesQuery).WithArgs(1).WillReturnError(errors.New("database error"))
 
-	resultingStorages, err := repo.GetStorages(nS.UserID)
+	resultingStorages, err := repo.GetStorages(context.Background(), nS.UserID)
 
 	assert.Nil(t, resultingStorages)
 	assert.NotNil(t, err)
@@ -1712,12 +1801,12 @@ func TestDb_GetStoragesByUser_RowScanError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"}).
-		AddRow("SHOULD BE INT", nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch)
+	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+		AddRow("SHOULD BE INT", nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch, nS.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetStoragesBase, nS.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetStoragesQuery).WithArgs(nS.UserID).WillReturnRows(rows)
 
-	resultingStorages, err := repo.GetStorages(nS.UserID)
+	resultingStorages, err := repo.GetStorages(context.Background(), nS.UserID)
 
 	assert.Nil(t, resultingStorages)
 	assert.NotNil(t, err)
@@ -1734,12 +1823,12 @@ func TestDb_GetStorageByID(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"}).
-		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch)
+	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch, nS.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageByIDBase, nS.UserID, nS.PersonalID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(rows)
 
-	resultingStorage, err := repo.GetStorageByID(nS.UserID, nS.PersonalID)
+	resultingStorage, err := repo.GetStorageByID(context.Background(), nS.UserID, nS.PersonalID)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, resultingStorage)
@@ -1757,9 +1846,9 @@ func TestDb_GetStorageByID_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageByIDBase, nS.UserID, nS.PersonalID)).WillReturnError(errors.New("database error"))
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnError(errors.New("database error"))
 
-	resultingStorage, err := repo.GetStorageByID(nS.UserID, nS.PersonalID)
+	resultingStorage, err := repo.GetStorageByID(context.Background(), nS.UserID, nS.PersonalID)
 
 	assert.Nil(t, resultingStorage)
 	assert.NotNil(t, err)
@@ -1777,11 +1866,11 @@ func TestDb_GetStorageByID_NotFound(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"})
+	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"})
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageByIDBase, nS.UserID, nS.PersonalID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(rows)
 
-	resultingStorage, err := repo.GetStorageByID(nS.UserID, nS.PersonalID)
+	resultingStorage, err := repo.GetStorageByID(context.Background(), nS.UserID, nS.PersonalID)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingStorage)
@@ -1799,12 +1888,12 @@ func TestDb_GetStorageByID_RowScanError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"}).
-		AddRow(nS.StorageID, "SHOULD BE INT", nS.UserID, nS.Title, nS.Description, nS.TempMatch)
+	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+		AddRow(nS.StorageID, "SHOULD BE INT", nS.UserID, nS.Title, nS.Description, nS.TempMatch, nS.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageByIDBase, nS.UserID, nS.PersonalID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(rows)
 
-	resultingStorage, err := repo.GetStorageByID(nS.UserID, nS.PersonalID)
+	resultingStorage, err := repo.GetStorageByID(context.Background(), nS.UserID, nS.PersonalID)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingStorage)
@@ -1822,13 +1911,13 @@ func TestDb_GetStorageByID_FoundMultiple(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"}).
-		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch).
-		AddRow(nS.StorageID+1, nS.PersonalID+1, nS.UserID, nS.Title+"2", nS.Description+"2", nS.TempMatch+"2")
+	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch, nS.HouseholdID).
+		AddRow(nS.StorageID+1, nS.PersonalID+1, nS.UserID, nS.Title+"2", nS.Description+"2", nS.TempMatch+"2", nS.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageByIDBase, nS.UserID, nS.PersonalID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(rows)
 
-	resultingStorage, err := repo.GetStorageByID(nS.UserID, nS.PersonalID)
+	resultingStorage, err := repo.GetStorageByID(context.Background(), nS.UserID, nS.PersonalID)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingStorage)
@@ -1838,7 +1927,7 @@ func TestDb_GetStorageByID_FoundMultiple(t *testing.T) {
 }
 
 func TestDb_CreateStorage(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
 	}
@@ -1846,17 +1935,15 @@ func TestDb_CreateStorage(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	createRows := sqlmock.NewRows([]string{""})
-
-	getRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"}).
-		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch)
+	getRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch, nS.HouseholdID)
 
-	mock.ExpectQuery(`INSERT INTO storage \(personal_id, user_id, title, description, temp_match\) VALUES\(.+\)`).
-		WillReturnRows(createRows)
+	mock.ExpectExec(CreateStorageQuery).WithArgs(nS.PersonalID, nS.UserID, nS.Title, nS.Description, sqlmock.AnyArg(), nS.HouseholdID).
+		WillReturnResult(sqlmock.NewResult(int64(nS.StorageID), 1))
 
-	mock.ExpectQuery(`SELECT \* FROM storage WHERE temp_match=".+"`).WillReturnRows(getRows)
+	mock.ExpectQuery(GetStorageByTempMatchQuery).WithArgs(sqlmock.AnyArg()).WillReturnRows(getRows)
 
-	returnedStorage, err := repo.CreateStorage(*nS)
+	returnedStorage, err := repo.CreateStorage(context.Background(), *nS)
 
 	assert.Nil(t, err)
 
@@ -1864,8 +1951,40 @@ func TestDb_CreateStorage(t *testing.T) {
 	assert.Equal(t, nS.Title, returnedStorage.Title)
 }
 
+func TestDb_CreateStorage_SpecialCharacters(t *testing.T) {
+	for _, tricky := range trickyStrings {
+		db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		if testerr != nil {
+			t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+		}
+
+		repo := &repository{db: db}
+
+		newStorage := *nS
+		newStorage.Title = tricky
+		newStorage.Description = tricky
+
+		getRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+			AddRow(nS.StorageID, nS.PersonalID, nS.UserID, tricky, tricky, nS.TempMatch, nS.HouseholdID)
+
+		mock.ExpectExec(CreateStorageQuery).WithArgs(nS.PersonalID, nS.UserID, tricky, tricky, sqlmock.AnyArg(), nS.HouseholdID).
+			WillReturnResult(sqlmock.NewResult(int64(nS.StorageID), 1))
+
+		mock.ExpectQuery(GetStorageByTempMatchQuery).WithArgs(sqlmock.AnyArg()).WillReturnRows(getRows)
+
+		returnedStorage, err := repo.CreateStorage(context.Background(), newStorage)
+
+		assert.Nil(t, err)
+		assert.NotNil(t, returnedStorage)
+		assert.Equal(t, tricky, returnedStorage.Title)
+		assert.Equal(t, tricky, returnedStorage.Description)
+		assert.Nil(t, mock.ExpectationsWereMet())
+		db.Close()
+	}
+}
+
 func TestDb_CreateStorage_InsertError(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
 	}
@@ -1873,10 +1992,10 @@ func TestDb_CreateStorage_InsertError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(`INSERT INTO storage \(user_id, title, description, temp_match\) VALUES\(.+\)`).
+	mock.ExpectExec(CreateStorageQuery).WithArgs(nS.PersonalID, nS.UserID, nS.Title, nS.Description, sqlmock.AnyArg(), nS.HouseholdID).
 		WillReturnError(errors.New("not possible"))
 
-	returnedStorage, err := repo.CreateStorage(*nS)
+	returnedStorage, err := repo.CreateStorage(context.Background(), *nS)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, returnedStorage)
@@ -1885,7 +2004,7 @@ func TestDb_CreateStorage_InsertError(t *testing.T) {
 }
 
 func TestDb_CreateStorage_CheckError(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
 		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
 	}
@@ -1893,14 +2012,12 @@ func TestDb_CreateStorage_CheckError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	createRows := sqlmock.NewRows([]string{""})
+	mock.ExpectExec(CreateStorageQuery).WithArgs(nS.PersonalID, nS.UserID, nS.Title, nS.Description, sqlmock.AnyArg(), nS.HouseholdID).
+		WillReturnResult(sqlmock.NewResult(int64(nS.StorageID), 1))
 
-	mock.ExpectQuery(`INSERT INTO storage \(user_id, title, description, temp_match\) VALUES\(.+\)`).
-		WillReturnRows(createRows)
+	mock.ExpectQuery(GetStorageByTempMatchQuery).WithArgs(sqlmock.AnyArg()).WillReturnError(errors.New("database error"))
 
-	mock.ExpectQuery(`SELECT \* FROM storage WHERE temp_match=".+"`).WillReturnError(errors.New("database error"))
-
-	returnedStorage, err := repo.CreateStorage(*nS)
+	returnedStorage, err := repo.CreateStorage(context.Background(), *nS)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, returnedStorage)
@@ -1918,16 +2035,14 @@ func TestDb_UpdateStorage(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	updateRows := sqlmock.NewRows([]string{""})
-
-	getRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"}).
-		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch)
+	getRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch, nS.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(UpdateStorageBase, nS.PersonalID, nS.Title, nS.Description, nS.TempMatch, nS.StorageID)).WillReturnRows(updateRows)
+	mock.ExpectExec(UpdateStorageQuery).WithArgs(nS.PersonalID, nS.Title, nS.Description, nS.TempMatch, nS.StorageID).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageByIDBase, nS.UserID, nS.PersonalID)).WillReturnRows(getRows)
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(getRows)
 
-	err := repo.UpdateStorage(*nS)
+	err := repo.UpdateStorage(context.Background(), *nS)
 
 	assert.Nil(t, err)
 
@@ -1942,10 +2057,10 @@ func TestDb_UpdateStorage_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(fmt.Sprintf(UpdateStorageBase, nS.PersonalID, nS.Title, nS.Description, nS.TempMatch, nS.StorageID)).
+	mock.ExpectExec(UpdateStorageQuery).WithArgs(nS.PersonalID, nS.Title, nS.Description, nS.TempMatch, nS.StorageID).
 		WillReturnError(errors.New("database error"))
 
-	err := repo.UpdateStorage(*nS)
+	err := repo.UpdateStorage(context.Background(), *nS)
 
 	assert.NotNil(t, err)
 
@@ -1962,13 +2077,11 @@ func TestDb_UpdateStorage_CheckError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	updateRows := sqlmock.NewRows([]string{""})
+	mock.ExpectExec(UpdateStorageQuery).WithArgs(nS.PersonalID, nS.Title, nS.Description, nS.TempMatch, nS.StorageID).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(fmt.Sprintf(UpdateStorageBase, nS.PersonalID, nS.Title, nS.Description, nS.TempMatch, nS.StorageID)).WillReturnRows(updateRows)
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnError(errors.New("database error"))
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageByIDBase, nS.UserID, nS.PersonalID)).WillReturnError(errors.New("database error"))
-
-	err := repo.UpdateStorage(*nS)
+	err := repo.UpdateStorage(context.Background(), *nS)
 
 	assert.NotNil(t, err)
 
@@ -1987,13 +2100,87 @@ func TestDb_DeleteStorage(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	deleteRows := sqlmock.NewRows([]string{""})
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(GetPersonalStorageCountQuery+ForUpdate).WithArgs(nS.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(nS.PersonalID))
+
+	mock.ExpectQuery(GetStorageDishCountQuery).WithArgs(nS.UserID, nS.PersonalID).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
+
+	mock.ExpectExec(DeleteStorageQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(fmt.Sprintf(DeleteStorageBase, nS.UserID, nS.PersonalID)).WillReturnRows(deleteRows)
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}))
 
-	err := repo.DeleteStorage(nS.UserID, nS.PersonalID)
+	mock.ExpectCommit()
+
+	err := repo.DeleteStorage(context.Background(), nS.UserID, nS.PersonalID)
 
 	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_DeleteStorage_RenumbersLaterStorages(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(GetPersonalStorageCountQuery+ForUpdate).WithArgs(nS.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(3))
+
+	mock.ExpectQuery(GetStorageDishCountQuery).WithArgs(nS.UserID, nS.PersonalID).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
+
+	mock.ExpectExec(DeleteStorageQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}))
+
+	mock.ExpectExec(NegateSomeStoragesQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	mock.ExpectExec(RestoreNegatedStoragesQuery).WithArgs(nS.UserID).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	mock.ExpectExec(DecrementSomeStorageDishesQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnResult(sqlmock.NewResult(0, 4))
+
+	mock.ExpectCommit()
+
+	err := repo.DeleteStorage(context.Background(), nS.UserID, nS.PersonalID)
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_DeleteStorage_StillHasDishes(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(GetPersonalStorageCountQuery+ForUpdate).WithArgs(nS.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+
+	mock.ExpectQuery(GetStorageDishCountQuery).WithArgs(nS.UserID, nS.PersonalID).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(2))
+
+	mock.ExpectRollback()
+
+	err := repo.DeleteStorage(context.Background(), nS.UserID, nS.PersonalID)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
 func TestDb_DeleteStorage_QueryError(t *testing.T) {
@@ -2005,9 +2192,19 @@ func TestDb_DeleteStorage_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	mock.ExpectQuery(fmt.Sprintf(DeleteStorageBase, nS.UserID, nS.PersonalID)).WillReturnError(errors.New("database error"))
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(GetPersonalStorageCountQuery+ForUpdate).WithArgs(nS.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
 
-	err := repo.DeleteStorage(nS.UserID, nS.PersonalID)
+	mock.ExpectQuery(GetStorageDishCountQuery).WithArgs(nS.UserID, nS.PersonalID).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
+
+	mock.ExpectExec(DeleteStorageQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnError(errors.New("database error"))
+
+	mock.ExpectRollback()
+
+	err := repo.DeleteStorage(context.Background(), nS.UserID, nS.PersonalID)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
@@ -2023,22 +2220,124 @@ func TestDb_DeleteStorage_CheckError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	deleteRows := sqlmock.NewRows([]string{""})
+	getRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch, nS.HouseholdID)
+
+	mock.ExpectBegin()
 
-	getRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"}).
-		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch)
+	mock.ExpectQuery(GetPersonalStorageCountQuery+ForUpdate).WithArgs(nS.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
 
-	mock.ExpectQuery(fmt.Sprintf(DeleteStorageBase, nS.UserID, nS.PersonalID)).WillReturnRows(deleteRows)
+	mock.ExpectQuery(GetStorageDishCountQuery).WithArgs(nS.UserID, nS.PersonalID).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageByIDBase, nS.UserID, nS.PersonalID)).WillReturnRows(getRows)
+	mock.ExpectExec(DeleteStorageQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	err := repo.DeleteStorage(nS.UserID, nS.PersonalID)
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(getRows)
+
+	mock.ExpectRollback()
+
+	err := repo.DeleteStorage(context.Background(), nS.UserID, nS.PersonalID)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
 	//assert.Equal(t, "Error while deleting the storage unit from the database, could not verify it was deleted.", err.Message())
 }
 
+func TestDb_GetDishes_DeadlineExceeded(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"})
+	mock.ExpectQuery(GetDishesQuery).WithArgs(nU.UserID).WillDelayFor(time.Second).WillReturnRows(rows)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	dishes, err := repo.GetDishes(ctx, nU.UserID)
+
+	assert.Nil(t, dishes)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, err.Status())
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "the query should give up at the deadline, not wait for the database")
+}
+
+func TestDb_WithTx_CancelledContext(t *testing.T) {
+	db, _, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	called := false
+	err := repo.WithTx(ctx, func(tx Repository) fcerr.FCErr {
+		called = true
+		return nil
+	})
+
+	assert.NotNil(t, err)
+	assert.False(t, called)
+	assert.Equal(t, http.StatusGatewayTimeout, err.Status())
+}
+
+func TestDb_WithTx_BeginError(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	mock.ExpectBegin().WillReturnError(errors.New("too many connections"))
+
+	called := false
+	err := repo.WithTx(context.Background(), func(tx Repository) fcerr.FCErr {
+		called = true
+		return nil
+	})
+
+	assert.NotNil(t, err)
+	assert.False(t, called)
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+}
+
+func TestDb_WithTx_CommitError(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(GetPersonalDishCountQuery+ForUpdate).WithArgs(nD.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+	mock.ExpectCommit().WillReturnError(errors.New("deadlock found when trying to get lock"))
+
+	err := repo.WithTx(context.Background(), func(tx Repository) fcerr.FCErr {
+		_, err := tx.GetPersonalDishCount(context.Background(), nD.UserID)
+		return err
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
 func TestDb_GetStorageDishes(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
@@ -2048,21 +2347,21 @@ func TestDb_GetStorageDishes(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	storageRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"}).
-		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch)
+	storageRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch, nS.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageByIDBase, nS.UserID, nS.PersonalID)).WillReturnRows(storageRows)
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(storageRows)
 
 	dishRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description,
-			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch).
+			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID).
 		AddRow(nD.DishID+200, nD.PersonalDishID+1, nD.UserID, nD.StorageID, nD.Title+"2", nD.Description+"2",
-			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch+"2")
+			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch+"2", nD.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageDishesBase, nS.UserID, nS.PersonalID)).WillReturnRows(dishRows)
+	mock.ExpectQuery(GetStorageDishesQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(dishRows)
 
-	resultingDishes, err := repo.GetStorageDishes(nS.UserID, nS.PersonalID)
+	resultingDishes, err := repo.GetStorageDishes(context.Background(), nS.UserID, nS.PersonalID)
 
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(*resultingDishes))
@@ -2083,17 +2382,17 @@ func TestDb_GetStorageDishes_NotFound(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	storageRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"}).
-		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch)
+	storageRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch, nS.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageByIDBase, nS.UserID, nS.PersonalID)).WillReturnRows(storageRows)
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(storageRows)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"})
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"})
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageDishesBase, nS.UserID, nS.PersonalID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetStorageDishesQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(rows)
 
-	resultingDishes, err := repo.GetStorageDishes(nS.UserID, nS.PersonalID)
+	resultingDishes, err := repo.GetStorageDishes(context.Background(), nS.UserID, nS.PersonalID)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resultingDishes)
@@ -2110,13 +2409,13 @@ func TestDb_GetStorageDishes_QueryError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	storageRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"}).
-		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch)
+	storageRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch, nS.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageByIDBase, nS.UserID, nS.PersonalID)).WillReturnRows(storageRows)
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(storageRows)
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageDishesBase, nS.UserID, nS.PersonalID)).WillReturnError(errors.New("database error"))
-	resultingDishes, err := repo.GetStorageDishes(nS.UserID, nS.PersonalID)
+	mock.ExpectQuery(GetStorageDishesQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnError(errors.New("database error"))
+	resultingDishes, err := repo.GetStorageDishes(context.Background(), nS.UserID, nS.PersonalID)
 
 	assert.Nil(t, resultingDishes)
 	assert.NotNil(t, err)
@@ -2133,22 +2432,398 @@ func TestDb_GetStorageDishes_RowScanError(t *testing.T) {
 
 	repo := &repository{db: db}
 
-	storageRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match"}).
-		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch)
+	storageRows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "title", "description", "temp_match", "household_id"}).
+		AddRow(nS.StorageID, nS.PersonalID, nS.UserID, nS.Title, nS.Description, nS.TempMatch, nS.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageByIDBase, nS.UserID, nS.PersonalID)).WillReturnRows(storageRows)
+	mock.ExpectQuery(GetStorageByIDQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(storageRows)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow("SHOULD BE INT", nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description,
-			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.CreatedDate, nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(GetStorageDishesBase, nS.UserID, nS.PersonalID)).WillReturnRows(rows)
+	mock.ExpectQuery(GetStorageDishesQuery).WithArgs(nS.UserID, nS.PersonalID).WillReturnRows(rows)
 
-	resultingDishes, err := repo.GetStorageDishes(nS.UserID, nS.PersonalID)
+	resultingDishes, err := repo.GetStorageDishes(context.Background(), nS.UserID, nS.PersonalID)
 
 	assert.Nil(t, resultingDishes)
 	assert.NotNil(t, err)
 	//assert.Equal(t, "Error while scanning the result from the database", err.Message())
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
 }
+
+func TestDb_GetDishes_DateFormats(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	expire := time.Date(2020, 10, 13, 8, 0, 0, 0, time.UTC)
+	mountain := time.FixedZone("MDT", -6*60*60)
+
+	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
+		AddRow(1, 1, 2, 3, "Stored", "", "2006-01-02 15:04:05", "2020-10-13 08:00:00", "", "", -1, "a", 2).
+		AddRow(2, 2, 2, 3, "Bytes", "", "2006-01-02 15:04:05", []byte("2020-10-13 08:00:00"), "", "", -1, "b", 2).
+		AddRow(3, 3, 2, 3, "DATETIME", "", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), expire.In(mountain), "", "", -1, "c", 2).
+		AddRow(4, 4, 2, 3, "Legacy", "", "2006-01-02T15:04:05", "2020-10-13T08:00", "", "", -1, "d", 2)
+
+	mock.ExpectQuery(GetDishesQuery).WithArgs(2).WillReturnRows(rows)
+
+	resultingDishes, err := repo.GetDishes(context.Background(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(*resultingDishes))
+
+	for _, d := range (*resultingDishes)[:3] {
+		assert.True(t, expire.Equal(d.ExpireDate), d.Title)
+		assert.Equal(t, time.UTC, d.ExpireDate.Location(), d.Title)
+		assert.True(t, nD.CreatedDate.Equal(d.CreatedDate), d.Title)
+	}
+	//the migration rewrote text in the older formats, so any that is left isn't read
+	assert.True(t, (*resultingDishes)[3].ExpireDate.IsZero())
+	assert.True(t, (*resultingDishes)[3].CreatedDate.IsZero())
+
+	assert.Equal(t, "2020-10-13 08:00:00", storedTime(expire.In(mountain)))
+	assert.Equal(t, "", storedTime(time.Time{}))
+	assert.Equal(t, "2020-10-13 08:00:00", storedDate(expire.In(mountain)))
+	assert.Nil(t, storedDate(time.Time{}))
+}
+
+func TestDb_CreateSession(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	created := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	nS := session.Session{UserID: 2, TokenHash: session.HashToken("fcs_token"), CreatedDate: created, ExpireDate: created.Add(time.Hour)}
+
+	getRows := sqlmock.NewRows([]string{"id", "user_id", "token_hash", "created_date", "expire_date", "revoked_date", "impersonator_id"}).
+		AddRow(7, 2, nS.TokenHash, "2021-03-01 12:00:00", "2021-03-01 13:00:00", "", 0)
+
+	mock.ExpectExec(CreateSessionQuery).WithArgs(2, nS.TokenHash, "2021-03-01 12:00:00", "2021-03-01 13:00:00", 0).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectQuery(GetSessionByTokenHashQuery).WithArgs(nS.TokenHash).WillReturnRows(getRows)
+
+	returnedSession, err := repo.CreateSession(context.Background(), nS)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 7, returnedSession.SessionID)
+	assert.Equal(t, created.Add(time.Hour), returnedSession.ExpireDate)
+	assert.True(t, returnedSession.RevokedDate.IsZero())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_CreateSession_QueryError(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	mock.ExpectExec(CreateSessionQuery).WillReturnError(errors.New("duplicate entry"))
+
+	returnedSession, err := repo.CreateSession(context.Background(), session.Session{UserID: 2, TokenHash: "abc"})
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+}
+
+func TestDb_GetSessionByTokenHash_NotFound(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	mock.ExpectQuery(GetSessionByTokenHashQuery).WithArgs("abc").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "token_hash", "created_date", "expire_date", "revoked_date", "impersonator_id"}))
+
+	returnedSession, err := repo.GetSessionByTokenHash(context.Background(), "abc")
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+}
+
+func TestDb_RevokeSession(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+	when := time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	mock.ExpectExec(RevokeSessionQuery).WithArgs("2021-03-01 12:30:00", "abc").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(RevokeUserSessionsQuery).WithArgs("2021-03-01 12:30:00", 2).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	assert.Nil(t, repo.RevokeSession(context.Background(), "abc", when))
+	assert.Nil(t, repo.RevokeUserSessions(context.Background(), 2, when))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_GetMembership(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	mock.ExpectQuery(GetMembershipQuery).WithArgs(nU.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"household_id", "user_id", "role", "joined_date", "email", "full_name"}).
+			AddRow(9, nU.UserID, household.RoleOwner, "2021-03-01 12:00:00", nU.Email, nU.FullName))
+	mock.ExpectQuery(GetMembershipQuery).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"household_id", "user_id", "role", "joined_date", "email", "full_name"}))
+
+	member, err := repo.GetMembership(context.Background(), nU.UserID)
+
+	assert.Nil(t, err)
+	assert.Equal(t, household.Member{HouseholdID: 9, UserID: nU.UserID, Role: household.RoleOwner,
+		JoinedDate: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), Email: nU.Email, FullName: nU.FullName}, *member)
+
+	_, err = repo.GetMembership(context.Background(), 3)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_CreateHousehold(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec(CreateHouseholdQuery).WithArgs("The Smiths", "2021-03-01 12:00:00", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectQuery(GetHouseholdByTempMatchQuery).WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_date", "temp_match"}).
+			AddRow(9, "The Smiths", "2021-03-01 12:00:00", "Eb2iev8zpxgy-dxe"))
+
+	created, err := repo.CreateHousehold(context.Background(), household.Household{Name: "The Smiths", CreatedDate: now})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 9, created.HouseholdID)
+	assert.Equal(t, now, created.CreatedDate)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_DeleteMembership_NotFound(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	mock.ExpectExec(DeleteMembershipQuery).WithArgs(9, nU.UserID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := repo.DeleteMembership(context.Background(), 9, nU.UserID)
+
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_UserDeletion(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+	when := time.Date(2021, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec(SetUserDeletionQuery).WithArgs("2021-03-31 12:00:00", nU.UserID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(GetUsersPendingDeletionQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
+			"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+			"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
+			AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate, "", "", false, "", "", "", "",
+				"America/Chicago", true, 2, "2021-03-31 12:00:00", "", ""))
+	mock.ExpectExec(SetUserDeletionQuery).WithArgs("", nU.UserID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.Nil(t, repo.SetUserDeletion(context.Background(), nU.UserID, when))
+	pending, err := repo.GetUsersPendingDeletion(context.Background())
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(*pending)) {
+		assert.Equal(t, when, (*pending)[0].DeletionDate)
+		assert.Equal(t, "America/Chicago", (*pending)[0].TimeZone)
+		assert.Equal(t, 2, (*pending)[0].NoticeDays())
+	}
+	assert.Nil(t, repo.SetUserDeletion(context.Background(), nU.UserID, time.Time{}))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_EraseUserData(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+
+	mock.ExpectExec(DeleteUserSessionsQuery).WithArgs(nU.UserID).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(DeleteHouseholdDishesQuery).WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(DeleteHouseholdStoragesQuery).WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(DeleteHouseholdRemovalsQuery).WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(DeleteHouseholdDishesQuery).WithArgs(10).WillReturnError(errors.New("database error"))
+
+	assert.Nil(t, repo.DeleteUserSessions(context.Background(), nU.UserID))
+	assert.Nil(t, repo.DeleteHouseholdFood(context.Background(), 9), "a household with no storage units is fine")
+	err := repo.DeleteHouseholdFood(context.Background(), 10)
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_Notifications(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+	expires := time.Date(2021, 4, 1, 18, 0, 0, 0, time.UTC)
+	sent := time.Date(2021, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec(CreateNotificationQuery).WithArgs(nU.UserID, 4, notification.Email, "2021-04-01 18:00:00",
+		"2021-03-31 12:00:00").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(GetNotificationsQuery).WithArgs(nU.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "dish_id", "channel", "expire_date", "sent_date"}).
+			AddRow(1, nU.UserID, 4, notification.Email, "2021-04-01 18:00:00", "2021-03-31 12:00:00"))
+	mock.ExpectExec(DeleteNotificationsBeforeQuery).WithArgs("2021-03-30 12:00:00").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(DeleteUserNotificationsQuery).WithArgs(nU.UserID).WillReturnError(errors.New("database error"))
+
+	assert.Nil(t, repo.CreateNotification(context.Background(), notification.Notification{UserID: nU.UserID, DishID: 4,
+		Channel: notification.Email, ExpireDate: expires, SentDate: sent}))
+	notifications, err := repo.GetNotifications(context.Background(), nU.UserID)
+	assert.Nil(t, err)
+	assert.True(t, notifications.Sent(4, notification.Email, expires))
+	assert.False(t, notifications.Sent(4, notification.Alexa, expires))
+	assert.Nil(t, repo.DeleteNotificationsBefore(context.Background(), sent.Add(-24*time.Hour)))
+	err = repo.DeleteUserNotifications(context.Background(), nU.UserID)
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_Removals(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+	since := time.Date(2021, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec(CreateRemovalQuery).WithArgs(9, nU.UserID, 3, "Carrots", "2021-04-01 18:00:00", "2021-04-01 08:00:00",
+		dish.Consumed).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(GetRemovalsQuery).WithArgs(9, "2021-03-31 12:00:00").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "household_id", "user_id", "storage_id", "title", "expire_date",
+			"removed_date", "outcome"}).
+			AddRow(1, 9, nU.UserID, 3, "Carrots", "2021-04-01 18:00:00", "2021-04-01 08:00:00", dish.Consumed).
+			AddRow(2, 9, nU.UserID, 3, "Soup", "", "2021-04-01 09:00:00", dish.Wasted))
+	mock.ExpectQuery(GetRemovalsQuery).WithArgs(10, "2021-03-31 12:00:00").WillReturnError(errors.New("database error"))
+
+	assert.Nil(t, repo.CreateRemoval(context.Background(), dish.Removal{HouseholdID: 9, UserID: nU.UserID, StorageID: 3,
+		Title: "Carrots", ExpireDate: time.Date(2021, 4, 1, 18, 0, 0, 0, time.UTC),
+		RemovedDate: time.Date(2021, 4, 1, 8, 0, 0, 0, time.UTC), Outcome: dish.Consumed}))
+	removals, err := repo.GetRemovals(context.Background(), 9, since)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(*removals)) {
+		assert.Equal(t, time.Date(2021, 4, 1, 18, 0, 0, 0, time.UTC), (*removals)[0].ExpireDate)
+		assert.True(t, (*removals)[1].ExpireDate.IsZero())
+		assert.Equal(t, dish.Wasted, (*removals)[1].Outcome)
+	}
+	_, err = repo.GetRemovals(context.Background(), 10, since)
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_Webhooks(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+	columns := []string{"id", "user_id", "url", "secret", "events", "active", "created_date", "temp_match"}
+
+	mock.ExpectQuery(GetWebhooksQuery).WithArgs(nU.UserID).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, nU.UserID, "https://example.com/hook", "whsec_one", "dish.created,dish.deleted", true, "2021-04-01 08:00:00", "tm1").
+			AddRow(2, nU.UserID, "https://example.com/all", "whsec_two", "", false, "2021-04-01 09:00:00", "tm2"))
+	mock.ExpectExec(UpdateWebhookQuery).WithArgs("https://example.com/all", "dish.expired", true, nU.UserID, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(DeleteWebhookDeliveriesQuery).WithArgs(nU.UserID, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(DeleteWebhookQuery).WithArgs(nU.UserID, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	subscriptions, err := repo.GetWebhooks(context.Background(), nU.UserID)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(*subscriptions)) {
+		assert.Equal(t, []string{"dish.created", "dish.deleted"}, (*subscriptions)[0].Events)
+		assert.True(t, (*subscriptions)[0].Active)
+		assert.Equal(t, []string{}, (*subscriptions)[1].Events)
+		assert.Equal(t, time.Date(2021, 4, 1, 9, 0, 0, 0, time.UTC), (*subscriptions)[1].CreatedDate)
+	}
+	assert.Nil(t, repo.UpdateWebhook(context.Background(), webhook.Subscription{SubscriptionID: 2, UserID: nU.UserID,
+		URL: "https://example.com/all", Events: []string{"dish.expired"}, Active: true}))
+	err = repo.DeleteWebhook(context.Background(), nU.UserID, 3)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_Deliveries(t *testing.T) {
+	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if testerr != nil {
+		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+	}
+	defer db.Close()
+
+	repo := &repository{db: db}
+	now := time.Date(2021, 4, 1, 8, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(GetDueDeliveriesQuery).WithArgs(webhook.Pending, "2021-04-01 08:00:00", MaxDueDeliveries).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "subscription_id", "user_id", "event_id", "event_type", "payload", "status",
+			"attempts", "response_code", "last_error", "next_attempt", "created_date", "updated_date", "temp_match"}).
+			AddRow(4, 1, nU.UserID, "evt_1", "dish.created", `{"Type":"dish.created"}`, webhook.Pending, 2, 503,
+				"The webhook answered with status 503", "2021-04-01 07:59:00", "2021-04-01 07:56:00", "2021-04-01 07:57:00", "tm"))
+	mock.ExpectExec(UpdateDeliveryQuery).WithArgs(webhook.Delivered, 3, 204, "", "", "2021-04-01 08:00:00", 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(DeleteDeliveriesBeforeQuery).WithArgs(webhook.Pending, "2021-03-02 08:00:00").
+		WillReturnError(errors.New("database error"))
+
+	due, err := repo.GetDueDeliveries(context.Background(), now)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(*due)) {
+		d := (*due)[0]
+		assert.Equal(t, `{"Type":"dish.created"}`, d.Payload)
+		assert.Equal(t, 2, d.Attempts)
+		assert.Equal(t, time.Date(2021, 4, 1, 7, 59, 0, 0, time.UTC), d.NextAttempt)
+
+		d.Succeeded(now, 204)
+		assert.Nil(t, repo.UpdateDelivery(context.Background(), d))
+	}
+	err = repo.DeleteDeliveriesBefore(context.Background(), now.AddDate(0, 0, -30))
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,1652 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/identity"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/notification"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/session"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/webhook"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//memoryRepository keeps every table in slices ordered by id, mirroring how the mysql tables come back without an ORDER BY.
+type memoryRepository struct {
+	mu sync.Mutex
+	//txMu makes WithTx calls take turns, the way row locks do for the mysql repository
+	txMu sync.Mutex
+
+	dishes     []dish.Dish
+	users      []user.User
+	storages   []storage.Storage
+	sessions   []session.Session
+	identities []identity.Identity
+	households []household.Household
+	//members are kept without the user's email and name, which are filled in from users when read
+	members       []household.Member
+	invites       []household.Invite
+	notifications []notification.Notification
+	removals      []dish.Removal
+	webhooks      []webhook.Subscription
+	deliveries    []webhook.Delivery
+
+	lastDishID         int
+	lastUserID         int
+	lastStorageID      int
+	lastSessionID      int
+	lastIdentityID     int
+	lastHouseholdID    int
+	lastInviteID       int
+	lastNotificationID int
+	lastRemovalID      int
+	lastWebhookID      int
+	lastDeliveryID     int
+}
+
+//NewMemoryRepository will get an empty, thread-safe in-memory instance which satisfies the Repository interface.
+//It is meant for tests and local development, and behaves the same as the mysql repository.
+func NewMemoryRepository() Repository {
+	return &memoryRepository{}
+}
+
+//memoryTx is handed to the function given to WithTx, so nested WithTx calls join the running transaction.
+type memoryTx struct {
+	*memoryRepository
+}
+
+//WithTx runs fn while holding the repository's transaction lock. If fn returns an error every change it made is undone.
+func (repo *memoryRepository) WithTx(ctx context.Context, fn func(Repository) fcerr.FCErr) fcerr.FCErr {
+	repo.txMu.Lock()
+	defer repo.txMu.Unlock()
+
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	snapshot := memoryRepository{
+		dishes:             append([]dish.Dish(nil), repo.dishes...),
+		users:              append([]user.User(nil), repo.users...),
+		storages:           append([]storage.Storage(nil), repo.storages...),
+		sessions:           append([]session.Session(nil), repo.sessions...),
+		identities:         append([]identity.Identity(nil), repo.identities...),
+		households:         append([]household.Household(nil), repo.households...),
+		members:            append([]household.Member(nil), repo.members...),
+		invites:            append([]household.Invite(nil), repo.invites...),
+		notifications:      append([]notification.Notification(nil), repo.notifications...),
+		removals:           append([]dish.Removal(nil), repo.removals...),
+		webhooks:           append([]webhook.Subscription(nil), repo.webhooks...),
+		deliveries:         append([]webhook.Delivery(nil), repo.deliveries...),
+		lastDishID:         repo.lastDishID,
+		lastUserID:         repo.lastUserID,
+		lastStorageID:      repo.lastStorageID,
+		lastSessionID:      repo.lastSessionID,
+		lastIdentityID:     repo.lastIdentityID,
+		lastHouseholdID:    repo.lastHouseholdID,
+		lastInviteID:       repo.lastInviteID,
+		lastNotificationID: repo.lastNotificationID,
+		lastRemovalID:      repo.lastRemovalID,
+		lastWebhookID:      repo.lastWebhookID,
+		lastDeliveryID:     repo.lastDeliveryID,
+	}
+	repo.mu.Unlock()
+
+	fcErr := fn(memoryTx{repo})
+	if fcErr != nil {
+		repo.mu.Lock()
+		repo.dishes, repo.users, repo.storages, repo.sessions = snapshot.dishes, snapshot.users, snapshot.storages, snapshot.sessions
+		repo.lastDishID, repo.lastUserID, repo.lastStorageID = snapshot.lastDishID, snapshot.lastUserID, snapshot.lastStorageID
+		repo.lastSessionID = snapshot.lastSessionID
+		repo.identities, repo.lastIdentityID = snapshot.identities, snapshot.lastIdentityID
+		repo.households, repo.members, repo.lastHouseholdID = snapshot.households, snapshot.members, snapshot.lastHouseholdID
+		repo.invites, repo.lastInviteID = snapshot.invites, snapshot.lastInviteID
+		repo.notifications, repo.lastNotificationID = snapshot.notifications, snapshot.lastNotificationID
+		repo.removals, repo.lastRemovalID = snapshot.removals, snapshot.lastRemovalID
+		repo.webhooks, repo.lastWebhookID = snapshot.webhooks, snapshot.lastWebhookID
+		repo.deliveries, repo.lastDeliveryID = snapshot.deliveries, snapshot.lastDeliveryID
+		repo.mu.Unlock()
+	}
+	return fcErr
+}
+
+//WithTx inside a transaction just runs fn as part of it.
+func (tx memoryTx) WithTx(ctx context.Context, fn func(Repository) fcerr.FCErr) fcerr.FCErr {
+	return fn(tx)
+}
+
+//GetDishes(householdID int) returns a *[]dish - all dishes the household has
+func (repo *memoryRepository) GetDishes(ctx context.Context, householdID int) (*dish.Dishes, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	var resultDishes dish.Dishes
+	for _, d := range repo.dishes {
+		if d.HouseholdID == householdID {
+			resultDishes = append(resultDishes, d)
+		}
+	}
+	if len(resultDishes) < 1 {
+		return nil, fcerr.NewNotFoundError("Database could not find any dishes")
+	}
+
+	return &resultDishes, nil
+}
+
+//GetDishByID (householdID int, pID int) looks for a dish the household has with the given personal id.
+func (repo *memoryRepository) GetDishByID(ctx context.Context, householdID int, pID int) (*dish.Dish, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	return repo.findDish(func(d dish.Dish) bool { return d.HouseholdID == householdID && d.PersonalDishID == pID },
+		"Database could not find a dish with this ID")
+}
+
+//GetDishByTempMatch(tm string) looks for a dish with this temp_match.
+func (repo *memoryRepository) GetDishByTempMatch(ctx context.Context, tm string) (*dish.Dish, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	return repo.findDish(func(d dish.Dish) bool { return d.TempMatch == tm },
+		"Database could not find a dish with this temp match")
+}
+
+//GetPersonalDishCount(householdID int) gets the number of dishes the given household has
+func (repo *memoryRepository) GetPersonalDishCount(ctx context.Context, householdID int) (int, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return 0, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	return repo.dishCount(householdID), nil
+}
+
+//CreateDish(d dish.Dish) takes a dish object and adds it with a new id and temp match
+func (repo *memoryRepository) CreateDish(ctx context.Context, d dish.Dish) (*dish.Dish, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	repo.lastDishID++
+	d.DishID = repo.lastDishID
+	d.TempMatch = generateTempMatch()
+	repo.dishes = append(repo.dishes, d)
+
+	return &d, nil
+}
+
+//UpdateDish(d dish.Dish) takes a dish object and updates the existing dish with the same id to match
+func (repo *memoryRepository) UpdateDish(ctx context.Context, d dish.Dish) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for i := range repo.dishes {
+		if repo.dishes[i].DishID == d.DishID {
+			current := &repo.dishes[i]
+			current.PersonalDishID = d.PersonalDishID
+			current.StorageID = d.StorageID
+			current.Title = d.Title
+			current.Description = d.Description
+			current.ExpireDate = d.ExpireDate
+			current.Priority = d.Priority
+			current.DishType = d.DishType
+			current.Portions = d.Portions
+		}
+	}
+
+	_, err := repo.findDish(func(c dish.Dish) bool { return c.HouseholdID == d.HouseholdID && c.PersonalDishID == d.PersonalDishID }, "")
+	if err != nil {
+		return fcerr.NewInternalServerError("Error while checking the dish that was created. Cannot verify if anything was updated in the Database")
+	}
+
+	return nil
+}
+
+//DeleteDish(householdID int, pID int) deletes the household's dish and shifts the personal ids after it down by one
+func (repo *memoryRepository) DeleteDish(ctx context.Context, householdID int, pID int) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	personalDishCount := repo.dishCount(householdID)
+	if pID < 1 || pID > personalDishCount {
+		return fcerr.NewBadRequestError("Could not delete a dish that doesn't exist")
+	}
+
+	remaining := repo.dishes[:0]
+	for _, d := range repo.dishes {
+		if d.HouseholdID == householdID && d.PersonalDishID == pID {
+			continue
+		}
+		remaining = append(remaining, d)
+	}
+	repo.dishes = remaining
+
+	if pID != personalDishCount {
+		for i := range repo.dishes {
+			if repo.dishes[i].HouseholdID == householdID && repo.dishes[i].PersonalDishID > pID {
+				repo.dishes[i].PersonalDishID--
+			}
+		}
+	}
+
+	return nil
+}
+
+//GetDishExpiryCounts() counts the dishes of every household by when they expire.
+func (repo *memoryRepository) GetDishExpiryCounts(ctx context.Context) (*dish.ExpiryCounts, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	counts := dish.ExpiryCounts{}
+	for _, d := range repo.dishes {
+		found := false
+		for i := range counts {
+			if counts[i].HouseholdID == d.HouseholdID && counts[i].ExpireDate.Equal(d.ExpireDate) {
+				counts[i].Dishes++
+				found = true
+				break
+			}
+		}
+		if !found {
+			counts = append(counts, dish.ExpiryCount{HouseholdID: d.HouseholdID, ExpireDate: d.ExpireDate, Dishes: 1})
+		}
+	}
+	return &counts, nil
+}
+
+//GetDishesExpiringBetween(after time.Time, until time.Time) gives the dishes of every household that expire after the
+//first time and by the second, soonest first.
+func (repo *memoryRepository) GetDishesExpiringBetween(ctx context.Context, after time.Time, until time.Time) (*dish.Dishes, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	after, until = dish.CanonicalTime(after), dish.CanonicalTime(until)
+	dishes := dish.Dishes{}
+	for _, d := range repo.dishes {
+		if d.ExpireDate.After(after) && !d.ExpireDate.After(until) {
+			dishes = append(dishes, d)
+		}
+	}
+	sort.SliceStable(dishes, func(i, j int) bool {
+		if !dishes[i].ExpireDate.Equal(dishes[j].ExpireDate) {
+			return dishes[i].ExpireDate.Before(dishes[j].ExpireDate)
+		}
+		return dishes[i].DishID < dishes[j].DishID
+	})
+	return &dishes, nil
+}
+
+//FindDishes(householdID int, q dish.Query) gives the household's dishes that q narrows them down to, sorted and paged
+//the way it says. It gives an empty list, not a 404, if there are none.
+func (repo *memoryRepository) FindDishes(ctx context.Context, householdID int, q dish.Query) (*dish.Dishes, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	search := strings.ToLower(q.Search)
+	after, before := dish.CanonicalTime(q.ExpiresAfter), dish.CanonicalTime(q.ExpiresBefore)
+	dishes := dish.Dishes{}
+	for _, d := range repo.dishes {
+		switch {
+		case d.HouseholdID != householdID,
+			q.StorageID != nil && d.StorageID != *q.StorageID,
+			q.DishType != "" && !strings.EqualFold(d.DishType, q.DishType),
+			q.Priority != "" && dish.PriorityRank(d.Priority) != dish.PriorityRank(q.Priority),
+			!q.ExpiresAfter.IsZero() && !d.ExpireDate.After(after),
+			!q.ExpiresBefore.IsZero() && (d.ExpireDate.IsZero() || d.ExpireDate.After(before)),
+			search != "" && !strings.Contains(strings.ToLower(d.Title), search):
+			continue
+		}
+		if q.After != nil && dishOrder(d.SortKey(q.Sort), d.DishID, q.After.Key, q.After.ID, q.Sort, q.Descending) <= 0 {
+			continue
+		}
+		dishes = append(dishes, d)
+	}
+	sort.SliceStable(dishes, func(i, j int) bool {
+		return dishOrder(dishes[i].SortKey(q.Sort), dishes[i].DishID, dishes[j].SortKey(q.Sort), dishes[j].DishID, q.Sort, q.Descending) < 0
+	})
+	if q.Limit > 0 && len(dishes) > q.Limit {
+		dishes = dishes[:q.Limit]
+	}
+	return &dishes, nil
+}
+
+//dishOrder compares two dishes by their sort keys and then their ids, the way FindDishes orders them: negative if the
+//first comes before the second.
+func dishOrder(key string, id int, otherKey string, otherID int, sortBy string, descending bool) int {
+	order := strings.Compare(key, otherKey)
+	if sortBy == dish.SortPriority {
+		//ranks are single digits, but compare them as the numbers they are
+		rank, _ := strconv.Atoi(key)
+		otherRank, _ := strconv.Atoi(otherKey)
+		order = rank - otherRank
+	}
+	if order == 0 {
+		order = id - otherID
+	}
+	if descending {
+		return -order
+	}
+	return order
+}
+
+//GetUsers(search string) gets every user, or only those whose email or full name contains the search, ignoring case.
+func (repo *memoryRepository) GetUsers(ctx context.Context, search string) (*user.Users, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	search = strings.ToLower(search)
+	var resultingUsers user.Users
+	for _, u := range repo.users {
+		if strings.Contains(strings.ToLower(u.Email), search) || strings.Contains(strings.ToLower(u.FullName), search) {
+			resultingUsers = append(resultingUsers, u)
+		}
+	}
+	if len(resultingUsers) == 0 {
+		return nil, fcerr.NewNotFoundError("Database could not find any users")
+	}
+	return &resultingUsers, nil
+}
+
+//GetUsersPendingDeletion gets every user who has asked for their account to be erased after a grace period, by id.
+func (repo *memoryRepository) GetUsersPendingDeletion(ctx context.Context) (*user.Users, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	var resultingUsers user.Users
+	for _, u := range repo.users {
+		if !u.DeletionDate.IsZero() {
+			resultingUsers = append(resultingUsers, u)
+		}
+	}
+	if len(resultingUsers) == 0 {
+		return nil, fcerr.NewNotFoundError("Database could not find any users")
+	}
+	return &resultingUsers, nil
+}
+
+//GetUsersToNotify gives every active user who asked to be told about food expiring, by id.
+func (repo *memoryRepository) GetUsersToNotify(ctx context.Context) (*user.Users, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	var resultingUsers user.Users
+	for _, u := range repo.users {
+		if u.NotifyExpiring && u.IsActive() {
+			resultingUsers = append(resultingUsers, u)
+		}
+	}
+	if len(resultingUsers) == 0 {
+		return nil, fcerr.NewNotFoundError("Database could not find any users")
+	}
+	return &resultingUsers, nil
+}
+
+//GetUserByID(id int) gets the user with the given ID.
+func (repo *memoryRepository) GetUserByID(ctx context.Context, id int) (*user.User, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	return repo.findUser(func(u user.User) bool { return u.UserID == id }, "Database could not find a user with this ID")
+}
+
+//GetUserByEmail(email string) gets the user with the given Email.
+func (repo *memoryRepository) GetUserByEmail(ctx context.Context, email string) (*user.User, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	return repo.findUser(func(u user.User) bool { return u.Email == email }, "Database could not find a user with this Email")
+}
+
+//GetUserByTempMatch(tm string) gets the user with the given temp match.
+func (repo *memoryRepository) GetUserByTempMatch(ctx context.Context, tm string) (*user.User, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	return repo.findUser(func(u user.User) bool { return u.TempMatch == tm }, "Database could not find a user with this Temp Match")
+}
+
+//CreateUser(u user.User) takes a user object and adds it with a new id and temp match, keeping emails unique
+func (repo *memoryRepository) CreateUser(ctx context.Context, u user.User) (*user.User, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for _, existing := range repo.users {
+		if existing.Email == u.Email {
+			return nil, fcerr.NewInternalServerError("Error while inserting the user into the database")
+		}
+	}
+
+	repo.lastUserID++
+	u.UserID = repo.lastUserID
+	u.TempMatch = generateTempMatch()
+	u.TokenExpiry = dish.CanonicalTime(u.TokenExpiry)
+	u.DeactivatedDate = time.Time{}
+	u.DeletionDate = time.Time{}
+	repo.users = append(repo.users, u)
+
+	return &u, nil
+}
+
+//UpdateUser(u user.User) takes a user object and updates the existing user with the same id to match
+func (repo *memoryRepository) UpdateUser(ctx context.Context, u user.User) (*user.User, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for i := range repo.users {
+		if repo.users[i].UserID != u.UserID && repo.users[i].Email == u.Email {
+			return nil, fcerr.NewInternalServerError("Error while updating the user in the database")
+		}
+	}
+
+	for i := range repo.users {
+		if repo.users[i].UserID == u.UserID {
+			current := &repo.users[i]
+			current.Email = u.Email
+			current.FirstName = u.FirstName
+			current.LastName = u.LastName
+			current.FullName = u.FullName
+			current.AccessToken = u.AccessToken
+			current.RefreshToken = u.RefreshToken
+			current.TokenExpiry = dish.CanonicalTime(u.TokenExpiry)
+			current.TokenProvider = u.TokenProvider
+			current.TempMatch = u.TempMatch
+			current.TimeZone = u.TimeZone
+			current.NotifyExpiring = u.NotifyExpiring
+			current.NotifyDaysBefore = u.NotifyDaysBefore
+			current.QuietStart = u.QuietStart
+			current.QuietEnd = u.QuietEnd
+		}
+	}
+
+	checkUser, err := repo.findUser(func(c user.User) bool { return c.UserID == u.UserID }, "")
+	if err != nil {
+		return nil, fcerr.NewInternalServerError("Error while checking the user that was created." +
+			" Cannot verify if anything was updated in the Database")
+	}
+
+	return checkUser, nil
+}
+
+//SetUserDeactivated(userID int, when time.Time) deactivates the user at the given time, or with the zero time reactivates them.
+func (repo *memoryRepository) SetUserDeactivated(ctx context.Context, userID int, when time.Time) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	if !when.IsZero() {
+		when = dish.CanonicalTime(when)
+	}
+	for i := range repo.users {
+		if repo.users[i].UserID == userID {
+			repo.users[i].DeactivatedDate = when
+		}
+	}
+	return nil
+}
+
+//SetUserDeletion(userID int, when time.Time) schedules the user's account to be erased at the given time, or with the
+//zero time cancels that.
+func (repo *memoryRepository) SetUserDeletion(ctx context.Context, userID int, when time.Time) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	if !when.IsZero() {
+		when = dish.CanonicalTime(when)
+	}
+	for i := range repo.users {
+		if repo.users[i].UserID == userID {
+			repo.users[i].DeletionDate = when
+		}
+	}
+	return nil
+}
+
+//DeleteUser(uID int) deletes the user with the given id
+func (repo *memoryRepository) DeleteUser(ctx context.Context, uID int) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	remaining := repo.users[:0]
+	for _, u := range repo.users {
+		if u.UserID != uID {
+			remaining = append(remaining, u)
+		}
+	}
+	repo.users = remaining
+
+	return nil
+}
+
+//GetStorages(householdID int) returns the []storage units the household has.
+func (repo *memoryRepository) GetStorages(ctx context.Context, householdID int) (*storage.Storages, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	var resultingStorages storage.Storages
+	for _, s := range repo.storages {
+		if s.HouseholdID == householdID {
+			resultingStorages = append(resultingStorages, s)
+		}
+	}
+	if len(resultingStorages) < 1 {
+		return nil, fcerr.NewNotFoundError("Database could not find any storage units for this user")
+	}
+
+	return &resultingStorages, nil
+}
+
+//GetStorageByID(householdID int, pID int) gets the storage belonging to the household with the personal id given
+func (repo *memoryRepository) GetStorageByID(ctx context.Context, householdID int, pID int) (*storage.Storage, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	return repo.findStorage(func(s storage.Storage) bool { return s.HouseholdID == householdID && s.PersonalID == pID },
+		"Database could not find a storage unit with this ID")
+}
+
+//GetStorageByTempMatch(tM string) gets the storage with this temp_match.
+func (repo *memoryRepository) GetStorageByTempMatch(ctx context.Context, tM string) (*storage.Storage, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	return repo.findStorage(func(s storage.Storage) bool { return s.TempMatch == tM },
+		"Database could not find a storage unit with this ID")
+}
+
+//GetPersonalStorageCount(householdID int) gets the number of storage units the given household has
+func (repo *memoryRepository) GetPersonalStorageCount(ctx context.Context, householdID int) (int, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return 0, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	count := 0
+	for _, s := range repo.storages {
+		if s.HouseholdID == householdID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+//CreateStorage(s storage.Storage) takes a storage object and adds it with a new id and temp match,
+//keeping personal ids unique per household like the table's UNIQUE (household_id, personal_id)
+func (repo *memoryRepository) CreateStorage(ctx context.Context, s storage.Storage) (*storage.Storage, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for _, existing := range repo.storages {
+		if existing.HouseholdID == s.HouseholdID && existing.PersonalID == s.PersonalID {
+			return nil, fcerr.NewInternalServerError("Error while inserting the storage unit into the database")
+		}
+	}
+
+	repo.lastStorageID++
+	s.StorageID = repo.lastStorageID
+	s.TempMatch = generateTempMatch()
+	repo.storages = append(repo.storages, s)
+
+	return &s, nil
+}
+
+//UpdateStorage(s storage.Storage) takes a storage object and updates the existing storage with the same id to match
+func (repo *memoryRepository) UpdateStorage(ctx context.Context, s storage.Storage) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for i := range repo.storages {
+		if repo.storages[i].StorageID == s.StorageID {
+			current := &repo.storages[i]
+			current.PersonalID = s.PersonalID
+			current.Title = s.Title
+			current.Description = s.Description
+			current.TempMatch = s.TempMatch
+		}
+	}
+
+	_, err := repo.findStorage(func(c storage.Storage) bool { return c.HouseholdID == s.HouseholdID && c.PersonalID == s.PersonalID }, "")
+	if err != nil {
+		return fcerr.NewInternalServerError("Error while checking the storage unit that was created." +
+			" Cannot verify if anything was updated in the Database")
+	}
+
+	return nil
+}
+
+//DeleteStorage(householdID int, pID int) deletes the household's empty storage unit and shifts the personal ids after it, and their dishes, down by one
+func (repo *memoryRepository) DeleteStorage(ctx context.Context, householdID int, pID int) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	personalStorageCount := 0
+	for _, s := range repo.storages {
+		if s.HouseholdID == householdID {
+			personalStorageCount++
+		}
+	}
+	if pID < 1 || pID > personalStorageCount {
+		return fcerr.NewBadRequestError("Could not delete a storage unit that doesn't exist")
+	}
+
+	for _, d := range repo.dishes {
+		if d.HouseholdID == householdID && d.StorageID == pID {
+			return fcerr.NewBadRequestError("Could not delete a storage unit that still has dishes in it")
+		}
+	}
+
+	remaining := repo.storages[:0]
+	for _, s := range repo.storages {
+		if s.HouseholdID == householdID && s.PersonalID == pID {
+			continue
+		}
+		remaining = append(remaining, s)
+	}
+	repo.storages = remaining
+
+	for i := range repo.storages {
+		if repo.storages[i].HouseholdID == householdID && repo.storages[i].PersonalID > pID {
+			repo.storages[i].PersonalID--
+		}
+	}
+	for i := range repo.dishes {
+		if repo.dishes[i].HouseholdID == householdID && repo.dishes[i].StorageID > pID {
+			repo.dishes[i].StorageID--
+		}
+	}
+
+	return nil
+}
+
+//GetStorageDishes(householdID int, storagePID int) returns the []dish contained in that household's matching storage unit
+func (repo *memoryRepository) GetStorageDishes(ctx context.Context, householdID int, storagePID int) (*dish.Dishes, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	resultStorage, storageErr := repo.findStorage(func(s storage.Storage) bool { return s.HouseholdID == householdID && s.PersonalID == storagePID }, "")
+	if storageErr != nil {
+		return nil, fcerr.NewNotFoundError("Database could not find such a storage unit")
+	}
+
+	var resultDishes dish.Dishes
+	for _, d := range repo.dishes {
+		if d.HouseholdID == householdID && d.StorageID == resultStorage.PersonalID {
+			resultDishes = append(resultDishes, d)
+		}
+	}
+	if len(resultDishes) < 1 {
+		return nil, fcerr.NewNotFoundError("Database could not find any dishes that belong to this storage unit")
+	}
+
+	return &resultDishes, nil
+}
+
+//GetSessionByTokenHash(hash string) gets the session whose token hashes to the given value.
+func (repo *memoryRepository) GetSessionByTokenHash(ctx context.Context, hash string) (*session.Session, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for _, s := range repo.sessions {
+		if s.TokenHash == hash {
+			found := s
+			return &found, nil
+		}
+	}
+	return nil, fcerr.NewNotFoundError("Database could not find a session with this token")
+}
+
+//CreateSession(s session.Session) adds a session with a new id, keeping token hashes unique.
+func (repo *memoryRepository) CreateSession(ctx context.Context, s session.Session) (*session.Session, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for _, existing := range repo.sessions {
+		if existing.TokenHash == s.TokenHash {
+			return nil, fcerr.NewInternalServerError("Error while inserting the session into the database")
+		}
+	}
+
+	repo.lastSessionID++
+	s.SessionID = repo.lastSessionID
+	s.CreatedDate = dish.CanonicalTime(s.CreatedDate)
+	s.ExpireDate = dish.CanonicalTime(s.ExpireDate)
+	s.RevokedDate = time.Time{}
+	repo.sessions = append(repo.sessions, s)
+
+	return &s, nil
+}
+
+//RevokeSession(hash string, when time.Time) marks the session with this token hash as revoked, if it isn't already.
+func (repo *memoryRepository) RevokeSession(ctx context.Context, hash string, when time.Time) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	repo.revokeSessions(func(s session.Session) bool { return s.TokenHash == hash }, when)
+	return nil
+}
+
+//RevokeUserSessions(userID int, when time.Time) marks every session the user still has as revoked.
+func (repo *memoryRepository) RevokeUserSessions(ctx context.Context, userID int, when time.Time) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	repo.revokeSessions(func(s session.Session) bool { return s.UserID == userID }, when)
+	return nil
+}
+
+//DeleteUserSessions(userID int) deletes every session the user has had, logged out or not.
+func (repo *memoryRepository) DeleteUserSessions(ctx context.Context, userID int) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	remaining := repo.sessions[:0]
+	for _, s := range repo.sessions {
+		if s.UserID != userID {
+			remaining = append(remaining, s)
+		}
+	}
+	repo.sessions = remaining
+	return nil
+}
+
+//revokeSessions sets the revoked time on the matching sessions that aren't revoked yet. Callers hold mu.
+func (repo *memoryRepository) revokeSessions(match func(session.Session) bool, when time.Time) {
+	for i := range repo.sessions {
+		if match(repo.sessions[i]) && repo.sessions[i].RevokedDate.IsZero() {
+			repo.sessions[i].RevokedDate = dish.CanonicalTime(when)
+		}
+	}
+}
+
+//GetIdentity(provider string, externalID string) gets the identity linked from the provider's account with this id.
+func (repo *memoryRepository) GetIdentity(ctx context.Context, provider string, externalID string) (*identity.Identity, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	return repo.findIdentity(func(i identity.Identity) bool { return i.Provider == provider && i.ExternalID == externalID },
+		"Database could not find an identity linked from this account")
+}
+
+//GetIdentityByRefreshHash(hash string) gets the identity whose refresh token hashes to the given value.
+func (repo *memoryRepository) GetIdentityByRefreshHash(ctx context.Context, hash string) (*identity.Identity, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	return repo.findIdentity(func(i identity.Identity) bool { return i.RefreshTokenHash == hash },
+		"Database could not find an identity with this refresh token")
+}
+
+//GetUserIdentities(userID int) gives every identity the user has linked, oldest first.
+func (repo *memoryRepository) GetUserIdentities(ctx context.Context, userID int) (*identity.Identities, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	identities := identity.Identities{}
+	for _, i := range repo.identities {
+		if i.UserID == userID {
+			identities = append(identities, i)
+		}
+	}
+	return &identities, nil
+}
+
+//CreateIdentity(i identity.Identity) adds a linked identity with a new id.
+func (repo *memoryRepository) CreateIdentity(ctx context.Context, i identity.Identity) (*identity.Identity, fcerr.FCErr) {
+	if i.RefreshTokenHash == "" {
+		return nil, fcerr.NewInternalServerError("A linked identity needs a refresh token")
+	}
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	repo.lastIdentityID++
+	i.LinkID = repo.lastIdentityID
+	i.CreatedDate = dish.CanonicalTime(i.CreatedDate)
+	repo.identities = append(repo.identities, i)
+
+	return &i, nil
+}
+
+//UpdateIdentity(i identity.Identity) saves the identity's external id and token hashes.
+func (repo *memoryRepository) UpdateIdentity(ctx context.Context, i identity.Identity) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for n := range repo.identities {
+		if repo.identities[n].LinkID == i.LinkID {
+			current := &repo.identities[n]
+			current.ExternalID = i.ExternalID
+			current.AccessTokenHash = i.AccessTokenHash
+			current.RefreshTokenHash = i.RefreshTokenHash
+		}
+	}
+	return nil
+}
+
+//DeleteIdentity(userID int, linkID int) removes one of the user's linked identities, or gives a 404 if they have none with that id.
+func (repo *memoryRepository) DeleteIdentity(ctx context.Context, userID int, linkID int) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	remaining := repo.identities[:0]
+	for _, i := range repo.identities {
+		if i.UserID != userID || i.LinkID != linkID {
+			remaining = append(remaining, i)
+		}
+	}
+	if len(remaining) == len(repo.identities) {
+		return fcerr.NewNotFoundError("Database could not find a linked identity with this id")
+	}
+	repo.identities = remaining
+	return nil
+}
+
+//GetHousehold(householdID int) gets the household with the given id.
+func (repo *memoryRepository) GetHousehold(ctx context.Context, householdID int) (*household.Household, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for _, h := range repo.households {
+		if h.HouseholdID == householdID {
+			return &h, nil
+		}
+	}
+	return nil, fcerr.NewNotFoundError("Database could not find a household with this ID")
+}
+
+//CreateHousehold(h household.Household) adds a household with a new id.
+func (repo *memoryRepository) CreateHousehold(ctx context.Context, h household.Household) (*household.Household, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	repo.lastHouseholdID++
+	h.HouseholdID = repo.lastHouseholdID
+	h.CreatedDate = dish.CanonicalTime(h.CreatedDate)
+	h.Members = nil
+	h.TempMatch = ""
+	repo.households = append(repo.households, h)
+
+	return &h, nil
+}
+
+//UpdateHousehold(h household.Household) saves the household's name.
+func (repo *memoryRepository) UpdateHousehold(ctx context.Context, h household.Household) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for i := range repo.households {
+		if repo.households[i].HouseholdID == h.HouseholdID {
+			repo.households[i].Name = h.Name
+		}
+	}
+	return nil
+}
+
+//DeleteHousehold(householdID int) deletes the household, leaving its members, storage units and dishes alone.
+func (repo *memoryRepository) DeleteHousehold(ctx context.Context, householdID int) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	remaining := repo.households[:0]
+	for _, h := range repo.households {
+		if h.HouseholdID != householdID {
+			remaining = append(remaining, h)
+		}
+	}
+	repo.households = remaining
+	return nil
+}
+
+//DeleteHouseholdFood(householdID int) deletes every dish and storage unit the household has, and the record of the
+//dishes it removed.
+func (repo *memoryRepository) DeleteHouseholdFood(ctx context.Context, householdID int) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	remainingDishes := repo.dishes[:0]
+	for _, d := range repo.dishes {
+		if d.HouseholdID != householdID {
+			remainingDishes = append(remainingDishes, d)
+		}
+	}
+	repo.dishes = remainingDishes
+
+	remainingStorages := repo.storages[:0]
+	for _, st := range repo.storages {
+		if st.HouseholdID != householdID {
+			remainingStorages = append(remainingStorages, st)
+		}
+	}
+	repo.storages = remainingStorages
+
+	remainingRemovals := repo.removals[:0]
+	for _, r := range repo.removals {
+		if r.HouseholdID != householdID {
+			remainingRemovals = append(remainingRemovals, r)
+		}
+	}
+	repo.removals = remainingRemovals
+	return nil
+}
+
+//GetMembership(userID int) gets the user's place in their household, or gives a 404 if they aren't in one.
+func (repo *memoryRepository) GetMembership(ctx context.Context, userID int) (*household.Member, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for _, m := range repo.members {
+		if m.UserID == userID {
+			found := repo.withUser(m)
+			return &found, nil
+		}
+	}
+	return nil, fcerr.NewNotFoundError("Database could not find a household this user is in")
+}
+
+//GetHouseholdMembers(householdID int) gives everyone in the household, in the order they joined.
+func (repo *memoryRepository) GetHouseholdMembers(ctx context.Context, householdID int) (*household.Members, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	members := household.Members{}
+	for _, m := range repo.members {
+		if m.HouseholdID == householdID {
+			members = append(members, repo.withUser(m))
+		}
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		if !members[i].JoinedDate.Equal(members[j].JoinedDate) {
+			return members[i].JoinedDate.Before(members[j].JoinedDate)
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return &members, nil
+}
+
+//CreateMembership(m household.Member) puts the user in the household. A user already in a household can't be added to another.
+func (repo *memoryRepository) CreateMembership(ctx context.Context, m household.Member) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for _, existing := range repo.members {
+		if existing.UserID == m.UserID {
+			return fcerr.NewInternalServerError("Error while inserting the household member into the database")
+		}
+	}
+	m.JoinedDate = dish.CanonicalTime(m.JoinedDate)
+	m.Email, m.FullName = "", ""
+	repo.members = append(repo.members, m)
+	return nil
+}
+
+//UpdateMembership(m household.Member) saves the member's role.
+func (repo *memoryRepository) UpdateMembership(ctx context.Context, m household.Member) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for i := range repo.members {
+		if repo.members[i].HouseholdID == m.HouseholdID && repo.members[i].UserID == m.UserID {
+			repo.members[i].Role = m.Role
+		}
+	}
+	return nil
+}
+
+//DeleteMembership(householdID int, userID int) takes the user out of the household, or gives a 404 if they aren't in it.
+func (repo *memoryRepository) DeleteMembership(ctx context.Context, householdID int, userID int) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	remaining := repo.members[:0]
+	for _, m := range repo.members {
+		if m.HouseholdID != householdID || m.UserID != userID {
+			remaining = append(remaining, m)
+		}
+	}
+	if len(remaining) == len(repo.members) {
+		return fcerr.NewNotFoundError("Database could not find this member in the household")
+	}
+	repo.members = remaining
+	return nil
+}
+
+//GetInvite(inviteID int) gets the household invite with the given id.
+func (repo *memoryRepository) GetInvite(ctx context.Context, inviteID int) (*household.Invite, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for _, i := range repo.invites {
+		if i.InviteID == inviteID {
+			found := i
+			return &found, nil
+		}
+	}
+	return nil, fcerr.NewNotFoundError("Database could not find a household invite with this ID")
+}
+
+//GetInvitesByEmail(email string) gives the invites sent to the email, oldest first. Emails are kept in lower case.
+func (repo *memoryRepository) GetInvitesByEmail(ctx context.Context, email string) (*household.Invites, fcerr.FCErr) {
+	return repo.findInvites(ctx, func(i household.Invite) bool { return i.Email == email })
+}
+
+//GetHouseholdInvites(householdID int) gives the invites the household has sent, oldest first.
+func (repo *memoryRepository) GetHouseholdInvites(ctx context.Context, householdID int) (*household.Invites, fcerr.FCErr) {
+	return repo.findInvites(ctx, func(i household.Invite) bool { return i.HouseholdID == householdID })
+}
+
+//findInvites gives the invites that match, in id order.
+func (repo *memoryRepository) findInvites(ctx context.Context, match func(household.Invite) bool) (*household.Invites, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	invites := household.Invites{}
+	for _, i := range repo.invites {
+		if match(i) {
+			invites = append(invites, i)
+		}
+	}
+	return &invites, nil
+}
+
+//CreateInvite(i household.Invite) adds a household invite with a new id.
+func (repo *memoryRepository) CreateInvite(ctx context.Context, i household.Invite) (*household.Invite, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	repo.lastInviteID++
+	i.InviteID = repo.lastInviteID
+	i.CreatedDate = dish.CanonicalTime(i.CreatedDate)
+	i.ExpireDate = dish.CanonicalTime(i.ExpireDate)
+	i.HouseholdName, i.From, i.TempMatch = "", "", ""
+	repo.invites = append(repo.invites, i)
+
+	created := i
+	return &created, nil
+}
+
+//DeleteInvite(inviteID int) deletes the household invite. Deleting one that is already gone isn't an error.
+func (repo *memoryRepository) DeleteInvite(ctx context.Context, inviteID int) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	remaining := repo.invites[:0]
+	for _, i := range repo.invites {
+		if i.InviteID != inviteID {
+			remaining = append(remaining, i)
+		}
+	}
+	repo.invites = remaining
+	return nil
+}
+
+//GetNotifications(userID int) gives the notifications the user was sent, oldest first.
+func (repo *memoryRepository) GetNotifications(ctx context.Context, userID int) (*notification.Notifications, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	notifications := notification.Notifications{}
+	for _, n := range repo.notifications {
+		if n.UserID == userID {
+			notifications = append(notifications, n)
+		}
+	}
+	return &notifications, nil
+}
+
+//CreateNotification(n notification.Notification) records that the user was sent a notification.
+func (repo *memoryRepository) CreateNotification(ctx context.Context, n notification.Notification) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	repo.lastNotificationID++
+	n.NotificationID = repo.lastNotificationID
+	n.ExpireDate = dish.CanonicalTime(n.ExpireDate)
+	n.SentDate = dish.CanonicalTime(n.SentDate)
+	repo.notifications = append(repo.notifications, n)
+	return nil
+}
+
+//DeleteNotificationsBefore(expired time.Time) clears out the notifications about dishes that expired before the given time.
+func (repo *memoryRepository) DeleteNotificationsBefore(ctx context.Context, expired time.Time) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	remaining := repo.notifications[:0]
+	for _, n := range repo.notifications {
+		if !n.ExpireDate.Before(dish.CanonicalTime(expired)) {
+			remaining = append(remaining, n)
+		}
+	}
+	repo.notifications = remaining
+	return nil
+}
+
+//DeleteUserNotifications(userID int) deletes every notification the user was sent.
+func (repo *memoryRepository) DeleteUserNotifications(ctx context.Context, userID int) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	remaining := repo.notifications[:0]
+	for _, n := range repo.notifications {
+		if n.UserID != userID {
+			remaining = append(remaining, n)
+		}
+	}
+	repo.notifications = remaining
+	return nil
+}
+
+//withUser fills in the member's email and name from their user, like the join in the mysql queries. Callers hold mu.
+func (repo *memoryRepository) withUser(m household.Member) household.Member {
+	for _, u := range repo.users {
+		if u.UserID == m.UserID {
+			m.Email, m.FullName = u.Email, u.FullName
+		}
+	}
+	return m
+}
+
+//lock takes mu, unless ctx is already cancelled or past its deadline - then it gives the same 504 the sql repository does.
+func (repo *memoryRepository) lock(ctx context.Context) fcerr.FCErr {
+	if ctx.Err() != nil {
+		return dbError(ctx, "")
+	}
+	repo.mu.Lock()
+	return nil
+}
+
+//findDish returns a copy of the single dish matching, with the same errors the mysql repository gives. Callers hold mu.
+func (repo *memoryRepository) findDish(match func(dish.Dish) bool, notFound string) (*dish.Dish, fcerr.FCErr) {
+	var result *dish.Dish
+	for _, d := range repo.dishes {
+		if match(d) {
+			if result != nil {
+				return nil, fcerr.NewInternalServerError("Database returned more than 1 row when only 1 was expected")
+			}
+			found := d
+			result = &found
+		}
+	}
+	if result == nil {
+		return nil, fcerr.NewNotFoundError(notFound)
+	}
+	return result, nil
+}
+
+//findUser returns a copy of the single user matching. Callers hold mu.
+func (repo *memoryRepository) findUser(match func(user.User) bool, notFound string) (*user.User, fcerr.FCErr) {
+	var result *user.User
+	for _, u := range repo.users {
+		if match(u) {
+			if result != nil {
+				return nil, fcerr.NewInternalServerError("Database returned more than 1 row when only 1 was expected")
+			}
+			found := u
+			result = &found
+		}
+	}
+	if result == nil {
+		return nil, fcerr.NewNotFoundError(notFound)
+	}
+	return result, nil
+}
+
+//findIdentity returns a copy of the single linked identity matching. Callers hold mu.
+func (repo *memoryRepository) findIdentity(match func(identity.Identity) bool, notFound string) (*identity.Identity, fcerr.FCErr) {
+	var result *identity.Identity
+	for _, i := range repo.identities {
+		if match(i) {
+			if result != nil {
+				return nil, fcerr.NewInternalServerError("Database returned more than 1 row when only 1 was expected")
+			}
+			found := i
+			result = &found
+		}
+	}
+	if result == nil {
+		return nil, fcerr.NewNotFoundError(notFound)
+	}
+	return result, nil
+}
+
+//findStorage returns a copy of the single storage unit matching. Callers hold mu.
+func (repo *memoryRepository) findStorage(match func(storage.Storage) bool, notFound string) (*storage.Storage, fcerr.FCErr) {
+	var result *storage.Storage
+	for _, s := range repo.storages {
+		if match(s) {
+			if result != nil {
+				return nil, fcerr.NewInternalServerError("Database returned more than 1 row when only 1 was expected")
+			}
+			found := s
+			result = &found
+		}
+	}
+	if result == nil {
+		return nil, fcerr.NewNotFoundError(notFound)
+	}
+	return result, nil
+}
+
+//dishCount is GetPersonalDishCount for callers that already hold mu.
+func (repo *memoryRepository) dishCount(householdID int) int {
+	count := 0
+	for _, d := range repo.dishes {
+		if d.HouseholdID == householdID {
+			count++
+		}
+	}
+	return count
+}
+
+//GetRemovals(householdID int, since time.Time) gives the dishes the household removed since the given time, oldest first.
+func (repo *memoryRepository) GetRemovals(ctx context.Context, householdID int, since time.Time) (*dish.Removals, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	removals := dish.Removals{}
+	for _, r := range repo.removals {
+		if r.HouseholdID == householdID && !r.RemovedDate.Before(dish.CanonicalTime(since)) {
+			removals = append(removals, r)
+		}
+	}
+	return &removals, nil
+}
+
+//CreateRemoval(r dish.Removal) records that a dish left its household, and whether it was eaten or wasted.
+func (repo *memoryRepository) CreateRemoval(ctx context.Context, r dish.Removal) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	repo.lastRemovalID++
+	r.RemovalID = repo.lastRemovalID
+	r.ExpireDate = dish.CanonicalTime(r.ExpireDate)
+	r.RemovedDate = dish.CanonicalTime(r.RemovedDate)
+	repo.removals = append(repo.removals, r)
+	return nil
+}
+
+//GetWebhooks(userID int) gives the user's webhook subscriptions, oldest first.
+func (repo *memoryRepository) GetWebhooks(ctx context.Context, userID int) (*webhook.Subscriptions, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	subscriptions := webhook.Subscriptions{}
+	for _, w := range repo.webhooks {
+		if w.UserID == userID {
+			subscriptions = append(subscriptions, copyWebhook(w))
+		}
+	}
+	return &subscriptions, nil
+}
+
+//GetWebhookByID(userID int, id int) gets the user's webhook subscription with the given id.
+func (repo *memoryRepository) GetWebhookByID(ctx context.Context, userID int, id int) (*webhook.Subscription, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for _, w := range repo.webhooks {
+		if w.UserID == userID && w.SubscriptionID == id {
+			found := copyWebhook(w)
+			return &found, nil
+		}
+	}
+	return nil, fcerr.NewNotFoundError("Database could not find a webhook with this ID")
+}
+
+//GetHouseholdWebhooks(householdID int) gives the active webhook subscriptions of everyone in the household, oldest first.
+func (repo *memoryRepository) GetHouseholdWebhooks(ctx context.Context, householdID int) (*webhook.Subscriptions, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	inHousehold := map[int]bool{}
+	for _, m := range repo.members {
+		if m.HouseholdID == householdID {
+			inHousehold[m.UserID] = true
+		}
+	}
+	subscriptions := webhook.Subscriptions{}
+	for _, w := range repo.webhooks {
+		if w.Active && inHousehold[w.UserID] {
+			subscriptions = append(subscriptions, copyWebhook(w))
+		}
+	}
+	return &subscriptions, nil
+}
+
+//copyWebhook gives a copy of w that doesn't share its list of events.
+func copyWebhook(w webhook.Subscription) webhook.Subscription {
+	w.Events = append([]string{}, w.Events...)
+	return w
+}
+
+//CreateWebhook(s webhook.Subscription) adds the webhook subscription with a new id.
+func (repo *memoryRepository) CreateWebhook(ctx context.Context, s webhook.Subscription) (*webhook.Subscription, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	repo.lastWebhookID++
+	s.SubscriptionID = repo.lastWebhookID
+	s.CreatedDate = dish.CanonicalTime(s.CreatedDate)
+	s.TempMatch = ""
+	s = copyWebhook(s)
+	repo.webhooks = append(repo.webhooks, s)
+
+	created := copyWebhook(s)
+	return &created, nil
+}
+
+//UpdateWebhook(s webhook.Subscription) saves the subscription's url, events and whether it is active.
+func (repo *memoryRepository) UpdateWebhook(ctx context.Context, s webhook.Subscription) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for i := range repo.webhooks {
+		if repo.webhooks[i].UserID == s.UserID && repo.webhooks[i].SubscriptionID == s.SubscriptionID {
+			repo.webhooks[i].URL = s.URL
+			repo.webhooks[i].Events = append([]string{}, s.Events...)
+			repo.webhooks[i].Active = s.Active
+		}
+	}
+	return nil
+}
+
+//DeleteWebhook(userID int, id int) deletes the user's webhook subscription and its deliveries, or gives a 404 if the user
+//has no subscription with this id.
+func (repo *memoryRepository) DeleteWebhook(ctx context.Context, userID int, id int) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	remainingDeliveries := repo.deliveries[:0]
+	for _, d := range repo.deliveries {
+		if d.UserID != userID || d.SubscriptionID != id {
+			remainingDeliveries = append(remainingDeliveries, d)
+		}
+	}
+	repo.deliveries = remainingDeliveries
+
+	for i, w := range repo.webhooks {
+		if w.UserID == userID && w.SubscriptionID == id {
+			repo.webhooks = append(repo.webhooks[:i], repo.webhooks[i+1:]...)
+			return nil
+		}
+	}
+	return fcerr.NewNotFoundError("Database could not find a webhook with this id")
+}
+
+//DeleteUserWebhooks(userID int) deletes every webhook subscription the user has, and their deliveries.
+func (repo *memoryRepository) DeleteUserWebhooks(ctx context.Context, userID int) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	remainingDeliveries := repo.deliveries[:0]
+	for _, d := range repo.deliveries {
+		if d.UserID != userID {
+			remainingDeliveries = append(remainingDeliveries, d)
+		}
+	}
+	repo.deliveries = remainingDeliveries
+
+	remaining := repo.webhooks[:0]
+	for _, w := range repo.webhooks {
+		if w.UserID != userID {
+			remaining = append(remaining, w)
+		}
+	}
+	repo.webhooks = remaining
+	return nil
+}
+
+//GetDeliveries(userID int, subscriptionID int) gives the latest MaxDeliveries deliveries to the user's webhook
+//subscription, newest first.
+func (repo *memoryRepository) GetDeliveries(ctx context.Context, userID int, subscriptionID int) (*webhook.Deliveries, fcerr.FCErr) {
+	return repo.latestDeliveries(ctx, func(d webhook.Delivery) bool {
+		return d.UserID == userID && d.SubscriptionID == subscriptionID
+	})
+}
+
+//GetDeliveriesByStatus(userID int, status string) gives the latest MaxDeliveries deliveries to any of the user's webhook
+//subscriptions that are in the given state, newest first.
+func (repo *memoryRepository) GetDeliveriesByStatus(ctx context.Context, userID int, status string) (*webhook.Deliveries, fcerr.FCErr) {
+	return repo.latestDeliveries(ctx, func(d webhook.Delivery) bool {
+		return d.UserID == userID && d.Status == status
+	})
+}
+
+//latestDeliveries gives the latest MaxDeliveries deliveries matching, newest first.
+func (repo *memoryRepository) latestDeliveries(ctx context.Context, match func(webhook.Delivery) bool) (*webhook.Deliveries, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	deliveries := webhook.Deliveries{}
+	for i := len(repo.deliveries) - 1; i >= 0 && len(deliveries) < MaxDeliveries; i-- {
+		if match(repo.deliveries[i]) {
+			deliveries = append(deliveries, repo.deliveries[i])
+		}
+	}
+	return &deliveries, nil
+}
+
+//GetDeliveryByID(userID int, id int) gets the delivery with the given id to one of the user's webhook subscriptions.
+func (repo *memoryRepository) GetDeliveryByID(ctx context.Context, userID int, id int) (*webhook.Delivery, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for _, d := range repo.deliveries {
+		if d.UserID == userID && d.DeliveryID == id {
+			return &d, nil
+		}
+	}
+	return nil, fcerr.NewNotFoundError("Database could not find a delivery with this ID")
+}
+
+//GetDueDeliveries(now time.Time) gives the MaxDueDeliveries pending deliveries, to anyone, whose next attempt is due by
+//now and that have waited longest.
+func (repo *memoryRepository) GetDueDeliveries(ctx context.Context, now time.Time) (*webhook.Deliveries, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	now = dish.CanonicalTime(now)
+	deliveries := webhook.Deliveries{}
+	for _, d := range repo.deliveries {
+		if d.Status == webhook.Pending && !d.NextAttempt.After(now) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttempt.Before(deliveries[j].NextAttempt)
+	})
+	if len(deliveries) > MaxDueDeliveries {
+		deliveries = deliveries[:MaxDueDeliveries]
+	}
+	return &deliveries, nil
+}
+
+//CreateDelivery(d webhook.Delivery) queues an event to be posted to a webhook subscription, with a new id.
+func (repo *memoryRepository) CreateDelivery(ctx context.Context, d webhook.Delivery) (*webhook.Delivery, fcerr.FCErr) {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return nil, fcErr
+	}
+	defer repo.mu.Unlock()
+
+	repo.lastDeliveryID++
+	d.DeliveryID = repo.lastDeliveryID
+	d.NextAttempt = dish.CanonicalTime(d.NextAttempt)
+	d.CreatedDate = dish.CanonicalTime(d.CreatedDate)
+	d.UpdatedDate = dish.CanonicalTime(d.UpdatedDate)
+	d.TempMatch = ""
+	repo.deliveries = append(repo.deliveries, d)
+	return &d, nil
+}
+
+//UpdateDelivery(d webhook.Delivery) saves how the delivery's latest attempt went and when it is next due.
+func (repo *memoryRepository) UpdateDelivery(ctx context.Context, d webhook.Delivery) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	for i := range repo.deliveries {
+		if repo.deliveries[i].DeliveryID == d.DeliveryID {
+			saved := &repo.deliveries[i]
+			saved.Status, saved.Attempts, saved.ResponseCode, saved.LastError = d.Status, d.Attempts, d.ResponseCode, d.LastError
+			saved.NextAttempt, saved.UpdatedDate = dish.CanonicalTime(d.NextAttempt), dish.CanonicalTime(d.UpdatedDate)
+		}
+	}
+	return nil
+}
+
+//DeleteDeliveriesBefore(before time.Time) forgets the deliveries that were delivered or given up on before the given time.
+func (repo *memoryRepository) DeleteDeliveriesBefore(ctx context.Context, before time.Time) fcerr.FCErr {
+	if fcErr := repo.lock(ctx); fcErr != nil {
+		return fcErr
+	}
+	defer repo.mu.Unlock()
+
+	before = dish.CanonicalTime(before)
+	remaining := repo.deliveries[:0]
+	for _, d := range repo.deliveries {
+		if d.Status == webhook.Pending || !d.UpdatedDate.Before(before) {
+			remaining = append(remaining, d)
+		}
+	}
+	repo.deliveries = remaining
+	return nil
+}
@@ -0,0 +1,368 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//migrationFiles holds every NNNN_name.up.sql / NNNN_name.down.sql pair under migrations/.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+//CreateMigrationsTableQuery creates the table that records which migrations have been applied.
+const CreateMigrationsTableQuery = `CREATE TABLE IF NOT EXISTS schema_migrations (` +
+	`version INT NOT NULL, name VARCHAR(255) NOT NULL, applied_at VARCHAR(32) NOT NULL, PRIMARY KEY (version))`
+
+//GetMigrationVersionsQuery is the Query for AppliedMigrations().
+const GetMigrationVersionsQuery = `SELECT version FROM schema_migrations ORDER BY version`
+
+//InsertMigrationQuery records a migration as applied, bound with the version, name and applied time.
+const InsertMigrationQuery = `INSERT INTO schema_migrations (version, name, applied_at) VALUES(?, ?, ?)`
+
+//DeleteMigrationQuery removes the record of an applied migration, bound with the version.
+const DeleteMigrationQuery = `DELETE FROM schema_migrations WHERE version = ?`
+
+//GetDishDatesQuery is the Query normalizeDishDates() reads every dish's dates with.
+const GetDishDatesQuery = `SELECT id, created_date, expire_date FROM dish`
+
+//UpdateDishDatesQuery is how normalizeDishDates() rewrites a dish's dates, bound with the created date, the expire date
+//and the dish id.
+const UpdateDishDatesQuery = `UPDATE dish SET created_date = ?, expire_date = ? WHERE id = ?`
+
+//dataMigrations rewrite rows where SQL alone can't read them, keyed by the version they run with. Each runs in the
+//version's transaction, before its statements, so one that fails leaves nothing behind even on MySQL, which commits
+//DDL as it goes. They aren't undone by a rollback.
+var dataMigrations = map[int]func(tx *sql.Tx) error{
+	15: normalizeDishDates,
+}
+
+//legacyDishFormats are the layouts dish dates were written in before dish.StorageFormat. The ones without a zone are
+//read as UTC, which is what the service always wrote them in.
+var legacyDishFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+//normalizeDishDates rewrites every dish date not already in dish.StorageFormat into it, so the next migration can move
+//them into DATETIME columns. A date none of the legacy layouts reads fails the migration, naming the dishes, rather than
+//being lost - fix or clear those dates by hand and start the service again.
+func normalizeDishDates(tx *sql.Tx) error {
+	rows, err := tx.Query(GetDishDatesQuery)
+	if err != nil {
+		return err
+	}
+	type dishDates struct {
+		id               int
+		created, expires string
+	}
+	var stale []dishDates
+	var unreadable []string
+	for rows.Next() {
+		var d dishDates
+		if err := rows.Scan(&d.id, &d.created, &d.expires); err != nil {
+			rows.Close()
+			return err
+		}
+		created, createdOK := normalizedDishDate(d.created)
+		expires, expiresOK := normalizedDishDate(d.expires)
+		if !createdOK || !expiresOK {
+			unreadable = append(unreadable, fmt.Sprintf("dish %d (created %q, expires %q)", d.id, d.created, d.expires))
+			continue
+		}
+		if created != d.created || expires != d.expires {
+			stale = append(stale, dishDates{id: d.id, created: created, expires: expires})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(unreadable) > 0 {
+		return fmt.Errorf("these dishes have dates that aren't dates: %s", strings.Join(unreadable, ", "))
+	}
+
+	for _, d := range stale {
+		if _, err := tx.Exec(UpdateDishDatesQuery, d.created, d.expires, d.id); err != nil {
+			return err
+		}
+	}
+	fmt.Println("rewrote the dates of", len(stale), "dishes into", dish.StorageFormat)
+	return nil
+}
+
+//normalizedDishDate gives the stored date value in dish.StorageFormat, and false if it isn't a date in any layout the
+//service has written.
+func normalizedDishDate(value string) (string, bool) {
+	if value == "" {
+		return "", true
+	}
+	if _, err := time.Parse(dish.StorageFormat, value); err == nil {
+		return value, true
+	}
+	for _, layout := range legacyDishFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return storedTime(t), true
+		}
+	}
+	return "", false
+}
+
+//sqliteReplacer turns the mysql flavoured migration files into statements sqlite accepts.
+//An INTEGER column used as the PRIMARY KEY is sqlite's rowid, so ids are still assigned on insert.
+var sqliteReplacer = strings.NewReplacer(
+	"INT NOT NULL AUTO_INCREMENT", "INTEGER NOT NULL",
+	" ENGINE=InnoDB DEFAULT CHARSET=utf8mb4", "",
+)
+
+//sqliteDropIndex matches mysql's DROP INDEX name ON table - sqlite index names are global, so it is just DROP INDEX name.
+var sqliteDropIndex = regexp.MustCompile(`^DROP INDEX (\w+) ON \w+$`)
+
+//sqliteModifyColumn matches mysql's ALTER TABLE name MODIFY column type. sqlite has no MODIFY, and doesn't hold a column
+//to its declared type or length anyway, so those statements are left out.
+var sqliteModifyColumn = regexp.MustCompile(`^ALTER TABLE \w+ MODIFY `)
+
+//dialectStatement rewrites a migration statement for the given driver; mysql statements are used as written. It gives
+//"" for a statement the driver has no use for.
+func dialectStatement(driver string, statement string) string {
+	if driver == SQLiteDriver {
+		if sqliteModifyColumn.MatchString(statement) {
+			return ""
+		}
+		statement = sqliteDropIndex.ReplaceAllString(statement, "DROP INDEX $1")
+		return sqliteReplacer.Replace(statement)
+	}
+	return statement
+}
+
+//Migration is one versioned schema change, with the statements to apply it and to roll it back.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+	//data is run before Up, if the version has a data migration
+	data func(tx *sql.Tx) error
+}
+
+//Migrations returns every embedded migration, ordered by version.
+func Migrations() ([]Migration, fcerr.FCErr) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fcerr.NewInternalServerError("Error while reading the embedded migrations")
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fcerr.NewInternalServerError("Migration file is not named .up.sql or .down.sql: " + fileName)
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		underscore := strings.Index(base, "_")
+		if underscore < 1 {
+			return nil, fcerr.NewInternalServerError("Migration file is missing its version prefix: " + fileName)
+		}
+		version, err := strconv.Atoi(base[:underscore])
+		if err != nil {
+			return nil, fcerr.NewInternalServerError("Migration file has a non-numeric version: " + fileName)
+		}
+
+		contents, err := migrationFiles.ReadFile(path.Join("migrations", fileName))
+		if err != nil {
+			return nil, fcerr.NewInternalServerError("Error while reading migration file: " + fileName)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: base[underscore+1:], data: dataMigrations[version]}
+			byVersion[version] = m
+		} else if m.Name != base[underscore+1:] {
+			return nil, fcerr.NewInternalServerError(fmt.Sprintf("Migration version %d is used by more than one name", version))
+		}
+
+		if direction == "up" {
+			m.Up = splitStatements(string(contents))
+		} else {
+			m.Down = splitStatements(string(contents))
+		}
+	}
+
+	var result []Migration
+	for _, m := range byVersion {
+		if len(m.Up) == 0 || len(m.Down) == 0 {
+			return nil, fcerr.NewInternalServerError(fmt.Sprintf("Migration %d_%s needs both an up and a down file", m.Version, m.Name))
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+
+	return result, nil
+}
+
+//splitStatements breaks a migration file into single statements, since the mysql driver won't run several in one Exec.
+func splitStatements(contents string) []string {
+	var statements []string
+	for _, statement := range strings.Split(contents, ";") {
+		statement = strings.TrimSpace(statement)
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+//AppliedMigrations returns the versions recorded in schema_migrations, creating that table if it doesn't exist yet.
+func AppliedMigrations(db *sql.DB) ([]int, fcerr.FCErr) {
+	_, err := db.Exec(CreateMigrationsTableQuery)
+	if err != nil {
+		fmt.Println("got an error creating schema_migrations:", err.Error())
+		return nil, fcerr.NewInternalServerError("Error while creating the schema_migrations table")
+	}
+
+	rows, err := db.Query(GetMigrationVersionsQuery)
+	if err != nil {
+		fmt.Println("got an error on the Query:", err.Error())
+		return nil, fcerr.NewInternalServerError("Error while retrieving applied migrations from the database")
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		err := rows.Scan(&version)
+		if err != nil {
+			return nil, fcerr.NewInternalServerError("Error while scanning the result from the database")
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+//Migrate applies every embedded migration that hasn't been recorded in schema_migrations yet, oldest first.
+//The first migrations use CREATE TABLE IF NOT EXISTS so a database built by hand is adopted rather than clobbered.
+func Migrate(db *sql.DB, driver string) fcerr.FCErr {
+	migrations, fcErr := Migrations()
+	if fcErr != nil {
+		return fcErr
+	}
+
+	applied, fcErr := AppliedMigrations(db)
+	if fcErr != nil {
+		return fcErr
+	}
+	isApplied := make(map[int]bool)
+	for _, version := range applied {
+		isApplied[version] = true
+	}
+
+	for _, m := range migrations {
+		if isApplied[m.Version] {
+			continue
+		}
+		fmt.Printf("applying migration %d_%s\n", m.Version, m.Name)
+		fcErr := runMigration(db, driver, m.Up, m.data, InsertMigrationQuery, m.Version, m.Name, time.Now().UTC().Format("2006-01-02T15:04:05"))
+		if fcErr != nil {
+			return fcErr
+		}
+	}
+
+	return nil
+}
+
+//MigrateDown rolls back the newest applied migrations, up to the given number of steps.
+func MigrateDown(db *sql.DB, driver string, steps int) fcerr.FCErr {
+	migrations, fcErr := Migrations()
+	if fcErr != nil {
+		return fcErr
+	}
+	byVersion := make(map[int]Migration)
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	applied, fcErr := AppliedMigrations(db)
+	if fcErr != nil {
+		return fcErr
+	}
+
+	for i := len(applied) - 1; i >= 0 && steps > 0; i-- {
+		m, ok := byVersion[applied[i]]
+		if !ok {
+			return fcerr.NewInternalServerError(fmt.Sprintf("Applied migration %d has no embedded down file", applied[i]))
+		}
+		fmt.Printf("rolling back migration %d_%s\n", m.Version, m.Name)
+		fcErr := runMigration(db, driver, m.Down, nil, DeleteMigrationQuery, m.Version)
+		if fcErr != nil {
+			return fcErr
+		}
+		steps--
+	}
+
+	return nil
+}
+
+//runMigration executes the data migration if there is one, then the statements, and the schema_migrations bookkeeping
+//in one transaction. Note that MySQL commits DDL implicitly, so the transaction only fully protects engines with
+//transactional DDL.
+func runMigration(db *sql.DB, driver string, statements []string, data func(tx *sql.Tx) error, record string, recordArgs ...interface{}) fcerr.FCErr {
+	tx, err := db.Begin()
+	if err != nil {
+		return fcerr.NewInternalServerError("Error while starting the migration transaction")
+	}
+
+	if data != nil {
+		if err := data(tx); err != nil {
+			fmt.Println("got an error running the data migration:", err.Error())
+			tx.Rollback()
+			return fcerr.NewInternalServerError(fmt.Sprintf("Error while running migration %v: %s", recordArgs[0], err.Error()))
+		}
+	}
+
+	for _, statement := range statements {
+		statement = dialectStatement(driver, statement)
+		if statement == "" {
+			continue
+		}
+		_, err := tx.Exec(statement)
+		if err != nil {
+			fmt.Println("got an error running migration statement:", err.Error())
+			tx.Rollback()
+			return fcerr.NewInternalServerError(fmt.Sprintf("Error while running migration %v", recordArgs[0]))
+		}
+	}
+
+	_, err = tx.Exec(record, recordArgs...)
+	if err != nil {
+		tx.Rollback()
+		return fcerr.NewInternalServerError(fmt.Sprintf("Error while recording migration %v", recordArgs[0]))
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fcerr.NewInternalServerError(fmt.Sprintf("Error while committing migration %v", recordArgs[0]))
+	}
+
+	return nil
+}
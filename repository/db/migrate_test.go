@@ -0,0 +1,300 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDb_Migrations_OrderedAndPaired(t *testing.T) {
+	migrations, err := Migrations()
+
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, len(migrations), 3)
+	for i, m := range migrations {
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+		if i > 0 {
+			assert.Greater(t, m.Version, migrations[i-1].Version)
+		}
+	}
+	assert.Equal(t, "create_user", migrations[0].Name)
+}
+
+func TestDb_dialectStatement(t *testing.T) {
+	assert.Equal(t, "CREATE TABLE a (id INTEGER NOT NULL)", dialectStatement(SQLiteDriver, "CREATE TABLE a (id INT NOT NULL AUTO_INCREMENT)"))
+	assert.Equal(t, "DROP INDEX a_b", dialectStatement(SQLiteDriver, "DROP INDEX a_b ON a"))
+	assert.Equal(t, "", dialectStatement(SQLiteDriver, "ALTER TABLE user MODIFY access_token TEXT NOT NULL"))
+	assert.Equal(t, "ALTER TABLE user MODIFY access_token TEXT NOT NULL", dialectStatement(MySQLDriver, "ALTER TABLE user MODIFY access_token TEXT NOT NULL"))
+}
+
+func TestDb_splitStatements(t *testing.T) {
+	statements := splitStatements("CREATE TABLE a (id INT);\n\n  DROP TABLE b;\n")
+
+	assert.Equal(t, []string{"CREATE TABLE a (id INT)", "DROP TABLE b"}, statements)
+}
+
+func TestDb_Migrate_FreshDatabase(t *testing.T) {
+	db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	defer db.Close()
+
+	migrations, _ := Migrations()
+
+	mock.ExpectExec(CreateMigrationsTableQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(GetMigrationVersionsQuery).WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	for _, m := range migrations {
+		mock.ExpectBegin()
+		if m.data != nil {
+			mock.ExpectQuery(GetDishDatesQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "created_date", "expire_date"}))
+		}
+		for _, statement := range m.Up {
+			mock.ExpectExec(statement).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectExec(InsertMigrationQuery).WithArgs(m.Version, m.Name, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	err := Migrate(db, MySQLDriver)
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_Migrate_AlreadyCurrent(t *testing.T) {
+	db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	defer db.Close()
+
+	migrations, _ := Migrations()
+	rows := sqlmock.NewRows([]string{"version"})
+	for _, m := range migrations {
+		rows.AddRow(m.Version)
+	}
+
+	mock.ExpectExec(CreateMigrationsTableQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(GetMigrationVersionsQuery).WillReturnRows(rows)
+
+	err := Migrate(db, MySQLDriver)
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_Migrate_StatementFailsRollsBack(t *testing.T) {
+	db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	defer db.Close()
+
+	migrations, _ := Migrations()
+
+	mock.ExpectExec(CreateMigrationsTableQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(GetMigrationVersionsQuery).WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(migrations[0].Up[0]).WillReturnError(errors.New("table exists with a different definition"))
+	mock.ExpectRollback()
+
+	err := Migrate(db, MySQLDriver)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_Migrate_CantCreateMigrationsTable(t *testing.T) {
+	db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	defer db.Close()
+
+	mock.ExpectExec(CreateMigrationsTableQuery).WillReturnError(errors.New("access denied"))
+
+	err := Migrate(db, MySQLDriver)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+}
+
+func TestDb_MigrateDown_OneStep(t *testing.T) {
+	db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	defer db.Close()
+
+	migrations, _ := Migrations()
+	rows := sqlmock.NewRows([]string{"version"})
+	for _, m := range migrations {
+		rows.AddRow(m.Version)
+	}
+	newest := migrations[len(migrations)-1]
+
+	mock.ExpectExec(CreateMigrationsTableQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(GetMigrationVersionsQuery).WillReturnRows(rows)
+	mock.ExpectBegin()
+	for _, statement := range newest.Down {
+		mock.ExpectExec(statement).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec(DeleteMigrationQuery).WithArgs(newest.Version).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := MigrateDown(db, MySQLDriver, 1)
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDb_Migrate_SQLiteUpDownUp(t *testing.T) {
+	db, fcErr := OpenDatabase(SQLiteDriver, ":memory:")
+	assert.Nil(t, fcErr)
+	defer db.Close()
+
+	migrations, _ := Migrations()
+
+	assert.Nil(t, Migrate(db, SQLiteDriver))
+	applied, _ := AppliedMigrations(db)
+	assert.Equal(t, len(migrations), len(applied))
+
+	assert.Nil(t, MigrateDown(db, SQLiteDriver, len(migrations)))
+	applied, _ = AppliedMigrations(db)
+	assert.Empty(t, applied)
+	_, err := db.Exec(`SELECT ` + DishColumns + ` FROM dish`)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, Migrate(db, SQLiteDriver))
+	_, err = db.Exec(`SELECT ` + DishColumns + ` FROM dish`)
+	assert.Nil(t, err)
+}
+
+func TestDb_NewRepositoryWithDriver_Unknown(t *testing.T) {
+	_, err := NewRepositoryWithDriver("postgres", "")
+
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, err.Status())
+}
+
+func TestDb_Migrate_SQLiteHouseholdsForExistingUsers(t *testing.T) {
+	db, fcErr := OpenDatabase(SQLiteDriver, ":memory:")
+	assert.Nil(t, fcErr)
+	defer db.Close()
+
+	assert.Nil(t, Migrate(db, SQLiteDriver))
+	//back to before households, and whatever came after them
+	migrateDownBefore(t, db, 9)
+
+	//what a user had before households
+	_, err := db.Exec(`INSERT INTO user (id, email, created_date) VALUES (7, 'nothing@gmail.com', '2016-01-02T15:04:05')`)
+	assert.Nil(t, err)
+	_, err = db.Exec(`INSERT INTO storage (id, personal_id, user_id, title) VALUES (3, 1, 7, 'Fridge')`)
+	assert.Nil(t, err)
+	_, err = db.Exec(`INSERT INTO dish (personal_id, user_id, storage_id, title) VALUES (1, 7, 1, 'Carrots')`)
+	assert.Nil(t, err)
+
+	assert.Nil(t, Migrate(db, SQLiteDriver))
+
+	var householdID int
+	var role string
+	assert.Nil(t, db.QueryRow(`SELECT household_id, role FROM household_member WHERE user_id = 7`).Scan(&householdID, &role))
+	assert.Equal(t, 7, householdID)
+	assert.Equal(t, "owner", role)
+	var dishHousehold, storageHousehold int
+	assert.Nil(t, db.QueryRow(`SELECT household_id FROM dish WHERE user_id = 7`).Scan(&dishHousehold))
+	assert.Nil(t, db.QueryRow(`SELECT household_id FROM storage WHERE id = 3`).Scan(&storageHousehold))
+	assert.Equal(t, 7, dishHousehold)
+	assert.Equal(t, 7, storageHousehold)
+}
+
+//migrateDownBefore rolls a migrated database back to before the given version, and whatever came after it.
+func migrateDownBefore(t *testing.T, db *sql.DB, version int) {
+	migrations, _ := Migrations()
+	steps := 0
+	for _, m := range migrations {
+		if m.Version >= version {
+			steps++
+		}
+	}
+	if fcErr := MigrateDown(db, SQLiteDriver, steps); fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+}
+
+func TestDb_Migrate_SQLiteNormalizesDishDates(t *testing.T) {
+	db, fcErr := OpenDatabase(SQLiteDriver, ":memory:")
+	assert.Nil(t, fcErr)
+	defer db.Close()
+
+	assert.Nil(t, Migrate(db, SQLiteDriver))
+	migrateDownBefore(t, db, 15)
+
+	//the dates as the older versions of the service, and people editing rows by hand, wrote them
+	for _, dates := range [][2]string{
+		{"2006-01-02T15:04:05", "2020-10-13T08:00"},
+		{"2006-01-02 15:04", "2020-10-13"},
+		{"2006-01-02T09:04:05-06:00", "2020-10-13T08:00:00Z"},
+		{"2006-01-02 15:04:05", ""},
+	} {
+		_, err := db.Exec(`INSERT INTO dish (personal_id, user_id, storage_id, title, created_date, expire_date) VALUES (1, 7, 1, 'Carrots', ?, ?)`,
+			dates[0], dates[1])
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, Migrate(db, SQLiteDriver))
+
+	rows, err := db.Query(`SELECT created_date, expire_date FROM dish ORDER BY id`)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer rows.Close()
+	var normalized [][2]time.Time
+	for rows.Next() {
+		var dates [2]time.Time
+		assert.Nil(t, rows.Scan(dbTime{&dates[0]}, dbTime{&dates[1]}))
+		normalized = append(normalized, dates)
+	}
+	created := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	expires := time.Date(2020, 10, 13, 8, 0, 0, 0, time.UTC)
+	assert.Equal(t, [][2]time.Time{
+		{created, expires},
+		{created.Add(-5 * time.Second), expires.Add(-8 * time.Hour)},
+		{created, expires},
+		{created, {}},
+	}, normalized)
+
+	var noExpiry int
+	assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM dish WHERE expire_date IS NULL`).Scan(&noExpiry))
+	assert.Equal(t, 1, noExpiry, "no expire date is NULL, not a time")
+	var raw interface{}
+	assert.Nil(t, db.QueryRow(`SELECT expire_date FROM dish WHERE id = 1`).Scan(&raw))
+	assert.IsType(t, time.Time{}, raw, "a DATETIME column, which the driver gives dbTime as a time.Time")
+}
+
+func TestDb_Migrate_SQLiteUnreadableDishDates(t *testing.T) {
+	db, fcErr := OpenDatabase(SQLiteDriver, ":memory:")
+	assert.Nil(t, fcErr)
+	defer db.Close()
+
+	assert.Nil(t, Migrate(db, SQLiteDriver))
+	migrateDownBefore(t, db, 15)
+	for _, dates := range [][2]string{
+		{"2006-01-02T15:04:05", "2020-10-13T08:00"},
+		{"2006-01-02 15:04:05", "next tuesday"},
+	} {
+		_, err := db.Exec(`INSERT INTO dish (personal_id, user_id, storage_id, title, created_date, expire_date) VALUES (1, 7, 1, 'Carrots', ?, ?)`,
+			dates[0], dates[1])
+		assert.Nil(t, err)
+	}
+
+	fcErr = Migrate(db, SQLiteDriver)
+	if assert.NotNil(t, fcErr) {
+		assert.Contains(t, fcErr.Message(), `dish 2 (created "2006-01-02 15:04:05", expires "next tuesday")`)
+		assert.NotContains(t, fcErr.Message(), "dish 1")
+	}
+
+	//nothing was rewritten or lost, and the migration can be run again once the date is fixed
+	applied, _ := AppliedMigrations(db)
+	assert.Equal(t, 14, applied[len(applied)-1])
+	var created, expires string
+	assert.Nil(t, db.QueryRow(`SELECT created_date, expire_date FROM dish WHERE id = 1`).Scan(&created, &expires))
+	assert.Equal(t, "2006-01-02T15:04:05", created)
+	_, err := db.Exec(`UPDATE dish SET expire_date = '2020-10-20' WHERE id = 2`)
+	assert.Nil(t, err)
+	assert.Nil(t, Migrate(db, SQLiteDriver))
+}
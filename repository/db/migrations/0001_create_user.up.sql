@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS user (
+	id INT NOT NULL AUTO_INCREMENT,
+	email VARCHAR(255) NOT NULL,
+	first_name VARCHAR(255) NOT NULL DEFAULT '',
+	last_name VARCHAR(255) NOT NULL DEFAULT '',
+	full_name VARCHAR(255) NOT NULL DEFAULT '',
+	created_date VARCHAR(32) NOT NULL DEFAULT '',
+	access_token VARCHAR(2048) NOT NULL DEFAULT '',
+	refresh_token VARCHAR(512) NOT NULL DEFAULT '',
+	alexa_user_id VARCHAR(255) NOT NULL DEFAULT '',
+	is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+	temp_match VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (id),
+	UNIQUE (email)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
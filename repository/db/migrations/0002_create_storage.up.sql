@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS storage (
+	id INT NOT NULL AUTO_INCREMENT,
+	personal_id INT NOT NULL,
+	user_id INT NOT NULL,
+	title VARCHAR(255) NOT NULL DEFAULT '',
+	description VARCHAR(1024) NOT NULL DEFAULT '',
+	temp_match VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (id),
+	UNIQUE (user_id, personal_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS dish (
+	id INT NOT NULL AUTO_INCREMENT,
+	personal_id INT NOT NULL,
+	user_id INT NOT NULL,
+	storage_id INT NOT NULL,
+	title VARCHAR(255) NOT NULL DEFAULT '',
+	description VARCHAR(1024) NOT NULL DEFAULT '',
+	created_date VARCHAR(32) NOT NULL DEFAULT '',
+	expire_date VARCHAR(32) NOT NULL DEFAULT '',
+	priority VARCHAR(32) NOT NULL DEFAULT '',
+	dish_type VARCHAR(64) NOT NULL DEFAULT '',
+	portions INT NOT NULL DEFAULT -1,
+	temp_match VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1 @@
+DROP INDEX dish_user_personal ON dish;
@@ -0,0 +1 @@
+CREATE INDEX dish_user_personal ON dish (user_id, personal_id);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS session (
+	id INT NOT NULL AUTO_INCREMENT,
+	user_id INT NOT NULL,
+	token_hash VARCHAR(64) NOT NULL,
+	created_date VARCHAR(32) NOT NULL DEFAULT '',
+	expire_date VARCHAR(32) NOT NULL DEFAULT '',
+	revoked_date VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (id),
+	UNIQUE (token_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+CREATE INDEX session_user ON session (user_id);
@@ -0,0 +1 @@
+ALTER TABLE user ADD COLUMN token_expiry VARCHAR(32) NOT NULL DEFAULT '';
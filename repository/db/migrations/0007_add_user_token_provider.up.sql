@@ -0,0 +1 @@
+ALTER TABLE user ADD COLUMN token_provider VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE user ADD COLUMN alexa_user_id VARCHAR(255) NOT NULL DEFAULT '';
+UPDATE user SET alexa_user_id = COALESCE((SELECT MAX(external_id) FROM linked_identity WHERE linked_identity.user_id = user.id AND linked_identity.provider = 'alexa'), '');
+DROP TABLE IF EXISTS linked_identity;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS linked_identity (
+	id INT NOT NULL AUTO_INCREMENT,
+	user_id INT NOT NULL,
+	provider VARCHAR(32) NOT NULL,
+	external_id VARCHAR(255) NOT NULL DEFAULT '',
+	access_token_hash VARCHAR(64) NOT NULL DEFAULT '',
+	refresh_token_hash VARCHAR(64) NOT NULL DEFAULT '',
+	created_date VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+CREATE INDEX linked_identity_user ON linked_identity (user_id);
+CREATE INDEX linked_identity_external ON linked_identity (provider, external_id);
+CREATE INDEX linked_identity_refresh ON linked_identity (refresh_token_hash);
+INSERT INTO linked_identity (user_id, provider, external_id, created_date) SELECT id, 'alexa', alexa_user_id, created_date FROM user WHERE alexa_user_id <> '';
+ALTER TABLE user DROP COLUMN alexa_user_id;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS storage_by_user (
+	id INT NOT NULL AUTO_INCREMENT,
+	personal_id INT NOT NULL,
+	user_id INT NOT NULL,
+	title VARCHAR(255) NOT NULL DEFAULT '',
+	description VARCHAR(1024) NOT NULL DEFAULT '',
+	temp_match VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (id),
+	UNIQUE (user_id, personal_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+INSERT INTO storage_by_user (id, personal_id, user_id, title, description, temp_match) SELECT id, personal_id, user_id, title, description, temp_match FROM storage;
+DROP TABLE storage;
+ALTER TABLE storage_by_user RENAME TO storage;
+DROP INDEX dish_household_personal ON dish;
+ALTER TABLE dish DROP COLUMN household_id;
+DROP TABLE IF EXISTS household_member;
+DROP TABLE IF EXISTS household;
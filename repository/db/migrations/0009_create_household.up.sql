@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS household (
+	id INT NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL DEFAULT '',
+	created_date VARCHAR(32) NOT NULL DEFAULT '',
+	temp_match VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+CREATE INDEX household_temp_match ON household (temp_match);
+CREATE TABLE IF NOT EXISTS household_member (
+	household_id INT NOT NULL,
+	user_id INT NOT NULL,
+	role VARCHAR(32) NOT NULL,
+	joined_date VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+CREATE INDEX household_member_household ON household_member (household_id);
+INSERT INTO household (id, name, created_date) SELECT id, '', created_date FROM user;
+INSERT INTO household_member (household_id, user_id, role, joined_date) SELECT id, id, 'owner', created_date FROM user;
+ALTER TABLE dish ADD COLUMN household_id INT NOT NULL DEFAULT 0;
+UPDATE dish SET household_id = user_id;
+CREATE INDEX dish_household_personal ON dish (household_id, personal_id);
+CREATE TABLE IF NOT EXISTS storage_by_household (
+	id INT NOT NULL AUTO_INCREMENT,
+	personal_id INT NOT NULL,
+	user_id INT NOT NULL,
+	title VARCHAR(255) NOT NULL DEFAULT '',
+	description VARCHAR(1024) NOT NULL DEFAULT '',
+	temp_match VARCHAR(32) NOT NULL DEFAULT '',
+	household_id INT NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE (household_id, personal_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+INSERT INTO storage_by_household (id, personal_id, user_id, title, description, temp_match, household_id) SELECT id, personal_id, user_id, title, description, temp_match, user_id FROM storage;
+DROP TABLE storage;
+ALTER TABLE storage_by_household RENAME TO storage;
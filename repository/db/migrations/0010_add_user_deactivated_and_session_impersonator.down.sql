@@ -0,0 +1,2 @@
+ALTER TABLE session DROP COLUMN impersonator_id;
+ALTER TABLE user DROP COLUMN deactivated_date;
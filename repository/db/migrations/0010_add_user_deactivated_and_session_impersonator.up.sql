@@ -0,0 +1,2 @@
+ALTER TABLE user ADD COLUMN deactivated_date VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE session ADD COLUMN impersonator_id INT NOT NULL DEFAULT 0;
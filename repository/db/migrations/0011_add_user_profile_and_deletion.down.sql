@@ -0,0 +1,4 @@
+ALTER TABLE user DROP COLUMN deletion_date;
+ALTER TABLE user DROP COLUMN notify_days_before;
+ALTER TABLE user DROP COLUMN notify_expiring;
+ALTER TABLE user DROP COLUMN time_zone;
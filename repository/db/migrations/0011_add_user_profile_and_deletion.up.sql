@@ -0,0 +1,4 @@
+ALTER TABLE user ADD COLUMN time_zone VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE user ADD COLUMN notify_expiring BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE user ADD COLUMN notify_days_before INT NOT NULL DEFAULT 0;
+ALTER TABLE user ADD COLUMN deletion_date VARCHAR(32) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE user DROP COLUMN quiet_end;
+ALTER TABLE user DROP COLUMN quiet_start;
+DROP TABLE IF EXISTS notification;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS notification (
+	id INT NOT NULL AUTO_INCREMENT,
+	user_id INT NOT NULL,
+	dish_id INT NOT NULL,
+	channel VARCHAR(32) NOT NULL,
+	expire_date VARCHAR(32) NOT NULL DEFAULT '',
+	sent_date VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+CREATE INDEX notification_user ON notification (user_id);
+CREATE INDEX notification_expire ON notification (expire_date);
+ALTER TABLE user ADD COLUMN quiet_start VARCHAR(5) NOT NULL DEFAULT '';
+ALTER TABLE user ADD COLUMN quiet_end VARCHAR(5) NOT NULL DEFAULT '';
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS dish_removal (
+	id INT NOT NULL AUTO_INCREMENT,
+	household_id INT NOT NULL,
+	user_id INT NOT NULL,
+	storage_id INT NOT NULL DEFAULT 0,
+	title VARCHAR(255) NOT NULL DEFAULT '',
+	expire_date VARCHAR(32) NOT NULL DEFAULT '',
+	removed_date VARCHAR(32) NOT NULL DEFAULT '',
+	outcome VARCHAR(16) NOT NULL,
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+CREATE INDEX dish_removal_household ON dish_removal (household_id, removed_date);
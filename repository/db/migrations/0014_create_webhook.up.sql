@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS webhook_subscription (
+	id INT NOT NULL AUTO_INCREMENT,
+	user_id INT NOT NULL,
+	url VARCHAR(2048) NOT NULL,
+	secret VARCHAR(255) NOT NULL DEFAULT '',
+	events VARCHAR(255) NOT NULL DEFAULT '',
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_date VARCHAR(32) NOT NULL DEFAULT '',
+	temp_match VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+CREATE INDEX webhook_subscription_user ON webhook_subscription (user_id);
+CREATE INDEX webhook_subscription_temp_match ON webhook_subscription (temp_match);
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+	id INT NOT NULL AUTO_INCREMENT,
+	subscription_id INT NOT NULL,
+	user_id INT NOT NULL,
+	event_id VARCHAR(64) NOT NULL,
+	event_type VARCHAR(32) NOT NULL,
+	payload TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	response_code INT NOT NULL DEFAULT 0,
+	last_error VARCHAR(512) NOT NULL DEFAULT '',
+	next_attempt VARCHAR(32) NOT NULL DEFAULT '',
+	created_date VARCHAR(32) NOT NULL DEFAULT '',
+	updated_date VARCHAR(32) NOT NULL DEFAULT '',
+	temp_match VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+CREATE INDEX webhook_delivery_subscription ON webhook_delivery (subscription_id, id);
+CREATE INDEX webhook_delivery_due ON webhook_delivery (status, next_attempt);
+CREATE INDEX webhook_delivery_user ON webhook_delivery (user_id, status);
+CREATE INDEX webhook_delivery_temp_match ON webhook_delivery (temp_match);
@@ -0,0 +1 @@
+DROP INDEX dish_household_expire ON dish;
@@ -0,0 +1 @@
+CREATE INDEX dish_household_expire ON dish (household_id, expire_date);
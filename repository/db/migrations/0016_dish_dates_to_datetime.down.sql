@@ -0,0 +1,9 @@
+DROP INDEX dish_household_expire ON dish;
+ALTER TABLE dish ADD COLUMN created_text VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE dish ADD COLUMN expire_text VARCHAR(32) NOT NULL DEFAULT '';
+UPDATE dish SET created_text = COALESCE(created_date, ''), expire_text = COALESCE(expire_date, '');
+ALTER TABLE dish DROP COLUMN created_date;
+ALTER TABLE dish DROP COLUMN expire_date;
+ALTER TABLE dish RENAME COLUMN created_text TO created_date;
+ALTER TABLE dish RENAME COLUMN expire_text TO expire_date;
+CREATE INDEX dish_household_expire ON dish (household_id, expire_date);
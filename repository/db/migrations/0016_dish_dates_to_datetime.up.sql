@@ -0,0 +1,9 @@
+DROP INDEX dish_household_expire ON dish;
+ALTER TABLE dish ADD COLUMN created_datetime DATETIME NULL DEFAULT NULL;
+ALTER TABLE dish ADD COLUMN expire_datetime DATETIME NULL DEFAULT NULL;
+UPDATE dish SET created_datetime = NULLIF(created_date, ''), expire_datetime = NULLIF(expire_date, '');
+ALTER TABLE dish DROP COLUMN created_date;
+ALTER TABLE dish DROP COLUMN expire_date;
+ALTER TABLE dish RENAME COLUMN created_datetime TO created_date;
+ALTER TABLE dish RENAME COLUMN expire_datetime TO expire_date;
+CREATE INDEX dish_household_expire ON dish (household_id, expire_date);
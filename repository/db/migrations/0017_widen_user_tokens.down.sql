@@ -0,0 +1,2 @@
+ALTER TABLE user MODIFY refresh_token VARCHAR(512) NOT NULL DEFAULT '';
+ALTER TABLE user MODIFY access_token VARCHAR(2048) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE user MODIFY access_token TEXT NOT NULL;
+ALTER TABLE user MODIFY refresh_token TEXT NOT NULL;
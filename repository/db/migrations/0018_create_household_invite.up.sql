@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS household_invite (
+	id INT NOT NULL AUTO_INCREMENT,
+	household_id INT NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	role VARCHAR(32) NOT NULL,
+	invited_by INT NOT NULL,
+	created_date VARCHAR(32) NOT NULL DEFAULT '',
+	expire_date VARCHAR(32) NOT NULL DEFAULT '',
+	temp_match VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+CREATE INDEX household_invite_email ON household_invite (email);
+CREATE INDEX household_invite_household ON household_invite (household_id);
+CREATE INDEX household_invite_temp_match ON household_invite (temp_match);
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/webhook"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/secret"
+)
+
+//sealedRepository encrypts each user's OAuth tokens and the secrets their webhooks are signed with on the way into the
+//wrapped Repository, and decrypts them on the way out, so they are never stored in plaintext. Everything else passes
+//straight through.
+type sealedRepository struct {
+	Repository
+	box *secret.Box
+}
+
+//NewSealedRepository wraps repo so user access and refresh tokens, and webhook secrets, are stored encrypted with box.
+//Tokens stored before encryption was turned on are still read, and are encrypted the next time the user is written.
+func NewSealedRepository(repo Repository, box *secret.Box) Repository {
+	return &sealedRepository{Repository: repo, box: box}
+}
+
+//WithTx runs fn with the transaction's Repository wrapped the same way.
+func (repo *sealedRepository) WithTx(ctx context.Context, fn func(Repository) fcerr.FCErr) fcerr.FCErr {
+	return repo.Repository.WithTx(ctx, func(txRepo Repository) fcerr.FCErr {
+		return fn(&sealedRepository{Repository: txRepo, box: repo.box})
+	})
+}
+
+//GetUsers gets the users matching the search, with their tokens decrypted.
+func (repo *sealedRepository) GetUsers(ctx context.Context, search string) (*user.Users, fcerr.FCErr) {
+	return repo.openAll(repo.Repository.GetUsers(ctx, search))
+}
+
+//GetUsersPendingDeletion gets the users waiting to be erased, with their tokens decrypted.
+func (repo *sealedRepository) GetUsersPendingDeletion(ctx context.Context) (*user.Users, fcerr.FCErr) {
+	return repo.openAll(repo.Repository.GetUsersPendingDeletion(ctx))
+}
+
+//GetUsersToNotify gets the users who asked to be told about food expiring, with their tokens decrypted.
+func (repo *sealedRepository) GetUsersToNotify(ctx context.Context) (*user.Users, fcerr.FCErr) {
+	return repo.openAll(repo.Repository.GetUsersToNotify(ctx))
+}
+
+//openAll decrypts the tokens of every user in users, passing fcErr through.
+func (repo *sealedRepository) openAll(users *user.Users, fcErr fcerr.FCErr) (*user.Users, fcerr.FCErr) {
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	opened := make(user.Users, 0, len(*users))
+	for i := range *users {
+		u, fcErr := repo.open(&(*users)[i], nil)
+		if fcErr != nil {
+			return nil, fcErr
+		}
+		opened = append(opened, *u)
+	}
+	return &opened, nil
+}
+
+//GetUserByID gets the user with the given id, with their tokens decrypted.
+func (repo *sealedRepository) GetUserByID(ctx context.Context, id int) (*user.User, fcerr.FCErr) {
+	return repo.open(repo.Repository.GetUserByID(ctx, id))
+}
+
+//GetUserByEmail gets the user with the given email, with their tokens decrypted.
+func (repo *sealedRepository) GetUserByEmail(ctx context.Context, email string) (*user.User, fcerr.FCErr) {
+	return repo.open(repo.Repository.GetUserByEmail(ctx, email))
+}
+
+//GetUserByTempMatch gets the user with the given temp match, with their tokens decrypted.
+func (repo *sealedRepository) GetUserByTempMatch(ctx context.Context, tm string) (*user.User, fcerr.FCErr) {
+	return repo.open(repo.Repository.GetUserByTempMatch(ctx, tm))
+}
+
+//CreateUser encrypts the user's tokens and adds them.
+func (repo *sealedRepository) CreateUser(ctx context.Context, u user.User) (*user.User, fcerr.FCErr) {
+	sealedUser, fcErr := repo.seal(u)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return repo.open(repo.Repository.CreateUser(ctx, sealedUser))
+}
+
+//UpdateUser encrypts the user's tokens and updates them.
+func (repo *sealedRepository) UpdateUser(ctx context.Context, u user.User) (*user.User, fcerr.FCErr) {
+	sealedUser, fcErr := repo.seal(u)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	return repo.open(repo.Repository.UpdateUser(ctx, sealedUser))
+}
+
+//seal gives a copy of u with its tokens encrypted.
+func (repo *sealedRepository) seal(u user.User) (user.User, fcerr.FCErr) {
+	var fcErr fcerr.FCErr
+	if u.AccessToken, fcErr = repo.box.Seal(u.AccessToken); fcErr != nil {
+		return u, fcErr
+	}
+	if u.RefreshToken, fcErr = repo.box.Seal(u.RefreshToken); fcErr != nil {
+		return u, fcErr
+	}
+	return u, nil
+}
+
+//open decrypts the tokens of a user the wrapped Repository returned, passing its error along.
+func (repo *sealedRepository) open(u *user.User, fcErr fcerr.FCErr) (*user.User, fcerr.FCErr) {
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	accessToken, fcErr := repo.box.Open(u.AccessToken)
+	if fcErr != nil {
+		fmt.Println("could not decrypt the access token of user", u.UserID)
+		return nil, fcErr
+	}
+	refreshToken, fcErr := repo.box.Open(u.RefreshToken)
+	if fcErr != nil {
+		fmt.Println("could not decrypt the refresh token of user", u.UserID)
+		return nil, fcErr
+	}
+	opened := *u
+	opened.AccessToken = accessToken
+	opened.RefreshToken = refreshToken
+	return &opened, nil
+}
+
+//GetWebhooks gets the user's webhook subscriptions, with their secrets decrypted.
+func (repo *sealedRepository) GetWebhooks(ctx context.Context, userID int) (*webhook.Subscriptions, fcerr.FCErr) {
+	return repo.openWebhooks(repo.Repository.GetWebhooks(ctx, userID))
+}
+
+//GetHouseholdWebhooks gets the active webhook subscriptions in the household, with their secrets decrypted.
+func (repo *sealedRepository) GetHouseholdWebhooks(ctx context.Context, householdID int) (*webhook.Subscriptions, fcerr.FCErr) {
+	return repo.openWebhooks(repo.Repository.GetHouseholdWebhooks(ctx, householdID))
+}
+
+//GetWebhookByID gets the user's webhook subscription with the given id, with its secret decrypted.
+func (repo *sealedRepository) GetWebhookByID(ctx context.Context, userID int, id int) (*webhook.Subscription, fcerr.FCErr) {
+	return repo.openWebhook(repo.Repository.GetWebhookByID(ctx, userID, id))
+}
+
+//CreateWebhook encrypts the subscription's secret and adds it.
+func (repo *sealedRepository) CreateWebhook(ctx context.Context, s webhook.Subscription) (*webhook.Subscription, fcerr.FCErr) {
+	var fcErr fcerr.FCErr
+	if s.Secret, fcErr = repo.box.Seal(s.Secret); fcErr != nil {
+		return nil, fcErr
+	}
+	return repo.openWebhook(repo.Repository.CreateWebhook(ctx, s))
+}
+
+//openWebhooks decrypts the secret of every subscription in subscriptions, passing fcErr through.
+func (repo *sealedRepository) openWebhooks(subscriptions *webhook.Subscriptions, fcErr fcerr.FCErr) (*webhook.Subscriptions, fcerr.FCErr) {
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	opened := make(webhook.Subscriptions, 0, len(*subscriptions))
+	for i := range *subscriptions {
+		s, fcErr := repo.openWebhook(&(*subscriptions)[i], nil)
+		if fcErr != nil {
+			return nil, fcErr
+		}
+		opened = append(opened, *s)
+	}
+	return &opened, nil
+}
+
+//openWebhook decrypts the secret of a subscription the wrapped Repository returned, passing its error along.
+func (repo *sealedRepository) openWebhook(s *webhook.Subscription, fcErr fcerr.FCErr) (*webhook.Subscription, fcerr.FCErr) {
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	secret, fcErr := repo.box.Open(s.Secret)
+	if fcErr != nil {
+		fmt.Println("could not decrypt the secret of webhook", s.SubscriptionID)
+		return nil, fcErr
+	}
+	opened := *s
+	opened.Secret = secret
+	return &opened, nil
+}
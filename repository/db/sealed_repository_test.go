@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/webhook"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/secret"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSealedRepository_StoresTokensEncrypted(t *testing.T) {
+	box, _ := secret.NewBox(secret.NewKey())
+	inner := NewMemoryRepository()
+	repo := NewSealedRepository(inner, box)
+
+	created, err := repo.CreateUser(context.Background(), *nU)
+	assert.Nil(t, err)
+	assert.Equal(t, nU.AccessToken, created.AccessToken)
+	assert.Equal(t, nU.RefreshToken, created.RefreshToken)
+
+	stored, _ := inner.GetUserByID(context.Background(), created.UserID)
+	assert.True(t, secret.IsSealed(stored.AccessToken))
+	assert.True(t, secret.IsSealed(stored.RefreshToken))
+	assert.False(t, strings.Contains(stored.AccessToken, nU.AccessToken))
+
+	fetched, err := repo.GetUserByEmail(context.Background(), nU.Email)
+	assert.Nil(t, err)
+	assert.Equal(t, nU.AccessToken, fetched.AccessToken)
+	assert.Equal(t, nU.RefreshToken, fetched.RefreshToken)
+}
+
+//roundTripLongTokens stores tokens as long as the ones some identity providers hand out through a sealed repo over inner,
+//and reads them back.
+func roundTripLongTokens(t *testing.T, inner Repository) {
+	box, _ := secret.NewBox(secret.NewKey())
+	repo := NewSealedRepository(inner, box)
+	longUser := *nU
+	longUser.AccessToken = strings.Repeat("a", 2048)
+	longUser.RefreshToken = strings.Repeat("r", 2048)
+
+	created, err := repo.CreateUser(context.Background(), longUser)
+	if !assert.Nil(t, err) {
+		return
+	}
+	fetched, err := repo.GetUserByID(context.Background(), created.UserID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, longUser.AccessToken, fetched.AccessToken)
+		assert.Equal(t, longUser.RefreshToken, fetched.RefreshToken)
+	}
+
+	fetched.RefreshToken = strings.Repeat("s", 2048)
+	_, err = repo.UpdateUser(context.Background(), *fetched)
+	assert.Nil(t, err)
+	updated, err := repo.GetUserByEmail(context.Background(), nU.Email)
+	if assert.Nil(t, err) {
+		assert.Equal(t, fetched.RefreshToken, updated.RefreshToken)
+	}
+}
+
+func TestSealedRepository_LongTokens(t *testing.T) {
+	inner, err := NewRepositoryWithDriver(SQLiteDriver, filepath.Join(t.TempDir(), "fcapi.db"))
+	if err != nil {
+		t.Fatal(err.Message())
+	}
+	roundTripLongTokens(t, inner)
+}
+
+//TestMySQLSealedRepository_LongTokens needs a throwaway database, the same as TestMySQLRepository_Conformance. It is
+//the one that would truncate or refuse a sealed token too long for its column.
+func TestMySQLSealedRepository_LongTokens(t *testing.T) {
+	dsn := os.Getenv("FCAPI_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("FCAPI_TEST_MYSQL_DSN is not set")
+	}
+	inner, err := NewRepository(dsn)
+	if err != nil {
+		t.Fatal(err.Message())
+	}
+	if _, err := inner.(*repository).db.ExecContext(context.Background(), "DELETE FROM user"); err != nil {
+		t.Fatal(err)
+	}
+	roundTripLongTokens(t, inner)
+}
+
+func TestSealedRepository_ReadsPlaintextRows(t *testing.T) {
+	box, _ := secret.NewBox(secret.NewKey())
+	inner := NewMemoryRepository()
+	plain, _ := inner.CreateUser(context.Background(), *nU)
+	repo := NewSealedRepository(inner, box)
+
+	fetched, err := repo.GetUserByID(context.Background(), plain.UserID)
+	assert.Nil(t, err)
+	assert.Equal(t, nU.AccessToken, fetched.AccessToken)
+
+	//writing the user back seals what was plaintext
+	_, err = repo.UpdateUser(context.Background(), *fetched)
+	assert.Nil(t, err)
+	stored, _ := inner.GetUserByID(context.Background(), plain.UserID)
+	assert.True(t, secret.IsSealed(stored.AccessToken))
+}
+
+func TestSealedRepository_WrongKey(t *testing.T) {
+	box, _ := secret.NewBox(secret.NewKey())
+	otherBox, _ := secret.NewBox(secret.NewKey())
+	inner := NewMemoryRepository()
+	created, _ := NewSealedRepository(inner, box).CreateUser(context.Background(), *nU)
+
+	_, err := NewSealedRepository(inner, otherBox).GetUserByID(context.Background(), created.UserID)
+	assert.NotNil(t, err)
+}
+
+func TestSealedRepository_WithTx(t *testing.T) {
+	box, _ := secret.NewBox(secret.NewKey())
+	inner := NewMemoryRepository()
+	repo := NewSealedRepository(inner, box)
+
+	err := repo.WithTx(context.Background(), func(txRepo Repository) fcerr.FCErr {
+		_, err := txRepo.CreateUser(context.Background(), *nU)
+		return err
+	})
+	assert.Nil(t, err)
+
+	stored, _ := inner.GetUserByEmail(context.Background(), nU.Email)
+	assert.True(t, secret.IsSealed(stored.AccessToken))
+}
+
+func TestSealedRepository_StoresWebhookSecretsEncrypted(t *testing.T) {
+	box, _ := secret.NewBox(secret.NewKey())
+	inner := NewMemoryRepository()
+	repo := NewSealedRepository(inner, box)
+
+	created, err := repo.CreateWebhook(context.Background(), webhook.Subscription{UserID: 1, URL: "https://example.com/hook",
+		Secret: "whsec_test", Active: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "whsec_test", created.Secret)
+
+	stored, _ := inner.GetWebhookByID(context.Background(), 1, created.SubscriptionID)
+	assert.True(t, secret.IsSealed(stored.Secret))
+
+	fetched, err := repo.GetWebhooks(context.Background(), 1)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(*fetched)) {
+		assert.Equal(t, "whsec_test", (*fetched)[0].Secret)
+	}
+}
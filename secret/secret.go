@@ -0,0 +1,84 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//Box encrypts values such as OAuth tokens before they are stored, with AES-256-GCM.
+type Box struct {
+	aead cipher.AEAD
+}
+
+//sealedPrefix marks a stored value as sealed by a Box, and which format it is in. Values without it are plaintext
+//written before encryption was turned on.
+const sealedPrefix = "enc:v1:"
+
+//KeySize is how many bytes the key has to be, before it is base64 encoded for the config file.
+const KeySize = 32
+
+//NewBox takes a standard base64 encoded 32 byte key and gives you a Box that seals with it.
+func NewBox(key string) (*Box, fcerr.FCErr) {
+	rawKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(rawKey) != KeySize {
+		return nil, fcerr.NewInternalServerError("The token encryption key must be 32 bytes, base64 encoded")
+	}
+	block, err := aes.NewCipher(rawKey)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not set up the token encryption", http.StatusInternalServerError)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not set up the token encryption", http.StatusInternalServerError)
+	}
+	return &Box{aead: aead}, nil
+}
+
+//NewKey makes a random key in the form NewBox takes.
+func NewKey() string {
+	n := make([]byte, KeySize)
+	rand.Read(n)
+	return base64.StdEncoding.EncodeToString(n)
+}
+
+//Seal encrypts value with a fresh random nonce. "" stays "", so an empty column still reads as no value.
+func (b *Box) Seal(value string) (string, fcerr.FCErr) {
+	if value == "" {
+		return "", nil
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fcerr.Wrap(err, "Could not encrypt the value", http.StatusInternalServerError)
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(value), nil)
+	return sealedPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+//Open decrypts a value from Seal. A value that was never sealed is given back as it is, so rows written before
+//encryption was turned on still read; they are sealed the next time they are written.
+func (b *Box) Open(stored string) (string, fcerr.FCErr) {
+	if !IsSealed(stored) {
+		return stored, nil
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", fcerr.NewInternalServerError("A stored value is not in the encrypted format")
+	}
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	value, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fcerr.Wrap(err, "A stored value could not be decrypted - the key may have changed", http.StatusInternalServerError)
+	}
+	return string(value), nil
+}
+
+//IsSealed says whether a stored value was written by Seal.
+func IsSealed(stored string) bool {
+	return strings.HasPrefix(stored, sealedPrefix)
+}
@@ -0,0 +1,56 @@
+package secret
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBox_SealAndOpen(t *testing.T) {
+	box, err := NewBox(NewKey())
+	assert.Nil(t, err)
+
+	sealed, err := box.Seal("ya29.a-google-token")
+	assert.Nil(t, err)
+	assert.True(t, IsSealed(sealed))
+	assert.False(t, strings.Contains(sealed, "ya29"))
+
+	again, _ := box.Seal("ya29.a-google-token")
+	assert.NotEqual(t, sealed, again)
+
+	opened, err := box.Open(sealed)
+	assert.Nil(t, err)
+	assert.Equal(t, "ya29.a-google-token", opened)
+}
+
+func TestBox_EmptyAndPlaintext(t *testing.T) {
+	box, _ := NewBox(NewKey())
+
+	sealed, err := box.Seal("")
+	assert.Nil(t, err)
+	assert.Equal(t, "", sealed)
+
+	opened, err := box.Open("a-token-stored-before-encryption")
+	assert.Nil(t, err)
+	assert.Equal(t, "a-token-stored-before-encryption", opened)
+}
+
+func TestBox_WrongKey(t *testing.T) {
+	box, _ := NewBox(NewKey())
+	otherBox, _ := NewBox(NewKey())
+
+	sealed, _ := box.Seal("ya29.a-google-token")
+	_, err := otherBox.Open(sealed)
+	assert.NotNil(t, err)
+
+	_, err = box.Open(sealedPrefix + "not base64!")
+	assert.NotNil(t, err)
+}
+
+func TestBox_BadKey(t *testing.T) {
+	for _, key := range []string{"", "not base64!", "c2hvcnQ="} {
+		_, err := NewBox(key)
+		assert.NotNil(t, err, key)
+	}
+}
@@ -0,0 +1,83 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/clock"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/digest"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/dish"
+	storageService "github.com/jasonradcliffe/freshness-countdown-api/services/storage"
+)
+
+//MaxDays is the furthest ahead a digest looks for dishes expiring.
+const MaxDays = 31
+
+//Service is the interface that defines the contract for a digest service.
+type Service interface {
+	Get(context.Context, *userDomain.User, string, int) (*digest.Digest, fcerr.FCErr)
+}
+
+type service struct {
+	dishService    dish.Service
+	storageService storageService.Service
+	clock          clock.Clock
+}
+
+//NewService takes the dish and storage services a digest is made from and the clock to go by, and gives you a new
+//Service instance.
+func NewService(ds dish.Service, ss storageService.Service, c clock.Clock) Service {
+	return &service{
+		dishService:    ds,
+		storageService: ss,
+		clock:          c,
+	}
+}
+
+//Get(requestingUser *userDomain.User, period string, days int) makes the user's digest.Daily or digest.Weekly digest:
+//the dishes in their household that expire in the next days days or already have, by storage unit, and how many dishes
+//were eaten and wasted over the period. 0 days looks as far ahead as the period is long.
+func (s *service) Get(ctx context.Context, requestingUser *userDomain.User, period string, days int) (*digest.Digest, fcerr.FCErr) {
+	length, ok := digest.Length(period)
+	if !ok {
+		return nil, fcerr.NewValidationError("The period must be "+digest.Daily+" or "+digest.Weekly,
+			fcerr.FieldDetail{Field: "period", Message: "not daily or weekly: " + period})
+	}
+	if days == 0 {
+		days = int(length / (24 * time.Hour))
+	}
+	if days < 1 || days > MaxDays {
+		return nil, fcerr.NewValidationError("The days must be between 1 and 31",
+			fcerr.FieldDetail{Field: "days", Message: "out of range"})
+	}
+
+	now := s.clock.Now()
+	result := digest.New(*requestingUser, period, now, now.AddDate(0, 0, days))
+
+	expiring, err := s.dishService.GetExpiredByDate(ctx, requestingUser, result.ExpiringBy.Format(time.RFC3339))
+	if errors.Is(err, fcerr.ErrNotFound) {
+		expiring = nil
+	} else if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the dishes for the digest", err.Status())
+	}
+	storages, err := s.storageService.GetAll(ctx, requestingUser)
+	if errors.Is(err, fcerr.ErrNotFound) {
+		storages = &storage.Storages{}
+	} else if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the storage units for the digest", err.Status())
+	}
+	if expiring != nil {
+		result.Group(*storages, *expiring)
+	}
+
+	removals, err := s.dishService.GetRemovals(ctx, requestingUser, result.From)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not count the removed dishes for the digest", err.Status())
+	}
+	result.Count(*removals)
+	return result, nil
+}
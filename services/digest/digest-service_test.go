@@ -0,0 +1,172 @@
+package digest
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/clock/clocktest"
+	digestDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/digest"
+	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	storageDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+//noon is when the digest tests are run, a Monday.
+var noon = time.Date(2022, 3, 7, 12, 0, 0, 0, time.UTC)
+
+//newDigestTest gives a digest service over a household with a fridge and a freezer, and these dishes: in the fridge,
+//soup expiring in 6 hours, high priority carrots in a day and milk that went off yesterday; in the freezer, peas in
+//3 days; and bread in a storage unit that is gone, in 10 hours. Over the last week, 2 dishes were eaten and 1 wasted,
+//and 1 more was eaten before that.
+func newDigestTest(t *testing.T) (Service, *userDomain.User, *clocktest.Clock) {
+	ctx := context.Background()
+	repo := db.NewMemoryRepository()
+	bob, fcErr := repo.CreateUser(ctx, userDomain.User{Email: "nothing@gmail.com", FirstName: "Bob", TimeZone: "America/Chicago"})
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	home, fcErr := household.Membership(ctx, repo, bob)
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	for i, title := range []string{"Fridge", "Freezer"} {
+		if _, fcErr := repo.CreateStorage(ctx, storageDomain.Storage{PersonalID: i + 1, UserID: bob.UserID,
+			HouseholdID: home.HouseholdID, Title: title}); fcErr != nil {
+			t.Fatal(fcErr.Message())
+		}
+	}
+	for i, d := range []dishDomain.Dish{
+		{Title: "Soup", StorageID: 1, ExpireDate: noon.Add(6 * time.Hour)},
+		{Title: "Carrots", StorageID: 1, Priority: "High", ExpireDate: noon.Add(24 * time.Hour)},
+		{Title: "Milk", StorageID: 1, ExpireDate: noon.Add(-24 * time.Hour)},
+		{Title: "Peas", StorageID: 2, ExpireDate: noon.Add(72 * time.Hour)},
+		{Title: "Bread", StorageID: 5, ExpireDate: noon.Add(10 * time.Hour)},
+		{Title: "Rice", StorageID: 1, ExpireDate: noon.Add(30 * 24 * time.Hour)},
+	} {
+		d.PersonalDishID, d.UserID, d.HouseholdID = i+1, bob.UserID, home.HouseholdID
+		if _, fcErr := repo.CreateDish(ctx, d); fcErr != nil {
+			t.Fatal(fcErr.Message())
+		}
+	}
+	for _, r := range []dishDomain.Removal{
+		{Title: "Cheese", RemovedDate: noon.Add(-2 * time.Hour), Outcome: dishDomain.Consumed},
+		{Title: "Ham", RemovedDate: noon.Add(-3 * 24 * time.Hour), Outcome: dishDomain.Wasted},
+		{Title: "Eggs", RemovedDate: noon.Add(-5 * 24 * time.Hour), Outcome: dishDomain.Consumed},
+		{Title: "Jam", RemovedDate: noon.Add(-9 * 24 * time.Hour), Outcome: dishDomain.Consumed},
+	} {
+		r.HouseholdID, r.UserID = home.HouseholdID, bob.UserID
+		if fcErr := repo.CreateRemoval(ctx, r); fcErr != nil {
+			t.Fatal(fcErr.Message())
+		}
+	}
+
+	c := clocktest.New(noon)
+	return NewService(dish.NewService(repo), storage.NewService(repo), c), bob, c
+}
+
+//titles lists the titles of the dishes in each section.
+func titles(d *digestDomain.Digest) map[string][]string {
+	result := map[string][]string{}
+	for _, section := range d.Storages {
+		for _, di := range section.Dishes {
+			result[section.Title] = append(result[section.Title], di.Title)
+		}
+	}
+	return result
+}
+
+func TestDigestService_Get_Daily(t *testing.T) {
+	s, bob, _ := newDigestTest(t)
+
+	result, err := s.Get(context.Background(), bob, digestDomain.Daily, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, noon, result.AsOf)
+	assert.Equal(t, noon.Add(-24*time.Hour), result.From)
+	assert.Equal(t, noon.Add(24*time.Hour), result.ExpiringBy)
+	assert.Equal(t, 4, result.Expiring)
+	assert.Equal(t, map[string][]string{
+		"Fridge":    {"Carrots", "Milk", "Soup"},
+		"Elsewhere": {"Bread"},
+	}, titles(result))
+	if assert.Equal(t, 2, len(result.Storages)) {
+		assert.Equal(t, 1, result.Storages[0].StorageID)
+		assert.Equal(t, 0, result.Storages[1].StorageID)
+	}
+	assert.Equal(t, 1, result.Consumed)
+	assert.Equal(t, 0, result.Wasted)
+
+	text := result.Text()
+	assert.True(t, strings.HasPrefix(text, "Hi Bob,\n\nThis is your daily Freshness Countdown digest for Monday, March 7.\n\n"))
+	assert.Contains(t, text, "Fridge\n- Carrots (High priority) expires tomorrow\n- Milk expired yesterday\n- Soup expires today\n\n")
+	assert.Contains(t, text, "Elsewhere\n- Bread expires today\n")
+	assert.Contains(t, text, "Since Sunday, March 6, your household used up 1 dish and threw away 0 dishes.")
+	assert.Equal(t, "4 dishes to use up - your daily Freshness Countdown digest", result.Subject())
+}
+
+func TestDigestService_Get_Weekly(t *testing.T) {
+	s, bob, _ := newDigestTest(t)
+
+	result, err := s.Get(context.Background(), bob, digestDomain.Weekly, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, noon.Add(-7*24*time.Hour), result.From)
+	assert.Equal(t, map[string][]string{
+		"Fridge":    {"Carrots", "Milk", "Soup"},
+		"Freezer":   {"Peas"},
+		"Elsewhere": {"Bread"},
+	}, titles(result))
+	assert.Equal(t, 2, result.Consumed)
+	assert.Equal(t, 1, result.Wasted)
+
+	result, err = s.Get(context.Background(), bob, digestDomain.Weekly, 31)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, result.Expiring, "the rice too")
+}
+
+func TestDigestService_Get_Nothing(t *testing.T) {
+	s, bob, c := newDigestTest(t)
+	c.Advance(-72 * time.Hour)
+
+	result, err := s.Get(context.Background(), bob, digestDomain.Daily, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.Expiring)
+	assert.Equal(t, 0, len(result.Storages))
+	assert.Contains(t, result.Text(), "Nothing in your household expires by Saturday, March 5.\n")
+	assert.Equal(t, "Nothing to use up - your daily Freshness Countdown digest", result.Subject())
+}
+
+func TestDigestService_Get_Invalid(t *testing.T) {
+	s, bob, _ := newDigestTest(t)
+
+	for _, tc := range []struct {
+		period string
+		days   int
+	}{{"monthly", 0}, {"", 0}, {digestDomain.Daily, -1}, {digestDomain.Weekly, MaxDays + 1}} {
+		_, err := s.Get(context.Background(), bob, tc.period, tc.days)
+		if assert.NotNil(t, err, tc.period) {
+			assert.Equal(t, http.StatusBadRequest, err.Status())
+		}
+	}
+}
+
+func TestDigest_HTML(t *testing.T) {
+	s, bob, _ := newDigestTest(t)
+
+	result, _ := s.Get(context.Background(), bob, digestDomain.Daily, 0)
+	result.Storages[0].Dishes[0].Title = "<script>alert(1)</script>"
+	page, err := result.HTML()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(page, "<!DOCTYPE html>"))
+	assert.Contains(t, page, "<title>4 dishes to use up - your daily Freshness Countdown digest</title>")
+	assert.Contains(t, page, "<h3 style=\"margin: 16px 0 4px 0;\">Fridge</h3>")
+	assert.Contains(t, page, "<li>Milk expired yesterday</li>")
+	assert.Contains(t, page, "&lt;script&gt;alert(1)&lt;/script&gt; (High priority) expires tomorrow")
+	assert.NotContains(t, page, "<script>")
+}
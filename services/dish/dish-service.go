@@ -1,70 +1,149 @@
 package dish
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/event"
 	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/duration"
+	"github.com/jasonradcliffe/freshness-countdown-api/eventbus"
 	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
 	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
 )
 
 //Service is the interface that defines the contract for a dish service.
 type Service interface {
-	GetByID(*userDomain.User, int) (*dish.Dish, fcerr.FCErr)
-	GetExpired(*userDomain.User) (*dish.Dishes, fcerr.FCErr)
-	GetExpiredByDate(*userDomain.User, string) (*dish.Dishes, fcerr.FCErr)
-	GetAll(*userDomain.User) (*dish.Dishes, fcerr.FCErr)
-	Create(*userDomain.User, *dish.Dish, string) (*dish.Dish, fcerr.FCErr)
-	Update(*userDomain.User, *dish.Dish, string) fcerr.FCErr
-	Delete(*userDomain.User, int) fcerr.FCErr
+	GetByID(context.Context, *userDomain.User, int) (*dish.Dish, fcerr.FCErr)
+	GetExpired(context.Context, *userDomain.User) (*dish.Dishes, fcerr.FCErr)
+	GetExpiredByDate(context.Context, *userDomain.User, string) (*dish.Dishes, fcerr.FCErr)
+	GetAll(context.Context, *userDomain.User) (*dish.Dishes, fcerr.FCErr)
+	List(context.Context, *userDomain.User, dish.Query) (*dish.Dishes, string, fcerr.FCErr)
+	Create(context.Context, *userDomain.User, *dish.Dish, string) (*dish.Dish, fcerr.FCErr)
+	Update(context.Context, *userDomain.User, *dish.Dish, string) fcerr.FCErr
+	Delete(context.Context, *userDomain.User, int, string) fcerr.FCErr
+	GetRemovals(context.Context, *userDomain.User, time.Time) (*dish.Removals, fcerr.FCErr)
+	ExpiryStats(context.Context, duration.Duration) (*dish.ExpiryStats, fcerr.FCErr)
+	PublishExpired(context.Context, time.Time, time.Time) (int, fcerr.FCErr)
 }
 
+//MaxPageSize is the most dishes List gives in one page.
+const MaxPageSize = 200
+
 type service struct {
 	repository db.Repository
+	events     *eventbus.Bus
 }
 
 //NewService takes a database repository and gives you a new Service instance.
 func NewService(repo db.Repository) Service {
+	return NewServiceWithEvents(repo, nil)
+}
+
+//NewServiceWithEvents is NewService with the bus that every dish created, updated, deleted or expired is published on.
+func NewServiceWithEvents(repo db.Repository, events *eventbus.Bus) Service {
 	return &service{
 		repository: repo,
+		events:     events,
 	}
 }
 
-//GetByID(requestingUser *userDomain.User, pID int) takes an int id and sends it to the database repo for lookup.
-func (s *service) GetByID(requestingUser *userDomain.User, pID int) (*dish.Dish, fcerr.FCErr) {
+//GetByID(requestingUser *userDomain.User, pID int) takes an int id and sends it to the database repo for lookup in the user's household.
+func (s *service) GetByID(ctx context.Context, requestingUser *userDomain.User, pID int) (*dish.Dish, fcerr.FCErr) {
 	fmt.Println("doing the service GetByID() with user:" + requestingUser.Email + "and dish id:" + strconv.Itoa(pID))
-	resultDish, err := s.repository.GetDishByID(requestingUser.UserID, pID)
+	member, err := household.Membership(ctx, s.repository, requestingUser)
+	if err != nil {
+		return nil, err
+	}
+	resultDish, err := s.repository.GetDishByID(ctx, member.HouseholdID, pID)
 	if err != nil {
 		fmt.Println("s.repository.GetDishByID got an error:" + err.Message())
-		return nil, fcerr.NewInternalServerError("could not do the GetByID, possibly not in the db")
+		return nil, fcerr.Wrap(err, "Could not get the dish with personal id "+strconv.Itoa(pID), err.Status())
 	}
 	return resultDish, nil
 }
 
-//GetAll(requestUser *userDomain.User) gets all the dishes for the requestUser
-func (s *service) GetAll(requestUser *userDomain.User) (*dish.Dishes, fcerr.FCErr) {
-	resultDishes, err := s.repository.GetDishes(requestUser.UserID)
+//GetAll(requestUser *userDomain.User) gets all the dishes in the requestUser's household, whoever added them
+func (s *service) GetAll(ctx context.Context, requestUser *userDomain.User) (*dish.Dishes, fcerr.FCErr) {
+	member, err := household.Membership(ctx, s.repository, requestUser)
 	if err != nil {
-		fcerr := fcerr.NewInternalServerError("dish service could not do GetAll()")
-		return nil, fcerr
+		return nil, err
+	}
+	resultDishes, err := s.repository.GetDishes(ctx, member.HouseholdID)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the dishes", err.Status())
 	}
 	return resultDishes, nil
 
 }
 
+//List(requestUser *userDomain.User, q dish.Query) gets a page of the dishes in the requestUser's household, narrowed
+//down and sorted the way q says, and the cursor for the page after it - or "" if this is the last page. Finding nothing
+//is an empty list rather than an error. A Limit of 0 gives every dish.
+func (s *service) List(ctx context.Context, requestUser *userDomain.User, q dish.Query) (*dish.Dishes, string, fcerr.FCErr) {
+	if details := checkQuery(q); len(details) > 0 {
+		return nil, "", fcerr.NewValidationError("The dishes could not be listed", details...)
+	}
+	member, err := household.Membership(ctx, s.repository, requestUser)
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit := q.Limit
+	if limit > 0 {
+		//one more than the page, to know whether there is another
+		q.Limit++
+	}
+	resultDishes, err := s.repository.FindDishes(ctx, member.HouseholdID, q)
+	if err != nil {
+		return nil, "", fcerr.Wrap(err, "Could not get the dishes", err.Status())
+	}
+
+	next := ""
+	if limit > 0 && len(*resultDishes) > limit {
+		page := (*resultDishes)[:limit]
+		resultDishes = &page
+		next = dish.CursorAfter(page[limit-1], q.Sort, q.Descending).Encode()
+	}
+	return resultDishes, next, nil
+}
+
+//checkQuery gives what is wrong with the query, if anything.
+func checkQuery(q dish.Query) []fcerr.FieldDetail {
+	var details []fcerr.FieldDetail
+	if !dish.ValidSort(q.Sort) {
+		details = append(details, fcerr.FieldDetail{Field: "sort", Message: "not a key dishes can be sorted by: " + q.Sort})
+	}
+	if q.Limit < 0 || q.Limit > MaxPageSize {
+		details = append(details, fcerr.FieldDetail{Field: "limit", Message: "not between 1 and " + strconv.Itoa(MaxPageSize)})
+	}
+	if q.Priority != "" && !dish.ValidPriority(q.Priority) {
+		details = append(details, fcerr.FieldDetail{Field: "priority", Message: "not high, medium or low: " + q.Priority})
+	}
+	if q.After != nil && (q.After.Sort != q.Sort || q.After.Descending != q.Descending) {
+		details = append(details, fcerr.FieldDetail{Field: "cursor", Message: "from a list sorted another way"})
+	}
+	return details
+}
+
 //GetExpired(requestUser *userDomain.User) gets all the dishes for the requestUser that are already expired
-func (s *service) GetExpired(requestUser *userDomain.User) (*dish.Dishes, fcerr.FCErr) {
+func (s *service) GetExpired(ctx context.Context, requestUser *userDomain.User) (*dish.Dishes, fcerr.FCErr) {
 	//var cDish dish.Dish
 	var expiredDishes dish.Dishes
-	resultDishes, err := s.repository.GetDishes(requestUser.UserID)
+	member, err := household.Membership(ctx, s.repository, requestUser)
+	if err != nil {
+		return nil, err
+	}
+	resultDishes, err := s.repository.GetDishes(ctx, member.HouseholdID)
 
 	if err != nil {
-		return nil, fcerr.NewInternalServerError("Could not retrieve the dishes")
+		return nil, fcerr.Wrap(err, "Could not retrieve the dishes", err.Status())
 	}
 
 	for i, d := range *resultDishes {
@@ -84,12 +163,16 @@ func (s *service) GetExpired(requestUser *userDomain.User) (*dish.Dishes, fcerr.
 }
 
 //GetExpiredByDate(requestUser *userDomain.User, expireDateStr string) gets all the dishes for the requestUser that are going to expire by the given date
-func (s *service) GetExpiredByDate(requestUser *userDomain.User, expireDateStr string) (*dish.Dishes, fcerr.FCErr) {
+func (s *service) GetExpiredByDate(ctx context.Context, requestUser *userDomain.User, expireDateStr string) (*dish.Dishes, fcerr.FCErr) {
 	var expiredDishes dish.Dishes
-	resultDishes, err := s.repository.GetDishes(requestUser.UserID)
+	member, err := household.Membership(ctx, s.repository, requestUser)
+	if err != nil {
+		return nil, err
+	}
+	resultDishes, err := s.repository.GetDishes(ctx, member.HouseholdID)
 
 	if err != nil {
-		return nil, fcerr.NewInternalServerError("Could not retrieve the dishes")
+		return nil, fcerr.Wrap(err, "Could not retrieve the dishes", err.Status())
 	}
 
 	for i, d := range *resultDishes {
@@ -108,158 +191,172 @@ func (s *service) GetExpiredByDate(requestUser *userDomain.User, expireDateStr s
 	return &expiredDishes, nil
 }
 
-//Create(requestingUser *userDomain.User, newDish *dish.Dish, expireWindow string) takes a user, a dish, and an expirateion window in the form of Amazon.duration ("PnYnMnDTnHnMnS") and creates the dish.
-func (s *service) Create(requestingUser *userDomain.User, newDish *dish.Dish, expireWindow string) (*dish.Dish, fcerr.FCErr) {
-
-	datePattern := "2006-01-02T15:04:05"
-
-	timehereandnow := time.Now().In(time.UTC)
-
-	createdDate := timehereandnow.Format(datePattern)
-
-	expireDate := timehereandnow.Add(parseDuration(expireWindow)).Format(datePattern)
+//Create(requestingUser *userDomain.User, newDish *dish.Dish, expireWindow string) takes a user, a dish, and an expirateion window in the form of Amazon.duration ("PnYnMnWnDTnHnMnS") and creates the dish.
+//The window is added to the creation time on the calendar, so "P1M" is a calendar month. A window that can't be parsed is a 400.
+func (s *service) Create(ctx context.Context, requestingUser *userDomain.User, newDish *dish.Dish, expireWindow string) (*dish.Dish, fcerr.FCErr) {
+	window, fcErr := duration.Parse(expireWindow)
+	if fcErr != nil {
+		return nil, expireWindowError(fcErr)
+	}
 
-	personalCount, err := s.repository.GetPersonalDishCount(requestingUser.UserID)
-	if err != nil {
-		return nil, fcerr.NewInternalServerError("Error when creating the dish.")
+	member, fcErr := household.Membership(ctx, s.repository, requestingUser)
+	if fcErr != nil {
+		return nil, fcErr
 	}
 
+	timehereandnow := dish.CanonicalTime(time.Now())
+
 	newDish.UserID = requestingUser.UserID
-	newDish.PersonalDishID = personalCount + 1
-	newDish.CreatedDate = createdDate
-	newDish.ExpireDate = expireDate
+	newDish.HouseholdID = member.HouseholdID
+	newDish.CreatedDate = timehereandnow
+	newDish.ExpireDate = window.AddTo(timehereandnow)
+
+	//Counting and inserting in one transaction keeps two creates from getting the same personal id
+	var resultDish *dish.Dish
+	err := s.repository.WithTx(ctx, func(tx db.Repository) fcerr.FCErr {
+		personalCount, err := tx.GetPersonalDishCount(ctx, member.HouseholdID)
+		if err != nil {
+			return fcerr.Wrap(err, "Error when creating the dish.", http.StatusInternalServerError)
+		}
 
-	//alexaid string, accessToken string, storageID string, title string, desc string, expire string, priority string, dishtype string, portions string
-	resultDish, err := s.repository.CreateDish(*newDish)
+		newDish.PersonalDishID = personalCount + 1
+
+		//alexaid string, accessToken string, storageID string, title string, desc string, expire string, priority string, dishtype string, portions string
+		resultDish, err = tx.CreateDish(ctx, *newDish)
+		if err != nil {
+			return fcerr.Wrap(err, "Dish Service could not do the Create()", err.Status())
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fcerr.NewInternalServerError("Dish Service could not do the Create()")
+		return nil, err
 	}
+	s.events.Publish(event.New(event.DishCreated, member.HouseholdID, requestingUser.UserID, timehereandnow, *resultDish))
 	return resultDish, nil
 
 }
 
-//Update(requestingUser *userDomain.User, newDish *dish.Dish, expireWindow string) parses the expire window and updates the dish with the resulting expireDate value
-func (s *service) Update(requestingUser *userDomain.User, newDish *dish.Dish, expireWindow string) fcerr.FCErr {
-	datePattern := "2006-01-02T15:04:05"
-	timehereandnow := time.Now().In(time.UTC)
-	newDish.ExpireDate = timehereandnow.Add(parseDuration(expireWindow)).Format(datePattern)
+//Update(requestingUser *userDomain.User, newDish *dish.Dish, expireWindow string) parses the expire window and updates the dish with the resulting expireDate value,
+//counted from now. An empty expireWindow leaves the dish's ExpireDate as it is. Only dishes in the user's household can be updated.
+func (s *service) Update(ctx context.Context, requestingUser *userDomain.User, newDish *dish.Dish, expireWindow string) fcerr.FCErr {
+	if expireWindow != "" {
+		window, fcErr := duration.Parse(expireWindow)
+		if fcErr != nil {
+			return expireWindowError(fcErr)
+		}
+		newDish.ExpireDate = window.AddTo(dish.CanonicalTime(time.Now()))
+	}
+
+	member, fcErr := household.Membership(ctx, s.repository, requestingUser)
+	if fcErr != nil {
+		return fcErr
+	}
+	if newDish.HouseholdID != member.HouseholdID {
+		return fcerr.NewNotFoundError("Could not find this dish in the user's household")
+	}
 
 	fmt.Println("\nWe are doing the dish service Update() with this dish:\n", newDish)
 	//alexaid string, accessToken string, storageID string, title string, desc string, expire string, priority string, dishtype string, portions string
-	err := s.repository.UpdateDish(*newDish)
+	err := s.repository.UpdateDish(ctx, *newDish)
 	if err != nil {
-		return fcerr.NewInternalServerError("Dish Service could not do the Update()")
+		return fcerr.Wrap(err, "Dish Service could not do the Update()", err.Status())
 	}
+	s.events.Publish(event.New(event.DishUpdated, member.HouseholdID, requestingUser.UserID, time.Now(), *newDish))
 	return nil
 }
 
-func (s *service) Delete(requestingUser *userDomain.User, dishID int) fcerr.FCErr {
+//Delete(requestingUser *userDomain.User, dishID int, outcome string) deletes the dish with the personal id from the user's
+//household, and records whether it was dish.Consumed or dish.Wasted. With no outcome, a dish that had expired is taken
+//as wasted and any other as consumed.
+func (s *service) Delete(ctx context.Context, requestingUser *userDomain.User, dishID int, outcome string) fcerr.FCErr {
 
 	fmt.Println("We are doing the dish service Delete() with this dish:\n", dishID)
-	//alexaid string, accessToken string, storageID string, title string, desc string, expire string, priority string, dishtype string, portions string
-	err := s.repository.DeleteDish(requestingUser.UserID, dishID)
+	if outcome != "" && !dish.ValidOutcome(outcome) {
+		return fcerr.NewValidationError("The outcome must be "+dish.Consumed+" or "+dish.Wasted,
+			fcerr.FieldDetail{Field: "outcome", Message: "not consumed or wasted: " + outcome})
+	}
+	member, err := household.Membership(ctx, s.repository, requestingUser)
+	if err != nil {
+		return err
+	}
+
+	now := dish.CanonicalTime(time.Now())
+	var removed *dish.Dish
+	//The dish is read, deleted and recorded as removed in one transaction, so a removal is never counted twice
+	err = s.repository.WithTx(ctx, func(tx db.Repository) fcerr.FCErr {
+		var err fcerr.FCErr
+		removed, err = tx.GetDishByID(ctx, member.HouseholdID, dishID)
+		if errors.Is(err, fcerr.ErrNotFound) {
+			return fcerr.NewBadRequestError("Could not delete a dish that doesn't exist")
+		} else if err != nil {
+			return err
+		}
+		//alexaid string, accessToken string, storageID string, title string, desc string, expire string, priority string, dishtype string, portions string
+		if err := tx.DeleteDish(ctx, member.HouseholdID, dishID); err != nil {
+			return err
+		}
+		if outcome == "" {
+			outcome = removed.Outcome(now)
+		}
+		return tx.CreateRemoval(ctx, dish.Removal{HouseholdID: member.HouseholdID, UserID: requestingUser.UserID,
+			StorageID: removed.StorageID, Title: removed.Title, ExpireDate: removed.ExpireDate, RemovedDate: now, Outcome: outcome})
+	})
 	if err != nil {
 
 		if err.Status() == http.StatusBadRequest {
-			return fcerr.NewBadRequestError("Dish Service could not do Delete() for what appears to be a bad request")
+			return fcerr.Wrap(err, "Dish Service could not do Delete() for what appears to be a bad request", http.StatusBadRequest)
 		} else {
-			return fcerr.NewInternalServerError("Dish Service could not do the Delete()")
+			return fcerr.Wrap(err, "Dish Service could not do the Delete()", http.StatusInternalServerError)
 		}
 
 	}
+	s.events.Publish(event.New(event.DishDeleted, member.HouseholdID, requestingUser.UserID, now,
+		event.RemovedDish{Dish: *removed, Outcome: outcome}))
 	return nil
 
 }
 
-//parseDuration(expireWindow string) takes a string in the form "PnYnMnDTnHnMnS" and returns a duration in nanoseconds
-func parseDuration(expireWindow string) (resultDuration time.Duration) {
-	expireWindow = expireWindow[1:]
-
-	//Split the whole thing on the T seperator, whether all values are on one side or the other, or both halves present
-	dateHalf, timeHalf, timeFound := strings.Cut(expireWindow, "T")
-
-	//Look for a number of years
-	yearString, rest, found := strings.Cut(dateHalf, "Y")
-	if found {
-		yearNumber, err := strconv.Atoi(yearString)
-		if err != nil {
-			return 0
-		}
-		yearNumberInHours := 8760 * yearNumber
-		resultDuration, _ = time.ParseDuration(fmt.Sprintf("%dh", yearNumberInHours))
-		dateHalf = rest
-	} else {
-		dateHalf = yearString
+//GetRemovals(requestingUser *userDomain.User, since time.Time) gets the dishes removed from the user's household since
+//the given time, and whether each was eaten or wasted.
+func (s *service) GetRemovals(ctx context.Context, requestingUser *userDomain.User, since time.Time) (*dish.Removals, fcerr.FCErr) {
+	member, err := household.Membership(ctx, s.repository, requestingUser)
+	if err != nil {
+		return nil, err
 	}
+	removals, err := s.repository.GetRemovals(ctx, member.HouseholdID, since)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the removed dishes", err.Status())
+	}
+	return removals, nil
+}
 
-	//Look for a number of months - using 730 hours as an approximate - will not be precise
-	monthString, rest, found := strings.Cut(dateHalf, "M")
-	if found {
-		monthNumber, err := strconv.Atoi(monthString)
-		if err != nil {
-			return 0
-		}
-		monthNumberInHours := 730 * monthNumber
-		newDuration, _ := time.ParseDuration(fmt.Sprintf("%dh", monthNumberInHours))
-		resultDuration += newDuration
-		dateHalf = rest
-	} else {
-		dateHalf = monthString
-	}
-
-	//Look for a number of days
-	dayString, rest, found := strings.Cut(dateHalf, "D")
-	if found {
-		dayNumber, err := strconv.Atoi(dayString)
-		if err != nil {
-			return 0
-		}
-		dayNumberInHours := 24 * dayNumber
-		newDuration, _ := time.ParseDuration(fmt.Sprintf("%dh", dayNumberInHours))
-		resultDuration += newDuration
-	}
-
-	if timeFound {
-		//Look for a number of hours
-		hourString, rest, found := strings.Cut(timeHalf, "H")
-		if found {
-			hourNumber, err := strconv.Atoi(hourString)
-			if err != nil {
-				return 0
-			}
-			newDuration, _ := time.ParseDuration(fmt.Sprintf("%dh", hourNumber))
-			resultDuration += newDuration
-			timeHalf = rest
-		} else {
-			timeHalf = hourString
-		}
-
-		//Look for a number of Minutes
-		minuteString, rest, found := strings.Cut(timeHalf, "M")
-		if found {
-			minuteNumber, err := strconv.Atoi(minuteString)
-			if err != nil {
-				return 0
-			}
-			newDuration, _ := time.ParseDuration(fmt.Sprintf("%dm", minuteNumber))
-			resultDuration += newDuration
-			timeHalf = rest
-		} else {
-			timeHalf = minuteString
-		}
+//expireWindowError points a duration.Parse error at the expireWindow field of the request.
+func expireWindowError(err fcerr.FCErr) fcerr.FCErr {
+	return fcerr.NewValidationError(err.Message(), fcerr.FieldDetail{Field: "expireWindow", Message: err.Message()})
+}
 
-		//Look for a number of Seconds
-		secondString, rest, found := strings.Cut(timeHalf, "S")
-		if found {
-			secondNumber, err := strconv.Atoi(secondString)
-			if err != nil {
-				return 0
-			}
-			newDuration, _ := time.ParseDuration(fmt.Sprintf("%ds", secondNumber))
-			resultDuration += newDuration
-		}
+//ExpiryStats(window duration.Duration) sums up how many dishes across every household have expired, and how many will
+//within the window from now. It is for admins, so it isn't limited to anyone's household.
+func (s *service) ExpiryStats(ctx context.Context, window duration.Duration) (*dish.ExpiryStats, fcerr.FCErr) {
+	counts, err := s.repository.GetDishExpiryCounts(ctx)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not count the dishes", err.Status())
 	}
+	now := time.Now()
+	stats := counts.Stats(now, window.AddTo(now))
+	return &stats, nil
+}
 
-	return resultDuration
-
+//PublishExpired(after time.Time, until time.Time) publishes an event.DishExpired for each dish, in any household, that
+//expired after the first time and by the second, and gives how many there were. Called with the times each check ran,
+//it tells of every dish expiring once.
+func (s *service) PublishExpired(ctx context.Context, after time.Time, until time.Time) (int, fcerr.FCErr) {
+	expired, err := s.repository.GetDishesExpiringBetween(ctx, after, until)
+	if err != nil {
+		return 0, fcerr.Wrap(err, "Could not get the dishes that expired", err.Status())
+	}
+	for _, d := range *expired {
+		s.events.Publish(event.New(event.DishExpired, d.HouseholdID, 0, d.ExpireDate, d))
+	}
+	return len(*expired), nil
 }
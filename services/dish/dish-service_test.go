@@ -1,13 +1,18 @@
 package dish
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/event"
 	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/duration"
+	"github.com/jasonradcliffe/freshness-countdown-api/eventbus"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	dbrepo "github.com/jasonradcliffe/freshness-countdown-api/repository/db"
@@ -18,11 +23,12 @@ var nD = &dishDomain.Dish{
 	DishID:         200,
 	PersonalDishID: 2,
 	UserID:         2,
+	HouseholdID:    2,
 	StorageID:      3,
 	Title:          "Carrots",
 	Description:    "Some carrots we got at the store",
-	CreatedDate:    "2006-01-02T15:04:05",
-	ExpireDate:     "2020-10-13T08:00",
+	CreatedDate:    time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+	ExpireDate:     time.Date(2020, 10, 13, 8, 0, 0, 0, time.UTC),
 	Priority:       "",
 	DishType:       "",
 	Portions:       -1,
@@ -38,11 +44,28 @@ var nU = &userDomain.User{
 	CreatedDate:  "2016-01-02T15:04:05",
 	AccessToken:  "ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 	RefreshToken: "105i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-	AlexaUserID:  "qwertyuiop",
 	Admin:        false,
 	TempMatch:    "1v842d234523a",
 }
 
+//expectMembership expects the service to look up nU's household with query, finding nU owns household 2.
+func expectMembership(mock sqlmock.Sqlmock, query string) {
+	mock.ExpectQuery(query).WithArgs(nU.UserID).
+		WillReturnRows(sqlmock.NewRows([]string{"household_id", "user_id", "role", "joined_date", "email", "full_name"}).
+			AddRow(nD.HouseholdID, nU.UserID, "owner", "2016-01-02 15:04:05", nU.Email, nU.FullName))
+}
+
+//dishRow gives the rows a dish query returns for the dishes.
+func dishRow(dishes ...*dishDomain.Dish) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"})
+	for _, d := range dishes {
+		rows.AddRow(d.DishID, d.PersonalDishID, d.UserID, d.StorageID, d.Title, d.Description, d.CreatedDate,
+			d.ExpireDate, d.Priority, d.DishType, d.Portions, d.TempMatch, d.HouseholdID)
+	}
+	return rows
+}
+
 func TestDishService_GetByID(t *testing.T) {
 	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if testerr != nil {
@@ -58,13 +81,15 @@ func TestDishService_GetByID(t *testing.T) {
 	dS := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetDishByIDBase, nD.UserID, nD.PersonalDishID)).WillReturnRows(rows)
+	expectMembership(mock, dbrepo.GetMembershipQuery)
 
-	resultingDish, err := dS.GetByID(nU, nD.PersonalDishID)
+	mock.ExpectQuery(dbrepo.GetDishByIDQuery).WithArgs(nD.UserID, nD.PersonalDishID).WillReturnRows(rows)
+
+	resultingDish, err := dS.GetByID(context.Background(), nU, nD.PersonalDishID)
 	fmt.Println("got this dish from the test:", resultingDish)
 
 	assert.Equal(t, nD.Title, resultingDish.Title)
@@ -85,16 +110,18 @@ func TestDishService_GetByID_NotFound(t *testing.T) {
 	dS := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"})
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"})
+
+	expectMembership(mock, dbrepo.GetMembershipQuery)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetDishByIDBase, nD.UserID, nD.PersonalDishID)).WillReturnRows(rows)
+	mock.ExpectQuery(dbrepo.GetDishByIDQuery).WithArgs(nD.UserID, nD.PersonalDishID).WillReturnRows(rows)
 
-	resultingDish, err := dS.GetByID(nU, nD.PersonalDishID)
+	resultingDish, err := dS.GetByID(context.Background(), nU, nD.PersonalDishID)
 	fmt.Println("got this dish from the test:", resultingDish)
 
 	assert.Nil(t, resultingDish)
 	assert.NotNil(t, err)
-	assert.Equal(t, http.StatusInternalServerError, err.Status())
+	assert.Equal(t, http.StatusNotFound, err.Status())
 }
 
 func TestDishService_GetAll(t *testing.T) {
@@ -112,15 +139,17 @@ func TestDishService_GetAll(t *testing.T) {
 	dS := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch).
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID).
 		AddRow(nD.DishID+1, nD.PersonalDishID+1, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetDishesBase, nU.UserID)).WillReturnRows(rows)
+	expectMembership(mock, dbrepo.GetMembershipQuery)
 
-	resultingDishes, err := dS.GetAll(nU)
+	mock.ExpectQuery(dbrepo.GetDishesQuery).WithArgs(nU.UserID).WillReturnRows(rows)
+
+	resultingDishes, err := dS.GetAll(context.Background(), nU)
 	dish := (*resultingDishes)[0]
 
 	assert.Nil(t, err)
@@ -145,15 +174,17 @@ func TestDishService_GetAll_NotFound(t *testing.T) {
 	dS := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"})
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"})
+
+	expectMembership(mock, dbrepo.GetMembershipQuery)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetDishesBase, nU.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(dbrepo.GetDishesQuery).WithArgs(nU.UserID).WillReturnRows(rows)
 
-	resultingDishes, err := dS.GetAll(nU)
+	resultingDishes, err := dS.GetAll(context.Background(), nU)
 
 	assert.Nil(t, resultingDishes)
 	assert.NotNil(t, err)
-	assert.Equal(t, http.StatusInternalServerError, err.Status())
+	assert.Equal(t, http.StatusNotFound, err.Status())
 
 }
 
@@ -172,15 +203,17 @@ func TestDishService_GetExpired(t *testing.T) {
 	dS := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch).
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID).
 		AddRow(nD.DishID+1, nD.PersonalDishID+1, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			"2019-10-13T08:00", nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			"2019-10-13 08:00:00", nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetDishesBase, nU.UserID)).WillReturnRows(rows)
+	expectMembership(mock, dbrepo.GetMembershipQuery)
 
-	resultingDishes, err := dS.GetExpired(nU)
+	mock.ExpectQuery(dbrepo.GetDishesQuery).WithArgs(nU.UserID).WillReturnRows(rows)
+
+	resultingDishes, err := dS.GetExpired(context.Background(), nU)
 	//dish := (*resultingDishes)[0]
 
 	assert.Nil(t, err)
@@ -204,15 +237,17 @@ func TestDishService_GetExpired_InvalidDishExpDateInList(t *testing.T) {
 	dS := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch).
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID).
 		AddRow(nD.DishID+1, nD.PersonalDishID+1, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			"201910INVALIDDATE13T08:00", nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			"201910INVALIDDATE13T08:00", nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
+
+	expectMembership(mock, dbrepo.GetMembershipQuery)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetDishesBase, nU.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(dbrepo.GetDishesQuery).WithArgs(nU.UserID).WillReturnRows(rows)
 
-	resultingDishes, err := dS.GetExpired(nU)
+	resultingDishes, err := dS.GetExpired(context.Background(), nU)
 	//dish := (*resultingDishes)[0]
 
 	assert.Nil(t, err)
@@ -236,16 +271,18 @@ func TestDishService_GetExpired_NoDishesFound(t *testing.T) {
 	dS := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"})
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"})
+
+	expectMembership(mock, dbrepo.GetMembershipQuery)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetDishesBase, nU.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(dbrepo.GetDishesQuery).WithArgs(nU.UserID).WillReturnRows(rows)
 
-	resultingDishes, err := dS.GetExpired(nU)
+	resultingDishes, err := dS.GetExpired(context.Background(), nU)
 	//dish := (*resultingDishes)[0]
 
 	assert.Nil(t, resultingDishes)
 	assert.NotNil(t, err)
-	assert.Equal(t, http.StatusInternalServerError, err.Status())
+	assert.Equal(t, http.StatusNotFound, err.Status())
 
 }
 
@@ -264,17 +301,19 @@ func TestDishService_GetExpiredByDate(t *testing.T) {
 	dS := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch).
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID).
 		AddRow(nD.DishID+1, nD.PersonalDishID+1, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			"2024INVALID10-13T08:00", nD.Priority, nD.DishType, nD.Portions, nD.TempMatch).
+			"2024INVALID10-13T08:00", nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID).
 		AddRow(nD.DishID+1, nD.PersonalDishID+1, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			"2024-10-13T08:00", nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			"2024-10-13 08:00:00", nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
+
+	expectMembership(mock, dbrepo.GetMembershipQuery)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetDishesBase, nU.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(dbrepo.GetDishesQuery).WithArgs(nU.UserID).WillReturnRows(rows)
 
-	resultingDishes, err := dS.GetExpiredByDate(nU, "2023-10-13T08:00")
+	resultingDishes, err := dS.GetExpiredByDate(context.Background(), nU, "2023-10-13T08:00")
 	dish := (*resultingDishes)[0]
 
 	assert.Nil(t, err)
@@ -299,15 +338,17 @@ func TestDishService_GetExpiredByDate_NotFound(t *testing.T) {
 	dS := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"})
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"})
+
+	expectMembership(mock, dbrepo.GetMembershipQuery)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetDishesBase, nU.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(dbrepo.GetDishesQuery).WithArgs(nU.UserID).WillReturnRows(rows)
 
-	resultingDishes, err := dS.GetExpiredByDate(nU, nD.ExpireDate)
+	resultingDishes, err := dS.GetExpiredByDate(context.Background(), nU, nD.ExpireDate.Format(time.RFC3339))
 
 	assert.Nil(t, resultingDishes)
 	assert.NotNil(t, err)
-	assert.Equal(t, http.StatusInternalServerError, err.Status())
+	assert.Equal(t, http.StatusNotFound, err.Status())
 
 }
 
@@ -328,17 +369,23 @@ func TestDishService_Create(t *testing.T) {
 	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
+
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
+
+	mock.ExpectBegin()
 
 	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
 
-	mock.ExpectQuery(`I.*`).WillReturnRows(rows)
+	mock.ExpectExec(`INSERT INTO dish.*`).WillReturnResult(sqlmock.NewResult(int64(nD.DishID), 1))
 
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE temp_match = ".+"`).WillReturnRows(rows)
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE temp_match = \?`).WillReturnRows(rows)
 
-	resultingDish, err := dS.Create(nU, nD, "P1Y3DT2M")
+	mock.ExpectCommit()
+
+	resultingDish, err := dS.Create(context.Background(), nU, nD, "P1Y3DT2M")
 
 	assert.Nil(t, err)
 	assert.NotNil(t, resultingDish)
@@ -362,17 +409,23 @@ func TestDishService_Create2MoreTimeCombinations(t *testing.T) {
 	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1)
 
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
+
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
+
+	mock.ExpectBegin()
 
 	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
 
-	mock.ExpectQuery(`I.*`).WillReturnRows(rows)
+	mock.ExpectExec(`INSERT INTO dish.*`).WillReturnResult(sqlmock.NewResult(int64(nD.DishID), 1))
 
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE temp_match = ".+"`).WillReturnRows(rows)
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE temp_match = \?`).WillReturnRows(rows)
 
-	resultingDish, err := dS.Create(nU, nD, "P1MT2H30S")
+	mock.ExpectCommit()
+
+	resultingDish, err := dS.Create(context.Background(), nU, nD, "P1MT2H30S")
 
 	assert.Nil(t, err)
 	assert.NotNil(t, resultingDish)
@@ -393,21 +446,14 @@ func TestDishService_Create2MoreTimeCombinations_ParseErrors1(t *testing.T) {
 
 	dS := NewService(repo)
 
-	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1)
-
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
-		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
-
-	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
-	mock.ExpectQuery(`I.*`).WillReturnRows(rows)
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE temp_match = ".+"`).WillReturnRows(rows)
-
-	resultingDish, err := dS.Create(nU, nD, "P1aY1M1DT2H2M30S")
-	assert.Nil(t, err)
-	assert.NotNil(t, resultingDish)
+	resultingDish, err := dS.Create(context.Background(), nU, nD, "P1aY1M1DT2H2M30S")
+	assert.Nil(t, resultingDish)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+	assert.Equal(t, "expireWindow", err.Details()[0].Field)
 
+	//a bad window is turned away before the database is touched
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
 func TestDishService_Create2MoreTimeCombinations_ParseErrors2(t *testing.T) {
@@ -424,21 +470,14 @@ func TestDishService_Create2MoreTimeCombinations_ParseErrors2(t *testing.T) {
 
 	dS := NewService(repo)
 
-	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1)
-
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
-		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
-
-	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
-	mock.ExpectQuery(`I.*`).WillReturnRows(rows)
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE temp_match = ".+"`).WillReturnRows(rows)
-
-	resultingDish, err := dS.Create(nU, nD, "P1Ya1M1DT2H2M30S")
-	assert.Nil(t, err)
-	assert.NotNil(t, resultingDish)
+	resultingDish, err := dS.Create(context.Background(), nU, nD, "P1Ya1M1DT2H2M30S")
+	assert.Nil(t, resultingDish)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+	assert.Equal(t, "expireWindow", err.Details()[0].Field)
 
+	//a bad window is turned away before the database is touched
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
 func TestDishService_Create2MoreTimeCombinations_ParseErrors3(t *testing.T) {
@@ -455,21 +494,14 @@ func TestDishService_Create2MoreTimeCombinations_ParseErrors3(t *testing.T) {
 
 	dS := NewService(repo)
 
-	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1)
-
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
-		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
-
-	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
-	mock.ExpectQuery(`I.*`).WillReturnRows(rows)
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE temp_match = ".+"`).WillReturnRows(rows)
-
-	resultingDish, err := dS.Create(nU, nD, "P1Y1M1bDT2H2M30S")
-	assert.Nil(t, err)
-	assert.NotNil(t, resultingDish)
+	resultingDish, err := dS.Create(context.Background(), nU, nD, "P1Y1M1bDT2H2M30S")
+	assert.Nil(t, resultingDish)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+	assert.Equal(t, "expireWindow", err.Details()[0].Field)
 
+	//a bad window is turned away before the database is touched
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
 func TestDishService_Create2MoreTimeCombinations_ParseErrors4(t *testing.T) {
@@ -486,21 +518,14 @@ func TestDishService_Create2MoreTimeCombinations_ParseErrors4(t *testing.T) {
 
 	dS := NewService(repo)
 
-	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1)
-
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
-		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
-
-	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
-	mock.ExpectQuery(`I.*`).WillReturnRows(rows)
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE temp_match = ".+"`).WillReturnRows(rows)
-
-	resultingDish, err := dS.Create(nU, nD, "P1Y1M1DTf2H2M30S")
-	assert.Nil(t, err)
-	assert.NotNil(t, resultingDish)
+	resultingDish, err := dS.Create(context.Background(), nU, nD, "P1Y1M1DTf2H2M30S")
+	assert.Nil(t, resultingDish)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+	assert.Equal(t, "expireWindow", err.Details()[0].Field)
 
+	//a bad window is turned away before the database is touched
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
 func TestDishService_Create2MoreTimeCombinations_ParseErrors5(t *testing.T) {
@@ -517,21 +542,14 @@ func TestDishService_Create2MoreTimeCombinations_ParseErrors5(t *testing.T) {
 
 	dS := NewService(repo)
 
-	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1)
-
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
-		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
-
-	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
-	mock.ExpectQuery(`I.*`).WillReturnRows(rows)
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE temp_match = ".+"`).WillReturnRows(rows)
-
-	resultingDish, err := dS.Create(nU, nD, "P1Y1M1DT2Hn2M30S")
-	assert.Nil(t, err)
-	assert.NotNil(t, resultingDish)
+	resultingDish, err := dS.Create(context.Background(), nU, nD, "P1Y1M1DT2Hn2M30S")
+	assert.Nil(t, resultingDish)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+	assert.Equal(t, "expireWindow", err.Details()[0].Field)
 
+	//a bad window is turned away before the database is touched
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
 func TestDishService_Create2MoreTimeCombinations_ParseErrors6(t *testing.T) {
@@ -548,21 +566,14 @@ func TestDishService_Create2MoreTimeCombinations_ParseErrors6(t *testing.T) {
 
 	dS := NewService(repo)
 
-	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1)
-
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
-		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
-
-	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
-	mock.ExpectQuery(`I.*`).WillReturnRows(rows)
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE temp_match = ".+"`).WillReturnRows(rows)
-
-	resultingDish, err := dS.Create(nU, nD, "P1Y1M1DT2H2M3d0S")
-	assert.Nil(t, err)
-	assert.NotNil(t, resultingDish)
+	resultingDish, err := dS.Create(context.Background(), nU, nD, "P1Y1M1DT2H2M3d0S")
+	assert.Nil(t, resultingDish)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+	assert.Equal(t, "expireWindow", err.Details()[0].Field)
 
+	//a bad window is turned away before the database is touched
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
 func TestDishService_Create_ErrorOnDishCountLookup(t *testing.T) {
@@ -579,9 +590,15 @@ func TestDishService_Create_ErrorOnDishCountLookup(t *testing.T) {
 
 	dS := NewService(repo)
 
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
+
+	mock.ExpectBegin()
+
 	mock.ExpectQuery(`SELECT C.*`).WillReturnError(errors.New("database could not perform this action or returned some error."))
 
-	resultingDish, err := dS.Create(nU, nD, "P2DT2H")
+	mock.ExpectRollback()
+
+	resultingDish, err := dS.Create(context.Background(), nU, nD, "P2DT2H")
 
 	assert.Nil(t, resultingDish)
 	assert.NotNil(t, err)
@@ -605,18 +622,19 @@ func TestDishService_Create_CouldNotCreate(t *testing.T) {
 
 	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1)
 
-	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
-		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
+
+	mock.ExpectBegin()
 
 	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
 
-	mock.ExpectQuery(`I.*`).WillReturnRows(rows)
+	mock.ExpectExec(`INSERT INTO dish.*`).WillReturnResult(sqlmock.NewResult(int64(nD.DishID), 1))
+
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE temp_match = \?`).WillReturnError(errors.New("Could Not Retrieve a dish with the same temp match as we though we just added"))
 
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE temp_match = ".+"`).WillReturnError(errors.New("Could Not Retrieve a dish with the same temp match as we though we just added"))
+	mock.ExpectRollback()
 
-	resultingDish, err := dS.Create(nU, nD, "P2DT2H")
+	resultingDish, err := dS.Create(context.Background(), nU, nD, "P2DT2H")
 
 	assert.Nil(t, resultingDish)
 	assert.NotNil(t, err)
@@ -638,18 +656,18 @@ func TestDishService_Update(t *testing.T) {
 
 	dS := NewService(repo)
 
-	emptyRows := sqlmock.NewRows([]string{})
-
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
 
-	mock.ExpectQuery(`UPDATE.*`).WillReturnRows(emptyRows)
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
 
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE.*`).WillReturnRows(rows)
+	mock.ExpectExec(`UPDATE.*`).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	err = dS.Update(nU, nD, "P1Y3DT2M")
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnRows(rows)
+
+	err = dS.Update(context.Background(), nU, nD, "P1Y3DT2M")
 
 	assert.Nil(t, err)
 }
@@ -668,9 +686,11 @@ func TestDishService_Update_CouldNotUpdate(t *testing.T) {
 
 	dS := NewService(repo)
 
-	mock.ExpectQuery(`UPDATE.*`).WillReturnError(errors.New("Database error, could not update"))
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
+
+	mock.ExpectExec(`UPDATE.*`).WillReturnError(errors.New("Database error, could not update"))
 
-	err = dS.Update(nU, nD, "P1Y3DT2M")
+	err = dS.Update(context.Background(), nU, nD, "P1Y3DT2M")
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
@@ -691,13 +711,13 @@ func TestDishService_Update_CouldNotVerifyUpdate(t *testing.T) {
 
 	dS := NewService(repo)
 
-	emptyRows := sqlmock.NewRows([]string{})
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
 
-	mock.ExpectQuery(`UPDATE.*`).WillReturnRows(emptyRows)
+	mock.ExpectExec(`UPDATE.*`).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE.*`).WillReturnError(errors.New("Database error - could not verify update"))
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnError(errors.New("Database error - could not verify update"))
 
-	err = dS.Update(nU, nD, "P1Y3DT2M")
+	err = dS.Update(context.Background(), nU, nD, "P1Y3DT2M")
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
@@ -719,15 +739,23 @@ func TestDishService_Delete(t *testing.T) {
 
 	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(2)
 
-	emptyRows := sqlmock.NewRows([]string{})
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
+
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnRows(dishRow(nD))
 
 	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
 
-	mock.ExpectQuery(`DELETE FROM dish WHERE.*`).WillReturnRows(emptyRows)
+	mock.ExpectExec(`DELETE FROM dish WHERE.*`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnError(errors.New("Database error - dish not found"))
+
+	mock.ExpectExec(`INSERT INTO dish_removal.*`).WillReturnResult(sqlmock.NewResult(1, 1))
 
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE.*`).WillReturnError(errors.New("Database error - dish not found"))
+	mock.ExpectCommit()
 
-	err = dS.Delete(nU, nD.PersonalDishID)
+	err = dS.Delete(context.Background(), nU, nD.PersonalDishID, "")
 
 	assert.Nil(t, err)
 }
@@ -746,17 +774,15 @@ func TestDishService_Delete_DishIDTooHigh(t *testing.T) {
 
 	dS := NewService(repo)
 
-	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(2)
-
-	emptyRows := sqlmock.NewRows([]string{})
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
 
-	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
+	mock.ExpectBegin()
 
-	mock.ExpectQuery(`DELETE FROM dish WHERE.*`).WillReturnRows(emptyRows)
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnRows(dishRow())
 
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE.*`).WillReturnError(errors.New("Database error - dish not found"))
+	mock.ExpectRollback()
 
-	err = dS.Delete(nU, nD.PersonalDishID+2)
+	err = dS.Delete(context.Background(), nU, nD.PersonalDishID+2, "")
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusBadRequest, err.Status())
@@ -776,9 +802,17 @@ func TestDishService_Delete_ErrorGettingDishCount(t *testing.T) {
 
 	dS := NewService(repo)
 
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
+
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnRows(dishRow(nD))
+
 	mock.ExpectQuery(`SELECT C.*`).WillReturnError(errors.New("Database error - could not get dish count"))
 
-	err = dS.Delete(nU, nD.PersonalDishID)
+	mock.ExpectRollback()
+
+	err = dS.Delete(context.Background(), nU, nD.PersonalDishID, "")
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
@@ -800,11 +834,19 @@ func TestDishService_Delete_ErrorOnDelete(t *testing.T) {
 
 	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(2)
 
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
+
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnRows(dishRow(nD))
+
 	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
 
-	mock.ExpectQuery(`DELETE FROM dish WHERE.*`).WillReturnError(errors.New("Could not do the delete query"))
+	mock.ExpectExec(`DELETE FROM dish WHERE.*`).WillReturnError(errors.New("Could not do the delete query"))
+
+	mock.ExpectRollback()
 
-	err = dS.Delete(nU, nD.PersonalDishID)
+	err = dS.Delete(context.Background(), nU, nD.PersonalDishID, "")
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
@@ -826,20 +868,26 @@ func TestDishService_Delete_FindsDeletedDishOnDoubleCheck(t *testing.T) {
 
 	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(2)
 
-	emptyRows := sqlmock.NewRows([]string{})
-
 	rows := sqlmock.NewRows([]string{"id", "personal_id", "user_id", "storage_id", "title", "description", "created_date",
-		"expire_date", "priority", "dish_type", "portions", "temp_match"}).
+		"expire_date", "priority", "dish_type", "portions", "temp_match", "household_id"}).
 		AddRow(nD.DishID, nD.PersonalDishID, nD.UserID, nD.StorageID, nD.Title, nD.Description, nD.CreatedDate,
-			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch)
+			nD.ExpireDate, nD.Priority, nD.DishType, nD.Portions, nD.TempMatch, nD.HouseholdID)
+
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
+
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnRows(dishRow(nD))
 
 	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
 
-	mock.ExpectQuery(`DELETE FROM dish WHERE.*`).WillReturnRows(emptyRows)
+	mock.ExpectExec(`DELETE FROM dish WHERE.*`).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE.*`).WillReturnRows(rows)
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnRows(rows)
 
-	err = dS.Delete(nU, nD.PersonalDishID)
+	mock.ExpectRollback()
+
+	err = dS.Delete(context.Background(), nU, nD.PersonalDishID, "")
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
@@ -861,17 +909,25 @@ func TestDishService_Delete_DecrementSomeDishes(t *testing.T) {
 
 	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(4)
 
-	emptyRows := sqlmock.NewRows([]string{})
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
+
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnRows(dishRow(nD))
 
 	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
 
-	mock.ExpectQuery(`DELETE FROM dish WHERE.*`).WillReturnRows(emptyRows)
+	mock.ExpectExec(`DELETE FROM dish WHERE.*`).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE.*`).WillReturnError(errors.New("Database error - dish not found"))
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnError(errors.New("Database error - dish not found"))
 
-	mock.ExpectQuery(`UPDATE.*`).WillReturnRows(emptyRows)
+	mock.ExpectExec(`UPDATE.*`).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	err = dS.Delete(nU, nD.PersonalDishID)
+	mock.ExpectExec(`INSERT INTO dish_removal.*`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectCommit()
+
+	err = dS.Delete(context.Background(), nU, nD.PersonalDishID, "")
 
 	assert.Nil(t, err)
 }
@@ -892,19 +948,223 @@ func TestDishService_Delete_DecrementSomeDishesError(t *testing.T) {
 
 	dishCount := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(4)
 
-	emptyRows := sqlmock.NewRows([]string{})
+	expectMembership(mock, `SELECT .+ FROM household_member.*`)
+
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnRows(dishRow(nD))
 
 	mock.ExpectQuery(`SELECT C.*`).WillReturnRows(dishCount)
 
-	mock.ExpectQuery(`DELETE FROM dish WHERE.*`).WillReturnRows(emptyRows)
+	mock.ExpectExec(`DELETE FROM dish WHERE.*`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectQuery(`SELECT .+ FROM dish WHERE.*`).WillReturnError(errors.New("Database error - dish not found"))
 
-	mock.ExpectQuery(`SELECT \* FROM dish WHERE.*`).WillReturnError(errors.New("Database error - dish not found"))
+	mock.ExpectExec(`UPDATE.*`).WillReturnError(errors.New("Could not decrement those dishes"))
 
-	mock.ExpectQuery(`UPDATE.*`).WillReturnError(errors.New("Could not decrement those dishes"))
+	mock.ExpectRollback()
 
-	err = dS.Delete(nU, nD.PersonalDishID)
+	err = dS.Delete(context.Background(), nU, nD.PersonalDishID, "")
 
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
 
 }
+
+func TestDishService_CreateAndDelete_MemoryRepository(t *testing.T) {
+	dS := NewService(dbrepo.NewMemoryRepository())
+
+	for _, title := range []string{"Carrots", "Soup", "Rice"} {
+		newDish := *nD
+		newDish.Title = title
+		_, err := dS.Create(context.Background(), nU, &newDish, "P2DT4H")
+		assert.Nil(t, err)
+	}
+
+	rice, err := dS.GetByID(context.Background(), nU, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "Rice", rice.Title)
+
+	err = dS.Delete(context.Background(), nU, 1, "")
+	assert.Nil(t, err)
+
+	dishes, err := dS.GetAll(context.Background(), nU)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(*dishes))
+
+	rice, err = dS.GetByID(context.Background(), nU, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "Rice", rice.Title)
+
+	err = dS.Delete(context.Background(), nU, 3, "")
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+}
+
+func TestDishService_Delete_RecordsRemovals(t *testing.T) {
+	repo := dbrepo.NewMemoryRepository()
+	dS := NewService(repo)
+	before := time.Now().Add(-time.Second)
+
+	for _, title := range []string{"Carrots", "Soup", "Rice"} {
+		newDish := *nD
+		newDish.Title = title
+		_, err := dS.Create(context.Background(), nU, &newDish, "P2D")
+		assert.Nil(t, err)
+	}
+	member, _ := repo.GetMembership(context.Background(), nU.UserID)
+	soup, _ := repo.GetDishByID(context.Background(), member.HouseholdID, 2)
+	soup.ExpireDate = time.Now().Add(-time.Hour)
+	repo.UpdateDish(context.Background(), *soup)
+
+	err := dS.Delete(context.Background(), nU, 1, "eaten")
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+	assert.Nil(t, dS.Delete(context.Background(), nU, 1, ""), "the carrots hadn't expired")
+	assert.Nil(t, dS.Delete(context.Background(), nU, 1, ""), "the soup had")
+	assert.Nil(t, dS.Delete(context.Background(), nU, 1, dishDomain.Wasted))
+	err = dS.Delete(context.Background(), nU, 1, "")
+	assert.Equal(t, http.StatusBadRequest, err.Status(), "no dishes are left")
+
+	removals, err := dS.GetRemovals(context.Background(), nU, before)
+	assert.Nil(t, err)
+	if assert.Equal(t, 3, len(*removals)) {
+		assert.Equal(t, "Carrots", (*removals)[0].Title)
+		assert.Equal(t, dishDomain.Consumed, (*removals)[0].Outcome)
+		assert.Equal(t, nD.StorageID, (*removals)[0].StorageID)
+		assert.Equal(t, dishDomain.Wasted, (*removals)[1].Outcome)
+		assert.Equal(t, dishDomain.Wasted, (*removals)[2].Outcome)
+	}
+	removals, _ = dS.GetRemovals(context.Background(), nU, time.Now().Add(time.Minute))
+	assert.Equal(t, 0, len(*removals))
+}
+
+func TestDishService_Create_FreshDishIsNotExpired(t *testing.T) {
+	dS := NewService(dbrepo.NewMemoryRepository())
+
+	newDish := *nD
+	created, err := dS.Create(context.Background(), nU, &newDish, "PT1H")
+	assert.Nil(t, err)
+	assert.Equal(t, time.UTC, created.ExpireDate.Location())
+	assert.Equal(t, time.Hour, created.ExpireDate.Sub(created.CreatedDate))
+
+	expired, err := dS.GetExpired(context.Background(), nU)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(*expired))
+
+	//a date without a zone is read as UTC
+	byDate, err := dS.GetExpiredByDate(context.Background(), nU, created.ExpireDate.Add(time.Minute).Format("2006-01-02 15:04:05"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*byDate))
+}
+
+func TestDishService_ExpiryStats(t *testing.T) {
+	dS := NewService(dbrepo.NewMemoryRepository())
+	other := *nU
+	other.UserID = nU.UserID + 1
+
+	for _, created := range []struct {
+		u      *userDomain.User
+		window string
+	}{{nU, "PT1H"}, {nU, "P2D"}, {nU, "P10D"}, {&other, "PT1H"}} {
+		newDish := *nD
+		_, err := dS.Create(context.Background(), created.u, &newDish, created.window)
+		assert.Nil(t, err)
+	}
+	window, _ := duration.Parse("P3D")
+
+	stats, err := dS.ExpiryStats(context.Background(), window)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, stats.Dishes)
+	assert.Equal(t, 0, stats.Expired)
+	assert.Equal(t, 3, stats.ExpiringSoon)
+	assert.Equal(t, 2, stats.Households)
+	assert.Equal(t, 0, stats.HouseholdsWithExpired)
+	assert.Equal(t, window.AddTo(stats.AsOf), stats.ExpiringBy)
+}
+
+func TestDishService_Events(t *testing.T) {
+	repo := dbrepo.NewMemoryRepository()
+	bus := eventbus.New()
+	var published []event.Event
+	bus.Subscribe(func(e event.Event) { published = append(published, e) })
+	dS := NewServiceWithEvents(repo, bus)
+
+	for _, window := range []string{"PT1H", "P2D"} {
+		newDish := *nD
+		_, err := dS.Create(context.Background(), nU, &newDish, window)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, dS.Delete(context.Background(), nU, 2, dishDomain.Wasted))
+
+	expired, err := dS.PublishExpired(context.Background(), time.Now(), time.Now().Add(2*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, expired, "only the dish expiring in an hour")
+	expired, _ = dS.PublishExpired(context.Background(), time.Now().Add(2*time.Hour), time.Now().Add(4*time.Hour))
+	assert.Equal(t, 0, expired)
+
+	if assert.Equal(t, 4, len(published)) {
+		member, _ := repo.GetMembership(context.Background(), nU.UserID)
+		for i, eventType := range []string{event.DishCreated, event.DishCreated, event.DishDeleted, event.DishExpired} {
+			assert.Equal(t, eventType, published[i].Type)
+			assert.Equal(t, member.HouseholdID, published[i].HouseholdID)
+		}
+		assert.Equal(t, nU.UserID, published[0].UserID)
+		removed, ok := published[2].Data.(event.RemovedDish)
+		if assert.True(t, ok) {
+			assert.Equal(t, dishDomain.Wasted, removed.Outcome)
+		}
+		assert.Equal(t, 0, published[3].UserID, "nobody expired the dish")
+	}
+}
+
+func TestDishService_List(t *testing.T) {
+	dS := NewService(dbrepo.NewMemoryRepository())
+	for _, window := range []string{"P3D", "PT1H", "P2D", "P1D", "P5D"} {
+		newDish := *nD
+		_, err := dS.Create(context.Background(), nU, &newDish, window)
+		assert.Nil(t, err)
+	}
+
+	q := dishDomain.Query{Sort: dishDomain.SortExpireDate, Limit: 2}
+	var order []int
+	for page := 0; page < 5; page++ {
+		dishes, next, err := dS.List(context.Background(), nU, q)
+		if !assert.Nil(t, err) {
+			break
+		}
+		for _, d := range *dishes {
+			order = append(order, d.PersonalDishID)
+		}
+		if next == "" {
+			break
+		}
+		q.After, _ = dishDomain.DecodeCursor(next)
+	}
+	assert.Equal(t, []int{2, 4, 3, 1, 5}, order, "soonest to expire first, over three pages")
+
+	all, next, err := dS.List(context.Background(), nU, dishDomain.Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(*all))
+	assert.Equal(t, "", next)
+
+	none, _, err := dS.List(context.Background(), nU, dishDomain.Query{Search: "cake"})
+	assert.Nil(t, err, "finding nothing isn't an error")
+	assert.Equal(t, 0, len(*none))
+}
+
+func TestDishService_List_InvalidQuery(t *testing.T) {
+	dS := NewService(dbrepo.NewMemoryRepository())
+	after := dishDomain.CursorAfter(*nD, dishDomain.SortPriority, false)
+
+	_, _, err := dS.List(context.Background(), nU, dishDomain.Query{Sort: "title", Limit: MaxPageSize + 1,
+		Priority: "soon", After: &after})
+
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusBadRequest, err.Status())
+		var fields []string
+		for _, detail := range err.Details() {
+			fields = append(fields, detail.Field)
+		}
+		assert.Equal(t, []string{"sort", "limit", "priority", "cursor"}, fields)
+	}
+}
@@ -0,0 +1,404 @@
+package household
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/household"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+)
+
+//Service is the interface that defines the contract for a household service.
+type Service interface {
+	Get(context.Context, *userDomain.User) (*household.Household, fcerr.FCErr)
+	Rename(context.Context, *userDomain.User, string) fcerr.FCErr
+	Invite(context.Context, *userDomain.User, string, string) (*household.Invite, fcerr.FCErr)
+	Invites(context.Context, *userDomain.User) (*household.Invites, fcerr.FCErr)
+	AcceptInvite(context.Context, *userDomain.User, int) (*household.Member, fcerr.FCErr)
+	DeclineInvite(context.Context, *userDomain.User, int) fcerr.FCErr
+	SetRole(context.Context, *userDomain.User, int, string) fcerr.FCErr
+	RemoveMember(context.Context, *userDomain.User, int) fcerr.FCErr
+}
+
+//inviteTTL is how long an invite can be accepted for.
+const inviteTTL = 7 * 24 * time.Hour
+
+type service struct {
+	repository db.Repository
+}
+
+//NewService takes a database repository and gives you a new Service instance.
+func NewService(repo db.Repository) Service {
+	return &service{
+		repository: repo,
+	}
+}
+
+//Membership gets the user's place in their household. A user who isn't in one yet gets a household of their own, with
+//them as its owner, so the dish and storage services always have a household to work in.
+func Membership(ctx context.Context, repo db.Repository, u *userDomain.User) (*household.Member, fcerr.FCErr) {
+	member, err := repo.GetMembership(ctx, u.UserID)
+	if err == nil {
+		return member, nil
+	}
+	if !errors.Is(err, fcerr.ErrNotFound) {
+		return nil, fcerr.Wrap(err, "Could not find the user's household", err.Status())
+	}
+
+	fmt.Println("Making a household for user", u.UserID)
+	txErr := repo.WithTx(ctx, func(tx db.Repository) fcerr.FCErr {
+		now := dishDomain.CanonicalTime(time.Now())
+		created, err := tx.CreateHousehold(ctx, household.Household{CreatedDate: now})
+		if err != nil {
+			return err
+		}
+		return tx.CreateMembership(ctx, household.Member{HouseholdID: created.HouseholdID, UserID: u.UserID,
+			Role: household.RoleOwner, JoinedDate: now})
+	})
+
+	//If another request made the user's household first, theirs is the one to use
+	member, err = repo.GetMembership(ctx, u.UserID)
+	if err != nil {
+		if txErr != nil {
+			err = txErr
+		}
+		return nil, fcerr.Wrap(err, "Could not make a household for the user", http.StatusInternalServerError)
+	}
+	return member, nil
+}
+
+//Get(requestingUser *userDomain.User) gets the user's household with everyone in it. Owners also see the invites that
+//are waiting to be accepted.
+func (s *service) Get(ctx context.Context, requestingUser *userDomain.User) (*household.Household, fcerr.FCErr) {
+	member, err := Membership(ctx, s.repository, requestingUser)
+	if err != nil {
+		return nil, err
+	}
+	resultHousehold, err := s.repository.GetHousehold(ctx, member.HouseholdID)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the household", err.Status())
+	}
+	members, err := s.repository.GetHouseholdMembers(ctx, member.HouseholdID)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the household members", err.Status())
+	}
+	resultHousehold.Members = *members
+
+	if member.IsOwner() {
+		invites, err := s.repository.GetHouseholdInvites(ctx, member.HouseholdID)
+		if err != nil {
+			return nil, fcerr.Wrap(err, "Could not get the household invites", err.Status())
+		}
+		now := time.Now()
+		for _, i := range *invites {
+			if !i.IsExpired(now) {
+				resultHousehold.Invites = append(resultHousehold.Invites, i)
+			}
+		}
+	}
+	return resultHousehold, nil
+}
+
+//Rename(requestingUser *userDomain.User, name string) names the user's household. Only an owner can.
+func (s *service) Rename(ctx context.Context, requestingUser *userDomain.User, name string) fcerr.FCErr {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fcerr.NewValidationError("The household needs a name", fcerr.FieldDetail{Field: "name", Message: "required"})
+	}
+	member, err := s.owner(ctx, requestingUser)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("We are doing the household service Rename() for household", member.HouseholdID)
+	err = s.repository.UpdateHousehold(ctx, household.Household{HouseholdID: member.HouseholdID, Name: name})
+	if err != nil {
+		return fcerr.Wrap(err, "Household Service could not do the Rename()", err.Status())
+	}
+	return nil
+}
+
+//Invite(requestingUser *userDomain.User, email string, role string) invites whoever signs in with this email into the
+//requesting user's household, as a member unless role says otherwise. Only an owner can invite people. Nobody is moved
+//until they accept, and the answer is the same whether or not anyone has signed up with the email yet. Inviting the
+//same email again replaces the earlier invite.
+func (s *service) Invite(ctx context.Context, requestingUser *userDomain.User, email string, role string) (*household.Invite, fcerr.FCErr) {
+	if role == "" {
+		role = household.RoleMember
+	}
+	if !household.ValidRole(role) {
+		return nil, roleError(role)
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil, fcerr.NewValidationError("The email of the person to invite is required",
+			fcerr.FieldDetail{Field: "email", Message: "required"})
+	}
+
+	owner, err := s.owner(ctx, requestingUser)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.repository.GetHouseholdMembers(ctx, owner.HouseholdID)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the household members", err.Status())
+	}
+	for _, m := range *members {
+		if strings.EqualFold(m.Email, email) {
+			return nil, fcerr.NewConflictError(email + " is already in this household")
+		}
+	}
+
+	fmt.Println("We are doing the household service Invite() for household", owner.HouseholdID)
+	var created *household.Invite
+	err = s.repository.WithTx(ctx, func(tx db.Repository) fcerr.FCErr {
+		invites, err := tx.GetHouseholdInvites(ctx, owner.HouseholdID)
+		if err != nil {
+			return err
+		}
+		for _, i := range *invites {
+			if i.Email == email {
+				if err := tx.DeleteInvite(ctx, i.InviteID); err != nil {
+					return err
+				}
+			}
+		}
+		now := dishDomain.CanonicalTime(time.Now())
+		created, err = tx.CreateInvite(ctx, household.Invite{HouseholdID: owner.HouseholdID, Email: email, Role: role,
+			InvitedBy: requestingUser.UserID, CreatedDate: now, ExpireDate: now.Add(inviteTTL)})
+		return err
+	})
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Household Service could not do the Invite()", err.Status())
+	}
+	return created, nil
+}
+
+//Invites(requestingUser *userDomain.User) gives the invites waiting for the user to accept or decline, oldest first.
+//Invites that have expired, or whose household is gone, are cleared out on the way.
+func (s *service) Invites(ctx context.Context, requestingUser *userDomain.User) (*household.Invites, fcerr.FCErr) {
+	invites, err := s.repository.GetInvitesByEmail(ctx, strings.ToLower(requestingUser.Email))
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the user's invites", err.Status())
+	}
+
+	now := time.Now()
+	waiting := household.Invites{}
+	for _, i := range *invites {
+		invitingHousehold, err := s.repository.GetHousehold(ctx, i.HouseholdID)
+		if err != nil && !errors.Is(err, fcerr.ErrNotFound) {
+			return nil, fcerr.Wrap(err, "Could not get the household of an invite", err.Status())
+		}
+		if err != nil || i.IsExpired(now) {
+			fmt.Println("Clearing out household invite", i.InviteID)
+			s.repository.DeleteInvite(ctx, i.InviteID)
+			continue
+		}
+		i.HouseholdName = invitingHousehold.Name
+		if from, err := s.repository.GetUserByID(ctx, i.InvitedBy); err == nil {
+			i.From = from.Email
+		}
+		waiting = append(waiting, i)
+	}
+	return &waiting, nil
+}
+
+//AcceptInvite(requestingUser *userDomain.User, inviteID int) moves the user into the household that invited them. Someone
+//already sharing a household, or with food of their own, has to leave or empty theirs first - their household is only
+//given up when there is nothing in it to lose.
+func (s *service) AcceptInvite(ctx context.Context, requestingUser *userDomain.User, inviteID int) (*household.Member, fcerr.FCErr) {
+	invite, err := s.invite(ctx, requestingUser, inviteID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := Membership(ctx, s.repository, requestingUser)
+	if err != nil {
+		return nil, err
+	}
+
+	if current.HouseholdID != invite.HouseholdID {
+		fmt.Println("We are doing the household service AcceptInvite() for household", invite.HouseholdID, "with user",
+			requestingUser.UserID)
+		err = s.repository.WithTx(ctx, func(tx db.Repository) fcerr.FCErr {
+			if _, err := tx.GetHousehold(ctx, invite.HouseholdID); err != nil {
+				return err
+			}
+			if err := leaveEmptyHousehold(ctx, tx, current); err != nil {
+				return err
+			}
+			return tx.CreateMembership(ctx, household.Member{HouseholdID: invite.HouseholdID, UserID: requestingUser.UserID,
+				Role: invite.Role, JoinedDate: dishDomain.CanonicalTime(time.Now())})
+		})
+		if err != nil {
+			return nil, fcerr.Wrap(err, "Household Service could not do the AcceptInvite()", err.Status())
+		}
+	}
+	if err := s.repository.DeleteInvite(ctx, invite.InviteID); err != nil {
+		return nil, fcerr.Wrap(err, "Could not clear out the accepted invite", err.Status())
+	}
+
+	joined, err := s.repository.GetMembership(ctx, requestingUser.UserID)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the membership that was accepted", err.Status())
+	}
+	return joined, nil
+}
+
+//DeclineInvite(requestingUser *userDomain.User, inviteID int) turns the invite down, deleting it.
+func (s *service) DeclineInvite(ctx context.Context, requestingUser *userDomain.User, inviteID int) fcerr.FCErr {
+	invite, err := s.invite(ctx, requestingUser, inviteID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("We are doing the household service DeclineInvite() for invite", invite.InviteID)
+	if err := s.repository.DeleteInvite(ctx, invite.InviteID); err != nil {
+		return fcerr.Wrap(err, "Household Service could not do the DeclineInvite()", err.Status())
+	}
+	return nil
+}
+
+//SetRole(requestingUser *userDomain.User, userID int, role string) changes what a member of the household can do.
+//Only an owner can, and the last owner can't be made a member.
+func (s *service) SetRole(ctx context.Context, requestingUser *userDomain.User, userID int, role string) fcerr.FCErr {
+	if !household.ValidRole(role) {
+		return roleError(role)
+	}
+	owner, err := s.owner(ctx, requestingUser)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.WithTx(ctx, func(tx db.Repository) fcerr.FCErr {
+		target, members, err := householdMember(ctx, tx, owner.HouseholdID, userID)
+		if err != nil {
+			return err
+		}
+		if target.IsOwner() && role != household.RoleOwner && members.Owners() == 1 {
+			return fcerr.NewBadRequestError("The household needs an owner - make someone else an owner first")
+		}
+
+		fmt.Println("We are doing the household service SetRole() for user", userID, "with role", role)
+		target.Role = role
+		if err := tx.UpdateMembership(ctx, *target); err != nil {
+			return fcerr.Wrap(err, "Household Service could not do the SetRole()", err.Status())
+		}
+		return nil
+	})
+}
+
+//RemoveMember(requestingUser *userDomain.User, userID int) takes someone out of the household. Owners can remove anyone
+//and everyone can remove themselves, except the last owner while others remain. Whoever is removed gets a household of
+//their own the next time they need one; what they added stays with the household.
+func (s *service) RemoveMember(ctx context.Context, requestingUser *userDomain.User, userID int) fcerr.FCErr {
+	member, err := Membership(ctx, s.repository, requestingUser)
+	if err != nil {
+		return err
+	}
+	if userID != requestingUser.UserID && !member.IsOwner() {
+		return fcerr.NewForbiddenError("Only an owner of the household can remove other people from it")
+	}
+
+	return s.repository.WithTx(ctx, func(tx db.Repository) fcerr.FCErr {
+		target, members, err := householdMember(ctx, tx, member.HouseholdID, userID)
+		if err != nil {
+			return err
+		}
+		if len(*members) == 1 {
+			return fcerr.NewBadRequestError("The only member of a household can't leave it")
+		}
+		if target.IsOwner() && members.Owners() == 1 {
+			return fcerr.NewBadRequestError("The household needs an owner - make someone else an owner first")
+		}
+
+		fmt.Println("We are doing the household service RemoveMember() for user", userID)
+		if err := tx.DeleteMembership(ctx, member.HouseholdID, userID); err != nil {
+			return fcerr.Wrap(err, "Household Service could not do the RemoveMember()", err.Status())
+		}
+		return nil
+	})
+}
+
+//owner gets the requesting user's membership, or a 403 if they aren't an owner of their household.
+func (s *service) owner(ctx context.Context, requestingUser *userDomain.User) (*household.Member, fcerr.FCErr) {
+	member, err := Membership(ctx, s.repository, requestingUser)
+	if err != nil {
+		return nil, err
+	}
+	if !member.IsOwner() {
+		return nil, fcerr.NewForbiddenError("Only an owner of the household can do this")
+	}
+	return member, nil
+}
+
+//invite gets an invite sent to the requesting user's email that can still be accepted, or gives a 404 - an invite for
+//anyone else looks the same as one that doesn't exist.
+func (s *service) invite(ctx context.Context, requestingUser *userDomain.User, inviteID int) (*household.Invite, fcerr.FCErr) {
+	invite, err := s.repository.GetInvite(ctx, inviteID)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not find this invite", err.Status())
+	}
+	if !strings.EqualFold(invite.Email, requestingUser.Email) {
+		return nil, fcerr.NewNotFoundError("Could not find this invite")
+	}
+	if invite.IsExpired(time.Now()) {
+		s.repository.DeleteInvite(ctx, invite.InviteID)
+		return nil, fcerr.NewNotFoundError("This invite has expired - ask for a new one")
+	}
+	return invite, nil
+}
+
+//householdMember finds the user among the household's members, or gives a 404, along with all of the members.
+func householdMember(ctx context.Context, repo db.Repository, householdID int, userID int) (*household.Member, *household.Members, fcerr.FCErr) {
+	members, err := repo.GetHouseholdMembers(ctx, householdID)
+	if err != nil {
+		return nil, nil, fcerr.Wrap(err, "Could not get the household members", err.Status())
+	}
+	for i, m := range *members {
+		if m.UserID == userID {
+			return &(*members)[i], members, nil
+		}
+	}
+	return nil, nil, fcerr.NewNotFoundError("Could not find this member in the household")
+}
+
+//leaveEmptyHousehold takes the member out of their household and deletes it, as long as nobody else is in it and it
+//has no storage units or dishes. Otherwise it gives the member a 409 saying what is in the way.
+func leaveEmptyHousehold(ctx context.Context, tx db.Repository, member *household.Member) fcerr.FCErr {
+	members, err := tx.GetHouseholdMembers(ctx, member.HouseholdID)
+	if err != nil {
+		return err
+	}
+	if len(*members) > 1 {
+		return fcerr.NewConflictError("You already share a household with other people - leave it first")
+	}
+	dishCount, err := tx.GetPersonalDishCount(ctx, member.HouseholdID)
+	if err != nil {
+		return err
+	}
+	storageCount, err := tx.GetPersonalStorageCount(ctx, member.HouseholdID)
+	if err != nil {
+		return err
+	}
+	if dishCount > 0 || storageCount > 0 {
+		return fcerr.NewConflictError("You have storage units or dishes of your own - remove them first")
+	}
+
+	if err := tx.DeleteMembership(ctx, member.HouseholdID, member.UserID); err != nil {
+		return err
+	}
+	return tx.DeleteHousehold(ctx, member.HouseholdID)
+}
+
+//roleError points at the role field of the request.
+func roleError(role string) fcerr.FCErr {
+	return fcerr.NewValidationError("A role must be "+household.RoleOwner+" or "+household.RoleMember,
+		fcerr.FieldDetail{Field: "role", Message: "not a role: " + role})
+}
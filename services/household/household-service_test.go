@@ -0,0 +1,204 @@
+package household
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//newTestService gives a household service over a memory repository holding three users.
+func newTestService(t *testing.T) (Service, db.Repository, []*userDomain.User) {
+	repo := db.NewMemoryRepository()
+	var users []*userDomain.User
+	for _, email := range []string{"nothing@gmail.com", "session@gmail.com", "third@gmail.com"} {
+		u, err := repo.CreateUser(context.Background(), userDomain.User{Email: email})
+		if err != nil {
+			t.Fatal(err.Message())
+		}
+		users = append(users, u)
+	}
+	return NewService(repo), repo, users
+}
+
+func TestMembership_MakesAHouseholdOnce(t *testing.T) {
+	_, repo, users := newTestService(t)
+
+	first, err := Membership(context.Background(), repo, users[0])
+	assert.Nil(t, err)
+	assert.True(t, first.IsOwner())
+	assert.Equal(t, users[0].Email, first.Email)
+
+	again, err := Membership(context.Background(), repo, users[0])
+	assert.Nil(t, err)
+	assert.Equal(t, first, again)
+
+	other, _ := Membership(context.Background(), repo, users[1])
+	assert.NotEqual(t, first.HouseholdID, other.HouseholdID)
+}
+
+func TestHouseholdService_InviteAndAccept(t *testing.T) {
+	s, _, users := newTestService(t)
+	ctx := context.Background()
+	bob, sam := users[0], users[1]
+
+	invite, err := s.Invite(ctx, bob, " Session@Gmail.com ", "")
+	assert.Nil(t, err)
+	assert.Equal(t, sam.Email, invite.Email)
+	assert.Equal(t, household.RoleMember, invite.Role)
+	assert.True(t, invite.ExpireDate.After(invite.CreatedDate))
+
+	home, _ := s.Get(ctx, bob)
+	assert.Equal(t, 1, len(home.Members), "nobody is moved before they accept")
+	assert.Equal(t, 1, len(home.Invites))
+
+	waiting, err := s.Invites(ctx, sam)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(*waiting)) {
+		assert.Equal(t, invite.InviteID, (*waiting)[0].InviteID)
+		assert.Equal(t, bob.Email, (*waiting)[0].From)
+	}
+	others, _ := s.Invites(ctx, users[2])
+	assert.Empty(t, *others)
+	_, err = s.AcceptInvite(ctx, users[2], invite.InviteID)
+	assert.Equal(t, http.StatusNotFound, err.Status(), "only the invited user can accept")
+
+	joined, err := s.AcceptInvite(ctx, sam, invite.InviteID)
+	assert.Nil(t, err)
+	assert.Equal(t, household.RoleMember, joined.Role)
+	assert.Equal(t, home.HouseholdID, joined.HouseholdID)
+
+	home, err = s.Get(ctx, sam)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(home.Members)) {
+		assert.Equal(t, bob.UserID, home.Members[0].UserID)
+		assert.Equal(t, sam.UserID, home.Members[1].UserID)
+	}
+	assert.Empty(t, home.Invites, "members don't see the invites")
+	_, err = s.Invite(ctx, sam, users[2].Email, "")
+	assert.Equal(t, http.StatusForbidden, err.Status(), "only an owner invites people")
+	waiting, _ = s.Invites(ctx, sam)
+	assert.Empty(t, *waiting)
+	_, err = s.AcceptInvite(ctx, sam, invite.InviteID)
+	assert.Equal(t, http.StatusNotFound, err.Status(), "an invite is only accepted once")
+}
+
+func TestHouseholdService_Invite_SameForUnknownEmails(t *testing.T) {
+	s, _, users := newTestService(t)
+	ctx := context.Background()
+	bob, sam := users[0], users[1]
+
+	known, err := s.Invite(ctx, bob, sam.Email, "")
+	assert.Nil(t, err)
+	unknown, err := s.Invite(ctx, bob, "nobody@example.com", "")
+	assert.Nil(t, err)
+	assert.Equal(t, known.Role, unknown.Role)
+
+	//an invite waits for whoever signs up with the email
+	again, err := s.Invite(ctx, bob, sam.Email, household.RoleOwner)
+	assert.Nil(t, err)
+	home, _ := s.Get(ctx, bob)
+	if assert.Equal(t, 2, len(home.Invites), "inviting again replaces the earlier invite") {
+		assert.Equal(t, again.InviteID, home.Invites[1].InviteID)
+		assert.Equal(t, household.RoleOwner, home.Invites[1].Role)
+	}
+
+	_, err = s.Invite(ctx, bob, bob.Email, "")
+	assert.Equal(t, http.StatusConflict, err.Status())
+	_, err = s.Invite(ctx, bob, users[2].Email, "chef")
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+	_, err = s.Invite(ctx, bob, " ", "")
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+}
+
+func TestHouseholdService_DeclineInvite(t *testing.T) {
+	s, repo, users := newTestService(t)
+	ctx := context.Background()
+	bob, sam := users[0], users[1]
+
+	invite, _ := s.Invite(ctx, bob, sam.Email, "")
+	assert.Equal(t, http.StatusNotFound, s.DeclineInvite(ctx, users[2], invite.InviteID).Status())
+	assert.Nil(t, s.DeclineInvite(ctx, sam, invite.InviteID))
+	_, err := s.AcceptInvite(ctx, sam, invite.InviteID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	home, _ := s.Get(ctx, bob)
+	assert.Equal(t, 1, len(home.Members))
+	assert.Empty(t, home.Invites)
+
+	//an expired invite can't be accepted, and is cleared out
+	owner, _ := Membership(ctx, repo, bob)
+	now := time.Now()
+	expired, _ := repo.CreateInvite(ctx, household.Invite{HouseholdID: owner.HouseholdID, Email: sam.Email,
+		Role: household.RoleMember, InvitedBy: bob.UserID, CreatedDate: now.Add(-inviteTTL), ExpireDate: now.Add(-time.Minute)})
+	waiting, _ := s.Invites(ctx, sam)
+	assert.Empty(t, *waiting)
+	_, err = s.AcceptInvite(ctx, sam, expired.InviteID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+}
+
+func TestHouseholdService_AcceptInvite_NotWithTheirOwnFood(t *testing.T) {
+	s, repo, users := newTestService(t)
+	ctx := context.Background()
+	bob, sam := users[0], users[1]
+
+	theirs, _ := Membership(ctx, repo, sam)
+	repo.CreateStorage(ctx, storage.Storage{PersonalID: 1, UserID: sam.UserID, HouseholdID: theirs.HouseholdID, Title: "Fridge"})
+
+	invite, err := s.Invite(ctx, bob, sam.Email, "")
+	assert.Nil(t, err, "the owner doesn't learn what the invited user has")
+	_, err = s.AcceptInvite(ctx, sam, invite.InviteID)
+	assert.Equal(t, http.StatusConflict, err.Status())
+	still, _ := repo.GetMembership(ctx, sam.UserID)
+	assert.Equal(t, theirs.HouseholdID, still.HouseholdID)
+	waiting, _ := s.Invites(ctx, sam)
+	assert.Equal(t, 1, len(*waiting), "the invite waits until they have emptied their household")
+}
+
+//join invites the user into the owner's household and accepts for them.
+func join(t *testing.T, s Service, owner *userDomain.User, u *userDomain.User) {
+	invite, err := s.Invite(context.Background(), owner, u.Email, household.RoleMember)
+	if err != nil {
+		t.Fatal(err.Message())
+	}
+	if _, err := s.AcceptInvite(context.Background(), u, invite.InviteID); err != nil {
+		t.Fatal(err.Message())
+	}
+}
+
+func TestHouseholdService_SetRoleAndRemove(t *testing.T) {
+	s, _, users := newTestService(t)
+	ctx := context.Background()
+	bob, sam, third := users[0], users[1], users[2]
+	join(t, s, bob, sam)
+	join(t, s, bob, third)
+
+	err := s.SetRole(ctx, bob, bob.UserID, household.RoleMember)
+	assert.Equal(t, http.StatusBadRequest, err.Status(), "the last owner stays an owner")
+	err = s.RemoveMember(ctx, bob, bob.UserID)
+	assert.Equal(t, http.StatusBadRequest, err.Status(), "the last owner can't leave")
+	err = s.RemoveMember(ctx, sam, third.UserID)
+	assert.Equal(t, http.StatusForbidden, err.Status())
+	assert.Equal(t, http.StatusForbidden, s.Rename(ctx, sam, "Sam's").Status())
+
+	assert.Nil(t, s.SetRole(ctx, bob, sam.UserID, household.RoleOwner))
+	assert.Nil(t, s.Rename(ctx, sam, "The Smiths"))
+	assert.Nil(t, s.RemoveMember(ctx, sam, third.UserID))
+	assert.Nil(t, s.RemoveMember(ctx, bob, bob.UserID), "a second owner lets the first leave")
+
+	home, _ := s.Get(ctx, sam)
+	assert.Equal(t, "The Smiths", home.Name)
+	assert.Equal(t, 1, len(home.Members))
+	assert.Equal(t, http.StatusBadRequest, s.RemoveMember(ctx, sam, sam.UserID).Status())
+
+	//whoever left starts again in a household of their own
+	own, _ := s.Get(ctx, bob)
+	assert.NotEqual(t, home.HouseholdID, own.HouseholdID)
+	assert.Equal(t, http.StatusNotFound, s.SetRole(ctx, bob, sam.UserID, household.RoleMember).Status())
+}
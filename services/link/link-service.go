@@ -0,0 +1,316 @@
+package link
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/identity"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/session"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	sessionService "github.com/jasonradcliffe/freshness-countdown-api/services/session"
+)
+
+//Service is the interface that defines the contract for a link service - Alexa account linking, where this API is the
+//OAuth authorization server the Alexa skill gets its tokens from.
+type Service interface {
+	CheckClient(clientID string, redirectURI string) fcerr.FCErr
+	IssueCode(u *userDomain.User, clientID string, redirectURI string) string
+	Exchange(ctx context.Context, clientID string, clientSecret string, code string, redirectURI string) (*Tokens, fcerr.FCErr)
+	Refresh(ctx context.Context, clientID string, clientSecret string, refreshToken string) (*Tokens, fcerr.FCErr)
+	Bind(ctx context.Context, u *userDomain.User, accessToken string, externalID string) fcerr.FCErr
+	Get(ctx context.Context, externalID string) (*identity.Identity, fcerr.FCErr)
+	List(ctx context.Context, u *userDomain.User) (*identity.Identities, fcerr.FCErr)
+	Unlink(ctx context.Context, u *userDomain.User, linkID int) fcerr.FCErr
+}
+
+//Client is the Alexa skill as its account linking settings describe it: the client id and secret it authenticates to
+//the token endpoint with, and the redirect URLs Amazon lists for it.
+type Client struct {
+	ID           string   `json:"clientid"`
+	Secret       string   `json:"clientsecret"`
+	RedirectURIs []string `json:"redirectURIs"`
+}
+
+//Tokens is what the token endpoint gives the skill. The access token is a session token, so it is used like any other.
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+//codeTTL is how long the skill has to exchange an authorization code.
+const codeTTL = 5 * time.Minute
+
+//pendingCode is an authorization code that hasn't been exchanged yet.
+type pendingCode struct {
+	userID      int
+	clientID    string
+	redirectURI string
+	expires     time.Time
+}
+
+type service struct {
+	repository db.Repository
+	sessions   sessionService.Service
+	client     Client
+
+	mu    sync.Mutex
+	codes map[string]pendingCode
+	now   func() time.Time
+}
+
+//NewService takes a database repository, the session service that mints the skill's access tokens and the skill's
+//client settings, and gives you a new Service instance. Without a client id, account linking is turned off.
+func NewService(repo db.Repository, sessions sessionService.Service, client Client) Service {
+	return &service{
+		repository: repo,
+		sessions:   sessions,
+		client:     client,
+		codes:      make(map[string]pendingCode),
+		now:        time.Now,
+	}
+}
+
+//CheckClient makes sure an authorization request comes from the skill and asks to go back to one of its redirect URLs.
+//Anything else is a 400, and the user should not be sent on to the redirect URL.
+func (s *service) CheckClient(clientID string, redirectURI string) fcerr.FCErr {
+	if s.client.ID == "" {
+		return fcerr.NewNotFoundError("Alexa account linking is not set up")
+	}
+	if clientID != s.client.ID {
+		return fcerr.NewBadRequestError("This client can't link accounts")
+	}
+	for _, allowed := range s.client.RedirectURIs {
+		if redirectURI == allowed {
+			return nil
+		}
+	}
+	return fcerr.NewBadRequestError("This redirect_uri is not one of the skill's")
+}
+
+//IssueCode gives a one-time authorization code for the signed in user, for the skill to exchange for tokens.
+func (s *service) IssueCode(u *userDomain.User, clientID string, redirectURI string) string {
+	n := make([]byte, 32)
+	rand.Read(n)
+	code := base64.RawURLEncoding.EncodeToString(n)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for c, pending := range s.codes {
+		if !now.Before(pending.expires) {
+			delete(s.codes, c)
+		}
+	}
+	s.codes[code] = pendingCode{userID: u.UserID, clientID: clientID, redirectURI: redirectURI, expires: now.Add(codeTTL)}
+	return code
+}
+
+//Exchange trades an authorization code for the skill's first tokens and records the link. The code can only be used once.
+//A client that isn't the skill is a 401, and a code that is unknown, expired or for another redirect URL is a 400.
+func (s *service) Exchange(ctx context.Context, clientID string, clientSecret string, code string, redirectURI string) (*Tokens, fcerr.FCErr) {
+	if fcErr := s.authenticate(clientID, clientSecret); fcErr != nil {
+		return nil, fcErr
+	}
+
+	s.mu.Lock()
+	pending, ok := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+	if !ok || !s.now().Before(pending.expires) || pending.clientID != clientID || pending.redirectURI != redirectURI {
+		return nil, fcerr.NewBadRequestError("The authorization code is not valid")
+	}
+
+	u, fcErr := s.repository.GetUserByID(ctx, pending.userID)
+	if fcErr != nil && fcErr.Status() == http.StatusNotFound {
+		return nil, fcerr.Wrap(fcErr, "The authorization code is for a user that no longer exists", http.StatusBadRequest)
+	} else if fcErr != nil {
+		return nil, fcErr
+	}
+
+	tokens, accessHash, refreshHash, fcErr := s.newTokens(ctx, u)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	_, fcErr = s.repository.CreateIdentity(ctx, identity.Identity{
+		UserID:           u.UserID,
+		Provider:         identity.Alexa,
+		AccessTokenHash:  accessHash,
+		RefreshTokenHash: refreshHash,
+		CreatedDate:      dish.CanonicalTime(s.now()),
+	})
+	if fcErr != nil {
+		return nil, fcerr.Wrap(fcErr, "Could not link the account", fcErr.Status())
+	}
+	return tokens, nil
+}
+
+//Refresh trades a refresh token for new tokens. The old access token is logged out and the old refresh token stops working.
+func (s *service) Refresh(ctx context.Context, clientID string, clientSecret string, refreshToken string) (*Tokens, fcerr.FCErr) {
+	if fcErr := s.authenticate(clientID, clientSecret); fcErr != nil {
+		return nil, fcErr
+	}
+
+	linked, fcErr := s.repository.GetIdentityByRefreshHash(ctx, session.HashToken(refreshToken))
+	if fcErr != nil && fcErr.Status() == http.StatusNotFound {
+		return nil, fcerr.Wrap(fcErr, "The refresh token is not valid, the account may have been unlinked", http.StatusBadRequest)
+	} else if fcErr != nil {
+		return nil, fcErr
+	}
+	u, fcErr := s.repository.GetUserByID(ctx, linked.UserID)
+	if fcErr != nil && fcErr.Status() == http.StatusNotFound {
+		return nil, fcerr.Wrap(fcErr, "The refresh token is for a user that no longer exists", http.StatusBadRequest)
+	} else if fcErr != nil {
+		return nil, fcErr
+	}
+
+	if fcErr := s.revoke(ctx, linked); fcErr != nil {
+		return nil, fcErr
+	}
+	tokens, accessHash, refreshHash, fcErr := s.newTokens(ctx, u)
+	if fcErr != nil {
+		return nil, fcErr
+	}
+	linked.AccessTokenHash, linked.RefreshTokenHash = accessHash, refreshHash
+	if fcErr := s.repository.UpdateIdentity(ctx, *linked); fcErr != nil {
+		return nil, fcerr.Wrap(fcErr, "Could not save the refreshed tokens", fcErr.Status())
+	}
+	return tokens, nil
+}
+
+//Bind records which Alexa account a link is for, the first time the skill calls with the access token it was given and
+//its Alexa user id. An Alexa account can only be linked to one user, so linking it again moves it. A session that didn't
+//come from account linking is a 404 - the Alexa user id it came with is not trusted.
+func (s *service) Bind(ctx context.Context, u *userDomain.User, accessToken string, externalID string) fcerr.FCErr {
+	if externalID == "" {
+		return nil
+	}
+	identities, fcErr := s.repository.GetUserIdentities(ctx, u.UserID)
+	if fcErr != nil {
+		return fcErr
+	}
+	accessHash := session.HashToken(accessToken)
+	var linked *identity.Identity
+	for i := range *identities {
+		if (*identities)[i].Provider == identity.Alexa && (*identities)[i].AccessTokenHash == accessHash {
+			linked = &(*identities)[i]
+		}
+	}
+	if linked == nil {
+		return fcerr.NewNotFoundError("This token did not come from Alexa account linking")
+	}
+	if linked.ExternalID == externalID {
+		return nil
+	}
+	if linked.IsBound() {
+		return fcerr.NewForbiddenError("This token was linked to a different Alexa account")
+	}
+
+	return s.repository.WithTx(ctx, func(tx db.Repository) fcerr.FCErr {
+		previous, fcErr := tx.GetIdentity(ctx, identity.Alexa, externalID)
+		if fcErr == nil {
+			fmt.Println("Alexa account was linked to user", previous.UserID, "and is now linked to user", u.UserID)
+			if fcErr := s.unlink(ctx, tx, previous); fcErr != nil {
+				return fcErr
+			}
+		} else if fcErr.Status() != http.StatusNotFound {
+			return fcErr
+		}
+		linked.ExternalID = externalID
+		return tx.UpdateIdentity(ctx, *linked)
+	})
+}
+
+//Get gives the link for the Alexa account with this user id, or a 404 if it hasn't been linked.
+func (s *service) Get(ctx context.Context, externalID string) (*identity.Identity, fcerr.FCErr) {
+	if externalID == "" {
+		return nil, fcerr.NewNotFoundError("Could not find this user in the system.")
+	}
+	linked, fcErr := s.repository.GetIdentity(ctx, identity.Alexa, externalID)
+	if fcErr != nil && fcErr.Status() == http.StatusNotFound {
+		return nil, fcerr.Wrap(fcErr, "Could not find this user in the system.", http.StatusNotFound)
+	} else if fcErr != nil {
+		return nil, fcerr.Wrap(fcErr, "Error while retrieving the linked account.", http.StatusInternalServerError)
+	}
+	return linked, nil
+}
+
+//List gives every account the user has linked, oldest first.
+func (s *service) List(ctx context.Context, u *userDomain.User) (*identity.Identities, fcerr.FCErr) {
+	identities, fcErr := s.repository.GetUserIdentities(ctx, u.UserID)
+	if fcErr != nil {
+		return nil, fcerr.Wrap(fcErr, "Error while retrieving the linked accounts.", fcErr.Status())
+	}
+	return identities, nil
+}
+
+//Unlink removes one of the user's links and logs out the access token it gave. Its refresh token stops working too,
+//so the skill has to be linked again. A link the user doesn't have is a 404.
+func (s *service) Unlink(ctx context.Context, u *userDomain.User, linkID int) fcerr.FCErr {
+	return s.repository.WithTx(ctx, func(tx db.Repository) fcerr.FCErr {
+		identities, fcErr := tx.GetUserIdentities(ctx, u.UserID)
+		if fcErr != nil {
+			return fcErr
+		}
+		for i := range *identities {
+			if (*identities)[i].LinkID == linkID {
+				return s.unlink(ctx, tx, &(*identities)[i])
+			}
+		}
+		return fcerr.NewNotFoundError("Could not find a linked account with this id")
+	})
+}
+
+//unlink logs out the link's access token and deletes the link.
+func (s *service) unlink(ctx context.Context, repo db.Repository, linked *identity.Identity) fcerr.FCErr {
+	if linked.AccessTokenHash != "" {
+		if fcErr := repo.RevokeSession(ctx, linked.AccessTokenHash, s.now()); fcErr != nil {
+			return fcErr
+		}
+	}
+	return repo.DeleteIdentity(ctx, linked.UserID, linked.LinkID)
+}
+
+//revoke logs out the access token the link was last given.
+func (s *service) revoke(ctx context.Context, linked *identity.Identity) fcerr.FCErr {
+	if linked.AccessTokenHash == "" {
+		return nil
+	}
+	return s.repository.RevokeSession(ctx, linked.AccessTokenHash, s.now())
+}
+
+//newTokens starts a session for the user and makes a refresh token, giving the tokens and the hashes to store.
+func (s *service) newTokens(ctx context.Context, u *userDomain.User) (*Tokens, string, string, fcerr.FCErr) {
+	accessToken, created, fcErr := s.sessions.Create(ctx, u)
+	if fcErr != nil {
+		return nil, "", "", fcErr
+	}
+	refreshToken, refreshHash, err := identity.NewRefreshToken()
+	if err != nil {
+		return nil, "", "", fcerr.NewInternalServerError("Could not make a refresh token")
+	}
+	tokens := &Tokens{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresAt: created.ExpireDate}
+	return tokens, created.TokenHash, refreshHash, nil
+}
+
+//authenticate checks the client is the skill. Anything else is a 401.
+func (s *service) authenticate(clientID string, clientSecret string) fcerr.FCErr {
+	if s.client.ID == "" {
+		return fcerr.NewNotFoundError("Alexa account linking is not set up")
+	}
+	idMatches := subtle.ConstantTimeCompare([]byte(clientID), []byte(s.client.ID)) == 1
+	secretMatches := subtle.ConstantTimeCompare([]byte(clientSecret), []byte(s.client.Secret)) == 1
+	if !idMatches || !secretMatches {
+		return fcerr.NewUnauthorizedError("The client id or secret is wrong")
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package link
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/identity"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	sessionService "github.com/jasonradcliffe/freshness-countdown-api/services/session"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRedirect = "https://pitangui.amazon.com/api/skill/link/TEST"
+
+//newTestService gives a link service over a memory repository holding two users, with a clock the test can move.
+func newTestService(t *testing.T) (*service, *userDomain.User, *userDomain.User, *time.Time) {
+	repo := db.NewMemoryRepository()
+	bob, err := repo.CreateUser(context.Background(), userDomain.User{Email: "nothing@gmail.com", FirstName: "Bob"})
+	if err != nil {
+		t.Fatal(err.Message())
+	}
+	sam, err := repo.CreateUser(context.Background(), userDomain.User{Email: "session@gmail.com", FirstName: "Sam"})
+	if err != nil {
+		t.Fatal(err.Message())
+	}
+
+	now := time.Now()
+	s := NewService(repo, sessionService.NewService(repo, time.Hour),
+		Client{ID: "alexa-client", Secret: "alexa-secret", RedirectURIs: []string{testRedirect}}).(*service)
+	s.now = func() time.Time { return now }
+	return s, bob, sam, &now
+}
+
+//link goes through the code exchange for u and gives back the skill's tokens.
+func link(t *testing.T, s *service, u *userDomain.User) *Tokens {
+	code := s.IssueCode(u, "alexa-client", testRedirect)
+	tokens, err := s.Exchange(context.Background(), "alexa-client", "alexa-secret", code, testRedirect)
+	if err != nil {
+		t.Fatal(err.Message())
+	}
+	return tokens
+}
+
+func TestLinkService_CheckClient(t *testing.T) {
+	s, _, _, _ := newTestService(t)
+
+	assert.Nil(t, s.CheckClient("alexa-client", testRedirect))
+	assert.Equal(t, http.StatusBadRequest, s.CheckClient("someone-else", testRedirect).Status())
+	assert.Equal(t, http.StatusBadRequest, s.CheckClient("alexa-client", "https://evil.example.com/").Status())
+
+	off := NewService(db.NewMemoryRepository(), nil, Client{})
+	assert.Equal(t, http.StatusNotFound, off.CheckClient("", "").Status())
+}
+
+func TestLinkService_Exchange_ExpiredCode(t *testing.T) {
+	s, bob, _, now := newTestService(t)
+
+	code := s.IssueCode(bob, "alexa-client", testRedirect)
+	*now = now.Add(codeTTL)
+	_, err := s.Exchange(context.Background(), "alexa-client", "alexa-secret", code, testRedirect)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+
+	code = s.IssueCode(bob, "alexa-client", testRedirect)
+	_, err = s.Exchange(context.Background(), "alexa-client", "wrong-secret", code, testRedirect)
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+}
+
+func TestLinkService_Bind(t *testing.T) {
+	s, bob, sam, _ := newTestService(t)
+	ctx := context.Background()
+
+	bobTokens := link(t, s, bob)
+	assert.Equal(t, http.StatusNotFound, s.Bind(ctx, bob, "fcs_not-from-linking", "amzn1.account.shared").Status())
+	assert.Nil(t, s.Bind(ctx, bob, bobTokens.AccessToken, "amzn1.account.shared"))
+	assert.Nil(t, s.Bind(ctx, bob, bobTokens.AccessToken, "amzn1.account.shared"))
+	assert.Equal(t, http.StatusForbidden, s.Bind(ctx, bob, bobTokens.AccessToken, "amzn1.account.other").Status())
+
+	linked, err := s.Get(ctx, "amzn1.account.shared")
+	assert.Nil(t, err)
+	assert.Equal(t, bob.UserID, linked.UserID)
+
+	//linking the same Alexa account for someone else moves it, and Bob's skill token is logged out
+	samTokens := link(t, s, sam)
+	assert.Nil(t, s.Bind(ctx, sam, samTokens.AccessToken, "amzn1.account.shared"))
+	linked, _ = s.Get(ctx, "amzn1.account.shared")
+	assert.Equal(t, sam.UserID, linked.UserID)
+	_, err = s.sessions.Validate(ctx, bobTokens.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+	identities, _ := s.List(ctx, bob)
+	assert.Equal(t, identity.Identities{}, *identities)
+}
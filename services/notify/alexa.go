@@ -0,0 +1,184 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/identity"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/notification"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+//AlexaConfig is the skill's Login with Amazon client from its permissions page, which it sends proactive events with.
+//Development sends them to the skill's development stage, for trying it out before certification. APIURL and TokenURL
+//are Amazon's unless set.
+type AlexaConfig struct {
+	ClientID     string `json:"clientid"`
+	ClientSecret string `json:"clientsecret"`
+	Development  bool   `json:"development"`
+	APIURL       string `json:"apiURL"`
+	TokenURL     string `json:"tokenURL"`
+}
+
+//Where proactive events go, and the token to send them with comes from.
+const (
+	defaultAlexaAPIURL   = "https://api.amazonalexa.com"
+	defaultAlexaTokenURL = "https://api.amazon.com/auth/o2/token"
+	proactiveEventsScope = "alexa::proactive_events"
+)
+
+//alexaEventTTL is how long Alexa keeps a notification waiting for the user before dropping it.
+const alexaEventTTL = 23 * time.Hour
+
+//alexaEvent is a proactive event - an AMAZON.MessageAlert.Activated, which Alexa announces as new messages from the
+//skill, to the one Alexa user it is for.
+type alexaEvent struct {
+	Timestamp   time.Time `json:"timestamp"`
+	ReferenceID string    `json:"referenceId"`
+	ExpiryTime  time.Time `json:"expiryTime"`
+	Event       struct {
+		Name    string `json:"name"`
+		Payload struct {
+			State struct {
+				Status    string `json:"status"`
+				Freshness string `json:"freshness"`
+			} `json:"state"`
+			MessageGroup struct {
+				Creator struct {
+					Name string `json:"name"`
+				} `json:"creator"`
+				Count   int    `json:"count"`
+				Urgency string `json:"urgency"`
+			} `json:"messageGroup"`
+		} `json:"payload"`
+	} `json:"event"`
+	RelevantAudience struct {
+		Type    string `json:"type"`
+		Payload struct {
+			User string `json:"user"`
+		} `json:"payload"`
+	} `json:"relevantAudience"`
+}
+
+type alexaNotifier struct {
+	repository db.Repository
+	eventsURL  string
+	tokens     oauth2.TokenSource
+	client     *http.Client
+}
+
+//NewAlexaNotifier gives a Notifier that has Alexa tell the user they have messages from the skill, on every Alexa
+//account they linked. A nil client uses http.DefaultClient.
+func NewAlexaNotifier(repo db.Repository, config AlexaConfig, client *http.Client) Notifier {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if config.APIURL == "" {
+		config.APIURL = defaultAlexaAPIURL
+	}
+	if config.TokenURL == "" {
+		config.TokenURL = defaultAlexaTokenURL
+	}
+	eventsURL := strings.TrimSuffix(config.APIURL, "/") + "/v1/proactiveEvents"
+	if config.Development {
+		eventsURL += "/stages/development"
+	}
+
+	credentials := clientcredentials.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		TokenURL:     config.TokenURL,
+		Scopes:       []string{proactiveEventsScope},
+		AuthStyle:    oauth2.AuthStyleInParams,
+	}
+	tokenCtx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	return &alexaNotifier{repository: repo, eventsURL: eventsURL, tokens: credentials.TokenSource(tokenCtx), client: client}
+}
+
+func (a *alexaNotifier) Channel() string {
+	return notification.Alexa
+}
+
+//Notify sends an event to each Alexa account the user linked that has called the skill, so its user id is known.
+func (a *alexaNotifier) Notify(ctx context.Context, m Message) fcerr.FCErr {
+	identities, err := a.repository.GetUserIdentities(ctx, m.User.UserID)
+	if err != nil {
+		return err
+	}
+	var alexaUsers []string
+	for _, linked := range *identities {
+		if linked.Provider == identity.Alexa && linked.ExternalID != "" {
+			alexaUsers = append(alexaUsers, linked.ExternalID)
+		}
+	}
+	if len(alexaUsers) == 0 {
+		return fcerr.NewNotFoundError("This user has not used the Alexa skill")
+	}
+
+	token, tokenErr := a.tokens.Token()
+	if tokenErr != nil {
+		return fcerr.Wrap(tokenErr, "Could not get a token to send Alexa events with", http.StatusBadGateway)
+	}
+	for _, alexaUser := range alexaUsers {
+		if err := a.send(ctx, token, newAlexaEvent(alexaUser, m)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//send posts one event. Alexa answers 202 when it takes it.
+func (a *alexaNotifier) send(ctx context.Context, token *oauth2.Token, event alexaEvent) fcerr.FCErr {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fcerr.Wrap(err, "Could not marshal the Alexa event", http.StatusInternalServerError)
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", a.eventsURL, bytes.NewReader(body))
+	if err != nil {
+		return fcerr.Wrap(err, "Could not make the Alexa event request", http.StatusInternalServerError)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	token.SetAuthHeader(request)
+
+	response, err := a.client.Do(request)
+	if err != nil {
+		return fcerr.Wrap(err, "Could not reach Alexa", http.StatusBadGateway)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fcerr.NewFCErr(fmt.Sprintf("Alexa answered the event with status %d", response.StatusCode), http.StatusBadGateway)
+	}
+	return nil
+}
+
+//newAlexaEvent makes the event telling the Alexa user about the message.
+func newAlexaEvent(alexaUser string, m Message) alexaEvent {
+	n := make([]byte, 18)
+	rand.Read(n)
+
+	var event alexaEvent
+	event.Timestamp = m.Time.UTC().Truncate(time.Second)
+	event.ReferenceID = base64.RawURLEncoding.EncodeToString(n)
+	event.ExpiryTime = event.Timestamp.Add(alexaEventTTL)
+	event.Event.Name = "AMAZON.MessageAlert.Activated"
+	event.Event.Payload.State.Status = "UNREAD"
+	event.Event.Payload.State.Freshness = "NEW"
+	event.Event.Payload.MessageGroup.Creator.Name = "Freshness Countdown"
+	event.Event.Payload.MessageGroup.Count = len(m.Dishes)
+	event.Event.Payload.MessageGroup.Urgency = "URGENT"
+	event.RelevantAudience.Type = "Unicast"
+	event.RelevantAudience.Payload.User = alexaUser
+	return event
+}
@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/notification"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//SMTPConfig is the mail server notification emails are sent through. Without a username, no AUTH is sent.
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+//defaultSMTPPort is the submission port, used when the config doesn't give one.
+const defaultSMTPPort = 587
+
+//headerBreaks keeps titles from starting new header lines in the subject.
+var headerBreaks = strings.NewReplacer("\r", " ", "\n", " ")
+
+type emailNotifier struct {
+	config SMTPConfig
+	send   func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+//NewEmailNotifier gives a Notifier that emails the user at the address they signed in with.
+func NewEmailNotifier(config SMTPConfig) Notifier {
+	if config.Port == 0 {
+		config.Port = defaultSMTPPort
+	}
+	return &emailNotifier{config: config, send: smtp.SendMail}
+}
+
+func (e *emailNotifier) Channel() string {
+	return notification.Email
+}
+
+//Notify emails the message as plain text. smtp.SendMail can't be given a context, so a cancelled scan still finishes
+//the email it is on.
+func (e *emailNotifier) Notify(ctx context.Context, m Message) fcerr.FCErr {
+	if m.User.Email == "" {
+		return fcerr.NewNotFoundError("This user has no email address")
+	}
+	if err := ctx.Err(); err != nil {
+		return fcerr.Wrap(err, "The notification was cancelled", http.StatusGatewayTimeout)
+	}
+
+	var auth smtp.Auth
+	if e.config.Username != "" {
+		auth = smtp.PlainAuth("", e.config.Username, e.config.Password, e.config.Host)
+	}
+	addr := net.JoinHostPort(e.config.Host, strconv.Itoa(e.config.Port))
+	if err := e.send(addr, auth, e.config.From, []string{m.User.Email}, e.compose(m)); err != nil {
+		return fcerr.Wrap(err, "Could not send the notification email", http.StatusBadGateway)
+	}
+	return nil
+}
+
+//compose writes the message as an email.
+func (e *emailNotifier) compose(m Message) []byte {
+	var b bytes.Buffer
+	b.WriteString("From: Freshness Countdown <" + e.config.From + ">\r\n")
+	b.WriteString("To: " + m.User.Email + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", headerBreaks.Replace(m.Subject())) + "\r\n")
+	b.WriteString("Date: " + m.Time.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(m.Text(), "\n", "\r\n"))
+	return b.Bytes()
+}
@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/identity"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/stretchr/testify/assert"
+)
+
+//carrots is a message about one dish, for the notifier tests.
+var carrots = Message{
+	User:   user.User{UserID: 1, Email: "nothing@gmail.com", FirstName: "Bob"},
+	Dishes: dish.Dishes{{DishID: 1, Title: "Carrots\r\nBcc: everyone@example.com", ExpireDate: noon.Add(6 * time.Hour)}},
+	Time:   noon,
+}
+
+func TestEmailNotifier_Notify(t *testing.T) {
+	var addr, from string
+	var to []string
+	var msg []byte
+	var auth smtp.Auth
+	n := NewEmailNotifier(SMTPConfig{Host: "smtp.example.com", Username: "fc", Password: "secret", From: "fc@example.com"}).(*emailNotifier)
+	n.send = func(a string, au smtp.Auth, f string, t []string, m []byte) error {
+		addr, auth, from, to, msg = a, au, f, t, m
+		return nil
+	}
+
+	assert.Nil(t, n.Notify(context.Background(), carrots))
+	assert.Equal(t, "smtp.example.com:587", addr)
+	assert.NotNil(t, auth)
+	assert.Equal(t, "fc@example.com", from)
+	assert.Equal(t, []string{"nothing@gmail.com"}, to)
+
+	headers := strings.SplitN(string(msg), "\r\n\r\n", 2)
+	if assert.Equal(t, 2, len(headers)) {
+		assert.Contains(t, headers[0], "To: nothing@gmail.com\r\n")
+		assert.NotContains(t, headers[0], "\r\nBcc:", "a title can't add headers")
+		assert.Contains(t, headers[1], "Hi Bob,\r\n")
+	}
+
+	n.send = func(string, smtp.Auth, string, []string, []byte) error { return errors.New("connection refused") }
+	err := n.Notify(context.Background(), carrots)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusBadGateway, err.Status())
+	}
+
+	noEmail := carrots
+	noEmail.User.Email = ""
+	err = n.Notify(context.Background(), noEmail)
+	assert.True(t, errors.Is(err, fcerr.ErrNotFound))
+}
+
+func TestWebhookNotifier_Notify(t *testing.T) {
+	status := http.StatusNoContent
+	var got webhookPayload
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	n := NewWebhookNotifier(WebhookConfig{URL: server.URL, Token: "hook-token"}, server.Client())
+
+	assert.Nil(t, n.Notify(context.Background(), carrots))
+	assert.Equal(t, "Bearer hook-token", authorization)
+	assert.Equal(t, ExpiringEvent, got.Event)
+	assert.Equal(t, 1, got.UserID)
+	assert.Equal(t, "nothing@gmail.com", got.Email)
+	if assert.Equal(t, 1, len(got.Dishes)) {
+		assert.Equal(t, 1, got.Dishes[0].DishID)
+	}
+
+	status = http.StatusInternalServerError
+	err := n.Notify(context.Background(), carrots)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusBadGateway, err.Status())
+	}
+}
+
+func TestAlexaNotifier_Notify(t *testing.T) {
+	var events []alexaEvent
+	var grantType, authorization string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/o2/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		grantType = r.PostForm.Get("grant_type")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"alexa-token","token_type":"bearer","expires_in":3600}`))
+	})
+	mux.HandleFunc("/v1/proactiveEvents/stages/development", func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		var event alexaEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	repo := db.NewMemoryRepository()
+	bob, _ := repo.CreateUser(ctx, user.User{Email: "nothing@gmail.com"})
+	n := NewAlexaNotifier(repo, AlexaConfig{ClientID: "client", ClientSecret: "secret", Development: true,
+		APIURL: server.URL, TokenURL: server.URL + "/auth/o2/token"}, server.Client())
+
+	m := carrots
+	m.User = *bob
+	err := n.Notify(ctx, m)
+	assert.True(t, errors.Is(err, fcerr.ErrNotFound), "bob hasn't linked Alexa")
+
+	repo.CreateIdentity(ctx, identity.Identity{UserID: bob.UserID, Provider: identity.Alexa, RefreshTokenHash: "unused"})
+	err = n.Notify(ctx, m)
+	assert.True(t, errors.Is(err, fcerr.ErrNotFound), "nor used the skill yet")
+
+	repo.CreateIdentity(ctx, identity.Identity{UserID: bob.UserID, Provider: identity.Alexa, ExternalID: "amzn1.ask.account.bob",
+		RefreshTokenHash: "also-unused"})
+	assert.Nil(t, n.Notify(ctx, m))
+	assert.Equal(t, "client_credentials", grantType)
+	assert.Equal(t, "Bearer alexa-token", authorization)
+	if assert.Equal(t, 1, len(events)) {
+		assert.Equal(t, "AMAZON.MessageAlert.Activated", events[0].Event.Name)
+		assert.Equal(t, 1, events[0].Event.Payload.MessageGroup.Count)
+		assert.Equal(t, "Unicast", events[0].RelevantAudience.Type)
+		assert.Equal(t, "amzn1.ask.account.bob", events[0].RelevantAudience.Payload.User)
+		assert.Equal(t, noon.Add(alexaEventTTL), events[0].ExpiryTime)
+	}
+}
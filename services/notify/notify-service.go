@@ -0,0 +1,204 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/clock"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/notification"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+)
+
+//Service is the interface that defines the contract for a notify service - the background job that warns users before
+//the food in their household goes bad.
+type Service interface {
+	Scan(ctx context.Context) (int, fcerr.FCErr)
+	Run(ctx context.Context, interval time.Duration)
+}
+
+//Notifier is one way of reaching a user, like email.
+type Notifier interface {
+	//Channel names the notifier in the notification records, like notification.Email.
+	Channel() string
+	//Notify sends the message. A user the notifier has no way of reaching gives a 404, and is skipped.
+	Notify(ctx context.Context, m Message) fcerr.FCErr
+}
+
+//Message is one notification to a user about dishes in their household that are about to expire, or just have.
+type Message struct {
+	User   user.User
+	Dishes dish.Dishes
+	Time   time.Time
+}
+
+//RecentlyExpired is how long after a dish expires the user can still be told about it - if it expired during their
+//quiet hours, say.
+const RecentlyExpired = 24 * time.Hour
+
+type service struct {
+	repository db.Repository
+	clock      clock.Clock
+	notifiers  []Notifier
+}
+
+//NewService takes a database repository, the clock to go by and the notifiers to send through, and gives you a new
+//Service instance.
+func NewService(repo db.Repository, c clock.Clock, notifiers ...Notifier) Service {
+	return &service{
+		repository: repo,
+		clock:      c,
+		notifiers:  notifiers,
+	}
+}
+
+//Run scans every interval until ctx is done.
+func (s *service) Run(ctx context.Context, interval time.Duration) {
+	for {
+		sent, fcErr := s.Scan(ctx)
+		if fcErr != nil {
+			fmt.Println("could not scan for dishes to notify about:", fcErr.Message())
+		} else if sent > 0 {
+			fmt.Println("sent", sent, "expiry notifications")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(interval):
+		}
+	}
+}
+
+//Scan tells every user who asked for it about the dishes in their household that expire within their notice, on every
+//channel that reaches them, unless it's their quiet hours or they were already told. It gives how many dishes it told
+//users about. A notifier that fails is tried again on the next scan.
+func (s *service) Scan(ctx context.Context) (int, fcerr.FCErr) {
+	now := s.clock.Now()
+	users, err := s.repository.GetUsersToNotify(ctx)
+	if errors.Is(err, fcerr.ErrNotFound) {
+		return 0, s.prune(ctx, now)
+	} else if err != nil {
+		return 0, fcerr.Wrap(err, "Error while finding the users to notify.", err.Status())
+	}
+
+	sent := 0
+	for _, u := range *users {
+		if u.InQuietHours(now) {
+			continue
+		}
+		told, err := s.notifyUser(ctx, u, now)
+		if err != nil {
+			fmt.Println("could not notify user", u.UserID, "-", err.Message())
+		}
+		sent += told
+	}
+	return sent, s.prune(ctx, now)
+}
+
+//notifyUser sends the user whatever they haven't been told yet on each channel, and gives how many dishes that was.
+func (s *service) notifyUser(ctx context.Context, u user.User, now time.Time) (int, fcerr.FCErr) {
+	expiring, err := s.expiring(ctx, u, now)
+	if err != nil || len(expiring) == 0 {
+		return 0, err
+	}
+	already, err := s.repository.GetNotifications(ctx, u.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, notifier := range s.notifiers {
+		var unsent dish.Dishes
+		for _, d := range expiring {
+			if !already.Sent(d.DishID, notifier.Channel(), d.ExpireDate) {
+				unsent = append(unsent, d)
+			}
+		}
+		if len(unsent) == 0 {
+			continue
+		}
+
+		err := notifier.Notify(ctx, Message{User: u, Dishes: unsent, Time: now})
+		if errors.Is(err, fcerr.ErrNotFound) {
+			continue
+		} else if err != nil {
+			fmt.Println("could not notify user", u.UserID, "by", notifier.Channel(), "-", err.Message())
+			continue
+		}
+		for _, d := range unsent {
+			err := s.repository.CreateNotification(ctx, notification.Notification{UserID: u.UserID, DishID: d.DishID,
+				Channel: notifier.Channel(), ExpireDate: d.ExpireDate, SentDate: now})
+			if err != nil {
+				return sent, err
+			}
+		}
+		sent += len(unsent)
+	}
+	return sent, nil
+}
+
+//expiring gives the dishes in the user's household that expire within their notice or expired recently, soonest first.
+func (s *service) expiring(ctx context.Context, u user.User, now time.Time) (dish.Dishes, fcerr.FCErr) {
+	member, err := s.repository.GetMembership(ctx, u.UserID)
+	if errors.Is(err, fcerr.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	dishes, err := s.repository.GetDishes(ctx, member.HouseholdID)
+	if errors.Is(err, fcerr.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	notice := now.Add(time.Duration(u.NoticeDays()) * 24 * time.Hour)
+	var expiring dish.Dishes
+	for _, d := range *dishes {
+		if d.ExpireDate.IsZero() || d.ExpireDate.After(notice) || !d.ExpireDate.After(now.Add(-RecentlyExpired)) {
+			continue
+		}
+		expiring = append(expiring, d)
+	}
+	sort.SliceStable(expiring, func(i, j int) bool { return expiring[i].ExpireDate.Before(expiring[j].ExpireDate) })
+	return expiring, nil
+}
+
+//prune clears out the records of notifications about dishes nobody is told about any more.
+func (s *service) prune(ctx context.Context, now time.Time) fcerr.FCErr {
+	if err := s.repository.DeleteNotificationsBefore(ctx, now.Add(-RecentlyExpired)); err != nil {
+		return fcerr.Wrap(err, "Error while clearing out old notifications.", err.Status())
+	}
+	return nil
+}
+
+//Subject is the message in a line: what expires when if it is one dish, or how many there are.
+func (m Message) Subject() string {
+	if len(m.Dishes) == 1 {
+		return m.Dishes[0].Title + " " + m.Dishes[0].ExpiresIn(m.Time)
+	}
+	return fmt.Sprintf("%d dishes are about to expire", len(m.Dishes))
+}
+
+//Text is the whole message as plain text, with each dish's expiry date in the user's time zone.
+func (m Message) Text() string {
+	var b strings.Builder
+	name := m.User.FirstName
+	if name == "" {
+		name = "there"
+	}
+	fmt.Fprintf(&b, "Hi %s,\n\nThis is what is about to expire in your household:\n\n", name)
+	for _, d := range m.Dishes {
+		fmt.Fprintf(&b, "- %s %s (%s)\n", d.Title, d.ExpiresIn(m.Time),
+			d.ExpireDate.In(m.User.Location()).Format("Monday, January 2 at 3:04 PM MST"))
+	}
+	b.WriteString("\nYou can change how early you hear about it, or stop these notifications, in your Freshness Countdown profile.\n")
+	return b.String()
+}
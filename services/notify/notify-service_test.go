@@ -0,0 +1,196 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/clock/clocktest"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/notification"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+	"github.com/stretchr/testify/assert"
+)
+
+//recorder is a Notifier that keeps what it was asked to send, failing with err if it is set.
+type recorder struct {
+	channel string
+	mu      sync.Mutex
+	sent    []Message
+	err     fcerr.FCErr
+}
+
+func (r *recorder) Channel() string {
+	return r.channel
+}
+
+func (r *recorder) Notify(ctx context.Context, m Message) fcerr.FCErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.sent = append(r.sent, m)
+	return nil
+}
+
+//messages is a copy of what the recorder sent so far.
+func (r *recorder) messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Message(nil), r.sent...)
+}
+
+//noon is when the notify tests start, a Monday.
+var noon = time.Date(2022, 3, 7, 12, 0, 0, 0, time.UTC)
+
+//newNotifyTest gives a repository holding a user who asked to be told a day ahead, with carrots expiring in 6 hours,
+//soup in 3 days, and bread that went off 2 days ago.
+func newNotifyTest(t *testing.T) (db.Repository, *user.User, *clocktest.Clock) {
+	ctx := context.Background()
+	repo := db.NewMemoryRepository()
+	bob, fcErr := repo.CreateUser(ctx, user.User{Email: "nothing@gmail.com", FirstName: "Bob", NotifyExpiring: true})
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	if _, fcErr := repo.CreateUser(ctx, user.User{Email: "session@gmail.com"}); fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	home, fcErr := household.Membership(ctx, repo, bob)
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	for i, d := range []dish.Dish{
+		{Title: "Carrots", ExpireDate: noon.Add(6 * time.Hour)},
+		{Title: "Soup", ExpireDate: noon.Add(72 * time.Hour)},
+		{Title: "Bread", ExpireDate: noon.Add(-48 * time.Hour)},
+	} {
+		d.PersonalDishID, d.UserID, d.HouseholdID = i+1, bob.UserID, home.HouseholdID
+		if _, fcErr := repo.CreateDish(ctx, d); fcErr != nil {
+			t.Fatal(fcErr.Message())
+		}
+	}
+	return repo, bob, clocktest.New(noon)
+}
+
+func TestNotify_Scan(t *testing.T) {
+	repo, bob, clock := newNotifyTest(t)
+	email, alexa := &recorder{channel: notification.Email}, &recorder{channel: notification.Alexa}
+	s := NewService(repo, clock, email, alexa)
+
+	sent, err := s.Scan(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, sent, "the carrots, on both channels")
+	if assert.Equal(t, 1, len(email.messages())) {
+		m := email.messages()[0]
+		assert.Equal(t, bob.UserID, m.User.UserID)
+		assert.Equal(t, noon, m.Time)
+		if assert.Equal(t, 1, len(m.Dishes)) {
+			assert.Equal(t, "Carrots", m.Dishes[0].Title)
+		}
+		assert.Equal(t, "Carrots expires today", m.Subject())
+		assert.Contains(t, m.Text(), "Hi Bob")
+		assert.Contains(t, m.Text(), "- Carrots expires today (Monday, March 7 at 6:00 PM UTC)")
+	}
+	assert.Equal(t, 1, len(alexa.messages()))
+
+	sent, _ = s.Scan(context.Background())
+	assert.Equal(t, 0, sent, "nobody is told twice")
+
+	clock.Advance(48 * time.Hour)
+	sent, _ = s.Scan(context.Background())
+	assert.Equal(t, 2, sent, "the soup is a day away now")
+	if assert.Equal(t, 2, len(email.messages())) {
+		assert.Equal(t, "Soup", email.messages()[1].Dishes[0].Title)
+	}
+
+	home, _ := repo.GetMembership(context.Background(), bob.UserID)
+	soup, _ := repo.GetDishByID(context.Background(), home.HouseholdID, 2)
+	soup.ExpireDate = soup.ExpireDate.Add(-6 * time.Hour)
+	repo.UpdateDish(context.Background(), *soup)
+	sent, _ = s.Scan(context.Background())
+	assert.Equal(t, 2, sent, "a dish given a new date is told about again")
+}
+
+func TestNotify_Scan_QuietHours(t *testing.T) {
+	repo, bob, clock := newNotifyTest(t)
+	bob.TimeZone, bob.QuietStart, bob.QuietEnd = "America/Chicago", "05:30", "07:00"
+	repo.UpdateUser(context.Background(), *bob)
+	email := &recorder{channel: notification.Email}
+	s := NewService(repo, clock, email)
+
+	//noon UTC is 6:00 in Chicago
+	sent, err := s.Scan(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, sent)
+
+	clock.Advance(time.Hour)
+	sent, _ = s.Scan(context.Background())
+	assert.Equal(t, 1, sent, "told once the quiet hours are over")
+	if assert.Equal(t, 1, len(email.messages())) {
+		assert.Contains(t, email.messages()[0].Text(), "(Monday, March 7 at 12:00 PM CST)")
+	}
+}
+
+func TestNotify_Scan_Failures(t *testing.T) {
+	repo, _, clock := newNotifyTest(t)
+	broken := &recorder{channel: notification.Webhook, err: fcerr.NewFCErr("The webhook answered with status 500", http.StatusBadGateway)}
+	unreachable := &recorder{channel: notification.Alexa, err: fcerr.NewNotFoundError("This user has not used the Alexa skill")}
+	s := NewService(repo, clock, broken, unreachable)
+
+	sent, err := s.Scan(context.Background())
+	assert.Nil(t, err, "one user's failures don't fail the scan")
+	assert.Equal(t, 0, sent)
+
+	broken.err = nil
+	sent, _ = s.Scan(context.Background())
+	assert.Equal(t, 1, sent, "the failed notification is sent on the next scan")
+}
+
+func TestNotify_Scan_PrunesOldRecords(t *testing.T) {
+	repo, bob, clock := newNotifyTest(t)
+	s := NewService(repo, clock, &recorder{channel: notification.Email})
+
+	s.Scan(context.Background())
+	sent, _ := repo.GetNotifications(context.Background(), bob.UserID)
+	assert.Equal(t, 1, len(*sent))
+
+	clock.Advance(RecentlyExpired + 7*time.Hour)
+	s.Scan(context.Background())
+	sent, _ = repo.GetNotifications(context.Background(), bob.UserID)
+	for _, n := range *sent {
+		assert.NotEqual(t, 1, n.DishID, "the carrots expired over a day ago")
+	}
+}
+
+func TestNotify_Run(t *testing.T) {
+	repo, _, clock := newNotifyTest(t)
+	email := &recorder{channel: notification.Email}
+	s := NewService(repo, clock, email)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx, time.Hour)
+		close(done)
+	}()
+
+	assert.True(t, clock.WaitForSleepers(1, time.Second))
+	assert.Equal(t, 1, len(email.messages()), "it scans straight away")
+
+	clock.Advance(48 * time.Hour)
+	assert.True(t, clock.WaitForSleepers(1, time.Second))
+	assert.Equal(t, 2, len(email.messages()), "and again every interval")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not stop when its context was cancelled")
+	}
+}
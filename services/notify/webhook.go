@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/notification"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//WebhookConfig is the URL every notification is posted to as JSON, like a chat integration's. With a token, it is sent
+//as a bearer Authorization header.
+type WebhookConfig struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+//ExpiringEvent is the event a webhook notification is.
+const ExpiringEvent = "dishes.expiring"
+
+//webhookPayload is the JSON body of a webhook notification.
+type webhookPayload struct {
+	Event   string      `json:"event"`
+	SentAt  time.Time   `json:"sentAt"`
+	UserID  int         `json:"userID"`
+	Email   string      `json:"email"`
+	Subject string      `json:"subject"`
+	Dishes  dish.Dishes `json:"dishes"`
+}
+
+type webhookNotifier struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+//NewWebhookNotifier gives a Notifier that posts to the configured URL. A nil client uses http.DefaultClient.
+func NewWebhookNotifier(config WebhookConfig, client *http.Client) Notifier {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &webhookNotifier{config: config, client: client}
+}
+
+func (w *webhookNotifier) Channel() string {
+	return notification.Webhook
+}
+
+//Notify posts the message. Anything but a 2xx answer is a failure, to be tried again.
+func (w *webhookNotifier) Notify(ctx context.Context, m Message) fcerr.FCErr {
+	body, err := json.Marshal(webhookPayload{Event: ExpiringEvent, SentAt: m.Time.UTC(), UserID: m.User.UserID,
+		Email: m.User.Email, Subject: m.Subject(), Dishes: m.Dishes})
+	if err != nil {
+		return fcerr.Wrap(err, "Could not marshal the webhook notification", http.StatusInternalServerError)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fcerr.Wrap(err, "Could not make the webhook request", http.StatusInternalServerError)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if w.config.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+w.config.Token)
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return fcerr.Wrap(err, "Could not reach the webhook", http.StatusBadGateway)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fcerr.NewFCErr(fmt.Sprintf("The webhook answered with status %d", response.StatusCode), http.StatusBadGateway)
+	}
+	return nil
+}
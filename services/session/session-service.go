@@ -0,0 +1,170 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/session"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+)
+
+//Service is the interface that defines the contract for a session service.
+type Service interface {
+	Create(context.Context, *userDomain.User) (string, *session.Session, fcerr.FCErr)
+	Impersonate(context.Context, *userDomain.User, *userDomain.User) (string, *session.Session, fcerr.FCErr)
+	Validate(context.Context, string) (*userDomain.User, fcerr.FCErr)
+	Revoke(context.Context, string) fcerr.FCErr
+	RevokeAll(context.Context, *userDomain.User) fcerr.FCErr
+}
+
+//DefaultTTL is how long a session lasts when NewService is given no TTL.
+const DefaultTTL = 30 * 24 * time.Hour
+
+//ImpersonationTTL is the longest an admin's session as another user lasts.
+const ImpersonationTTL = time.Hour
+
+//DeactivatedMessage is the 403 a deactivated user gets when they try to use the API.
+const DeactivatedMessage = "This account has been deactivated"
+
+type service struct {
+	repository db.Repository
+	ttl        time.Duration
+	now        func() time.Time
+}
+
+//NewService takes a database repository and how long each session should last, and gives you a new Service instance.
+func NewService(repo db.Repository, ttl time.Duration) Service {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &service{
+		repository: repo,
+		ttl:        ttl,
+		now:        time.Now,
+	}
+}
+
+//Create(u *userDomain.User) starts a session for the user. The token is only ever returned here - the database keeps its hash.
+func (s *service) Create(ctx context.Context, u *userDomain.User) (string, *session.Session, fcerr.FCErr) {
+	return s.create(ctx, u, nil, s.ttl)
+}
+
+//Impersonate(admin *userDomain.User, u *userDomain.User) starts a session for the admin to act as the user, for support.
+//It lasts no longer than ImpersonationTTL, and stops working if the admin is deactivated or is no longer an admin.
+//Another admin can't be impersonated.
+func (s *service) Impersonate(ctx context.Context, admin *userDomain.User, u *userDomain.User) (string, *session.Session, fcerr.FCErr) {
+	if !admin.Admin {
+		return "", nil, fcerr.NewForbiddenError("Only an admin can act as another user")
+	}
+	if u.Admin {
+		return "", nil, fcerr.NewForbiddenError("An admin can't be impersonated")
+	}
+	if !u.IsActive() {
+		return "", nil, fcerr.NewBadRequestError("A deactivated user can't be impersonated")
+	}
+	ttl := s.ttl
+	if ttl > ImpersonationTTL {
+		ttl = ImpersonationTTL
+	}
+	fmt.Println("admin", admin.UserID, "is starting a session as user", u.UserID)
+	return s.create(ctx, u, admin, ttl)
+}
+
+//create starts a session for the user that lasts ttl, started by the impersonator if there is one. A deactivated user
+//gets a 403 instead.
+func (s *service) create(ctx context.Context, u *userDomain.User, impersonator *userDomain.User, ttl time.Duration) (string, *session.Session, fcerr.FCErr) {
+	if !u.IsActive() {
+		return "", nil, fcerr.NewForbiddenError(DeactivatedMessage)
+	}
+	token, hash, err := session.NewToken()
+	if err != nil {
+		return "", nil, fcerr.NewInternalServerError("Could not make a session token")
+	}
+
+	now := dish.CanonicalTime(s.now())
+	started := session.Session{
+		UserID:      u.UserID,
+		TokenHash:   hash,
+		CreatedDate: now,
+		ExpireDate:  now.Add(ttl),
+	}
+	if impersonator != nil {
+		started.ImpersonatorID = impersonator.UserID
+	}
+	created, fcErr := s.repository.CreateSession(ctx, started)
+	if fcErr != nil {
+		return "", nil, fcerr.Wrap(fcErr, "Could not start a session", fcErr.Status())
+	}
+	return token, created, nil
+}
+
+//Validate(token string) gives the user a session token belongs to, or a 401 if it is unknown, revoked or expired.
+//A deactivated user's token gives a 403.
+func (s *service) Validate(ctx context.Context, token string) (*userDomain.User, fcerr.FCErr) {
+	if !session.IsToken(token) {
+		return nil, fcerr.NewUnauthorizedError("This is not a session token")
+	}
+
+	current, fcErr := s.repository.GetSessionByTokenHash(ctx, session.HashToken(token))
+	if fcErr != nil && fcErr.Status() == http.StatusNotFound {
+		return nil, fcerr.Wrap(fcErr, "This session token is not valid", http.StatusUnauthorized)
+	} else if fcErr != nil {
+		return nil, fcerr.Wrap(fcErr, "Could not check the session token", fcErr.Status())
+	}
+
+	if !current.RevokedDate.IsZero() {
+		return nil, fcerr.NewUnauthorizedError("This session has been logged out")
+	}
+	if !current.IsActive(s.now()) {
+		return nil, fcerr.NewUnauthorizedError("This session has expired, please log in again")
+	}
+
+	u, fcErr := s.repository.GetUserByID(ctx, current.UserID)
+	if fcErr != nil && fcErr.Status() == http.StatusNotFound {
+		fmt.Println("session", current.SessionID, "belongs to a user that no longer exists")
+		return nil, fcerr.Wrap(fcErr, "This session token is not valid", http.StatusUnauthorized)
+	} else if fcErr != nil {
+		return nil, fcerr.Wrap(fcErr, "Could not check the session token", fcErr.Status())
+	}
+	if !u.IsActive() {
+		return nil, fcerr.NewForbiddenError(DeactivatedMessage)
+	}
+
+	if current.ImpersonatorID != 0 {
+		admin, fcErr := s.repository.GetUserByID(ctx, current.ImpersonatorID)
+		if fcErr != nil && fcErr.Status() != http.StatusNotFound {
+			return nil, fcerr.Wrap(fcErr, "Could not check the session token", fcErr.Status())
+		}
+		if fcErr != nil || !admin.Admin || !admin.IsActive() {
+			fmt.Println("session", current.SessionID, "was started by someone who is no longer an admin")
+			return nil, fcerr.NewUnauthorizedError("This session is no longer allowed")
+		}
+	}
+	return u, nil
+}
+
+//Revoke(token string) logs out the session the token belongs to.
+func (s *service) Revoke(ctx context.Context, token string) fcerr.FCErr {
+	if !session.IsToken(token) {
+		return fcerr.NewBadRequestError("Only a session token can be logged out")
+	}
+	fcErr := s.repository.RevokeSession(ctx, session.HashToken(token), s.now())
+	if fcErr != nil {
+		return fcerr.Wrap(fcErr, "Could not log out the session", fcErr.Status())
+	}
+	return nil
+}
+
+//RevokeAll(u *userDomain.User) logs out every session the user has.
+func (s *service) RevokeAll(ctx context.Context, u *userDomain.User) fcerr.FCErr {
+	fcErr := s.repository.RevokeUserSessions(ctx, u.UserID, s.now())
+	if fcErr != nil {
+		return fcerr.Wrap(fcErr, "Could not log out the user's sessions", fcErr.Status())
+	}
+	return nil
+}
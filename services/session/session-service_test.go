@@ -0,0 +1,138 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/session"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//newTestService gives a session service over a memory repository holding one user, with a clock the test can move.
+func newTestService(t *testing.T) (*service, *userDomain.User, *time.Time) {
+	repo := db.NewMemoryRepository()
+	u, err := repo.CreateUser(context.Background(), userDomain.User{Email: "nothing@gmail.com", FirstName: "Bob"})
+	if err != nil {
+		t.Fatal(err.Message())
+	}
+
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := NewService(repo, time.Hour).(*service)
+	s.now = func() time.Time { return now }
+	return s, u, &now
+}
+
+func TestSessionService_CreateAndValidate(t *testing.T) {
+	s, u, now := newTestService(t)
+
+	token, created, err := s.Create(context.Background(), u)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(token, session.TokenPrefix))
+	assert.Equal(t, now.Add(time.Hour), created.ExpireDate)
+	assert.NotEqual(t, token, created.TokenHash)
+
+	validated, err := s.Validate(context.Background(), token)
+	assert.Nil(t, err)
+	assert.Equal(t, u.UserID, validated.UserID)
+
+	otherToken, _, _ := s.Create(context.Background(), u)
+	assert.NotEqual(t, token, otherToken)
+}
+
+func TestSessionService_Validate_Invalid(t *testing.T) {
+	s, _, _ := newTestService(t)
+
+	for _, token := range []string{"", "ya29.a-google-token", session.TokenPrefix + "never-issued"} {
+		_, err := s.Validate(context.Background(), token)
+		if assert.NotNil(t, err, token) {
+			assert.Equal(t, http.StatusUnauthorized, err.Status(), token)
+		}
+	}
+}
+
+func TestSessionService_Validate_Expired(t *testing.T) {
+	s, u, now := newTestService(t)
+	token, _, _ := s.Create(context.Background(), u)
+
+	*now = now.Add(time.Hour - time.Second)
+	_, err := s.Validate(context.Background(), token)
+	assert.Nil(t, err)
+
+	*now = now.Add(time.Second)
+	_, err = s.Validate(context.Background(), token)
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+}
+
+func TestSessionService_Revoke(t *testing.T) {
+	s, u, _ := newTestService(t)
+	token, _, _ := s.Create(context.Background(), u)
+	otherToken, _, _ := s.Create(context.Background(), u)
+
+	assert.Nil(t, s.Revoke(context.Background(), token))
+	_, err := s.Validate(context.Background(), token)
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+
+	_, err = s.Validate(context.Background(), otherToken)
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.RevokeAll(context.Background(), u))
+	_, err = s.Validate(context.Background(), otherToken)
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+
+	err = s.Revoke(context.Background(), "ya29.a-google-token")
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+}
+
+func TestSessionService_Validate_DeletedUser(t *testing.T) {
+	s, u, _ := newTestService(t)
+	token, _, _ := s.Create(context.Background(), u)
+
+	assert.Nil(t, s.repository.DeleteUser(context.Background(), u.UserID))
+	_, err := s.Validate(context.Background(), token)
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+}
+
+func TestSessionService_Impersonate(t *testing.T) {
+	s, u, now := newTestService(t)
+	ctx := context.Background()
+	admin, _ := s.repository.CreateUser(ctx, userDomain.User{Email: "admin@gmail.com", Admin: true})
+	s.ttl = 24 * time.Hour
+
+	token, created, err := s.Impersonate(ctx, admin, u)
+	assert.Nil(t, err)
+	assert.Equal(t, now.Add(ImpersonationTTL), created.ExpireDate, "no longer than an hour")
+	assert.Equal(t, admin.UserID, created.ImpersonatorID)
+	validated, err := s.Validate(ctx, token)
+	assert.Nil(t, err)
+	assert.Equal(t, u.UserID, validated.UserID)
+
+	_, _, err = s.Impersonate(ctx, u, admin)
+	assert.Equal(t, http.StatusForbidden, err.Status(), "only admins impersonate")
+	_, _, err = s.Impersonate(ctx, admin, admin)
+	assert.Equal(t, http.StatusForbidden, err.Status(), "admins aren't impersonated")
+
+	//the session stops working once its admin is deactivated
+	s.repository.SetUserDeactivated(ctx, admin.UserID, *now)
+	_, err = s.Validate(ctx, token)
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+}
+
+func TestSessionService_DeactivatedUser(t *testing.T) {
+	s, u, now := newTestService(t)
+	ctx := context.Background()
+	token, _, _ := s.Create(ctx, u)
+
+	s.repository.SetUserDeactivated(ctx, u.UserID, *now)
+	_, err := s.Validate(ctx, token)
+	assert.Equal(t, http.StatusForbidden, err.Status())
+
+	deactivated, _ := s.repository.GetUserByID(ctx, u.UserID)
+	_, _, err = s.Create(ctx, deactivated)
+	assert.Equal(t, http.StatusForbidden, err.Status())
+}
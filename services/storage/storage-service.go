@@ -1,103 +1,170 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"strconv"
+	"time"
 
 	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/event"
 	"github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
 	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/eventbus"
 	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
 	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
 )
 
 //Service is the interface that defines the contract for a storage service.
 type Service interface {
-	GetByID(*userDomain.User, int) (*storage.Storage, fcerr.FCErr)
-	GetDishesByID(*userDomain.User, int) (*dishDomain.Dishes, fcerr.FCErr)
-	GetAll(*userDomain.User) (*storage.Storages, fcerr.FCErr)
-	Create(*userDomain.User, *storage.Storage) (*storage.Storage, fcerr.FCErr)
-	Update(*userDomain.User, *storage.Storage) fcerr.FCErr
-	Delete(*userDomain.User, int) fcerr.FCErr
+	GetByID(context.Context, *userDomain.User, int) (*storage.Storage, fcerr.FCErr)
+	GetDishesByID(context.Context, *userDomain.User, int) (*dishDomain.Dishes, fcerr.FCErr)
+	GetAll(context.Context, *userDomain.User) (*storage.Storages, fcerr.FCErr)
+	Create(context.Context, *userDomain.User, *storage.Storage) (*storage.Storage, fcerr.FCErr)
+	Update(context.Context, *userDomain.User, *storage.Storage) fcerr.FCErr
+	Delete(context.Context, *userDomain.User, int) fcerr.FCErr
 }
 
 type service struct {
 	repository db.Repository
+	events     *eventbus.Bus
 }
 
 //NewService takes a database repository and gives you a new Service instance.
 func NewService(repo db.Repository) Service {
+	return NewServiceWithEvents(repo, nil)
+}
+
+//NewServiceWithEvents is NewService with the bus that every storage unit created, updated or deleted is published on.
+func NewServiceWithEvents(repo db.Repository, events *eventbus.Bus) Service {
 	return &service{
 		repository: repo,
+		events:     events,
 	}
 }
 
 //GetByID: (alexaid string, accessToken string, id int) takes an int id and sends it to the database repo for lookup.
-func (s *service) GetByID(requestingUser *userDomain.User, pID int) (*storage.Storage, fcerr.FCErr) {
-	resultStorage, err := s.repository.GetStorageByID(requestingUser.UserID, pID)
+func (s *service) GetByID(ctx context.Context, requestingUser *userDomain.User, pID int) (*storage.Storage, fcerr.FCErr) {
+	member, err := household.Membership(ctx, s.repository, requestingUser)
 	if err != nil {
-		return nil, fcerr.NewInternalServerError("could not do the GetByID, possibly not in the db")
+		return nil, err
+	}
+	resultStorage, err := s.repository.GetStorageByID(ctx, member.HouseholdID, pID)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the storage unit with personal id "+strconv.Itoa(pID), err.Status())
 	}
 	return resultStorage, nil
 }
 
-//GetDishesByID(requestingUser *userDomain.User, pID int) gets all the dishes that belong to the requesting user in the given storage unit
-func (s *service) GetDishesByID(requestingUser *userDomain.User, pID int) (*dishDomain.Dishes, fcerr.FCErr) {
-	resultDishes, err := s.repository.GetStorageDishes(requestingUser.UserID, pID)
+//GetDishesByID(requestingUser *userDomain.User, pID int) gets all the dishes in the given storage unit of the requesting user's household
+func (s *service) GetDishesByID(ctx context.Context, requestingUser *userDomain.User, pID int) (*dishDomain.Dishes, fcerr.FCErr) {
+	member, err := household.Membership(ctx, s.repository, requestingUser)
+	if err != nil {
+		return nil, err
+	}
+	resultDishes, err := s.repository.GetStorageDishes(ctx, member.HouseholdID, pID)
 	if err != nil {
-		return nil, fcerr.NewInternalServerError("could not do the getstoragedishes")
+		return nil, fcerr.Wrap(err, "Could not get the dishes in this storage unit", err.Status())
 	}
 	return resultDishes, nil
 }
 
-//GetAll: (alexaid string, accessToken string) - gets all the storage units that the requesting user has
-func (s *service) GetAll(requestUser *userDomain.User) (*storage.Storages, fcerr.FCErr) {
-	resultStorageList, err := s.repository.GetStorages(requestUser.UserID)
+//GetAll: (alexaid string, accessToken string) - gets all the storage units in the requesting user's household
+func (s *service) GetAll(ctx context.Context, requestUser *userDomain.User) (*storage.Storages, fcerr.FCErr) {
+	member, err := household.Membership(ctx, s.repository, requestUser)
+	if err != nil {
+		return nil, err
+	}
+	resultStorageList, err := s.repository.GetStorages(ctx, member.HouseholdID)
 	if err != nil {
-		fcerr := fcerr.NewInternalServerError("storage service could not do GetAll()")
-		return nil, fcerr
+		return nil, fcerr.Wrap(err, "Could not get the storage units", err.Status())
 	}
 	return resultStorageList, nil
 
 }
 
-func (s *service) Create(requestingUser *userDomain.User, newStorage *storage.Storage) (*storage.Storage, fcerr.FCErr) {
-
-	personalCount, err := s.repository.GetPersonalStorageCount(requestingUser.UserID)
+func (s *service) Create(ctx context.Context, requestingUser *userDomain.User, newStorage *storage.Storage) (*storage.Storage, fcerr.FCErr) {
+	member, err := household.Membership(ctx, s.repository, requestingUser)
 	if err != nil {
-		return nil, fcerr.NewInternalServerError("Error when creating the storage unit.")
+		return nil, err
 	}
 
 	newStorage.UserID = requestingUser.UserID
-	newStorage.PersonalID = personalCount + 1
-
-	fmt.Println("\nWe are doing the storage service Create() with this storage:\n", newStorage)
-	//alexaid string, accessToken string, storageID string, title string, desc string, expire string, priority string, dishtype string, portions string
-	resultStorage, err := s.repository.CreateStorage(*newStorage)
+	newStorage.HouseholdID = member.HouseholdID
+
+	//Counting and inserting in one transaction keeps two creates from getting the same personal id
+	var resultStorage *storage.Storage
+	err = s.repository.WithTx(ctx, func(tx db.Repository) fcerr.FCErr {
+		personalCount, err := tx.GetPersonalStorageCount(ctx, member.HouseholdID)
+		if err != nil {
+			return fcerr.Wrap(err, "Error when creating the storage unit.", http.StatusInternalServerError)
+		}
+
+		newStorage.PersonalID = personalCount + 1
+
+		fmt.Println("\nWe are doing the storage service Create() with this storage:\n", newStorage)
+		//alexaid string, accessToken string, storageID string, title string, desc string, expire string, priority string, dishtype string, portions string
+		resultStorage, err = tx.CreateStorage(ctx, *newStorage)
+		if err != nil {
+			return fcerr.Wrap(err, "Storage Service could not do the Create()", err.Status())
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fcerr.NewInternalServerError("Storage Service could not do the Create()")
+		return nil, err
 	}
+	s.events.Publish(event.New(event.StorageCreated, member.HouseholdID, requestingUser.UserID, time.Now(), *resultStorage))
 	return resultStorage, nil
 
 }
 
-func (s *service) Update(requestingUser *userDomain.User, newStorage *storage.Storage) fcerr.FCErr {
+//Update only changes storage units in the requesting user's household.
+func (s *service) Update(ctx context.Context, requestingUser *userDomain.User, newStorage *storage.Storage) fcerr.FCErr {
+	member, err := household.Membership(ctx, s.repository, requestingUser)
+	if err != nil {
+		return err
+	}
+	if newStorage.HouseholdID != member.HouseholdID {
+		return fcerr.NewNotFoundError("Could not find this storage unit in the user's household")
+	}
 
 	fmt.Println("\nWe are doing the storage service Update() with this storage:\n", newStorage)
 	//alexaid string, accessToken string, storageID string, title string, desc string, expire string, priority string, dishtype string, portions string
-	err := s.repository.UpdateStorage(*newStorage)
+	err = s.repository.UpdateStorage(ctx, *newStorage)
 	if err != nil {
-		return fcerr.NewInternalServerError("Storage Service could not do the Create()")
+		return fcerr.Wrap(err, "Storage Service could not do the Update()", err.Status())
 	}
+	s.events.Publish(event.New(event.StorageUpdated, member.HouseholdID, requestingUser.UserID, time.Now(), *newStorage))
 	return nil
 }
 
-func (s *service) Delete(requestingUser *userDomain.User, storageID int) fcerr.FCErr {
+//Delete removes a storage unit from the household. Only an owner of the household can.
+func (s *service) Delete(ctx context.Context, requestingUser *userDomain.User, storageID int) fcerr.FCErr {
+	member, err := household.Membership(ctx, s.repository, requestingUser)
+	if err != nil {
+		return err
+	}
+	if !member.IsOwner() {
+		return fcerr.NewForbiddenError("Only an owner of the household can delete its storage units")
+	}
 
 	fmt.Println("We are doing the storage service Delete() with this storage:\n", storageID)
+	//the storage unit is read first only to say what was deleted; if it isn't there, DeleteStorage says so
+	removed, _ := s.repository.GetStorageByID(ctx, member.HouseholdID, storageID)
 	//alexaid string, accessToken string, storageID string, title string, desc string, expire string, priority string, dishtype string, portions string
-	err := s.repository.DeleteStorage(requestingUser.UserID, storageID)
+	err = s.repository.DeleteStorage(ctx, member.HouseholdID, storageID)
 	if err != nil {
-		return fcerr.NewInternalServerError("Storage Service could not do the Delete()")
+
+		if err.Status() == http.StatusBadRequest {
+			return fcerr.Wrap(err, "Storage Service could not do Delete(): "+err.Message(), http.StatusBadRequest)
+		}
+		return fcerr.Wrap(err, "Storage Service could not do the Delete()", http.StatusInternalServerError)
+
+	}
+	if removed != nil {
+		s.events.Publish(event.New(event.StorageDeleted, member.HouseholdID, requestingUser.UserID, time.Now(), *removed))
 	}
 	return nil
 
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/event"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/eventbus"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//newTestService gives a storage service over a memory repository holding an owner, a member of the owner's household
+//and someone outside it, along with everything published on the service's bus.
+func newTestService(t *testing.T) (Service, db.Repository, []*userDomain.User, *[]event.Event) {
+	ctx := context.Background()
+	repo := db.NewMemoryRepository()
+	var users []*userDomain.User
+	for _, email := range []string{"nothing@gmail.com", "session@gmail.com", "outside@gmail.com"} {
+		u, err := repo.CreateUser(ctx, userDomain.User{Email: email})
+		if err != nil {
+			t.Fatal(err.Message())
+		}
+		users = append(users, u)
+	}
+
+	hS := household.NewService(repo)
+	invite, err := hS.Invite(ctx, users[0], users[1].Email, "")
+	if err != nil {
+		t.Fatal(err.Message())
+	}
+	if _, err := hS.AcceptInvite(ctx, users[1], invite.InviteID); err != nil {
+		t.Fatal(err.Message())
+	}
+
+	bus := eventbus.New()
+	published := &[]event.Event{}
+	bus.Subscribe(func(e event.Event) { *published = append(*published, e) })
+	return NewServiceWithEvents(repo, bus), repo, users, published
+}
+
+func TestStorageService_MemberListsAndReads(t *testing.T) {
+	sS, repo, users, _ := newTestService(t)
+	ctx := context.Background()
+	owner, member := users[0], users[1]
+
+	for _, title := range []string{"Fridge", "Freezer"} {
+		_, err := sS.Create(ctx, owner, &storage.Storage{Title: title})
+		assert.Nil(t, err)
+	}
+	home, _ := repo.GetMembership(ctx, owner.UserID)
+	if _, err := repo.CreateDish(ctx, dish.Dish{PersonalDishID: 1, UserID: owner.UserID, HouseholdID: home.HouseholdID,
+		StorageID: 2, Title: "Peas", Portions: -1}); err != nil {
+		t.Fatal(err.Message())
+	}
+
+	storages, err := sS.GetAll(ctx, member)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(*storages)) {
+		assert.Equal(t, "Fridge", (*storages)[0].Title)
+		assert.Equal(t, owner.UserID, (*storages)[0].UserID)
+	}
+	freezer, err := sS.GetByID(ctx, member, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "Freezer", freezer.Title)
+	dishes, err := sS.GetDishesByID(ctx, member, 2)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(*dishes)) {
+		assert.Equal(t, "Peas", (*dishes)[0].Title)
+	}
+
+	//a unit the member adds gets the household's next personal id
+	pantry, err := sS.Create(ctx, member, &storage.Storage{Title: "Pantry"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, pantry.PersonalID)
+	assert.Equal(t, member.UserID, pantry.UserID)
+}
+
+func TestStorageService_Delete_OnlyOwners(t *testing.T) {
+	sS, _, users, _ := newTestService(t)
+	ctx := context.Background()
+	owner, member := users[0], users[1]
+
+	_, err := sS.Create(ctx, member, &storage.Storage{Title: "Fridge"})
+	assert.Nil(t, err)
+
+	err = sS.Delete(ctx, member, 1)
+	assert.Equal(t, http.StatusForbidden, err.Status(), "even the member who added it can't delete it")
+	_, err = sS.GetByID(ctx, owner, 1)
+	assert.Nil(t, err)
+
+	assert.Nil(t, sS.Delete(ctx, owner, 1))
+	_, err = sS.GetByID(ctx, member, 1)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+}
+
+func TestStorageService_OutsideTheHousehold(t *testing.T) {
+	sS, _, users, _ := newTestService(t)
+	ctx := context.Background()
+	owner, outsider := users[0], users[2]
+
+	fridge, err := sS.Create(ctx, owner, &storage.Storage{Title: "Fridge"})
+	assert.Nil(t, err)
+
+	_, err = sS.GetByID(ctx, outsider, fridge.PersonalID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	_, err = sS.GetDishesByID(ctx, outsider, fridge.PersonalID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	_, err = sS.GetAll(ctx, outsider)
+	assert.Equal(t, http.StatusNotFound, err.Status(), "the outsider's own household has no storage units")
+
+	changed := *fridge
+	changed.Title = "Mine now"
+	err = sS.Update(ctx, outsider, &changed)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	assert.NotNil(t, sS.Delete(ctx, outsider, fridge.PersonalID))
+
+	still, err := sS.GetByID(ctx, owner, fridge.PersonalID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Fridge", still.Title)
+}
+
+func TestStorageService_Events(t *testing.T) {
+	sS, repo, users, published := newTestService(t)
+	ctx := context.Background()
+	owner, member := users[0], users[1]
+
+	fridge, err := sS.Create(ctx, member, &storage.Storage{Title: "Fridge"})
+	assert.Nil(t, err)
+	fridge.Title = "Big Fridge"
+	assert.Nil(t, sS.Update(ctx, member, fridge))
+	assert.Nil(t, sS.Delete(ctx, owner, fridge.PersonalID))
+	assert.NotNil(t, sS.Delete(ctx, owner, fridge.PersonalID))
+
+	if assert.Equal(t, 3, len(*published), "nothing is published for what failed") {
+		home, _ := repo.GetMembership(ctx, owner.UserID)
+		for i, eventType := range []string{event.StorageCreated, event.StorageUpdated, event.StorageDeleted} {
+			assert.Equal(t, eventType, (*published)[i].Type)
+			assert.Equal(t, home.HouseholdID, (*published)[i].HouseholdID)
+		}
+		assert.Equal(t, member.UserID, (*published)[0].UserID)
+		assert.Equal(t, owner.UserID, (*published)[2].UserID)
+		updated, ok := (*published)[1].Data.(storage.Storage)
+		if assert.True(t, ok) {
+			assert.Equal(t, "Big Fridge", updated.Title)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/event"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+)
+
+//Service is the interface that defines the contract for a stream service - it keeps the latest events in each user's
+//household, so a dashboard following them live can pick up where it left off after losing its connection.
+type Service interface {
+	Record(event.Event)
+	Follow(context.Context, *userDomain.User, string) (*Stream, fcerr.FCErr)
+}
+
+//Entry is an event as a user's stream gives it, with the id to resume after it.
+type Entry struct {
+	ID    string
+	Event event.Event
+}
+
+//Stream is a user following their household's events. Missed is what happened since the last event id they gave, and
+//Events gives what happens from now on. Events is closed if they fall more than StreamBuffer events behind, and they
+//should follow again from the last id they got. Reset is set when the last event id can't be resumed from - it fell out
+//of the log, or was given before a restart - so they should load everything again.
+type Stream struct {
+	Missed []Entry
+	Events <-chan Entry
+	Reset  bool
+	stop   func()
+}
+
+//Close stops following the events.
+func (st *Stream) Close() {
+	st.stop()
+}
+
+//LogSize is how many of the latest events are kept for each user.
+const LogSize = 100
+
+//StreamBuffer is how many events a stream can fall behind before it is closed.
+const StreamBuffer = 32
+
+//recordTimeout is how long finding who an event is for can take.
+const recordTimeout = 5 * time.Second
+
+//userLog is one user's latest events, oldest first, and the streams following them. Each event gets the next of the
+//user's sequence numbers.
+type userLog struct {
+	entries []Entry
+	lastSeq int
+	streams map[int]chan Entry
+}
+
+type service struct {
+	repository db.Repository
+	//boot starts every event id, so ids given out before a restart aren't mistaken for new ones
+	boot string
+
+	mu           sync.Mutex
+	logs         map[int]*userLog
+	lastStreamID int
+}
+
+//NewService takes a database repository and gives you a new Service instance, with nothing in its logs.
+func NewService(repo db.Repository) Service {
+	return &service{
+		repository: repo,
+		boot:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		logs:       map[int]*userLog{},
+	}
+}
+
+//Record adds the event to the log of everyone in its household, and sends it to those following them. It is subscribed
+//to the event bus.
+func (s *service) Record(e event.Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+
+	members, err := s.repository.GetHouseholdMembers(ctx, e.HouseholdID)
+	if errors.Is(err, fcerr.ErrNotFound) {
+		return
+	} else if err != nil {
+		fmt.Println("could not find who event", e.EventID, "is for -", err.Message())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range *members {
+		l := s.log(m.UserID)
+		l.lastSeq++
+		entry := Entry{ID: s.boot + "-" + strconv.Itoa(l.lastSeq), Event: e}
+		l.entries = append(l.entries, entry)
+		if len(l.entries) > LogSize {
+			l.entries = append([]Entry(nil), l.entries[len(l.entries)-LogSize:]...)
+		}
+		for id, events := range l.streams {
+			select {
+			case events <- entry:
+			default:
+				//too far behind - closing it has the client follow again from the log
+				close(events)
+				delete(l.streams, id)
+			}
+		}
+	}
+}
+
+//log is the user's log, made if they don't have one yet. The caller holds s.mu.
+func (s *service) log(userID int) *userLog {
+	l, ok := s.logs[userID]
+	if !ok {
+		l = &userLog{streams: map[int]chan Entry{}}
+		s.logs[userID] = l
+	}
+	return l
+}
+
+//Follow(requestingUser *userDomain.User, lastEventID string) follows the events in the user's household, starting
+//after lastEventID, or from now on if it is "".
+func (s *service) Follow(ctx context.Context, requestingUser *userDomain.User, lastEventID string) (*Stream, fcerr.FCErr) {
+	if err := ctx.Err(); err != nil {
+		return nil, fcerr.NewGatewayTimeoutError("Gave up before following the events")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.log(requestingUser.UserID)
+	st := &Stream{Missed: []Entry{}}
+	if lastEventID != "" {
+		st.Missed, st.Reset = s.missed(l, lastEventID)
+	}
+
+	events := make(chan Entry, StreamBuffer)
+	s.lastStreamID++
+	id := s.lastStreamID
+	l.streams[id] = events
+	st.Events = events
+	st.stop = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if current, ok := l.streams[id]; ok {
+			close(current)
+			delete(l.streams, id)
+		}
+	}
+	return st, nil
+}
+
+//missed is what is in the log after lastEventID, and whether it can't be resumed from. The caller holds s.mu.
+func (s *service) missed(l *userLog, lastEventID string) ([]Entry, bool) {
+	boot, seqText, found := strings.Cut(lastEventID, "-")
+	seq, err := strconv.Atoi(seqText)
+	if !found || err != nil || boot != s.boot || seq < 0 || seq > l.lastSeq {
+		return []Entry{}, true
+	}
+
+	first := l.lastSeq - len(l.entries) + 1
+	if seq < first-1 {
+		//the events straight after it have fallen out of the log
+		return []Entry{}, true
+	}
+	return append([]Entry{}, l.entries[seq-first+1:]...), false
+}
@@ -0,0 +1,134 @@
+package stream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/event"
+	householdDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+	"github.com/stretchr/testify/assert"
+)
+
+//newStreamTest gives a stream service over a repository where Bob and Alice share a household and Carol has her own.
+func newStreamTest(t *testing.T) (Service, *user.User, *user.User, int) {
+	ctx := context.Background()
+	repo := db.NewMemoryRepository()
+	bob, fcErr := repo.CreateUser(ctx, user.User{Email: "nothing@gmail.com", FirstName: "Bob"})
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	alice, fcErr := repo.CreateUser(ctx, user.User{Email: "alice@gmail.com", FirstName: "Alice"})
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	carol, fcErr := repo.CreateUser(ctx, user.User{Email: "carol@gmail.com", FirstName: "Carol"})
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	home, fcErr := household.Membership(ctx, repo, bob)
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	if fcErr := repo.CreateMembership(ctx, householdDomain.Member{HouseholdID: home.HouseholdID, UserID: alice.UserID,
+		Role: householdDomain.RoleMember, JoinedDate: time.Now()}); fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	if _, fcErr := household.Membership(ctx, repo, carol); fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	return NewService(repo), bob, carol, home.HouseholdID
+}
+
+//next is the next entry on the stream, failing the test if there isn't one within a second.
+func next(t *testing.T, st *Stream) Entry {
+	select {
+	case entry, ok := <-st.Events:
+		if !ok {
+			t.Fatal("the stream was closed")
+		}
+		return entry
+	case <-time.After(time.Second):
+		t.Fatal("no event came")
+	}
+	return Entry{}
+}
+
+func TestStream_Follow(t *testing.T) {
+	s, bob, carol, householdID := newStreamTest(t)
+	ctx := context.Background()
+
+	st, err := s.Follow(ctx, bob, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(st.Missed))
+	other, _ := s.Follow(ctx, carol, "")
+
+	created := event.New(event.DishCreated, householdID, bob.UserID, time.Now(), nil)
+	s.Record(created)
+	first := next(t, st)
+	assert.Equal(t, created.EventID, first.Event.EventID)
+	select {
+	case entry := <-other.Events:
+		t.Fatal("Carol isn't in the household, but got", entry.Event.Type)
+	default:
+	}
+
+	st.Close()
+	s.Record(event.New(event.DishUpdated, householdID, bob.UserID, time.Now(), nil))
+	s.Record(event.New(event.DishExpired, householdID, 0, time.Now(), nil))
+
+	resumed, err := s.Follow(ctx, bob, first.ID)
+	assert.Nil(t, err)
+	assert.False(t, resumed.Reset)
+	if assert.Equal(t, 2, len(resumed.Missed), "what happened while Bob was away") {
+		assert.Equal(t, event.DishUpdated, resumed.Missed[0].Event.Type)
+		assert.Equal(t, event.DishExpired, resumed.Missed[1].Event.Type)
+	}
+	s.Record(event.New(event.StorageCreated, householdID, bob.UserID, time.Now(), nil))
+	assert.Equal(t, event.StorageCreated, next(t, resumed).Event.Type, "and then what happens from now on")
+	resumed.Close()
+	other.Close()
+}
+
+func TestStream_Reset(t *testing.T) {
+	s, bob, _, householdID := newStreamTest(t)
+	ctx := context.Background()
+
+	for _, lastEventID := range []string{"nonsense", "0-1", "abc-x"} {
+		st, err := s.Follow(ctx, bob, lastEventID)
+		assert.Nil(t, err)
+		assert.True(t, st.Reset, lastEventID)
+		st.Close()
+	}
+
+	st, _ := s.Follow(ctx, bob, "")
+	s.Record(event.New(event.DishCreated, householdID, bob.UserID, time.Now(), nil))
+	first := next(t, st)
+	st.Close()
+
+	for i := 0; i <= LogSize; i++ {
+		s.Record(event.New(event.DishUpdated, householdID, bob.UserID, time.Now(), nil))
+	}
+	tooOld, _ := s.Follow(ctx, bob, first.ID)
+	assert.True(t, tooOld.Reset, "the events after it fell out of the log")
+	assert.Equal(t, 0, len(tooOld.Missed))
+	tooOld.Close()
+}
+
+func TestStream_FallsBehind(t *testing.T) {
+	s, bob, _, householdID := newStreamTest(t)
+	st, _ := s.Follow(context.Background(), bob, "")
+
+	for i := 0; i <= StreamBuffer; i++ {
+		s.Record(event.New(event.DishUpdated, householdID, bob.UserID, time.Now(), nil))
+	}
+	received := 0
+	for range st.Events {
+		received++
+	}
+	assert.Equal(t, StreamBuffer, received, "the stream is closed once it can't keep up")
+	st.Close()
+}
@@ -0,0 +1,297 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	dishDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/identity"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/webhook"
+	"github.com/jasonradcliffe/freshness-countdown-api/duration"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+)
+
+//MaxDeletionGrace is the longest a user can wait between asking for their account to be erased and it happening.
+const MaxDeletionGrace = 90 * 24 * time.Hour
+
+//Export is everything the API keeps about a user, for them to take with them before their account is erased.
+//Their tokens and webhook secrets are left out. A user who shares a household gets all of its storage units and dishes, since they are theirs too.
+type Export struct {
+	ExportedAt     time.Time             `json:"TimeExported"`
+	User           user.User             `json:"User"`
+	Household      *household.Household  `json:"Household,omitempty"`
+	Storages       storage.Storages      `json:"Storages"`
+	Dishes         dishDomain.Dishes     `json:"Dishes"`
+	LinkedAccounts identity.Identities   `json:"LinkedAccounts"`
+	Webhooks       webhook.Subscriptions `json:"Webhooks"`
+}
+
+//UpdateProfile(id int, p user.Profile) changes the user's names, time zone and notification preferences. Changing the
+//first or last name without a full name makes the full name from them. Quiet hours are turned off by giving the same
+//start and end.
+func (s *service) UpdateProfile(ctx context.Context, id int, p user.Profile) (*user.User, fcerr.FCErr) {
+	current, err := s.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var details []fcerr.FieldDetail
+	if p.TimeZone != "" {
+		if _, err := time.LoadLocation(p.TimeZone); err != nil || strings.EqualFold(p.TimeZone, "Local") {
+			details = append(details, fcerr.FieldDetail{Field: "timeZone", Message: "not a known time zone: " + p.TimeZone})
+		}
+	}
+	if p.NotifyDaysBefore != nil && (*p.NotifyDaysBefore < 1 || *p.NotifyDaysBefore > user.MaxNotifyDaysBefore) {
+		details = append(details, fcerr.FieldDetail{Field: "notifyDaysBefore",
+			Message: "must be from 1 to " + strconv.Itoa(user.MaxNotifyDaysBefore)})
+	}
+	for _, quiet := range []struct{ field, value string }{{"quietStart", p.QuietStart}, {"quietEnd", p.QuietEnd}} {
+		if _, err := time.Parse(user.QuietFormat, quiet.value); quiet.value != "" && err != nil {
+			details = append(details, fcerr.FieldDetail{Field: quiet.field, Message: "not a time like 22:30: " + quiet.value})
+		}
+	}
+	if len(details) > 0 {
+		return nil, fcerr.NewValidationError("The profile could not be updated", details...)
+	}
+
+	updated := *current
+	if p.FirstName != "" {
+		updated.FirstName = strings.TrimSpace(p.FirstName)
+	}
+	if p.LastName != "" {
+		updated.LastName = strings.TrimSpace(p.LastName)
+	}
+	if p.FullName != "" {
+		updated.FullName = strings.TrimSpace(p.FullName)
+	} else if p.FirstName != "" || p.LastName != "" {
+		updated.FullName = strings.TrimSpace(updated.FirstName + " " + updated.LastName)
+	}
+	if p.TimeZone != "" {
+		updated.TimeZone = p.TimeZone
+	}
+	if p.NotifyExpiring != nil {
+		updated.NotifyExpiring = *p.NotifyExpiring
+	}
+	if p.NotifyDaysBefore != nil {
+		updated.NotifyDaysBefore = *p.NotifyDaysBefore
+	}
+	if p.QuietStart != "" {
+		updated.QuietStart = p.QuietStart
+	}
+	if p.QuietEnd != "" {
+		updated.QuietEnd = p.QuietEnd
+	}
+
+	fmt.Println("updating the profile of user", id)
+	saved, err := s.repository.UpdateUser(ctx, updated)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Error while updating the user's profile.", err.Status())
+	}
+	s.tokens.forgetUser(id)
+	return saved, nil
+}
+
+//ScheduleDeletion(id int, grace duration.Duration) has the user's account erased once the grace period is over, unless
+//they cancel it first. The grace period has to be more than nothing and no more than MaxDeletionGrace.
+func (s *service) ScheduleDeletion(ctx context.Context, id int, grace duration.Duration) (*user.User, fcerr.FCErr) {
+	now := dishDomain.CanonicalTime(time.Now())
+	when := grace.AddTo(now)
+	if !when.After(now) || when.After(now.Add(MaxDeletionGrace)) {
+		return nil, fcerr.NewValidationError("The grace period must be more than nothing and no more than 90 days",
+			fcerr.FieldDetail{Field: "grace", Message: "out of range: " + grace.String()})
+	}
+	if _, err := s.GetByID(ctx, id); err != nil {
+		return nil, err
+	}
+
+	fmt.Println("user", id, "will be erased at", when)
+	if err := s.repository.SetUserDeletion(ctx, id, when); err != nil {
+		return nil, fcerr.Wrap(err, "Error while scheduling the user's deletion.", err.Status())
+	}
+	return s.GetByID(ctx, id)
+}
+
+//CancelDeletion(id int) keeps the user's account after they asked for it to be erased.
+func (s *service) CancelDeletion(ctx context.Context, id int) (*user.User, fcerr.FCErr) {
+	current, err := s.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !current.DeletionPending() {
+		return nil, fcerr.NewNotFoundError("This account is not going to be deleted")
+	}
+
+	fmt.Println("user", id, "will not be erased after all")
+	if err := s.repository.SetUserDeletion(ctx, id, time.Time{}); err != nil {
+		return nil, fcerr.Wrap(err, "Error while cancelling the user's deletion.", err.Status())
+	}
+	return s.GetByID(ctx, id)
+}
+
+//Erase(id int) deletes the user and everything that is only theirs: their sessions, notifications, linked accounts,
+//webhooks and, if nobody else is in their household, its storage units and dishes. In a shared household what they added stays
+//with the household, and if they were its last owner the member who joined first after them becomes one.
+func (s *service) Erase(ctx context.Context, id int) fcerr.FCErr {
+	if _, err := s.GetByID(ctx, id); err != nil {
+		return err
+	}
+
+	fmt.Println("erasing user", id)
+	err := s.repository.WithTx(ctx, func(tx db.Repository) fcerr.FCErr {
+		if err := leaveHousehold(ctx, tx, id); err != nil {
+			return err
+		}
+		identities, err := tx.GetUserIdentities(ctx, id)
+		if err != nil {
+			return err
+		}
+		for _, linked := range *identities {
+			if err := tx.DeleteIdentity(ctx, id, linked.LinkID); err != nil {
+				return err
+			}
+		}
+		if err := tx.DeleteUserSessions(ctx, id); err != nil {
+			return err
+		}
+		if err := tx.DeleteUserNotifications(ctx, id); err != nil {
+			return err
+		}
+		if err := tx.DeleteUserWebhooks(ctx, id); err != nil {
+			return err
+		}
+		return tx.DeleteUser(ctx, id)
+	})
+	if err != nil {
+		return fcerr.Wrap(err, "Error while erasing the user.", err.Status())
+	}
+	s.tokens.forgetUser(id)
+	return nil
+}
+
+//EraseDue(now time.Time) erases every user whose grace period is over by now, and gives how many there were.
+//One that can't be erased is left for next time.
+func (s *service) EraseDue(ctx context.Context, now time.Time) (int, fcerr.FCErr) {
+	pending, err := s.repository.GetUsersPendingDeletion(ctx)
+	if errors.Is(err, fcerr.ErrNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fcerr.Wrap(err, "Error while finding the users to erase.", err.Status())
+	}
+
+	erased := 0
+	for _, u := range *pending {
+		if u.DeletionDate.After(now) {
+			continue
+		}
+		if err := s.Erase(ctx, u.UserID); err != nil {
+			fmt.Println("could not erase user", u.UserID, "-", err.Message())
+			continue
+		}
+		erased++
+	}
+	return erased, nil
+}
+
+//Export(id int) gathers everything the API keeps about the user.
+func (s *service) Export(ctx context.Context, id int) (*Export, fcerr.FCErr) {
+	current, err := s.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	exported := &Export{ExportedAt: dishDomain.CanonicalTime(time.Now()), User: *current,
+		Storages: storage.Storages{}, Dishes: dishDomain.Dishes{}}
+	exported.User.AccessToken, exported.User.RefreshToken, exported.User.TempMatch = "", "", ""
+
+	member, err := s.repository.GetMembership(ctx, id)
+	if err != nil && !errors.Is(err, fcerr.ErrNotFound) {
+		return nil, fcerr.Wrap(err, "Error while exporting the user's household.", err.Status())
+	}
+	if member != nil {
+		home, err := s.repository.GetHousehold(ctx, member.HouseholdID)
+		if err != nil {
+			return nil, fcerr.Wrap(err, "Error while exporting the user's household.", err.Status())
+		}
+		members, err := s.repository.GetHouseholdMembers(ctx, member.HouseholdID)
+		if err != nil {
+			return nil, fcerr.Wrap(err, "Error while exporting the user's household.", err.Status())
+		}
+		home.Members = *members
+		exported.Household = home
+
+		storages, err := s.repository.GetStorages(ctx, member.HouseholdID)
+		if err != nil && !errors.Is(err, fcerr.ErrNotFound) {
+			return nil, fcerr.Wrap(err, "Error while exporting the user's storage units.", err.Status())
+		} else if err == nil {
+			exported.Storages = *storages
+		}
+		dishes, err := s.repository.GetDishes(ctx, member.HouseholdID)
+		if err != nil && !errors.Is(err, fcerr.ErrNotFound) {
+			return nil, fcerr.Wrap(err, "Error while exporting the user's dishes.", err.Status())
+		} else if err == nil {
+			exported.Dishes = *dishes
+		}
+	}
+
+	identities, err := s.repository.GetUserIdentities(ctx, id)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Error while exporting the user's linked accounts.", err.Status())
+	}
+	exported.LinkedAccounts = *identities
+
+	webhooks, err := s.repository.GetWebhooks(ctx, id)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Error while exporting the user's webhooks.", err.Status())
+	}
+	for i := range *webhooks {
+		(*webhooks)[i].Secret = ""
+	}
+	exported.Webhooks = *webhooks
+	return exported, nil
+}
+
+//leaveHousehold takes the user out of their household before they are erased, deleting it along with its food if
+//nobody else is in it, and otherwise making sure it still has an owner.
+func leaveHousehold(ctx context.Context, tx db.Repository, userID int) fcerr.FCErr {
+	member, err := tx.GetMembership(ctx, userID)
+	if errors.Is(err, fcerr.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	members, err := tx.GetHouseholdMembers(ctx, member.HouseholdID)
+	if err != nil {
+		return err
+	}
+
+	if len(*members) == 1 {
+		if err := tx.DeleteHouseholdFood(ctx, member.HouseholdID); err != nil {
+			return err
+		}
+		if err := tx.DeleteMembership(ctx, member.HouseholdID, userID); err != nil {
+			return err
+		}
+		return tx.DeleteHousehold(ctx, member.HouseholdID)
+	}
+
+	if member.IsOwner() && members.Owners() == 1 {
+		for _, next := range *members {
+			if next.UserID != userID {
+				fmt.Println("user", next.UserID, "is now an owner of household", member.HouseholdID)
+				next.Role = household.RoleOwner
+				if err := tx.UpdateMembership(ctx, next); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return tx.DeleteMembership(ctx, member.HouseholdID, userID)
+}
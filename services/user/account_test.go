@@ -0,0 +1,189 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/dish"
+	householdDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/household"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/identity"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/session"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/storage"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/webhook"
+	"github.com/jasonradcliffe/freshness-countdown-api/duration"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+	"github.com/stretchr/testify/assert"
+)
+
+//newAccountTest gives a user service over a memory repository holding a user with a fridge, a dish, a session, a
+//linked Alexa account and a webhook.
+func newAccountTest(t *testing.T) (Service, db.Repository, *user.User) {
+	ctx := context.Background()
+	repo := db.NewMemoryRepository()
+	bob, fcErr := repo.CreateUser(ctx, user.User{Email: "nothing@gmail.com", FirstName: "Bob", LastName: "Nothing",
+		FullName: "Bob Nothing", AccessToken: "ya29.bob", RefreshToken: "1//bob"})
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	home, fcErr := household.Membership(ctx, repo, bob)
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	if _, fcErr := repo.CreateStorage(ctx, storage.Storage{PersonalID: 1, UserID: bob.UserID, HouseholdID: home.HouseholdID,
+		Title: "Fridge"}); fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	if _, fcErr := repo.CreateDish(ctx, dish.Dish{PersonalDishID: 1, UserID: bob.UserID, HouseholdID: home.HouseholdID,
+		StorageID: 1, Title: "Carrots", ExpireDate: time.Now().Add(24 * time.Hour)}); fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	if _, fcErr := repo.CreateSession(ctx, session.Session{UserID: bob.UserID, TokenHash: session.HashToken("fcs_bob"),
+		CreatedDate: time.Now(), ExpireDate: time.Now().Add(time.Hour)}); fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	if _, fcErr := repo.CreateIdentity(ctx, identity.Identity{UserID: bob.UserID, Provider: identity.Alexa,
+		ExternalID: "amzn1.ask.account.BOB", RefreshTokenHash: session.HashToken("fcr_bob")}); fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	if _, fcErr := repo.CreateWebhook(ctx, webhook.Subscription{UserID: bob.UserID, URL: "https://homeassistant.local/fc",
+		Secret: "whsec_bob", Active: true, CreatedDate: time.Now()}); fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	return NewService(repo), repo, bob
+}
+
+func TestUser_UpdateProfile(t *testing.T) {
+	s, _, bob := newAccountTest(t)
+	ctx := context.Background()
+	on, days := true, 3
+
+	updated, err := s.UpdateProfile(ctx, bob.UserID, user.Profile{FirstName: "Robert", TimeZone: "America/Chicago",
+		NotifyExpiring: &on, NotifyDaysBefore: &days})
+	assert.Nil(t, err)
+	assert.Equal(t, "Robert", updated.FirstName)
+	assert.Equal(t, "Nothing", updated.LastName)
+	assert.Equal(t, "Robert Nothing", updated.FullName)
+	assert.Equal(t, "America/Chicago", updated.Location().String())
+	assert.True(t, updated.NotifyExpiring)
+	assert.Equal(t, 3, updated.NoticeDays())
+	assert.Equal(t, "ya29.bob", updated.AccessToken)
+
+	updated, err = s.UpdateProfile(ctx, bob.UserID, user.Profile{FullName: "Bobby"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Bobby", updated.FullName)
+	assert.Equal(t, "America/Chicago", updated.TimeZone, "what isn't given stays as it is")
+
+	tooMany := user.MaxNotifyDaysBefore + 1
+	_, err = s.UpdateProfile(ctx, bob.UserID, user.Profile{TimeZone: "Mars/Olympus_Mons", NotifyDaysBefore: &tooMany})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusBadRequest, err.Status())
+		assert.Equal(t, 2, len(err.Details()))
+	}
+	_, err = s.UpdateProfile(ctx, 99, user.Profile{FullName: "Nobody"})
+	assert.Equal(t, http.StatusNotFound, err.Status())
+}
+
+func TestUser_Erase(t *testing.T) {
+	s, repo, bob := newAccountTest(t)
+	ctx := context.Background()
+	home, _ := repo.GetMembership(ctx, bob.UserID)
+
+	assert.Nil(t, s.Erase(ctx, bob.UserID))
+	_, err := repo.GetUserByID(ctx, bob.UserID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	_, err = repo.GetDishes(ctx, home.HouseholdID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	_, err = repo.GetStorages(ctx, home.HouseholdID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	_, err = repo.GetHousehold(ctx, home.HouseholdID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	_, err = repo.GetSessionByTokenHash(ctx, session.HashToken("fcs_bob"))
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	_, err = repo.GetIdentity(ctx, identity.Alexa, "amzn1.ask.account.BOB")
+	assert.Equal(t, http.StatusNotFound, err.Status())
+	webhooks, _ := repo.GetWebhooks(ctx, bob.UserID)
+	assert.Equal(t, 0, len(*webhooks))
+
+	assert.Equal(t, http.StatusNotFound, s.Erase(ctx, bob.UserID).Status())
+}
+
+func TestUser_Erase_SharedHousehold(t *testing.T) {
+	s, repo, bob := newAccountTest(t)
+	ctx := context.Background()
+	sam, _ := repo.CreateUser(ctx, user.User{Email: "session@gmail.com"})
+	theirs, _ := household.Membership(ctx, repo, sam)
+	repo.DeleteMembership(ctx, theirs.HouseholdID, sam.UserID)
+	home, _ := repo.GetMembership(ctx, bob.UserID)
+	repo.CreateMembership(ctx, householdDomain.Member{HouseholdID: home.HouseholdID, UserID: sam.UserID,
+		Role: householdDomain.RoleMember, JoinedDate: time.Now()})
+
+	assert.Nil(t, s.Erase(ctx, bob.UserID))
+	members, _ := repo.GetHouseholdMembers(ctx, home.HouseholdID)
+	if assert.Equal(t, 1, len(*members)) {
+		assert.Equal(t, sam.UserID, (*members)[0].UserID)
+		assert.True(t, (*members)[0].IsOwner(), "the household still has an owner")
+	}
+	dishes, err := repo.GetDishes(ctx, home.HouseholdID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*dishes), "the food stays with the household")
+}
+
+func TestUser_ScheduleDeletion(t *testing.T) {
+	s, repo, bob := newAccountTest(t)
+	ctx := context.Background()
+	week, _ := duration.Parse("P7D")
+
+	scheduled, err := s.ScheduleDeletion(ctx, bob.UserID, week)
+	assert.Nil(t, err)
+	assert.True(t, scheduled.DeletionPending())
+	assert.WithinDuration(t, time.Now().Add(7*24*time.Hour), scheduled.DeletionDate, time.Minute)
+
+	none, _ := duration.Parse("P0D")
+	_, err = s.ScheduleDeletion(ctx, bob.UserID, none)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+	tooLong, _ := duration.Parse("P1Y")
+	_, err = s.ScheduleDeletion(ctx, bob.UserID, tooLong)
+	assert.Equal(t, http.StatusBadRequest, err.Status())
+
+	erased, err := s.EraseDue(ctx, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, erased, "not before the grace period is over")
+
+	kept, err := s.CancelDeletion(ctx, bob.UserID)
+	assert.Nil(t, err)
+	assert.False(t, kept.DeletionPending())
+	_, err = s.CancelDeletion(ctx, bob.UserID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+
+	s.ScheduleDeletion(ctx, bob.UserID, week)
+	erased, err = s.EraseDue(ctx, time.Now().Add(8*24*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, erased)
+	_, err = repo.GetUserByID(ctx, bob.UserID)
+	assert.Equal(t, http.StatusNotFound, err.Status())
+}
+
+func TestUser_Export(t *testing.T) {
+	s, _, bob := newAccountTest(t)
+
+	exported, err := s.Export(context.Background(), bob.UserID)
+	assert.Nil(t, err)
+	assert.Equal(t, bob.Email, exported.User.Email)
+	assert.Equal(t, "", exported.User.AccessToken)
+	assert.Equal(t, "", exported.User.RefreshToken)
+	if assert.NotNil(t, exported.Household) {
+		assert.Equal(t, 1, len(exported.Household.Members))
+	}
+	assert.Equal(t, 1, len(exported.Storages))
+	if assert.Equal(t, 1, len(exported.Dishes)) {
+		assert.Equal(t, "Carrots", exported.Dishes[0].Title)
+	}
+	assert.Equal(t, 1, len(exported.LinkedAccounts))
+	if assert.Equal(t, 1, len(exported.Webhooks)) {
+		assert.Equal(t, "", exported.Webhooks[0].Secret)
+	}
+}
@@ -0,0 +1,205 @@
+package user
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+)
+
+//DefaultTokenCacheTTL is how long a Google access token is trusted without asking Google again.
+//Google's tokens last an hour, so a revoked one is still honoured for at most this long.
+const DefaultTokenCacheTTL = 5 * time.Minute
+
+//tokenLookupTimeout is how long a lookup shared by concurrent requests for the same token can take. It isn't cut short
+//by the request that started it going away, since the others are still waiting on it.
+const tokenLookupTimeout = 10 * time.Second
+
+//DefaultTokenCacheSize is how many access tokens are remembered before the least recently used is dropped.
+const DefaultTokenCacheSize = 1000
+
+//CacheStats counts how the access token cache has been doing since the service started.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Shared    uint64 `json:"shared"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+}
+
+//HitRate is the share of lookups answered without a call to Google - cache hits, and requests that waited on
+//another request's call for the same token. It is 0 before any lookups.
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.Shared + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.Shared) / float64(total)
+}
+
+//tokenCache remembers which user an access token belongs to. Tokens are kept as their SHA-256, entries expire after
+//ttl, and past maxSize the least recently used one is dropped. Concurrent misses for the same token share one lookup.
+//Failed lookups aren't remembered.
+type tokenCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	now     func() time.Time
+
+	entries map[string]*list.Element
+	order   *list.List
+	calls   map[string]*tokenCall
+
+	stats CacheStats
+}
+
+type tokenEntry struct {
+	key     string
+	user    user.User
+	expires time.Time
+}
+
+//tokenCall is a lookup in progress - done is closed once user and err are set.
+type tokenCall struct {
+	done chan struct{}
+	user *user.User
+	err  fcerr.FCErr
+}
+
+func newTokenCache(ttl time.Duration, maxSize int) *tokenCache {
+	return &tokenCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		calls:   make(map[string]*tokenCall),
+	}
+}
+
+//get gives the user for token, calling lookup on a miss. A cache with no ttl or size just calls lookup with ctx.
+func (c *tokenCache) get(ctx context.Context, token string, lookup func(context.Context) (*user.User, fcerr.FCErr)) (*user.User, fcerr.FCErr) {
+	if c.ttl <= 0 || c.maxSize <= 0 {
+		return lookup(ctx)
+	}
+	key := tokenKey(token)
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*tokenEntry)
+		if c.now().Before(entry.expires) {
+			c.order.MoveToFront(element)
+			c.stats.Hits++
+			found := entry.user
+			c.mu.Unlock()
+			return &found, nil
+		}
+		c.remove(element)
+	}
+
+	if call, ok := c.calls[key]; ok {
+		c.stats.Shared++
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, fcerr.Wrap(ctx.Err(), "The request was cancelled or ran out of time while checking the access token", http.StatusGatewayTimeout)
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		found := *call.user
+		return &found, nil
+	}
+
+	call := &tokenCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	c.share(ctx, key, call, lookup)
+	if call.err != nil {
+		return nil, call.err
+	}
+	found := *call.user
+	return &found, nil
+}
+
+//share runs lookup for the requests waiting on call, under ctx without its cancellation and with tokenLookupTimeout,
+//then lets them have the result. The call is finished even if lookup panics, so later requests for the token don't
+//wait on it for ever - they get an error, and the panic carries on to the recovery middleware.
+func (c *tokenCache) share(ctx context.Context, key string, call *tokenCall, lookup func(context.Context) (*user.User, fcerr.FCErr)) {
+	defer func() {
+		if call.user == nil && call.err == nil {
+			call.err = fcerr.NewInternalServerError("Error while checking the access token")
+		}
+		c.mu.Lock()
+		delete(c.calls, key)
+		if call.err == nil {
+			c.add(key, *call.user)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenLookupTimeout)
+	defer cancel()
+	call.user, call.err = lookup(lookupCtx)
+}
+
+//forgetUser drops every token for the user, so a change to them isn't hidden behind a cached copy.
+func (c *tokenCache) forgetUser(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*tokenEntry).user.UserID == userID {
+			c.remove(element)
+		}
+		element = next
+	}
+}
+
+//snapshot gives the counts so far. A nil cache has none.
+func (c *tokenCache) snapshot() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.order.Len()
+	return stats
+}
+
+//add remembers u for key, making room if the cache is full. Callers hold mu.
+func (c *tokenCache) add(key string, u user.User) {
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	for c.order.Len() >= c.maxSize {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+	c.entries[key] = c.order.PushFront(&tokenEntry{key: key, user: u, expires: c.now().Add(c.ttl)})
+}
+
+//remove drops one entry. Callers hold mu.
+func (c *tokenCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*tokenEntry).key)
+}
+
+//tokenKey is what a token is cached under, so the cache doesn't hold usable tokens.
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,267 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	dbrepo "github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+//countingClient answers every userinfo call with body, counting the calls.
+func countingClient(body string, calls *int32) (*Client, func()) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		w.Write([]byte(body))
+	})
+	httpClient, teardown := testHTTPClient(h)
+
+	client := NewClient()
+	client.httpClient = httpClient
+	return client, teardown
+}
+
+func TestUser_TokenCache_Hit(t *testing.T) {
+	var calls int32
+	client, teardown := countingClient(googleAPIOKResponse, &calls)
+	defer teardown()
+	userService := NewService(dbrepo.NewMemoryRepository())
+
+	first, err := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	assert.Nil(t, err)
+	second, err := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	assert.Nil(t, err)
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	stats := userService.TokenCacheStats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 1, stats.Size)
+	assert.Equal(t, 0.5, stats.HitRate())
+
+	//the caller's copy can be changed without changing the cache
+	first.Email = "changed@gmail.com"
+	third, _ := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	assert.Equal(t, nU.Email, third.Email)
+}
+
+func TestUser_TokenCache_Expires(t *testing.T) {
+	var calls int32
+	client, teardown := countingClient(googleAPIOKResponse, &calls)
+	defer teardown()
+	userService := NewServiceWithTokenCache(dbrepo.NewMemoryRepository(), time.Minute, 10).(*service)
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	userService.tokens.now = func() time.Time { return now }
+
+	userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	now = now.Add(59 * time.Second)
+	userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	now = now.Add(time.Second)
+	userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestUser_TokenCache_SizeLimit(t *testing.T) {
+	var calls int32
+	client, teardown := countingClient(googleAPIOKResponse, &calls)
+	defer teardown()
+	userService := NewServiceWithTokenCache(dbrepo.NewMemoryRepository(), time.Minute, 2)
+
+	userService.GetOrCreateByAccessToken(context.Background(), "token-a", client)
+	userService.GetOrCreateByAccessToken(context.Background(), "token-b", client)
+	userService.GetOrCreateByAccessToken(context.Background(), "token-a", client)
+	userService.GetOrCreateByAccessToken(context.Background(), "token-c", client)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	//token-b was the least recently used, so it was the one dropped
+	userService.GetOrCreateByAccessToken(context.Background(), "token-a", client)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	userService.GetOrCreateByAccessToken(context.Background(), "token-b", client)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	stats := userService.TokenCacheStats()
+	assert.Equal(t, 2, stats.Size)
+	assert.Equal(t, uint64(2), stats.Evictions)
+}
+
+func TestUser_TokenCache_FailuresNotCached(t *testing.T) {
+	var calls int32
+	client, teardown := countingClient(googleAPINotVerifiedErrorResponse, &calls)
+	defer teardown()
+	userService := NewService(dbrepo.NewMemoryRepository())
+
+	_, err := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	assert.NotNil(t, err)
+	_, err = userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, 0, userService.TokenCacheStats().Size)
+}
+
+func TestUser_TokenCache_ConcurrentLookupsShareOneCall(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Write([]byte(googleAPIOKResponse))
+	})
+	httpClient, teardown := testHTTPClient(h)
+	defer teardown()
+	client := NewClient()
+	client.httpClient = httpClient
+
+	userService := NewService(dbrepo.NewMemoryRepository())
+
+	const requests = 10
+	var wg sync.WaitGroup
+	results := make([]*userDomain.User, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+		}(i)
+	}
+
+	//hold Google's answer until every other request is waiting on the first one
+	deadline := time.Now().Add(5 * time.Second)
+	for userService.TokenCacheStats().Shared < requests-1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, u := range results {
+		if assert.NotNil(t, u) {
+			assert.Equal(t, nU.Email, u.Email)
+		}
+	}
+	assert.Equal(t, 0.9, userService.TokenCacheStats().HitRate())
+}
+
+func TestUser_TokenCache_SharedLookupOutlivesTheFirstRequest(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Write([]byte(googleAPIOKResponse))
+	})
+	httpClient, teardown := testHTTPClient(h)
+	defer teardown()
+	client := NewClient()
+	client.httpClient = httpClient
+
+	userService := NewService(dbrepo.NewMemoryRepository())
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		userService.GetOrCreateByAccessToken(firstCtx, nU.AccessToken, client)
+	}()
+	for userService.TokenCacheStats().Misses < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	var waiter *userDomain.User
+	go func() {
+		defer wg.Done()
+		waiter, _ = userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	}()
+	for userService.TokenCacheStats().Shared < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	//the client that started the lookup goes away before Google answers
+	cancelFirst()
+	close(release)
+	wg.Wait()
+
+	if assert.NotNil(t, waiter, "the request waiting on the lookup still gets the user") {
+		assert.Equal(t, nU.Email, waiter.Email)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestUser_TokenCache_LookupPanics(t *testing.T) {
+	cache := newTokenCache(time.Minute, 10)
+
+	func() {
+		defer func() {
+			assert.NotNil(t, recover(), "the panic carries on to the recovery middleware")
+		}()
+		cache.get(context.Background(), nU.AccessToken, func(context.Context) (*userDomain.User, fcerr.FCErr) {
+			panic("lookup failed")
+		})
+	}()
+
+	//the next request for the token looks it up again, rather than waiting on the call that panicked
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	found, err := cache.get(ctx, nU.AccessToken, func(context.Context) (*userDomain.User, fcerr.FCErr) {
+		return &userDomain.User{UserID: 3}, nil
+	})
+	assert.Nil(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, 3, found.UserID)
+	}
+}
+
+func TestUser_TokenCache_ForgetsUpdatedUser(t *testing.T) {
+	var calls int32
+	client, teardown := countingClient(googleAPIOKResponse, &calls)
+	defer teardown()
+	userService := NewService(dbrepo.NewMemoryRepository())
+
+	cached, _ := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	_, err := userService.SaveTokens(context.Background(), *cached, "google",
+		&oauth2.Token{AccessToken: nU.AccessToken, RefreshToken: "new-refresh-token"})
+	assert.Nil(t, err)
+
+	updated, _ := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	assert.Equal(t, "new-refresh-token", updated.RefreshToken)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestUser_TokenCache_Disabled(t *testing.T) {
+	var calls int32
+	client, teardown := countingClient(googleAPIOKResponse, &calls)
+	defer teardown()
+	userService := NewServiceWithTokenCache(dbrepo.NewMemoryRepository(), 0, 0)
+
+	userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestUser_TokenCache_ForgetsDeactivatedUser(t *testing.T) {
+	var calls int32
+	client, teardown := countingClient(googleAPIOKResponse, &calls)
+	defer teardown()
+	userService := NewService(dbrepo.NewMemoryRepository())
+
+	cached, _ := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	deactivated, err := userService.SetActive(context.Background(), cached.UserID, false)
+	assert.Nil(t, err)
+	assert.False(t, deactivated.IsActive())
+
+	again, _ := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
+	assert.False(t, again.IsActive(), "the cached user isn't handed out once deactivated")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -1,27 +1,40 @@
 package user
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/duration"
 	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
 
 	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc"
+	"golang.org/x/oauth2"
 )
 
 //Service is the interface that defines the contract for a dish service.
 type Service interface {
-	GetByID(int) (*user.User, fcerr.FCErr)
-	GetByEmail(string) (*user.User, fcerr.FCErr)
-	GetByAlexaID(string) (*user.User, fcerr.FCErr)
-	GetOrCreateByAccessToken(string, *Client) (*user.User, fcerr.FCErr)
-	Create(u user.OauthUser, aT string, rT string) (*user.User, fcerr.FCErr)
-	UpdateAlexaID(user.User, string) (*user.User, fcerr.FCErr)
+	GetByID(context.Context, int) (*user.User, fcerr.FCErr)
+	GetByEmail(context.Context, string) (*user.User, fcerr.FCErr)
+	List(context.Context, string) (*user.Users, fcerr.FCErr)
+	SetActive(context.Context, int, bool) (*user.User, fcerr.FCErr)
+	UpdateProfile(context.Context, int, user.Profile) (*user.User, fcerr.FCErr)
+	ScheduleDeletion(context.Context, int, duration.Duration) (*user.User, fcerr.FCErr)
+	CancelDeletion(context.Context, int) (*user.User, fcerr.FCErr)
+	Erase(context.Context, int) fcerr.FCErr
+	EraseDue(context.Context, time.Time) (int, fcerr.FCErr)
+	Export(context.Context, int) (*Export, fcerr.FCErr)
+	GetOrCreateByAccessToken(context.Context, string, *Client) (*user.User, fcerr.FCErr)
+	Create(ctx context.Context, u user.OauthUser, aT string, rT string) (*user.User, fcerr.FCErr)
+	SaveTokens(ctx context.Context, u user.User, provider string, token *oauth2.Token) (*user.User, fcerr.FCErr)
+	FreshAccessToken(context.Context, *user.User) (string, fcerr.FCErr)
+	TokenCacheStats() CacheStats
 }
 
 //Client can be pointed to real http.Client or mocked
@@ -31,12 +44,29 @@ type Client struct {
 
 type service struct {
 	repository db.Repository
+	tokens     *tokenCache
+	providers  *oidc.Providers
 }
 
-//NewService takes a database repository and gives you a new Service instance.
+//NewService takes a database repository and gives you a new Service instance, caching Google access tokens
+//for DefaultTokenCacheTTL.
 func NewService(repo db.Repository) Service {
+	return NewServiceWithTokenCache(repo, DefaultTokenCacheTTL, DefaultTokenCacheSize)
+}
+
+//NewServiceWithTokenCache is NewService with the access token cache's ttl and size given. A ttl or size of 0 turns the cache off.
+func NewServiceWithTokenCache(repo db.Repository, ttl time.Duration, size int) Service {
+	return NewServiceWithProviders(repo, nil, ttl, size)
+}
+
+//NewServiceWithProviders is NewServiceWithTokenCache with the identity providers users sign in with. Access tokens are
+//checked with the default provider, and stored tokens are refreshed with the provider that issued them.
+//Without providers, access tokens are checked with Google and FreshAccessToken can't refresh an expired token.
+func NewServiceWithProviders(repo db.Repository, providers *oidc.Providers, ttl time.Duration, size int) Service {
 	return &service{
 		repository: repo,
+		tokens:     newTokenCache(ttl, size),
+		providers:  providers,
 	}
 }
 
@@ -49,73 +79,94 @@ func NewClient() *Client {
 }
 
 //GetByID gets a user from the database with the given ID
-func (s *service) GetByID(id int) (*user.User, fcerr.FCErr) {
-	receivedUser, err := s.repository.GetUserByID(id)
+func (s *service) GetByID(ctx context.Context, id int) (*user.User, fcerr.FCErr) {
+	receivedUser, err := s.repository.GetUserByID(ctx, id)
 	if err != nil && err.Status() == http.StatusNotFound {
-		return nil, fcerr.NewNotFoundError("Could not find this user in the system.")
+		return nil, fcerr.Wrap(err, "Could not find this user in the system.", http.StatusNotFound)
 	} else if err != nil {
-		return nil, fcerr.NewInternalServerError("Error while retrieving the user.")
+		return nil, fcerr.Wrap(err, "Error while retrieving the user.", http.StatusInternalServerError)
 	}
 
 	return receivedUser, nil
 }
 
 //GetByEmail gets a user from the database with the given email address
-func (s *service) GetByEmail(email string) (*user.User, fcerr.FCErr) {
-	receivedUser, err := s.repository.GetUserByEmail(email)
+func (s *service) GetByEmail(ctx context.Context, email string) (*user.User, fcerr.FCErr) {
+	receivedUser, err := s.repository.GetUserByEmail(ctx, email)
 	if err != nil && err.Status() == http.StatusNotFound {
 		fmt.Println("Could not find this user in the system.")
-		fcerr := fcerr.NewNotFoundError("Could not find this user in the system.")
+		fcerr := fcerr.Wrap(err, "Could not find this user in the system.", http.StatusNotFound)
 		return nil, fcerr
 	} else if err != nil {
-		fcerr := fcerr.NewInternalServerError("Error while retrieving the user.")
+		fcerr := fcerr.Wrap(err, "Error while retrieving the user.", http.StatusInternalServerError)
 		return nil, fcerr
 	}
 
 	return receivedUser, nil
 }
 
-//GetByAlexaID gets a user from the database with the given alexa user id
-func (s *service) GetByAlexaID(alexaID string) (*user.User, fcerr.FCErr) {
-	receivedUser, err := s.repository.GetUserByAlexa(alexaID)
-	if err != nil && err.Status() == http.StatusNotFound {
-		fmt.Println("Could not find this user in the system.")
-		fcerr := fcerr.NewNotFoundError("Could not find this user in the system.")
-		return nil, fcerr
+//List(search string) gets every user, or those whose email or name contains the search, by id. No users is an empty list.
+func (s *service) List(ctx context.Context, search string) (*user.Users, fcerr.FCErr) {
+	users, err := s.repository.GetUsers(ctx, strings.TrimSpace(search))
+	if err != nil && errors.Is(err, fcerr.ErrNotFound) {
+		return &user.Users{}, nil
 	} else if err != nil {
-		fcerr := fcerr.NewInternalServerError("Error while retrieving the user.")
-		return nil, fcerr
+		return nil, fcerr.Wrap(err, "Error while retrieving the users.", err.Status())
 	}
-	return receivedUser, nil
+	return users, nil
 }
 
-//GetOrCreateByAccessToken gets a user from the database with the given access token
-func (s *service) GetOrCreateByAccessToken(aT string, client *Client) (*user.User, fcerr.FCErr) {
-
-	var currentUser user.OauthUser
-
-	req, err := http.NewRequest("GET", "https://openidconnect.googleapis.com/v1/userinfo?access_token="+aT, nil)
+//SetActive(id int, active bool) deactivates the user with the given id, or reactivates them. A deactivated user keeps
+//their household and food, but can't sign in or use a token until they are reactivated.
+func (s *service) SetActive(ctx context.Context, id int, active bool) (*user.User, fcerr.FCErr) {
+	current, err := s.GetByID(ctx, id)
 	if err != nil {
-		return nil, fcerr.NewInternalServerError("Error when setting up the network request")
+		return nil, err
+	}
+	if current.IsActive() == active {
+		return current, nil
 	}
 
-	response, err := client.httpClient.Do(req)
-	if err != nil {
-		fmt.Println("error when getting the userinfo with the access token")
-		return nil, fcerr.NewInternalServerError("Error when trying to verify user identity")
+	deactivated := time.Time{}
+	if !active {
+		deactivated = time.Now()
+	}
+	fmt.Println("setting user", id, "active:", active)
+	if err := s.repository.SetUserDeactivated(ctx, id, deactivated); err != nil {
+		return nil, fcerr.Wrap(err, "Error while deactivating the user.", err.Status())
 	}
+	s.tokens.forgetUser(id)
 
-	defer response.Body.Close()
+	return s.GetByID(ctx, id)
+}
 
-	contents, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, fcerr.NewInternalServerError("Error when trying to read response from Google about user identity")
+//GetOrCreateByAccessToken gets a user from the database with the given access token. The identity provider is only asked
+//who the token belongs to when it isn't in the token cache.
+func (s *service) GetOrCreateByAccessToken(ctx context.Context, aT string, client *Client) (*user.User, fcerr.FCErr) {
+	return s.tokens.get(ctx, aT, func(lookupCtx context.Context) (*user.User, fcerr.FCErr) {
+		return s.lookupAccessToken(lookupCtx, aT, client)
+	})
+}
+
+//TokenCacheStats gives the access token cache's counts, for its hit rate.
+func (s *service) TokenCacheStats() CacheStats {
+	return s.tokens.snapshot()
+}
+
+//lookupAccessToken asks the default identity provider who the access token belongs to, and finds or adds that user
+//in the database.
+func (s *service) lookupAccessToken(ctx context.Context, aT string, client *Client) (*user.User, fcerr.FCErr) {
+
+	userInfoURL := oidc.GoogleUserInfoURL
+	if provider := s.providers.Default(); provider != nil && provider.UserInfoURL() != "" {
+		userInfoURL = provider.UserInfoURL()
 	}
 
-	err = json.Unmarshal(contents, &currentUser)
-	if err != nil {
-		return nil, fcerr.NewInternalServerError("Could not Unmarshal the data received from the AccessToken request into a valid user.")
+	claims, fcErr := oidc.FetchUserInfo(ctx, client.httpClient, userInfoURL, aT)
+	if fcErr != nil {
+		return nil, fcErr
 	}
+	currentUser := claims.OauthUser()
 	fmt.Println("Here is the current User we are fetching with access token:", currentUser)
 
 	if currentUser.VerifiedEmail == false {
@@ -125,12 +176,12 @@ func (s *service) GetOrCreateByAccessToken(aT string, client *Client) (*user.Use
 
 	fmt.Println("Got a verified user!!!!!!", currentUser)
 
-	dbUser, err2 := s.GetByEmail(currentUser.Email)
+	dbUser, err2 := s.GetByEmail(ctx, currentUser.Email)
 	if err2 != nil && err2.Status() == http.StatusNotFound {
 		fmt.Println("We could not find this user in the database! (We should add them!?!)")
-		newUser, err := s.Create(currentUser, aT, "")
+		newUser, err := s.Create(ctx, currentUser, aT, "")
 		if err != nil {
-			return nil, fcerr.NewInternalServerError("Attempted to add the user to the database, but something went wrong.")
+			return nil, fcerr.Wrap(err, "Attempted to add the user to the database, but something went wrong.", http.StatusInternalServerError)
 		}
 		fmt.Println("User has been added. New User ID:" + strconv.Itoa(newUser.UserID))
 		return newUser, nil
@@ -141,11 +192,11 @@ func (s *service) GetOrCreateByAccessToken(aT string, client *Client) (*user.Use
 	}
 
 	fmt.Println("We already have this user!!! database user id:", dbUser)
-	return dbUser, nil
+	return s.withFreshTokens(ctx, dbUser), nil
 
 }
 
-func (s *service) Create(u user.OauthUser, aT string, rT string) (*user.User, fcerr.FCErr) {
+func (s *service) Create(ctx context.Context, u user.OauthUser, aT string, rT string) (*user.User, fcerr.FCErr) {
 	var newUser user.User
 
 	timeNow := time.Now().In(time.UTC)
@@ -159,34 +210,93 @@ func (s *service) Create(u user.OauthUser, aT string, rT string) (*user.User, fc
 	newUser.AccessToken = aT
 	newUser.RefreshToken = rT
 
-	receivedUser, err := s.repository.CreateUser(newUser)
+	receivedUser, err := s.repository.CreateUser(ctx, newUser)
 	if err != nil {
 		fmt.Println("the user service could not create the new user")
-		fcerr := fcerr.NewInternalServerError("the user service could not create the new user")
+		fcerr := fcerr.Wrap(err, "the user service could not create the new user", http.StatusInternalServerError)
 		return nil, fcerr
 	}
 
 	return receivedUser, nil
 }
 
-//UpdateAlexaID (u user.User, alexaID string) takes a user and a string and sets the alexaUserID equal to the given string in the database
-func (s *service) UpdateAlexaID(u user.User, alexaID string) (*user.User, fcerr.FCErr) {
-	newUser := &user.User{
-		UserID:       u.UserID,
-		Email:        u.Email,
-		FirstName:    u.FirstName,
-		LastName:     u.LastName,
-		FullName:     u.FullName,
-		CreatedDate:  u.CreatedDate,
-		AccessToken:  u.AccessToken,
-		RefreshToken: u.RefreshToken,
-		AlexaUserID:  alexaID,
-		TempMatch:    u.TempMatch,
-	}
-	updatedUser, err := s.repository.UpdateUser(*newUser)
+//SaveTokens(u user.User, provider string, token *oauth2.Token) stores the tokens the named identity provider gave the
+//user at login or on a refresh. Providers like Google only send a refresh token the first time a user consents, so an
+//empty one keeps the refresh token already stored.
+func (s *service) SaveTokens(ctx context.Context, u user.User, provider string, token *oauth2.Token) (*user.User, fcerr.FCErr) {
+	if provider != u.TokenProvider && token.RefreshToken == "" {
+		//the stored refresh token is from another provider, so it can't refresh this one's access token
+		u.RefreshToken = ""
+	}
+	u.TokenProvider = provider
+	u.AccessToken = token.AccessToken
+	if token.RefreshToken != "" {
+		u.RefreshToken = token.RefreshToken
+	}
+	u.TokenExpiry = token.Expiry.UTC().Truncate(time.Second)
+
+	updatedUser, err := s.repository.UpdateUser(ctx, u)
 	if err != nil {
-		return nil, fcerr.NewInternalServerError("Error when updating the user with Alexa ID")
+		return nil, fcerr.Wrap(err, "Error when saving the user's tokens", http.StatusInternalServerError)
 	}
+	s.tokens.forgetUser(u.UserID)
 
 	return updatedUser, nil
 }
+
+//FreshAccessToken(u *user.User) gives an access token for the user that hasn't expired, refreshing it with the stored
+//refresh token - and storing what the provider rotates it to - when it has. It is a 401 when the user has to log in again.
+func (s *service) FreshAccessToken(ctx context.Context, u *user.User) (string, fcerr.FCErr) {
+	fresh, fcErr := s.refreshTokens(ctx, u)
+	if fcErr != nil {
+		return "", fcErr
+	}
+	return fresh.AccessToken, nil
+}
+
+//withFreshTokens is the user with their stored provider tokens refreshed if they have expired, so the sign in they
+//gave at login keeps working while they use the api. A user without a refresh token is left as they are, and so is one
+//whose tokens can't be refreshed - the request was still made with a token the provider accepted.
+func (s *service) withFreshTokens(ctx context.Context, u *user.User) *user.User {
+	if u.RefreshToken == "" {
+		return u
+	}
+	fresh, fcErr := s.refreshTokens(ctx, u)
+	if fcErr != nil {
+		fmt.Println("could not refresh the stored tokens of user", u.UserID, "-", fcErr.Message())
+		return u
+	}
+	return fresh
+}
+
+//refreshTokens is FreshAccessToken giving the whole user, as stored after any refresh.
+func (s *service) refreshTokens(ctx context.Context, u *user.User) (*user.User, fcerr.FCErr) {
+	expiry := u.TokenExpiry
+	if expiry.IsZero() {
+		//tokens stored before their expiry was kept are treated as expired, so they get refreshed once
+		expiry = time.Unix(1, 0)
+	}
+	current := &oauth2.Token{AccessToken: u.AccessToken, RefreshToken: u.RefreshToken, TokenType: "Bearer", Expiry: expiry}
+	if current.Valid() {
+		return u, nil
+	}
+	provider, ok := s.providers.Get(u.TokenProvider)
+	if !ok || u.RefreshToken == "" {
+		return nil, fcerr.NewUnauthorizedError("The sign in for this user has expired, please log in again")
+	}
+
+	refreshed, err := provider.TokenSource(ctx, current).Token()
+	if err != nil {
+		fmt.Println("could not refresh the", provider.Name(), "token for user", u.UserID, ":", err.Error())
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) {
+			return nil, fcerr.Wrap(err, provider.DisplayName()+" would not refresh the sign in for this user, please log in again", http.StatusUnauthorized)
+		}
+		if ctx.Err() != nil {
+			return nil, fcerr.Wrap(ctx.Err(), "The request was cancelled or ran out of time while refreshing the sign in", http.StatusGatewayTimeout)
+		}
+		return nil, fcerr.Wrap(err, "Could not reach "+provider.DisplayName()+" to refresh the sign in", http.StatusBadGateway)
+	}
+
+	return s.SaveTokens(ctx, *u, provider.Name(), refreshed)
+}
@@ -17,7 +17,10 @@ import (
 	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
 	dbrepo "github.com/jasonradcliffe/freshness-countdown-api/repository/db"
 
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc"
+	"github.com/jasonradcliffe/freshness-countdown-api/oidc/oidctest"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
 )
 
 const googleAPIOKResponse = `{
@@ -61,7 +64,6 @@ var nU = &userDomain.User{
 	CreatedDate:  "2016-01-02T15:04:05",
 	AccessToken:  "ya33.a0Ae4lvC1iHeKSDRdQ542I-lEy8LHUU7-9r-k",
 	RefreshToken: "105i7nDY0JDTJmCgYIAQDKJSNwF-L9IrRgJ4-fM",
-	AlexaUserID:  "qwertyuiop",
 	Admin:        false,
 	TempMatch:    "1v842d234523a",
 }
@@ -132,13 +134,14 @@ func TestUser_GetByID(t *testing.T) {
 	userService := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
+			nU.AccessToken, nU.RefreshToken, nU.Admin, nU.TempMatch, "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetUserByIDBase, nU.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(dbrepo.GetUserByIDQuery).WithArgs(nU.UserID).WillReturnRows(rows)
 
-	resultingUser, err := userService.GetByID(nU.UserID)
+	resultingUser, err := userService.GetByID(context.Background(), nU.UserID)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, resultingUser)
@@ -160,11 +163,12 @@ func TestUser_GetByID_NotFound(t *testing.T) {
 	userService := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"})
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"})
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetUserByIDBase, nU.UserID)).WillReturnRows(rows)
+	mock.ExpectQuery(dbrepo.GetUserByIDQuery).WithArgs(nU.UserID).WillReturnRows(rows)
 
-	resultingUser, err := userService.GetByID(nU.UserID)
+	resultingUser, err := userService.GetByID(context.Background(), nU.UserID)
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -185,9 +189,9 @@ func TestUser_GetByID_Error(t *testing.T) {
 
 	userService := NewService(repo)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetUserByIDBase, nU.UserID)).WillReturnError(errors.New("database error"))
+	mock.ExpectQuery(dbrepo.GetUserByIDQuery).WithArgs(nU.UserID).WillReturnError(errors.New("database error"))
 
-	resultingUser, err := userService.GetByID(nU.UserID)
+	resultingUser, err := userService.GetByID(context.Background(), nU.UserID)
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -209,13 +213,14 @@ func TestUser_GetByEmail(t *testing.T) {
 	userService := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
+			nU.AccessToken, nU.RefreshToken, nU.Admin, nU.TempMatch, "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetUserByEmailBase, nU.Email)).WillReturnRows(rows)
+	mock.ExpectQuery(dbrepo.GetUserByEmailQuery).WithArgs(nU.Email).WillReturnRows(rows)
 
-	resultingUser, err := userService.GetByEmail(nU.Email)
+	resultingUser, err := userService.GetByEmail(context.Background(), nU.Email)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, resultingUser)
@@ -237,11 +242,12 @@ func TestUser_GetByEmail_NotFound(t *testing.T) {
 	userService := NewService(repo)
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"})
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"})
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetUserByEmailBase, nU.Email)).WillReturnRows(rows)
+	mock.ExpectQuery(dbrepo.GetUserByEmailQuery).WithArgs(nU.Email).WillReturnRows(rows)
 
-	resultingUser, err := userService.GetByEmail(nU.Email)
+	resultingUser, err := userService.GetByEmail(context.Background(), nU.Email)
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -262,86 +268,9 @@ func TestUser_GetByEmail_Error(t *testing.T) {
 
 	userService := NewService(repo)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetUserByEmailBase, nU.Email)).WillReturnError(errors.New("database error"))
+	mock.ExpectQuery(dbrepo.GetUserByEmailQuery).WithArgs(nU.Email).WillReturnError(errors.New("database error"))
 
-	resultingUser, err := userService.GetByEmail(nU.Email)
-
-	assert.Nil(t, resultingUser)
-	assert.NotNil(t, err)
-	assert.Equal(t, http.StatusInternalServerError, err.Status())
-}
-
-func TestUser_GetByAlexa(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	if testerr != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
-	}
-	defer db.Close()
-
-	repo, err := dbrepo.NewRepositoryWithDB(db)
-	if err != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, err)
-	}
-
-	userService := NewService(repo)
-
-	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
-		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
-
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetUserByAlexaBase, nU.AlexaUserID)).WillReturnRows(rows)
-
-	resultingUser, err := userService.GetByAlexaID(nU.AlexaUserID)
-
-	assert.Nil(t, err)
-	assert.NotNil(t, resultingUser)
-	assert.Exactly(t, nU, resultingUser)
-}
-
-func TestUser_GetByAlexa_NotFound(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	if testerr != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
-	}
-	defer db.Close()
-
-	repo, err := dbrepo.NewRepositoryWithDB(db)
-	if err != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, err)
-	}
-
-	userService := NewService(repo)
-
-	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"})
-
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetUserByAlexaBase, nU.AlexaUserID)).WillReturnRows(rows)
-
-	resultingUser, err := userService.GetByAlexaID(nU.AlexaUserID)
-
-	assert.Nil(t, resultingUser)
-	assert.NotNil(t, err)
-	assert.Equal(t, http.StatusNotFound, err.Status())
-}
-
-func TestUser_GetByAlexa_Error(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	if testerr != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
-	}
-	defer db.Close()
-
-	repo, err := dbrepo.NewRepositoryWithDB(db)
-	if err != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, err)
-	}
-
-	userService := NewService(repo)
-
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetUserByAlexaBase, nU.AlexaUserID)).WillReturnError(errors.New("database error"))
-
-	resultingUser, err := userService.GetByAlexaID(nU.AlexaUserID)
+	resultingUser, err := userService.GetByEmail(context.Background(), nU.Email)
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -372,13 +301,14 @@ func TestUser_GetOrCreateByAccessToken(t *testing.T) {
 	client.httpClient = httpClient
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
+			nU.AccessToken, nU.RefreshToken, nU.Admin, nU.TempMatch, "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(`SELECT \* FROM user WHERE email = ".+"`)).WillReturnRows(rows)
+	mock.ExpectQuery(fmt.Sprintf(`SELECT .+ FROM user WHERE email = \?`)).WillReturnRows(rows)
 
-	resultingUser, err := userService.GetOrCreateByAccessToken(nU.AccessToken, client)
+	resultingUser, err := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, resultingUser)
@@ -408,7 +338,7 @@ func TestUser_GetOrCreateByAccessToken_ResponseUnmarshalError(t *testing.T) {
 	client := NewClient()
 	client.httpClient = httpClient
 
-	resultingUser, err := userService.GetOrCreateByAccessToken(nU.AccessToken, client)
+	resultingUser, err := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -441,7 +371,7 @@ func TestUser_GetOrCreateByAccessToken_AccessTokenThrowsURLError(t *testing.T) {
 	badAccessToken := nU.AccessToken
 	badAccessToken += string([]byte{0x7f})
 
-	resultingUser, err := userService.GetOrCreateByAccessToken(badAccessToken, client)
+	resultingUser, err := userService.GetOrCreateByAccessToken(context.Background(), badAccessToken, client)
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -474,7 +404,7 @@ func TestUser_GetOrCreateByAccessToken_RequestError(t *testing.T) {
 
 	client.httpClient.Timeout = time.Microsecond * 20
 
-	resultingUser, err := userService.GetOrCreateByAccessToken(nU.AccessToken, client)
+	resultingUser, err := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -499,7 +429,7 @@ func TestUser_GetOrCreateByAccessToken_OauthResponseReadError(t *testing.T) {
 		httpClient: &http.Client{Transport: respondWithReader{body: failReader(0)}},
 	}
 
-	resultingUser, err := userService.GetOrCreateByAccessToken(nU.AccessToken, c)
+	resultingUser, err := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, c)
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -529,7 +459,7 @@ func TestUser_GetOrCreateByAccessToken_NonVerifiedUser(t *testing.T) {
 	client := NewClient()
 	client.httpClient = httpClient
 
-	resultingUser, err := userService.GetOrCreateByAccessToken(nU.AccessToken, client)
+	resultingUser, err := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -559,24 +489,24 @@ func TestUser_GetOrCreateByAccessToken_NewUserAdded(t *testing.T) {
 	client := NewClient()
 	client.httpClient = httpClient
 
-	createRows := sqlmock.NewRows([]string{""})
-
 	getRows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
+			nU.AccessToken, nU.RefreshToken, nU.Admin, nU.TempMatch, "", "", "", "", false, 0, "", "", "")
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"})
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"})
 
-	mock.ExpectQuery(fmt.Sprintf(`SELECT \* FROM user WHERE email = ".+"`)).WillReturnRows(rows)
+	mock.ExpectQuery(fmt.Sprintf(`SELECT .+ FROM user WHERE email = \?`)).WillReturnRows(rows)
 
-	mock.ExpectQuery(`INSERT INTO user \(.+\) VALUES\(".+", ".+", ".+", ".+", ".+", ".*", ".*", false, ".*"\)`).
-		WillReturnRows(createRows)
+	mock.ExpectExec(`INSERT INTO user \(.+\) VALUES\(.+\)`).WithArgs(nU.Email, nU.FirstName, nU.LastName, nU.FullName, sqlmock.AnyArg(),
+		nU.AccessToken, sqlmock.AnyArg(), false, sqlmock.AnyArg(), "", "", "", false, 0, "", "").WillReturnResult(sqlmock.NewResult(int64(nU.UserID), 1))
 
-	mock.ExpectQuery(`SELECT \* FROM user WHERE temp_match = ".+"`).WillReturnRows(getRows)
+	mock.ExpectQuery(`SELECT .+ FROM user WHERE temp_match = \?`).WillReturnRows(getRows)
 
-	resultingUser, err := userService.GetOrCreateByAccessToken(nU.AccessToken, client)
+	resultingUser, err := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, resultingUser)
@@ -609,22 +539,24 @@ func TestUser_GetOrCreateByAccessToken_NewUserFailedtoAdd(t *testing.T) {
 	//createRows := sqlmock.NewRows([]string{""})
 
 	/*getRows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-	"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+	"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+	"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 	AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-		nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
+		nU.AccessToken, nU.RefreshToken, nU.Admin, nU.TempMatch, "", "", "", "", false, 0, "", "", "")
 	*/
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"})
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"})
 
-	mock.ExpectQuery(fmt.Sprintf(`SELECT \* FROM user WHERE email = ".+"`)).WillReturnRows(rows)
+	mock.ExpectQuery(fmt.Sprintf(`SELECT .+ FROM user WHERE email = \?`)).WillReturnRows(rows)
 
-	mock.ExpectQuery(`INSERT INTO user \(.+\) VALUES\(".+", ".+", ".+", ".+", ".+", ".*", ".*", false, ".*"\)`).
-		WillReturnError(errors.New("Database Error"))
+	mock.ExpectExec(`INSERT INTO user \(.+\) VALUES\(.+\)`).WithArgs(nU.Email, nU.FirstName, nU.LastName, nU.FullName, sqlmock.AnyArg(),
+		nU.AccessToken, sqlmock.AnyArg(), false, sqlmock.AnyArg(), "", "", "", false, 0, "", "").WillReturnError(errors.New("Database Error"))
 
-	//mock.ExpectQuery(`SELECT \* FROM user WHERE temp_match = ".+"`).WillReturnRows(getRows)
+	//mock.ExpectQuery(`SELECT .+ FROM user WHERE temp_match = \?`).WillReturnRows(getRows)
 
-	resultingUser, err := userService.GetOrCreateByAccessToken(nU.AccessToken, client)
+	resultingUser, err := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -655,12 +587,13 @@ func TestUser_GetOrCreateByAccessToken_RetrieveEmptySet(t *testing.T) {
 	client.httpClient = httpClient
 
 	rows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
-		AddRow(0, "", "", "", "", "", "", "", "", "", "")
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
+		AddRow(0, "", "", "", "", "", "", "", "", "", "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(fmt.Sprintf(`SELECT \* FROM user WHERE email = ".+"`)).WillReturnRows(rows)
+	mock.ExpectQuery(fmt.Sprintf(`SELECT .+ FROM user WHERE email = \?`)).WillReturnRows(rows)
 
-	resultingUser, err := userService.GetOrCreateByAccessToken(nU.AccessToken, client)
+	resultingUser, err := userService.GetOrCreateByAccessToken(context.Background(), nU.AccessToken, client)
 
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
@@ -681,19 +614,18 @@ func TestUser_Create(t *testing.T) {
 
 	userService := NewService(repo)
 
-	createRows := sqlmock.NewRows([]string{""})
-
 	getRows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"}).
 		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, nU.AlexaUserID, nU.Admin, nU.TempMatch)
+			nU.AccessToken, nU.RefreshToken, nU.Admin, nU.TempMatch, "", "", "", "", false, 0, "", "", "")
 
-	mock.ExpectQuery(`INSERT INTO user \(.+\) VALUES\(".+", ".+", ".+", ".+", ".+", ".*", ".*", false, ".*"\)`).
-		WillReturnRows(createRows)
+	mock.ExpectExec(`INSERT INTO user \(.+\) VALUES\(.+\)`).WithArgs(nU.Email, nU.FirstName, nU.LastName, nU.FullName, sqlmock.AnyArg(),
+		nU.AccessToken, sqlmock.AnyArg(), false, sqlmock.AnyArg(), "", "", "", false, 0, "", "").WillReturnResult(sqlmock.NewResult(int64(nU.UserID), 1))
 
-	mock.ExpectQuery(`SELECT \* FROM user WHERE temp_match = ".+"`).WillReturnRows(getRows)
+	mock.ExpectQuery(`SELECT .+ FROM user WHERE temp_match = \?`).WillReturnRows(getRows)
 
-	resultingUser, err := userService.Create(*nOauthU, nU.AccessToken, nU.RefreshToken)
+	resultingUser, err := userService.Create(context.Background(), *nOauthU, nU.AccessToken, nU.RefreshToken)
 	assert.Nil(t, err)
 	assert.NotNil(t, resultingUser)
 	assert.Equal(t, resultingUser, nU)
@@ -713,10 +645,10 @@ func TestUser_Create_InsertError(t *testing.T) {
 
 	userService := NewService(repo)
 
-	mock.ExpectQuery(`INSERT INTO user \(.+\) VALUES\(".+", ".+", ".+", ".+", ".+", ".*", ".*", false, ".*"\)`).
-		WillReturnError(errors.New("Database Error"))
+	mock.ExpectExec(`INSERT INTO user \(.+\) VALUES\(.+\)`).WithArgs(nU.Email, nU.FirstName, nU.LastName, nU.FullName, sqlmock.AnyArg(),
+		nU.AccessToken, sqlmock.AnyArg(), false, sqlmock.AnyArg(), "", "", "", false, 0, "", "").WillReturnError(errors.New("Database Error"))
 
-	resultingUser, err := userService.Create(*nOauthU, nU.AccessToken, nU.RefreshToken)
+	resultingUser, err := userService.Create(context.Background(), *nOauthU, nU.AccessToken, nU.RefreshToken)
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
@@ -736,119 +668,189 @@ func TestUser_Create_CheckError(t *testing.T) {
 
 	userService := NewService(repo)
 
-	createRows := sqlmock.NewRows([]string{""})
-
 	getRows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"})
+		"access_token", "refresh_token", "is_admin", "temp_match", "token_expiry", "token_provider", "deactivated_date",
+		"time_zone", "notify_expiring", "notify_days_before", "deletion_date", "quiet_start", "quiet_end"})
 
-	mock.ExpectQuery(`INSERT INTO user \(.+\) VALUES\(".+", ".+", ".+", ".+", ".+", ".*", ".*", false, ".*"\)`).
-		WillReturnRows(createRows)
+	mock.ExpectExec(`INSERT INTO user \(.+\) VALUES\(.+\)`).WithArgs(nU.Email, nU.FirstName, nU.LastName, nU.FullName, sqlmock.AnyArg(),
+		nU.AccessToken, sqlmock.AnyArg(), false, sqlmock.AnyArg(), "", "", "", false, 0, "", "").WillReturnResult(sqlmock.NewResult(int64(nU.UserID), 1))
 
-	mock.ExpectQuery(`SELECT \* FROM user WHERE temp_match = ".+"`).WillReturnRows(getRows)
+	mock.ExpectQuery(`SELECT .+ FROM user WHERE temp_match = \?`).WillReturnRows(getRows)
 
-	resultingUser, err := userService.Create(*nOauthU, nU.AccessToken, nU.RefreshToken)
+	resultingUser, err := userService.Create(context.Background(), *nOauthU, nU.AccessToken, nU.RefreshToken)
 	assert.Nil(t, resultingUser)
 	assert.NotNil(t, err)
 	assert.Equal(t, http.StatusInternalServerError, err.Status())
 }
 
-func TestUser_UpdateAlexaID(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	if testerr != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+func TestUser_GenerateTempMatch(t *testing.T) {
+	assert.Equal(t, "", "")
+}
+
+//newStubProviders starts a stub identity provider and gives it as the only provider, with an access and refresh token
+//it issued for its default user.
+func newStubProviders(t *testing.T) (*oidctest.Server, *oidc.Providers, string, string) {
+	stub := oidctest.NewServer()
+	t.Cleanup(stub.Close)
+
+	p, err := oidc.Discover(context.Background(), stub.Config("stub", ""), nil)
+	if err != nil {
+		t.Fatal(err.Message())
 	}
-	defer db.Close()
+	providers, _ := oidc.NewProviders(p)
+	accessToken, refreshToken, _ := stub.IssueTokens(oidctest.DefaultUser)
+	return stub, providers, accessToken, refreshToken
+}
 
-	repo, err := dbrepo.NewRepositoryWithDB(db)
+func newTokenTestUser(t *testing.T, repo dbrepo.Repository, u userDomain.User) *userDomain.User {
+	u.Email = "nothing@gmail.com"
+	created, err := repo.CreateUser(context.Background(), u)
 	if err != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, err)
+		t.Fatal(err.Message())
 	}
+	return created
+}
 
+func TestUser_SaveTokens_KeepsRefreshToken(t *testing.T) {
+	repo := dbrepo.NewMemoryRepository()
+	u := newTokenTestUser(t, repo, userDomain.User{AccessToken: "old-access", RefreshToken: "refresh", TokenProvider: "google"})
 	userService := NewService(repo)
 
-	newAlexaID := "AMZ.23.NEWID"
-	newUser := nU
-	newUser.AlexaUserID = newAlexaID
+	expiry := time.Date(2021, 3, 1, 13, 0, 0, 0, time.UTC)
+	saved, err := userService.SaveTokens(context.Background(), *u, "google", &oauth2.Token{AccessToken: "new-access", Expiry: expiry})
+	assert.Nil(t, err)
+	assert.Equal(t, "new-access", saved.AccessToken)
+	assert.Equal(t, "refresh", saved.RefreshToken)
 
-	updateRows := sqlmock.NewRows([]string{""})
+	stored, _ := repo.GetUserByID(context.Background(), u.UserID)
+	assert.Equal(t, "new-access", stored.AccessToken)
+	assert.Equal(t, "refresh", stored.RefreshToken)
+	assert.True(t, expiry.Equal(stored.TokenExpiry))
 
-	getRows := sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "full_name", "created_date",
-		"access_token", "refresh_token", "alexa_user_id", "is_admin", "temp_match"}).
-		AddRow(nU.UserID, nU.Email, nU.FirstName, nU.LastName, nU.FullName, nU.CreatedDate,
-			nU.AccessToken, nU.RefreshToken, newAlexaID, nU.Admin, nU.TempMatch)
+	saved, _ = userService.SaveTokens(context.Background(), *stored, "google", &oauth2.Token{AccessToken: "newer-access", RefreshToken: "rotated", Expiry: expiry})
+	assert.Equal(t, "rotated", saved.RefreshToken)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.UpdateUserBase, nU.Email, nU.FirstName, nU.LastName, nU.FullName,
-		nU.AccessToken, nU.RefreshToken, newAlexaID, nU.TempMatch, nU.UserID)).
-		WillReturnRows(updateRows)
+	//a refresh token from one provider is no use with another's access token
+	saved, _ = userService.SaveTokens(context.Background(), *saved, "other", &oauth2.Token{AccessToken: "other-access", Expiry: expiry})
+	assert.Equal(t, "other", saved.TokenProvider)
+	assert.Equal(t, "", saved.RefreshToken)
+}
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetUserByIDBase, nU.UserID)).WillReturnRows(getRows)
+func TestUser_FreshAccessToken_NotExpired(t *testing.T) {
+	repo := dbrepo.NewMemoryRepository()
+	stub, providers, _, _ := newStubProviders(t)
+	stub.RejectRefresh(true)
+	u := newTokenTestUser(t, repo, userDomain.User{AccessToken: "old-access", RefreshToken: "refresh", TokenExpiry: time.Now().Add(time.Hour)})
+	userService := NewServiceWithProviders(repo, providers, 0, 0)
 
-	resultingUser, err := userService.UpdateAlexaID(*nU, newAlexaID)
+	token, err := userService.FreshAccessToken(context.Background(), u)
 	assert.Nil(t, err)
-	assert.NotNil(t, resultingUser)
-	assert.Equal(t, resultingUser, newUser)
+	assert.Equal(t, "old-access", token)
 }
 
-func TestUser_UpdateAlexaID_UpdateError(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	if testerr != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
-	}
-	defer db.Close()
+func TestUser_FreshAccessToken_Refreshes(t *testing.T) {
+	repo := dbrepo.NewMemoryRepository()
+	_, providers, accessToken, refreshToken := newStubProviders(t)
+	u := newTokenTestUser(t, repo, userDomain.User{AccessToken: accessToken, RefreshToken: refreshToken, TokenProvider: "stub",
+		TokenExpiry: time.Now().Add(-time.Minute)})
+	userService := NewServiceWithProviders(repo, providers, 0, 0)
 
-	repo, err := dbrepo.NewRepositoryWithDB(db)
-	if err != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, err)
-	}
+	token, err := userService.FreshAccessToken(context.Background(), u)
+	assert.Nil(t, err)
+	assert.NotEqual(t, accessToken, token)
 
-	userService := NewService(repo)
+	stored, _ := repo.GetUserByID(context.Background(), u.UserID)
+	assert.Equal(t, token, stored.AccessToken)
+	assert.NotEqual(t, refreshToken, stored.RefreshToken)
+	assert.True(t, stored.TokenExpiry.After(time.Now()))
+	assert.Equal(t, "stub", stored.TokenProvider)
 
-	newAlexaID := "AMZ.23.NEWID"
-	newUser := nU
-	newUser.AlexaUserID = newAlexaID
+	//the stub provider knows the refreshed access token
+	client := NewClient()
+	byToken, err := NewServiceWithProviders(repo, providers, 0, 0).GetOrCreateByAccessToken(context.Background(), token, client)
+	if assert.Nil(t, err) {
+		assert.Equal(t, u.UserID, byToken.UserID)
+	}
+}
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.UpdateUserBase, nU.Email, nU.FirstName, nU.LastName, nU.FullName,
-		nU.AccessToken, nU.RefreshToken, newAlexaID, nU.TempMatch, nU.UserID)).
-		WillReturnError(errors.New("Database Error"))
+func TestUser_FreshAccessToken_UnknownExpiryRefreshes(t *testing.T) {
+	repo := dbrepo.NewMemoryRepository()
+	_, providers, accessToken, refreshToken := newStubProviders(t)
+	u := newTokenTestUser(t, repo, userDomain.User{AccessToken: accessToken, RefreshToken: refreshToken})
+	userService := NewServiceWithProviders(repo, providers, 0, 0)
 
-	resultingUser, err := userService.UpdateAlexaID(*nU, newAlexaID)
-	assert.Nil(t, resultingUser)
-	assert.NotNil(t, err)
-	assert.Equal(t, http.StatusInternalServerError, err.Status())
+	token, err := userService.FreshAccessToken(context.Background(), u)
+	assert.Nil(t, err)
+	assert.NotEqual(t, accessToken, token)
 }
 
-func TestUser_UpdateAlexaID_CheckError(t *testing.T) {
-	db, mock, testerr := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	if testerr != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, testerr)
+func TestUser_FreshAccessToken_Errors(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	_, providers, _, refreshToken := newStubProviders(t)
+
+	closedStub, closedProviders, _, _ := newStubProviders(t)
+	closedStub.Close()
+
+	tests := []struct {
+		name      string
+		providers *oidc.Providers
+		provider  string
+		refresh   string
+		status    int
+	}{
+		{"no providers", nil, "", refreshToken, http.StatusUnauthorized},
+		{"unknown provider", providers, "gone", refreshToken, http.StatusUnauthorized},
+		{"no refresh token", providers, "stub", "", http.StatusUnauthorized},
+		{"refresh token revoked", providers, "stub", "not-a-refresh-token", http.StatusUnauthorized},
+		{"provider unreachable", closedProviders, "stub", refreshToken, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		repo := dbrepo.NewMemoryRepository()
+		u := newTokenTestUser(t, repo, userDomain.User{AccessToken: "old-access", RefreshToken: tt.refresh, TokenProvider: tt.provider, TokenExpiry: expired})
+		userService := NewServiceWithProviders(repo, tt.providers, 0, 0)
+
+		_, err := userService.FreshAccessToken(context.Background(), u)
+		if assert.NotNil(t, err, tt.name) {
+			assert.Equal(t, tt.status, err.Status(), tt.name)
+		}
 	}
-	defer db.Close()
+}
 
-	repo, err := dbrepo.NewRepositoryWithDB(db)
-	if err != nil {
-		t.Fatalf(`an error "%s" was not expected when opening the fake database connection`, err)
-	}
+func TestUser_GetOrCreateByAccessToken_DefaultProvider(t *testing.T) {
+	_, providers, accessToken, _ := newStubProviders(t)
+	userService := NewServiceWithProviders(dbrepo.NewMemoryRepository(), providers, 0, 0)
 
-	userService := NewService(repo)
+	created, err := userService.GetOrCreateByAccessToken(context.Background(), accessToken, NewClient())
+	if assert.Nil(t, err) {
+		assert.Equal(t, oidctest.DefaultUser.Email, created.Email)
+	}
 
-	newAlexaID := "AMZ.23.NEWID"
-	newUser := nU
-	newUser.AlexaUserID = newAlexaID
+	_, err = userService.GetOrCreateByAccessToken(context.Background(), "not-a-token", NewClient())
+	assert.Equal(t, http.StatusUnauthorized, err.Status())
+}
 
-	updateRows := sqlmock.NewRows([]string{""})
+func TestUser_ListAndSetActive(t *testing.T) {
+	repo := dbrepo.NewMemoryRepository()
+	bob := newTokenTestUser(t, repo, userDomain.User{FullName: "Bob Nothing"})
+	userService := NewService(repo)
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.UpdateUserBase, nU.Email, nU.FirstName, nU.LastName, nU.FullName,
-		nU.AccessToken, nU.RefreshToken, newAlexaID, nU.TempMatch, nU.UserID)).
-		WillReturnRows(updateRows)
+	listed, err := userService.List(context.Background(), " bob ")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*listed))
+	listed, err = userService.List(context.Background(), "nobody")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(*listed))
 
-	mock.ExpectQuery(fmt.Sprintf(dbrepo.GetUserByIDBase, nU.UserID)).WillReturnError(errors.New("database error"))
+	deactivated, err := userService.SetActive(context.Background(), bob.UserID, false)
+	assert.Nil(t, err)
+	assert.False(t, deactivated.IsActive())
+	again, _ := userService.SetActive(context.Background(), bob.UserID, false)
+	assert.Equal(t, deactivated.DeactivatedDate, again.DeactivatedDate, "deactivating twice keeps the first time")
 
-	resultingUser, err := userService.UpdateAlexaID(*nU, newAlexaID)
-	assert.Nil(t, resultingUser)
-	assert.NotNil(t, err)
-	assert.Equal(t, http.StatusInternalServerError, err.Status())
-}
+	reactivated, err := userService.SetActive(context.Background(), bob.UserID, true)
+	assert.Nil(t, err)
+	assert.True(t, reactivated.IsActive())
 
-func TestUser_GenerateTempMatch(t *testing.T) {
-	assert.Equal(t, "", "")
+	_, err = userService.SetActive(context.Background(), bob.UserID+1, false)
+	assert.Equal(t, http.StatusNotFound, err.Status())
 }
@@ -0,0 +1,460 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/clock"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/event"
+	userDomain "github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/webhook"
+	"github.com/jasonradcliffe/freshness-countdown-api/fcerr"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+)
+
+//Service is the interface that defines the contract for a webhook service - the URLs users want the events in their
+//household posted to, and the background job that posts them.
+type Service interface {
+	GetAll(context.Context, *userDomain.User) (*webhook.Subscriptions, fcerr.FCErr)
+	GetByID(context.Context, *userDomain.User, int) (*webhook.Subscription, fcerr.FCErr)
+	Create(context.Context, *userDomain.User, string, []string) (*webhook.Subscription, fcerr.FCErr)
+	Update(context.Context, *userDomain.User, int, Changes) (*webhook.Subscription, fcerr.FCErr)
+	Delete(context.Context, *userDomain.User, int) fcerr.FCErr
+	GetDeliveries(context.Context, *userDomain.User, int) (*webhook.Deliveries, fcerr.FCErr)
+	GetDeadLetters(context.Context, *userDomain.User) (*webhook.Deliveries, fcerr.FCErr)
+	Redeliver(context.Context, *userDomain.User, int) (*webhook.Delivery, fcerr.FCErr)
+
+	Enqueue(event.Event)
+	Deliver(context.Context) (int, fcerr.FCErr)
+	Run(context.Context, time.Duration)
+}
+
+//Changes are what Update changes about a subscription. A nil URL, Events or Active is left as it is, and an empty
+//Events subscribes to every type of event.
+type Changes struct {
+	URL    *string
+	Events []string
+	Active *bool
+}
+
+//MaxSubscriptions is how many webhooks a user can have.
+const MaxSubscriptions = 10
+
+//maxURLLength is the longest webhook URL, as the url column holds.
+const maxURLLength = 2048
+
+//DeliveryRetention is how long a delivery is kept in the log after it was delivered or given up on.
+const DeliveryRetention = 30 * 24 * time.Hour
+
+//deliveryTimeout is how long a webhook has to answer a delivery.
+const deliveryTimeout = 10 * time.Second
+
+//enqueueTimeout is how long queueing an event's deliveries can take.
+const enqueueTimeout = 5 * time.Second
+
+//userAgent is sent with every delivery.
+const userAgent = "FreshnessCountdown-Webhooks/1"
+
+type service struct {
+	repository db.Repository
+	clock      clock.Clock
+	client     *http.Client
+	//wake tells Run that deliveries were queued, so it doesn't wait for the next interval
+	wake chan struct{}
+}
+
+//errBlockedAddress is what connecting to an address webhooks can't be posted to fails with.
+var errBlockedAddress = errors.New("webhooks can't be posted to this address")
+
+//NewService takes a database repository, the clock to go by and the client to post deliveries with, and gives you a new
+//Service instance. A nil client is NewClient(false).
+func NewService(repo db.Repository, c clock.Clock, client *http.Client) Service {
+	if client == nil {
+		client = NewClient(false)
+	}
+	return &service{
+		repository: repo,
+		clock:      c,
+		client:     client,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+//NewClient gives a client to post deliveries with. It has a deliveryTimeout, doesn't follow redirects or go through a
+//proxy, and won't connect to a loopback, link-local or unspecified address, whatever the webhook's host name looks up
+//to. Addresses on a private network are only reached when allowPrivate is set, for an api hosted at home next to
+//Home Assistant.
+func NewClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: deliveryTimeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			return checkAddress(address, allowPrivate)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   deliveryTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+//checkAddress gives errBlockedAddress if the ip:port about to be connected to is one webhooks can't be posted to.
+func checkAddress(address string, allowPrivate bool) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || blockedIP(ip, allowPrivate) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, host)
+	}
+	return nil
+}
+
+//blockedIP says whether webhooks can't be posted to the ip - the api's own machine, link-local addresses like the cloud
+//metadata service, and unless allowPrivate is set, private networks.
+func blockedIP(ip net.IP, allowPrivate bool) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() ||
+		(!allowPrivate && ip.IsPrivate())
+}
+
+//GetAll(requestingUser *userDomain.User) gets the user's webhooks, without their secrets.
+func (s *service) GetAll(ctx context.Context, requestingUser *userDomain.User) (*webhook.Subscriptions, fcerr.FCErr) {
+	subscriptions, err := s.repository.GetWebhooks(ctx, requestingUser.UserID)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the webhooks", err.Status())
+	}
+	for i := range *subscriptions {
+		(*subscriptions)[i].Secret = ""
+	}
+	return subscriptions, nil
+}
+
+//GetByID(requestingUser *userDomain.User, id int) gets one of the user's webhooks, without its secret.
+func (s *service) GetByID(ctx context.Context, requestingUser *userDomain.User, id int) (*webhook.Subscription, fcerr.FCErr) {
+	subscription, err := s.repository.GetWebhookByID(ctx, requestingUser.UserID, id)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the webhook with id "+strconv.Itoa(id), err.Status())
+	}
+	subscription.Secret = ""
+	return subscription, nil
+}
+
+//Create(requestingUser *userDomain.User, rawURL string, events []string) subscribes the URL to the events in the user's
+//household - those of the given types, or all of them with none given. The new webhook comes back with the secret its
+//deliveries are signed with, which isn't shown again.
+func (s *service) Create(ctx context.Context, requestingUser *userDomain.User, rawURL string, events []string) (*webhook.Subscription, fcerr.FCErr) {
+	if details := checkSubscription(rawURL, events); len(details) > 0 {
+		return nil, fcerr.NewValidationError("The webhook could not be created", details...)
+	}
+	existing, err := s.repository.GetWebhooks(ctx, requestingUser.UserID)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Error when creating the webhook.", err.Status())
+	}
+	if len(*existing) >= MaxSubscriptions {
+		return nil, fcerr.NewConflictError("A user can have at most " + strconv.Itoa(MaxSubscriptions) + " webhooks")
+	}
+
+	secret, genErr := webhook.NewSecret()
+	if genErr != nil {
+		return nil, fcerr.Wrap(genErr, "Could not make the webhook's secret", http.StatusInternalServerError)
+	}
+	created, err := s.repository.CreateWebhook(ctx, webhook.Subscription{UserID: requestingUser.UserID, URL: rawURL,
+		Secret: secret, Events: uniqueEvents(events), Active: true, CreatedDate: s.clock.Now()})
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Webhook Service could not do the Create()", err.Status())
+	}
+	return created, nil
+}
+
+//Update(requestingUser *userDomain.User, id int, changes Changes) changes one of the user's webhooks. Turning a webhook
+//off gives up on the deliveries still waiting for it.
+func (s *service) Update(ctx context.Context, requestingUser *userDomain.User, id int, changes Changes) (*webhook.Subscription, fcerr.FCErr) {
+	subscription, err := s.GetByID(ctx, requestingUser, id)
+	if err != nil {
+		return nil, err
+	}
+	if changes.URL != nil {
+		subscription.URL = *changes.URL
+	}
+	if changes.Events != nil {
+		subscription.Events = uniqueEvents(changes.Events)
+	}
+	if changes.Active != nil {
+		subscription.Active = *changes.Active
+	}
+	if details := checkSubscription(subscription.URL, subscription.Events); len(details) > 0 {
+		return nil, fcerr.NewValidationError("The webhook could not be updated", details...)
+	}
+
+	if err := s.repository.UpdateWebhook(ctx, *subscription); err != nil {
+		return nil, fcerr.Wrap(err, "Webhook Service could not do the Update()", err.Status())
+	}
+	return subscription, nil
+}
+
+//Delete(requestingUser *userDomain.User, id int) deletes one of the user's webhooks and its delivery log.
+func (s *service) Delete(ctx context.Context, requestingUser *userDomain.User, id int) fcerr.FCErr {
+	if err := s.repository.DeleteWebhook(ctx, requestingUser.UserID, id); err != nil {
+		return fcerr.Wrap(err, "Webhook Service could not do the Delete()", err.Status())
+	}
+	return nil
+}
+
+//GetDeliveries(requestingUser *userDomain.User, id int) gets the latest deliveries to one of the user's webhooks,
+//newest first.
+func (s *service) GetDeliveries(ctx context.Context, requestingUser *userDomain.User, id int) (*webhook.Deliveries, fcerr.FCErr) {
+	if _, err := s.GetByID(ctx, requestingUser, id); err != nil {
+		return nil, err
+	}
+	deliveries, err := s.repository.GetDeliveries(ctx, requestingUser.UserID, id)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the webhook's deliveries", err.Status())
+	}
+	return deliveries, nil
+}
+
+//GetDeadLetters(requestingUser *userDomain.User) gets the latest deliveries to any of the user's webhooks that were given
+//up on, newest first.
+func (s *service) GetDeadLetters(ctx context.Context, requestingUser *userDomain.User) (*webhook.Deliveries, fcerr.FCErr) {
+	deliveries, err := s.repository.GetDeliveriesByStatus(ctx, requestingUser.UserID, webhook.Dead)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the webhook deliveries that were given up on", err.Status())
+	}
+	return deliveries, nil
+}
+
+//Redeliver(requestingUser *userDomain.User, deliveryID int) queues a delivery to one of the user's webhooks to be tried
+//again straight away, with MaxAttempts more tries. One still waiting to be tried can't be, nor one to a webhook that is
+//turned off.
+func (s *service) Redeliver(ctx context.Context, requestingUser *userDomain.User, deliveryID int) (*webhook.Delivery, fcerr.FCErr) {
+	delivery, err := s.repository.GetDeliveryByID(ctx, requestingUser.UserID, deliveryID)
+	if err != nil {
+		return nil, fcerr.Wrap(err, "Could not get the delivery with id "+strconv.Itoa(deliveryID), err.Status())
+	}
+	if delivery.Status == webhook.Pending {
+		return nil, fcerr.NewConflictError("This delivery is already waiting to be tried again")
+	}
+	subscription, err := s.GetByID(ctx, requestingUser, delivery.SubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if !subscription.Active {
+		return nil, fcerr.NewConflictError("The webhook is turned off, so its deliveries can't be tried again")
+	}
+
+	delivery.Retry(s.clock.Now())
+	if err := s.repository.UpdateDelivery(ctx, *delivery); err != nil {
+		return nil, fcerr.Wrap(err, "Could not queue the delivery again", err.Status())
+	}
+	s.wakeUp()
+	return delivery, nil
+}
+
+//checkSubscription says what is wrong with a webhook's URL and events, if anything. The URL has to be http or https,
+//as Home Assistant on a home network often isn't served over TLS. A host that is plainly the api's own machine is turned
+//away here; whatever else a host name looks up to is checked when a delivery connects.
+func checkSubscription(rawURL string, events []string) []fcerr.FieldDetail {
+	var details []fcerr.FieldDetail
+	parsed, err := url.Parse(rawURL)
+	switch {
+	case rawURL == "":
+		details = append(details, fcerr.FieldDetail{Field: "url", Message: "is required"})
+	case len(rawURL) > maxURLLength:
+		details = append(details, fcerr.FieldDetail{Field: "url", Message: "longer than " + strconv.Itoa(maxURLLength) + " characters"})
+	case err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "":
+		details = append(details, fcerr.FieldDetail{Field: "url", Message: "not an http or https URL: " + rawURL})
+	case localHost(parsed.Hostname()):
+		details = append(details, fcerr.FieldDetail{Field: "url", Message: "not an address webhooks can be posted to: " + rawURL})
+	}
+	for _, e := range events {
+		if !event.ValidType(e) {
+			details = append(details, fcerr.FieldDetail{Field: "events", Message: "not a type of event: " + e})
+		}
+	}
+	return details
+}
+
+//uniqueEvents gives the event types without repeats, in the order given.
+func uniqueEvents(events []string) []string {
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, e := range events {
+		if !seen[e] {
+			seen[e] = true
+			unique = append(unique, e)
+		}
+	}
+	return unique
+}
+
+//Enqueue queues a delivery of the event to every active webhook in its household that wants it. It is subscribed to
+//the event bus, so it only writes the deliveries down and leaves posting them to Run.
+func (s *service) Enqueue(e event.Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), enqueueTimeout)
+	defer cancel()
+
+	subscriptions, err := s.repository.GetHouseholdWebhooks(ctx, e.HouseholdID)
+	if err != nil {
+		fmt.Println("could not find the webhooks for event", e.EventID, "-", err.Message())
+		return
+	}
+	payload, marshalErr := json.Marshal(e)
+	if marshalErr != nil {
+		fmt.Println("could not marshal event", e.EventID, "-", marshalErr.Error())
+		return
+	}
+
+	now := s.clock.Now()
+	queued := 0
+	for _, subscription := range *subscriptions {
+		if !subscription.Wants(e.Type) {
+			continue
+		}
+		_, err := s.repository.CreateDelivery(ctx, webhook.Delivery{SubscriptionID: subscription.SubscriptionID,
+			UserID: subscription.UserID, EventID: e.EventID, EventType: e.Type, Payload: string(payload),
+			Status: webhook.Pending, NextAttempt: now, CreatedDate: now, UpdatedDate: now})
+		if err != nil {
+			fmt.Println("could not queue event", e.EventID, "for webhook", subscription.SubscriptionID, "-", err.Message())
+			continue
+		}
+		queued++
+	}
+	if queued > 0 {
+		s.wakeUp()
+	}
+}
+
+//wakeUp has Run deliver straight away, if it isn't about to already.
+func (s *service) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+//Run delivers whatever is due every interval, and as soon as anything is queued, until ctx is done.
+func (s *service) Run(ctx context.Context, interval time.Duration) {
+	for {
+		delivered, fcErr := s.Deliver(ctx)
+		if fcErr != nil {
+			fmt.Println("could not deliver webhooks:", fcErr.Message())
+		} else if delivered > 0 {
+			fmt.Println("delivered", delivered, "webhooks")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-s.clock.After(interval):
+		}
+	}
+}
+
+//Deliver tries every delivery that is due, and gives how many were delivered. One that fails is put off for
+//webhook.Backoff, and given up on after webhook.MaxAttempts; one to a webhook that was turned off is given up on
+//straight away. The log is cleared of deliveries finished more than DeliveryRetention ago.
+func (s *service) Deliver(ctx context.Context) (int, fcerr.FCErr) {
+	delivered := 0
+	subscriptions := map[int]*webhook.Subscription{}
+	for {
+		now := s.clock.Now()
+		due, err := s.repository.GetDueDeliveries(ctx, now)
+		if err != nil {
+			return delivered, fcerr.Wrap(err, "Error while finding the webhook deliveries that are due.", err.Status())
+		}
+
+		for _, d := range *due {
+			subscription, ok := subscriptions[d.SubscriptionID]
+			if !ok {
+				subscription, err = s.repository.GetWebhookByID(ctx, d.UserID, d.SubscriptionID)
+				if err != nil && !errors.Is(err, fcerr.ErrNotFound) {
+					return delivered, err
+				}
+				subscriptions[d.SubscriptionID] = subscription
+			}
+
+			switch {
+			case subscription == nil:
+				d.GiveUp(now, "The webhook was deleted")
+			case !subscription.Active:
+				d.GiveUp(now, "The webhook was turned off")
+			case s.attempt(ctx, *subscription, &d, now):
+				delivered++
+			}
+			if err := s.repository.UpdateDelivery(ctx, d); err != nil {
+				return delivered, err
+			}
+		}
+		//each delivery tried is no longer due, so a full page means there may be more
+		if len(*due) < db.MaxDueDeliveries {
+			break
+		}
+	}
+	return delivered, s.repository.DeleteDeliveriesBefore(ctx, s.clock.Now().Add(-DeliveryRetention))
+}
+
+//attempt posts the delivery to the subscription's URL, signed with its secret, and records how it went. It tells
+//whether the webhook answered with a 2xx code.
+func (s *service) attempt(ctx context.Context, subscription webhook.Subscription, d *webhook.Delivery, now time.Time) bool {
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+
+	body := []byte(d.Payload)
+	request, err := http.NewRequestWithContext(ctx, "POST", subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		d.Failed(now, 0, "Could not make the webhook request: "+err.Error())
+		return false
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", userAgent)
+	request.Header.Set(webhook.EventHeader, d.EventType)
+	request.Header.Set(webhook.DeliveryHeader, strconv.Itoa(d.DeliveryID))
+	request.Header.Set(webhook.SignatureHeader, webhook.Sign(subscription.Secret, now, body))
+
+	response, err := s.client.Do(request)
+	if errors.Is(err, errBlockedAddress) {
+		d.GiveUp(now, "The webhook's address can't be posted to: "+err.Error())
+		return false
+	}
+	if err != nil {
+		d.Failed(now, 0, "Could not reach the webhook: "+err.Error())
+		return false
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		d.Failed(now, response.StatusCode, fmt.Sprintf("The webhook answered with status %d", response.StatusCode))
+		return false
+	}
+	d.Succeeded(now, response.StatusCode)
+	return true
+}
+
+//localHost says whether the URL's host is a name or address that is never anywhere but the api's own machine, or one
+//that isn't anywhere at all.
+func localHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && blockedIP(ip, true)
+}
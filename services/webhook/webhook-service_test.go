@@ -0,0 +1,321 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jasonradcliffe/freshness-countdown-api/clock/clocktest"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/event"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/user"
+	"github.com/jasonradcliffe/freshness-countdown-api/domain/webhook"
+	"github.com/jasonradcliffe/freshness-countdown-api/repository/db"
+	"github.com/jasonradcliffe/freshness-countdown-api/services/household"
+	"github.com/stretchr/testify/assert"
+)
+
+//receiver is a webhook endpoint at url that keeps what was posted to it, answering with status.
+type receiver struct {
+	url      string
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, string(body))
+	w.WriteHeader(r.status)
+}
+
+//received is how many deliveries were posted so far.
+func (r *receiver) received() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+//noon is when the webhook tests start.
+var noon = time.Date(2022, 3, 7, 12, 0, 0, 0, time.UTC)
+
+//newWebhookTest gives a service posting to a receiver answering with status, and a user in their own household. The
+//receiver's URL has a host name the service's client connects to the test server for, as webhooks can't be made for
+//the test server's own loopback address.
+func newWebhookTest(t *testing.T, status int) (Service, *user.User, int, *receiver, *clocktest.Clock) {
+	ctx := context.Background()
+	repo := db.NewMemoryRepository()
+	bob, fcErr := repo.CreateUser(ctx, user.User{Email: "nothing@gmail.com", FirstName: "Bob"})
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	home, fcErr := household.Membership(ctx, repo, bob)
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+
+	r := &receiver{status: status}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	r.url = "http://homeassistant.test/api/webhook/fc"
+	client := server.Client()
+	client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network string, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	clock := clocktest.New(noon)
+	return NewService(repo, clock, client), bob, home.HouseholdID, r, clock
+}
+
+func TestWebhook_Create(t *testing.T) {
+	s, bob, _, _, _ := newWebhookTest(t, http.StatusOK)
+	ctx := context.Background()
+
+	created, err := s.Create(ctx, bob, "https://homeassistant.local/api/webhook/fc", []string{event.DishExpired, event.DishExpired})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(created.Secret, webhook.SecretPrefix), "the secret is given back once")
+	assert.Equal(t, []string{event.DishExpired}, created.Events)
+	assert.True(t, created.Active)
+
+	got, err := s.GetByID(ctx, bob, created.SubscriptionID)
+	assert.Nil(t, err)
+	assert.Equal(t, "", got.Secret, "and not again")
+
+	for _, bad := range []struct {
+		url    string
+		events []string
+	}{
+		{"", nil},
+		{"ftp://homeassistant.local/fc", nil},
+		{"https:///fc", nil},
+		{"https://homeassistant.local/fc", []string{"dish.eaten"}},
+		{"http://localhost:8123/fc", nil},
+		{"http://127.0.0.1:8123/fc", nil},
+		{"http://[::1]/fc", nil},
+		{"http://169.254.169.254/latest/meta-data", nil},
+		{"http://0.0.0.0/fc", nil},
+	} {
+		_, err := s.Create(ctx, bob, bad.url, bad.events)
+		if assert.NotNil(t, err, bad.url) {
+			assert.Equal(t, http.StatusBadRequest, err.Status(), bad.url)
+		}
+	}
+
+	for i := 1; i < MaxSubscriptions; i++ {
+		s.Create(ctx, bob, "http://homeassistant.local/fc", nil)
+	}
+	_, err = s.Create(ctx, bob, "http://homeassistant.local/fc", nil)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusConflict, err.Status())
+	}
+}
+
+func TestWebhook_Update(t *testing.T) {
+	s, bob, _, _, _ := newWebhookTest(t, http.StatusOK)
+	ctx := context.Background()
+	created, _ := s.Create(ctx, bob, "https://homeassistant.local/fc", nil)
+
+	off, other := false, "https://example.com/fc"
+	updated, err := s.Update(ctx, bob, created.SubscriptionID, Changes{URL: &other, Events: []string{event.StorageCreated}, Active: &off})
+	assert.Nil(t, err)
+	assert.Equal(t, other, updated.URL)
+	assert.Equal(t, []string{event.StorageCreated}, updated.Events)
+	assert.False(t, updated.Active)
+
+	bad := "not a url"
+	_, err = s.Update(ctx, bob, created.SubscriptionID, Changes{URL: &bad})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusBadRequest, err.Status())
+	}
+
+	assert.Nil(t, s.Delete(ctx, bob, created.SubscriptionID))
+	_, err = s.GetByID(ctx, bob, created.SubscriptionID)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusNotFound, err.Status())
+	}
+}
+
+func TestWebhook_Deliver(t *testing.T) {
+	s, bob, householdID, r, clock := newWebhookTest(t, http.StatusNoContent)
+	ctx := context.Background()
+	everything, _ := s.Create(ctx, bob, r.url, nil)
+	s.Create(ctx, bob, r.url, []string{event.StorageDeleted})
+
+	e := event.New(event.DishCreated, householdID, bob.UserID, noon, map[string]string{"Title": "Carrots"})
+	s.Enqueue(e)
+	s.Enqueue(event.New(event.DishCreated, householdID+1, 0, noon, nil))
+
+	delivered, err := s.Deliver(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, delivered, "only the webhook wanting the event in the household gets it")
+	if assert.Equal(t, 1, r.received()) {
+		req := r.requests[0]
+		assert.Equal(t, event.DishCreated, req.Header.Get(webhook.EventHeader))
+		assert.Contains(t, r.bodies[0], e.EventID)
+		assert.True(t, webhook.Verify(everything.Secret, req.Header.Get(webhook.SignatureHeader), []byte(r.bodies[0]), clock.Now(), time.Minute))
+	}
+
+	deliveries, err := s.GetDeliveries(ctx, bob, everything.SubscriptionID)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(*deliveries)) {
+		assert.Equal(t, webhook.Delivered, (*deliveries)[0].Status)
+		assert.Equal(t, http.StatusNoContent, (*deliveries)[0].ResponseCode)
+	}
+
+	delivered, _ = s.Deliver(ctx)
+	assert.Equal(t, 0, delivered, "nothing is delivered twice")
+}
+
+func TestWebhook_BlockedAddress(t *testing.T) {
+	ctx := context.Background()
+	repo := db.NewMemoryRepository()
+	bob, fcErr := repo.CreateUser(ctx, user.User{Email: "nothing@gmail.com", FirstName: "Bob"})
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	home, fcErr := household.Membership(ctx, repo, bob)
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	r := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	//a host name can look up to the api's own machine after the webhook was made, so it is checked when connecting
+	subscription, fcErr := repo.CreateWebhook(ctx, webhook.Subscription{UserID: bob.UserID, URL: server.URL + "/fc",
+		Secret: "whsec_test", Active: true, CreatedDate: noon})
+	if fcErr != nil {
+		t.Fatal(fcErr.Message())
+	}
+	s := NewService(repo, clocktest.New(noon), NewClient(true))
+	s.Enqueue(event.New(event.DishCreated, home.HouseholdID, bob.UserID, noon, nil))
+
+	delivered, err := s.Deliver(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, delivered)
+	assert.Equal(t, 0, r.received())
+	deliveries, _ := s.GetDeliveries(ctx, bob, subscription.SubscriptionID)
+	if assert.Equal(t, 1, len(*deliveries)) {
+		assert.Equal(t, webhook.Dead, (*deliveries)[0].Status, "it isn't tried again")
+		assert.Contains(t, (*deliveries)[0].LastError, "can't be posted to")
+	}
+}
+
+func TestWebhook_blockedIP(t *testing.T) {
+	for _, address := range []struct {
+		ip            string
+		blocked       bool
+		blockedAtHome bool
+	}{
+		{"93.184.216.34", false, false},
+		{"2606:2800:220:1::", false, false},
+		{"192.168.1.20", true, false},
+		{"10.0.0.5", true, false},
+		{"fd00::20", true, false},
+		{"127.0.0.1", true, true},
+		{"::ffff:127.0.0.1", true, true},
+		{"::1", true, true},
+		{"169.254.169.254", true, true},
+		{"fe80::1", true, true},
+		{"0.0.0.0", true, true},
+		{"::", true, true},
+	} {
+		ip := net.ParseIP(address.ip)
+		assert.Equal(t, address.blocked, blockedIP(ip, false), address.ip)
+		assert.Equal(t, address.blockedAtHome, blockedIP(ip, true), address.ip+" with private networks allowed")
+	}
+	assert.ErrorIs(t, checkAddress("127.0.0.1:8123", true), errBlockedAddress)
+	assert.Nil(t, checkAddress("192.168.1.20:8123", true))
+}
+
+func TestWebhook_Retry(t *testing.T) {
+	s, bob, householdID, r, clock := newWebhookTest(t, http.StatusInternalServerError)
+	ctx := context.Background()
+	s.Create(ctx, bob, r.url, nil)
+	s.Enqueue(event.New(event.DishExpired, householdID, 0, noon, nil))
+
+	s.Deliver(ctx)
+	s.Deliver(ctx)
+	assert.Equal(t, 1, r.received(), "a failed delivery waits before it is tried again")
+
+	for attempts := 1; attempts < webhook.MaxAttempts; attempts++ {
+		clock.Advance(webhook.Backoff(attempts))
+		s.Deliver(ctx)
+	}
+	assert.Equal(t, webhook.MaxAttempts, r.received())
+
+	dead, err := s.GetDeadLetters(ctx, bob)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(*dead)) {
+		assert.Equal(t, webhook.MaxAttempts, (*dead)[0].Attempts)
+		assert.Equal(t, http.StatusInternalServerError, (*dead)[0].ResponseCode)
+	}
+
+	r.mu.Lock()
+	r.status = http.StatusOK
+	r.mu.Unlock()
+	retried, err := s.Redeliver(ctx, bob, (*dead)[0].DeliveryID)
+	assert.Nil(t, err)
+	assert.Equal(t, webhook.Pending, retried.Status)
+	_, err = s.Redeliver(ctx, bob, retried.DeliveryID)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusConflict, err.Status(), "it is already waiting")
+	}
+
+	delivered, _ := s.Deliver(ctx)
+	assert.Equal(t, 1, delivered)
+	dead, _ = s.GetDeadLetters(ctx, bob)
+	assert.Equal(t, 0, len(*dead))
+}
+
+func TestWebhook_TurnedOff(t *testing.T) {
+	s, bob, householdID, r, _ := newWebhookTest(t, http.StatusOK)
+	ctx := context.Background()
+	created, _ := s.Create(ctx, bob, r.url, nil)
+	s.Enqueue(event.New(event.StorageCreated, householdID, bob.UserID, noon, nil))
+
+	off := false
+	s.Update(ctx, bob, created.SubscriptionID, Changes{Active: &off})
+	s.Deliver(ctx)
+	assert.Equal(t, 0, r.received())
+
+	dead, _ := s.GetDeadLetters(ctx, bob)
+	if assert.Equal(t, 1, len(*dead)) {
+		_, err := s.Redeliver(ctx, bob, (*dead)[0].DeliveryID)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, http.StatusConflict, err.Status())
+		}
+	}
+}
+
+func TestWebhook_Run(t *testing.T) {
+	s, bob, householdID, r, clock := newWebhookTest(t, http.StatusOK)
+	s.Create(context.Background(), bob, r.url, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx, time.Hour)
+		close(done)
+	}()
+
+	assert.True(t, clock.WaitForSleepers(1, time.Second))
+	s.Enqueue(event.New(event.DishUpdated, householdID, bob.UserID, noon, nil))
+	assert.True(t, clock.WaitForSleepers(1, time.Second))
+	assert.Equal(t, 1, r.received(), "a queued event is delivered without waiting for the interval")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not stop when its context was cancelled")
+	}
+}